@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cohenjo/replicator/pkg/config"
+	"github.com/cohenjo/replicator/pkg/dlq"
+	"github.com/cohenjo/replicator/pkg/replicator"
+	"github.com/sirupsen/logrus"
+)
+
+const deadLetterUsage = `Usage: %s dlq <command> [options] [id...]
+
+Inspect and manage the dead-letter queue of the configuration.
+
+Commands:
+  list             List entries, oldest first
+  inspect <id>     Show an entry
+  replay [id...]   Replay entries through the stage they failed in, removing
+                   the replayed ones; without ids the filtered entries are replayed
+  purge [id...]    Remove entries; without ids the filtered entries are removed
+
+Options:
+`
+
+// runDeadLetterCommand runs a dlq subcommand and returns the exit code
+func runDeadLetterCommand(args []string) int {
+	flags := flag.NewFlagSet("dlq", flag.ContinueOnError)
+	var (
+		configFile = flags.String("config", "", "Configuration file path")
+		stream     = flags.String("stream", "", "Only entries of the stream")
+		stage      = flags.String("stage", "", "Only entries of the stage (transform, write)")
+		limit      = flags.Int("limit", 0, "Maximum number of entries, 0 for all")
+		all        = flags.Bool("all", false, "Purge every entry when no filter is given")
+	)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, deadLetterUsage, os.Args[0])
+		flags.PrintDefaults()
+	}
+	if len(args) == 0 {
+		flags.Usage()
+		return 2
+	}
+	command := args[0]
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	filter := dlq.Filter{Stream: *stream, Stage: dlq.Stage(*stage), Limit: *limit}
+	ids := flags.Args()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	cfg, err := loadConfiguration(*configFile, logger)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading configuration: %v\n", err)
+		return 1
+	}
+	if !cfg.DeadLetter.Enabled {
+		fmt.Fprintln(os.Stderr, "The dead-letter queue is not enabled in the configuration")
+		return 1
+	}
+
+	ctx := context.Background()
+	switch command {
+	case "list":
+		err = listDeadLetters(ctx, cfg, filter, os.Stdout)
+	case "inspect":
+		if len(ids) != 1 {
+			fmt.Fprintln(os.Stderr, "inspect takes a single entry id")
+			return 2
+		}
+		err = inspectDeadLetter(ctx, cfg, ids[0], os.Stdout)
+	case "replay":
+		err = replayDeadLetters(ctx, cfg, logger, filter, ids, os.Stdout)
+	case "purge":
+		if len(ids) == 0 && filter.Stream == "" && filter.Stage == "" && !*all {
+			fmt.Fprintln(os.Stderr, "purge without ids or a filter requires -all")
+			return 2
+		}
+		err = purgeDeadLetters(ctx, cfg, filter, ids, os.Stdout)
+	default:
+		flags.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func openDeadLetters(cfg *config.Config) (dlq.Queue, error) {
+	queue, err := dlq.New(cfg.DeadLetter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dead-letter queue: %w", err)
+	}
+	return queue, nil
+}
+
+func listDeadLetters(ctx context.Context, cfg *config.Config, filter dlq.Filter, out io.Writer) error {
+	queue, err := openDeadLetters(cfg)
+	if err != nil {
+		return err
+	}
+	defer queue.Close()
+
+	entries, err := queue.List(ctx, filter)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tCREATED\tSTREAM\tSTAGE\tATTEMPTS\tERROR")
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", entry.ID, entry.CreatedAt.Format(time.RFC3339),
+			entry.Stream, entry.Stage, entry.Attempts, firstLine(entry.Error, 80))
+	}
+	return w.Flush()
+}
+
+func inspectDeadLetter(ctx context.Context, cfg *config.Config, id string, out io.Writer) error {
+	queue, err := openDeadLetters(cfg)
+	if err != nil {
+		return err
+	}
+	defer queue.Close()
+
+	entry, err := queue.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(entry)
+}
+
+// replayDeadLetters creates the estuaries of the configured streams without
+// starting them, and replays the entries through the service
+func replayDeadLetters(ctx context.Context, cfg *config.Config, logger *logrus.Logger, filter dlq.Filter, ids []string, out io.Writer) error {
+	service, err := replicator.NewService(replicator.ServiceOptions{
+		Config: cfg,
+		Logger: logger,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create service: %w", err)
+	}
+	if err := service.PrepareReplay(ctx); err != nil {
+		return fmt.Errorf("failed to create estuaries: %w", err)
+	}
+	queue := service.DeadLetters()
+	defer queue.Close()
+
+	var entries []*dlq.Entry
+	if len(ids) > 0 {
+		for _, id := range ids {
+			entry, err := queue.Get(ctx, id)
+			if err != nil {
+				return fmt.Errorf("entry %s: %w", id, err)
+			}
+			entries = append(entries, entry)
+		}
+	} else if entries, err = queue.List(ctx, filter); err != nil {
+		return err
+	}
+
+	result, err := dlq.Replay(ctx, queue, entries, service.ReplayDeadLetter)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Replayed %d of %d entries\n", len(result.Replayed), len(entries))
+	for id, failure := range result.Failed {
+		fmt.Fprintf(out, "  %s: %s\n", id, failure)
+	}
+	if len(result.Failed) > 0 {
+		return fmt.Errorf("%d entries failed to replay", len(result.Failed))
+	}
+	return nil
+}
+
+func purgeDeadLetters(ctx context.Context, cfg *config.Config, filter dlq.Filter, ids []string, out io.Writer) error {
+	queue, err := openDeadLetters(cfg)
+	if err != nil {
+		return err
+	}
+	defer queue.Close()
+
+	if len(ids) > 0 {
+		if err := queue.Delete(ctx, ids...); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Removed %d entries\n", len(ids))
+		return nil
+	}
+	purged, err := queue.Purge(ctx, filter)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Purged %d entries\n", purged)
+	return nil
+}
+
+// firstLine returns the first line of s, cut to max runes
+func firstLine(s string, max int) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	if runes := []rune(s); len(runes) > max {
+		return string(runes[:max-3]) + "..."
+	}
+	return s
+}
@@ -20,6 +20,11 @@ var (
 )
 
 func main() {
+	// Dead-letter queue subcommand
+	if len(os.Args) > 1 && os.Args[1] == "dlq" {
+		os.Exit(runDeadLetterCommand(os.Args[2:]))
+	}
+
 	// Parse command line flags
 	var (
 		configFile    = flag.String("config", "", "Configuration file path")
@@ -172,6 +177,9 @@ func showConfiguration(cfg *config.Config, logger *logrus.Logger) {
 		fmt.Printf("    [%d] %s (%s -> %s)\n", 
 			i+1, stream.Name, stream.Source.Type, stream.Target.Type)
 		fmt.Printf("        Enabled: %t\n", stream.Enabled)
+		for _, route := range stream.Routes {
+			fmt.Printf("        Route: %s -> %s\n", route.Name, route.Target.Type)
+		}
 	}
 }
 
@@ -187,9 +195,8 @@ func generateConfigTemplate(filename string) error {
 func run(cfg *config.Config, logger *logrus.Logger) error {
 	// Create service
 	service, err := replicator.NewService(replicator.ServiceOptions{
-		Config:      cfg,
-		Logger:      logger,
-		EventBuffer: 10000,
+		Config: cfg,
+		Logger: logger,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to create service: %w", err)
@@ -277,6 +284,7 @@ func init() {
 		fmt.Fprintf(os.Stderr, "  %s -validate                    # Validate configuration only\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -generate-config=config.yaml # Generate config template\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -version                     # Show version information\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s dlq list -config=config.yaml # List the dead-letter queue (see dlq -h)\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "\nEnvironment Variables:\n")
 		fmt.Fprintf(os.Stderr, "  REPLICATOR_CONFIG_FILE          # Configuration file path\n")
 		fmt.Fprintf(os.Stderr, "  REPLICATOR_LOG_LEVEL            # Log level\n")
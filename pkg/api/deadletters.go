@@ -0,0 +1,195 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cohenjo/replicator/pkg/dlq"
+	"github.com/rs/zerolog/log"
+)
+
+// DeadLettersHandler lists, inspects, replays and purges the entries of the
+// dead-letter queue
+type DeadLettersHandler struct {
+	queue  dlq.Queue
+	replay dlq.ReplayFunc
+}
+
+// NewDeadLettersHandler creates a new dead-letters handler. Without a queue
+// every request is answered with 503 Service Unavailable, without a replay
+// function replays are answered with 501 Not Implemented.
+func NewDeadLettersHandler(queue dlq.Queue, replay dlq.ReplayFunc) *DeadLettersHandler {
+	return &DeadLettersHandler{
+		queue:  queue,
+		replay: replay,
+	}
+}
+
+// ServeHTTP handles HTTP requests for dead letters
+func (h *DeadLettersHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.queue == nil {
+		http.Error(w, "Dead-letter queue is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1/deadletters")
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	switch {
+	case parts[0] == "" && r.Method == http.MethodGet:
+		h.handleList(w, r)
+	case parts[0] == "" && r.Method == http.MethodDelete:
+		h.handlePurge(w, r)
+	case len(parts) == 1 && parts[0] == "replay" && r.Method == http.MethodPost:
+		h.handleReplay(w, r, "")
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		h.handleGet(w, r, parts[0])
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		h.handleDelete(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "replay" && r.Method == http.MethodPost:
+		h.handleReplay(w, r, parts[0])
+	case len(parts) <= 2:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// handleList handles GET /deadletters?stream=&stage=&limit=
+func (h *DeadLettersHandler) handleList(w http.ResponseWriter, r *http.Request) {
+	filter, err := deadLetterFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entries, err := h.queue.List(r.Context(), filter)
+	if err != nil {
+		writeDeadLetterError(w, err)
+		return
+	}
+	writeDeadLetterResponse(w, http.StatusOK, map[string]interface{}{
+		"entries": entries,
+		"total":   len(entries),
+	})
+}
+
+// handleGet handles GET /deadletters/{id}
+func (h *DeadLettersHandler) handleGet(w http.ResponseWriter, r *http.Request, id string) {
+	entry, err := h.queue.Get(r.Context(), id)
+	if err != nil {
+		writeDeadLetterError(w, err)
+		return
+	}
+	writeDeadLetterResponse(w, http.StatusOK, entry)
+}
+
+// handleDelete handles DELETE /deadletters/{id}
+func (h *DeadLettersHandler) handleDelete(w http.ResponseWriter, r *http.Request, id string) {
+	if _, err := h.queue.Get(r.Context(), id); err != nil {
+		writeDeadLetterError(w, err)
+		return
+	}
+	if err := h.queue.Delete(r.Context(), id); err != nil {
+		writeDeadLetterError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlePurge handles DELETE /deadletters?stream=&stage=. Purging the whole
+// queue has to be asked for with all=true.
+func (h *DeadLettersHandler) handlePurge(w http.ResponseWriter, r *http.Request) {
+	filter, err := deadLetterFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.Stream == "" && filter.Stage == "" && r.URL.Query().Get("all") != "true" {
+		http.Error(w, "Purging every entry requires all=true", http.StatusBadRequest)
+		return
+	}
+	purged, err := h.queue.Purge(r.Context(), filter)
+	if err != nil {
+		writeDeadLetterError(w, err)
+		return
+	}
+	writeDeadLetterResponse(w, http.StatusOK, map[string]interface{}{"purged": purged})
+}
+
+// handleReplay handles POST /deadletters/{id}/replay and
+// POST /deadletters/replay?stream=&stage=&limit=
+func (h *DeadLettersHandler) handleReplay(w http.ResponseWriter, r *http.Request, id string) {
+	if h.replay == nil {
+		http.Error(w, "Replay is not available", http.StatusNotImplemented)
+		return
+	}
+
+	var entries []*dlq.Entry
+	if id != "" {
+		entry, err := h.queue.Get(r.Context(), id)
+		if err != nil {
+			writeDeadLetterError(w, err)
+			return
+		}
+		entries = []*dlq.Entry{entry}
+	} else {
+		filter, err := deadLetterFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if entries, err = h.queue.List(r.Context(), filter); err != nil {
+			writeDeadLetterError(w, err)
+			return
+		}
+	}
+
+	result, err := dlq.Replay(r.Context(), h.queue, entries, h.replay)
+	if err != nil {
+		writeDeadLetterError(w, err)
+		return
+	}
+	writeDeadLetterResponse(w, http.StatusOK, result)
+}
+
+// deadLetterFilter reads the filter from the query parameters
+func deadLetterFilter(r *http.Request) (dlq.Filter, error) {
+	query := r.URL.Query()
+	filter := dlq.Filter{
+		Stream: query.Get("stream"),
+		Stage:  dlq.Stage(query.Get("stage")),
+	}
+	switch filter.Stage {
+	case "", dlq.StageTransform, dlq.StageWrite:
+	default:
+		return filter, errors.New("stage must be transform or write")
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			return filter, errors.New("limit must be a non-negative integer")
+		}
+		filter.Limit = n
+	}
+	return filter, nil
+}
+
+func writeDeadLetterResponse(w http.ResponseWriter, status int, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Error().Err(err).Msg("Failed to encode dead-letter response")
+	}
+}
+
+func writeDeadLetterError(w http.ResponseWriter, err error) {
+	if errors.Is(err, dlq.ErrEntryNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	log.Error().Err(err).Msg("Dead-letter request failed")
+	http.Error(w, "Internal server error", http.StatusInternalServerError)
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/cohenjo/replicator/pkg/dlq"
+	"github.com/cohenjo/replicator/pkg/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDeadLetters(t *testing.T, streams ...string) (dlq.Queue, []*dlq.Entry) {
+	queue, err := dlq.NewFileQueue(filepath.Join(t.TempDir(), "dlq.jsonl"))
+	require.NoError(t, err)
+	entries := make([]*dlq.Entry, 0, len(streams))
+	for _, stream := range streams {
+		entry := dlq.NewEntry(stream, dlq.StageWrite, errors.New("boom"), 1, &events.RecordEvent{Action: "insert"}, nil)
+		require.NoError(t, queue.Put(context.Background(), entry))
+		entries = append(entries, entry)
+	}
+	return queue, entries
+}
+
+func serveDeadLetters(handler http.Handler, method, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	return rec
+}
+
+func TestDeadLettersHandlerListAndGet(t *testing.T) {
+	queue, entries := newTestDeadLetters(t, "orders", "users")
+	handler := NewDeadLettersHandler(queue, nil)
+
+	rec := serveDeadLetters(handler, http.MethodGet, "/api/v1/deadletters?stream=orders")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var list struct {
+		Entries []*dlq.Entry `json:"entries"`
+		Total   int          `json:"total"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	assert.Equal(t, 1, list.Total)
+	assert.Equal(t, entries[0].ID, list.Entries[0].ID)
+
+	rec = serveDeadLetters(handler, http.MethodGet, "/api/v1/deadletters/"+entries[1].ID)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"stream":"users"`)
+
+	assert.Equal(t, http.StatusNotFound, serveDeadLetters(handler, http.MethodGet, "/api/v1/deadletters/missing").Code)
+	assert.Equal(t, http.StatusBadRequest, serveDeadLetters(handler, http.MethodGet, "/api/v1/deadletters?stage=load").Code)
+	assert.Equal(t, http.StatusNotImplemented, serveDeadLetters(handler, http.MethodPost, "/api/v1/deadletters/replay").Code)
+}
+
+func TestDeadLettersHandlerReplayAndPurge(t *testing.T) {
+	queue, entries := newTestDeadLetters(t, "orders", "users", "users")
+	var replayed []string
+	handler := NewDeadLettersHandler(queue, func(ctx context.Context, entry *dlq.Entry) error {
+		replayed = append(replayed, entry.ID)
+		return nil
+	})
+
+	rec := serveDeadLetters(handler, http.MethodPost, "/api/v1/deadletters/"+entries[0].ID+"/replay")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []string{entries[0].ID}, replayed)
+	_, err := queue.Get(context.Background(), entries[0].ID)
+	assert.ErrorIs(t, err, dlq.ErrEntryNotFound, "replayed entries are removed")
+
+	assert.Equal(t, http.StatusBadRequest, serveDeadLetters(handler, http.MethodDelete, "/api/v1/deadletters").Code,
+		"purging everything has to be explicit")
+	rec = serveDeadLetters(handler, http.MethodDelete, "/api/v1/deadletters?stream=users")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"purged":2}`, rec.Body.String())
+
+	assert.Equal(t, http.StatusServiceUnavailable, serveDeadLetters(NewDeadLettersHandler(nil, nil), http.MethodGet, "/api/v1/deadletters").Code)
+}
@@ -0,0 +1,234 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cohenjo/replicator/pkg/estuary"
+	"github.com/rs/zerolog/log"
+)
+
+// destinationRequestTimeout bounds the calls a request makes to a destination
+const destinationRequestTimeout = 10 * time.Second
+
+// DestinationInfo describes a destination in the destinations list
+type DestinationInfo struct {
+	Name   string                `json:"name"`
+	Type   string                `json:"type"`
+	Health *estuary.HealthStatus `json:"health,omitempty"`
+}
+
+// DestinationsHandler serves the health, metrics and tables of the destinations
+type DestinationsHandler struct {
+	registry estuary.DestinationRegistry
+}
+
+// NewDestinationsHandler creates a new destinations handler. Without a registry
+// every request is answered with 503 Service Unavailable.
+func NewDestinationsHandler(registry estuary.DestinationRegistry) *DestinationsHandler {
+	return &DestinationsHandler{
+		registry: registry,
+	}
+}
+
+// ServeHTTP handles HTTP requests for destinations
+func (h *DestinationsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.registry == nil {
+		http.Error(w, "Destinations are not available", http.StatusServiceUnavailable)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1/destinations")
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if parts[0] == "" {
+		h.handleListDestinations(w, r)
+		return
+	}
+
+	destination, err := h.registry.GetDestination(parts[0])
+	if err != nil {
+		writeDestinationError(w, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), destinationRequestTimeout)
+	defer cancel()
+
+	switch {
+	case len(parts) == 1:
+		health, _ := destination.GetHealth(ctx)
+		writeDestinationResponse(w, DestinationInfo{
+			Name:   parts[0],
+			Type:   string(destination.GetConfig().Type),
+			Health: health,
+		})
+	case len(parts) == 2 && parts[1] == "health":
+		health, err := destination.GetHealth(ctx)
+		if err != nil {
+			writeDestinationError(w, err)
+			return
+		}
+		writeDestinationResponse(w, health)
+	case len(parts) == 2 && parts[1] == "metrics":
+		metrics, err := destination.GetMetrics(ctx)
+		if err != nil {
+			writeDestinationError(w, err)
+			return
+		}
+		writeDestinationResponse(w, metrics)
+	case len(parts) == 2 && parts[1] == "tables":
+		tables, err := destination.ListTables(ctx)
+		if err != nil {
+			writeDestinationError(w, err)
+			return
+		}
+		writeDestinationResponse(w, map[string]interface{}{"tables": tables})
+	case len(parts) == 4 && parts[1] == "tables" && parts[3] == "schema":
+		schema, err := destination.GetSchema(ctx, parts[2])
+		if err != nil {
+			writeDestinationError(w, err)
+			return
+		}
+		writeDestinationResponse(w, schema)
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// handleListDestinations handles GET /destinations
+func (h *DestinationsHandler) handleListDestinations(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), destinationRequestTimeout)
+	defer cancel()
+
+	health, _ := h.registry.CheckAllHealth(ctx)
+	destinations := make([]DestinationInfo, 0)
+	for _, name := range h.registry.ListDestinations() {
+		destination, err := h.registry.GetDestination(name)
+		if err != nil {
+			continue
+		}
+		destinations = append(destinations, DestinationInfo{
+			Name:   name,
+			Type:   string(destination.GetConfig().Type),
+			Health: health[name],
+		})
+	}
+	writeDestinationResponse(w, map[string]interface{}{
+		"destinations": destinations,
+		"total":        len(destinations),
+	})
+}
+
+func writeDestinationResponse(w http.ResponseWriter, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Error().Err(err).Msg("Failed to encode destination response")
+	}
+}
+
+// writeDestinationError maps destination error codes to HTTP statuses
+func writeDestinationError(w http.ResponseWriter, err error) {
+	var destErr *estuary.DestinationError
+	if errors.As(err, &destErr) {
+		switch destErr.Code {
+		case estuary.ErrCodeDestinationNotFound, estuary.ErrCodeTableNotFound, estuary.ErrCodeSchemaNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case estuary.ErrCodeUnsupportedOperation:
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		case estuary.ErrCodeConnectionFailed, estuary.ErrCodeTimeout, estuary.ErrCodeThrottled:
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	log.Error().Err(err).Msg("Destination request failed")
+	http.Error(w, "Internal server error", http.StatusInternalServerError)
+}
+
+// DestinationChecker reports the health of the registered destinations
+type DestinationChecker struct {
+	name      string
+	essential bool
+	registry  estuary.DestinationRegistry
+}
+
+// NewDestinationChecker creates a new destination health checker
+func NewDestinationChecker(name string, essential bool, registry estuary.DestinationRegistry) *DestinationChecker {
+	return &DestinationChecker{
+		name:      name,
+		essential: essential,
+		registry:  registry,
+	}
+}
+
+// Name returns the checker name
+func (d *DestinationChecker) Name() string {
+	return d.name
+}
+
+// IsEssential returns whether this check is essential
+func (d *DestinationChecker) IsEssential() bool {
+	return d.essential
+}
+
+// Check reports unhealthy when every destination is unhealthy and degraded when some are
+func (d *DestinationChecker) Check() CheckResult {
+	if d.registry == nil {
+		return CheckResult{
+			Status: HealthStatusUnhealthy,
+			Error:  "destination registry not configured",
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), destinationRequestTimeout)
+	defer cancel()
+	health, err := d.registry.CheckAllHealth(ctx)
+	if err != nil {
+		return CheckResult{
+			Status: HealthStatusUnhealthy,
+			Error:  err.Error(),
+		}
+	}
+	if len(health) == 0 {
+		return CheckResult{
+			Status:  HealthStatusHealthy,
+			Message: "no destinations configured",
+		}
+	}
+
+	unhealthy, degraded := 0, 0
+	for _, status := range health {
+		switch {
+		case status == nil || status.Status == estuary.HealthUnhealthy:
+			unhealthy++
+		case status.Status != estuary.HealthHealthy:
+			degraded++
+		}
+	}
+	switch {
+	case unhealthy == len(health):
+		return CheckResult{
+			Status: HealthStatusUnhealthy,
+			Error:  "no healthy destinations",
+		}
+	case unhealthy > 0 || degraded > 0:
+		return CheckResult{
+			Status:  HealthStatusDegraded,
+			Message: fmt.Sprintf("%d unhealthy and %d degraded of %d destinations", unhealthy, degraded, len(health)),
+		}
+	}
+	return CheckResult{
+		Status:  HealthStatusHealthy,
+		Message: "all destinations healthy",
+	}
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cohenjo/replicator/pkg/config"
+	"github.com/cohenjo/replicator/pkg/estuary"
+	"github.com/cohenjo/replicator/pkg/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testEndpoint is an endpoint with a single orders table
+type testEndpoint struct {
+	pingErr error
+}
+
+func (e *testEndpoint) Write(ctx context.Context, record *events.RecordEvent) error { return nil }
+
+func (e *testEndpoint) WriteBatch(ctx context.Context, records []*events.RecordEvent) error {
+	return nil
+}
+
+func (e *testEndpoint) Ping(ctx context.Context) error { return e.pingErr }
+
+func (e *testEndpoint) ListTables(ctx context.Context) ([]string, error) {
+	return []string{"orders"}, nil
+}
+
+func (e *testEndpoint) GetSchema(ctx context.Context, tableName string) (*estuary.TableSchema, error) {
+	if tableName != "orders" {
+		return nil, &estuary.DestinationError{Code: estuary.ErrCodeTableNotFound, Message: "table does not exist"}
+	}
+	return &estuary.TableSchema{Name: "orders", Columns: []estuary.ColumnDefinition{{Name: "id", Type: "BIGINT"}}}, nil
+}
+
+func (e *testEndpoint) CreateTable(ctx context.Context, schema estuary.TableSchema) error { return nil }
+
+func (e *testEndpoint) DropTable(ctx context.Context, tableName string) error { return nil }
+
+func (e *testEndpoint) TruncateTable(ctx context.Context, tableName string) error { return nil }
+
+func newTestRegistry(t *testing.T, endpoints map[string]*testEndpoint) estuary.DestinationRegistry {
+	registry := estuary.NewDestinationRegistry()
+	for name, endpoint := range endpoints {
+		endpoint := endpoint
+		destination := estuary.NewEndpointDestination(
+			config.TargetConfig{Type: config.TargetTypeMongoDB, URI: "mongodb://localhost:27017"},
+			func(*config.WaterFlowsConfig) (estuary.Endpoint, error) { return endpoint, nil },
+		)
+		require.NoError(t, destination.Connect(context.Background()))
+		require.NoError(t, registry.RegisterDestination(name, destination))
+	}
+	return registry
+}
+
+func TestDestinationsHandler(t *testing.T) {
+	handler := NewDestinationsHandler(newTestRegistry(t, map[string]*testEndpoint{
+		"orders-stream":    {},
+		"customers-stream": {pingErr: errors.New("connection refused")},
+	}))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		return rr
+	}
+
+	rr := get("/api/v1/destinations")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var list struct {
+		Destinations []DestinationInfo `json:"destinations"`
+		Total        int               `json:"total"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &list))
+	assert.Equal(t, 2, list.Total)
+	assert.Equal(t, "customers-stream", list.Destinations[0].Name)
+	assert.Equal(t, estuary.HealthUnhealthy, list.Destinations[0].Health.Status)
+	assert.Equal(t, "mongodb", list.Destinations[1].Type)
+	assert.Equal(t, estuary.HealthHealthy, list.Destinations[1].Health.Status)
+
+	rr = get("/api/v1/destinations/orders-stream/health")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"status":"HEALTHY"`)
+
+	rr = get("/api/v1/destinations/orders-stream/metrics")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"active_connections":1`)
+
+	rr = get("/api/v1/destinations/orders-stream/tables")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"tables":["orders"]}`, rr.Body.String())
+
+	rr = get("/api/v1/destinations/orders-stream/tables/orders/schema")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"name":"id"`)
+
+	assert.Equal(t, http.StatusNotFound, get("/api/v1/destinations/orders-stream/tables/items/schema").Code)
+	assert.Equal(t, http.StatusNotFound, get("/api/v1/destinations/missing").Code)
+	assert.Equal(t, http.StatusNotFound, get("/api/v1/destinations/orders-stream/unknown").Code)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/api/v1/destinations/orders-stream", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+}
+
+func TestDestinationsHandler_NoRegistry(t *testing.T) {
+	rr := httptest.NewRecorder()
+	NewDestinationsHandler(nil).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/destinations", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+}
+
+func TestDestinationChecker(t *testing.T) {
+	checker := NewDestinationChecker("destinations", false, newTestRegistry(t, map[string]*testEndpoint{"orders-stream": {}}))
+	assert.Equal(t, "destinations", checker.Name())
+	assert.False(t, checker.IsEssential())
+	assert.Equal(t, HealthStatusHealthy, checker.Check().Status)
+
+	checker = NewDestinationChecker("destinations", false, newTestRegistry(t, map[string]*testEndpoint{
+		"orders-stream":    {},
+		"customers-stream": {pingErr: errors.New("connection refused")},
+	}))
+	assert.Equal(t, HealthStatusDegraded, checker.Check().Status)
+
+	checker = NewDestinationChecker("destinations", true, newTestRegistry(t, map[string]*testEndpoint{
+		"customers-stream": {pingErr: errors.New("connection refused")},
+	}))
+	assert.Equal(t, HealthStatusUnhealthy, checker.Check().Status)
+
+	assert.Equal(t, HealthStatusUnhealthy, NewDestinationChecker("destinations", false, nil).Check().Status)
+}
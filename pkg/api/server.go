@@ -9,6 +9,8 @@ import (
 	"time"
 
 	"github.com/cohenjo/replicator/pkg/config"
+	"github.com/cohenjo/replicator/pkg/dlq"
+	"github.com/cohenjo/replicator/pkg/estuary"
 	"github.com/cohenjo/replicator/pkg/metrics"
 	"github.com/rs/zerolog/log"
 )
@@ -66,10 +68,12 @@ type Server struct {
 	configService  ConfigManager
 
 	// Handlers
-	healthHandler  *HealthHandler
-	metricsHandler *MetricsHandler
-	streamsHandler *StreamsHandler
-	configHandler  *ConfigHandler
+	healthHandler       *HealthHandler
+	metricsHandler      *MetricsHandler
+	streamsHandler      *StreamsHandler
+	configHandler       *ConfigHandler
+	destinationsHandler *DestinationsHandler
+	deadLettersHandler  *DeadLettersHandler
 
 	// Middleware
 	metricsMiddleware *MetricsMiddleware
@@ -132,16 +136,18 @@ func NewServer(
 
 	// Create server
 	server := &Server{
-		config:            cfg,
-		healthService:     healthService,
-		metricsService:    metricsService,
-		streamService:     streamService,
-		configService:     NewDefaultConfigManager(configService),
-		healthHandler:     healthHandler,
-		metricsHandler:    metricsHandler,
-		streamsHandler:    streamsHandler,
-		configHandler:     configHandler,
-		metricsMiddleware: metricsMiddleware,
+		config:              cfg,
+		healthService:       healthService,
+		metricsService:      metricsService,
+		streamService:       streamService,
+		configService:       NewDefaultConfigManager(configService),
+		healthHandler:       healthHandler,
+		metricsHandler:      metricsHandler,
+		streamsHandler:      streamsHandler,
+		configHandler:       configHandler,
+		destinationsHandler: NewDestinationsHandler(nil),
+		deadLettersHandler:  NewDeadLettersHandler(nil, nil),
+		metricsMiddleware:   metricsMiddleware,
 	}
 
 	// Setup HTTP server
@@ -185,6 +191,10 @@ func (s *Server) createMux(serverCfg ServerConfig) http.Handler {
 	mux.Handle("/api/v1/streams/", s.streamsHandler)
 	mux.Handle("/api/v1/config", s.configHandler)
 	mux.Handle("/api/v1/config/", s.configHandler)
+	mux.Handle("/api/v1/destinations", s.destinationsHandler)
+	mux.Handle("/api/v1/destinations/", s.destinationsHandler)
+	mux.Handle("/api/v1/deadletters", s.deadLettersHandler)
+	mux.Handle("/api/v1/deadletters/", s.deadLettersHandler)
 
 	// Legacy endpoints (without /api/v1 prefix)
 	mux.Handle("/streams", s.streamsHandler)
@@ -226,6 +236,20 @@ func (s *Server) createMux(serverCfg ServerConfig) http.Handler {
 	return handler
 }
 
+// SetDestinationRegistry serves the destinations of the registry and adds
+// their health to the health checks
+func (s *Server) SetDestinationRegistry(registry estuary.DestinationRegistry) {
+	s.destinationsHandler.registry = registry
+	s.healthService.RegisterChecker(NewDestinationChecker("destinations", false, registry))
+}
+
+// SetDeadLetterQueue serves the entries of the dead-letter queue, replaying
+// them with the replay function
+func (s *Server) SetDeadLetterQueue(queue dlq.Queue, replay dlq.ReplayFunc) {
+	s.deadLettersHandler.queue = queue
+	s.deadLettersHandler.replay = replay
+}
+
 // Start starts the HTTP server
 func (s *Server) Start() error {
 	log.Info().
@@ -276,11 +300,13 @@ func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
 		"status":      "running",
 		"timestamp":   time.Now(),
 		"endpoints": map[string]interface{}{
-			"health":  "/health",
-			"metrics": "/metrics",
-			"api":     "/api",
-			"streams": "/api/v1/streams",
-			"config":  "/api/v1/config",
+			"health":       "/health",
+			"metrics":      "/metrics",
+			"api":          "/api",
+			"streams":      "/api/v1/streams",
+			"config":       "/api/v1/config",
+			"destinations": "/api/v1/destinations",
+			"deadletters":  "/api/v1/deadletters",
 		},
 		"documentation": "/api",
 	}
@@ -337,6 +363,33 @@ func (s *Server) handleAPIInfo(w http.ResponseWriter, r *http.Request) {
 					"restore":  "POST /api/v1/config/backups/{id}/restore",
 				},
 			},
+			"destinations": map[string]interface{}{
+				"path":        "/api/v1/destinations",
+				"methods":     []string{"GET"},
+				"description": "Inspect replication destinations",
+				"endpoints": map[string]interface{}{
+					"list":    "GET /api/v1/destinations",
+					"get":     "GET /api/v1/destinations/{name}",
+					"health":  "GET /api/v1/destinations/{name}/health",
+					"metrics": "GET /api/v1/destinations/{name}/metrics",
+					"tables":  "GET /api/v1/destinations/{name}/tables",
+					"schema":  "GET /api/v1/destinations/{name}/tables/{table}/schema",
+				},
+			},
+			"deadletters": map[string]interface{}{
+				"path":        "/api/v1/deadletters",
+				"methods":     []string{"GET", "POST", "DELETE"},
+				"description": "Inspect, replay and purge dead-lettered events",
+				"parameters":  map[string]string{"stream": "stream name", "stage": "transform|write", "limit": "max entries"},
+				"endpoints": map[string]interface{}{
+					"list":       "GET /api/v1/deadletters",
+					"get":        "GET /api/v1/deadletters/{id}",
+					"replay":     "POST /api/v1/deadletters/{id}/replay",
+					"replay_all": "POST /api/v1/deadletters/replay",
+					"delete":     "DELETE /api/v1/deadletters/{id}",
+					"purge":      "DELETE /api/v1/deadletters",
+				},
+			},
 		},
 		"authentication": map[string]interface{}{
 			"required": false, // TODO: Get from config
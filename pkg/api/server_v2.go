@@ -5,6 +5,8 @@ import (
 	"fmt"
 
 	"github.com/cohenjo/replicator/pkg/config"
+	"github.com/cohenjo/replicator/pkg/dlq"
+	"github.com/cohenjo/replicator/pkg/estuary"
 	"github.com/cohenjo/replicator/pkg/metrics"
 	"github.com/cohenjo/replicator/pkg/models"
 	"github.com/sirupsen/logrus"
@@ -15,6 +17,9 @@ type ServerV2 struct {
 	config          *config.Config
 	streamManager   models.StreamManager
 	metricsCollector *metrics.TelemetryManager
+	destinations    estuary.DestinationRegistry
+	deadLetters     dlq.Queue
+	replay          dlq.ReplayFunc
 	logger          *logrus.Logger
 }
 
@@ -23,6 +28,8 @@ type ServerV2Config struct {
 	Config          *config.Config
 	StreamManager   models.StreamManager
 	MetricsCollector *metrics.TelemetryManager
+	Destinations    estuary.DestinationRegistry
+	DeadLetters     dlq.Queue // nil when the dead-letter queue is disabled
 	Logger          *logrus.Logger
 }
 
@@ -40,12 +47,29 @@ func NewServerV2(cfg ServerV2Config) (*ServerV2, error) {
 		config:          cfg.Config,
 		streamManager:   cfg.StreamManager,
 		metricsCollector: cfg.MetricsCollector,
+		destinations:    cfg.Destinations,
+		deadLetters:     cfg.DeadLetters,
 		logger:          cfg.Logger,
 	}
 	
 	return server, nil
 }
 
+// Destinations returns the registry of the replication destinations
+func (s *ServerV2) Destinations() estuary.DestinationRegistry {
+	return s.destinations
+}
+
+// SetDeadLetterReplay sets how dead-letter entries are replayed
+func (s *ServerV2) SetDeadLetterReplay(replay dlq.ReplayFunc) {
+	s.replay = replay
+}
+
+// DeadLetters returns the dead-letter queue and how its entries are replayed
+func (s *ServerV2) DeadLetters() (dlq.Queue, dlq.ReplayFunc) {
+	return s.deadLetters, s.replay
+}
+
 // Start starts the API server
 func (s *ServerV2) Start(ctx context.Context) error {
 	s.logger.Info("API server starting (placeholder implementation)")
@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+// Azure authentication methods, as used in config.AuthenticationConfig.Method
+const (
+	AzureAuthMethodDefault          = "default"
+	AzureAuthMethodManagedIdentity  = "managed_identity"
+	AzureAuthMethodServicePrincipal = "service_principal"
+	AzureAuthMethodCLI              = "cli"
+)
+
+// NewAzureCredential creates the Azure token credential for an authentication method.
+// An empty method uses the default credential chain.
+func NewAzureCredential(method, tenantID, clientID, clientSecret string) (azcore.TokenCredential, error) {
+	switch method {
+	case "", AzureAuthMethodDefault:
+		return azidentity.NewDefaultAzureCredential(nil)
+	case AzureAuthMethodManagedIdentity:
+		options := &azidentity.ManagedIdentityCredentialOptions{}
+		if clientID != "" {
+			options.ID = azidentity.ClientID(clientID)
+		}
+		return azidentity.NewManagedIdentityCredential(options)
+	case AzureAuthMethodServicePrincipal:
+		return azidentity.NewClientSecretCredential(tenantID, clientID, clientSecret, nil)
+	case AzureAuthMethodCLI:
+		return azidentity.NewAzureCLICredential(nil)
+	default:
+		return nil, fmt.Errorf("unsupported Azure auth method: %s", method)
+	}
+}
+
+// ParseCosmosConnectionString extracts the endpoint and key of a Cosmos DB connection string:
+// AccountEndpoint=https://host:443/;AccountKey=...;
+func ParseCosmosConnectionString(connStr string) (endpoint, key string) {
+	for _, part := range strings.Split(connStr, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(name) {
+		case "accountendpoint":
+			endpoint = value
+		case "accountkey":
+			key = value
+		}
+	}
+	return endpoint, key
+}
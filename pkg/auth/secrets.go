@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/security/keyvault/azsecrets"
+	"github.com/cohenjo/replicator/pkg/config"
+)
+
+// DefaultSecretEnvPrefix is the prefix of the environment variables holding
+// the secrets of the env secret provider
+const DefaultSecretEnvPrefix = "REPLICATOR_SECRET_"
+
+// ErrSecretNotFound is returned for a secret the provider does not have
+var ErrSecretNotFound = errors.New("secret not found")
+
+// SecretProvider reads secrets by name, so key material stays out of the
+// configuration
+type SecretProvider interface {
+	// GetSecret returns the value of a secret
+	GetSecret(ctx context.Context, name string) ([]byte, error)
+}
+
+// NewSecretProvider creates the secret provider selected by the
+// configuration: environment variables by default, files of a directory, or
+// an Azure Key Vault authenticated like the other Azure resources
+func NewSecretProvider(cfg config.SecretsConfig, azure config.AzureConfig) (SecretProvider, error) {
+	switch cfg.Provider {
+	case "", config.SecretProviderEnv:
+		prefix := cfg.EnvPrefix
+		if prefix == "" {
+			prefix = DefaultSecretEnvPrefix
+		}
+		return &EnvSecretProvider{Prefix: prefix}, nil
+	case config.SecretProviderFile:
+		if cfg.Directory == "" {
+			return nil, fmt.Errorf("file secret provider requires a directory")
+		}
+		return &FileSecretProvider{Directory: cfg.Directory}, nil
+	case config.SecretProviderAzureKeyVault:
+		return NewKeyVaultSecretProvider(azure)
+	default:
+		return nil, fmt.Errorf("unsupported secret provider: %s", cfg.Provider)
+	}
+}
+
+// EnvSecretProvider reads secrets from environment variables: the secret
+// pii-key is read from REPLICATOR_SECRET_PII_KEY with the default prefix
+type EnvSecretProvider struct {
+	Prefix string
+}
+
+// GetSecret returns the value of the environment variable of a secret
+func (p *EnvSecretProvider) GetSecret(_ context.Context, name string) ([]byte, error) {
+	variable := p.Prefix + envSecretName(name)
+	value, ok := os.LookupEnv(variable)
+	if !ok || value == "" {
+		return nil, fmt.Errorf("%w: %s (environment variable %s)", ErrSecretNotFound, name, variable)
+	}
+	return []byte(value), nil
+}
+
+func envSecretName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+// FileSecretProvider reads secrets from the files of a directory, one file
+// per secret named after it, as mounted by Kubernetes secret volumes. A
+// trailing newline is not part of the secret.
+type FileSecretProvider struct {
+	Directory string
+}
+
+// GetSecret returns the content of the file of a secret
+func (p *FileSecretProvider) GetSecret(_ context.Context, name string) ([]byte, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return nil, fmt.Errorf("invalid secret name: %q", name)
+	}
+	value, err := os.ReadFile(filepath.Join(p.Directory, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret %s: %w", name, err)
+	}
+	value = []byte(strings.TrimRight(string(value), "\r\n"))
+	if len(value) == 0 {
+		return nil, fmt.Errorf("%w: %s is empty", ErrSecretNotFound, name)
+	}
+	return value, nil
+}
+
+// KeyVaultSecretProvider reads secrets from an Azure Key Vault, prefixing
+// their names with the configured secret prefix
+type KeyVaultSecretProvider struct {
+	client *azsecrets.Client
+	prefix string
+}
+
+// NewKeyVaultSecretProvider creates the secret provider of the Key Vault of
+// the Azure configuration
+func NewKeyVaultSecretProvider(azure config.AzureConfig) (*KeyVaultSecretProvider, error) {
+	if azure.KeyVault.VaultURL == "" {
+		return nil, fmt.Errorf("azure key vault secret provider requires a vault url")
+	}
+	authConfig := azure.Authentication
+	credential, err := NewAzureCredential(authConfig.Method, authConfig.TenantID, authConfig.ClientID, authConfig.ClientSecret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Azure credential: %w", err)
+	}
+	client, err := azsecrets.NewClient(azure.KeyVault.VaultURL, credential, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Key Vault client: %w", err)
+	}
+	return &KeyVaultSecretProvider{client: client, prefix: azure.KeyVault.SecretPrefix}, nil
+}
+
+// GetSecret returns the latest version of a secret of the vault
+func (p *KeyVaultSecretProvider) GetSecret(ctx context.Context, name string) ([]byte, error) {
+	resp, err := p.client.GetSecret(ctx, p.prefix+name, "", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret %s from Key Vault: %w", name, err)
+	}
+	if resp.Value == nil || *resp.Value == "" {
+		return nil, fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+	return []byte(*resp.Value), nil
+}
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cohenjo/replicator/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvSecretProvider(t *testing.T) {
+	t.Setenv("REPLICATOR_SECRET_PII_HASH_KEY", "s3cr3t")
+
+	provider, err := NewSecretProvider(config.SecretsConfig{}, config.AzureConfig{})
+	require.NoError(t, err)
+
+	value, err := provider.GetSecret(context.Background(), "pii-hash.key")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("s3cr3t"), value)
+
+	_, err = provider.GetSecret(context.Background(), "missing")
+	assert.ErrorIs(t, err, ErrSecretNotFound)
+}
+
+func TestFileSecretProvider(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "pii-key"), []byte("s3cr3t\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "empty"), nil, 0o600))
+
+	provider, err := NewSecretProvider(config.SecretsConfig{Provider: config.SecretProviderFile, Directory: dir}, config.AzureConfig{})
+	require.NoError(t, err)
+
+	value, err := provider.GetSecret(context.Background(), "pii-key")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("s3cr3t"), value, "the trailing newline is not part of the secret")
+
+	_, err = provider.GetSecret(context.Background(), "missing")
+	assert.ErrorIs(t, err, ErrSecretNotFound)
+	_, err = provider.GetSecret(context.Background(), "empty")
+	assert.ErrorIs(t, err, ErrSecretNotFound)
+
+	for _, name := range []string{"../pii-key", "sub/pii-key", ".hidden", ""} {
+		_, err = provider.GetSecret(context.Background(), name)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestNewSecretProviderErrors(t *testing.T) {
+	_, err := NewSecretProvider(config.SecretsConfig{Provider: config.SecretProviderFile}, config.AzureConfig{})
+	assert.Error(t, err)
+
+	_, err = NewSecretProvider(config.SecretsConfig{Provider: config.SecretProviderAzureKeyVault}, config.AzureConfig{})
+	assert.Error(t, err)
+
+	_, err = NewSecretProvider(config.SecretsConfig{Provider: "vault"}, config.AzureConfig{})
+	assert.Error(t, err)
+}
@@ -23,6 +23,13 @@ const (
 	StreamStatusStopping StreamStatus = "stopping"
 )
 
+// Delivery guarantees supported by a stream
+const (
+	DeliveryAtLeastOnce = "at_least_once"
+	DeliveryAtMostOnce  = "at_most_once"
+	DeliveryExactlyOnce = "exactly_once"
+)
+
 // SourceType represents the type of data source
 type SourceType string
 
@@ -81,6 +88,73 @@ type TargetConfig struct {
 	Username string                 `json:"username,omitempty" yaml:"username,omitempty"`
 	Password string                 `json:"password,omitempty" yaml:"password,omitempty"`
 	Options  map[string]interface{} `json:"options,omitempty" yaml:"options,omitempty"`
+
+	// Retry and CircuitBreaker guard the writes to the target
+	Retry          *RetryConfig          `json:"retry,omitempty" yaml:"retry,omitempty"`
+	CircuitBreaker *CircuitBreakerConfig `json:"circuit_breaker,omitempty" yaml:"circuit_breaker,omitempty"`
+}
+
+// RetryConfig configures the retries of failed writes to a target. Unset or
+// zero fields keep their defaults.
+type RetryConfig struct {
+	MaxRetries      int      `json:"max_retries" yaml:"max_retries"`
+	InitialDelay    string   `json:"initial_delay,omitempty" yaml:"initial_delay,omitempty"` // Duration string
+	MaxDelay        string   `json:"max_delay,omitempty" yaml:"max_delay,omitempty"`         // Duration string
+	BackoffFactor   float64  `json:"backoff_factor,omitempty" yaml:"backoff_factor,omitempty"`
+	Jitter          float64  `json:"jitter,omitempty" yaml:"jitter,omitempty"`                     // Fraction of the delay, 0 to 1
+	RetryableErrors []string `json:"retryable_errors,omitempty" yaml:"retryable_errors,omitempty"` // Destination error codes
+}
+
+// CircuitBreakerConfig configures the circuit breaker of a target. An open
+// breaker pauses the source of the stream until the target recovers.
+type CircuitBreakerConfig struct {
+	Enabled          bool   `json:"enabled" yaml:"enabled"`
+	FailureThreshold int    `json:"failure_threshold,omitempty" yaml:"failure_threshold,omitempty"`
+	RecoveryTimeout  string `json:"recovery_timeout,omitempty" yaml:"recovery_timeout,omitempty"` // Duration string
+	HalfOpenRequests int    `json:"half_open_requests,omitempty" yaml:"half_open_requests,omitempty"`
+}
+
+// TransactionConfig groups the events of a stream by source transaction so
+// each transaction is applied atomically on the target
+type TransactionConfig struct {
+	Enabled   bool `json:"enabled" yaml:"enabled"`
+	MaxEvents int  `json:"max_events,omitempty" yaml:"max_events,omitempty"` // Larger transactions are split, 0 for the default
+}
+
+// FilterConfig selects the events of a stream that are replicated, by
+// operation, database, collection and field values. Names wrapped in
+// slashes, such as /^tmp_/, are regular expressions.
+type FilterConfig struct {
+	IncludeOperations  []string            `json:"include_operations,omitempty" yaml:"include_operations,omitempty"`
+	ExcludeOperations  []string            `json:"exclude_operations,omitempty" yaml:"exclude_operations,omitempty"`
+	IncludeDatabases   []string            `json:"include_databases,omitempty" yaml:"include_databases,omitempty"`
+	ExcludeDatabases   []string            `json:"exclude_databases,omitempty" yaml:"exclude_databases,omitempty"`
+	IncludeCollections []string            `json:"include_collections,omitempty" yaml:"include_collections,omitempty"`
+	ExcludeCollections []string            `json:"exclude_collections,omitempty" yaml:"exclude_collections,omitempty"`
+	FieldFilters       []FieldFilterConfig `json:"field_filters,omitempty" yaml:"field_filters,omitempty"`
+	CustomFilter       string              `json:"custom_filter,omitempty" yaml:"custom_filter,omitempty"` // Not supported yet
+}
+
+// FieldFilterConfig includes or excludes the events whose document field
+// matches a value
+type FieldFilterConfig struct {
+	Field    string      `json:"field" yaml:"field"`       // Path in the document of the event
+	Operator string      `json:"operator" yaml:"operator"` // eq, ne, gt, lt, gte, lte, in, nin, regex
+	Value    interface{} `json:"value" yaml:"value"`
+	Include  bool        `json:"include" yaml:"include"` // true to only keep matching events, false to skip them
+}
+
+// DeadLetterConfig configures the dead-letter queue that keeps the events
+// that failed to transform or to be written
+type DeadLetterConfig struct {
+	Enabled    bool     `json:"enabled" yaml:"enabled"`
+	Type       string   `json:"type,omitempty" yaml:"type,omitempty"`             // file (default), kafka, mongodb
+	Path       string   `json:"path,omitempty" yaml:"path,omitempty"`             // JSONL file of the file queue
+	Brokers    []string `json:"brokers,omitempty" yaml:"brokers,omitempty"`       // Brokers of the kafka queue
+	Topic      string   `json:"topic,omitempty" yaml:"topic,omitempty"`           // Topic of the kafka queue, should be compacted
+	URI        string   `json:"uri,omitempty" yaml:"uri,omitempty"`               // Connection URI of the mongodb queue
+	Database   string   `json:"database,omitempty" yaml:"database,omitempty"`     // Database of the mongodb queue
+	Collection string   `json:"collection,omitempty" yaml:"collection,omitempty"` // Collection of the mongodb queue
 }
 
 // LegacyTransformationConfig represents legacy configuration for data transformation (deprecated)
@@ -95,14 +169,34 @@ type StreamConfig struct {
 	Source         SourceConfig                 `json:"source" yaml:"source"`
 	Target         TargetConfig                 `json:"target" yaml:"target"`
 	Transformation *TransformationRulesConfig  `json:"transformation,omitempty" yaml:"transformation,omitempty"`
-	BatchSize      int                          `json:"batch_size,omitempty" yaml:"batch_size,omitempty"`
-	BufferSize     int                          `json:"buffer_size,omitempty" yaml:"buffer_size,omitempty"`
+	BatchSize      int                          `json:"batch_size,omitempty" yaml:"batch_size,omitempty"`     // Events per target write
+	BatchBytes     int                          `json:"batch_bytes,omitempty" yaml:"batch_bytes,omitempty"`   // Payload bytes per target write
+	BatchLinger    string                       `json:"batch_linger,omitempty" yaml:"batch_linger,omitempty"` // Duration string, how long a batch waits to fill
+	BufferSize     int                          `json:"buffer_size,omitempty" yaml:"buffer_size,omitempty"`   // Events buffered between source and target
+	Workers        int                          `json:"workers,omitempty" yaml:"workers,omitempty"`           // Parallel writers, events are partitioned by key
+	Transactions   *TransactionConfig           `json:"transactions,omitempty" yaml:"transactions,omitempty"` // Apply source transactions atomically
+	Filter         *FilterConfig                `json:"filter,omitempty" yaml:"filter,omitempty"`             // Events replicated, the others are skipped
+	Routes         []RouteConfig                `json:"routes,omitempty" yaml:"routes,omitempty"`             // Targets of the events meeting their conditions
 	Enabled        bool                         `json:"enabled" yaml:"enabled"`
+
+	// DeliveryGuarantee is one of at_least_once (default), at_most_once or exactly_once
+	DeliveryGuarantee string                    `json:"delivery_guarantee,omitempty" yaml:"delivery_guarantee,omitempty"`
 	
 	// Legacy field for backwards compatibility
 	LegacyTransformation *LegacyTransformationConfig `json:"legacy_transformation,omitempty" yaml:"legacy_transformation,omitempty"`
 }
 
+// RouteConfig sends the events of a stream that meet its conditions to a
+// target of its own, after the transformations of the stream and then its
+// own. Events are sent to every route whose conditions they meet, and to the
+// target of the stream, which is optional with routes.
+type RouteConfig struct {
+	Name           string                     `json:"name" yaml:"name"`
+	Conditions     []Condition                `json:"conditions,omitempty" yaml:"conditions,omitempty"`         // All must be met, every event is routed without conditions
+	Target         TargetConfig               `json:"target" yaml:"target"`
+	Transformation *TransformationRulesConfig `json:"transformation,omitempty" yaml:"transformation,omitempty"` // Applied after the transformations of the stream
+}
+
 // TransformationRulesConfig represents the configuration for stream-specific transformation rules
 type TransformationRulesConfig struct {
 	Enabled       bool                      `json:"enabled" yaml:"enabled"`
@@ -124,22 +218,145 @@ type TransformationRule struct {
 	Metadata     map[string]interface{} `json:"metadata,omitempty" yaml:"metadata,omitempty"`
 }
 
-// Condition represents a condition for applying a transformation
+// Condition represents a condition for applying a transformation. A
+// condition with All, Any or Not is a group combining other conditions
+// instead of testing a field.
 type Condition struct {
 	Field    string      `json:"field" yaml:"field"`         // JSONPath or field name
-	Operator string      `json:"operator" yaml:"operator"`   // eq, ne, gt, lt, contains, exists, etc.
+	Operator string      `json:"operator" yaml:"operator"`   // eq, ne, gt, gte, lt, lte, in, nin, contains, regex, startswith, endswith, between, exists, is_null
 	Value    interface{} `json:"value" yaml:"value"`         // Value to compare against
-	Type     string      `json:"type,omitempty" yaml:"type,omitempty"` // string, number, boolean, null
+	Type     string      `json:"type,omitempty" yaml:"type,omitempty"` // string, number, boolean, timestamp; inferred from the values when empty
+	All      []Condition `json:"all,omitempty" yaml:"all,omitempty"`   // Met when all of the conditions are
+	Any      []Condition `json:"any,omitempty" yaml:"any,omitempty"`   // Met when any of the conditions is
+	Not      *Condition  `json:"not,omitempty" yaml:"not,omitempty"`   // Met when the condition is not
 }
 
 // Action represents a transformation action
 type Action struct {
-	Type     string                 `json:"type" yaml:"type"`         // "kazaam", "jq", "lua", "javascript"
+	Type     string                 `json:"type" yaml:"type"`         // "kazaam", "jq", "lua", "javascript", or a field action
 	Spec     string                 `json:"spec" yaml:"spec"`         // Transformation specification
-	Target   string                 `json:"target,omitempty" yaml:"target,omitempty"` // Target field for output
+	Input    string                 `json:"input,omitempty" yaml:"input,omitempty"`   // Field the action runs on, defaults to Target
+	Target   string                 `json:"target,omitempty" yaml:"target,omitempty"` // Field the output is written to, defaults to Input; the whole event when both are empty
 	Config   map[string]interface{} `json:"config,omitempty" yaml:"config,omitempty"` // Action-specific config
 }
 
+// JQVariables are the variables of jq queries, bound to the metadata of the
+// transformed event
+var JQVariables = []string{"$action", "$schema", "$collection", "$stream"}
+
+// JQMultipleOutputs is the jq action config setting what happens when a query
+// returns more or less than one output: JQOutputsReject fails the action,
+// JQOutputsFanOut turns each output into an event of its own
+const (
+	JQMultipleOutputs = "multiple_outputs"
+	JQOutputsReject   = "reject"
+	JQOutputsFanOut   = "fan_out"
+)
+
+// Field actions are the built-in actions masking the personal data of the
+// events. They transform the values of the fields listed in their fields
+// setting, and need no spec.
+const (
+	ActionMask       = "mask"       // Masks all but the last keep_last characters
+	ActionHash       = "hash"       // HMAC-SHA256 with the key of key_secret, hex encoded
+	ActionTokenize   = "tokenize"   // Deterministic token keeping the digits, letters and separators
+	ActionRedact     = "redact"     // Replaces the value with replacement
+	ActionDrop       = "drop"       // Removes the field
+	ActionTruncate   = "truncate"   // Keeps the first length characters
+	ActionGeneralize = "generalize" // Dates to their granularity, numbers to buckets of bucket_size
+)
+
+// FieldActionTypes are the types of the field actions
+var FieldActionTypes = []string{ActionMask, ActionHash, ActionTokenize, ActionRedact, ActionDrop, ActionTruncate, ActionGeneralize}
+
+// Defaults of the field action settings
+const (
+	DefaultMaskKeepLast          = 4
+	DefaultMaskChar              = "*"
+	DefaultRedactReplacement     = "[REDACTED]"
+	DefaultGeneralizeGranularity = "month"
+)
+
+// FieldActionSettings are the settings of a field action, read from the
+// config of the action by ParseFieldActionSettings
+type FieldActionSettings struct {
+	Fields      []string // Paths of the fields the action transforms
+	KeepLast    int      // mask
+	MaskChar    string   // mask
+	KeySecret   string   // hash, tokenize: name of the secret holding the key
+	Replacement string   // redact
+	Length      int      // truncate
+	Granularity string   // generalize: year, month or day
+	BucketSize  float64  // generalize: numbers are generalized when set
+}
+
+// SecretsConfig selects where the secrets the configuration refers to by
+// name, such as the keys of the hash and tokenize actions, are read from
+type SecretsConfig struct {
+	Provider  string `json:"provider,omitempty" yaml:"provider,omitempty"`     // env (default), file or azure_key_vault
+	EnvPrefix string `json:"env_prefix,omitempty" yaml:"env_prefix,omitempty"` // env: prefix of the variables, REPLICATOR_SECRET_ by default
+	Directory string `json:"directory,omitempty" yaml:"directory,omitempty"`   // file: directory holding a file per secret
+}
+
+// ActionLookup is the type of the lookup actions, which enrich the events
+// with the records of a reference store. They are configured by their
+// settings, read by ParseLookupSettings, and need no spec.
+const ActionLookup = "lookup"
+
+// Miss policies of the lookup actions, for events whose key is not found
+const (
+	LookupMissNull = "null" // The target fields are set to null
+	LookupMissSkip = "skip" // The event is left as it is
+	LookupMissFail = "fail" // The action fails
+)
+
+// LookupSettings are the settings of a lookup action
+type LookupSettings struct {
+	Store       string            // Name of the reference store
+	Key         string            // Path of the key in the event
+	TargetField string            // Path the record is written to
+	Fields      map[string]string // Paths record fields are written to, by path
+	OnMiss      string            // Miss policy, null by default
+}
+
+// ReferenceStoreConfig configures a reference store, the table, collection
+// or file the lookup actions read their records from
+type ReferenceStoreConfig struct {
+	Name      string `json:"name" yaml:"name"`
+	Type      string `json:"type" yaml:"type"`                                 // mysql, postgresql, mongodb, csv or json
+	URI       string `json:"uri,omitempty" yaml:"uri,omitempty"`               // MySQL DSN, PostgreSQL or MongoDB connection string
+	Database  string `json:"database,omitempty" yaml:"database,omitempty"`     // MongoDB database
+	Table     string `json:"table,omitempty" yaml:"table,omitempty"`           // Table or collection
+	KeyField  string `json:"key_field" yaml:"key_field"`                       // Column or field the records are looked up by
+	Path      string `json:"path,omitempty" yaml:"path,omitempty"`             // CSV or JSON file, a JSON array of objects
+	CacheSize int    `json:"cache_size,omitempty" yaml:"cache_size,omitempty"` // Keys cached, 10000 by default
+	CacheTTL  string `json:"cache_ttl,omitempty" yaml:"cache_ttl,omitempty"`   // Duration keys stay cached, 5m by default
+	Timeout   string `json:"timeout,omitempty" yaml:"timeout,omitempty"`       // Query timeout, 5s by default
+}
+
+// Reference store types
+const (
+	ReferenceStoreMySQL      = "mysql"
+	ReferenceStorePostgreSQL = "postgresql"
+	ReferenceStoreMongoDB    = "mongodb"
+	ReferenceStoreCSV        = "csv"
+	ReferenceStoreJSON       = "json"
+)
+
+// Defaults of the reference stores
+const (
+	DefaultReferenceCacheSize = 10000
+	DefaultReferenceCacheTTL  = 5 * time.Minute
+	DefaultReferenceTimeout   = 5 * time.Second
+)
+
+// Secret providers
+const (
+	SecretProviderEnv           = "env"
+	SecretProviderFile          = "file"
+	SecretProviderAzureKeyVault = "azure_key_vault"
+)
+
 // ErrorHandlingPolicy defines how errors should be handled during transformation
 type ErrorHandlingPolicy struct {
 	Strategy        string        `json:"strategy" yaml:"strategy"`                 // fail_fast, skip, retry, dead_letter
@@ -167,7 +384,7 @@ func (s *StreamConfig) Validate() error {
 		return fmt.Errorf("source type cannot be empty")
 	}
 	
-	if s.Target.Type == "" {
+	if s.Target.Type == "" && len(s.Routes) == 0 {
 		return fmt.Errorf("target type cannot be empty")
 	}
 	
@@ -183,9 +400,14 @@ func (s *StreamConfig) Validate() error {
 		TargetTypeMongoDB: true, TargetTypeMySQL: true, TargetTypePostgreSQL: true,
 		TargetTypeCosmosDB: true, TargetTypeKafka: true, TargetTypeElastic: true,
 	}
-	if !validTargetTypes[s.Target.Type] {
+	if s.Target.Type != "" && !validTargetTypes[s.Target.Type] {
 		return fmt.Errorf("invalid target type: %s", s.Target.Type)
 	}
+	for _, route := range s.Routes {
+		if !validTargetTypes[route.Target.Type] {
+			return fmt.Errorf("invalid target type of route %s: %s", route.Name, route.Target.Type)
+		}
+	}
 	
 	return nil
 }
@@ -326,6 +548,11 @@ type Config struct {
 	Logging     LoggingConfig     `json:"logging" yaml:"logging"`
 	Azure       AzureConfig       `json:"azure" yaml:"azure"`
 	Telemetry   TelemetryConfig   `json:"telemetry" yaml:"telemetry"`
+	DeadLetter  DeadLetterConfig  `json:"dead_letter,omitempty" yaml:"dead_letter,omitempty"`
+	Secrets     SecretsConfig     `json:"secrets,omitempty" yaml:"secrets,omitempty"`
+
+	// ReferenceStores are the stores the lookup actions of the streams read from
+	ReferenceStores []ReferenceStoreConfig `json:"reference_stores,omitempty" yaml:"reference_stores,omitempty"`
 
 	// Legacy fields for backwards compatibility
 	Debug              bool                   `json:"debug,omitempty" yaml:"debug,omitempty"`
@@ -378,6 +605,17 @@ MongoRefreshBeforeExpiry     string   `json:"mongo_refresh_before_expiry,omitemp
 	CosmosPollInterval           int      `json:"cosmos_poll_interval,omitempty" yaml:"cosmos_poll_interval,omitempty"`
 	CosmosIncludeOperations      []string `json:"cosmos_include_operations,omitempty" yaml:"cosmos_include_operations,omitempty"`
 	CosmosExcludeOperations      []string `json:"cosmos_exclude_operations,omitempty" yaml:"cosmos_exclude_operations,omitempty"`
+	CosmosChangeFeedMode         string   `json:"cosmos_change_feed_mode,omitempty" yaml:"cosmos_change_feed_mode,omitempty"` // latest_version, all_versions_and_deletes
+	CosmosStartFrom              string   `json:"cosmos_start_from,omitempty" yaml:"cosmos_start_from,omitempty"`             // beginning, now or an RFC3339 time
+	CosmosAccountKey             string   `json:"cosmos_account_key,omitempty" yaml:"cosmos_account_key,omitempty"`
+	CosmosAuthMethod             string   `json:"cosmos_auth_method,omitempty" yaml:"cosmos_auth_method,omitempty"`           // default, managed_identity, service_principal, cli or key
+	CosmosTenantID               string   `json:"cosmos_tenant_id,omitempty" yaml:"cosmos_tenant_id,omitempty"`
+	CosmosClientID               string   `json:"cosmos_client_id,omitempty" yaml:"cosmos_client_id,omitempty"`
+	CosmosClientSecret           string   `json:"cosmos_client_secret,omitempty" yaml:"cosmos_client_secret,omitempty"`
+	CosmosPartitionKeyPaths      []string `json:"cosmos_partition_key_paths,omitempty" yaml:"cosmos_partition_key_paths,omitempty"` // e.g. /tenantId; read from the container when empty
+	CosmosIDField                string   `json:"cosmos_id_field,omitempty" yaml:"cosmos_id_field,omitempty"`                 // document field holding the item id, defaults to id
+	CosmosMaxConcurrency         int      `json:"cosmos_max_concurrency,omitempty" yaml:"cosmos_max_concurrency,omitempty"`   // logical partitions written in parallel
+	CosmosInsecureSkipVerify     bool     `json:"cosmos_insecure_skip_verify,omitempty" yaml:"cosmos_insecure_skip_verify,omitempty"`
 	
 	// MySQL specific fields
 	MySQLServerID                uint32   `json:"mysql_server_id,omitempty" yaml:"mysql_server_id,omitempty"`
@@ -400,6 +638,11 @@ MongoRefreshBeforeExpiry     string   `json:"mongo_refresh_before_expiry,omitemp
 	MySQLSSLCa                   string   `json:"mysql_ssl_ca,omitempty" yaml:"mysql_ssl_ca,omitempty"`
 	MySQLSSLMode                 string   `json:"mysql_ssl_mode,omitempty" yaml:"mysql_ssl_mode,omitempty"`
 	MySQLSkipSSLVerify           bool     `json:"mysql_skip_ssl_verify,omitempty" yaml:"mysql_skip_ssl_verify,omitempty"`
+	MySQLURI                     string   `json:"mysql_uri,omitempty" yaml:"mysql_uri,omitempty"` // go-sql-driver DSN
+	MySQLUser                    string   `json:"mysql_user,omitempty" yaml:"mysql_user,omitempty"`
+	MySQLPassword                string   `json:"mysql_password,omitempty" yaml:"mysql_password,omitempty"`
+	MySQLKeyColumns              []string `json:"mysql_key_columns,omitempty" yaml:"mysql_key_columns,omitempty"` // used when the table has no primary key
+	MySQLBatchRows               int      `json:"mysql_batch_rows,omitempty" yaml:"mysql_batch_rows,omitempty"`   // rows per multi-row upsert
 	
 	// PostgreSQL specific fields
 	PostgreSQLHost               string   `json:"postgresql_host,omitempty" yaml:"postgresql_host,omitempty"`
@@ -434,6 +677,40 @@ MongoRefreshBeforeExpiry     string   `json:"mongo_refresh_before_expiry,omitemp
 	PostgreSQLDropSlotOnExit     bool     `json:"postgresql_drop_slot_on_exit,omitempty" yaml:"postgresql_drop_slot_on_exit,omitempty"`
 	PostgreSQLSlotSnapShotAction string   `json:"postgresql_slot_snapshot_action,omitempty" yaml:"postgresql_slot_snapshot_action,omitempty"`
 	PostgreSQLTempSlot           bool     `json:"postgresql_temp_slot,omitempty" yaml:"postgresql_temp_slot,omitempty"`
+	PostgreSQLURI                string   `json:"postgresql_uri,omitempty" yaml:"postgresql_uri,omitempty"`
+	PostgreSQLTargetSchema       string   `json:"postgresql_target_schema,omitempty" yaml:"postgresql_target_schema,omitempty"` // defaults to public
+	PostgreSQLKeyColumns         []string `json:"postgresql_key_columns,omitempty" yaml:"postgresql_key_columns,omitempty"`     // used when the table has no primary key
+	PostgreSQLAutoCreate         bool     `json:"postgresql_auto_create,omitempty" yaml:"postgresql_auto_create,omitempty"`     // create missing tables and columns
+	PostgreSQLBatchMode          string   `json:"postgresql_batch_mode,omitempty" yaml:"postgresql_batch_mode,omitempty"`       // copy or multirow
+
+	// Elasticsearch specific fields
+	ElasticIDField               string                 `json:"elastic_id_field,omitempty" yaml:"elastic_id_field,omitempty"`             // document field holding the _id, defaults to the document key
+	ElasticRefresh               string                 `json:"elastic_refresh,omitempty" yaml:"elastic_refresh,omitempty"`               // true, false or wait_for
+	ElasticBulkActions           int                    `json:"elastic_bulk_actions,omitempty" yaml:"elastic_bulk_actions,omitempty"`     // flush after this many actions
+	ElasticBulkBytes             int                    `json:"elastic_bulk_bytes,omitempty" yaml:"elastic_bulk_bytes,omitempty"`         // flush after this many bytes
+	ElasticBulkTimeout           int                    `json:"elastic_bulk_timeout,omitempty" yaml:"elastic_bulk_timeout,omitempty"`     // milliseconds per bulk request
+	ElasticTemplateName          string                 `json:"elastic_template_name,omitempty" yaml:"elastic_template_name,omitempty"`
+	ElasticTemplate              map[string]interface{} `json:"elastic_template,omitempty" yaml:"elastic_template,omitempty"`
+	ElasticMappings              map[string]interface{} `json:"elastic_mappings,omitempty" yaml:"elastic_mappings,omitempty"`
+	ElasticSettings              map[string]interface{} `json:"elastic_settings,omitempty" yaml:"elastic_settings,omitempty"`
+	ElasticAddresses             []string               `json:"elastic_addresses,omitempty" yaml:"elastic_addresses,omitempty"` // node URLs, defaults to host:port
+	ElasticUsername              string                 `json:"elastic_username,omitempty" yaml:"elastic_username,omitempty"`
+	ElasticPassword              string                 `json:"elastic_password,omitempty" yaml:"elastic_password,omitempty"`
+	ElasticAPIKey                string                 `json:"elastic_api_key,omitempty" yaml:"elastic_api_key,omitempty"` // encoded, or id:key
+	ElasticCACert                string                 `json:"elastic_ca_cert,omitempty" yaml:"elastic_ca_cert,omitempty"`
+	ElasticClientCert            string                 `json:"elastic_client_cert,omitempty" yaml:"elastic_client_cert,omitempty"`
+	ElasticClientKey             string                 `json:"elastic_client_key,omitempty" yaml:"elastic_client_key,omitempty"`
+	ElasticInsecureSkipVerify    bool                   `json:"elastic_insecure_skip_verify,omitempty" yaml:"elastic_insecure_skip_verify,omitempty"`
+	ElasticVersion               string                 `json:"elastic_version,omitempty" yaml:"elastic_version,omitempty"`                 // 7, 8, opensearch or auto
+	ElasticCompatibleWith        int                    `json:"elastic_compatible_with,omitempty" yaml:"elastic_compatible_with,omitempty"` // compatibility headers version
+	ElasticExternalVersion       bool                   `json:"elastic_external_version,omitempty" yaml:"elastic_external_version,omitempty"` // version documents by source position
+
+	// Kafka specific fields
+	KafkaBrokers                 []string `json:"kafka_brokers,omitempty" yaml:"kafka_brokers,omitempty"`
+	KafkaTopicTemplate           string   `json:"kafka_topic_template,omitempty" yaml:"kafka_topic_template,omitempty"` // e.g. "{schema}.{collection}"
+	KafkaKeyFields               []string `json:"kafka_key_fields,omitempty" yaml:"kafka_key_fields,omitempty"`
+	KafkaFormat                  string   `json:"kafka_format,omitempty" yaml:"kafka_format,omitempty"` // legacy, document, debezium, cloudevents
+	KafkaTransactionalID         string   `json:"kafka_transactional_id,omitempty" yaml:"kafka_transactional_id,omitempty"` // enables exactly-once production
 }
 
 type TransformOperation struct {
@@ -604,6 +881,20 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("invalid log format: %s", c.Logging.Format)
 	}
 
+	if c.DeadLetter.Enabled {
+		if err := ValidateDeadLetterConfig(&c.DeadLetter); err != nil {
+			return fmt.Errorf("invalid dead letter config: %w", err)
+		}
+	}
+
+	if err := ValidateSecretsConfig(&c.Secrets, &c.Azure.KeyVault); err != nil {
+		return fmt.Errorf("invalid secrets config: %w", err)
+	}
+
+	if err := ValidateReferenceStores(c.ReferenceStores); err != nil {
+		return fmt.Errorf("invalid reference stores: %w", err)
+	}
+
 	// Validate stream configurations
 	streamNames := make(map[string]bool)
 	for _, stream := range c.Streams {
@@ -649,14 +940,59 @@ func (c *Config) validateStreamConfig(stream StreamConfig) error {
 		return fmt.Errorf("invalid target type: %s", stream.Target.Type)
 	}
 	
+	if stream.Target.Retry != nil {
+		if err := ValidateRetryConfig(stream.Target.Retry); err != nil {
+			return fmt.Errorf("invalid target retry config: %w", err)
+		}
+	}
+	if stream.Target.CircuitBreaker != nil {
+		if err := ValidateCircuitBreakerConfig(stream.Target.CircuitBreaker); err != nil {
+			return fmt.Errorf("invalid target circuit breaker config: %w", err)
+		}
+	}
+	
 	if stream.BatchSize < 0 {
 		return fmt.Errorf("batch size cannot be negative")
 	}
 	
+	if stream.BatchBytes < 0 {
+		return fmt.Errorf("batch bytes cannot be negative")
+	}
+	
+	if stream.BatchLinger != "" {
+		linger, err := time.ParseDuration(stream.BatchLinger)
+		if err != nil {
+			return fmt.Errorf("invalid batch linger: %w", err)
+		}
+		if linger < 0 {
+			return fmt.Errorf("batch linger cannot be negative")
+		}
+	}
+	
 	if stream.BufferSize < 0 {
 		return fmt.Errorf("buffer size cannot be negative")
 	}
 	
+	if stream.Workers < 0 {
+		return fmt.Errorf("workers cannot be negative")
+	}
+	
+	if err := ValidateDeliveryGuarantee(&stream); err != nil {
+		return err
+	}
+	
+	if stream.Transactions != nil {
+		if err := ValidateTransactionConfig(&stream); err != nil {
+			return fmt.Errorf("invalid transactions config: %w", err)
+		}
+	}
+	
+	if stream.Filter != nil {
+		if err := ValidateFilterConfig(stream.Filter); err != nil {
+			return fmt.Errorf("invalid filter config: %w", err)
+		}
+	}
+	
 	return nil
 }
 
@@ -316,6 +316,10 @@ func (l *Loader) validateStream(stream StreamConfig, index int) error {
 		return fmt.Errorf("target validation failed: %w", err)
 	}
 
+	if err := ValidateDeliveryGuarantee(&stream); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -334,6 +338,24 @@ func (l *Loader) validateTarget(target TargetConfig) error {
 		if target.URI == "" && target.Host == "" {
 			return fmt.Errorf("MongoDB connection string or host is required")
 		}
+	case TargetTypeMySQL:
+		if target.URI == "" && target.Host == "" {
+			return fmt.Errorf("MySQL DSN or host is required")
+		}
+	case TargetTypePostgreSQL:
+		if target.URI == "" && target.Host == "" {
+			return fmt.Errorf("PostgreSQL connection string or host is required")
+		}
+		if target.URI == "" && target.Database == "" {
+			return fmt.Errorf("PostgreSQL database is required")
+		}
+	case TargetTypeCosmosDB:
+		if target.URI == "" && target.Host == "" {
+			return fmt.Errorf("Cosmos DB connection string or endpoint is required")
+		}
+		if target.Database == "" {
+			return fmt.Errorf("Cosmos DB database is required")
+		}
 	default:
 		return fmt.Errorf("unsupported target type: %s", target.Type)
 	}
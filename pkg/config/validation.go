@@ -3,6 +3,12 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/itchyny/gojq"
+	"github.com/yuin/gopher-lua/parse"
 )
 
 // ValidateConfig validates the entire configuration
@@ -32,6 +38,14 @@ func ValidateConfig(cfg *Config) error {
 		return fmt.Errorf("azure config validation failed: %w", err)
 	}
 
+	if err := ValidateSecretsConfig(&cfg.Secrets, &cfg.Azure.KeyVault); err != nil {
+		return fmt.Errorf("secrets config validation failed: %w", err)
+	}
+
+	if err := ValidateReferenceStores(cfg.ReferenceStores); err != nil {
+		return fmt.Errorf("reference stores validation failed: %w", err)
+	}
+
 	return nil
 }
 
@@ -67,9 +81,15 @@ func ValidateStreamConfig(cfg *StreamConfig) error {
 		return fmt.Errorf("source config validation failed: %w", err)
 	}
 
-	// Validate target config
-	if err := ValidateTargetConfig(&cfg.Target); err != nil {
-		return fmt.Errorf("target config validation failed: %w", err)
+	// Validate target config, optional when the stream has routes
+	if cfg.Target.Type != "" || len(cfg.Routes) == 0 {
+		if err := ValidateTargetConfig(&cfg.Target); err != nil {
+			return fmt.Errorf("target config validation failed: %w", err)
+		}
+	}
+
+	if err := ValidateRoutes(cfg.Routes); err != nil {
+		return fmt.Errorf("routes validation failed: %w", err)
 	}
 
 	// Validate transformation rules if present
@@ -79,6 +99,90 @@ func ValidateStreamConfig(cfg *StreamConfig) error {
 		}
 	}
 
+	if err := ValidateDeliveryGuarantee(cfg); err != nil {
+		return err
+	}
+
+	if cfg.Transactions != nil {
+		if err := ValidateTransactionConfig(cfg); err != nil {
+			return fmt.Errorf("transactions config validation failed: %w", err)
+		}
+	}
+
+	if cfg.Filter != nil {
+		if err := ValidateFilterConfig(cfg.Filter); err != nil {
+			return fmt.Errorf("filter config validation failed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ValidateDeliveryGuarantee validates the delivery guarantee of a stream
+func ValidateDeliveryGuarantee(cfg *StreamConfig) error {
+	switch cfg.DeliveryGuarantee {
+	case "", DeliveryAtLeastOnce, DeliveryAtMostOnce:
+	case DeliveryExactlyOnce:
+		// Exactly-once relies on Kafka transactions committing the consumed offsets
+		if cfg.Source.Type != SourceTypeKafka || cfg.Target.Type != TargetTypeKafka {
+			return fmt.Errorf("exactly_once delivery is only supported for kafka to kafka streams")
+		}
+		// Transactions committing the consumed offsets have to be written in order
+		if cfg.Workers > 1 {
+			return fmt.Errorf("exactly_once delivery requires a single worker")
+		}
+		if len(cfg.Routes) > 0 {
+			return fmt.Errorf("exactly_once delivery is not supported with routes")
+		}
+	default:
+		return fmt.Errorf("unsupported delivery guarantee: %s", cfg.DeliveryGuarantee)
+	}
+	return nil
+}
+
+// ValidateRoutes validates the routes of a stream, whose names must be
+// unique
+func ValidateRoutes(routes []RouteConfig) error {
+	names := make(map[string]bool)
+	for i := range routes {
+		route := &routes[i]
+		if err := ValidateRouteConfig(route); err != nil {
+			return fmt.Errorf("route %d validation failed: %w", i, err)
+		}
+		if names[route.Name] {
+			return fmt.Errorf("duplicate route name: %s", route.Name)
+		}
+		names[route.Name] = true
+	}
+	return nil
+}
+
+// ValidateRouteConfig validates the conditions, target and transformation
+// rules of a route
+func ValidateRouteConfig(cfg *RouteConfig) error {
+	if cfg.Name == "" {
+		return fmt.Errorf("route name is required")
+	}
+	// Routes are named after their stream, stream/route
+	if strings.Contains(cfg.Name, "/") {
+		return fmt.Errorf("route name cannot contain '/': %s", cfg.Name)
+	}
+
+	for i := range cfg.Conditions {
+		if err := ValidateCondition(&cfg.Conditions[i]); err != nil {
+			return fmt.Errorf("condition %d validation failed: %w", i, err)
+		}
+	}
+
+	if err := ValidateTargetConfig(&cfg.Target); err != nil {
+		return fmt.Errorf("target config validation failed: %w", err)
+	}
+
+	if cfg.Transformation != nil {
+		if err := ValidateTransformationRules(cfg.Transformation); err != nil {
+			return fmt.Errorf("transformation rules validation failed: %w", err)
+		}
+	}
 	return nil
 }
 
@@ -117,12 +221,15 @@ func ValidateTargetConfig(cfg *TargetConfig) error {
 		return fmt.Errorf("target config cannot be nil")
 	}
 
-	if cfg.URI == "" {
+	// Kafka targets may list their brokers instead
+	if cfg.URI == "" && !(cfg.Type == TargetTypeKafka && (cfg.Host != "" || cfg.Options["brokers"] != nil)) {
 		return fmt.Errorf("uri is required")
 	}
 
 	// Type-specific validation
 	switch cfg.Type {
+	case TargetTypeKafka:
+		// Kafka targets write to topics and have no database
 	case TargetTypeMongoDB:
 		if cfg.Database == "" {
 			return fmt.Errorf("database is required for MongoDB target")
@@ -143,6 +250,166 @@ func ValidateTargetConfig(cfg *TargetConfig) error {
 		return fmt.Errorf("unsupported target type: %s", cfg.Type)
 	}
 
+	if cfg.Retry != nil {
+		if err := ValidateRetryConfig(cfg.Retry); err != nil {
+			return fmt.Errorf("retry config validation failed: %w", err)
+		}
+	}
+	if cfg.CircuitBreaker != nil {
+		if err := ValidateCircuitBreakerConfig(cfg.CircuitBreaker); err != nil {
+			return fmt.Errorf("circuit breaker config validation failed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ValidateDeadLetterConfig validates the dead-letter queue configuration
+func ValidateDeadLetterConfig(cfg *DeadLetterConfig) error {
+	switch cfg.Type {
+	case "", "file":
+	case "kafka":
+		if len(cfg.Brokers) == 0 {
+			return fmt.Errorf("kafka dead letter queue requires brokers")
+		}
+	case "mongodb":
+		if cfg.URI == "" {
+			return fmt.Errorf("mongodb dead letter queue requires a uri")
+		}
+	default:
+		return fmt.Errorf("unsupported dead letter queue type: %s", cfg.Type)
+	}
+	return nil
+}
+
+// ValidateRetryConfig validates the retry configuration of a target
+func ValidateRetryConfig(cfg *RetryConfig) error {
+	if cfg.MaxRetries < 0 {
+		return fmt.Errorf("max_retries cannot be negative")
+	}
+	for name, value := range map[string]string{"initial_delay": cfg.InitialDelay, "max_delay": cfg.MaxDelay} {
+		if value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(value); err != nil || d < 0 {
+			return fmt.Errorf("invalid %s: %s", name, value)
+		}
+	}
+	if cfg.BackoffFactor != 0 && cfg.BackoffFactor < 1 {
+		return fmt.Errorf("backoff_factor must be at least 1")
+	}
+	if cfg.Jitter < 0 || cfg.Jitter > 1 {
+		return fmt.Errorf("jitter must be between 0 and 1")
+	}
+	return nil
+}
+
+// ValidateCircuitBreakerConfig validates the circuit breaker configuration of a target
+func ValidateCircuitBreakerConfig(cfg *CircuitBreakerConfig) error {
+	if cfg.FailureThreshold < 0 {
+		return fmt.Errorf("failure_threshold cannot be negative")
+	}
+	if cfg.HalfOpenRequests < 0 {
+		return fmt.Errorf("half_open_requests cannot be negative")
+	}
+	if cfg.RecoveryTimeout != "" {
+		if d, err := time.ParseDuration(cfg.RecoveryTimeout); err != nil || d <= 0 {
+			return fmt.Errorf("invalid recovery_timeout: %s", cfg.RecoveryTimeout)
+		}
+	}
+	return nil
+}
+
+// ValidateFilterConfig validates the event filter of a stream
+func ValidateFilterConfig(cfg *FilterConfig) error {
+	if cfg.CustomFilter != "" {
+		return fmt.Errorf("custom filters are not supported")
+	}
+
+	names := [][]string{
+		cfg.IncludeOperations, cfg.ExcludeOperations,
+		cfg.IncludeDatabases, cfg.ExcludeDatabases,
+		cfg.IncludeCollections, cfg.ExcludeCollections,
+	}
+	for _, list := range names {
+		for _, name := range list {
+			if pattern, ok := FilterPattern(name); ok {
+				if _, err := regexp.Compile(pattern); err != nil {
+					return fmt.Errorf("invalid filter pattern %s: %w", name, err)
+				}
+			}
+		}
+	}
+
+	for i, filter := range cfg.FieldFilters {
+		if filter.Field == "" {
+			return fmt.Errorf("field filter %d: field is required", i)
+		}
+		switch filter.Operator {
+		case "eq", "ne", "gt", "lt", "gte", "lte":
+		case "in", "nin":
+			if _, ok := filter.Value.([]interface{}); !ok {
+				return fmt.Errorf("field filter %d: operator %s needs a list of values", i, filter.Operator)
+			}
+		case "regex":
+			pattern, ok := filter.Value.(string)
+			if !ok {
+				return fmt.Errorf("field filter %d: operator regex needs a string pattern", i)
+			}
+			if _, err := regexp.Compile(pattern); err != nil {
+				return fmt.Errorf("field filter %d: invalid regex: %w", i, err)
+			}
+		default:
+			return fmt.Errorf("field filter %d: unsupported operator: %s", i, filter.Operator)
+		}
+	}
+	return nil
+}
+
+// FilterPattern returns the regular expression of a filter name wrapped in
+// slashes, and whether the name is one
+func FilterPattern(name string) (string, bool) {
+	if len(name) >= 2 && strings.HasPrefix(name, "/") && strings.HasSuffix(name, "/") {
+		return name[1 : len(name)-1], true
+	}
+	return "", false
+}
+
+// ValidateTransactionConfig validates the transaction grouping of a stream.
+// Transactions are read from the MySQL binlog and PostgreSQL logical
+// replication, and applied on targets that can write a batch atomically.
+func ValidateTransactionConfig(cfg *StreamConfig) error {
+	transactions := cfg.Transactions
+	if transactions.MaxEvents < 0 {
+		return fmt.Errorf("max_events cannot be negative")
+	}
+	if !transactions.Enabled {
+		return nil
+	}
+	switch cfg.Source.Type {
+	case SourceTypeMySQL, SourceTypePostgreSQL:
+	default:
+		return fmt.Errorf("transactions are not supported for %s sources", cfg.Source.Type)
+	}
+	// Each target applies the transactions on its own
+	targets := make([]TargetType, 0, len(cfg.Routes)+1)
+	if cfg.Target.Type != "" {
+		targets = append(targets, cfg.Target.Type)
+	}
+	for _, route := range cfg.Routes {
+		targets = append(targets, route.Target.Type)
+	}
+	for _, target := range targets {
+		switch target {
+		case TargetTypeMySQL, TargetTypePostgreSQL, TargetTypeMongoDB:
+		default:
+			return fmt.Errorf("transactions are not supported for %s targets", target)
+		}
+	}
+	// Transactions are applied one after the other, in commit order
+	if cfg.Workers > 1 {
+		return fmt.Errorf("transactions require a single worker")
+	}
 	return nil
 }
 
@@ -202,6 +469,31 @@ func ValidateCondition(condition *Condition) error {
 		return fmt.Errorf("condition cannot be nil")
 	}
 
+	// Validate condition groups
+	groups := 0
+	for _, set := range []bool{len(condition.All) > 0, len(condition.Any) > 0, condition.Not != nil} {
+		if set {
+			groups++
+		}
+	}
+	if groups > 1 {
+		return fmt.Errorf("a condition group is one of all, any or not")
+	}
+	if groups == 1 {
+		if condition.Field != "" || condition.Operator != "" {
+			return fmt.Errorf("condition groups have no field or operator")
+		}
+		for i, c := range append(append([]Condition{}, condition.All...), condition.Any...) {
+			if err := ValidateCondition(&c); err != nil {
+				return fmt.Errorf("condition %d validation failed: %w", i, err)
+			}
+		}
+		if condition.Not != nil {
+			return ValidateCondition(condition.Not)
+		}
+		return nil
+	}
+
 	if condition.Field == "" {
 		return fmt.Errorf("field is required")
 	}
@@ -211,7 +503,7 @@ func ValidateCondition(condition *Condition) error {
 	}
 
 	// Validate supported operators
-	supportedOperators := []string{"eq", "ne", "gt", "lt", "gte", "lte", "contains", "exists", "in", "not_in"}
+	supportedOperators := []string{"eq", "ne", "gt", "lt", "gte", "lte", "contains", "exists", "in", "nin", "not_in", "regex", "startswith", "endswith", "between", "is_null"}
 	validOperator := false
 	for _, op := range supportedOperators {
 		if condition.Operator == op {
@@ -224,11 +516,36 @@ func ValidateCondition(condition *Condition) error {
 		return fmt.Errorf("unsupported operator: %s", condition.Operator)
 	}
 
+	switch condition.Type {
+	case "", "string", "number", "boolean", "timestamp":
+	default:
+		return fmt.Errorf("unsupported condition type: %s", condition.Type)
+	}
+
 	// For operators that require a value, ensure it's provided
-	if condition.Operator != "exists" && condition.Value == nil {
+	if condition.Operator != "exists" && condition.Operator != "is_null" && condition.Value == nil {
 		return fmt.Errorf("value is required for operator: %s", condition.Operator)
 	}
 
+	switch condition.Operator {
+	case "in", "nin", "not_in":
+		if _, ok := condition.Value.([]interface{}); !ok {
+			return fmt.Errorf("operator %s needs a list of values", condition.Operator)
+		}
+	case "between":
+		if bounds, ok := condition.Value.([]interface{}); !ok || len(bounds) != 2 {
+			return fmt.Errorf("operator between needs a list of two values")
+		}
+	case "regex":
+		pattern, ok := condition.Value.(string)
+		if !ok {
+			return fmt.Errorf("operator regex needs a string pattern")
+		}
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid regex: %w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -243,7 +560,7 @@ func ValidateAction(action *Action) error {
 	}
 
 	// Validate supported action types
-	supportedTypes := []string{"kazaam", "jq", "lua", "javascript"}
+	supportedTypes := append([]string{"kazaam", "jq", "lua", "javascript", ActionLookup}, FieldActionTypes...)
 	validType := false
 	for _, t := range supportedTypes {
 		if action.Type == t {
@@ -256,6 +573,16 @@ func ValidateAction(action *Action) error {
 		return fmt.Errorf("unsupported action type: %s", action.Type)
 	}
 
+	// Field and lookup actions are configured by their settings
+	if IsFieldAction(action.Type) {
+		_, err := ParseFieldActionSettings(action.Type, action.Config)
+		return err
+	}
+	if action.Type == ActionLookup {
+		_, err := ParseLookupSettings(action.Config)
+		return err
+	}
+
 	if action.Spec == "" {
 		return fmt.Errorf("action spec is required")
 	}
@@ -268,6 +595,284 @@ func ValidateAction(action *Action) error {
 		}
 	}
 
+	if action.Type == "jq" {
+		if err := validateJQAction(action); err != nil {
+			return err
+		}
+	}
+
+	// Check the syntax of lua scripts
+	if action.Type == "lua" {
+		if _, err := parse.Parse(strings.NewReader(action.Spec), "transform"); err != nil {
+			return fmt.Errorf("invalid Lua script: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// IsFieldAction reports whether an action type is one of the field actions
+func IsFieldAction(actionType string) bool {
+	for _, t := range FieldActionTypes {
+		if actionType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseFieldActionSettings reads and checks the settings of a field action
+// from its config, with the defaults for the settings it leaves out
+func ParseFieldActionSettings(actionType string, settings map[string]interface{}) (FieldActionSettings, error) {
+	parsed := FieldActionSettings{
+		KeepLast:    DefaultMaskKeepLast,
+		MaskChar:    DefaultMaskChar,
+		Replacement: DefaultRedactReplacement,
+		Granularity: DefaultGeneralizeGranularity,
+	}
+
+	fields, ok := settings["fields"].([]interface{})
+	if !ok {
+		if list, isList := settings["fields"].([]string); isList {
+			for _, field := range list {
+				fields = append(fields, field)
+			}
+		}
+	}
+	for _, field := range fields {
+		name, ok := field.(string)
+		if !ok || name == "" {
+			return parsed, fmt.Errorf("%s action: invalid field %v", actionType, field)
+		}
+		parsed.Fields = append(parsed.Fields, name)
+	}
+	if len(parsed.Fields) == 0 {
+		return parsed, fmt.Errorf("%s action: fields are required", actionType)
+	}
+
+	var err error
+	switch actionType {
+	case ActionMask:
+		if parsed.KeepLast, err = intSetting(settings, "keep_last", DefaultMaskKeepLast); err != nil {
+			return parsed, err
+		}
+		if parsed.KeepLast < 0 {
+			return parsed, fmt.Errorf("mask action: keep_last cannot be negative")
+		}
+		if char, ok := settings["mask_char"]; ok {
+			value, isString := char.(string)
+			if !isString || len([]rune(value)) != 1 {
+				return parsed, fmt.Errorf("mask action: mask_char must be a single character")
+			}
+			parsed.MaskChar = value
+		}
+	case ActionHash, ActionTokenize:
+		parsed.KeySecret, _ = settings["key_secret"].(string)
+		if parsed.KeySecret == "" {
+			return parsed, fmt.Errorf("%s action: key_secret is required, keys are read from the secret provider", actionType)
+		}
+	case ActionRedact:
+		if replacement, ok := settings["replacement"]; ok {
+			value, isString := replacement.(string)
+			if !isString {
+				return parsed, fmt.Errorf("redact action: replacement must be a string")
+			}
+			parsed.Replacement = value
+		}
+	case ActionTruncate:
+		if parsed.Length, err = intSetting(settings, "length", 0); err != nil {
+			return parsed, err
+		}
+		if parsed.Length <= 0 {
+			return parsed, fmt.Errorf("truncate action: length must be positive")
+		}
+	case ActionGeneralize:
+		if granularity, ok := settings["granularity"]; ok {
+			parsed.Granularity, _ = granularity.(string)
+		}
+		switch parsed.Granularity {
+		case "year", "month", "day":
+		default:
+			return parsed, fmt.Errorf("generalize action: invalid granularity %v, must be year, month or day", settings["granularity"])
+		}
+		if size, ok := settings["bucket_size"]; ok {
+			value, isNumber := numberSetting(size)
+			if !isNumber || value <= 0 {
+				return parsed, fmt.Errorf("generalize action: bucket_size must be a positive number")
+			}
+			parsed.BucketSize = value
+		}
+	}
+	return parsed, nil
+}
+
+// intSetting reads an integer setting, given as an int by YAML and as a
+// float64 by JSON
+func intSetting(settings map[string]interface{}, key string, defaultValue int) (int, error) {
+	value, ok := settings[key]
+	if !ok {
+		return defaultValue, nil
+	}
+	number, isNumber := numberSetting(value)
+	if !isNumber || number != float64(int(number)) {
+		return 0, fmt.Errorf("invalid %s: %v, must be an integer", key, value)
+	}
+	return int(number), nil
+}
+
+func numberSetting(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// ParseLookupSettings reads and checks the settings of a lookup action from
+// its config
+func ParseLookupSettings(settings map[string]interface{}) (LookupSettings, error) {
+	parsed := LookupSettings{OnMiss: LookupMissNull}
+	parsed.Store, _ = settings["store"].(string)
+	if parsed.Store == "" {
+		return parsed, fmt.Errorf("lookup action: store is required")
+	}
+	parsed.Key, _ = settings["key"].(string)
+	if parsed.Key == "" {
+		return parsed, fmt.Errorf("lookup action: key is required")
+	}
+	if target, ok := settings["target_field"]; ok {
+		if parsed.TargetField, _ = target.(string); parsed.TargetField == "" {
+			return parsed, fmt.Errorf("lookup action: invalid target_field %v", target)
+		}
+	}
+
+	switch fields := settings["fields"].(type) {
+	case nil:
+	case map[string]interface{}:
+		parsed.Fields = make(map[string]string, len(fields))
+		for path, field := range fields {
+			name, ok := field.(string)
+			if !ok || path == "" || name == "" {
+				return parsed, fmt.Errorf("lookup action: invalid field %s: %v", path, field)
+			}
+			parsed.Fields[path] = name
+		}
+	case map[string]string:
+		parsed.Fields = fields
+	default:
+		return parsed, fmt.Errorf("lookup action: fields must map the paths of the event to record fields")
+	}
+	if parsed.TargetField == "" && len(parsed.Fields) == 0 {
+		return parsed, fmt.Errorf("lookup action: target_field or fields is required")
+	}
+
+	if onMiss, ok := settings["on_miss"]; ok {
+		parsed.OnMiss, _ = onMiss.(string)
+		switch parsed.OnMiss {
+		case LookupMissNull, LookupMissSkip, LookupMissFail:
+		default:
+			return parsed, fmt.Errorf("lookup action: invalid on_miss %v, must be %s, %s or %s", onMiss, LookupMissNull, LookupMissSkip, LookupMissFail)
+		}
+	}
+	return parsed, nil
+}
+
+// ValidateReferenceStores validates the reference stores, whose names must
+// be unique
+func ValidateReferenceStores(stores []ReferenceStoreConfig) error {
+	names := make(map[string]bool)
+	for i := range stores {
+		store := &stores[i]
+		if store.Name == "" {
+			return fmt.Errorf("reference store %d: name is required", i)
+		}
+		if names[store.Name] {
+			return fmt.Errorf("duplicate reference store name: %s", store.Name)
+		}
+		names[store.Name] = true
+		if err := ValidateReferenceStoreConfig(store); err != nil {
+			return fmt.Errorf("reference store %s: %w", store.Name, err)
+		}
+	}
+	return nil
+}
+
+// ValidateReferenceStoreConfig validates a reference store
+func ValidateReferenceStoreConfig(cfg *ReferenceStoreConfig) error {
+	if cfg.KeyField == "" {
+		return fmt.Errorf("key_field is required")
+	}
+	switch cfg.Type {
+	case ReferenceStoreMySQL, ReferenceStorePostgreSQL, ReferenceStoreMongoDB:
+		if cfg.URI == "" {
+			return fmt.Errorf("%s reference store requires a uri", cfg.Type)
+		}
+		if cfg.Table == "" {
+			return fmt.Errorf("%s reference store requires a table", cfg.Type)
+		}
+		if cfg.Type == ReferenceStoreMongoDB && cfg.Database == "" {
+			return fmt.Errorf("mongodb reference store requires a database")
+		}
+	case ReferenceStoreCSV, ReferenceStoreJSON:
+		if cfg.Path == "" {
+			return fmt.Errorf("%s reference store requires a path", cfg.Type)
+		}
+	default:
+		return fmt.Errorf("unsupported reference store type: %s", cfg.Type)
+	}
+	if cfg.CacheSize < 0 {
+		return fmt.Errorf("cache_size cannot be negative")
+	}
+	for name, value := range map[string]string{"cache_ttl": cfg.CacheTTL, "timeout": cfg.Timeout} {
+		if value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+			return fmt.Errorf("invalid %s: %s", name, value)
+		}
+	}
+	return nil
+}
+
+// ValidateSecretsConfig validates the secret provider configuration
+func ValidateSecretsConfig(cfg *SecretsConfig, keyVault *KeyVaultConfig) error {
+	switch cfg.Provider {
+	case "", SecretProviderEnv:
+	case SecretProviderFile:
+		if cfg.Directory == "" {
+			return fmt.Errorf("file secret provider requires a directory")
+		}
+	case SecretProviderAzureKeyVault:
+		if keyVault == nil || keyVault.VaultURL == "" {
+			return fmt.Errorf("azure key vault secret provider requires azure.key_vault.vault_url")
+		}
+	default:
+		return fmt.Errorf("unsupported secret provider: %s", cfg.Provider)
+	}
+	return nil
+}
+
+// validateJQAction compiles the query of a jq action, so queries that fail
+// to parse or use unknown variables are rejected when the config is loaded
+func validateJQAction(action *Action) error {
+	query, err := gojq.Parse(action.Spec)
+	if err != nil {
+		return fmt.Errorf("invalid jq query: %w", err)
+	}
+	if _, err := gojq.Compile(query, gojq.WithVariables(JQVariables)); err != nil {
+		return fmt.Errorf("invalid jq query: %w", err)
+	}
+	if outputs, ok := action.Config[JQMultipleOutputs]; ok {
+		switch outputs {
+		case JQOutputsReject, JQOutputsFanOut:
+		default:
+			return fmt.Errorf("invalid %s for jq action: %v, must be %s or %s", JQMultipleOutputs, outputs, JQOutputsReject, JQOutputsFanOut)
+		}
+	}
 	return nil
 }
 
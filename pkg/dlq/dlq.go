@@ -0,0 +1,207 @@
+// Package dlq implements the dead-letter queue of the replicator. Events that
+// fail to transform or to be written are stored with the stream, stage, error
+// and source position, so they can be inspected and replayed or purged later.
+package dlq
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/cohenjo/replicator/pkg/config"
+	"github.com/cohenjo/replicator/pkg/events"
+)
+
+// Stage is the processing stage an event failed in
+type Stage string
+
+const (
+	StageTransform Stage = "transform"
+	StageWrite     Stage = "write"
+)
+
+// Supported queue backends
+const (
+	TypeFile    = "file"
+	TypeKafka   = "kafka"
+	TypeMongoDB = "mongodb"
+)
+
+// ErrEntryNotFound is returned for entries that are not in the queue
+var ErrEntryNotFound = errors.New("dead-letter entry not found")
+
+// Entry is a failed event in the dead-letter queue
+type Entry struct {
+	ID        string                 `json:"id"`
+	Stream    string                 `json:"stream"`
+	Stage     Stage                  `json:"stage"`
+	Error     string                 `json:"error"`
+	Rule      string                 `json:"rule,omitempty"` // Transformation rule that failed, for the transform stage
+	Attempts  int                    `json:"attempts"`
+	Position  map[string]interface{} `json:"position,omitempty"` // Source position of the event
+	Event     *events.RecordEvent    `json:"event,omitempty"`    // The event as read from the source
+	Payload   map[string]interface{} `json:"payload,omitempty"`  // The transformed event, for the write stage
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// NewEntry creates an entry for an event that failed in the given stage. The
+// payload is the event as it was handed to the stage.
+func NewEntry(stream string, stage Stage, cause error, attempts int, event *events.RecordEvent, payload map[string]interface{}) *Entry {
+	entry := &Entry{
+		Stream:    stream,
+		Stage:     stage,
+		Attempts:  attempts,
+		Event:     event,
+		Payload:   encodablePayload(payload),
+		CreatedAt: time.Now().UTC(),
+	}
+	if cause != nil {
+		entry.Error = cause.Error()
+	}
+	if event != nil {
+		entry.Position = event.Position
+	}
+	return entry
+}
+
+// Filter selects entries of the queue. Empty fields match every entry.
+type Filter struct {
+	Stream string `json:"stream,omitempty"`
+	Stage  Stage  `json:"stage,omitempty"`
+	Limit  int    `json:"limit,omitempty"` // Zero lists every matching entry
+}
+
+// Matches reports whether the entry is selected by the filter
+func (f Filter) Matches(entry *Entry) bool {
+	return (f.Stream == "" || entry.Stream == f.Stream) && (f.Stage == "" || entry.Stage == f.Stage)
+}
+
+// Queue stores the entries of the dead-letter queue. Entries are listed
+// oldest first.
+type Queue interface {
+	// Put stores an entry, assigning its ID when it has none
+	Put(ctx context.Context, entry *Entry) error
+
+	// List returns the entries selected by the filter
+	List(ctx context.Context, filter Filter) ([]*Entry, error)
+
+	// Get returns an entry by ID
+	Get(ctx context.Context, id string) (*Entry, error)
+
+	// Delete removes entries by ID, ignoring IDs that are not in the queue
+	Delete(ctx context.Context, ids ...string) error
+
+	// Purge removes the entries selected by the filter and returns their count
+	Purge(ctx context.Context, filter Filter) (int, error)
+
+	// Close releases the resources of the queue
+	Close() error
+}
+
+// New creates the queue configured by the dead-letter configuration
+func New(cfg config.DeadLetterConfig) (Queue, error) {
+	switch cfg.Type {
+	case TypeFile, "":
+		return NewFileQueue(cfg.Path)
+	case TypeKafka:
+		return NewKafkaQueue(cfg.Brokers, cfg.Topic)
+	case TypeMongoDB:
+		return NewMongoQueue(cfg.URI, cfg.Database, cfg.Collection)
+	default:
+		return nil, fmt.Errorf("unsupported dead-letter queue type: %s", cfg.Type)
+	}
+}
+
+// prepare assigns the ID and creation time of a new entry
+func prepare(entry *Entry) {
+	if entry.ID == "" {
+		entry.ID = newEntryID(entry.CreatedAt)
+	}
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now().UTC()
+	}
+}
+
+// newEntryID returns an ID that sorts by creation time
+func newEntryID(createdAt time.Time) string {
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return fmt.Sprintf("%d-%s", createdAt.UnixNano(), hex.EncodeToString(suffix))
+}
+
+// selectEntries sorts the entries oldest first and applies the filter
+func selectEntries(entries []*Entry, filter Filter) []*Entry {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+	})
+	selected := make([]*Entry, 0, len(entries))
+	for _, entry := range entries {
+		if !filter.Matches(entry) {
+			continue
+		}
+		selected = append(selected, entry)
+		if filter.Limit > 0 && len(selected) == filter.Limit {
+			break
+		}
+	}
+	return selected
+}
+
+// encodablePayload keeps the JSON documents of the payload readable: the
+// data, old data and document key of an event are JSON bytes, which would be
+// stored base64-encoded and come back as strings
+func encodablePayload(payload map[string]interface{}) map[string]interface{} {
+	if payload == nil {
+		return nil
+	}
+	encodable := make(map[string]interface{}, len(payload))
+	for key, value := range payload {
+		if raw, ok := value.([]byte); ok && json.Valid(raw) {
+			encodable[key] = json.RawMessage(raw)
+			continue
+		}
+		encodable[key] = value
+	}
+	return encodable
+}
+
+// ReplayFunc replays an entry through the stage it failed in
+type ReplayFunc func(ctx context.Context, entry *Entry) error
+
+// ReplayResult lists the entries that were replayed and the errors of those
+// that failed again
+type ReplayResult struct {
+	Replayed []string          `json:"replayed"`
+	Failed   map[string]string `json:"failed,omitempty"`
+}
+
+// Replay replays the entries in order and removes the replayed ones from the
+// queue. Entries that fail again stay in the queue.
+func Replay(ctx context.Context, queue Queue, entries []*Entry, replay ReplayFunc) (*ReplayResult, error) {
+	result := &ReplayResult{Replayed: make([]string, 0, len(entries))}
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		if err := replay(ctx, entry); err != nil {
+			if result.Failed == nil {
+				result.Failed = make(map[string]string)
+			}
+			result.Failed[entry.ID] = err.Error()
+			continue
+		}
+		if err := queue.Delete(ctx, entry.ID); err != nil {
+			return result, fmt.Errorf("failed to remove replayed entry %s: %w", entry.ID, err)
+		}
+		result.Replayed = append(result.Replayed, entry.ID)
+	}
+	return result, nil
+}
@@ -0,0 +1,186 @@
+package dlq
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// defaultFilePath is used when the file queue has no path configured
+const defaultFilePath = "replicator-dlq.jsonl"
+
+// FileQueue stores the entries as JSON lines in a local file. Deletes rewrite
+// the file, which suits the small queues of a single replicator.
+type FileQueue struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileQueue creates a queue stored in the file at path, creating its
+// directory when missing
+func NewFileQueue(path string) (*FileQueue, error) {
+	if path == "" {
+		path = defaultFilePath
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create dead-letter directory: %w", err)
+		}
+	}
+	return &FileQueue{path: path}, nil
+}
+
+// Put appends an entry to the file
+func (q *FileQueue) Put(ctx context.Context, entry *Entry) error {
+	prepare(entry)
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode dead-letter entry: %w", err)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	f, err := os.OpenFile(q.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open dead-letter file: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write dead-letter entry: %w", err)
+	}
+	return f.Sync()
+}
+
+// List returns the entries selected by the filter
+func (q *FileQueue) List(ctx context.Context, filter Filter) ([]*Entry, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	entries, err := q.read()
+	if err != nil {
+		return nil, err
+	}
+	return selectEntries(entries, filter), nil
+}
+
+// Get returns an entry by ID
+func (q *FileQueue) Get(ctx context.Context, id string) (*Entry, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	entries, err := q.read()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.ID == id {
+			return entry, nil
+		}
+	}
+	return nil, ErrEntryNotFound
+}
+
+// Delete removes entries by ID
+func (q *FileQueue) Delete(ctx context.Context, ids ...string) error {
+	remove := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		remove[id] = true
+	}
+	_, err := q.rewrite(func(entry *Entry) bool { return remove[entry.ID] })
+	return err
+}
+
+// Purge removes the entries selected by the filter
+func (q *FileQueue) Purge(ctx context.Context, filter Filter) (int, error) {
+	purged := 0
+	return q.rewrite(func(entry *Entry) bool {
+		if !filter.Matches(entry) || (filter.Limit > 0 && purged == filter.Limit) {
+			return false
+		}
+		purged++
+		return true
+	})
+}
+
+// Close implements the Queue interface; the file is only open while in use
+func (q *FileQueue) Close() error {
+	return nil
+}
+
+// read decodes every entry of the file
+func (q *FileQueue) read() ([]*Entry, error) {
+	f, err := os.Open(q.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dead-letter file: %w", err)
+	}
+	defer f.Close()
+
+	var entries []*Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("failed to decode dead-letter entry at line %d: %w", line, err)
+		}
+		entries = append(entries, &entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read dead-letter file: %w", err)
+	}
+	return entries, nil
+}
+
+// rewrite replaces the file with the entries that are not removed, and
+// returns the number of removed entries
+func (q *FileQueue) rewrite(removed func(entry *Entry) bool) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	entries, err := q.read()
+	if err != nil {
+		return 0, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(q.path), filepath.Base(q.path)+".*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create dead-letter file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	count := 0
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	for _, entry := range entries {
+		if removed(entry) {
+			count++
+			continue
+		}
+		if err := encoder.Encode(entry); err != nil {
+			tmp.Close()
+			return 0, fmt.Errorf("failed to write dead-letter entry: %w", err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return 0, fmt.Errorf("failed to write dead-letter file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, fmt.Errorf("failed to write dead-letter file: %w", err)
+	}
+	if count == 0 {
+		return 0, nil
+	}
+	if err := os.Rename(tmp.Name(), q.path); err != nil {
+		return 0, fmt.Errorf("failed to replace dead-letter file: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,110 @@
+package dlq
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cohenjo/replicator/pkg/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestQueue(t *testing.T) *FileQueue {
+	queue, err := NewFileQueue(filepath.Join(t.TempDir(), "dlq", "entries.jsonl"))
+	require.NoError(t, err)
+	return queue
+}
+
+func putEntry(t *testing.T, queue Queue, stream string, stage Stage, createdAt time.Time) *Entry {
+	event := &events.RecordEvent{
+		Action:     "insert",
+		Schema:     "shop",
+		Collection: "orders",
+		Data:       []byte(`{"id":1}`),
+		Position:   map[string]interface{}{"offset": float64(42)},
+		Stream:     stream,
+	}
+	entry := NewEntry(stream, stage, errors.New("boom"), 3, event, map[string]interface{}{
+		"action": "insert",
+		"data":   []byte(`{"id":1}`),
+	})
+	entry.CreatedAt = createdAt
+	require.NoError(t, queue.Put(context.Background(), entry))
+	return entry
+}
+
+func TestFileQueueRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	queue := newTestQueue(t)
+	stored := putEntry(t, queue, "orders", StageWrite, time.Now())
+	require.NotEmpty(t, stored.ID)
+
+	entry, err := queue.Get(ctx, stored.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "orders", entry.Stream)
+	assert.Equal(t, StageWrite, entry.Stage)
+	assert.Equal(t, "boom", entry.Error)
+	assert.Equal(t, 3, entry.Attempts)
+	assert.Equal(t, float64(42), entry.Position["offset"])
+	assert.Equal(t, []byte(`{"id":1}`), entry.Event.Data)
+	assert.Equal(t, map[string]interface{}{"id": float64(1)}, entry.Payload["data"], "JSON bytes are stored as documents")
+
+	_, err = queue.Get(ctx, "missing")
+	assert.ErrorIs(t, err, ErrEntryNotFound)
+}
+
+func TestFileQueueListAndPurge(t *testing.T) {
+	ctx := context.Background()
+	queue := newTestQueue(t)
+	now := time.Now()
+	second := putEntry(t, queue, "orders", StageWrite, now.Add(time.Second))
+	first := putEntry(t, queue, "orders", StageTransform, now)
+	putEntry(t, queue, "users", StageWrite, now.Add(2*time.Second))
+
+	entries, err := queue.List(ctx, Filter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, first.ID, entries[0].ID, "oldest first")
+
+	entries, err = queue.List(ctx, Filter{Stream: "orders", Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, first.ID, entries[0].ID)
+
+	require.NoError(t, queue.Delete(ctx, second.ID, "missing"))
+	purged, err := queue.Purge(ctx, Filter{Stage: StageWrite})
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	entries, err = queue.List(ctx, Filter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, first.ID, entries[0].ID)
+}
+
+func TestReplay(t *testing.T) {
+	ctx := context.Background()
+	queue := newTestQueue(t)
+	ok := putEntry(t, queue, "orders", StageWrite, time.Now())
+	failing := putEntry(t, queue, "users", StageWrite, time.Now())
+
+	entries, err := queue.List(ctx, Filter{})
+	require.NoError(t, err)
+	result, err := Replay(ctx, queue, entries, func(ctx context.Context, entry *Entry) error {
+		if entry.Stream == "users" {
+			return errors.New("still failing")
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{ok.ID}, result.Replayed)
+	assert.Equal(t, map[string]string{failing.ID: "still failing"}, result.Failed)
+
+	remaining, err := queue.List(ctx, Filter{})
+	require.NoError(t, err)
+	require.Len(t, remaining, 1, "entries that fail again stay in the queue")
+	assert.Equal(t, failing.ID, remaining[0].ID)
+}
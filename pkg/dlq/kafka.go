@@ -0,0 +1,217 @@
+package dlq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// defaultKafkaTopic is used when the Kafka queue has no topic configured
+const defaultKafkaTopic = "replicator-dlq"
+
+// KafkaQueue stores the entries in a Kafka topic, keyed by entry ID. Deletes
+// produce tombstones, so the topic should use the compact cleanup policy;
+// listing reads the whole topic and keeps the latest value of every key.
+type KafkaQueue struct {
+	client   sarama.Client
+	producer sarama.SyncProducer
+	topic    string
+}
+
+// NewKafkaQueue creates a queue stored in the topic of the cluster
+func NewKafkaQueue(brokers []string, topic string) (*KafkaQueue, error) {
+	if len(brokers) == 0 {
+		return nil, fmt.Errorf("kafka dead-letter queue requires brokers")
+	}
+	if topic == "" {
+		topic = defaultKafkaTopic
+	}
+
+	cfg := sarama.NewConfig()
+	cfg.Producer.RequiredAcks = sarama.WaitForAll
+	cfg.Producer.Retry.Max = 10
+	cfg.Producer.Return.Successes = true
+	cfg.Producer.Partitioner = sarama.NewHashPartitioner // Same key, same partition
+	cfg.Consumer.Return.Errors = true
+
+	client, err := sarama.NewClient(brokers, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to kafka: %w", err)
+	}
+	producer, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create kafka producer: %w", err)
+	}
+	return &KafkaQueue{client: client, producer: producer, topic: topic}, nil
+}
+
+// Put produces an entry to the topic
+func (q *KafkaQueue) Put(ctx context.Context, entry *Entry) error {
+	prepare(entry)
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode dead-letter entry: %w", err)
+	}
+	_, _, err = q.producer.SendMessage(&sarama.ProducerMessage{
+		Topic: q.topic,
+		Key:   sarama.StringEncoder(entry.ID),
+		Value: sarama.ByteEncoder(value),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to produce dead-letter entry: %w", err)
+	}
+	return nil
+}
+
+// List returns the entries selected by the filter
+func (q *KafkaQueue) List(ctx context.Context, filter Filter) ([]*Entry, error) {
+	entries, err := q.readAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return selectEntries(entries, filter), nil
+}
+
+// Get returns an entry by ID
+func (q *KafkaQueue) Get(ctx context.Context, id string) (*Entry, error) {
+	entries, err := q.readAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.ID == id {
+			return entry, nil
+		}
+	}
+	return nil, ErrEntryNotFound
+}
+
+// Delete produces tombstones for the entries
+func (q *KafkaQueue) Delete(ctx context.Context, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	msgs := make([]*sarama.ProducerMessage, 0, len(ids))
+	for _, id := range ids {
+		msgs = append(msgs, &sarama.ProducerMessage{Topic: q.topic, Key: sarama.StringEncoder(id)})
+	}
+	if err := q.producer.SendMessages(msgs); err != nil {
+		return fmt.Errorf("failed to produce dead-letter tombstones: %w", err)
+	}
+	return nil
+}
+
+// Purge produces tombstones for the entries selected by the filter
+func (q *KafkaQueue) Purge(ctx context.Context, filter Filter) (int, error) {
+	entries, err := q.List(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		ids = append(ids, entry.ID)
+	}
+	if err := q.Delete(ctx, ids...); err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// Close closes the producer and the client
+func (q *KafkaQueue) Close() error {
+	if err := q.producer.Close(); err != nil {
+		return fmt.Errorf("failed to close kafka producer: %w", err)
+	}
+	if err := q.client.Close(); err != nil {
+		return fmt.Errorf("failed to close kafka client: %w", err)
+	}
+	return nil
+}
+
+// readAll reads every partition of the topic up to its current end
+func (q *KafkaQueue) readAll(ctx context.Context) ([]*Entry, error) {
+	consumer, err := sarama.NewConsumerFromClient(q.client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kafka consumer: %w", err)
+	}
+	defer consumer.Close()
+
+	partitions, err := q.client.Partitions(q.topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get partitions of %s: %w", q.topic, err)
+	}
+
+	latest := make(map[string]*Entry)
+	for _, partition := range partitions {
+		oldest, err := q.client.GetOffset(q.topic, partition, sarama.OffsetOldest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get offsets of %s/%d: %w", q.topic, partition, err)
+		}
+		newest, err := q.client.GetOffset(q.topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get offsets of %s/%d: %w", q.topic, partition, err)
+		}
+		if newest <= oldest {
+			continue
+		}
+		if err := q.readPartition(ctx, consumer, partition, oldest, newest, latest); err != nil {
+			return nil, err
+		}
+	}
+
+	entries := make([]*Entry, 0, len(latest))
+	for _, entry := range latest {
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// readPartition applies the messages of a partition between the offsets
+func (q *KafkaQueue) readPartition(ctx context.Context, consumer sarama.Consumer, partition int32, oldest, newest int64, latest map[string]*Entry) error {
+	pc, err := consumer.ConsumePartition(q.topic, partition, oldest)
+	if err != nil {
+		return fmt.Errorf("failed to consume %s/%d: %w", q.topic, partition, err)
+	}
+	defer pc.Close()
+
+	// Compacted partitions have gaps, so reading stops at the last offset
+	// or when no message arrives for a while
+	idle := time.NewTimer(10 * time.Second)
+	defer idle.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-idle.C:
+			return nil
+		case err := <-pc.Errors():
+			return fmt.Errorf("failed to read %s/%d: %w", q.topic, partition, err)
+		case msg := <-pc.Messages():
+			if err := applyMessage(latest, msg.Key, msg.Value); err != nil {
+				return err
+			}
+			if msg.Offset >= newest-1 {
+				return nil
+			}
+			idle.Reset(10 * time.Second)
+		}
+	}
+}
+
+// applyMessage keeps the latest value of a key, removing it on a tombstone
+func applyMessage(latest map[string]*Entry, key, value []byte) error {
+	if value == nil {
+		delete(latest, string(key))
+		return nil
+	}
+	var entry Entry
+	if err := json.Unmarshal(value, &entry); err != nil {
+		return fmt.Errorf("failed to decode dead-letter entry %s: %w", key, err)
+	}
+	latest[string(key)] = &entry
+	return nil
+}
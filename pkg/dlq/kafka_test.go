@@ -0,0 +1,31 @@
+package dlq
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyMessageKeepsLatestValue(t *testing.T) {
+	latest := make(map[string]*Entry)
+	first, err := json.Marshal(&Entry{ID: "a", Stream: "orders", Attempts: 1})
+	require.NoError(t, err)
+	second, err := json.Marshal(&Entry{ID: "a", Stream: "orders", Attempts: 2})
+	require.NoError(t, err)
+	other, err := json.Marshal(&Entry{ID: "b", Stream: "users"})
+	require.NoError(t, err)
+
+	require.NoError(t, applyMessage(latest, []byte("a"), first))
+	require.NoError(t, applyMessage(latest, []byte("b"), other))
+	require.NoError(t, applyMessage(latest, []byte("a"), second))
+	require.Len(t, latest, 2)
+	assert.Equal(t, 2, latest["a"].Attempts)
+
+	// A tombstone removes the entry
+	require.NoError(t, applyMessage(latest, []byte("b"), nil))
+	assert.NotContains(t, latest, "b")
+
+	assert.Error(t, applyMessage(latest, []byte("c"), []byte("not json")))
+}
@@ -0,0 +1,188 @@
+package dlq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cohenjo/replicator/pkg/auth"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Defaults for the Mongo queue
+const (
+	defaultMongoDatabase   = "replicator"
+	defaultMongoCollection = "dead_letters"
+)
+
+// mongoEntry is the document of an entry. The fields that entries are
+// selected by are stored as fields, the entry itself as JSON so the event
+// and payload come back exactly as they were stored.
+type mongoEntry struct {
+	ID        string    `bson:"_id"`
+	Stream    string    `bson:"stream"`
+	Stage     string    `bson:"stage"`
+	CreatedAt time.Time `bson:"created_at"`
+	Entry     string    `bson:"entry"`
+}
+
+// MongoQueue stores the entries in a MongoDB collection
+type MongoQueue struct {
+	client     *mongo.Client
+	collection *mongo.Collection
+}
+
+// NewMongoQueue creates a queue stored in the collection of the database
+func NewMongoQueue(uri, database, collection string) (*MongoQueue, error) {
+	if uri == "" {
+		return nil, fmt.Errorf("mongodb dead-letter queue requires a uri")
+	}
+	if database == "" {
+		database = defaultMongoDatabase
+	}
+	if collection == "" {
+		collection = defaultMongoCollection
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	client, err := auth.NewMongoClientWithAuth(ctx, &auth.MongoAuthConfig{
+		ConnectionURI: uri,
+		AuthMethod:    "connection_string",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to mongodb: %w", err)
+	}
+	return &MongoQueue{
+		client:     client,
+		collection: client.Database(database).Collection(collection),
+	}, nil
+}
+
+// Put inserts an entry into the collection
+func (q *MongoQueue) Put(ctx context.Context, entry *Entry) error {
+	prepare(entry)
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode dead-letter entry: %w", err)
+	}
+	_, err = q.collection.InsertOne(ctx, mongoEntry{
+		ID:        entry.ID,
+		Stream:    entry.Stream,
+		Stage:     string(entry.Stage),
+		CreatedAt: entry.CreatedAt,
+		Entry:     string(body),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to insert dead-letter entry: %w", err)
+	}
+	return nil
+}
+
+// List returns the entries selected by the filter
+func (q *MongoQueue) List(ctx context.Context, filter Filter) ([]*Entry, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	if filter.Limit > 0 {
+		opts.SetLimit(int64(filter.Limit))
+	}
+	cursor, err := q.collection.Find(ctx, mongoFilter(filter), opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dead-letter entries: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	entries := make([]*Entry, 0)
+	for cursor.Next(ctx) {
+		var doc mongoEntry
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("failed to decode dead-letter entry: %w", err)
+		}
+		entry, err := doc.decode()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list dead-letter entries: %w", err)
+	}
+	return entries, nil
+}
+
+// Get returns an entry by ID
+func (q *MongoQueue) Get(ctx context.Context, id string) (*Entry, error) {
+	var doc mongoEntry
+	err := q.collection.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrEntryNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dead-letter entry: %w", err)
+	}
+	return doc.decode()
+}
+
+// Delete removes entries by ID
+func (q *MongoQueue) Delete(ctx context.Context, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := q.collection.DeleteMany(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}})
+	if err != nil {
+		return fmt.Errorf("failed to delete dead-letter entries: %w", err)
+	}
+	return nil
+}
+
+// Purge removes the entries selected by the filter
+func (q *MongoQueue) Purge(ctx context.Context, filter Filter) (int, error) {
+	if filter.Limit > 0 {
+		// DeleteMany has no limit, so the oldest entries are selected first
+		entries, err := q.List(ctx, filter)
+		if err != nil {
+			return 0, err
+		}
+		ids := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			ids = append(ids, entry.ID)
+		}
+		return len(ids), q.Delete(ctx, ids...)
+	}
+
+	result, err := q.collection.DeleteMany(ctx, mongoFilter(filter))
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge dead-letter entries: %w", err)
+	}
+	return int(result.DeletedCount), nil
+}
+
+// Close disconnects the client
+func (q *MongoQueue) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return q.client.Disconnect(ctx)
+}
+
+// mongoFilter translates the filter to a query
+func mongoFilter(filter Filter) bson.D {
+	query := bson.D{}
+	if filter.Stream != "" {
+		query = append(query, bson.E{Key: "stream", Value: filter.Stream})
+	}
+	if filter.Stage != "" {
+		query = append(query, bson.E{Key: "stage", Value: string(filter.Stage)})
+	}
+	return query
+}
+
+func (doc mongoEntry) decode() (*Entry, error) {
+	var entry Entry
+	if err := json.Unmarshal([]byte(doc.Entry), &entry); err != nil {
+		return nil, fmt.Errorf("failed to decode dead-letter entry %s: %w", doc.ID, err)
+	}
+	return &entry, nil
+}
@@ -0,0 +1,518 @@
+package estuary
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+	"github.com/cohenjo/replicator/pkg/auth"
+	"github.com/cohenjo/replicator/pkg/config"
+	"github.com/cohenjo/replicator/pkg/events"
+)
+
+const (
+	// cosmosMaxBatchOperations is the operation limit of a transactional batch
+	cosmosMaxBatchOperations = 100
+
+	// cosmosDefaultThrottleDelay is used when a throttled response carries no retry-after
+	cosmosDefaultThrottleDelay = 100 * time.Millisecond
+
+	cosmosDestination = string(config.TargetTypeCosmosDB)
+)
+
+// cosmosOperation is a single item write against a Cosmos DB container
+type cosmosOperation struct {
+	delete       bool
+	id           string
+	partitionKey azcosmos.PartitionKey
+	logicalKey   string // identifies the logical partition, for grouping
+	item         []byte
+}
+
+// cosmosContainer writes items to a container. Operations in a batch share a logical partition.
+type cosmosContainer interface {
+	upsert(ctx context.Context, op *cosmosOperation) error
+	delete(ctx context.Context, op *cosmosOperation) error
+	executeBatch(ctx context.Context, ops []*cosmosOperation) error
+}
+
+// cosmosBatchError reports the operation that failed a transactional batch
+type cosmosBatchError struct {
+	StatusCode int
+	Index      int
+}
+
+// Error implements the error interface
+func (e *cosmosBatchError) Error() string {
+	return fmt.Sprintf("cosmos transactional batch failed at operation %d with status %d", e.Index, e.StatusCode)
+}
+
+// CosmosEndpoint writes records to a Cosmos DB container. Inserts and updates
+// are upserts, deletes remove the item by id and partition key.
+type CosmosEndpoint struct {
+	container          cosmosContainer
+	partitionKeyPaths  []string
+	idField            string
+	maxConcurrency     int
+	maxThrottleRetries int
+	maxThrottleWait    time.Duration
+}
+
+// NewCosmosEndpoint creates a Cosmos DB endpoint. The partition key paths are
+// read from the container definition unless configured.
+func NewCosmosEndpoint(streamConfig *config.WaterFlowsConfig) (*CosmosEndpoint, error) {
+	endpointURL, key := streamConfig.CosmosEndpoint, streamConfig.CosmosAccountKey
+	if strings.Contains(endpointURL, "AccountEndpoint=") {
+		var connKey string
+		endpointURL, connKey = auth.ParseCosmosConnectionString(endpointURL)
+		if key == "" {
+			key = connKey
+		}
+	}
+	if endpointURL == "" || streamConfig.CosmosDatabaseName == "" || streamConfig.CosmosContainerName == "" {
+		return nil, fmt.Errorf("cosmos DB endpoint, database and container are required")
+	}
+
+	clientOptions := &azcosmos.ClientOptions{}
+	// Throttled requests are retried by the endpoint, which honours the configured limits
+	clientOptions.Retry.StatusCodes = []int{
+		http.StatusRequestTimeout, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout,
+	}
+	if streamConfig.CosmosInsecureSkipVerify {
+		clientOptions.Transport = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	}
+
+	var client *azcosmos.Client
+	var err error
+	if key != "" && (streamConfig.CosmosAuthMethod == "" || streamConfig.CosmosAuthMethod == "key") {
+		keyCred, credErr := azcosmos.NewKeyCredential(key)
+		if credErr != nil {
+			return nil, fmt.Errorf("failed to create key credential: %w", credErr)
+		}
+		client, err = azcosmos.NewClientWithKey(endpointURL, keyCred, clientOptions)
+	} else {
+		cred, credErr := auth.NewAzureCredential(streamConfig.CosmosAuthMethod, streamConfig.CosmosTenantID,
+			streamConfig.CosmosClientID, streamConfig.CosmosClientSecret)
+		if credErr != nil {
+			return nil, fmt.Errorf("failed to create Azure credential: %w", credErr)
+		}
+		client, err = azcosmos.NewClient(endpointURL, cred, clientOptions)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Cosmos DB client: %w", err)
+	}
+
+	containerClient, err := client.NewContainer(streamConfig.CosmosDatabaseName, streamConfig.CosmosContainerName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create container client: %w", err)
+	}
+
+	paths := streamConfig.CosmosPartitionKeyPaths
+	if len(paths) == 0 {
+		resp, err := containerClient.Read(context.Background(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read container properties: %w", err)
+		}
+		paths = resp.ContainerProperties.PartitionKeyDefinition.Paths
+	}
+
+	endpoint := &CosmosEndpoint{
+		container:          &azureCosmosContainer{client: containerClient},
+		partitionKeyPaths:  paths,
+		idField:            streamConfig.CosmosIDField,
+		maxConcurrency:     streamConfig.CosmosMaxConcurrency,
+		maxThrottleRetries: 3,
+		maxThrottleWait:    30 * time.Second,
+	}
+	if config.Global != nil {
+		endpoint.maxThrottleRetries = config.Global.Azure.CosmosDB.MaxRetryAttemptsOnThrottledRequests
+		endpoint.maxThrottleWait = time.Duration(config.Global.Azure.CosmosDB.MaxRetryWaitTimeInSeconds) * time.Second
+	}
+	if endpoint.idField == "" {
+		endpoint.idField = "id"
+	}
+	if endpoint.maxConcurrency <= 0 {
+		endpoint.maxConcurrency = 4
+	}
+
+	logger.Info().
+		Str("database", streamConfig.CosmosDatabaseName).
+		Str("container", streamConfig.CosmosContainerName).
+		Strs("partition_key_paths", paths).
+		Msg("Created Cosmos DB endpoint")
+	return endpoint, nil
+}
+
+// Write writes a single record to the container
+func (e *CosmosEndpoint) Write(ctx context.Context, record *events.RecordEvent) error {
+	op, err := e.buildOperation(record)
+	if err != nil {
+		return NewRecordError(cosmosDestination, record.Collection, "failed to build Cosmos DB operation", err)
+	}
+
+	if err := e.apply(ctx, op); err != nil {
+		return classifyCosmosError(fmt.Errorf("failed to write Cosmos DB item %s: %w", op.id, err))
+	}
+	recordsSent.Inc()
+	return nil
+}
+
+// WriteBatch writes records with one transactional batch per logical partition
+// and chunk. Records of a logical partition are applied in order; partitions
+// are written in parallel.
+func (e *CosmosEndpoint) WriteBatch(ctx context.Context, records []*events.RecordEvent) error {
+	var order []string
+	groups := make(map[string][]*cosmosOperation)
+	for _, record := range records {
+		op, err := e.buildOperation(record)
+		if err != nil {
+			return NewRecordError(cosmosDestination, record.Collection, "failed to build Cosmos DB operation", err)
+		}
+		if _, ok := groups[op.logicalKey]; !ok {
+			order = append(order, op.logicalKey)
+		}
+		groups[op.logicalKey] = append(groups[op.logicalKey], op)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	sem := make(chan struct{}, e.maxConcurrency)
+
+	for _, logicalKey := range order {
+		ops := groups[logicalKey]
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := e.writePartition(ctx, ops); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return classifyCosmosError(firstErr)
+	}
+	recordsSent.Add(float64(len(records)))
+	return nil
+}
+
+// writePartition writes the operations of one logical partition in chunks
+func (e *CosmosEndpoint) writePartition(ctx context.Context, ops []*cosmosOperation) error {
+	for start := 0; start < len(ops); start += cosmosMaxBatchOperations {
+		end := start + cosmosMaxBatchOperations
+		if end > len(ops) {
+			end = len(ops)
+		}
+		chunk := ops[start:end]
+
+		if len(chunk) == 1 {
+			if err := e.apply(ctx, chunk[0]); err != nil {
+				return err
+			}
+			continue
+		}
+
+		err := e.retryThrottled(ctx, func() error {
+			return e.container.executeBatch(ctx, chunk)
+		})
+		var batchErr *cosmosBatchError
+		if errors.As(err, &batchErr) && batchErr.StatusCode == http.StatusNotFound {
+			// A delete of a missing item fails the whole batch; apply the chunk item by item instead
+			logger.Debug().Int("operations", len(chunk)).Msg("Cosmos DB batch hit a missing item, applying operations individually")
+			for _, op := range chunk {
+				if err := e.apply(ctx, op); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write Cosmos DB batch: %w", err)
+		}
+	}
+	return nil
+}
+
+// apply executes a single operation; deleting an item that does not exist is not an error
+func (e *CosmosEndpoint) apply(ctx context.Context, op *cosmosOperation) error {
+	return e.retryThrottled(ctx, func() error {
+		if !op.delete {
+			return e.container.upsert(ctx, op)
+		}
+		err := e.container.delete(ctx, op)
+		var respErr *azcore.ResponseError
+		if errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		return err
+	})
+}
+
+// retryThrottled runs fn, retrying throttled (429) attempts after the delay the service asks for
+func (e *CosmosEndpoint) retryThrottled(ctx context.Context, fn func() error) error {
+	var waited time.Duration
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		delay, throttled := cosmosThrottleDelay(err, attempt)
+		if !throttled || attempt >= e.maxThrottleRetries {
+			return err
+		}
+		if e.maxThrottleWait > 0 && waited+delay > e.maxThrottleWait {
+			return err
+		}
+
+		logger.Debug().Dur("retry_after", delay).Int("attempt", attempt+1).Msg("Cosmos DB request throttled")
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		waited += delay
+	}
+}
+
+// cosmosThrottleDelay reports whether err is a throttled response and how long to wait
+func cosmosThrottleDelay(err error, attempt int) (time.Duration, bool) {
+	if err == nil {
+		return 0, false
+	}
+
+	var delay time.Duration
+	var respErr *azcore.ResponseError
+	var batchErr *cosmosBatchError
+	switch {
+	case errors.As(err, &respErr) && respErr.StatusCode == http.StatusTooManyRequests:
+		if respErr.RawResponse != nil {
+			if ms, err := strconv.Atoi(respErr.RawResponse.Header.Get("x-ms-retry-after-ms")); err == nil {
+				delay = time.Duration(ms) * time.Millisecond
+			}
+		}
+	case errors.As(err, &batchErr) && batchErr.StatusCode == http.StatusTooManyRequests:
+	default:
+		return 0, false
+	}
+
+	if delay <= 0 {
+		delay = cosmosDefaultThrottleDelay << attempt
+	}
+	return delay, true
+}
+
+// classifyCosmosError classifies a failed write by the status of the response
+// or of the failed batch operation. Gone (410) and retry-with (449) are
+// transient conditions of a partition that the service resolves itself.
+func classifyCosmosError(err error) error {
+	status := 0
+	var respErr *azcore.ResponseError
+	var batchErr *cosmosBatchError
+	switch {
+	case errors.As(err, &respErr):
+		status = respErr.StatusCode
+	case errors.As(err, &batchErr):
+		status = batchErr.StatusCode
+	default:
+		return classifyError(cosmosDestination, "", err)
+	}
+
+	switch status {
+	case http.StatusGone, 449, http.StatusServiceUnavailable, http.StatusInternalServerError:
+		return NewWriteError(cosmosDestination, "", "write failed", err)
+	case http.StatusConflict, http.StatusPreconditionFailed, http.StatusRequestEntityTooLarge:
+		return NewRecordError(cosmosDestination, "", "write rejected", err)
+	}
+	return classifyHTTPStatus(cosmosDestination, "", status, err)
+}
+
+// buildOperation maps a record to an upsert or a delete of a Cosmos DB item
+func (e *CosmosEndpoint) buildOperation(record *events.RecordEvent) (*cosmosOperation, error) {
+	op := &cosmosOperation{delete: record.Action == events.DeleteAction}
+
+	var document map[string]interface{}
+	var err error
+	if op.delete {
+		// The partition key of a deleted item comes from its last known state
+		for _, source := range [][]byte{record.OldData, record.Data, record.DocumentKey} {
+			if len(source) > 0 {
+				if document, err = decodeDocument(source); err != nil {
+					return nil, err
+				}
+				break
+			}
+		}
+	} else {
+		if len(record.Data) == 0 {
+			return nil, fmt.Errorf("%s record has no data", record.Action)
+		}
+		if document, err = decodeDocument(record.Data); err != nil {
+			return nil, err
+		}
+	}
+	if document == nil {
+		return nil, fmt.Errorf("%s record has no data or document key", record.Action)
+	}
+
+	if op.id, err = e.itemID(document, record.DocumentKey); err != nil {
+		return nil, err
+	}
+
+	pk := azcosmos.NewPartitionKey()
+	values := make([]interface{}, 0, len(e.partitionKeyPaths))
+	for _, path := range e.partitionKeyPaths {
+		value, ok := cosmosPathValue(document, path)
+		if !ok && strings.TrimPrefix(path, "/") == "id" {
+			value, ok = op.id, true
+		}
+		if !ok {
+			logger.Debug().Str("path", path).Str("id", op.id).Msg("Partition key value missing, using null")
+			value = nil
+		}
+		pk = appendPartitionKey(pk, value)
+		values = append(values, value)
+	}
+	op.partitionKey = pk
+	logicalKey, _ := json.Marshal(values)
+	op.logicalKey = string(logicalKey)
+
+	if !op.delete {
+		document["id"] = op.id
+		if op.item, err = json.Marshal(document); err != nil {
+			return nil, fmt.Errorf("failed to encode item: %w", err)
+		}
+	}
+	return op, nil
+}
+
+// itemID returns the item id: the configured id field, else the document key
+func (e *CosmosEndpoint) itemID(document map[string]interface{}, documentKey []byte) (string, error) {
+	if value, ok := cosmosPathValue(document, e.idField); ok && value != nil {
+		return documentIDString(value), nil
+	}
+
+	if len(documentKey) > 0 {
+		key, err := decodeDocument(documentKey)
+		if err != nil {
+			return "", fmt.Errorf("failed to decode document key: %w", err)
+		}
+		if value, ok := key["id"]; ok {
+			return documentIDString(value), nil
+		}
+		if value, ok := key["_id"]; ok {
+			return documentIDString(value), nil
+		}
+		if len(key) == 1 {
+			for _, value := range key {
+				return documentIDString(value), nil
+			}
+		}
+		// Composite keys become a stable JSON id
+		encoded, _ := json.Marshal(key)
+		return string(encoded), nil
+	}
+
+	if value, ok := document["_id"]; ok {
+		return documentIDString(value), nil
+	}
+	return "", fmt.Errorf("record has no %s field and no document key", e.idField)
+}
+
+// cosmosPathValue reads the value at a partition key path such as /tenant/id
+func cosmosPathValue(document map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = document
+	for _, part := range strings.Split(strings.TrimPrefix(path, "/"), "/") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// appendPartitionKey appends a document value to a (hierarchical) partition key
+func appendPartitionKey(pk azcosmos.PartitionKey, value interface{}) azcosmos.PartitionKey {
+	switch v := value.(type) {
+	case nil:
+		return pk.AppendNull()
+	case string:
+		return pk.AppendString(v)
+	case bool:
+		return pk.AppendBool(v)
+	case json.Number:
+		if f, err := v.Float64(); err == nil {
+			return pk.AppendNumber(f)
+		}
+		return pk.AppendString(v.String())
+	case float64:
+		return pk.AppendNumber(v)
+	default:
+		return pk.AppendString(documentIDString(v))
+	}
+}
+
+// azureCosmosContainer implements cosmosContainer with the Cosmos DB SDK
+type azureCosmosContainer struct {
+	client *azcosmos.ContainerClient
+}
+
+func (c *azureCosmosContainer) upsert(ctx context.Context, op *cosmosOperation) error {
+	_, err := c.client.UpsertItem(ctx, op.partitionKey, op.item, nil)
+	return err
+}
+
+func (c *azureCosmosContainer) delete(ctx context.Context, op *cosmosOperation) error {
+	_, err := c.client.DeleteItem(ctx, op.partitionKey, op.id, nil)
+	return err
+}
+
+func (c *azureCosmosContainer) executeBatch(ctx context.Context, ops []*cosmosOperation) error {
+	batch := c.client.NewTransactionalBatch(ops[0].partitionKey)
+	for _, op := range ops {
+		if op.delete {
+			batch.DeleteItem(op.id, nil)
+		} else {
+			batch.UpsertItem(op.item, nil)
+		}
+	}
+
+	resp, err := c.client.ExecuteTransactionalBatch(ctx, batch, nil)
+	if err != nil {
+		return err
+	}
+	if resp.Success {
+		return nil
+	}
+	// The cause is the first operation that did not fail as a dependency of another
+	for i, result := range resp.OperationResults {
+		if result.StatusCode != http.StatusFailedDependency {
+			return &cosmosBatchError{StatusCode: int(result.StatusCode), Index: i}
+		}
+	}
+	return &cosmosBatchError{StatusCode: http.StatusMultiStatus}
+}
+
+// Close implements the endpoint close hook; the SDK client holds no connections to release
+func (e *CosmosEndpoint) Close() error {
+	return nil
+}
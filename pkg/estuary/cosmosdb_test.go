@@ -0,0 +1,205 @@
+package estuary
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/cohenjo/replicator/pkg/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCosmosContainer records the operations written to it
+type fakeCosmosContainer struct {
+	mu       sync.Mutex
+	points   []*cosmosOperation
+	batches  [][]*cosmosOperation
+	failures []error // returned by the next calls, in order
+}
+
+func (f *fakeCosmosContainer) next() error {
+	if len(f.failures) == 0 {
+		return nil
+	}
+	err := f.failures[0]
+	f.failures = f.failures[1:]
+	return err
+}
+
+func (f *fakeCosmosContainer) upsert(ctx context.Context, op *cosmosOperation) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.next(); err != nil {
+		return err
+	}
+	f.points = append(f.points, op)
+	return nil
+}
+
+func (f *fakeCosmosContainer) delete(ctx context.Context, op *cosmosOperation) error {
+	return f.upsert(ctx, op)
+}
+
+func (f *fakeCosmosContainer) executeBatch(ctx context.Context, ops []*cosmosOperation) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.next(); err != nil {
+		return err
+	}
+	f.batches = append(f.batches, ops)
+	return nil
+}
+
+func throttled(retryAfterMs string) error {
+	resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+	resp.Header.Set("x-ms-retry-after-ms", retryAfterMs)
+	return &azcore.ResponseError{StatusCode: http.StatusTooManyRequests, RawResponse: resp}
+}
+
+func newTestCosmosEndpoint(container cosmosContainer, paths ...string) *CosmosEndpoint {
+	return &CosmosEndpoint{
+		container:          container,
+		partitionKeyPaths:  paths,
+		idField:            "id",
+		maxConcurrency:     2,
+		maxThrottleRetries: 3,
+		maxThrottleWait:    time.Second,
+	}
+}
+
+func TestCosmosEndpoint_BuildOperation(t *testing.T) {
+	endpoint := newTestCosmosEndpoint(nil, "/tenant/id", "/region")
+
+	op, err := endpoint.buildOperation(&events.RecordEvent{
+		Action:      events.InsertAction,
+		DocumentKey: []byte(`{"_id":{"$oid":"64b7f0c2a1b2c3d4e5f60718"}}`),
+		Data:        []byte(`{"_id":{"$oid":"64b7f0c2a1b2c3d4e5f60718"},"tenant":{"id":42},"region":"eu","qty":12345678901234}`),
+	})
+	require.NoError(t, err)
+	assert.False(t, op.delete)
+	// Mongo keys become the item id
+	assert.Equal(t, "64b7f0c2a1b2c3d4e5f60718", op.id)
+	assert.Equal(t, `[42,"eu"]`, op.logicalKey)
+	assert.JSONEq(t, `{"_id":{"$oid":"64b7f0c2a1b2c3d4e5f60718"},"id":"64b7f0c2a1b2c3d4e5f60718","tenant":{"id":42},"region":"eu","qty":12345678901234}`, string(op.item))
+
+	// Deletes take the partition key from the old data
+	op, err = endpoint.buildOperation(&events.RecordEvent{
+		Action:      events.DeleteAction,
+		DocumentKey: []byte(`{"id":"order-1"}`),
+		OldData:     []byte(`{"id":"order-1","tenant":{"id":7},"region":"us"}`),
+	})
+	require.NoError(t, err)
+	assert.True(t, op.delete)
+	assert.Equal(t, "order-1", op.id)
+	assert.Equal(t, `[7,"us"]`, op.logicalKey)
+	assert.Nil(t, op.item)
+
+	// Missing partition key values are null
+	op, err = endpoint.buildOperation(&events.RecordEvent{Action: events.DeleteAction, DocumentKey: []byte(`{"id":"order-2"}`)})
+	require.NoError(t, err)
+	assert.Equal(t, `[null,null]`, op.logicalKey)
+
+	_, err = endpoint.buildOperation(&events.RecordEvent{Action: events.UpdateAction})
+	assert.Error(t, err)
+	_, err = endpoint.buildOperation(&events.RecordEvent{Action: events.InsertAction, Data: []byte(`{"name":"no key"}`)})
+	assert.Error(t, err)
+}
+
+func TestCosmosEndpoint_WriteBatchGroupsByLogicalPartition(t *testing.T) {
+	container := &fakeCosmosContainer{}
+	endpoint := newTestCosmosEndpoint(container, "/tenant")
+
+	var records []*events.RecordEvent
+	for i := 0; i < 150; i++ {
+		records = append(records, &events.RecordEvent{Action: events.InsertAction, Data: []byte(`{"id":"a` + string(rune('0'+i%10)) + `","tenant":"a"}`)})
+	}
+	records = append(records, &events.RecordEvent{Action: events.UpdateAction, Data: []byte(`{"id":"b1","tenant":"b"}`)})
+
+	require.NoError(t, endpoint.WriteBatch(context.Background(), records))
+
+	// Tenant a is split into a full transactional batch and a remainder; tenant b is a point write
+	require.Len(t, container.batches, 2)
+	sizes := []int{len(container.batches[0]), len(container.batches[1])}
+	assert.ElementsMatch(t, []int{100, 50}, sizes)
+	require.Len(t, container.points, 1)
+	assert.Equal(t, "b1", container.points[0].id)
+}
+
+func TestCosmosEndpoint_RetriesThrottledRequests(t *testing.T) {
+	container := &fakeCosmosContainer{failures: []error{throttled("5"), throttled("5")}}
+	endpoint := newTestCosmosEndpoint(container, "/id")
+
+	require.NoError(t, endpoint.WriteBatch(context.Background(), []*events.RecordEvent{
+		{Action: events.InsertAction, Data: []byte(`{"id":"1"}`)},
+	}))
+	assert.Len(t, container.points, 1)
+
+	// Retries stop at the configured attempt limit
+	container = &fakeCosmosContainer{failures: []error{throttled("1"), throttled("1"), throttled("1"), throttled("1")}}
+	endpoint = newTestCosmosEndpoint(container, "/id")
+	err := endpoint.WriteBatch(context.Background(), []*events.RecordEvent{{Action: events.InsertAction, Data: []byte(`{"id":"1"}`)}})
+	assert.Error(t, err)
+	assert.True(t, IsRetryable(err), "throttled writes may be retried later")
+	assert.Empty(t, container.points)
+
+	// And at the configured total wait
+	container = &fakeCosmosContainer{failures: []error{throttled("2000")}}
+	endpoint = newTestCosmosEndpoint(container, "/id")
+	err = endpoint.WriteBatch(context.Background(), []*events.RecordEvent{{Action: events.InsertAction, Data: []byte(`{"id":"1"}`)}})
+	assert.Error(t, err)
+
+	delay, ok := cosmosThrottleDelay(&cosmosBatchError{StatusCode: http.StatusTooManyRequests}, 2)
+	assert.True(t, ok)
+	assert.Equal(t, 4*cosmosDefaultThrottleDelay, delay)
+}
+
+func TestCosmosEndpoint_MissingItems(t *testing.T) {
+	notFound := &azcore.ResponseError{StatusCode: http.StatusNotFound}
+
+	// Deleting a missing item is not an error
+	container := &fakeCosmosContainer{failures: []error{notFound}}
+	endpoint := newTestCosmosEndpoint(container, "/id")
+	require.NoError(t, endpoint.apply(context.Background(), &cosmosOperation{delete: true, id: "gone"}))
+
+	// A batch that fails on a missing item is applied item by item
+	container = &fakeCosmosContainer{failures: []error{&cosmosBatchError{StatusCode: http.StatusNotFound, Index: 1}}}
+	endpoint = newTestCosmosEndpoint(container, "/tenant")
+	require.NoError(t, endpoint.WriteBatch(context.Background(), []*events.RecordEvent{
+		{Action: events.InsertAction, Data: []byte(`{"id":"1","tenant":"a"}`)},
+		{Action: events.DeleteAction, OldData: []byte(`{"id":"2","tenant":"a"}`)},
+	}))
+	assert.Empty(t, container.batches)
+	assert.Len(t, container.points, 2)
+}
+
+func TestClassifyCosmosError(t *testing.T) {
+	tests := []struct {
+		err       error
+		code      string
+		retryable bool
+	}{
+		{&azcore.ResponseError{StatusCode: http.StatusTooManyRequests}, ErrCodeThrottled, true},
+		{&azcore.ResponseError{StatusCode: http.StatusGone}, ErrCodeWriteFailed, true},
+		{&azcore.ResponseError{StatusCode: 449}, ErrCodeWriteFailed, true},
+		{&azcore.ResponseError{StatusCode: http.StatusRequestTimeout}, ErrCodeTimeout, true},
+		{&cosmosBatchError{StatusCode: http.StatusRequestEntityTooLarge}, ErrCodeInvalidRecord, false},
+		{&azcore.ResponseError{StatusCode: http.StatusBadRequest}, ErrCodeInvalidRecord, false},
+		{&azcore.ResponseError{StatusCode: http.StatusUnauthorized}, ErrCodeInvalidConfig, false},
+	}
+	for _, tt := range tests {
+		err := classifyCosmosError(tt.err)
+		var destErr *DestinationError
+		require.ErrorAs(t, err, &destErr)
+		assert.Equal(t, tt.code, destErr.Code, tt.err.Error())
+		assert.Equal(t, tt.retryable, IsRetryable(err), tt.err.Error())
+	}
+
+	// Records without an id are rejected
+	endpoint := newTestCosmosEndpoint(&fakeCosmosContainer{}, "/id")
+	err := endpoint.Write(context.Background(), &events.RecordEvent{Action: events.InsertAction, Data: []byte(`{"qty":1}`)})
+	assert.False(t, IsRetryable(err))
+}
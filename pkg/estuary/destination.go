@@ -0,0 +1,599 @@
+package estuary
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cohenjo/replicator/pkg/config"
+	"github.com/cohenjo/replicator/pkg/events"
+)
+
+// Destination health states
+const (
+	HealthHealthy   = "HEALTHY"
+	HealthDegraded  = "DEGRADED"
+	HealthUnhealthy = "UNHEALTHY"
+)
+
+// Record operations of a DestinationRecord
+const (
+	OperationInsert = "INSERT"
+	OperationUpdate = "UPDATE"
+	OperationDelete = "DELETE"
+	OperationUpsert = "UPSERT"
+)
+
+// endpointPinger is implemented by endpoints that can check their connection
+type endpointPinger interface {
+	Ping(ctx context.Context) error
+}
+
+// endpointTransactor is implemented by endpoints whose batch writes are not
+// atomic and that can write a batch in a transaction
+type endpointTransactor interface {
+	WriteTransaction(ctx context.Context, records []*events.RecordEvent) error
+}
+
+// endpointCatalog is implemented by endpoints that manage their tables:
+// collections, indices or topics depending on the target
+type endpointCatalog interface {
+	ListTables(ctx context.Context) ([]string, error)
+	GetSchema(ctx context.Context, tableName string) (*TableSchema, error)
+	CreateTable(ctx context.Context, schema TableSchema) error
+	DropTable(ctx context.Context, tableName string) error
+	TruncateTable(ctx context.Context, tableName string) error
+}
+
+// endpointSchemaUpdater is implemented by endpoints that can add columns to a table
+type endpointSchemaUpdater interface {
+	UpdateSchema(ctx context.Context, tableName string, schema TableSchema) error
+}
+
+// EndpointOpener creates the endpoint of a destination
+type EndpointOpener func(streamConfig *config.WaterFlowsConfig) (Endpoint, error)
+
+// EndpointDestination implements DatabaseDestination on top of an Endpoint.
+// Writes go through the endpoint; schema operations are available when the
+// endpoint of the target supports them.
+type EndpointDestination struct {
+	targetConfig config.TargetConfig
+	open         EndpointOpener
+
+	mu       sync.RWMutex
+	endpoint Endpoint
+	txnSeq   atomic.Uint64
+
+	metricsMu sync.Mutex
+	metrics   DestinationMetrics
+	started   time.Time
+	lastWrite time.Time // of the last successful write
+}
+
+// NewEndpointDestination creates a destination whose endpoint is opened on Connect
+func NewEndpointDestination(targetConfig config.TargetConfig, open EndpointOpener) *EndpointDestination {
+	return &EndpointDestination{targetConfig: targetConfig, open: open}
+}
+
+// Connect opens the endpoint, unless it is open already
+func (d *EndpointDestination) Connect(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.endpoint != nil {
+		return nil
+	}
+
+	streamConfig, err := legacyTargetConfig(d.targetConfig)
+	if err != nil {
+		return newDestinationError(ErrCodeInvalidConfig, d.name(), "", "invalid target configuration", err)
+	}
+	start := time.Now()
+	endpoint, err := d.open(streamConfig)
+	d.recordConnect(time.Since(start), err)
+	if err != nil {
+		return NewConnectionError(d.name(), "failed to open endpoint", err)
+	}
+	d.endpoint = endpoint
+	return nil
+}
+
+// Disconnect closes the endpoint
+func (d *EndpointDestination) Disconnect(ctx context.Context) error {
+	d.mu.Lock()
+	endpoint := d.endpoint
+	d.endpoint = nil
+	d.mu.Unlock()
+
+	if closer, ok := endpoint.(interface{ Close() error }); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// IsConnected reports whether the endpoint is open
+func (d *EndpointDestination) IsConnected() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.endpoint != nil
+}
+
+// Ping checks the connection of the endpoint
+func (d *EndpointDestination) Ping(ctx context.Context) error {
+	endpoint, err := d.connected()
+	if err != nil {
+		return err
+	}
+	if pinger, ok := endpoint.(endpointPinger); ok {
+		if err := pinger.Ping(ctx); err != nil {
+			return classifyError(d.name(), "", err)
+		}
+	}
+	return nil
+}
+
+// Write inserts a JSON document into a table
+func (d *EndpointDestination) Write(ctx context.Context, data []byte, table string) error {
+	document, err := decodeDocument(data)
+	if err != nil {
+		return NewRecordError(d.name(), table, "invalid document", err)
+	}
+	return d.WriteBatch(ctx, []DestinationRecord{{Table: table, Operation: OperationInsert, Data: document, Timestamp: time.Now()}})
+}
+
+// WriteBatch writes records in order with a single endpoint batch
+func (d *EndpointDestination) WriteBatch(ctx context.Context, batch []DestinationRecord) error {
+	records, size, err := d.recordEvents(batch)
+	if err != nil {
+		return err
+	}
+	return d.WriteEvents(ctx, records, size)
+}
+
+// WriteEvents writes record events in order with a single endpoint batch.
+// The size is the number of payload bytes, for the metrics.
+func (d *EndpointDestination) WriteEvents(ctx context.Context, records []*events.RecordEvent, size int) error {
+	endpoint, err := d.connected()
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err = endpoint.WriteBatch(ctx, records)
+	d.recordWrite(len(records), size, time.Since(start), err)
+	return err
+}
+
+// writeAtomic writes records in order as one atomic write: a transaction on
+// the endpoints that support them, a single batch on the others, whose
+// batches are atomic
+func (d *EndpointDestination) writeAtomic(ctx context.Context, batch []DestinationRecord) error {
+	endpoint, err := d.connected()
+	if err != nil {
+		return err
+	}
+	transactor, ok := endpoint.(endpointTransactor)
+	if !ok {
+		return d.WriteBatch(ctx, batch)
+	}
+	records, size, err := d.recordEvents(batch)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err = transactor.WriteTransaction(ctx, records)
+	d.recordWrite(len(records), size, time.Since(start), err)
+	return err
+}
+
+// recordEvents converts records to record events and returns their number
+// of payload bytes
+func (d *EndpointDestination) recordEvents(batch []DestinationRecord) ([]*events.RecordEvent, int, error) {
+	records := make([]*events.RecordEvent, 0, len(batch))
+	size := 0
+	for _, record := range batch {
+		event, err := RecordEventFromDestinationRecord(record)
+		if err != nil {
+			return nil, 0, NewRecordError(d.name(), record.Table, "invalid record", err)
+		}
+		records = append(records, event)
+		size += len(event.Data)
+	}
+	return records, size, nil
+}
+
+// CreateTable creates a table
+func (d *EndpointDestination) CreateTable(ctx context.Context, schema TableSchema) error {
+	catalog, err := d.catalog("create table")
+	if err != nil {
+		return err
+	}
+	return catalog.CreateTable(ctx, schema)
+}
+
+// DropTable drops a table
+func (d *EndpointDestination) DropTable(ctx context.Context, tableName string) error {
+	catalog, err := d.catalog("drop table")
+	if err != nil {
+		return err
+	}
+	return catalog.DropTable(ctx, tableName)
+}
+
+// TruncateTable removes all rows of a table
+func (d *EndpointDestination) TruncateTable(ctx context.Context, tableName string) error {
+	catalog, err := d.catalog("truncate table")
+	if err != nil {
+		return err
+	}
+	return catalog.TruncateTable(ctx, tableName)
+}
+
+// GetSchema returns the schema of a table
+func (d *EndpointDestination) GetSchema(ctx context.Context, tableName string) (*TableSchema, error) {
+	catalog, err := d.catalog("get schema")
+	if err != nil {
+		return nil, err
+	}
+	return catalog.GetSchema(ctx, tableName)
+}
+
+// UpdateSchema adds the columns of the schema that the table lacks
+func (d *EndpointDestination) UpdateSchema(ctx context.Context, tableName string, schema TableSchema) error {
+	endpoint, err := d.connected()
+	if err != nil {
+		return err
+	}
+	updater, ok := endpoint.(endpointSchemaUpdater)
+	if !ok {
+		return d.unsupported("update schema")
+	}
+	return updater.UpdateSchema(ctx, tableName, schema)
+}
+
+// ListTables lists the tables of the destination
+func (d *EndpointDestination) ListTables(ctx context.Context) ([]string, error) {
+	catalog, err := d.catalog("list tables")
+	if err != nil {
+		return nil, err
+	}
+	return catalog.ListTables(ctx)
+}
+
+// BeginTransaction starts a transaction. Its writes are buffered and sent as
+// one batch on commit, which the relational and transactional Kafka targets
+// apply atomically, and MongoDB in a multi-document transaction.
+func (d *EndpointDestination) BeginTransaction(ctx context.Context) (Transaction, error) {
+	if _, err := d.connected(); err != nil {
+		return nil, err
+	}
+	d.metricsMu.Lock()
+	d.metrics.TotalTransactions++
+	d.metricsMu.Unlock()
+	return &bufferedTransaction{
+		destination: d,
+		id:          fmt.Sprintf("%s-%d", d.name(), d.txnSeq.Add(1)),
+		started:     time.Now(),
+		active:      true,
+	}, nil
+}
+
+// GetHealth pings the destination. A destination whose recent writes failed
+// is degraded.
+func (d *EndpointDestination) GetHealth(ctx context.Context) (*HealthStatus, error) {
+	start := time.Now()
+	err := d.Ping(ctx)
+	health := &HealthStatus{
+		Status:       HealthHealthy,
+		LastCheck:    time.Now(),
+		ResponseTime: time.Since(start),
+		Details:      map[string]interface{}{"type": string(d.targetConfig.Type)},
+	}
+	if err != nil {
+		health.Status = HealthUnhealthy
+		health.Message = err.Error()
+		return health, nil
+	}
+
+	d.metricsMu.Lock()
+	if d.metrics.LastErrorTime != nil && d.metrics.LastErrorTime.After(d.lastWrite) {
+		health.Status = HealthDegraded
+		health.Message = d.metrics.LastError
+	}
+	d.metricsMu.Unlock()
+	return health, nil
+}
+
+// GetMetrics returns a snapshot of the write metrics
+func (d *EndpointDestination) GetMetrics(ctx context.Context) (*DestinationMetrics, error) {
+	connected := d.IsConnected()
+	d.metricsMu.Lock()
+	defer d.metricsMu.Unlock()
+
+	metrics := d.metrics
+	if elapsed := time.Since(d.started).Seconds(); !d.started.IsZero() && elapsed > 0 {
+		metrics.Throughput = float64(metrics.RecordsWritten) / elapsed
+	}
+	if connected {
+		metrics.ActiveConnections = 1
+	}
+	metrics.LastUpdated = time.Now()
+	return &metrics, nil
+}
+
+// GetConfig returns the target configuration
+func (d *EndpointDestination) GetConfig() config.TargetConfig {
+	return d.targetConfig
+}
+
+// ValidateConfig checks that the endpoint configuration can be built
+func (d *EndpointDestination) ValidateConfig() error {
+	return validateTargetConfig(d.targetConfig)
+}
+
+// Close closes the endpoint
+func (d *EndpointDestination) Close() error {
+	return d.Disconnect(context.Background())
+}
+
+// connected returns the endpoint, failing when it is not open
+func (d *EndpointDestination) connected() (Endpoint, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.endpoint == nil {
+		return nil, NewConnectionError(d.name(), "destination is not connected", nil)
+	}
+	return d.endpoint, nil
+}
+
+func (d *EndpointDestination) catalog(operation string) (endpointCatalog, error) {
+	endpoint, err := d.connected()
+	if err != nil {
+		return nil, err
+	}
+	catalog, ok := endpoint.(endpointCatalog)
+	if !ok {
+		return nil, d.unsupported(operation)
+	}
+	return catalog, nil
+}
+
+func (d *EndpointDestination) unsupported(operation string) error {
+	err := newDestinationError(ErrCodeUnsupportedOperation, d.name(), "", fmt.Sprintf("%s is not supported", operation), nil)
+	err.Operation = operation
+	return err
+}
+
+// name identifies the destination in errors
+func (d *EndpointDestination) name() string {
+	return string(d.targetConfig.Type)
+}
+
+func (d *EndpointDestination) recordConnect(duration time.Duration, err error) {
+	d.metricsMu.Lock()
+	defer d.metricsMu.Unlock()
+	d.metrics.TotalConnections++
+	if err != nil {
+		d.metrics.ConnectionErrors++
+		d.recordError(err)
+		return
+	}
+	d.metrics.AverageConnectTime = runningAverage(d.metrics.AverageConnectTime, duration, d.metrics.TotalConnections-d.metrics.ConnectionErrors)
+	if d.started.IsZero() {
+		d.started = time.Now()
+	}
+}
+
+func (d *EndpointDestination) recordWrite(records, size int, duration time.Duration, err error) {
+	d.metricsMu.Lock()
+	defer d.metricsMu.Unlock()
+	d.metrics.TotalWrites++
+	d.metrics.Latency = duration
+	if err != nil {
+		d.metrics.FailedWrites++
+		d.recordError(err)
+		return
+	}
+	d.metrics.SuccessfulWrites++
+	d.metrics.RecordsWritten += int64(records)
+	d.metrics.BytesWritten += int64(size)
+	d.metrics.AverageWriteTime = runningAverage(d.metrics.AverageWriteTime, duration, d.metrics.SuccessfulWrites)
+	d.lastWrite = time.Now()
+}
+
+// recordError records the last error; the caller holds metricsMu
+func (d *EndpointDestination) recordError(err error) {
+	now := time.Now()
+	d.metrics.TotalErrors++
+	d.metrics.LastError = err.Error()
+	d.metrics.LastErrorTime = &now
+}
+
+// runningAverage adds the n-th sample to an average
+func runningAverage(average, sample time.Duration, n int64) time.Duration {
+	if n <= 1 {
+		return sample
+	}
+	return average + (sample-average)/time.Duration(n)
+}
+
+// bufferedTransaction buffers records and writes them as one batch on commit
+type bufferedTransaction struct {
+	destination *EndpointDestination
+	id          string
+	started     time.Time
+
+	mu      sync.Mutex
+	records []DestinationRecord
+	active  bool
+}
+
+// Commit writes the buffered records
+func (t *bufferedTransaction) Commit(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return NewTransactionError(t.destination.name(), fmt.Sprintf("transaction %s is not active", t.id), nil)
+	}
+	t.active = false
+
+	err := t.destination.writeAtomic(ctx, t.records)
+	t.records = nil
+
+	d := t.destination
+	d.metricsMu.Lock()
+	defer d.metricsMu.Unlock()
+	if err != nil {
+		d.metrics.RolledBackTransactions++
+		return err
+	}
+	d.metrics.CommittedTransactions++
+	d.metrics.AverageTransactionTime = runningAverage(d.metrics.AverageTransactionTime, time.Since(t.started), d.metrics.CommittedTransactions)
+	return nil
+}
+
+// Rollback discards the buffered records
+func (t *bufferedTransaction) Rollback(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return nil
+	}
+	t.active = false
+	t.records = nil
+
+	t.destination.metricsMu.Lock()
+	t.destination.metrics.RolledBackTransactions++
+	t.destination.metricsMu.Unlock()
+	return nil
+}
+
+// Write buffers the insert of a JSON document
+func (t *bufferedTransaction) Write(ctx context.Context, data []byte, table string) error {
+	document, err := decodeDocument(data)
+	if err != nil {
+		return NewRecordError(t.destination.name(), table, "invalid document", err)
+	}
+	return t.WriteBatch(ctx, []DestinationRecord{{Table: table, Operation: OperationInsert, Data: document, Timestamp: time.Now()}})
+}
+
+// WriteBatch buffers records
+func (t *bufferedTransaction) WriteBatch(ctx context.Context, batch []DestinationRecord) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.active {
+		return NewTransactionError(t.destination.name(), fmt.Sprintf("transaction %s is not active", t.id), nil)
+	}
+	t.records = append(t.records, batch...)
+	return nil
+}
+
+// IsActive reports whether the transaction can take writes
+func (t *bufferedTransaction) IsActive() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.active
+}
+
+// GetID returns the transaction id
+func (t *bufferedTransaction) GetID() string {
+	return t.id
+}
+
+// Record metadata keys carrying the record event fields that have no
+// DestinationRecord counterpart
+const (
+	recordMetadataSchema   = "schema"
+	recordMetadataOldData  = "old_data"
+	recordMetadataPosition = "position"
+)
+
+// DestinationRecordFromEvent maps a record event to a destination record.
+// The schema, previous document and source position go to the metadata.
+func DestinationRecordFromEvent(record *events.RecordEvent) (DestinationRecord, error) {
+	result := DestinationRecord{
+		Table:     record.Collection,
+		Operation: strings.ToUpper(record.Action),
+		Metadata:  make(map[string]interface{}),
+		Timestamp: time.Now(),
+	}
+
+	var err error
+	if len(record.Data) > 0 {
+		if result.Data, err = decodeDocument(record.Data); err != nil {
+			return result, err
+		}
+	}
+	if len(record.DocumentKey) > 0 {
+		if result.Key, err = decodeDocument(record.DocumentKey); err != nil {
+			return result, fmt.Errorf("failed to decode document key: %w", err)
+		}
+	}
+	if len(record.OldData) > 0 {
+		oldData, err := decodeDocument(record.OldData)
+		if err != nil {
+			return result, fmt.Errorf("failed to decode old data: %w", err)
+		}
+		result.Metadata[recordMetadataOldData] = oldData
+	}
+	if record.Schema != "" {
+		result.Metadata[recordMetadataSchema] = record.Schema
+	}
+	if record.Position != nil {
+		result.Metadata[recordMetadataPosition] = record.Position
+	}
+	return result, nil
+}
+
+// RecordEventFromDestinationRecord maps a destination record to the record
+// event the endpoints write. Upserts are inserts, which every endpoint
+// applies as an upsert.
+func RecordEventFromDestinationRecord(record DestinationRecord) (*events.RecordEvent, error) {
+	event := &events.RecordEvent{Collection: record.Table}
+	switch strings.ToUpper(record.Operation) {
+	case OperationInsert, OperationUpsert, "":
+		event.Action = events.InsertAction
+	case OperationUpdate:
+		event.Action = events.UpdateAction
+	case OperationDelete:
+		event.Action = events.DeleteAction
+	default:
+		return nil, fmt.Errorf("unsupported operation %q", record.Operation)
+	}
+
+	var err error
+	if record.Data != nil {
+		if event.Data, err = encodeDocument(record.Data); err != nil {
+			return nil, err
+		}
+	}
+	if record.Key != nil {
+		if event.DocumentKey, err = encodeDocument(record.Key); err != nil {
+			return nil, err
+		}
+	}
+	if oldData, ok := record.Metadata[recordMetadataOldData]; ok && oldData != nil {
+		if event.OldData, err = encodeDocument(oldData); err != nil {
+			return nil, err
+		}
+	}
+	event.Schema, _ = record.Metadata[recordMetadataSchema].(string)
+	event.Position, _ = record.Metadata[recordMetadataPosition].(map[string]interface{})
+	return event, nil
+}
+
+// encodeDocument encodes a document as JSON, keeping non-ASCII characters and markup as is
+func encodeDocument(document interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(document); err != nil {
+		return nil, fmt.Errorf("failed to encode document: %w", err)
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
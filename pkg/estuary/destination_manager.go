@@ -0,0 +1,493 @@
+package estuary
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cohenjo/replicator/pkg/config"
+)
+
+// defaultMonitorInterval is the health check interval of a started manager
+const defaultMonitorInterval = 30 * time.Second
+
+// EndpointDestinationFactory creates endpoint destinations of one target type
+type EndpointDestinationFactory struct {
+	targetType config.TargetType
+	open       EndpointOpener
+}
+
+// NewEndpointDestinationFactory creates a factory opening endpoints with open
+func NewEndpointDestinationFactory(targetType config.TargetType, open EndpointOpener) *EndpointDestinationFactory {
+	return &EndpointDestinationFactory{targetType: targetType, open: open}
+}
+
+// CreateDestination creates and connects a destination
+func (f *EndpointDestinationFactory) CreateDestination(ctx context.Context, targetConfig config.TargetConfig) (DatabaseDestination, error) {
+	if err := f.ValidateConfig(targetConfig); err != nil {
+		return nil, err
+	}
+	destination := NewEndpointDestination(targetConfig, f.open)
+	if err := destination.Connect(ctx); err != nil {
+		return nil, err
+	}
+	return destination, nil
+}
+
+// GetSupportedTypes returns the target type of the factory
+func (f *EndpointDestinationFactory) GetSupportedTypes() []string {
+	return []string{string(f.targetType)}
+}
+
+// ValidateConfig checks the target type and that the endpoint configuration can be built
+func (f *EndpointDestinationFactory) ValidateConfig(targetConfig config.TargetConfig) error {
+	if targetConfig.Type != f.targetType {
+		return newDestinationError(ErrCodeInvalidConfig, string(f.targetType), "",
+			fmt.Sprintf("factory creates %s destinations, not %s", f.targetType, targetConfig.Type), nil)
+	}
+	return validateTargetConfig(targetConfig)
+}
+
+// validateTargetConfig checks that a target has what its endpoint needs to connect
+func validateTargetConfig(targetConfig config.TargetConfig) error {
+	streamConfig, err := legacyTargetConfig(targetConfig)
+	if err != nil {
+		return newDestinationError(ErrCodeInvalidConfig, string(targetConfig.Type), "", "invalid target configuration", err)
+	}
+
+	var missing string
+	switch targetConfig.Type {
+	case config.TargetTypeKafka:
+		if len(streamConfig.KafkaBrokers) == 0 && targetConfig.Host == "" {
+			missing = "brokers or host"
+		}
+	case config.TargetTypeCosmosDB:
+		if streamConfig.CosmosEndpoint == "" {
+			missing = "uri or host"
+		} else if streamConfig.CosmosContainerName == "" {
+			missing = "container"
+		}
+	case config.TargetTypeMySQL, config.TargetTypePostgreSQL:
+		if targetConfig.URI == "" && targetConfig.Host == "" {
+			missing = "uri or host"
+		}
+	case config.TargetTypeElastic:
+		// Defaults to a local node
+	}
+	if missing != "" {
+		return newDestinationError(ErrCodeInvalidConfig, string(targetConfig.Type), "", fmt.Sprintf("%s target requires %s", targetConfig.Type, missing), nil)
+	}
+	return nil
+}
+
+// openTargetEndpoint creates the endpoint of a target type
+func openTargetEndpoint(targetType config.TargetType) EndpointOpener {
+	return func(streamConfig *config.WaterFlowsConfig) (Endpoint, error) {
+		switch targetType {
+		case config.TargetTypeElastic:
+			return NewElasticEndpoint(streamConfig)
+		case config.TargetTypeMySQL:
+			return NewMySQLEndpoint(streamConfig)
+		case config.TargetTypeMongoDB:
+			endpoint := NewMongoEndpoint(streamConfig)
+			return &endpoint, nil
+		case config.TargetTypeKafka:
+			endpoint := NewKafkaEndpoint(streamConfig)
+			return &endpoint, nil
+		case config.TargetTypePostgreSQL:
+			return NewPostgreSQLEndpoint(streamConfig)
+		case config.TargetTypeCosmosDB:
+			return NewCosmosEndpoint(streamConfig)
+		}
+		return nil, fmt.Errorf("unsupported target type: %s", targetType)
+	}
+}
+
+// DefaultDestinationFactories returns the factories of the supported target types
+func DefaultDestinationFactories() map[config.TargetType]DestinationFactory {
+	factories := make(map[config.TargetType]DestinationFactory)
+	for _, targetType := range []config.TargetType{
+		config.TargetTypeMongoDB, config.TargetTypeMySQL, config.TargetTypeElastic,
+		config.TargetTypeKafka, config.TargetTypePostgreSQL, config.TargetTypeCosmosDB,
+	} {
+		factories[targetType] = NewEndpointDestinationFactory(targetType, openTargetEndpoint(targetType))
+	}
+	return factories
+}
+
+// DefaultDestinationRegistry implements DestinationRegistry
+type DefaultDestinationRegistry struct {
+	mu           sync.RWMutex
+	destinations map[string]DatabaseDestination
+}
+
+// NewDestinationRegistry creates an empty destination registry
+func NewDestinationRegistry() *DefaultDestinationRegistry {
+	return &DefaultDestinationRegistry{destinations: make(map[string]DatabaseDestination)}
+}
+
+// RegisterDestination adds a destination under a unique name
+func (r *DefaultDestinationRegistry) RegisterDestination(name string, destination DatabaseDestination) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.destinations[name]; exists {
+		return fmt.Errorf("destination %s is already registered", name)
+	}
+	r.destinations[name] = destination
+	return nil
+}
+
+// GetDestination returns a destination by name
+func (r *DefaultDestinationRegistry) GetDestination(name string) (DatabaseDestination, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	destination, ok := r.destinations[name]
+	if !ok {
+		return nil, destinationNotFound(name)
+	}
+	return destination, nil
+}
+
+// RemoveDestination removes a destination without closing it
+func (r *DefaultDestinationRegistry) RemoveDestination(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.destinations[name]; !ok {
+		return destinationNotFound(name)
+	}
+	delete(r.destinations, name)
+	return nil
+}
+
+// ListDestinations returns the destination names, sorted
+func (r *DefaultDestinationRegistry) ListDestinations() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.destinations))
+	for name := range r.destinations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetDestinationByType returns the first destination, by name, of a target type
+func (r *DefaultDestinationRegistry) GetDestinationByType(destinationType string) (DatabaseDestination, error) {
+	for _, name := range r.ListDestinations() {
+		destination, err := r.GetDestination(name)
+		if err == nil && strings.EqualFold(string(destination.GetConfig().Type), destinationType) {
+			return destination, nil
+		}
+	}
+	return nil, newDestinationError(ErrCodeDestinationNotFound, destinationType, "", "no destination of this type", nil)
+}
+
+// WriteToAll writes a document to every destination
+func (r *DefaultDestinationRegistry) WriteToAll(ctx context.Context, data []byte, table string) error {
+	return r.WriteToMultiple(ctx, r.ListDestinations(), data, table)
+}
+
+// WriteToMultiple writes a document to the named destinations, returning the
+// errors of all failed writes
+func (r *DefaultDestinationRegistry) WriteToMultiple(ctx context.Context, destinationNames []string, data []byte, table string) error {
+	var errs []error
+	for _, name := range destinationNames {
+		destination, err := r.GetDestination(name)
+		if err == nil {
+			err = destination.Write(ctx, data, table)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("destination %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CheckAllHealth checks the health of every destination
+func (r *DefaultDestinationRegistry) CheckAllHealth(ctx context.Context) (map[string]*HealthStatus, error) {
+	results := make(map[string]*HealthStatus)
+	for _, name := range r.ListDestinations() {
+		destination, err := r.GetDestination(name)
+		if err != nil {
+			continue
+		}
+		health, err := destination.GetHealth(ctx)
+		if err != nil {
+			health = &HealthStatus{Status: HealthUnhealthy, Message: err.Error(), LastCheck: time.Now()}
+		}
+		results[name] = health
+	}
+	return results, nil
+}
+
+// GetAllMetrics returns the metrics of every destination
+func (r *DefaultDestinationRegistry) GetAllMetrics(ctx context.Context) (map[string]*DestinationMetrics, error) {
+	results := make(map[string]*DestinationMetrics)
+	for _, name := range r.ListDestinations() {
+		destination, err := r.GetDestination(name)
+		if err != nil {
+			continue
+		}
+		metrics, err := destination.GetMetrics(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get metrics of destination %s: %w", name, err)
+		}
+		results[name] = metrics
+	}
+	return results, nil
+}
+
+func destinationNotFound(name string) error {
+	return newDestinationError(ErrCodeDestinationNotFound, name, "", "destination not found", nil)
+}
+
+// DefaultDestinationManager implements DestinationManager. Destinations are
+// created by the factory registered for their target type and kept in a
+// registry; a started manager checks their health periodically.
+type DefaultDestinationManager struct {
+	registry *DefaultDestinationRegistry
+
+	mu        sync.RWMutex
+	factories map[config.TargetType]DestinationFactory
+	health    map[string]*HealthStatus
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+// NewDestinationManager creates a manager with the default factories
+func NewDestinationManager() *DefaultDestinationManager {
+	return &DefaultDestinationManager{
+		registry:  NewDestinationRegistry(),
+		factories: DefaultDestinationFactories(),
+		health:    make(map[string]*HealthStatus),
+	}
+}
+
+// Registry returns the registry of the managed destinations
+func (m *DefaultDestinationManager) Registry() DestinationRegistry {
+	return m.registry
+}
+
+// RegisterFactory registers the factory of a target type, replacing any previous one
+func (m *DefaultDestinationManager) RegisterFactory(destinationType string, factory DestinationFactory) error {
+	if factory == nil {
+		return fmt.Errorf("factory for %s is nil", destinationType)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.factories[config.TargetType(strings.ToLower(destinationType))] = factory
+	return nil
+}
+
+// GetFactory returns the factory of a target type
+func (m *DefaultDestinationManager) GetFactory(destinationType string) (DestinationFactory, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	factory, ok := m.factories[config.TargetType(strings.ToLower(destinationType))]
+	if !ok {
+		return nil, newDestinationError(ErrCodeInvalidConfig, destinationType, "", "unsupported target type", nil)
+	}
+	return factory, nil
+}
+
+// CreateDestination creates a destination with the factory of its target type and registers it
+func (m *DefaultDestinationManager) CreateDestination(ctx context.Context, name string, targetConfig config.TargetConfig) error {
+	if _, err := m.registry.GetDestination(name); err == nil {
+		return fmt.Errorf("destination %s already exists", name)
+	}
+	factory, err := m.GetFactory(string(targetConfig.Type))
+	if err != nil {
+		return err
+	}
+	destination, err := factory.CreateDestination(ctx, targetConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create destination %s: %w", name, err)
+	}
+	if err := m.registry.RegisterDestination(name, destination); err != nil {
+		destination.Close()
+		return err
+	}
+	logger.Info().Str("destination", name).Str("type", string(targetConfig.Type)).Msg("Created destination")
+	return nil
+}
+
+// GetDestination returns a destination by name
+func (m *DefaultDestinationManager) GetDestination(name string) (DatabaseDestination, error) {
+	return m.registry.GetDestination(name)
+}
+
+// RemoveDestination unregisters and closes a destination
+func (m *DefaultDestinationManager) RemoveDestination(ctx context.Context, name string) error {
+	destination, err := m.registry.GetDestination(name)
+	if err != nil {
+		return err
+	}
+	if err := m.registry.RemoveDestination(name); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	delete(m.health, name)
+	m.mu.Unlock()
+	return destination.Close()
+}
+
+// UpdateDestinationConfig replaces a destination with one created from the
+// new configuration. The old destination is kept when the new one fails.
+func (m *DefaultDestinationManager) UpdateDestinationConfig(ctx context.Context, name string, targetConfig config.TargetConfig) error {
+	old, err := m.registry.GetDestination(name)
+	if err != nil {
+		return err
+	}
+	factory, err := m.GetFactory(string(targetConfig.Type))
+	if err != nil {
+		return err
+	}
+	destination, err := factory.CreateDestination(ctx, targetConfig)
+	if err != nil {
+		return fmt.Errorf("failed to update destination %s: %w", name, err)
+	}
+
+	m.registry.mu.Lock()
+	m.registry.destinations[name] = destination
+	m.registry.mu.Unlock()
+	if err := old.Close(); err != nil {
+		logger.Warn().Err(err).Str("destination", name).Msg("Failed to close replaced destination")
+	}
+	return nil
+}
+
+// GetDestinationConfig returns the target configuration of a destination
+func (m *DefaultDestinationManager) GetDestinationConfig(name string) (*config.TargetConfig, error) {
+	destination, err := m.registry.GetDestination(name)
+	if err != nil {
+		return nil, err
+	}
+	targetConfig := destination.GetConfig()
+	return &targetConfig, nil
+}
+
+// MonitorDestinations checks the health of all destinations every interval
+// until the context is done
+func (m *DefaultDestinationManager) MonitorDestinations(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("monitor interval must be positive")
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		m.checkHealth(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *DefaultDestinationManager) checkHealth(ctx context.Context) {
+	results, _ := m.registry.CheckAllHealth(ctx)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name, health := range results {
+		if previous, ok := m.health[name]; ok && previous.Status != health.Status {
+			logger.Info().Str("destination", name).Str("from", previous.Status).Str("to", health.Status).Msg("Destination health changed")
+		}
+		m.health[name] = health
+	}
+}
+
+// GetDestinationHealth returns the last monitored health of a destination,
+// checking it now when it has not been monitored yet
+func (m *DefaultDestinationManager) GetDestinationHealth(name string) (*HealthStatus, error) {
+	m.mu.RLock()
+	health, ok := m.health[name]
+	m.mu.RUnlock()
+	if ok {
+		return health, nil
+	}
+	destination, err := m.registry.GetDestination(name)
+	if err != nil {
+		return nil, err
+	}
+	return destination.GetHealth(context.Background())
+}
+
+// GetDestinationMetrics returns the metrics of a destination
+func (m *DefaultDestinationManager) GetDestinationMetrics(name string) (*DestinationMetrics, error) {
+	destination, err := m.registry.GetDestination(name)
+	if err != nil {
+		return nil, err
+	}
+	return destination.GetMetrics(context.Background())
+}
+
+// WriteToDestination writes records to a destination
+func (m *DefaultDestinationManager) WriteToDestination(ctx context.Context, destinationName string, records []DestinationRecord) error {
+	destination, err := m.registry.GetDestination(destinationName)
+	if err != nil {
+		return err
+	}
+	return destination.WriteBatch(ctx, records)
+}
+
+// WriteToMultipleDestinations writes records to each destination, returning
+// the errors of all failed writes
+func (m *DefaultDestinationManager) WriteToMultipleDestinations(ctx context.Context, destinationNames []string, records []DestinationRecord) error {
+	var errs []error
+	for _, name := range destinationNames {
+		if err := m.WriteToDestination(ctx, name, records); err != nil {
+			errs = append(errs, fmt.Errorf("destination %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Start starts monitoring the destinations
+func (m *DefaultDestinationManager) Start(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cancel != nil {
+		return fmt.Errorf("destination manager is already running")
+	}
+	ctx, m.cancel = context.WithCancel(ctx)
+	m.done = make(chan struct{})
+	go func(done chan struct{}) {
+		defer close(done)
+		m.MonitorDestinations(ctx, defaultMonitorInterval)
+	}(m.done)
+	return nil
+}
+
+// Stop stops monitoring and closes all destinations
+func (m *DefaultDestinationManager) Stop(ctx context.Context) error {
+	m.mu.Lock()
+	cancel, done := m.cancel, m.done
+	m.cancel, m.done = nil, nil
+	m.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
+	}
+
+	var errs []error
+	for _, name := range m.registry.ListDestinations() {
+		if err := m.RemoveDestination(ctx, name); err != nil {
+			errs = append(errs, fmt.Errorf("destination %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// IsRunning reports whether the destinations are monitored
+func (m *DefaultDestinationManager) IsRunning() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.cancel != nil
+}
@@ -0,0 +1,136 @@
+package estuary
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cohenjo/replicator/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateTargetConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		target config.TargetConfig
+		valid  bool
+	}{
+		{"mongo uri", config.TargetConfig{Type: config.TargetTypeMongoDB, URI: "mongodb://localhost"}, true},
+		{"mongo without address", config.TargetConfig{Type: config.TargetTypeMongoDB}, false},
+		{"kafka brokers", config.TargetConfig{Type: config.TargetTypeKafka, Options: map[string]interface{}{"brokers": "a:9092,b:9092"}}, true},
+		{"kafka without brokers", config.TargetConfig{Type: config.TargetTypeKafka}, false},
+		{"mysql host", config.TargetConfig{Type: config.TargetTypeMySQL, Host: "db"}, true},
+		{"postgres without address", config.TargetConfig{Type: config.TargetTypePostgreSQL}, false},
+		{"cosmos without container", config.TargetConfig{Type: config.TargetTypeCosmosDB, URI: "https://acct.documents.azure.com"}, false},
+		{"elastic defaults", config.TargetConfig{Type: config.TargetTypeElastic}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateTargetConfig(tt.target)
+			if tt.valid {
+				assert.NoError(t, err)
+				return
+			}
+			var destErr *DestinationError
+			require.True(t, errors.As(err, &destErr))
+			assert.Equal(t, ErrCodeInvalidConfig, destErr.Code)
+		})
+	}
+}
+
+func TestDefaultDestinationFactories(t *testing.T) {
+	factories := DefaultDestinationFactories()
+	for _, targetType := range []config.TargetType{
+		config.TargetTypeMongoDB, config.TargetTypeMySQL, config.TargetTypeElastic, config.TargetTypeKafka,
+	} {
+		require.Contains(t, factories, targetType)
+		assert.Equal(t, []string{string(targetType)}, factories[targetType].GetSupportedTypes())
+	}
+	// A factory only creates destinations of its own type
+	assert.Error(t, factories[config.TargetTypeMySQL].ValidateConfig(testTargetConfig))
+}
+
+func newTestManager(t *testing.T) (*DefaultDestinationManager, map[string]*fakeEndpoint) {
+	endpoints := make(map[string]*fakeEndpoint)
+	manager := NewDestinationManager()
+	require.NoError(t, manager.RegisterFactory("MongoDB", NewEndpointDestinationFactory(config.TargetTypeMongoDB,
+		func(streamConfig *config.WaterFlowsConfig) (Endpoint, error) {
+			endpoint := &fakeEndpoint{tables: map[string]TableSchema{}}
+			endpoints[streamConfig.MongoDatabaseName] = endpoint
+			return endpoint, nil
+		})))
+	return manager, endpoints
+}
+
+func TestDestinationManager(t *testing.T) {
+	ctx := context.Background()
+	manager, endpoints := newTestManager(t)
+
+	orders := testTargetConfig
+	orders.Database = "orders"
+	customers := testTargetConfig
+	customers.Database = "customers"
+	require.NoError(t, manager.CreateDestination(ctx, "orders-stream", orders))
+	require.NoError(t, manager.CreateDestination(ctx, "customers-stream", customers))
+	assert.Error(t, manager.CreateDestination(ctx, "orders-stream", orders))
+	assert.Error(t, manager.CreateDestination(ctx, "unknown", config.TargetConfig{Type: "oracle"}))
+
+	registry := manager.Registry()
+	assert.Equal(t, []string{"customers-stream", "orders-stream"}, registry.ListDestinations())
+	destination, err := registry.GetDestinationByType("mongodb")
+	require.NoError(t, err)
+	assert.Equal(t, config.TargetTypeMongoDB, destination.GetConfig().Type)
+	_, err = manager.GetDestination("missing")
+	var destErr *DestinationError
+	require.True(t, errors.As(err, &destErr))
+	assert.Equal(t, ErrCodeDestinationNotFound, destErr.Code)
+
+	require.NoError(t, registry.WriteToAll(ctx, []byte(`{"id":1}`), "items"))
+	require.NoError(t, manager.WriteToDestination(ctx, "orders-stream", []DestinationRecord{{Table: "items", Data: map[string]interface{}{"id": 2}}}))
+	assert.Len(t, endpoints["orders"].batches, 2)
+	assert.Len(t, endpoints["customers"].batches, 1)
+	assert.Error(t, manager.WriteToMultipleDestinations(ctx, []string{"orders-stream", "missing"}, nil))
+
+	endpoints["customers"].err = errors.New("connection refused")
+	health, err := registry.CheckAllHealth(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, HealthHealthy, health["orders-stream"].Status)
+	assert.Equal(t, HealthUnhealthy, health["customers-stream"].Status)
+
+	metrics, err := manager.GetDestinationMetrics("orders-stream")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), metrics.RecordsWritten)
+
+	// Updating the configuration replaces the destination and closes the old one
+	moved := orders
+	moved.Database = "orders_v2"
+	require.NoError(t, manager.UpdateDestinationConfig(ctx, "orders-stream", moved))
+	assert.True(t, endpoints["orders"].closed)
+	updated, err := manager.GetDestinationConfig("orders-stream")
+	require.NoError(t, err)
+	assert.Equal(t, "orders_v2", updated.Database)
+
+	require.NoError(t, manager.RemoveDestination(ctx, "customers-stream"))
+	assert.True(t, endpoints["customers"].closed)
+	assert.Equal(t, []string{"orders-stream"}, registry.ListDestinations())
+}
+
+func TestDestinationManager_StartStop(t *testing.T) {
+	ctx := context.Background()
+	manager, endpoints := newTestManager(t)
+	require.NoError(t, manager.CreateDestination(ctx, "orders-stream", testTargetConfig))
+
+	require.NoError(t, manager.Start(ctx))
+	assert.True(t, manager.IsRunning())
+	assert.Error(t, manager.Start(ctx))
+
+	health, err := manager.GetDestinationHealth("orders-stream")
+	require.NoError(t, err)
+	assert.Equal(t, HealthHealthy, health.Status)
+
+	require.NoError(t, manager.Stop(ctx))
+	assert.False(t, manager.IsRunning())
+	assert.True(t, endpoints["shop"].closed)
+	assert.Empty(t, manager.Registry().ListDestinations())
+}
@@ -0,0 +1,279 @@
+package estuary
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cohenjo/replicator/pkg/config"
+	"github.com/cohenjo/replicator/pkg/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeEndpoint records the written batches and keeps its tables in memory
+type fakeEndpoint struct {
+	batches [][]*events.RecordEvent
+	tables  map[string]TableSchema
+	err     error // returned by writes and pings when set
+	closed  bool
+}
+
+func (f *fakeEndpoint) Write(ctx context.Context, record *events.RecordEvent) error {
+	return f.WriteBatch(ctx, []*events.RecordEvent{record})
+}
+
+func (f *fakeEndpoint) WriteBatch(ctx context.Context, records []*events.RecordEvent) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.batches = append(f.batches, records)
+	return nil
+}
+
+func (f *fakeEndpoint) Ping(ctx context.Context) error {
+	return f.err
+}
+
+func (f *fakeEndpoint) ListTables(ctx context.Context) ([]string, error) {
+	return sortedKeys(f.tables), nil
+}
+
+func (f *fakeEndpoint) GetSchema(ctx context.Context, tableName string) (*TableSchema, error) {
+	schema, ok := f.tables[tableName]
+	if !ok {
+		return nil, newDestinationError(ErrCodeTableNotFound, "fake", tableName, "table does not exist", nil)
+	}
+	return &schema, nil
+}
+
+func (f *fakeEndpoint) CreateTable(ctx context.Context, schema TableSchema) error {
+	f.tables[schema.Name] = schema
+	return nil
+}
+
+func (f *fakeEndpoint) DropTable(ctx context.Context, tableName string) error {
+	delete(f.tables, tableName)
+	return nil
+}
+
+func (f *fakeEndpoint) TruncateTable(ctx context.Context, tableName string) error {
+	return nil
+}
+
+func (f *fakeEndpoint) Close() error {
+	f.closed = true
+	return nil
+}
+
+// writeOnlyEndpoint supports writes only
+type writeOnlyEndpoint struct{}
+
+func (writeOnlyEndpoint) Write(ctx context.Context, record *events.RecordEvent) error { return nil }
+
+func (writeOnlyEndpoint) WriteBatch(ctx context.Context, records []*events.RecordEvent) error {
+	return nil
+}
+
+var testTargetConfig = config.TargetConfig{Type: config.TargetTypeMongoDB, URI: "mongodb://localhost:27017", Database: "shop"}
+
+func newTestDestination(t *testing.T, endpoint Endpoint) *EndpointDestination {
+	destination := NewEndpointDestination(testTargetConfig, func(streamConfig *config.WaterFlowsConfig) (Endpoint, error) {
+		assert.Equal(t, "mongodb://localhost:27017", streamConfig.MongoURI)
+		return endpoint, nil
+	})
+	require.NoError(t, destination.Connect(context.Background()))
+	return destination
+}
+
+func TestEndpointDestination_Writes(t *testing.T) {
+	ctx := context.Background()
+	endpoint := &fakeEndpoint{tables: map[string]TableSchema{}}
+	destination := NewEndpointDestination(testTargetConfig, func(*config.WaterFlowsConfig) (Endpoint, error) {
+		return endpoint, nil
+	})
+
+	// Writes need a connection
+	assert.Error(t, destination.Write(ctx, []byte(`{"id":1}`), "orders"))
+	require.NoError(t, destination.Connect(ctx))
+	assert.True(t, destination.IsConnected())
+
+	require.NoError(t, destination.Write(ctx, []byte(`{"id":1}`), "orders"))
+	require.NoError(t, destination.WriteBatch(ctx, []DestinationRecord{
+		{Table: "orders", Operation: OperationUpsert, Data: map[string]interface{}{"id": 2}},
+		{Table: "orders", Operation: OperationDelete, Key: map[string]interface{}{"id": 1}},
+	}))
+	require.Len(t, endpoint.batches, 2)
+	assert.Equal(t, events.InsertAction, endpoint.batches[0][0].Action)
+	assert.Equal(t, `{"id":1}`, string(endpoint.batches[0][0].Data))
+	assert.Equal(t, events.DeleteAction, endpoint.batches[1][1].Action)
+	assert.Equal(t, `{"id":1}`, string(endpoint.batches[1][1].DocumentKey))
+
+	assert.Error(t, destination.Write(ctx, []byte(`not json`), "orders"))
+	assert.Error(t, destination.WriteBatch(ctx, []DestinationRecord{{Table: "orders", Operation: "MERGE"}}))
+
+	endpoint.err = NewWriteError("fake", "orders", "write failed", errors.New("boom"))
+	assert.Error(t, destination.Write(ctx, []byte(`{"id":3}`), "orders"))
+
+	metrics, err := destination.GetMetrics(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), metrics.RecordsWritten)
+	assert.Equal(t, int64(1), metrics.FailedWrites)
+	assert.Equal(t, int64(1), metrics.ActiveConnections)
+	assert.NotEmpty(t, metrics.LastError)
+
+	// A failing ping makes the destination unhealthy
+	health, err := destination.GetHealth(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, HealthUnhealthy, health.Status)
+	// A failed write after the last success makes it degraded
+	endpoint.err = nil
+	health, err = destination.GetHealth(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, HealthDegraded, health.Status)
+	require.NoError(t, destination.Write(ctx, []byte(`{"id":4}`), "orders"))
+	health, err = destination.GetHealth(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, HealthHealthy, health.Status)
+
+	require.NoError(t, destination.Close())
+	assert.True(t, endpoint.closed)
+	assert.False(t, destination.IsConnected())
+}
+
+func TestEndpointDestination_Catalog(t *testing.T) {
+	ctx := context.Background()
+	destination := newTestDestination(t, &fakeEndpoint{tables: map[string]TableSchema{}})
+
+	require.NoError(t, destination.CreateTable(ctx, TableSchema{Name: "orders", Columns: []ColumnDefinition{{Name: "id", Type: "int"}}}))
+	tables, err := destination.ListTables(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"orders"}, tables)
+	schema, err := destination.GetSchema(ctx, "orders")
+	require.NoError(t, err)
+	assert.Equal(t, "id", schema.Columns[0].Name)
+	require.NoError(t, destination.DropTable(ctx, "orders"))
+	_, err = destination.GetSchema(ctx, "orders")
+	assert.Error(t, err)
+
+	// The fake endpoint cannot add columns
+	err = destination.UpdateSchema(ctx, "orders", TableSchema{})
+	var destErr *DestinationError
+	require.True(t, errors.As(err, &destErr))
+	assert.Equal(t, ErrCodeUnsupportedOperation, destErr.Code)
+	assert.False(t, destErr.Retryable())
+}
+
+func TestEndpointDestination_UnsupportedOperations(t *testing.T) {
+	ctx := context.Background()
+	destination := newTestDestination(t, writeOnlyEndpoint{})
+
+	_, err := destination.ListTables(ctx)
+	var destErr *DestinationError
+	require.True(t, errors.As(err, &destErr))
+	assert.Equal(t, ErrCodeUnsupportedOperation, destErr.Code)
+	assert.Error(t, destination.CreateTable(ctx, TableSchema{Name: "orders"}))
+	assert.Error(t, destination.TruncateTable(ctx, "orders"))
+
+	// Without a ping, a connected endpoint is healthy
+	require.NoError(t, destination.Ping(ctx))
+	health, err := destination.GetHealth(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, HealthHealthy, health.Status)
+}
+
+func TestEndpointDestination_Transaction(t *testing.T) {
+	ctx := context.Background()
+	endpoint := &fakeEndpoint{tables: map[string]TableSchema{}}
+	destination := newTestDestination(t, endpoint)
+
+	txn, err := destination.BeginTransaction(ctx)
+	require.NoError(t, err)
+	assert.True(t, txn.IsActive())
+	require.NoError(t, txn.Write(ctx, []byte(`{"id":1}`), "orders"))
+	require.NoError(t, txn.Write(ctx, []byte(`{"id":2}`), "orders"))
+	assert.Empty(t, endpoint.batches)
+	require.NoError(t, txn.Commit(ctx))
+	require.Len(t, endpoint.batches, 1)
+	assert.Len(t, endpoint.batches[0], 2)
+	assert.False(t, txn.IsActive())
+	assert.Error(t, txn.Write(ctx, []byte(`{"id":3}`), "orders"))
+
+	txn, err = destination.BeginTransaction(ctx)
+	require.NoError(t, err)
+	require.NoError(t, txn.Write(ctx, []byte(`{"id":3}`), "orders"))
+	require.NoError(t, txn.Rollback(ctx))
+	assert.Len(t, endpoint.batches, 1)
+
+	metrics, err := destination.GetMetrics(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), metrics.CommittedTransactions)
+	assert.Equal(t, int64(1), metrics.RolledBackTransactions)
+}
+
+// transactorEndpoint writes its transactions apart from its batches
+type transactorEndpoint struct {
+	fakeEndpoint
+	transactions [][]*events.RecordEvent
+}
+
+func (f *transactorEndpoint) WriteTransaction(ctx context.Context, records []*events.RecordEvent) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.transactions = append(f.transactions, records)
+	return nil
+}
+
+func TestEndpointDestination_TransactionUsesEndpointTransactions(t *testing.T) {
+	ctx := context.Background()
+	endpoint := &transactorEndpoint{fakeEndpoint: fakeEndpoint{tables: map[string]TableSchema{}}}
+	destination := newTestDestination(t, endpoint)
+
+	txn, err := destination.BeginTransaction(ctx)
+	require.NoError(t, err)
+	require.NoError(t, txn.Write(ctx, []byte(`{"id":1}`), "orders"))
+	require.NoError(t, txn.Write(ctx, []byte(`{"id":2}`), "orders"))
+	require.NoError(t, txn.Commit(ctx))
+	assert.Empty(t, endpoint.batches, "the records are not written as a plain batch")
+	require.Len(t, endpoint.transactions, 1)
+	assert.Len(t, endpoint.transactions[0], 2)
+
+	// A failed transaction is rolled back
+	endpoint.err = NewRecordError("fake", "orders", "rejected", nil)
+	txn, err = destination.BeginTransaction(ctx)
+	require.NoError(t, err)
+	require.NoError(t, txn.Write(ctx, []byte(`{"id":3}`), "orders"))
+	require.Error(t, txn.Commit(ctx))
+
+	metrics, err := destination.GetMetrics(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), metrics.CommittedTransactions)
+	assert.Equal(t, int64(1), metrics.RolledBackTransactions)
+	assert.Equal(t, int64(2), metrics.RecordsWritten)
+}
+
+func TestDestinationRecordConversion(t *testing.T) {
+	record := &events.RecordEvent{
+		Action:      events.UpdateAction,
+		Schema:      "shop",
+		Collection:  "orders",
+		Data:        []byte(`{"id":7,"note":"<b>ñ</b>","qty":2}`),
+		OldData:     []byte(`{"id":7,"qty":1}`),
+		DocumentKey: []byte(`{"id":7}`),
+		Position:    map[string]interface{}{"file": "binlog.000001", "pos": 4},
+	}
+
+	destinationRecord, err := DestinationRecordFromEvent(record)
+	require.NoError(t, err)
+	assert.Equal(t, "orders", destinationRecord.Table)
+	assert.Equal(t, OperationUpdate, destinationRecord.Operation)
+	assert.Equal(t, "shop", destinationRecord.Metadata["schema"])
+
+	event, err := RecordEventFromDestinationRecord(destinationRecord)
+	require.NoError(t, err)
+	assert.Equal(t, record, event)
+
+	_, err = DestinationRecordFromEvent(&events.RecordEvent{Action: events.InsertAction, Data: []byte(`[1]`)})
+	assert.Error(t, err)
+}
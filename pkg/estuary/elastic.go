@@ -5,199 +5,541 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"net"
+	"io"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/cohenjo/replicator/pkg/config"
 	"github.com/cohenjo/replicator/pkg/events"
 
-	elasticsearch "github.com/elastic/go-elasticsearch/v7"
 	"github.com/elastic/go-elasticsearch/v7/esapi"
 )
 
+const (
+	// Default bulk request thresholds
+	esDefaultBulkActions = 500
+	esDefaultBulkBytes   = 5 << 20
+
+	elasticDestination = string(config.TargetTypeElastic)
+)
+
+// esIndexPlaceholder matches the placeholders of an index name pattern
+var esIndexPlaceholder = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// esBulkItem is one action of a bulk request
+type esBulkItem struct {
+	action   string // index, update or delete
+	index    string
+	id       string
+	document []byte // source line, empty for deletes
+	version  int64  // external version, 0 when unversioned
+}
+
+// ElasticBulkFailure is an action a bulk request rejected
+type ElasticBulkFailure struct {
+	Action string `json:"action"`
+	Index  string `json:"index"`
+	ID     string `json:"id"`
+	Status int    `json:"status"`
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// ElasticBulkError reports the failed actions of a bulk request
+type ElasticBulkError struct {
+	Failures []ElasticBulkFailure
+}
+
+// Error implements the error interface
+func (e *ElasticBulkError) Error() string {
+	first := e.Failures[0]
+	return fmt.Sprintf("%d bulk actions failed, first: %s %s/%s: %d %s: %s",
+		len(e.Failures), first.Action, first.Index, first.ID, first.Status, first.Type, first.Reason)
+}
+
+// ElasticEndpoint writes records to Elasticsearch through the bulk API.
+// Batches are split into bulk requests by action count and payload size.
+// With external versioning, documents carry the
+// source position as their version so a retried older change never
+// overwrites a newer one.
 type ElasticEndpoint struct {
-	index string
-	es    *elasticsearch.Client
+	es              esapi.Transport
+	indexPattern    string
+	idField         string
+	refresh         string
+	externalVersion bool // version documents by source position, see positionVersion
+
+	bulkActions int
+	bulkBytes   int
+	bulkTimeout time.Duration
 }
 
-func NewElasticEndpoint(streamConfig *config.WaterFlowsConfig) *ElasticEndpoint {
-	cfg := elasticsearch.Config{
-		Addresses: []string{fmt.Sprintf("http://%s:%d", streamConfig.Host, streamConfig.Port)},
-		Transport: &http.Transport{
-			MaxIdleConnsPerHost:   10,
-			ResponseHeaderTimeout: 10 * time.Second,
-			DialContext:           (&net.Dialer{Timeout: 5 * time.Second}).DialContext,
-			// TLSClientConfig: &tls.Config{
-			// 	MinVersion: tls.VersionTLS11,
-			// 	// ...
-			// },
-		},
+// NewElasticEndpoint creates an Elasticsearch endpoint and installs the
+// configured index template. The cluster version is configured or read from
+// the cluster, and decides the compatibility headers of the requests.
+func NewElasticEndpoint(streamConfig *config.WaterFlowsConfig) (*ElasticEndpoint, error) {
+	transport, err := newElasticTransport(streamConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Elasticsearch client: %w", err)
 	}
 
-	es, err := elasticsearch.NewClient(cfg)
-	if err != nil {
-		logger.Error().Err(err).Msg("Failed to create client :( ")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var version esClusterVersion
+	if streamConfig.ElasticVersion != "" && streamConfig.ElasticVersion != "auto" {
+		if version, err = parseElasticVersion(streamConfig.ElasticVersion); err != nil {
+			return nil, err
+		}
+	} else if version, err = transport.detectVersion(ctx); err != nil {
+		// The cluster may come up later, requests fall back to the 7 API
+		logger.Warn().Err(err).Msg("Failed to detect the Elasticsearch version, assuming Elasticsearch 7")
+		version = esClusterVersion{flavour: esFlavourElasticsearch, major: 7}
 	}
-	return &ElasticEndpoint{
-		index: streamConfig.Collection,
-		es:    es,
+	transport.compatibleWith = defaultCompatibleWith(version)
+	if streamConfig.ElasticCompatibleWith != 0 {
+		transport.compatibleWith = streamConfig.ElasticCompatibleWith
 	}
 
+	endpoint := newElasticEndpoint(transport, streamConfig)
+	if err := endpoint.putTemplate(ctx, streamConfig); err != nil {
+		return nil, err
+	}
+
+	logger.Info().
+		Str("version", version.String()).
+		Int("nodes", len(transport.urls)).
+		Str("index", endpoint.indexPattern).
+		Str("refresh", endpoint.refresh).
+		Bool("external_version", endpoint.externalVersion).
+		Int("bulk_actions", endpoint.bulkActions).
+		Dur("bulk_timeout", endpoint.bulkTimeout).
+		Msg("Created Elasticsearch endpoint")
+	return endpoint, nil
+}
+
+func newElasticEndpoint(es esapi.Transport, streamConfig *config.WaterFlowsConfig) *ElasticEndpoint {
+	endpoint := &ElasticEndpoint{
+		es:              es,
+		indexPattern:    streamConfig.Collection,
+		idField:         streamConfig.ElasticIDField,
+		refresh:         streamConfig.ElasticRefresh,
+		externalVersion: streamConfig.ElasticExternalVersion,
+		bulkActions:     streamConfig.ElasticBulkActions,
+		bulkBytes:       streamConfig.ElasticBulkBytes,
+		bulkTimeout:     time.Duration(streamConfig.ElasticBulkTimeout) * time.Millisecond,
+	}
+	if endpoint.indexPattern == "" {
+		endpoint.indexPattern = "{collection}"
+	}
+	if endpoint.bulkActions <= 0 {
+		endpoint.bulkActions = esDefaultBulkActions
+	}
+	if endpoint.bulkBytes <= 0 {
+		endpoint.bulkBytes = esDefaultBulkBytes
+	}
+	return endpoint
+}
+
+// Write writes a single record with a bulk request
+func (ee *ElasticEndpoint) Write(ctx context.Context, record *events.RecordEvent) error {
+	return ee.WriteBatch(ctx, []*events.RecordEvent{record})
+}
+
+// WriteBatch writes records in order with bulk requests, starting a new
+// request when the action count or payload size threshold is reached
+func (ee *ElasticEndpoint) WriteBatch(ctx context.Context, records []*events.RecordEvent) error {
+	now := time.Now()
+	var items []*esBulkItem
+	size := 0
+	for _, record := range records {
+		item, err := ee.buildItem(record, now)
+		if err != nil {
+			return NewRecordError(elasticDestination, record.Collection, "failed to build bulk action", err)
+		}
+		items = append(items, item)
+		size += len(item.document) + len(item.index) + len(item.id) + 64
+		if len(items) >= ee.bulkActions || size >= ee.bulkBytes {
+			if err := ee.bulk(ctx, items); err != nil {
+				return err
+			}
+			items, size = nil, 0
+		}
+	}
+	return ee.bulk(ctx, items)
 }
 
-func (ee *ElasticEndpoint) WriteEvent(record *events.RecordEvent) {
-	// Debug logging
-	logger.Debug().Str("action", record.Action).Str("schema", record.Schema).Str("collection", record.Collection).Msg("Processing event for Elasticsearch")
-	
-	// For MySQL data, we receive raw column values as JSON array
-	// We need to convert this to a structured JSON object
-	var rowData []interface{}
-	err := json.Unmarshal(record.Data, &rowData)
+// bulk sends actions in one bulk request
+func (ee *ElasticEndpoint) bulk(ctx context.Context, items []*esBulkItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+	index := items[0].index
+
+	body, err := esBulkBody(items)
+	if err != nil {
+		return NewRecordError(elasticDestination, index, "failed to encode bulk request", err)
+	}
+	if ee.bulkTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ee.bulkTimeout)
+		defer cancel()
+	}
+	req := esapi.BulkRequest{Body: bytes.NewReader(body), Refresh: ee.refresh}
+	res, err := req.Do(ctx, ee.es)
 	if err != nil {
-		logger.Error().Err(err).Msgf("Error unmarshaling MySQL row data")
-		return
-	}
-	
-	// Convert array to structured object - use generic approach
-	// For now, we'll create a simple indexed structure until schema mapping is configurable
-	var structuredData map[string]interface{}
-	var documentID string
-	
-	// Generic approach: create an object with indexed field names
-	structuredData = make(map[string]interface{})
-	for i, value := range rowData {
-		structuredData[fmt.Sprintf("field_%d", i)] = value
-	}
-	
-	// Use the first column as document ID if available, otherwise generate one
-	if len(rowData) > 0 {
-		documentID = fmt.Sprintf("%v", rowData[0])
-	} else {
-		documentID = fmt.Sprintf("%d", time.Now().UnixNano())
-	}
-	
-	// Convert structured data back to JSON for Elasticsearch
-	structuredJSON, err := json.Marshal(structuredData)
+		return classifyError(elasticDestination, index, fmt.Errorf("failed to send bulk request: %w", err))
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return classifyHTTPStatus(elasticDestination, index, res.StatusCode, fmt.Errorf("bulk request failed: %s", res.String()))
+	}
+
+	failures, err := parseBulkResponse(res.Body)
 	if err != nil {
-		logger.Error().Err(err).Msgf("Error marshaling structured data")
-		return
+		return classifyError(elasticDestination, index, err)
+	}
+	for _, failure := range failures {
+		logger.Error().
+			Str("action", failure.Action).
+			Str("index", failure.Index).
+			Str("id", failure.ID).
+			Int("status", failure.Status).
+			Str("type", failure.Type).
+			Str("reason", failure.Reason).
+			Msg("Elasticsearch bulk action failed")
+	}
+
+	if len(failures) < len(items) {
+		recordsSent.Add(float64(len(items) - len(failures)))
+	}
+	if len(failures) > 0 {
+		return classifyBulkFailures(&ElasticBulkError{Failures: failures})
+	}
+	return nil
+}
+
+// classifyBulkFailures classifies the failed actions of a bulk request. The
+// request is retryable when any action was throttled or hit a server error,
+// as the actions that succeeded are idempotent to replay.
+func classifyBulkFailures(bulkErr *ElasticBulkError) error {
+	failure := bulkErr.Failures[0]
+	for _, f := range bulkErr.Failures {
+		if f.Status == http.StatusTooManyRequests || f.Status >= 500 {
+			failure = f
+			break
+		}
+	}
+	return classifyHTTPStatus(elasticDestination, failure.Index, failure.Status, bulkErr)
+}
+
+// buildItem maps a record to a bulk action. Inserts index the document,
+// updates merge it into the existing one and deletes remove it by id. With
+// external versioning updates index the whole document instead, as the update
+// action cannot be versioned.
+func (ee *ElasticEndpoint) buildItem(record *events.RecordEvent, now time.Time) (*esBulkItem, error) {
+	item := &esBulkItem{index: renderIndexName(ee.indexPattern, record, now)}
+
+	var document map[string]interface{}
+	var err error
+	source := record.Data
+	if record.Action == events.DeleteAction && len(record.OldData) > 0 {
+		source = record.OldData
+	}
+	if len(source) > 0 {
+		if document, err = decodeDocument(source); err != nil {
+			return nil, fmt.Errorf("elasticsearch documents must be JSON objects: %w", err)
+		}
+	}
+
+	if item.id, err = ee.documentID(document, record.DocumentKey); err != nil {
+		return nil, err
 	}
-	
-	logger.Debug().Str("documentID", documentID).RawJSON("data", structuredJSON).Msg("Prepared data for Elasticsearch")
 
-	// Ensure index exists (Elasticsearch will auto-create, but we can be explicit)
-	ee.ensureIndexExists()
-	
-	var req esapi.Request
 	switch record.Action {
-	case "insert":
-		req = esapi.IndexRequest{
-			Index:      ee.index,
-			DocumentID: documentID,
-			Body:       bytes.NewReader(structuredJSON),
-			Refresh:    "true",
-		}
-	case "update":
-		// For updates, we'll use upsert to handle cases where document doesn't exist
-		upsertBody := map[string]interface{}{
-			"doc":           structuredData,
-			"doc_as_upsert": true,
-		}
-		upsertJSON, _ := json.Marshal(upsertBody)
-		req = esapi.UpdateRequest{
-			Index:      ee.index,
-			DocumentID: documentID,
-			Body:       bytes.NewReader(upsertJSON),
-			Refresh:    "true",
-		}
-	case "delete":
-		req = esapi.DeleteRequest{
-			Index:      ee.index,
-			DocumentID: documentID,
-			Refresh:    "true",
-		}
-	}
-	// Perform the request with the client.
-	res, err := req.Do(context.Background(), ee.es)
+	case events.InsertAction:
+		if document == nil {
+			return nil, fmt.Errorf("insert record has no data")
+		}
+		item.action = "index"
+		item.document, err = json.Marshal(document)
+	case events.UpdateAction:
+		if document == nil {
+			return nil, fmt.Errorf("update record has no data")
+		}
+		if ee.externalVersion {
+			item.action = "index"
+			item.document, err = json.Marshal(document)
+			break
+		}
+		item.action = "update"
+		item.document, err = json.Marshal(map[string]interface{}{"doc": document, "doc_as_upsert": true})
+	case events.DeleteAction:
+		item.action = "delete"
+	default:
+		return nil, fmt.Errorf("unsupported action for Elasticsearch: %s", record.Action)
+	}
 	if err != nil {
-		logger.Error().Err(err).Msg("Error getting response")
-		return
+		return nil, fmt.Errorf("failed to encode document: %w", err)
 	}
-	
-	// Ensure we have a valid response before dereferencing
-	if res == nil {
-		logger.Error().Msg("Received nil response from Elasticsearch")
-		return
+
+	if item.id == "" && (item.action != "index" || ee.externalVersion) {
+		return nil, fmt.Errorf("%s record has no document id", record.Action)
 	}
-	defer res.Body.Close()
+	if ee.externalVersion {
+		version, ok := positionVersion(record.Position)
+		if !ok {
+			return nil, fmt.Errorf("%s record has no source position to version the document by", record.Action)
+		}
+		item.version = version
+	}
+	return item, nil
+}
 
-	if res.IsError() {
-		logger.Error().Str("status", res.Status()).Str("documentID", documentID).Msg("Error indexing document")
-	} else {
-		// Deserialize the response into a map.
-		var r map[string]interface{}
-		if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
-			logger.Error().Err(err).Msg("Error parsing the response body")
-		} else {
-			// Print the response status and indexed document version.
-			logger.Debug().Str("status", res.Status()).Msgf(" %s; version=%d", r["result"], int(r["_version"].(float64)))
+// positionVersion derives a document version from a source position, growing
+// with the position within a source partition: the Kafka offset, the Cosmos DB
+// or PostgreSQL LSN, the MySQL binlog file and offset or the MongoDB cluster time
+func positionVersion(position map[string]interface{}) (int64, bool) {
+	if offset, ok := toInt64(position["offset"]); ok {
+		return offset, true
+	}
+
+	switch lsn := position["lsn"].(type) {
+	case string:
+		// PostgreSQL LSNs are printed as two hexadecimal halves, X/Y
+		high, low, found := strings.Cut(lsn, "/")
+		if found {
+			h, errHigh := strconv.ParseUint(high, 16, 32)
+			l, errLow := strconv.ParseUint(low, 16, 32)
+			if errHigh == nil && errLow == nil {
+				return int64(h<<32 | l), true
+			}
+		}
+		if n, err := strconv.ParseInt(lsn, 10, 64); err == nil {
+			return n, true
+		}
+	case nil:
+	default:
+		if n, ok := toInt64(lsn); ok {
+			return n, true
+		}
+	}
+
+	// Binlog files are numbered, e.g. mysql-bin.000042
+	if file, ok := position["binlog_file"].(string); ok {
+		pos, okPos := toInt64(position["binlog_pos"])
+		sequence, err := strconv.ParseInt(file[strings.LastIndex(file, ".")+1:], 10, 64)
+		if okPos && err == nil {
+			return sequence<<32 | pos, true
+		}
+	}
+
+	switch clusterTime := position["cluster_time"].(type) {
+	case map[string]interface{}:
+		t, okT := toInt64(clusterTime["t"])
+		i, okI := toInt64(clusterTime["i"])
+		if okT && okI {
+			return t<<32 | i, true
+		}
+	case nil:
+	default:
+		if n, ok := toInt64(clusterTime); ok {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+// documentID returns the configured id field of the document, else the
+// document key. Without either, inserts get an id from Elasticsearch.
+func (ee *ElasticEndpoint) documentID(document map[string]interface{}, documentKey []byte) (string, error) {
+	if ee.idField != "" {
+		if value, ok := esFieldValue(document, ee.idField); ok && value != nil {
+			return documentIDString(value), nil
+		}
+	}
+
+	if len(documentKey) > 0 {
+		key, err := decodeDocument(documentKey)
+		if err != nil {
+			return "", fmt.Errorf("failed to decode document key: %w", err)
+		}
+		if len(key) == 1 {
+			for _, value := range key {
+				return documentIDString(value), nil
+			}
 		}
+		// Composite keys become a stable JSON id
+		encoded, _ := json.Marshal(key)
+		return string(encoded), nil
 	}
+
+	for _, field := range []string{"_id", "id"} {
+		if value, ok := document[field]; ok && value != nil {
+			return documentIDString(value), nil
+		}
+	}
+	return "", nil
 }
 
-// ensureIndexExists creates the index if it doesn't exist
-func (ee *ElasticEndpoint) ensureIndexExists() {
-	// Check if index exists
-	req := esapi.IndicesExistsRequest{
-		Index: []string{ee.index},
+// putTemplate installs the configured index template. Mappings and settings
+// without a template body become a template matching the index pattern.
+func (ee *ElasticEndpoint) putTemplate(ctx context.Context, streamConfig *config.WaterFlowsConfig) error {
+	template := streamConfig.ElasticTemplate
+	if template == nil && streamConfig.ElasticMappings == nil && streamConfig.ElasticSettings == nil {
+		return nil
+	}
+
+	body := make(map[string]interface{}, len(template)+3)
+	for key, value := range template {
+		body[key] = value
+	}
+	if streamConfig.ElasticMappings != nil {
+		body["mappings"] = streamConfig.ElasticMappings
+	}
+	if streamConfig.ElasticSettings != nil {
+		body["settings"] = streamConfig.ElasticSettings
+	}
+	if _, ok := body["index_patterns"]; !ok {
+		body["index_patterns"] = []string{indexWildcard(ee.indexPattern)}
+	}
+
+	name := streamConfig.ElasticTemplateName
+	if name == "" {
+		name = "replicator"
+		if base := strings.Trim(esIndexPlaceholder.ReplaceAllString(ee.indexPattern, ""), "-_."); base != "" {
+			name += "-" + strings.ToLower(base)
+		}
+	}
+
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode index template: %w", err)
 	}
-	
-	res, err := req.Do(context.Background(), ee.es)
+	res, err := esapi.IndicesPutTemplateRequest{Name: name, Body: bytes.NewReader(encoded)}.Do(ctx, ee.es)
 	if err != nil {
-		logger.Error().Err(err).Msg("Error checking if index exists")
-		return
+		return fmt.Errorf("failed to put index template %s: %w", name, err)
 	}
 	defer res.Body.Close()
-	
-	// If index doesn't exist (404), create it
-	if res.StatusCode == 404 {
-		logger.Info().Str("index", ee.index).Msg("Creating Elasticsearch index")
-		
-		// Create index with generic mapping (single-node setup)
-		indexMapping := map[string]interface{}{
-			"settings": map[string]interface{}{
-				"number_of_shards":   1,
-				"number_of_replicas": 0, // No replicas for single-node setup
-			},
-			"mappings": map[string]interface{}{
-				"dynamic": true, // Allow dynamic field mapping
-				"properties": map[string]interface{}{
-					// Generic properties - Elasticsearch will auto-detect field types
-				},
-			},
-		}
-		
-		mappingJSON, _ := json.Marshal(indexMapping)
-		
-		createReq := esapi.IndicesCreateRequest{
-			Index: ee.index,
-			Body:  bytes.NewReader(mappingJSON),
-		}
-		
-		createRes, err := createReq.Do(context.Background(), ee.es)
+	if res.IsError() {
+		return fmt.Errorf("failed to put index template %s: %s", name, res.String())
+	}
+	logger.Info().Str("template", name).Interface("index_patterns", body["index_patterns"]).Msg("Installed Elasticsearch index template")
+	return nil
+}
+
+// Close releases the endpoint; writes are never buffered
+func (ee *ElasticEndpoint) Close() error {
+	return nil
+}
+
+// esBulkBody encodes actions as a bulk request body
+func esBulkBody(items []*esBulkItem) ([]byte, error) {
+	var body bytes.Buffer
+	for _, item := range items {
+		meta := map[string]interface{}{"_index": item.index}
+		if item.id != "" {
+			meta["_id"] = item.id
+		}
+		if item.version > 0 {
+			meta["version"] = item.version
+			meta["version_type"] = "external"
+		}
+		line, err := json.Marshal(map[string]interface{}{item.action: meta})
 		if err != nil {
-			logger.Error().Err(err).Msg("Error creating index")
-			return
+			return nil, fmt.Errorf("failed to encode bulk action: %w", err)
+		}
+		body.Write(line)
+		body.WriteByte('\n')
+		if len(item.document) > 0 {
+			body.Write(item.document)
+			body.WriteByte('\n')
+		}
+	}
+	return body.Bytes(), nil
+}
+
+// parseBulkResponse returns the failed actions of a bulk response. Deletes of
+// missing documents are not failures, nor are version conflicts: the index
+// already holds a newer version of the document.
+func parseBulkResponse(body io.Reader) ([]ElasticBulkFailure, error) {
+	var response struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Index  string `json:"_index"`
+			ID     string `json:"_id"`
+			Status int    `json:"status"`
+			Error  *struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to parse bulk response: %w", err)
+	}
+	if !response.Errors {
+		return nil, nil
+	}
+
+	var failures []ElasticBulkFailure
+	for _, item := range response.Items {
+		for action, result := range item {
+			if result.Status < 300 || (action == "delete" && result.Status == http.StatusNotFound) {
+				continue
+			}
+			if result.Status == http.StatusConflict && result.Error != nil && result.Error.Type == "version_conflict_engine_exception" {
+				logger.Debug().Str("index", result.Index).Str("id", result.ID).Msg("Skipped Elasticsearch action older than the indexed document")
+				continue
+			}
+			failure := ElasticBulkFailure{Action: action, Index: result.Index, ID: result.ID, Status: result.Status}
+			if result.Error != nil {
+				failure.Type, failure.Reason = result.Error.Type, result.Error.Reason
+			}
+			failures = append(failures, failure)
+		}
+	}
+	return failures, nil
+}
+
+// renderIndexName expands an index name pattern such as {collection}-{yyyy.MM}.
+// {collection} and {schema} come from the record, any other placeholder is a
+// date format of the write time using yyyy, MM, dd and HH.
+func renderIndexName(pattern string, record *events.RecordEvent, now time.Time) string {
+	name := esIndexPlaceholder.ReplaceAllStringFunc(pattern, func(placeholder string) string {
+		switch token := placeholder[1 : len(placeholder)-1]; token {
+		case "collection":
+			return record.Collection
+		case "schema":
+			return record.Schema
+		default:
+			layout := strings.NewReplacer("yyyy", "2006", "MM", "01", "dd", "02", "HH", "15").Replace(token)
+			return now.UTC().Format(layout)
+		}
+	})
+	// Index names must be lowercase
+	return strings.ToLower(name)
+}
+
+// indexWildcard turns an index name pattern into an index template pattern
+func indexWildcard(pattern string) string {
+	return strings.ToLower(esIndexPlaceholder.ReplaceAllString(pattern, "*"))
+}
+
+// esFieldValue reads a possibly dotted field of a document
+func esFieldValue(document map[string]interface{}, field string) (interface{}, bool) {
+	var current interface{} = document
+	for _, part := range strings.Split(field, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
 		}
-		defer createRes.Body.Close()
-		
-		if createRes.IsError() {
-			logger.Error().Str("status", createRes.Status()).Msg("Error response when creating index")
-		} else {
-			logger.Info().Str("index", ee.index).Msg("Successfully created Elasticsearch index")
+		if current, ok = object[part]; !ok {
+			return nil, false
 		}
-	} else if res.StatusCode == 200 {
-		logger.Debug().Str("index", ee.index).Msg("Index already exists")
 	}
+	return current, true
 }
@@ -0,0 +1,170 @@
+package estuary
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+)
+
+// Ping checks that a node of the cluster answers
+func (ee *ElasticEndpoint) Ping(ctx context.Context) error {
+	_, err := ee.perform(ctx, esapi.PingRequest{}, "")
+	return err
+}
+
+// ListTables lists the indices of the cluster, without hidden and system indices
+func (ee *ElasticEndpoint) ListTables(ctx context.Context) ([]string, error) {
+	body, err := ee.perform(ctx, esapi.CatIndicesRequest{Format: "json", H: []string{"index"}}, "")
+	if err != nil {
+		return nil, err
+	}
+	var indices []struct {
+		Index string `json:"index"`
+	}
+	if err := json.Unmarshal(body, &indices); err != nil {
+		return nil, fmt.Errorf("failed to decode indices: %w", err)
+	}
+	names := make([]string, 0, len(indices))
+	for _, index := range indices {
+		if !strings.HasPrefix(index.Index, ".") {
+			names = append(names, index.Index)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// GetSchema describes an index by the top-level fields of its mapping
+func (ee *ElasticEndpoint) GetSchema(ctx context.Context, tableName string) (*TableSchema, error) {
+	body, err := ee.perform(ctx, esapi.IndicesGetMappingRequest{Index: []string{tableName}}, tableName)
+	if err != nil {
+		return nil, err
+	}
+	var mappings map[string]struct {
+		Mappings struct {
+			Properties map[string]struct {
+				Type string `json:"type"`
+			} `json:"properties"`
+		} `json:"mappings"`
+	}
+	if err := json.Unmarshal(body, &mappings); err != nil {
+		return nil, fmt.Errorf("failed to decode mapping of %s: %w", tableName, err)
+	}
+
+	schema := &TableSchema{Name: tableName, PrimaryKey: []string{"_id"}}
+	for _, index := range mappings {
+		for _, name := range sortedKeys(index.Mappings.Properties) {
+			fieldType := index.Mappings.Properties[name].Type
+			if fieldType == "" {
+				fieldType = "object"
+			}
+			schema.Columns = append(schema.Columns, ColumnDefinition{Name: name, Type: fieldType, Nullable: true})
+		}
+		break
+	}
+	return schema, nil
+}
+
+// CreateTable creates an index with the columns mapped as fields
+func (ee *ElasticEndpoint) CreateTable(ctx context.Context, schema TableSchema) error {
+	body := map[string]interface{}{"mappings": esMappingProperties(schema.Columns)}
+	if settings, ok := schema.Metadata["settings"]; ok {
+		body["settings"] = settings
+	}
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode index %s: %w", schema.Name, err)
+	}
+	_, err = ee.perform(ctx, esapi.IndicesCreateRequest{Index: schema.Name, Body: bytes.NewReader(encoded)}, schema.Name)
+	return err
+}
+
+// DropTable deletes an index if it exists
+func (ee *ElasticEndpoint) DropTable(ctx context.Context, tableName string) error {
+	ignore := true
+	_, err := ee.perform(ctx, esapi.IndicesDeleteRequest{Index: []string{tableName}, IgnoreUnavailable: &ignore}, tableName)
+	return err
+}
+
+// TruncateTable deletes all documents of an index, keeping its mapping
+func (ee *ElasticEndpoint) TruncateTable(ctx context.Context, tableName string) error {
+	refresh := true
+	_, err := ee.perform(ctx, esapi.DeleteByQueryRequest{
+		Index:     []string{tableName},
+		Body:      strings.NewReader(`{"query":{"match_all":{}}}`),
+		Conflicts: "proceed",
+		Refresh:   &refresh,
+	}, tableName)
+	return err
+}
+
+// UpdateSchema adds the columns as fields to the mapping of an index
+func (ee *ElasticEndpoint) UpdateSchema(ctx context.Context, tableName string, schema TableSchema) error {
+	encoded, err := json.Marshal(esMappingProperties(schema.Columns))
+	if err != nil {
+		return fmt.Errorf("failed to encode mapping of %s: %w", tableName, err)
+	}
+	_, err = ee.perform(ctx, esapi.IndicesPutMappingRequest{Index: []string{tableName}, Body: bytes.NewReader(encoded)}, tableName)
+	return err
+}
+
+// perform runs a request, returning the response body or a classified error
+func (ee *ElasticEndpoint) perform(ctx context.Context, req esapi.Request, index string) ([]byte, error) {
+	res, err := req.Do(ctx, ee.es)
+	if err != nil {
+		return nil, classifyError(elasticDestination, index, err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, classifyError(elasticDestination, index, fmt.Errorf("failed to read response: %w", err))
+	}
+	if res.IsError() {
+		return nil, classifyHTTPStatus(elasticDestination, index, res.StatusCode, fmt.Errorf("request failed: %s %s", res.Status(), body))
+	}
+	return body, nil
+}
+
+// esMappingProperties maps columns to the properties of an index mapping.
+// Column types that are Elasticsearch field types are used as is, SQL types
+// are mapped to the closest field type.
+func esMappingProperties(columns []ColumnDefinition) map[string]interface{} {
+	properties := make(map[string]interface{}, len(columns))
+	for _, column := range columns {
+		properties[column.Name] = map[string]interface{}{"type": esFieldType(column.Type)}
+	}
+	return map[string]interface{}{"properties": properties}
+}
+
+func esFieldType(columnType string) string {
+	base := strings.ToLower(strings.TrimSpace(columnType))
+	if i := strings.IndexAny(base, "( "); i >= 0 {
+		base = base[:i]
+	}
+	switch base {
+	case "text", "keyword", "long", "integer", "short", "byte", "double", "float", "half_float",
+		"scaled_float", "boolean", "date", "object", "nested", "ip", "geo_point", "binary", "flattened":
+		return base
+	case "bigint", "int8", "serial", "bigserial":
+		return "long"
+	case "int", "int4", "mediumint", "smallint", "int2", "tinyint":
+		return "integer"
+	case "decimal", "numeric", "real", "double precision", "float8", "float4":
+		return "double"
+	case "bool":
+		return "boolean"
+	case "datetime", "timestamp", "timestamptz", "time":
+		return "date"
+	case "json", "jsonb":
+		return "object"
+	case "blob", "bytea", "varbinary":
+		return "binary"
+	}
+	return "keyword"
+}
@@ -0,0 +1,81 @@
+package estuary
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cohenjo/replicator/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeElasticCatalog serves index requests, recording their method, path and body
+type fakeElasticCatalog struct {
+	requests []string
+}
+
+func (f *fakeElasticCatalog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path+" "+string(body))
+
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.URL.Path == "/_cat/indices":
+		w.Write([]byte(`[{"index":"orders"},{"index":".kibana"},{"index":"customers"}]`))
+	case r.URL.Path == "/orders/_mapping" && r.Method == http.MethodGet:
+		w.Write([]byte(`{"orders":{"mappings":{"properties":{"total":{"type":"double"},"name":{"type":"keyword"},"meta":{"properties":{"sku":{"type":"keyword"}}}}}}}`))
+	case r.URL.Path == "/missing/_mapping":
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":{"type":"index_not_found_exception"},"status":404}`))
+	default:
+		w.Write([]byte(`{"acknowledged":true}`))
+	}
+}
+
+func TestElasticEndpoint_Catalog(t *testing.T) {
+	fake := &fakeElasticCatalog{}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	transport, err := newElasticTransport(&config.WaterFlowsConfig{ElasticAddresses: []string{server.URL}})
+	require.NoError(t, err)
+	endpoint := newElasticEndpoint(transport, &config.WaterFlowsConfig{Collection: "orders"})
+	ctx := context.Background()
+
+	tables, err := endpoint.ListTables(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"customers", "orders"}, tables)
+
+	schema, err := endpoint.GetSchema(ctx, "orders")
+	require.NoError(t, err)
+	assert.Equal(t, []ColumnDefinition{
+		{Name: "meta", Type: "object", Nullable: true},
+		{Name: "name", Type: "keyword", Nullable: true},
+		{Name: "total", Type: "double", Nullable: true},
+	}, schema.Columns)
+
+	_, err = endpoint.GetSchema(ctx, "missing")
+	var destErr *DestinationError
+	require.True(t, errors.As(err, &destErr))
+	assert.Equal(t, ErrCodeTableNotFound, destErr.Code)
+
+	fake.requests = nil
+	require.NoError(t, endpoint.CreateTable(ctx, TableSchema{Name: "items", Columns: []ColumnDefinition{
+		{Name: "id", Type: "BIGINT"},
+		{Name: "price", Type: "decimal(10,2)"},
+		{Name: "created", Type: "timestamp"},
+		{Name: "tags", Type: "keyword"},
+	}}))
+	require.NoError(t, endpoint.UpdateSchema(ctx, "items", TableSchema{Columns: []ColumnDefinition{{Name: "active", Type: "bool"}}}))
+	require.NoError(t, endpoint.TruncateTable(ctx, "items"))
+	require.NoError(t, endpoint.DropTable(ctx, "items"))
+	assert.Equal(t, []string{
+		`PUT /items {"mappings":{"properties":{"created":{"type":"date"},"id":{"type":"long"},"price":{"type":"double"},"tags":{"type":"keyword"}}}}`,
+		`PUT /items/_mapping {"properties":{"active":{"type":"boolean"}}}`,
+		`POST /items/_delete_by_query {"query":{"match_all":{}}}`,
+		`DELETE /items `,
+	}, fake.requests)
+}
@@ -1,10 +1,19 @@
 package estuary
 
 import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/cohenjo/replicator/pkg/config"
 	"github.com/cohenjo/replicator/pkg/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestIndex(t *testing.T) {
@@ -18,15 +27,264 @@ func TestIndex(t *testing.T) {
 		Host: "localhost",
 		Port: 9200,
 	}
-	ee := NewElasticEndpoint(testConfig)
+	ee, err := NewElasticEndpoint(testConfig)
+	if err != nil {
+		t.Fatalf("failed to create endpoint: %v", err)
+	}
+	defer ee.Close()
 
 	record := &events.RecordEvent{
 		Action: "insert",
 		Data:   []byte(`{"id":6,"output":"hello world"}`),
 	}
 
-	ee.WriteEvent(record)
+	if err := ee.Write(context.Background(), record); err != nil {
+		t.Logf("write failed: %v", err)
+	}
 
 	t.Logf("Finished listenening - look at your terminal ")
 
 }
+
+// fakeElastic serves bulk and template requests, recording their bodies
+type fakeElastic struct {
+	mu        sync.Mutex
+	bulks     []string
+	refreshes []string
+	templates map[string]string
+	response  string // bulk response, a success for every action when empty
+}
+
+func (f *fakeElastic) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.URL.Path == "/_bulk":
+		f.bulks = append(f.bulks, string(body))
+		f.refreshes = append(f.refreshes, r.URL.Query().Get("refresh"))
+		if f.response != "" {
+			w.Write([]byte(f.response))
+			return
+		}
+		w.Write([]byte(`{"errors":false,"items":[]}`))
+	case strings.HasPrefix(r.URL.Path, "/_template/"):
+		if f.templates == nil {
+			f.templates = make(map[string]string)
+		}
+		f.templates[strings.TrimPrefix(r.URL.Path, "/_template/")] = string(body)
+		w.Write([]byte(`{"acknowledged":true}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestElasticEndpoint(t *testing.T, streamConfig *config.WaterFlowsConfig) (*ElasticEndpoint, *fakeElastic) {
+	fake := &fakeElastic{}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	transport, err := newElasticTransport(&config.WaterFlowsConfig{ElasticAddresses: []string{server.URL}})
+	require.NoError(t, err)
+	return newElasticEndpoint(transport, streamConfig), fake
+}
+
+func TestElasticEndpoint_BulkWrites(t *testing.T) {
+	endpoint, fake := newTestElasticEndpoint(t, &config.WaterFlowsConfig{Collection: "{schema}_{collection}", ElasticRefresh: "wait_for"})
+
+	require.NoError(t, endpoint.WriteBatch(context.Background(), []*events.RecordEvent{
+		{Action: events.InsertAction, Schema: "Shop", Collection: "orders", DocumentKey: []byte(`{"_id":{"$oid":"64b7f0c2a1b2c3d4e5f60718"}}`), Data: []byte(`{"name":"a","qty":1}`)},
+		{Action: events.UpdateAction, Schema: "Shop", Collection: "orders", DocumentKey: []byte(`{"order_id":7,"line":2}`), Data: []byte(`{"qty":2}`)},
+		{Action: events.DeleteAction, Schema: "Shop", Collection: "orders", OldData: []byte(`{"id":9,"qty":3}`)},
+		{Action: events.InsertAction, Schema: "Shop", Collection: "orders", Data: []byte(`{"qty":4}`)},
+	}))
+
+	require.Len(t, fake.bulks, 1)
+	assert.Equal(t, "wait_for", fake.refreshes[0])
+	assert.Equal(t, `{"index":{"_id":"64b7f0c2a1b2c3d4e5f60718","_index":"shop_orders"}}
+{"name":"a","qty":1}
+{"update":{"_id":"{\"line\":2,\"order_id\":7}","_index":"shop_orders"}}
+{"doc":{"qty":2},"doc_as_upsert":true}
+{"delete":{"_id":"9","_index":"shop_orders"}}
+{"index":{"_index":"shop_orders"}}
+{"qty":4}
+`, fake.bulks[0])
+
+	// Updates and deletes need an id
+	err := endpoint.WriteBatch(context.Background(), []*events.RecordEvent{{Action: events.DeleteAction, Collection: "orders"}})
+	assert.Error(t, err)
+	// Documents must be objects
+	err = endpoint.WriteBatch(context.Background(), []*events.RecordEvent{{Action: events.InsertAction, Collection: "orders", Data: []byte(`[1,"a"]`)}})
+	assert.Error(t, err)
+}
+
+func TestElasticEndpoint_ConfiguredIDField(t *testing.T) {
+	endpoint, fake := newTestElasticEndpoint(t, &config.WaterFlowsConfig{Collection: "orders", ElasticIDField: "meta.sku"})
+
+	require.NoError(t, endpoint.WriteBatch(context.Background(), []*events.RecordEvent{
+		{Action: events.InsertAction, DocumentKey: []byte(`{"id":1}`), Data: []byte(`{"id":1,"meta":{"sku":"A-1"}}`)},
+		{Action: events.InsertAction, DocumentKey: []byte(`{"id":2}`), Data: []byte(`{"id":2}`)},
+	}))
+	require.Len(t, fake.bulks, 1)
+	assert.Contains(t, fake.bulks[0], `{"index":{"_id":"A-1","_index":"orders"}}`)
+	// Falls back to the document key
+	assert.Contains(t, fake.bulks[0], `{"index":{"_id":"2","_index":"orders"}}`)
+}
+
+func TestElasticEndpoint_BulkThresholds(t *testing.T) {
+	endpoint, fake := newTestElasticEndpoint(t, &config.WaterFlowsConfig{Collection: "orders", ElasticBulkActions: 2})
+
+	record := &events.RecordEvent{Action: events.InsertAction, Data: []byte(`{"id":1}`)}
+	require.NoError(t, endpoint.Write(context.Background(), record))
+	require.Len(t, fake.bulks, 1, "a single write is sent at once")
+
+	require.NoError(t, endpoint.WriteBatch(context.Background(), []*events.RecordEvent{record, record, record}))
+	require.Len(t, fake.bulks, 3, "batches are split at the action threshold")
+	assert.Equal(t, 2, strings.Count(fake.bulks[1], "\n")/2)
+	assert.Equal(t, 1, strings.Count(fake.bulks[2], "\n")/2)
+}
+
+func TestElasticEndpoint_BulkFailures(t *testing.T) {
+	endpoint, fake := newTestElasticEndpoint(t, &config.WaterFlowsConfig{Collection: "orders"})
+	fake.response = `{"errors":true,"items":[
+		{"index":{"_index":"orders","_id":"1","status":201}},
+		{"index":{"_index":"orders","_id":"2","status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse field [qty]"}}},
+		{"delete":{"_index":"orders","_id":"3","status":404}}
+	]}`
+
+	err := endpoint.WriteBatch(context.Background(), []*events.RecordEvent{
+		{Action: events.InsertAction, Data: []byte(`{"id":1}`)},
+		{Action: events.InsertAction, Data: []byte(`{"id":2,"qty":"x"}`)},
+		{Action: events.DeleteAction, DocumentKey: []byte(`{"id":3}`)},
+	})
+	var bulkErr *ElasticBulkError
+	require.ErrorAs(t, err, &bulkErr)
+	assert.False(t, IsRetryable(err), "mapping errors fail again on retry")
+	// Deleting a missing document is not a failure
+	assert.Equal(t, []ElasticBulkFailure{{
+		Action: "index", Index: "orders", ID: "2", Status: 400,
+		Type: "mapper_parsing_exception", Reason: "failed to parse field [qty]",
+	}}, bulkErr.Failures)
+}
+
+func TestElasticEndpoint_ErrorClassification(t *testing.T) {
+	endpoint, fake := newTestElasticEndpoint(t, &config.WaterFlowsConfig{Collection: "orders"})
+	record := &events.RecordEvent{Action: events.InsertAction, Data: []byte(`{"id":1}`)}
+
+	// A rejected action fails the write for good, a throttled one is retried
+	fake.response = `{"errors":true,"items":[
+		{"index":{"_index":"orders","_id":"1","status":400,"error":{"type":"mapper_parsing_exception"}}},
+		{"index":{"_index":"orders","_id":"2","status":429,"error":{"type":"es_rejected_execution_exception"}}}
+	]}`
+	err := endpoint.WriteBatch(context.Background(), []*events.RecordEvent{record, record})
+	var destErr *DestinationError
+	require.ErrorAs(t, err, &destErr)
+	assert.Equal(t, ErrCodeThrottled, destErr.Code)
+	assert.True(t, IsRetryable(err))
+
+	// Unparsable documents are rejected before they are sent
+	err = endpoint.Write(context.Background(), &events.RecordEvent{Action: events.InsertAction, Data: []byte(`[1]`)})
+	require.ErrorAs(t, err, &destErr)
+	assert.Equal(t, ErrCodeInvalidRecord, destErr.Code)
+
+	// Unreachable clusters are retryable
+	transport, err := newElasticTransport(&config.WaterFlowsConfig{ElasticAddresses: []string{"http://127.0.0.1:1"}})
+	require.NoError(t, err)
+	endpoint = newElasticEndpoint(transport, &config.WaterFlowsConfig{Collection: "orders"})
+	err = endpoint.Write(context.Background(), record)
+	require.Error(t, err)
+	assert.True(t, IsRetryable(err))
+}
+
+func TestElasticEndpoint_Template(t *testing.T) {
+	streamConfig := &config.WaterFlowsConfig{
+		Collection:      "{collection}-{yyyy.MM}",
+		ElasticMappings: map[string]interface{}{"properties": map[string]interface{}{"qty": map[string]interface{}{"type": "long"}}},
+		ElasticSettings: map[string]interface{}{"number_of_shards": 1},
+	}
+	endpoint, fake := newTestElasticEndpoint(t, streamConfig)
+
+	require.NoError(t, endpoint.putTemplate(context.Background(), streamConfig))
+	assert.JSONEq(t,
+		`{"index_patterns":["*-*"],"mappings":{"properties":{"qty":{"type":"long"}}},"settings":{"number_of_shards":1}}`,
+		fake.templates["replicator"])
+
+	// A template body keeps its own patterns
+	streamConfig = &config.WaterFlowsConfig{
+		Collection:          "orders-{yyyy}",
+		ElasticTemplateName: "orders",
+		ElasticTemplate:     map[string]interface{}{"index_patterns": []string{"orders-2*"}, "order": 1},
+	}
+	require.NoError(t, endpoint.putTemplate(context.Background(), streamConfig))
+	assert.JSONEq(t, `{"index_patterns":["orders-2*"],"order":1}`, fake.templates["orders"])
+}
+
+func TestElasticEndpoint_ExternalVersion(t *testing.T) {
+	endpoint, fake := newTestElasticEndpoint(t, &config.WaterFlowsConfig{Collection: "orders", ElasticExternalVersion: true})
+	fake.response = `{"errors":true,"items":[
+		{"index":{"_index":"orders","_id":"1","status":201}},
+		{"index":{"_index":"orders","_id":"1","status":409,"error":{"type":"version_conflict_engine_exception","reason":"version conflict"}}},
+		{"delete":{"_index":"orders","_id":"1","status":200}}
+	]}`
+
+	position := func(offset int64) map[string]interface{} {
+		return map[string]interface{}{"topic": "orders", "partition": int32(0), "offset": offset}
+	}
+	require.NoError(t, endpoint.WriteBatch(context.Background(), []*events.RecordEvent{
+		{Action: events.InsertAction, DocumentKey: []byte(`{"id":1}`), Data: []byte(`{"id":1,"qty":1}`), Position: position(10)},
+		{Action: events.UpdateAction, DocumentKey: []byte(`{"id":1}`), Data: []byte(`{"id":1,"qty":2}`), Position: position(11)},
+		{Action: events.DeleteAction, DocumentKey: []byte(`{"id":1}`), Position: position(12)},
+	}), "version conflicts mean a newer document is indexed")
+
+	require.Len(t, fake.bulks, 1)
+	// Updates index the whole document, as the update action cannot be versioned
+	assert.Equal(t, `{"index":{"_id":"1","_index":"orders","version":10,"version_type":"external"}}
+{"id":1,"qty":1}
+{"index":{"_id":"1","_index":"orders","version":11,"version_type":"external"}}
+{"id":1,"qty":2}
+{"delete":{"_id":"1","_index":"orders","version":12,"version_type":"external"}}
+`, fake.bulks[0])
+
+	// Versioned writes need a position and a document id
+	err := endpoint.WriteBatch(context.Background(), []*events.RecordEvent{{Action: events.InsertAction, DocumentKey: []byte(`{"id":1}`), Data: []byte(`{"id":1}`)}})
+	assert.Error(t, err)
+	err = endpoint.WriteBatch(context.Background(), []*events.RecordEvent{{Action: events.InsertAction, Data: []byte(`{"qty":1}`), Position: position(13)}})
+	assert.Error(t, err)
+}
+
+func TestPositionVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		position map[string]interface{}
+		version  int64
+		ok       bool
+	}{
+		{"kafka offset", map[string]interface{}{"topic": "t", "partition": int32(1), "offset": int64(42)}, 42, true},
+		{"cosmos lsn", map[string]interface{}{"feed_range": "r", "lsn": int64(7)}, 7, true},
+		{"postgresql lsn", map[string]interface{}{"lsn": "16/B374D848"}, 0x16<<32 | 0xB374D848, true},
+		{"mysql binlog", map[string]interface{}{"binlog_file": "mysql-bin.000042", "binlog_pos": uint32(1234)}, 42<<32 | 1234, true},
+		{"mongodb cluster time", map[string]interface{}{"cluster_time": map[string]interface{}{"t": 1700000000, "i": 3}}, 1700000000<<32 | 3, true},
+		{"json round trip", map[string]interface{}{"offset": float64(9)}, 9, true},
+		{"unknown", map[string]interface{}{"cursor": "abc"}, 0, false},
+		{"none", nil, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, ok := positionVersion(tt.position)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.version, version)
+		})
+	}
+}
+
+func TestRenderIndexName(t *testing.T) {
+	now := time.Date(2024, 3, 7, 15, 0, 0, 0, time.UTC)
+	record := &events.RecordEvent{Schema: "Shop", Collection: "Orders"}
+
+	assert.Equal(t, "orders-2024.03", renderIndexName("{collection}-{yyyy.MM}", record, now))
+	assert.Equal(t, "shop.orders-2024-03-07t15", renderIndexName("{schema}.{collection}-{yyyy-MM-dd}T{HH}", record, now))
+	assert.Equal(t, "fixed", renderIndexName("fixed", record, now))
+	assert.Equal(t, "orders-*", indexWildcard("orders-{yyyy.MM}"))
+}
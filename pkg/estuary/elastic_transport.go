@@ -0,0 +1,300 @@
+package estuary
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cohenjo/replicator/pkg/config"
+)
+
+// Elasticsearch flavours
+const (
+	esFlavourElasticsearch = "elasticsearch"
+	esFlavourOpenSearch    = "opensearch"
+)
+
+// esClusterVersion is the product and major version of the target cluster
+type esClusterVersion struct {
+	flavour string
+	major   int
+	number  string
+}
+
+// String implements fmt.Stringer
+func (v esClusterVersion) String() string {
+	if v.number != "" {
+		return v.flavour + " " + v.number
+	}
+	return fmt.Sprintf("%s %d", v.flavour, v.major)
+}
+
+// elasticTransport performs Elasticsearch requests against a list of nodes.
+// Requests rotate between the nodes and fail over to the next one on
+// connection errors. It implements esapi.Transport.
+type elasticTransport struct {
+	urls           []*url.URL
+	client         *http.Client
+	username       string
+	password       string
+	apiKey         string // encoded, as sent in the Authorization header
+	compatibleWith int    // major version of the compatibility headers, 0 to send none
+	next           uint32
+}
+
+// newElasticTransport creates the transport of an Elasticsearch target. The
+// nodes are the configured addresses, else the target host and port; the
+// scheme defaults to https when TLS settings are configured.
+func newElasticTransport(streamConfig *config.WaterFlowsConfig) (*elasticTransport, error) {
+	tlsConfig, err := esTLSConfig(streamConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
+	}
+	addresses := streamConfig.ElasticAddresses
+	if len(addresses) == 0 {
+		port := streamConfig.Port
+		if port == 0 {
+			port = 9200
+		}
+		addresses = []string{fmt.Sprintf("%s:%d", streamConfig.Host, port)}
+	}
+
+	t := &elasticTransport{
+		username: streamConfig.ElasticUsername,
+		password: streamConfig.ElasticPassword,
+		apiKey:   esEncodeAPIKey(streamConfig.ElasticAPIKey),
+	}
+	for _, address := range addresses {
+		address = strings.TrimRight(strings.TrimSpace(address), "/")
+		if !strings.Contains(address, "://") {
+			address = scheme + "://" + address
+		}
+		u, err := url.Parse(address)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid Elasticsearch address %q", address)
+		}
+		t.urls = append(t.urls, u)
+	}
+
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		MaxIdleConnsPerHost:   10,
+		ResponseHeaderTimeout: 10 * time.Second,
+		DialContext:           (&net.Dialer{Timeout: 5 * time.Second}).DialContext,
+		TLSClientConfig:       tlsConfig,
+	}
+	t.client = &http.Client{Transport: transport}
+	return t, nil
+}
+
+// esTLSConfig builds the TLS settings of an Elasticsearch target, nil when none are configured
+func esTLSConfig(streamConfig *config.WaterFlowsConfig) (*tls.Config, error) {
+	if streamConfig.ElasticCACert == "" && streamConfig.ElasticClientCert == "" && !streamConfig.ElasticInsecureSkipVerify {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: streamConfig.ElasticInsecureSkipVerify,
+	}
+	if streamConfig.ElasticCACert != "" {
+		pem, err := os.ReadFile(streamConfig.ElasticCACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Elasticsearch CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in Elasticsearch CA file %s", streamConfig.ElasticCACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if streamConfig.ElasticClientCert != "" || streamConfig.ElasticClientKey != "" {
+		if streamConfig.ElasticClientCert == "" || streamConfig.ElasticClientKey == "" {
+			return nil, fmt.Errorf("elasticsearch client certificate and key must be configured together")
+		}
+		certificate, err := tls.LoadX509KeyPair(streamConfig.ElasticClientCert, streamConfig.ElasticClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load Elasticsearch client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig, nil
+}
+
+// esEncodeAPIKey returns the Authorization value of an API key, which may be
+// given encoded or as id:key
+func esEncodeAPIKey(apiKey string) string {
+	if apiKey == "" {
+		return ""
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(apiKey); err == nil && strings.Contains(string(decoded), ":") {
+		return apiKey
+	}
+	return base64.StdEncoding.EncodeToString([]byte(apiKey))
+}
+
+// Perform sends a request to the next node, trying the others when a node
+// cannot be reached
+func (t *elasticTransport) Perform(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+		req.Body.Close()
+	}
+	t.setHeaders(req)
+
+	start := atomic.AddUint32(&t.next, 1) - 1
+	var lastErr error
+	for i := range t.urls {
+		node := t.urls[(int(start)+i)%len(t.urls)]
+
+		attempt := req.Clone(req.Context())
+		attempt.URL = esNodeURL(node, req.URL)
+		attempt.Host = node.Host
+		if body != nil {
+			attempt.Body = io.NopCloser(bytes.NewReader(body))
+			attempt.ContentLength = int64(len(body))
+		}
+
+		res, err := t.client.Do(attempt)
+		if err == nil {
+			return res, nil
+		}
+		if req.Context().Err() != nil {
+			return nil, err
+		}
+		lastErr = err
+		logger.Warn().Err(err).Str("node", node.Host).Msg("Elasticsearch node unreachable, trying the next node")
+	}
+	return nil, fmt.Errorf("all Elasticsearch nodes failed: %w", lastErr)
+}
+
+// setHeaders sets the credentials and content headers of a request
+func (t *elasticTransport) setHeaders(req *http.Request) {
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	switch {
+	case t.apiKey != "":
+		req.Header.Set("Authorization", "ApiKey "+t.apiKey)
+	case t.username != "":
+		req.SetBasicAuth(t.username, t.password)
+	}
+
+	contentType := "json"
+	if strings.HasSuffix(req.URL.Path, "/_bulk") {
+		contentType = "x-ndjson"
+	}
+	if t.compatibleWith > 0 {
+		compatible := "; compatible-with=" + strconv.Itoa(t.compatibleWith)
+		req.Header.Set("Accept", "application/vnd.elasticsearch+json"+compatible)
+		if req.Body != nil {
+			req.Header.Set("Content-Type", "application/vnd.elasticsearch+"+contentType+compatible)
+		}
+		return
+	}
+	if req.Body != nil {
+		req.Header.Set("Content-Type", "application/"+contentType)
+	}
+}
+
+// esNodeURL resolves the path and query of a request against a node, keeping
+// any path prefix of the node address
+func esNodeURL(node *url.URL, request *url.URL) *url.URL {
+	u := *node
+	u.Path = strings.TrimRight(node.Path, "/") + request.Path
+	u.RawQuery = request.RawQuery
+	return &u
+}
+
+// detectVersion reads the product and version of the cluster from its root endpoint
+func (t *elasticTransport) detectVersion(ctx context.Context) (esClusterVersion, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+	if err != nil {
+		return esClusterVersion{}, err
+	}
+	res, err := t.Perform(req)
+	if err != nil {
+		return esClusterVersion{}, fmt.Errorf("failed to read Elasticsearch version: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return esClusterVersion{}, fmt.Errorf("failed to read Elasticsearch version: %s", res.Status)
+	}
+
+	var info struct {
+		Version struct {
+			Number       string `json:"number"`
+			Distribution string `json:"distribution"`
+		} `json:"version"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&info); err != nil {
+		return esClusterVersion{}, fmt.Errorf("failed to parse Elasticsearch version: %w", err)
+	}
+
+	version := esClusterVersion{flavour: esFlavourElasticsearch, number: info.Version.Number}
+	if info.Version.Distribution == esFlavourOpenSearch {
+		version.flavour = esFlavourOpenSearch
+	}
+	major, _, _ := strings.Cut(info.Version.Number, ".")
+	if version.major, err = strconv.Atoi(major); err != nil {
+		return esClusterVersion{}, fmt.Errorf("unexpected Elasticsearch version %q", info.Version.Number)
+	}
+	return version, nil
+}
+
+// parseElasticVersion parses a configured version: 7, 8, 8.x or opensearch
+func parseElasticVersion(value string) (esClusterVersion, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if strings.HasPrefix(value, esFlavourOpenSearch) {
+		version := esClusterVersion{flavour: esFlavourOpenSearch, major: 2}
+		if number := strings.TrimLeft(strings.TrimPrefix(value, esFlavourOpenSearch), " -"); number != "" {
+			major, _, _ := strings.Cut(number, ".")
+			n, err := strconv.Atoi(major)
+			if err != nil {
+				return esClusterVersion{}, fmt.Errorf("invalid Elasticsearch version %q", value)
+			}
+			version.major, version.number = n, number
+		}
+		return version, nil
+	}
+
+	major, _, _ := strings.Cut(value, ".")
+	n, err := strconv.Atoi(major)
+	if err != nil || n < 6 {
+		return esClusterVersion{}, fmt.Errorf("invalid Elasticsearch version %q", value)
+	}
+	return esClusterVersion{flavour: esFlavourElasticsearch, major: n}, nil
+}
+
+// defaultCompatibleWith returns the compatibility headers version of a
+// cluster. The request builders come from the 7 client, so Elasticsearch 8
+// and later get requests compatible with the previous major; Elasticsearch 7
+// and OpenSearch get no headers.
+func defaultCompatibleWith(version esClusterVersion) int {
+	if version.flavour == esFlavourElasticsearch && version.major >= 8 {
+		return version.major - 1
+	}
+	return 0
+}
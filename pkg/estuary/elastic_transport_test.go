@@ -0,0 +1,160 @@
+package estuary
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/cohenjo/replicator/pkg/config"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// headerRecorder answers every request with a root info body, recording the request headers
+type headerRecorder struct {
+	mu      sync.Mutex
+	headers []http.Header
+	body    string
+}
+
+func (h *headerRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	h.headers = append(h.headers, r.Header.Clone())
+	h.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(h.body))
+}
+
+func TestElasticTransport_Failover(t *testing.T) {
+	recorder := &headerRecorder{body: `{"errors":false,"items":[]}`}
+	live := httptest.NewServer(recorder)
+	defer live.Close()
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	transport, err := newElasticTransport(&config.WaterFlowsConfig{
+		ElasticAddresses: []string{dead.URL, live.URL},
+		ElasticUsername:  "elastic",
+		ElasticPassword:  "changeme",
+	})
+	require.NoError(t, err)
+
+	// Every request reaches the live node, whichever node it starts with
+	for i := 0; i < 2; i++ {
+		res, err := esapi.BulkRequest{Body: bytes.NewReader([]byte("{\"delete\":{\"_index\":\"a\",\"_id\":\"1\"}}\n"))}.Do(context.Background(), transport)
+		require.NoError(t, err)
+		res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}
+
+	require.Len(t, recorder.headers, 2)
+	assert.Equal(t, "Basic "+base64.StdEncoding.EncodeToString([]byte("elastic:changeme")), recorder.headers[0].Get("Authorization"))
+	assert.Equal(t, "application/x-ndjson", recorder.headers[0].Get("Content-Type"))
+
+	// Without a live node the last error is returned
+	transport, err = newElasticTransport(&config.WaterFlowsConfig{ElasticAddresses: []string{dead.URL}})
+	require.NoError(t, err)
+	_, err = esapi.BulkRequest{Body: bytes.NewReader([]byte("{}\n"))}.Do(context.Background(), transport)
+	assert.Error(t, err)
+}
+
+func TestElasticTransport_Headers(t *testing.T) {
+	recorder := &headerRecorder{body: `{}`}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	transport, err := newElasticTransport(&config.WaterFlowsConfig{ElasticAddresses: []string{server.URL}, ElasticAPIKey: "id:secret"})
+	require.NoError(t, err)
+	transport.compatibleWith = 7
+
+	res, err := esapi.BulkRequest{Body: bytes.NewReader([]byte("{}\n"))}.Do(context.Background(), transport)
+	require.NoError(t, err)
+	res.Body.Close()
+	res, err = esapi.IndicesPutTemplateRequest{Name: "t", Body: bytes.NewReader([]byte("{}"))}.Do(context.Background(), transport)
+	require.NoError(t, err)
+	res.Body.Close()
+
+	require.Len(t, recorder.headers, 2)
+	assert.Equal(t, "ApiKey "+base64.StdEncoding.EncodeToString([]byte("id:secret")), recorder.headers[0].Get("Authorization"))
+	assert.Equal(t, "application/vnd.elasticsearch+json; compatible-with=7", recorder.headers[0].Get("Accept"))
+	assert.Equal(t, "application/vnd.elasticsearch+x-ndjson; compatible-with=7", recorder.headers[0].Get("Content-Type"))
+	assert.Equal(t, "application/vnd.elasticsearch+json; compatible-with=7", recorder.headers[1].Get("Content-Type"))
+}
+
+func TestElasticTransport_TLS(t *testing.T) {
+	server := httptest.NewTLSServer(&headerRecorder{body: `{"version":{"number":"8.13.4","build_flavor":"default"}}`})
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600))
+
+	// Addresses without a scheme default to https when TLS is configured
+	transport, err := newElasticTransport(&config.WaterFlowsConfig{ElasticAddresses: []string{server.Listener.Addr().String()}, ElasticCACert: caFile})
+	require.NoError(t, err)
+	assert.Equal(t, "https", transport.urls[0].Scheme)
+
+	version, err := transport.detectVersion(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, esClusterVersion{flavour: esFlavourElasticsearch, major: 8, number: "8.13.4"}, version)
+
+	// The server certificate is not trusted without the CA
+	transport, err = newElasticTransport(&config.WaterFlowsConfig{ElasticAddresses: []string{server.URL}})
+	require.NoError(t, err)
+	_, err = transport.detectVersion(context.Background())
+	assert.Error(t, err)
+
+	_, err = newElasticTransport(&config.WaterFlowsConfig{ElasticCACert: filepath.Join(t.TempDir(), "missing.pem")})
+	assert.Error(t, err)
+	_, err = newElasticTransport(&config.WaterFlowsConfig{ElasticClientCert: "client.pem"})
+	assert.Error(t, err, "a client certificate needs its key")
+}
+
+func TestElasticTransport_DetectVersion(t *testing.T) {
+	server := httptest.NewServer(&headerRecorder{body: `{"version":{"distribution":"opensearch","number":"2.11.0"}}`})
+	defer server.Close()
+
+	transport, err := newElasticTransport(&config.WaterFlowsConfig{ElasticAddresses: []string{server.URL}})
+	require.NoError(t, err)
+	version, err := transport.detectVersion(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, esClusterVersion{flavour: esFlavourOpenSearch, major: 2, number: "2.11.0"}, version)
+	assert.Equal(t, 0, defaultCompatibleWith(version))
+}
+
+func TestParseElasticVersion(t *testing.T) {
+	version, err := parseElasticVersion("8")
+	require.NoError(t, err)
+	assert.Equal(t, esClusterVersion{flavour: esFlavourElasticsearch, major: 8}, version)
+	assert.Equal(t, 7, defaultCompatibleWith(version))
+
+	version, err = parseElasticVersion("7.17")
+	require.NoError(t, err)
+	assert.Equal(t, 0, defaultCompatibleWith(version))
+
+	version, err = parseElasticVersion("OpenSearch")
+	require.NoError(t, err)
+	assert.Equal(t, esFlavourOpenSearch, version.flavour)
+
+	version, err = parseElasticVersion("opensearch-1.3")
+	require.NoError(t, err)
+	assert.Equal(t, 1, version.major)
+
+	_, err = parseElasticVersion("five")
+	assert.Error(t, err)
+}
+
+func TestEsEncodeAPIKey(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString([]byte("id:secret"))
+	assert.Equal(t, encoded, esEncodeAPIKey("id:secret"))
+	// Encoded keys are sent as is
+	assert.Equal(t, encoded, esEncodeAPIKey(encoded))
+	assert.Empty(t, esEncodeAPIKey(""))
+}
@@ -0,0 +1,73 @@
+package estuary
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// newDestinationError creates a write error with a specific code
+func newDestinationError(code, destination, table, message string, cause error) *DestinationError {
+	err := &DestinationError{
+		Code:        code,
+		Message:     message,
+		Operation:   "write",
+		Destination: destination,
+		Timestamp:   time.Now(),
+		Cause:       cause,
+	}
+	if table != "" {
+		err.Details = map[string]interface{}{"table": table}
+	}
+	return err
+}
+
+// classifyError wraps a write error in a DestinationError, classifying the
+// errors every destination shares: cancellation, timeouts and lost
+// connections. Destination errors are returned as is and anything else is a
+// retryable write failure.
+func classifyError(destination, table string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var destErr *DestinationError
+	if errors.As(err, &destErr) {
+		return err
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		// The write was abandoned, not rejected
+		return newDestinationError(ErrCodeWriteFailed, destination, table, "write cancelled", err)
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return newDestinationError(ErrCodeTimeout, destination, table, "write timed out", err)
+	case errors.As(err, &netErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return NewConnectionError(destination, "connection lost", err)
+	}
+	return NewWriteError(destination, table, "write failed", err)
+}
+
+// classifyHTTPStatus classifies a failed HTTP response status
+func classifyHTTPStatus(destination, table string, status int, err error) error {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return newDestinationError(ErrCodeThrottled, destination, table, "write throttled", err)
+	case status == http.StatusRequestTimeout || status == http.StatusGatewayTimeout:
+		return newDestinationError(ErrCodeTimeout, destination, table, "write timed out", err)
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return newDestinationError(ErrCodeInvalidConfig, destination, table, "write not authorized", err)
+	case status == http.StatusNotFound:
+		return newDestinationError(ErrCodeTableNotFound, destination, table, "write target not found", err)
+	case status >= 500:
+		return NewWriteError(destination, table, "write failed", err)
+	case status >= 400:
+		return NewRecordError(destination, table, "write rejected", err)
+	}
+	return classifyError(destination, table, err)
+}
@@ -0,0 +1,66 @@
+package estuary
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		code      string
+		retryable bool
+	}{
+		{"deadline", fmt.Errorf("write: %w", context.DeadlineExceeded), ErrCodeTimeout, true},
+		{"cancelled", context.Canceled, ErrCodeWriteFailed, false},
+		{"connection refused", fmt.Errorf("dial: %w", syscall.ECONNREFUSED), ErrCodeConnectionFailed, true},
+		{"closed connection", io.ErrUnexpectedEOF, ErrCodeConnectionFailed, true},
+		{"other", errors.New("boom"), ErrCodeWriteFailed, true},
+		{"classified", NewRecordError("test", "t", "rejected", nil), ErrCodeInvalidRecord, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := classifyError("test", "t", tt.err)
+			var destErr *DestinationError
+			require.ErrorAs(t, err, &destErr)
+			assert.Equal(t, tt.code, destErr.Code)
+			assert.Equal(t, tt.retryable, IsRetryable(err))
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+	assert.NoError(t, classifyError("test", "t", nil))
+}
+
+func TestClassifyHTTPStatus(t *testing.T) {
+	tests := []struct {
+		status    int
+		code      string
+		retryable bool
+	}{
+		{http.StatusTooManyRequests, ErrCodeThrottled, true},
+		{http.StatusGatewayTimeout, ErrCodeTimeout, true},
+		{http.StatusServiceUnavailable, ErrCodeWriteFailed, true},
+		{http.StatusForbidden, ErrCodeInvalidConfig, false},
+		{http.StatusNotFound, ErrCodeTableNotFound, false},
+		{http.StatusBadRequest, ErrCodeInvalidRecord, false},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			err := classifyHTTPStatus("test", "t", tt.status, errors.New("request failed"))
+			var destErr *DestinationError
+			require.ErrorAs(t, err, &destErr)
+			assert.Equal(t, tt.code, destErr.Code)
+			assert.Equal(t, tt.retryable, destErr.Retryable())
+			assert.Equal(t, "t", destErr.Details["table"])
+		})
+	}
+}
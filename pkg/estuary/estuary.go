@@ -6,6 +6,7 @@ package estuary
 // we will have here implementations to write an event to an output server as defined in the configuration.
 
 import (
+	"context"
 	"os"
 
 	"github.com/cohenjo/replicator/pkg/config"
@@ -16,8 +17,14 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// Endpoint writes record events to a destination. Failed writes return a
+// *DestinationError whose code tells retryable failures from permanent ones,
+// see IsRetryable.
 type Endpoint interface {
-	WriteEvent(record *events.RecordEvent)
+	// Write writes a single record
+	Write(ctx context.Context, record *events.RecordEvent) error
+	// WriteBatch writes records in order, returning once all are written
+	WriteBatch(ctx context.Context, records []*events.RecordEvent) error
 }
 
 func WriteEndpoints(record events.RecordEvent) {
@@ -51,13 +58,23 @@ func (em *EndpointManagment) NewEstuary(streamConfig *config.WaterFlowsConfig) {
 	var endpoint Endpoint
 	switch streamConfig.Type {
 	case "MYSQL":
-		endpoint = NewMySQLEndpoint(streamConfig)
+		mysqlEndpoint, err := NewMySQLEndpoint(streamConfig)
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to create MySQL endpoint")
+			return
+		}
+		endpoint = mysqlEndpoint
 	case "MONGO":
 		endpoint = NewMongoEndpoint(streamConfig)
 	case "KAFKA":
 		endpoint = NewKafkaEndpoint(streamConfig)
 	case "ELASTIC":
-		endpoint = NewElasticEndpoint(streamConfig)
+		elasticEndpoint, err := NewElasticEndpoint(streamConfig)
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to create Elasticsearch endpoint")
+			return
+		}
+		endpoint = elasticEndpoint
 	case "STDOUT":
 		endpoint = StdoutEndpoint{}
 
@@ -74,7 +91,9 @@ func (em *EndpointManagment) PublishEvents() {
 		select {
 		case record := <-*em.recordEvents:
 			for _, endpoint := range em.endpoints {
-				endpoint.WriteEvent(record)
+				if err := endpoint.Write(context.Background(), record); err != nil {
+					logger.Error().Err(err).Bool("retryable", IsRetryable(err)).Msg("Failed to write record")
+				}
 			}
 			recordsSent.Inc()
 		case <-em.quit:
@@ -95,8 +114,18 @@ func (em *EndpointManagment) Quit() {
 type StdoutEndpoint struct {
 }
 
-func (std StdoutEndpoint) WriteEvent(record *events.RecordEvent) {
+func (std StdoutEndpoint) Write(ctx context.Context, record *events.RecordEvent) error {
 	logger := zerolog.New(os.Stderr).With().Timestamp().Logger()
 
 	logger.Info().Msgf("record: %s", string(record.Data))
+	return nil
+}
+
+func (std StdoutEndpoint) WriteBatch(ctx context.Context, records []*events.RecordEvent) error {
+	for _, record := range records {
+		if err := std.Write(ctx, record); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -2,6 +2,7 @@ package estuary
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -303,6 +304,45 @@ func (e *DestinationError) Unwrap() error {
 	return e.Cause
 }
 
+// Retryable reports whether the failed operation may succeed when retried.
+// Connection, timeout, throttling and transaction failures are transient;
+// invalid records, schemas and configuration fail again on every retry.
+func (e *DestinationError) Retryable() bool {
+	switch e.Code {
+	case ErrCodeConnectionFailed, ErrCodeWriteFailed, ErrCodeTransactionFailed, ErrCodeThrottled, ErrCodeTimeout:
+		return true
+	}
+	return false
+}
+
+// IsRetryable reports whether a write error may succeed when retried.
+// Cancelled writes are not retried; errors that are not destination errors
+// are assumed to be transient.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var destErr *DestinationError
+	if errors.As(err, &destErr) {
+		return destErr.Retryable()
+	}
+	return true
+}
+
+// WrittenRecords returns how many records of a failed batch were written
+// before the failing one, for destinations that apply a batch in order
+// without a transaction. It is 0 when the batch left nothing written, or
+// when replaying the written records is harmless.
+func WrittenRecords(err error) int {
+	var destErr *DestinationError
+	if errors.As(err, &destErr) {
+		if written, ok := destErr.Details["written"].(int); ok {
+			return written
+		}
+	}
+	return 0
+}
+
 // Common error codes
 const (
 	ErrCodeConnectionFailed    = "CONNECTION_FAILED"
@@ -314,6 +354,9 @@ const (
 	ErrCodeInvalidConfig       = "INVALID_CONFIG"
 	ErrCodeDestinationNotFound = "DESTINATION_NOT_FOUND"
 	ErrCodeUnsupportedOperation = "UNSUPPORTED_OPERATION"
+	ErrCodeInvalidRecord       = "INVALID_RECORD" // the record cannot be written as is
+	ErrCodeThrottled           = "THROTTLED"
+	ErrCodeTimeout             = "TIMEOUT"
 )
 
 // Helper functions for creating destination errors
@@ -361,4 +404,17 @@ func NewTransactionError(destination, message string, cause error) *DestinationE
 		Timestamp:   time.Now(),
 		Cause:       cause,
 	}
-}
\ No newline at end of file
+}
+
+// NewRecordError reports a record that cannot be written to a destination as is
+func NewRecordError(destination, table, message string, cause error) *DestinationError {
+	return &DestinationError{
+		Code:        ErrCodeInvalidRecord,
+		Message:     message,
+		Operation:   "write",
+		Destination: destination,
+		Timestamp:   time.Now(),
+		Details:     map[string]interface{}{"table": table},
+		Cause:       cause,
+	}
+}
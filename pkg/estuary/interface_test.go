@@ -1,7 +1,9 @@
 package estuary
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"testing"
 	"time"
 
@@ -410,4 +412,25 @@ func TestDestinationErrorWithoutCause(t *testing.T) {
 	
 	// Test Unwrap() with no cause
 	assert.Nil(t, err.Unwrap())
-}
\ No newline at end of file
+}
+func TestDestinationErrorRetryable(t *testing.T) {
+	assert.True(t, NewConnectionError("d", "lost", nil).Retryable())
+	assert.True(t, NewWriteError("d", "t", "failed", nil).Retryable())
+	assert.True(t, NewTransactionError("d", "deadlock", nil).Retryable())
+	assert.False(t, NewRecordError("d", "t", "rejected", nil).Retryable())
+	assert.False(t, NewSchemaError("d", "t", "mismatch", nil).Retryable())
+
+	// Wrapped destination errors keep their classification
+	assert.False(t, IsRetryable(fmt.Errorf("stream: %w", NewRecordError("d", "t", "rejected", nil))))
+	assert.True(t, IsRetryable(errors.New("unclassified")))
+	assert.False(t, IsRetryable(context.Canceled))
+	assert.False(t, IsRetryable(nil))
+}
+
+func TestWrittenRecords(t *testing.T) {
+	err := NewRecordError("d", "t", "duplicate key", nil)
+	assert.Equal(t, 0, WrittenRecords(err))
+	err.Details["written"] = 3
+	assert.Equal(t, 3, WrittenRecords(fmt.Errorf("batch: %w", err)))
+	assert.Equal(t, 0, WrittenRecords(errors.New("unclassified")))
+}
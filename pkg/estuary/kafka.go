@@ -1,12 +1,19 @@
 package estuary
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/pquerna/ffjson/ffjson"
 
-	"github.com/Shopify/sarama"
+	"github.com/IBM/sarama"
 	"github.com/cohenjo/replicator/pkg/config"
 	"github.com/cohenjo/replicator/pkg/events"
 )
@@ -14,59 +21,438 @@ import (
 /*
 Simple SyncProducer for kafka
 
-Taken from: https://github.com/Shopify/sarama/blob/master/examples/http_server/http_server.go
+Taken from: https://github.com/IBM/sarama/blob/main/examples/http_server/http_server.go
 If this works ok for mock - then add snappy...
 
+When a transactional id is configured the producer runs in exactly-once mode:
+every write is produced inside a Kafka transaction, and the consumed offsets
+of the Kafka source (carried in RecordEvent.Position) are committed to the
+source consumer group with AddOffsetsToTxn as part of the same transaction.
+
 */
 
+// Supported Kafka output formats
+const (
+	KafkaFormatLegacy      = "legacy"      // events.KafkaMessage wrapper (default)
+	KafkaFormatDocument    = "document"    // the plain document, tombstone on delete
+	KafkaFormatDebezium    = "debezium"    // Debezium-style before/after envelope
+	KafkaFormatCloudEvents = "cloudevents" // CloudEvents 1.0 structured mode
+)
+
+const kafkaDestination = string(config.TargetTypeKafka)
+
 type KafkaEndpoint struct {
-	producer sarama.SyncProducer
-	topic    string
+	producer      sarama.SyncProducer
+	brokers       []string
+	topic         string
+	topicTemplate string
+	keyFields     []string
+	format        string
+}
+
+// Write produces a single record
+func (s KafkaEndpoint) Write(ctx context.Context, record *events.RecordEvent) error {
+	return s.WriteBatch(ctx, []*events.RecordEvent{record})
+}
+
+// WriteBatch produces the records, in one transaction when the producer is transactional
+func (s KafkaEndpoint) WriteBatch(ctx context.Context, records []*events.RecordEvent) error {
+	msgs := make([]*sarama.ProducerMessage, 0, len(records))
+	for _, record := range records {
+		msg, err := s.buildMessage(record)
+		if err != nil {
+			return NewRecordError(kafkaDestination, record.Collection, "failed to build message", err)
+		}
+		msgs = append(msgs, msg)
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return classifyError(kafkaDestination, msgs[0].Topic, err)
+	}
+
+	if s.producer.IsTransactional() {
+		if err := s.produceInTxn(msgs, records); err != nil {
+			return classifyKafkaError(msgs[0].Topic, err)
+		}
+		return nil
+	}
+
+	if len(msgs) == 1 {
+		partition, offset, err := s.producer.SendMessage(msgs[0])
+		if err != nil {
+			return classifyKafkaError(msgs[0].Topic, fmt.Errorf("failed to produce message: %w", err))
+		}
+		// The tuple (topic, partition, offset) can be used as a unique identifier
+		// for a message in a Kafka cluster.
+		logger.Debug().Msgf("Your data is stored with unique identifier %s/%d/%d", msgs[0].Topic, partition, offset)
+		return nil
+	}
+	if err := s.producer.SendMessages(msgs); err != nil {
+		return classifyKafkaError(msgs[0].Topic, fmt.Errorf("failed to produce messages: %w", err))
+	}
+	return nil
+}
+
+// classifyKafkaError classifies a produce error. Rejected messages, unknown
+// topics and authorization failures are permanent; broker and leadership
+// errors are transient.
+func classifyKafkaError(topic string, err error) error {
+	var producerErrs sarama.ProducerErrors
+	if errors.As(err, &producerErrs) && len(producerErrs) > 0 {
+		// A batch is retryable unless every message was rejected for good
+		for _, producerErr := range producerErrs {
+			if IsRetryable(classifyKafkaError(topic, producerErr.Err)) {
+				return NewWriteError(kafkaDestination, topic, "failed to deliver messages", err)
+			}
+		}
+		var first *DestinationError
+		if errors.As(classifyKafkaError(topic, producerErrs[0].Err), &first) {
+			first.Cause = fmt.Errorf("%w: %w", err, producerErrs[0].Err)
+			return first
+		}
+		return NewWriteError(kafkaDestination, topic, "failed to deliver messages", err)
+	}
+
+	var kafkaErr sarama.KError
+	if errors.As(err, &kafkaErr) {
+		switch kafkaErr {
+		case sarama.ErrInvalidMessage, sarama.ErrInvalidMessageSize, sarama.ErrMessageSizeTooLarge,
+			sarama.ErrMessageSetSizeTooLarge, sarama.ErrInvalidRecord, sarama.ErrPolicyViolation:
+			return NewRecordError(kafkaDestination, topic, "message rejected", err)
+		case sarama.ErrUnknownTopicOrPartition, sarama.ErrInvalidTopic:
+			return newDestinationError(ErrCodeTableNotFound, kafkaDestination, topic, "topic not found", err)
+		case sarama.ErrTopicAuthorizationFailed, sarama.ErrClusterAuthorizationFailed,
+			sarama.ErrTransactionalIDAuthorizationFailed, sarama.ErrUnsupportedVersion:
+			return newDestinationError(ErrCodeInvalidConfig, kafkaDestination, topic, "producer not allowed to write", err)
+		case sarama.ErrRequestTimedOut:
+			return newDestinationError(ErrCodeTimeout, kafkaDestination, topic, "write timed out", err)
+		}
+		return NewWriteError(kafkaDestination, topic, "broker error", err)
+	}
+	if errors.Is(err, sarama.ErrOutOfBrokers) || errors.Is(err, sarama.ErrNotConnected) {
+		return NewConnectionError(kafkaDestination, "no broker available", err)
+	}
+	return classifyError(kafkaDestination, topic, err)
+}
+
+// produceInTxn sends the messages and commits the source offsets of the
+// records in a single transaction. On failure the transaction is aborted, so
+// neither the messages nor the offsets become visible.
+func (s KafkaEndpoint) produceInTxn(msgs []*sarama.ProducerMessage, records []*events.RecordEvent) error {
+	if err := s.producer.BeginTxn(); err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	if err := s.producer.SendMessages(msgs); err != nil {
+		return s.abortTxn(fmt.Errorf("failed to produce messages: %w", err))
+	}
+
+	for group, offsets := range sourceOffsets(records) {
+		if err := s.producer.AddOffsetsToTxn(offsets, group); err != nil {
+			return s.abortTxn(fmt.Errorf("failed to add offsets of group %s to transaction: %w", group, err))
+		}
+	}
+
+	if err := s.producer.CommitTxn(); err != nil {
+		return s.abortTxn(fmt.Errorf("failed to commit transaction: %w", err))
+	}
+
+	logger.Debug().Int("messages", len(msgs)).Msg("Kafka transaction committed")
+	return nil
 }
 
-func (s KafkaEndpoint) WriteEvent(record *events.RecordEvent) {
-	// We are not setting a message key, which means that all messages will
-	// be distributed randomly over the different partitions.
+// abortTxn aborts the current transaction after a failure and returns the cause
+func (s KafkaEndpoint) abortTxn(cause error) error {
+	if s.producer.TxnStatus()&sarama.ProducerTxnFlagFatalError != 0 {
+		// A fatal error leaves the producer unusable, it has to be recreated
+		return fmt.Errorf("kafka producer in fatal state: %w", cause)
+	}
+	if err := s.producer.AbortTxn(); err != nil {
+		return fmt.Errorf("failed to abort transaction (%v): %w", err, cause)
+	}
+	return cause
+}
+
+// sourceOffsets collects the next offsets to commit, per consumer group,
+// from the Kafka source positions of the records
+func sourceOffsets(records []*events.RecordEvent) map[string]map[string][]*sarama.PartitionOffsetMetadata {
+	type partitionKey struct {
+		group, topic string
+		partition    int32
+	}
+	latest := make(map[partitionKey]int64)
 
-	data, err := ffjson.Marshal(events.KafkaMessage{Payload: *record})
+	for _, record := range records {
+		if record == nil || record.Position == nil {
+			continue
+		}
+		group, _ := record.Position["consumer_group"].(string)
+		topic, _ := record.Position["topic"].(string)
+		partition, okPartition := toInt64(record.Position["partition"])
+		offset, okOffset := toInt64(record.Position["offset"])
+		if group == "" || topic == "" || !okPartition || !okOffset {
+			continue
+		}
+		key := partitionKey{group: group, topic: topic, partition: int32(partition)}
+		if current, ok := latest[key]; !ok || offset > current {
+			latest[key] = offset
+		}
+	}
+
+	result := make(map[string]map[string][]*sarama.PartitionOffsetMetadata)
+	for key, offset := range latest {
+		if result[key.group] == nil {
+			result[key.group] = make(map[string][]*sarama.PartitionOffsetMetadata)
+		}
+		// The committed offset is the next message to consume
+		result[key.group][key.topic] = append(result[key.group][key.topic], &sarama.PartitionOffsetMetadata{
+			Partition: key.partition,
+			Offset:    offset + 1,
+		})
+	}
+	return result
+}
+
+// toInt64 converts a numeric position value, which may have round-tripped through JSON
+func toInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		return int64(v), true
+	case float64:
+		return int64(v), true
+	case json.Number:
+		n, err := v.Int64()
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// buildMessage resolves the topic, key and value for a record
+func (s KafkaEndpoint) buildMessage(record *events.RecordEvent) (*sarama.ProducerMessage, error) {
+	value, err := s.encodeValue(record)
 	if err != nil {
-		logger.Error().Err(err).Msg("Failed to send event... :( ")
+		return nil, fmt.Errorf("failed to encode record: %w", err)
+	}
+
+	msg := &sarama.ProducerMessage{
+		Topic: s.resolveTopic(record),
+	}
+	// Keyed messages are hashed to a partition, so all events for the same
+	// row stay on the same partition and keep their order.
+	if key := s.messageKey(record); key != nil {
+		msg.Key = sarama.ByteEncoder(key)
+	}
+	// A nil value is a tombstone, which lets compacted topics drop the key.
+	if value != nil {
+		msg.Value = sarama.ByteEncoder(value)
+	}
+	return msg, nil
+}
+
+// resolveTopic expands the topic template for the record.
+// Supported placeholders are {schema}, {collection} and {action}.
+func (s KafkaEndpoint) resolveTopic(record *events.RecordEvent) string {
+	if s.topicTemplate == "" {
+		return s.topic
+	}
+	replacer := strings.NewReplacer(
+		"{schema}", record.Schema,
+		"{collection}", record.Collection,
+		"{action}", record.Action,
+	)
+	return replacer.Replace(s.topicTemplate)
+}
+
+// messageKey derives the message key from the configured key fields,
+// falling back to the record's DocumentKey
+func (s KafkaEndpoint) messageKey(record *events.RecordEvent) []byte {
+	if len(s.keyFields) > 0 {
+		if key := keyFromFields(record, s.keyFields); key != nil {
+			return key
+		}
+	}
+	if len(record.DocumentKey) > 0 {
+		return record.DocumentKey
+	}
+	return nil
+}
+
+// keyFromFields builds a JSON object key from the given fields of the record
+// document. For deletes the fields are looked up in the old data.
+func keyFromFields(record *events.RecordEvent, fields []string) []byte {
+	source := record.Data
+	if record.Action == events.DeleteAction && len(record.OldData) > 0 {
+		source = record.OldData
 	}
 
-	partition, offset, err := s.producer.SendMessage(&sarama.ProducerMessage{
-		Topic: s.topic,
-		Value: sarama.ByteEncoder(data),
-	})
+	var doc map[string]interface{}
+	if err := json.Unmarshal(source, &doc); err != nil {
+		return nil
+	}
 
+	key := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		value, ok := lookupField(doc, field)
+		if !ok {
+			return nil
+		}
+		key[field] = value
+	}
+
+	data, err := json.Marshal(key)
 	if err != nil {
-		logger.Error().Err(err).Msg("Failed to send event... :( ")
+		return nil
+	}
+	return data
+}
+
+// lookupField resolves a dot separated path inside a document
+func lookupField(doc map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = doc
+	for _, part := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// encodeValue serializes the record in the configured output format
+func (s KafkaEndpoint) encodeValue(record *events.RecordEvent) ([]byte, error) {
+	switch s.format {
+	case "", KafkaFormatLegacy:
+		return ffjson.Marshal(events.KafkaMessage{Payload: *record})
+	case KafkaFormatDocument:
+		if record.Action == events.DeleteAction {
+			return nil, nil
+		}
+		return record.Data, nil
+	case KafkaFormatDebezium:
+		return json.Marshal(debeziumEnvelope(record))
+	case KafkaFormatCloudEvents:
+		return json.Marshal(cloudEvent(record))
+	default:
+		return nil, fmt.Errorf("unsupported kafka format: %s", s.format)
+	}
+}
+
+// debeziumEnvelope wraps the record in a Debezium-style change envelope
+func debeziumEnvelope(record *events.RecordEvent) map[string]interface{} {
+	op := "u"
+	switch record.Action {
+	case events.InsertAction:
+		op = "c"
+	case events.DeleteAction:
+		op = "d"
+	}
+
+	var before, after json.RawMessage
+	if len(record.OldData) > 0 {
+		before = rawJSON(record.OldData)
+	}
+	if record.Action != events.DeleteAction && len(record.Data) > 0 {
+		after = rawJSON(record.Data)
+	} else if record.Action == events.DeleteAction && before == nil && len(record.Data) > 0 {
+		before = rawJSON(record.Data)
+	}
+
+	return map[string]interface{}{
+		"before": before,
+		"after":  after,
+		"op":     op,
+		"ts_ms":  time.Now().UnixMilli(),
+		"source": map[string]interface{}{
+			"connector": "replicator",
+			"db":        record.Schema,
+			"table":     record.Collection,
+		},
+	}
+}
+
+// cloudEvent wraps the record in a CloudEvents 1.0 structured envelope
+func cloudEvent(record *events.RecordEvent) map[string]interface{} {
+	ce := map[string]interface{}{
+		"specversion":     "1.0",
+		"id":              cloudEventID(record),
+		"source":          fmt.Sprintf("/replicator/%s/%s", record.Schema, record.Collection),
+		"type":            "io.replicator.record." + record.Action,
+		"time":            time.Now().UTC().Format(time.RFC3339Nano),
+		"datacontenttype": "application/json",
+	}
+	if len(record.DocumentKey) > 0 {
+		ce["subject"] = string(record.DocumentKey)
+	}
+	if len(record.Data) > 0 {
+		ce["data"] = rawJSON(record.Data)
+	}
+	return ce
+}
+
+// cloudEventID derives the id of the CloudEvent of a record from its source
+// position and document key, so a record produced again, by a retry or
+// another producer, keeps its id and consumers can deduplicate it. Records
+// without a position are identified by their content instead.
+func cloudEventID(record *events.RecordEvent) string {
+	identity := map[string]interface{}{
+		"schema":     record.Schema,
+		"collection": record.Collection,
+		"action":     record.Action,
+		"key":        string(record.DocumentKey),
+	}
+	if len(record.Position) > 0 {
+		identity["position"] = record.Position
 	} else {
-		// The tuple (topic, partition, offset) can be used as a unique identifier
-		// for a message in a Kafka cluster.
-		logger.Debug().Msgf("Your data is stored with unique identifier important/%d/%d", partition, offset)
+		identity["data"] = string(record.Data)
+		identity["old_data"] = string(record.OldData)
+	}
+	// Map keys are encoded in order, the encoding is stable
+	encoded, _ := json.Marshal(identity)
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])
+}
+
+// rawJSON returns the bytes as a raw JSON value, quoting them if they are not valid JSON
+func rawJSON(data []byte) json.RawMessage {
+	if json.Valid(data) {
+		return json.RawMessage(data)
 	}
-	logger.Debug().Msgf("record: %v", record)
+	quoted, _ := json.Marshal(string(data))
+	return json.RawMessage(quoted)
 }
 
 func NewKafkaEndpoint(streamConfig *config.WaterFlowsConfig) (endpoint KafkaEndpoint) {
-	brokers := []string{fmt.Sprintf("%s:%d", streamConfig.Host, streamConfig.Port)}
-	producer := newDataCollector(brokers)
+	brokers := streamConfig.KafkaBrokers
+	if len(brokers) == 0 {
+		brokers = []string{fmt.Sprintf("%s:%d", streamConfig.Host, streamConfig.Port)}
+	}
+	producer := newDataCollector(brokers, streamConfig.KafkaTransactionalID)
 	endpoint = KafkaEndpoint{
-		producer: producer,
-		topic:    streamConfig.Schema,
+		producer:      producer,
+		brokers:       brokers,
+		topic:         streamConfig.Schema,
+		topicTemplate: streamConfig.KafkaTopicTemplate,
+		keyFields:     streamConfig.KafkaKeyFields,
+		format:        strings.ToLower(streamConfig.KafkaFormat),
 	}
 	return endpoint
 }
 
-func newDataCollector(brokerList []string) sarama.SyncProducer {
-
-	// For the data collector, we are looking for strong consistency semantics.
-	// Because we don't change the flush settings, sarama will try to produce messages
-	// as fast as possible to keep latency low.
-	config := sarama.NewConfig()
-	config.Producer.RequiredAcks = sarama.WaitForAll // Wait for all in-sync replicas to ack the message
-	config.Producer.Retry.Max = 10                   // Retry up to 10 times to produce the message
-	config.Producer.Return.Successes = true
+func newDataCollector(brokerList []string, transactionalID string) sarama.SyncProducer {
+	config := newProducerConfig(transactionalID)
 
 	// tlsConfig := createTlsConfiguration()
 	// if tlsConfig != nil {
@@ -87,6 +473,27 @@ func newDataCollector(brokerList []string) sarama.SyncProducer {
 	return producer
 }
 
+// newProducerConfig builds the producer configuration, transactional when an id is given
+func newProducerConfig(transactionalID string) *sarama.Config {
+	// For the data collector, we are looking for strong consistency semantics.
+	// Because we don't change the flush settings, sarama will try to produce messages
+	// as fast as possible to keep latency low.
+	config := sarama.NewConfig()
+	config.Producer.RequiredAcks = sarama.WaitForAll // Wait for all in-sync replicas to ack the message
+	config.Producer.Retry.Max = 10                   // Retry up to 10 times to produce the message
+	config.Producer.Return.Successes = true
+
+	// Exactly-once: idempotent, transactional producer
+	if transactionalID != "" {
+		config.Version = sarama.V2_6_0_0
+		config.Producer.Idempotent = true
+		config.Producer.Transaction.ID = transactionalID
+		config.Net.MaxOpenRequests = 1
+	}
+
+	return config
+}
+
 func (s *KafkaEndpoint) Close() error {
 	if err := s.producer.Close(); err != nil {
 		logger.Error().Err(err).Msg("Failed to shut down data collector cleanly")
@@ -0,0 +1,158 @@
+package estuary
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/IBM/sarama"
+)
+
+// admin opens a cluster admin over the brokers of the endpoint. The caller closes it.
+func (s KafkaEndpoint) admin() (sarama.ClusterAdmin, sarama.Client, error) {
+	config := sarama.NewConfig()
+	config.Version = sarama.V2_6_0_0
+	client, err := sarama.NewClient(s.brokers, config)
+	if err != nil {
+		return nil, nil, classifyKafkaError("", fmt.Errorf("failed to connect to kafka: %w", err))
+	}
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		client.Close()
+		return nil, nil, classifyKafkaError("", fmt.Errorf("failed to create kafka admin: %w", err))
+	}
+	return admin, client, nil
+}
+
+// Ping checks that the cluster answers with its brokers
+func (s KafkaEndpoint) Ping(ctx context.Context) error {
+	admin, _, err := s.admin()
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+	brokers, _, err := admin.DescribeCluster()
+	if err != nil {
+		return classifyKafkaError("", fmt.Errorf("failed to describe cluster: %w", err))
+	}
+	if len(brokers) == 0 {
+		return NewConnectionError(kafkaDestination, "cluster has no brokers", nil)
+	}
+	return nil
+}
+
+// ListTables lists the topics of the cluster, without internal topics
+func (s KafkaEndpoint) ListTables(ctx context.Context) ([]string, error) {
+	admin, _, err := s.admin()
+	if err != nil {
+		return nil, err
+	}
+	defer admin.Close()
+	topics, err := admin.ListTopics()
+	if err != nil {
+		return nil, classifyKafkaError("", fmt.Errorf("failed to list topics: %w", err))
+	}
+	names := make([]string, 0, len(topics))
+	for name := range topics {
+		if !strings.HasPrefix(name, "__") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// GetSchema describes a topic. Topics carry no columns, the partitions and
+// replication factor are reported in the metadata.
+func (s KafkaEndpoint) GetSchema(ctx context.Context, tableName string) (*TableSchema, error) {
+	admin, _, err := s.admin()
+	if err != nil {
+		return nil, err
+	}
+	defer admin.Close()
+	topics, err := admin.DescribeTopics([]string{tableName})
+	if err != nil {
+		return nil, classifyKafkaError(tableName, fmt.Errorf("failed to describe topic %s: %w", tableName, err))
+	}
+	if len(topics) == 0 || topics[0].Err == sarama.ErrUnknownTopicOrPartition {
+		return nil, newDestinationError(ErrCodeTableNotFound, kafkaDestination, tableName, "topic does not exist", nil)
+	}
+	if topics[0].Err != sarama.ErrNoError {
+		return nil, classifyKafkaError(tableName, topics[0].Err)
+	}
+
+	replication := 0
+	if len(topics[0].Partitions) > 0 {
+		replication = len(topics[0].Partitions[0].Replicas)
+	}
+	return &TableSchema{
+		Name:       tableName,
+		PrimaryKey: s.keyFields,
+		Metadata: map[string]interface{}{
+			"partitions":         len(topics[0].Partitions),
+			"replication_factor": replication,
+		},
+	}, nil
+}
+
+// CreateTable creates a topic. The partitions and replication factor are
+// taken from the schema metadata and default to 1.
+func (s KafkaEndpoint) CreateTable(ctx context.Context, schema TableSchema) error {
+	admin, _, err := s.admin()
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+	partitions, ok := toInt64(schema.Metadata["partitions"])
+	if !ok || partitions < 1 {
+		partitions = 1
+	}
+	replication, ok := toInt64(schema.Metadata["replication_factor"])
+	if !ok || replication < 1 {
+		replication = 1
+	}
+	detail := &sarama.TopicDetail{NumPartitions: int32(partitions), ReplicationFactor: int16(replication)}
+	if err := admin.CreateTopic(schema.Name, detail, false); err != nil {
+		return classifyKafkaError(schema.Name, fmt.Errorf("failed to create topic %s: %w", schema.Name, err))
+	}
+	return nil
+}
+
+// DropTable deletes a topic
+func (s KafkaEndpoint) DropTable(ctx context.Context, tableName string) error {
+	admin, _, err := s.admin()
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+	if err := admin.DeleteTopic(tableName); err != nil {
+		return classifyKafkaError(tableName, fmt.Errorf("failed to delete topic %s: %w", tableName, err))
+	}
+	return nil
+}
+
+// TruncateTable deletes the records of every partition of a topic up to its newest offset
+func (s KafkaEndpoint) TruncateTable(ctx context.Context, tableName string) error {
+	admin, client, err := s.admin()
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+	partitions, err := client.Partitions(tableName)
+	if err != nil {
+		return classifyKafkaError(tableName, fmt.Errorf("failed to get partitions of %s: %w", tableName, err))
+	}
+	offsets := make(map[int32]int64, len(partitions))
+	for _, partition := range partitions {
+		offset, err := client.GetOffset(tableName, partition, sarama.OffsetNewest)
+		if err != nil {
+			return classifyKafkaError(tableName, fmt.Errorf("failed to get offset of %s/%d: %w", tableName, partition, err))
+		}
+		offsets[partition] = offset
+	}
+	if err := admin.DeleteRecords(tableName, offsets); err != nil {
+		return classifyKafkaError(tableName, fmt.Errorf("failed to delete records of %s: %w", tableName, err))
+	}
+	return nil
+}
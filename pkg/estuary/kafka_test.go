@@ -0,0 +1,205 @@
+package estuary
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/cohenjo/replicator/pkg/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKafkaEndpoint_ResolveTopic(t *testing.T) {
+	record := &events.RecordEvent{Action: "insert", Schema: "shop", Collection: "orders"}
+
+	endpoint := KafkaEndpoint{topic: "shop"}
+	assert.Equal(t, "shop", endpoint.resolveTopic(record))
+
+	endpoint.topicTemplate = "{schema}.{collection}"
+	assert.Equal(t, "shop.orders", endpoint.resolveTopic(record))
+
+	endpoint.topicTemplate = "cdc-{collection}-{action}"
+	assert.Equal(t, "cdc-orders-insert", endpoint.resolveTopic(record))
+}
+
+func TestKafkaEndpoint_MessageKey(t *testing.T) {
+	record := &events.RecordEvent{
+		Action:      "update",
+		DocumentKey: []byte(`{"_id":"abc"}`),
+		Data:        []byte(`{"_id":"abc","tenant":{"id":7},"name":"x"}`),
+	}
+
+	endpoint := KafkaEndpoint{}
+	assert.Equal(t, []byte(`{"_id":"abc"}`), endpoint.messageKey(record))
+
+	endpoint.keyFields = []string{"tenant.id", "name"}
+	assert.JSONEq(t, `{"tenant.id":7,"name":"x"}`, string(endpoint.messageKey(record)))
+
+	// Missing fields fall back to the document key
+	endpoint.keyFields = []string{"missing"}
+	assert.Equal(t, []byte(`{"_id":"abc"}`), endpoint.messageKey(record))
+
+	// Deletes read key fields from the old data
+	deleteRecord := &events.RecordEvent{Action: "delete", OldData: []byte(`{"name":"y"}`)}
+	endpoint.keyFields = []string{"name"}
+	assert.JSONEq(t, `{"name":"y"}`, string(endpoint.messageKey(deleteRecord)))
+
+	assert.Nil(t, KafkaEndpoint{}.messageKey(&events.RecordEvent{Action: "insert"}))
+}
+
+func TestKafkaEndpoint_EncodeValue(t *testing.T) {
+	record := &events.RecordEvent{
+		Action:      "update",
+		Schema:      "shop",
+		Collection:  "orders",
+		DocumentKey: []byte(`{"_id":"abc"}`),
+		OldData:     []byte(`{"_id":"abc","qty":1}`),
+		Data:        []byte(`{"_id":"abc","qty":2}`),
+	}
+
+	t.Run("legacy", func(t *testing.T) {
+		value, err := KafkaEndpoint{}.encodeValue(record)
+		require.NoError(t, err)
+		var msg events.KafkaMessage
+		require.NoError(t, json.Unmarshal(value, &msg))
+		assert.Equal(t, "orders", msg.Payload.Collection)
+	})
+
+	t.Run("document", func(t *testing.T) {
+		endpoint := KafkaEndpoint{format: KafkaFormatDocument}
+		value, err := endpoint.encodeValue(record)
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"_id":"abc","qty":2}`, string(value))
+
+		value, err = endpoint.encodeValue(&events.RecordEvent{Action: "delete"})
+		require.NoError(t, err)
+		assert.Nil(t, value)
+	})
+
+	t.Run("debezium", func(t *testing.T) {
+		value, err := KafkaEndpoint{format: KafkaFormatDebezium}.encodeValue(record)
+		require.NoError(t, err)
+		var envelope map[string]interface{}
+		require.NoError(t, json.Unmarshal(value, &envelope))
+		assert.Equal(t, "u", envelope["op"])
+		assert.Equal(t, float64(1), envelope["before"].(map[string]interface{})["qty"])
+		assert.Equal(t, float64(2), envelope["after"].(map[string]interface{})["qty"])
+		assert.Equal(t, "orders", envelope["source"].(map[string]interface{})["table"])
+	})
+
+	t.Run("cloudevents", func(t *testing.T) {
+		value, err := KafkaEndpoint{format: KafkaFormatCloudEvents}.encodeValue(record)
+		require.NoError(t, err)
+		var ce map[string]interface{}
+		require.NoError(t, json.Unmarshal(value, &ce))
+		assert.Equal(t, "1.0", ce["specversion"])
+		assert.Equal(t, "io.replicator.record.update", ce["type"])
+		assert.Equal(t, "/replicator/shop/orders", ce["source"])
+		assert.Equal(t, `{"_id":"abc"}`, ce["subject"])
+		assert.Equal(t, cloudEventID(record), ce["id"], "the id is stable across productions")
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		_, err := KafkaEndpoint{format: "avro"}.encodeValue(record)
+		assert.Error(t, err)
+	})
+}
+
+func TestCloudEventID(t *testing.T) {
+	record := func(offset int64, key string) *events.RecordEvent {
+		return &events.RecordEvent{
+			Action:      "update",
+			Collection:  "orders",
+			DocumentKey: []byte(key),
+			Data:        []byte(`{"qty":2}`),
+			Position:    map[string]interface{}{"file": "binlog.000001", "pos": offset},
+		}
+	}
+
+	assert.Equal(t, cloudEventID(record(4, `{"id":1}`)), cloudEventID(record(4, `{"id":1}`)))
+	assert.NotEqual(t, cloudEventID(record(4, `{"id":1}`)), cloudEventID(record(4, `{"id":2}`)), "rows of a source event differ by key")
+	assert.NotEqual(t, cloudEventID(record(4, `{"id":1}`)), cloudEventID(record(8, `{"id":1}`)))
+
+	// Without a position, the content identifies the record
+	noPosition := record(0, `{"id":1}`)
+	noPosition.Position = nil
+	changed := *noPosition
+	changed.Data = []byte(`{"qty":3}`)
+	assert.NotEqual(t, cloudEventID(noPosition), cloudEventID(&changed))
+}
+
+func TestSourceOffsets(t *testing.T) {
+	records := []*events.RecordEvent{
+		{Position: map[string]interface{}{"consumer_group": "g1", "topic": "in", "partition": int32(0), "offset": int64(5)}},
+		{Position: map[string]interface{}{"consumer_group": "g1", "topic": "in", "partition": int32(0), "offset": int64(7)}},
+		{Position: map[string]interface{}{"consumer_group": "g1", "topic": "in", "partition": float64(1), "offset": float64(3)}},
+		{Position: map[string]interface{}{"consumer_group": "g2", "topic": "other", "partition": 0, "offset": 1}},
+		{Position: map[string]interface{}{"topic": "no-group", "partition": 0, "offset": 1}},
+		{},
+	}
+
+	offsets := sourceOffsets(records)
+	require.Len(t, offsets, 2)
+	require.Len(t, offsets["g1"]["in"], 2)
+
+	byPartition := map[int32]int64{}
+	for _, p := range offsets["g1"]["in"] {
+		byPartition[p.Partition] = p.Offset
+	}
+	// Committed offsets point at the next message to consume
+	assert.Equal(t, int64(8), byPartition[0])
+	assert.Equal(t, int64(4), byPartition[1])
+	assert.Equal(t, int64(2), offsets["g2"]["other"][0].Offset)
+}
+
+func TestKafkaEndpoint_WriteTransactional(t *testing.T) {
+	producer := mocks.NewSyncProducer(t, newProducerConfig("replicator-test"))
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		assert.Equal(t, "shop.orders", msg.Topic)
+		return nil
+	})
+
+	endpoint := KafkaEndpoint{producer: producer, topicTemplate: "{schema}.{collection}"}
+	require.NoError(t, endpoint.Write(context.Background(), &events.RecordEvent{
+		Action:     "insert",
+		Schema:     "shop",
+		Collection: "orders",
+		Data:       []byte(`{"_id":"abc"}`),
+		Position:   map[string]interface{}{"consumer_group": "g1", "topic": "in", "partition": int32(0), "offset": int64(5)},
+	}))
+
+	assert.Equal(t, sarama.ProducerTxnFlagReady, producer.TxnStatus())
+	require.NoError(t, producer.Close())
+}
+
+func TestClassifyKafkaError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		code      string
+		retryable bool
+	}{
+		{"leader moved", sarama.ErrNotLeaderForPartition, ErrCodeWriteFailed, true},
+		{"message too large", sarama.ErrMessageSizeTooLarge, ErrCodeInvalidRecord, false},
+		{"unknown topic", sarama.ErrUnknownTopicOrPartition, ErrCodeTableNotFound, false},
+		{"not authorized", sarama.ErrTopicAuthorizationFailed, ErrCodeInvalidConfig, false},
+		{"timed out", sarama.ErrRequestTimedOut, ErrCodeTimeout, true},
+		{"no brokers", sarama.ErrOutOfBrokers, ErrCodeConnectionFailed, true},
+		{"producer errors", sarama.ProducerErrors{
+			{Msg: &sarama.ProducerMessage{Topic: "a"}, Err: sarama.ErrInvalidMessage},
+			{Msg: &sarama.ProducerMessage{Topic: "a"}, Err: sarama.ErrNotEnoughReplicas},
+		}, ErrCodeWriteFailed, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := classifyKafkaError("orders", tt.err)
+			var destErr *DestinationError
+			require.ErrorAs(t, err, &destErr)
+			assert.Equal(t, tt.code, destErr.Code)
+			assert.Equal(t, tt.retryable, IsRetryable(err))
+		})
+	}
+}
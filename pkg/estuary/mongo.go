@@ -2,7 +2,9 @@ package estuary
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 	"time"
 
@@ -12,6 +14,7 @@ import (
 	"github.com/pquerna/ffjson/ffjson"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
 type MongoEndpoint struct {
@@ -219,100 +222,122 @@ func convertValue(value interface{}) interface{} {
 	}
 }
 
-func (std MongoEndpoint) WriteEvent(record *events.RecordEvent) {
+// Write applies a record to the collection: inserts add the document, updates
+// set its fields and deletes remove it by document key
+func (std MongoEndpoint) Write(ctx context.Context, record *events.RecordEvent) error {
+	return std.WriteBatch(ctx, []*events.RecordEvent{record})
+}
 
-	// Guard against empty or nil Data to prevent JSON unmarshal errors
-	if len(record.Data) == 0 {
-		logger.Warn().
-		Str("action", record.Action).
-		Str("schema", record.Schema).
-		Str("collection", record.Collection).
-		Msg("Skipping event with empty Data field")
-		return
+// WriteBatch applies records in order with a single ordered bulk write. The
+// bulk write stops at the first failing record, the records before it stay
+// applied.
+func (std MongoEndpoint) WriteBatch(ctx context.Context, records []*events.RecordEvent) error {
+	models, err := std.writeModels(records)
+	if err != nil || len(models) == 0 {
+		return err
 	}
 
-	row := make(map[string]interface{})
-	err := ffjson.Unmarshal(record.Data, &row)
+	result, err := std.collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(true))
 	if err != nil {
-		logger.Error().Err(err).
-		Str("action", record.Action).
-		Str("schema", record.Schema).
-		Str("collection", record.Collection).
-		Int("data_size", len(record.Data)).
-		Msgf("Error while unmarshal record")
-		return
+		classified := classifyMongoError(std.collectionName, fmt.Errorf("failed to bulk write %d documents: %w", len(models), err))
+		var bulkErr mongo.BulkWriteException
+		var destErr *DestinationError
+		if errors.As(err, &bulkErr) && len(bulkErr.WriteErrors) > 0 && errors.As(classified, &destErr) {
+			// The records before the failing one are written, inserting them
+			// again would fail on their duplicate keys
+			if destErr.Details == nil {
+				destErr.Details = make(map[string]interface{})
+			}
+			destErr.Details["written"] = bulkErr.WriteErrors[0].Index
+		}
+		return classified
 	}
-	
-	// Convert MongoDB Extended JSON to native types
-	row = convertExtendedJSON(row)
-	
-	logger.Debug().Str("action", record.Action).Str("name", std.collection.Name()).Msgf("write event: %+v", row)
+	logger.Debug().
+		Str("name", std.collectionName).
+		Int64("InsertedCount", result.InsertedCount).
+		Int64("MatchedCount", result.MatchedCount).
+		Int64("ModifiedCount", result.ModifiedCount).
+		Int64("DeletedCount", result.DeletedCount).
+		Msg("records written properly")
+	return nil
+}
 
-	switch record.Action {
-	case "insert":
-		// For inserts, we don't need to parse OldData since it's empty
-		// The document already contains all necessary data including _id
-		insertResult, err := std.collection.InsertOne(context.TODO(), row)
-		if err != nil {
-			logger.Error().Err(err).Msgf("Error while inserting document")
-			return
-		}
-		logger.Debug().Msgf("Inserted a single document: %s", insertResult.InsertedID)
-		
-	case "delete":
-		// For deletes, we need the key from OldData to identify the document
-		if len(record.DocumentKey) == 0 {
-			logger.Error().Msg("Delete operation requires DocumentKey with document key")
-			return
-		}
+// WriteTransaction applies records atomically, with an ordered bulk write in
+// a multi-document transaction. Transactions need a replica set or a sharded
+// cluster. When a record fails the transaction is aborted and none of the
+// records is written.
+func (std MongoEndpoint) WriteTransaction(ctx context.Context, records []*events.RecordEvent) error {
+	models, err := std.writeModels(records)
+	if err != nil || len(models) == 0 {
+		return err
+	}
 
-		// Parse the document key directly as it contains _id
-		var documentKey map[string]interface{}
-		err = ffjson.Unmarshal(record.DocumentKey, &documentKey)
-		if err != nil {
-			logger.Error().Err(err).Msgf("Error while Unmarshal document key for delete")
-			return
-		}
+	session, err := std.client.StartSession()
+	if err != nil {
+		return classifyMongoError(std.collectionName, fmt.Errorf("failed to start session: %w", err))
+	}
+	defer session.EndSession(ctx)
 
-		// Convert Extended JSON format if needed
-		documentKey = convertExtendedJSON(documentKey)
+	_, err = session.WithTransaction(ctx, func(ctx context.Context) (any, error) {
+		return std.collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(true))
+	})
+	if err != nil {
+		return classifyMongoError(std.collectionName, fmt.Errorf("failed to write transaction of %d documents: %w", len(models), err))
+	}
+	logger.Debug().
+		Str("name", std.collectionName).
+		Int("records", len(models)).
+		Msg("transaction written properly")
+	return nil
+}
 
-		filter, err := bson.Marshal(documentKey)
+// writeModels builds the bulk write models of records
+func (std MongoEndpoint) writeModels(records []*events.RecordEvent) ([]mongo.WriteModel, error) {
+	models := make([]mongo.WriteModel, 0, len(records))
+	for _, record := range records {
+		model, err := std.writeModel(record)
 		if err != nil {
-			logger.Error().Err(err).Msgf("Error while Marshal filter")
-			return
+			return nil, err
 		}
+		models = append(models, model)
+	}
+	return models, nil
+}
 
-		deleteResult, err := std.collection.DeleteOne(context.TODO(), filter)
-		if err != nil {
-			logger.Error().Err(err).Msgf("Failed to delete record")
-			return
-		}
-		logger.Debug().Int("DeletedCount", int(deleteResult.DeletedCount)).Msg("record deleted properly")
-		
+// writeModel builds the bulk write model of a record
+func (std MongoEndpoint) writeModel(record *events.RecordEvent) (mongo.WriteModel, error) {
+	destination := string(config.TargetTypeMongoDB)
 
-	case "update":
-		// For updates, we need the key from OldData to identify the document
-		if len(record.DocumentKey) == 0 {
-			logger.Error().Msg("Update operation requires DocumentKey with document key")
-			return
+	var row map[string]interface{}
+	if len(record.Data) > 0 {
+		if err := ffjson.Unmarshal(record.Data, &row); err != nil {
+			return nil, NewRecordError(destination, std.collectionName, "failed to unmarshal record data", err)
 		}
+		// Convert MongoDB Extended JSON to native types
+		row = convertExtendedJSON(row)
+	} else if record.Action != events.DeleteAction {
+		return nil, NewRecordError(destination, std.collectionName, fmt.Sprintf("%s record has no data", record.Action), nil)
+	}
+
+	logger.Debug().Str("action", record.Action).Str("name", std.collectionName).Msgf("write event: %+v", row)
 
-		// Parse the document key directly as it contains _id
-		var documentKey map[string]interface{}
-		err = ffjson.Unmarshal(record.DocumentKey, &documentKey)
+	switch record.Action {
+	case "insert":
+		// For inserts, we don't need to parse OldData since it's empty
+		// The document already contains all necessary data including _id
+		return mongo.NewInsertOneModel().SetDocument(row), nil
+
+	case "delete":
+		filter, err := std.documentFilter(record)
 		if err != nil {
-			logger.Error().Err(err).Msgf("Error while Unmarshal document key for update")
-			return
+			return nil, err
 		}
+		return mongo.NewDeleteOneModel().SetFilter(filter), nil
 
-		// Convert Extended JSON format if needed
-		documentKey = convertExtendedJSON(documentKey)
-
-		filter, err := bson.Marshal(documentKey)
+	case "update":
+		filter, err := std.documentFilter(record)
 		if err != nil {
-			logger.Error().Err(err).Msgf("Error while Marshal filter")
-			return
+			return nil, err
 		}
 
 		// For updates, we need to ensure the _id from the document key matches the _id in the full document.
@@ -321,8 +346,7 @@ func (std MongoEndpoint) WriteEvent(record *events.RecordEvent) {
 		// 1. Extract _id from the document key filter
 		var keyFilterMap map[string]interface{}
 		if err := bson.Unmarshal(filter, &keyFilterMap); err != nil {
-		logger.Error().Err(err).Msg("Failed to unmarshal document key filter for verification")
-		return
+			return nil, NewRecordError(destination, std.collectionName, "failed to unmarshal document key filter", err)
 		}
 		keyID, keyOk := keyFilterMap["_id"]
 
@@ -331,11 +355,8 @@ func (std MongoEndpoint) WriteEvent(record *events.RecordEvent) {
 
 		// 3. Verify that the _id fields match
 		if !keyOk || !docOk || keyID != docID {
-		logger.Error().
-		Interface("key_id", keyID).
-		Interface("doc_id", docID).
-		Msg("Mismatch between document key _id and payload _id. Halting update.")
-		return
+			return nil, NewRecordError(destination, std.collectionName,
+				fmt.Sprintf("document key _id %v does not match payload _id %v", keyID, docID), nil)
 		}
 
 		// 4. If they match, remove the _id from the payload to prevent immutable field error
@@ -343,18 +364,141 @@ func (std MongoEndpoint) WriteEvent(record *events.RecordEvent) {
 
 		// 5. Construct the update operation
 		update := bson.M{
-		"$set": row,
+			"$set": row,
 		}
+		return mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update), nil
 
-		// 6. Execute the update
-		updateResult, err := std.collection.UpdateOne(context.TODO(), filter, update)
-		if err != nil {
-			logger.Error().Err(err).Msgf("Failed to update record")
-			return
-		}
-		logger.Debug().Int("MatchedCount", int(updateResult.MatchedCount)).Int("ModifiedCount", int(updateResult.ModifiedCount)).Msg("record Updated properly")
-		
 	default:
-		logger.Error().Str("action", record.Action).Msg("Unknown action type")
+		return nil, newDestinationError(ErrCodeUnsupportedOperation, destination, std.collectionName, fmt.Sprintf("unknown action type %q", record.Action), nil)
+	}
+}
+
+// documentFilter builds the filter matching the document of an update or delete
+func (std MongoEndpoint) documentFilter(record *events.RecordEvent) (bson.Raw, error) {
+	destination := string(config.TargetTypeMongoDB)
+	if len(record.DocumentKey) == 0 {
+		return nil, NewRecordError(destination, std.collectionName, fmt.Sprintf("%s operation requires a document key", record.Action), nil)
+	}
+
+	// The document key contains the _id
+	var documentKey map[string]interface{}
+	if err := ffjson.Unmarshal(record.DocumentKey, &documentKey); err != nil {
+		return nil, NewRecordError(destination, std.collectionName, "failed to unmarshal document key", err)
+	}
+
+	// Convert Extended JSON format if needed
+	filter, err := bson.Marshal(convertExtendedJSON(documentKey))
+	if err != nil {
+		return nil, NewRecordError(destination, std.collectionName, "failed to marshal document key filter", err)
+	}
+	return filter, nil
+}
+
+// classifyMongoError classifies a MongoDB write error. Rejected documents,
+// such as duplicate keys or failed validation, are permanent; network errors,
+// timeouts and errors labelled retryable by the server are transient.
+func classifyMongoError(collection string, err error) error {
+	destination := string(config.TargetTypeMongoDB)
+	switch {
+	case err == nil:
+		return nil
+	case mongo.IsTimeout(err):
+		return newDestinationError(ErrCodeTimeout, destination, collection, "write timed out", err)
+	case mongo.IsNetworkError(err):
+		return NewConnectionError(destination, "connection lost", err)
+	case mongo.IsDuplicateKeyError(err):
+		return NewRecordError(destination, collection, "duplicate key", err)
 	}
+
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) && (serverErr.HasErrorLabel("RetryableWriteError") || serverErr.HasErrorLabel("TransientTransactionError")) {
+		return NewWriteError(destination, collection, "transient write failure", err)
+	}
+	var writeErr mongo.WriteException
+	if errors.As(err, &writeErr) && writeErr.WriteConcernError == nil && len(writeErr.WriteErrors) > 0 {
+		return NewRecordError(destination, collection, "document rejected", err)
+	}
+	return classifyError(destination, collection, err)
+}
+
+// Ping checks the connection to the primary
+func (std MongoEndpoint) Ping(ctx context.Context) error {
+	return std.client.Ping(ctx, nil)
+}
+
+// ListTables lists the collections of the database
+func (std MongoEndpoint) ListTables(ctx context.Context) ([]string, error) {
+	names, err := std.client.Database(std.db).ListCollectionNames(ctx, bson.D{})
+	if err != nil {
+		return nil, classifyMongoError("", fmt.Errorf("failed to list collections: %w", err))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// GetSchema describes a collection by the fields of its newest document.
+// Collections have no fixed schema, so other documents may differ.
+func (std MongoEndpoint) GetSchema(ctx context.Context, tableName string) (*TableSchema, error) {
+	names, err := std.client.Database(std.db).ListCollectionNames(ctx, bson.D{{Key: "name", Value: tableName}})
+	if err != nil {
+		return nil, classifyMongoError(tableName, fmt.Errorf("failed to read collection %s: %w", tableName, err))
+	}
+	if len(names) == 0 {
+		return nil, newDestinationError(ErrCodeTableNotFound, string(config.TargetTypeMongoDB), tableName, fmt.Sprintf("collection %s does not exist", tableName), nil)
+	}
+
+	schema := &TableSchema{Name: tableName, PrimaryKey: []string{"_id"}, Metadata: map[string]interface{}{"inferred": true}}
+	raw, err := std.client.Database(std.db).Collection(tableName).
+		FindOne(ctx, bson.D{}, options.FindOne().SetSort(bson.D{{Key: "_id", Value: -1}})).Raw()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return schema, nil
+	}
+	if err != nil {
+		return nil, classifyMongoError(tableName, fmt.Errorf("failed to sample collection %s: %w", tableName, err))
+	}
+	elements, err := raw.Elements()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read document of %s: %w", tableName, err)
+	}
+	for _, element := range elements {
+		schema.Columns = append(schema.Columns, ColumnDefinition{
+			Name:     element.Key(),
+			Type:     element.Value().Type.String(),
+			Nullable: element.Key() != "_id",
+		})
+	}
+	return schema, nil
+}
+
+// CreateTable creates a collection
+func (std MongoEndpoint) CreateTable(ctx context.Context, schema TableSchema) error {
+	if err := std.client.Database(std.db).CreateCollection(ctx, schema.Name); err != nil {
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) && cmdErr.Name == "NamespaceExists" {
+			return nil
+		}
+		return classifyMongoError(schema.Name, fmt.Errorf("failed to create collection %s: %w", schema.Name, err))
+	}
+	return nil
+}
+
+// DropTable drops a collection
+func (std MongoEndpoint) DropTable(ctx context.Context, tableName string) error {
+	if err := std.client.Database(std.db).Collection(tableName).Drop(ctx); err != nil {
+		return classifyMongoError(tableName, fmt.Errorf("failed to drop collection %s: %w", tableName, err))
+	}
+	return nil
+}
+
+// TruncateTable deletes all documents of a collection, keeping its indexes
+func (std MongoEndpoint) TruncateTable(ctx context.Context, tableName string) error {
+	if _, err := std.client.Database(std.db).Collection(tableName).DeleteMany(ctx, bson.D{}); err != nil {
+		return classifyMongoError(tableName, fmt.Errorf("failed to truncate collection %s: %w", tableName, err))
+	}
+	return nil
+}
+
+// Close disconnects the client
+func (std MongoEndpoint) Close() error {
+	return std.client.Disconnect(context.Background())
 }
@@ -0,0 +1,42 @@
+package estuary
+
+import (
+	"testing"
+
+	"github.com/cohenjo/replicator/pkg/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func TestMongoEndpoint_WriteModel(t *testing.T) {
+	endpoint := MongoEndpoint{collectionName: "orders"}
+	key := []byte(`{"_id":1}`)
+
+	model, err := endpoint.writeModel(&events.RecordEvent{Action: "insert", Data: []byte(`{"_id":1,"qty":{"$numberInt":"2"}}`)})
+	require.NoError(t, err)
+	insert, ok := model.(*mongo.InsertOneModel)
+	require.True(t, ok)
+	assert.Equal(t, map[string]interface{}{"_id": float64(1), "qty": 2}, insert.Document)
+
+	model, err = endpoint.writeModel(&events.RecordEvent{Action: "update", Data: []byte(`{"_id":1,"qty":3}`), DocumentKey: key})
+	require.NoError(t, err)
+	update, ok := model.(*mongo.UpdateOneModel)
+	require.True(t, ok)
+	assert.Equal(t, bson.M{"$set": map[string]interface{}{"qty": float64(3)}}, update.Update, "the immutable _id is not set")
+
+	model, err = endpoint.writeModel(&events.RecordEvent{Action: "delete", DocumentKey: key})
+	require.NoError(t, err)
+	assert.IsType(t, &mongo.DeleteOneModel{}, model)
+
+	_, err = endpoint.writeModel(&events.RecordEvent{Action: "update", Data: []byte(`{"_id":2}`), DocumentKey: key})
+	require.Error(t, err)
+	assert.False(t, IsRetryable(err), "a mismatched document key is a record error")
+	_, err = endpoint.writeModel(&events.RecordEvent{Action: "delete"})
+	assert.Error(t, err, "deletes need a document key")
+	_, err = endpoint.writeModel(&events.RecordEvent{Action: "insert"})
+	assert.Error(t, err, "inserts need data")
+	_, err = endpoint.writeModel(&events.RecordEvent{Action: "upsert", Data: []byte(`{}`)})
+	assert.Error(t, err)
+}
@@ -1,100 +1,755 @@
 package estuary
 
 import (
-	"encoding/hex"
+	"container/list"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/cohenjo/replicator/pkg/config"
 	"github.com/cohenjo/replicator/pkg/events"
+	"github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
-	"github.com/pquerna/ffjson/ffjson"
-	"github.com/rs/zerolog/log"
 )
 
+const (
+	// mysqlMaxParameters is the placeholder limit of a prepared statement
+	mysqlMaxParameters = 65535
+
+	// mysqlDefaultBatchRows is the number of rows per multi-row upsert
+	mysqlDefaultBatchRows = 500
+
+	// mysqlMaxPreparedStatements bounds the prepared statement cache
+	mysqlMaxPreparedStatements = 256
+
+	mysqlDestination = string(config.TargetTypeMySQL)
+)
+
+// MySQLEndpoint writes records to MySQL tables. Inserts are upserts on the
+// table keys, updates and deletes match rows by the document key. A batch is
+// applied in a single transaction with cached prepared statements.
 type MySQLEndpoint struct {
-	db        string
-	tableName string
-	// table     *schema.Table
 	conn       *sqlx.DB
-	insertStmt *sqlx.Stmt
-	updateStmt sqlx.Stmt
-	deleteStmt sqlx.Stmt
-}
-
-func NewMySQLEndpoint(streamConfig *config.WaterFlowsConfig) (endpoint MySQLEndpoint) {
-	endpoint.db = streamConfig.Schema
-	endpoint.tableName = streamConfig.Collection
-
-	streamUri := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?interpolateParams=true",
-		config.Global.MyDBUser,
-		config.Global.MyDBPasswd,
-		streamConfig.Host, streamConfig.Port,
-		streamConfig.Schema,
-	)
-	conn, err := sqlx.Open("mysql", streamUri)
+	db         string
+	tableName  string // fixed target table; the record collection when empty
+	keyColumns []string
+	batchRows  int
+
+	mu     sync.Mutex
+	tables map[string]*tableLayout
+	stmts  *statementCache
+	warned map[string]bool
+}
+
+// NewMySQLEndpoint creates a MySQL endpoint
+func NewMySQLEndpoint(streamConfig *config.WaterFlowsConfig) (*MySQLEndpoint, error) {
+	dsn, err := mysqlDSN(streamConfig)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := sqlx.Open("mysql", dsn)
 	if err != nil {
-		logger.Error().Err(err).Msgf("Error while connecting to source MySQL db")
+		return nil, fmt.Errorf("failed to open MySQL connection: %w", err)
 	}
-	endpoint.conn = conn
 
-	// endpoint.insertStmt, _ = conn.Preparex("INSERT INTO " + collection + " VALUES(?)")
+	endpoint := newMySQLEndpoint(conn, streamConfig)
+	logger.Info().
+		Str("db", endpoint.db).
+		Str("table", endpoint.tableName).
+		Int("batch_rows", endpoint.batchRows).
+		Msg("Created MySQL endpoint")
+	return endpoint, nil
+}
 
+func newMySQLEndpoint(conn *sqlx.DB, streamConfig *config.WaterFlowsConfig) *MySQLEndpoint {
+	endpoint := &MySQLEndpoint{
+		conn:       conn,
+		db:         streamConfig.Schema,
+		tableName:  streamConfig.Collection,
+		keyColumns: streamConfig.MySQLKeyColumns,
+		batchRows:  streamConfig.MySQLBatchRows,
+		tables:     make(map[string]*tableLayout),
+		stmts:      newStatementCache(mysqlMaxPreparedStatements),
+		warned:     make(map[string]bool),
+	}
+	if endpoint.batchRows <= 0 {
+		endpoint.batchRows = mysqlDefaultBatchRows
+	}
 	return endpoint
 }
 
-func (std MySQLEndpoint) WriteEvent(record *events.RecordEvent) {
+// mysqlDSN returns the data source name of the target. Credentials come from
+// the target configuration, falling back to the legacy global MySQL user.
+func mysqlDSN(streamConfig *config.WaterFlowsConfig) (string, error) {
+	cfg := mysql.NewConfig()
+	if streamConfig.MySQLURI != "" {
+		parsed, err := mysql.ParseDSN(strings.TrimPrefix(streamConfig.MySQLURI, "mysql://"))
+		if err != nil {
+			return "", fmt.Errorf("failed to parse MySQL DSN: %w", err)
+		}
+		cfg = parsed
+	} else {
+		port := streamConfig.Port
+		if port == 0 {
+			port = 3306
+		}
+		cfg.Net = "tcp"
+		cfg.Addr = net.JoinHostPort(streamConfig.Host, strconv.Itoa(port))
+		cfg.DBName = streamConfig.Schema
+		cfg.User, cfg.Passwd = streamConfig.MySQLUser, streamConfig.MySQLPassword
+		if cfg.User == "" && config.Global != nil {
+			cfg.User, cfg.Passwd = config.Global.MyDBUser, config.Global.MyDBPasswd
+		}
+	}
+	// Updates of unchanged rows still count as found, so missing rows can be told apart
+	cfg.ClientFoundRows = true
+	return cfg.FormatDSN(), nil
+}
+
+// Write writes a single record
+func (std *MySQLEndpoint) Write(ctx context.Context, record *events.RecordEvent) error {
+	return std.WriteBatch(ctx, []*events.RecordEvent{record})
+}
 
-	row := make(map[string]interface{})
-	err := ffjson.Unmarshal(record.Data, &row)
+// WriteBatch applies records in order within one transaction. Consecutive
+// inserts become multi-row upserts; updates and deletes run row by row.
+func (std *MySQLEndpoint) WriteBatch(ctx context.Context, records []*events.RecordEvent) error {
+	changes := make([]*rowChange, 0, len(records))
+	for _, record := range records {
+		change, err := newRowChange(record, std.tableName)
+		if err != nil {
+			return NewRecordError(mysqlDestination, record.Collection, "invalid record", err)
+		}
+		changes = append(changes, change)
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	tx, err := std.conn.BeginTxx(ctx, nil)
 	if err != nil {
-		log.Error().Err(err).Msgf("Error while connecting to source MySQL db")
-	}
-
-	switch record.Action {
-	case "insert":
-		// @todo: we can do this on initialization of the endpoint.
-		var values strings.Builder
-		values.WriteString("insert into ")
-		values.WriteString(std.tableName)
-		values.WriteString(" values(")
-		first := true
-		for key, value := range row {
-			logger.Info().Msgf("Key: %s, type ", key)
-			switch v := value.(type) {
-			case string:
-				logger.Info().Msgf("Key: %s, value %s - string ", key, v)
-				row[key] = v
-			default:
-				logger.Info().Msgf("Key: %s, is not string", key)
+		return classifyMySQLError("", fmt.Errorf("failed to begin transaction: %w", err))
+	}
+	defer tx.Rollback()
+
+	if err := std.applyChanges(ctx, tx, changes); err != nil {
+		// The table may have changed underneath the cached layout
+		std.forgetTables(changes)
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return classifyMySQLError("", fmt.Errorf("failed to commit transaction: %w", err))
+	}
+
+	recordsSent.Add(float64(len(records)))
+	return nil
+}
+
+// applyChanges applies runs of changes with the same action and table
+func (std *MySQLEndpoint) applyChanges(ctx context.Context, tx *sqlx.Tx, changes []*rowChange) error {
+	for start := 0; start < len(changes); {
+		end := start + 1
+		for end < len(changes) && changes[end].action == changes[start].action && changes[end].table == changes[start].table {
+			end++
+		}
+		run := changes[start:end]
+		start = end
+
+		table, err := std.table(ctx, run[0].table)
+		if err != nil {
+			return classifyMySQLError(run[0].table, err)
+		}
+		if missing := table.missingColumns(run, func(interface{}) string { return "" }); len(missing) > 0 {
+			std.warnMissingColumns(run[0].table, missing)
+		}
+		keys := keyColumns(table, std.keyColumns, run[0])
+
+		switch run[0].action {
+		case events.InsertAction:
+			err = std.upsert(ctx, tx, run[0].table, table, keys, run)
+		case events.UpdateAction:
+			err = std.update(ctx, tx, run[0].table, table, keys, run)
+		case events.DeleteAction:
+			err = std.delete(ctx, tx, run[0].table, table, keys, run)
+		}
+		if err != nil {
+			return classifyMySQLError(run[0].table, err)
+		}
+	}
+	return nil
+}
+
+// upsert inserts rows, updating the existing row on a duplicate key
+func (std *MySQLEndpoint) upsert(ctx context.Context, tx *sqlx.Tx, name string, table *tableLayout, keys []string, run []*rowChange) error {
+	for _, group := range groupByColumns(dedupeByKey(run, keys), table) {
+		columns := group.columns
+		chunk := std.batchRows
+		if limit := mysqlMaxParameters / len(columns); chunk > limit {
+			chunk = limit
+		}
+
+		// Rows are written in full chunks and the remainder row by row, so a
+		// table and its columns need two statements whatever the batch sizes
+		for start := 0; start < len(group.changes); {
+			end := start + chunk
+			if end > len(group.changes) {
+				end = start + 1
+			}
+			args := make([]interface{}, 0, (end-start)*len(columns))
+			for _, change := range group.changes[start:end] {
+				for _, column := range columns {
+					args = append(args, mysqlValue(change.row[column], table.columns[column]))
+				}
 			}
-			if !first {
-				values.WriteString(",")
+			if _, err := std.exec(ctx, tx, name, mysqlUpsertSQL(std.qualified(name), columns, keys, end-start), args...); err != nil {
+				return fmt.Errorf("failed to upsert into %s: %w", name, err)
 			}
-			values.WriteString(fmt.Sprintf(":%s", key))
-			// values.WriteString("?")
-			first = false
+			start = end
+		}
+	}
+	return nil
+}
 
+// update updates rows by key. Rows that do not exist yet are upserted.
+func (std *MySQLEndpoint) update(ctx context.Context, tx *sqlx.Tx, name string, table *tableLayout, keys []string, run []*rowChange) error {
+	if len(keys) == 0 {
+		return newDestinationError(ErrCodeInvalidSchema, mysqlDestination, name, "cannot update: no primary key or key columns", nil)
+	}
+
+	var missing []*rowChange
+	for _, change := range run {
+		columns := table.knownColumns(change.row)
+		if len(columns) == 0 {
+			continue
+		}
+		args := make([]interface{}, 0, len(columns)+len(keys))
+		for _, column := range columns {
+			args = append(args, mysqlValue(change.row[column], table.columns[column]))
+		}
+		for _, key := range keys {
+			args = append(args, mysqlValue(change.keyValue(key), table.columns[key]))
 		}
 
-		values.WriteString(")")
-		row["id"], _ = hex.DecodeString(row["id"].(string))
-		logger.Info().Msgf("Insert stmnt: %s, record: %v", values.String(), row)
-		tx := std.conn.MustBegin()
-		res, err := tx.NamedExec(values.String(), row)
+		result, err := std.exec(ctx, tx, name, mysqlUpdateSQL(std.qualified(name), columns, keys), args...)
 		if err != nil {
-			log.Error().Err(err).Msgf("Error inserting to MySQL. res: %v", res)
+			return fmt.Errorf("failed to update %s: %w", name, err)
 		}
-		err = tx.Commit()
-		if err != nil {
-			log.Error().Err(err).Msgf("Commit error")
+		if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+			missing = append(missing, change)
+		}
+	}
+
+	if len(missing) > 0 {
+		logger.Debug().Str("table", name).Int("rows", len(missing)).Msg("Updated rows not found, upserting")
+		return std.upsert(ctx, tx, name, table, keys, missing)
+	}
+	return nil
+}
+
+// delete deletes rows by key
+func (std *MySQLEndpoint) delete(ctx context.Context, tx *sqlx.Tx, name string, table *tableLayout, keys []string, run []*rowChange) error {
+	if len(keys) == 0 {
+		return newDestinationError(ErrCodeInvalidSchema, mysqlDestination, name, "cannot delete: no primary key or key columns", nil)
+	}
+
+	query := mysqlDeleteSQL(std.qualified(name), keys)
+	for _, change := range run {
+		args := make([]interface{}, len(keys))
+		for i, key := range keys {
+			args[i] = mysqlValue(change.keyValue(key), table.columns[key])
+		}
+		if _, err := std.exec(ctx, tx, name, query, args...); err != nil {
+			return fmt.Errorf("failed to delete from %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// exec runs a statement on a table in the transaction, through the prepared
+// statement cache
+func (std *MySQLEndpoint) exec(ctx context.Context, tx *sqlx.Tx, table, query string, args ...interface{}) (sql.Result, error) {
+	stmt, err := std.statement(ctx, table, query)
+	if err != nil {
+		return nil, err
+	}
+	return tx.StmtContext(ctx, stmt).ExecContext(ctx, args...)
+}
+
+// statement returns the cached prepared statement of a query on a table,
+// preparing it on first use
+func (std *MySQLEndpoint) statement(ctx context.Context, table, query string) (*sql.Stmt, error) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+
+	if stmt := std.stmts.get(query); stmt != nil {
+		return stmt, nil
+	}
+	stmt, err := std.conn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	std.stmts.put(table, query, stmt)
+	return stmt, nil
+}
+
+// table returns the cached layout of a table, reading it on first use
+func (std *MySQLEndpoint) table(ctx context.Context, name string) (*tableLayout, error) {
+	std.mu.Lock()
+	table := std.tables[name]
+	std.mu.Unlock()
+	if table != nil {
+		return table, nil
+	}
+
+	schema := std.schemaArg()
+	rows, err := std.conn.QueryContext(ctx,
+		`SELECT COLUMN_NAME, DATA_TYPE FROM information_schema.COLUMNS
+		 WHERE TABLE_SCHEMA = COALESCE(?, DATABASE()) AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION`, schema, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns of %s: %w", name, err)
+	}
+	defer rows.Close()
+	table = &tableLayout{columns: make(map[string]string)}
+	for rows.Next() {
+		var column, dataType string
+		if err := rows.Scan(&column, &dataType); err != nil {
+			return nil, fmt.Errorf("failed to read columns of %s: %w", name, err)
 		}
+		table.columns[column] = strings.ToLower(dataType)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read columns of %s: %w", name, err)
+	}
+	if len(table.columns) == 0 {
+		return nil, newDestinationError(ErrCodeTableNotFound, mysqlDestination, name, fmt.Sprintf("table %s does not exist", std.qualified(name)), nil)
+	}
+
+	if err := std.conn.SelectContext(ctx, &table.primaryKey,
+		`SELECT COLUMN_NAME FROM information_schema.KEY_COLUMN_USAGE
+		 WHERE TABLE_SCHEMA = COALESCE(?, DATABASE()) AND TABLE_NAME = ? AND CONSTRAINT_NAME = 'PRIMARY'
+		 ORDER BY ORDINAL_POSITION`, schema, name); err != nil {
+		return nil, fmt.Errorf("failed to read primary key of %s: %w", name, err)
+	}
 
-	case "delete":
-	case "update":
-		logger.Info().Msgf("update not yes supported , %s", record.Action)
+	std.mu.Lock()
+	std.tables[name] = table
+	std.mu.Unlock()
+	return table, nil
+}
 
+// warnMissingColumns logs once per column that values without a column are dropped
+func (std *MySQLEndpoint) warnMissingColumns(name string, missing []ColumnDefinition) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	for _, column := range missing {
+		if key := name + "." + column.Name; !std.warned[key] {
+			std.warned[key] = true
+			logger.Warn().Str("table", name).Str("column", column.Name).Msg("Column does not exist in MySQL table, dropping its values")
+		}
 	}
+}
 
-	// logger.Info().Msgf("record: %v", record)
+// forgetTables drops the cached layout and statements of the tables of failed changes
+func (std *MySQLEndpoint) forgetTables(changes []*rowChange) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	for _, change := range changes {
+		delete(std.tables, change.table)
+		std.stmts.forget(change.table)
+	}
+}
+
+// classifyMySQLError classifies a MySQL write error. Deadlocks, lock waits,
+// lost connections and read-only servers during a failover are transient;
+// other server errors reject the statement, e.g. a duplicate key, a bad value
+// or a missing column, and fail again on retry.
+func classifyMySQLError(table string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var destErr *DestinationError
+	if errors.As(err, &destErr) {
+		return err
+	}
+
+	var serverErr *mysql.MySQLError
+	if errors.As(err, &serverErr) {
+		switch serverErr.Number {
+		case 1205, 1213: // lock wait timeout, deadlock
+			return NewTransactionError(mysqlDestination, "transaction rolled back", err)
+		case 1040, 1053, 1927, 2006, 2013: // too many connections, shutdown, killed, gone away, lost
+			return NewConnectionError(mysqlDestination, "connection lost", err)
+		case 1290, 1792, 1836: // read-only server or transaction
+			return NewWriteError(mysqlDestination, table, "server is read-only", err)
+		case 1146: // no such table
+			return newDestinationError(ErrCodeTableNotFound, mysqlDestination, table, "table does not exist", err)
+		case 1054, 1364: // unknown column, column without default
+			return newDestinationError(ErrCodeInvalidSchema, mysqlDestination, table, "row does not match the table", err)
+		}
+		return NewRecordError(mysqlDestination, table, "row rejected", err)
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) {
+		return NewConnectionError(mysqlDestination, "connection lost", err)
+	}
+	return classifyError(mysqlDestination, table, err)
+}
+
+// qualified returns the quoted table name, in the configured database if any
+func (std *MySQLEndpoint) qualified(name string) string {
+	if std.db == "" {
+		return mysqlQuote(name)
+	}
+	return mysqlQuote(std.db) + "." + mysqlQuote(name)
+}
+
+// Ping checks the connection to the server
+func (std *MySQLEndpoint) Ping(ctx context.Context) error {
+	return std.conn.PingContext(ctx)
+}
+
+// ListTables lists the base tables of the database
+func (std *MySQLEndpoint) ListTables(ctx context.Context) ([]string, error) {
+	var tables []string
+	if err := std.conn.SelectContext(ctx, &tables,
+		`SELECT TABLE_NAME FROM information_schema.TABLES
+		 WHERE TABLE_SCHEMA = COALESCE(?, DATABASE()) AND TABLE_TYPE = 'BASE TABLE' ORDER BY TABLE_NAME`, std.schemaArg()); err != nil {
+		return nil, classifyMySQLError("", fmt.Errorf("failed to list tables: %w", err))
+	}
+	return tables, nil
+}
+
+// GetSchema reads the columns and primary key of a table
+func (std *MySQLEndpoint) GetSchema(ctx context.Context, tableName string) (*TableSchema, error) {
+	var columns []struct {
+		Name     string         `db:"COLUMN_NAME"`
+		Type     string         `db:"COLUMN_TYPE"`
+		Nullable string         `db:"IS_NULLABLE"`
+		Default  sql.NullString `db:"COLUMN_DEFAULT"`
+	}
+	if err := std.conn.SelectContext(ctx, &columns,
+		`SELECT COLUMN_NAME, COLUMN_TYPE, IS_NULLABLE, COLUMN_DEFAULT FROM information_schema.COLUMNS
+		 WHERE TABLE_SCHEMA = COALESCE(?, DATABASE()) AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION`, std.schemaArg(), tableName); err != nil {
+		return nil, classifyMySQLError(tableName, fmt.Errorf("failed to read columns of %s: %w", tableName, err))
+	}
+	if len(columns) == 0 {
+		return nil, newDestinationError(ErrCodeTableNotFound, mysqlDestination, tableName, fmt.Sprintf("table %s does not exist", std.qualified(tableName)), nil)
+	}
+
+	schema := &TableSchema{Name: tableName}
+	for _, column := range columns {
+		definition := ColumnDefinition{Name: column.Name, Type: column.Type, Nullable: column.Nullable == "YES"}
+		if column.Default.Valid {
+			definition.DefaultValue = column.Default.String
+		}
+		schema.Columns = append(schema.Columns, definition)
+	}
+	if err := std.conn.SelectContext(ctx, &schema.PrimaryKey,
+		`SELECT COLUMN_NAME FROM information_schema.KEY_COLUMN_USAGE
+		 WHERE TABLE_SCHEMA = COALESCE(?, DATABASE()) AND TABLE_NAME = ? AND CONSTRAINT_NAME = 'PRIMARY'
+		 ORDER BY ORDINAL_POSITION`, std.schemaArg(), tableName); err != nil {
+		return nil, classifyMySQLError(tableName, fmt.Errorf("failed to read primary key of %s: %w", tableName, err))
+	}
+	return schema, nil
+}
+
+// CreateTable creates a table unless it exists
+func (std *MySQLEndpoint) CreateTable(ctx context.Context, schema TableSchema) error {
+	if _, err := std.conn.ExecContext(ctx, mysqlCreateTableSQL(std.qualified(schema.Name), schema)); err != nil {
+		return classifyMySQLError(schema.Name, fmt.Errorf("failed to create table %s: %w", schema.Name, err))
+	}
+	std.forgetTable(schema.Name)
+	return nil
+}
+
+// DropTable drops a table if it exists
+func (std *MySQLEndpoint) DropTable(ctx context.Context, tableName string) error {
+	if _, err := std.conn.ExecContext(ctx, "DROP TABLE IF EXISTS "+std.qualified(tableName)); err != nil {
+		return classifyMySQLError(tableName, fmt.Errorf("failed to drop table %s: %w", tableName, err))
+	}
+	std.forgetTable(tableName)
+	return nil
+}
+
+// TruncateTable removes all rows of a table
+func (std *MySQLEndpoint) TruncateTable(ctx context.Context, tableName string) error {
+	if _, err := std.conn.ExecContext(ctx, "TRUNCATE TABLE "+std.qualified(tableName)); err != nil {
+		return classifyMySQLError(tableName, fmt.Errorf("failed to truncate table %s: %w", tableName, err))
+	}
+	return nil
+}
+
+// UpdateSchema adds the columns of the schema that the table lacks
+func (std *MySQLEndpoint) UpdateSchema(ctx context.Context, tableName string, schema TableSchema) error {
+	std.forgetTable(tableName)
+	table, err := std.table(ctx, tableName)
+	if err != nil {
+		return classifyMySQLError(tableName, err)
+	}
+	var added []ColumnDefinition
+	for _, column := range schema.Columns {
+		if _, ok := table.columns[column.Name]; !ok {
+			added = append(added, column)
+		}
+	}
+	if len(added) == 0 {
+		return nil
+	}
+
+	defer std.forgetTable(tableName)
+	if _, err := std.conn.ExecContext(ctx, mysqlAddColumnsSQL(std.qualified(tableName), added)); err != nil {
+		return classifyMySQLError(tableName, fmt.Errorf("failed to add columns to %s: %w", tableName, err))
+	}
+	logger.Info().Str("table", tableName).Int("columns", len(added)).Msg("Added columns to MySQL table")
+	return nil
+}
+
+// schemaArg returns the configured database, or nil for the database of the connection
+func (std *MySQLEndpoint) schemaArg() interface{} {
+	if std.db == "" {
+		return nil
+	}
+	return std.db
+}
+
+// forgetTable drops the cached layout and statements of a table
+func (std *MySQLEndpoint) forgetTable(name string) {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	delete(std.tables, name)
+	std.stmts.forget(name)
+}
+
+// Close closes the prepared statements and the connection pool
+func (std *MySQLEndpoint) Close() error {
+	std.mu.Lock()
+	std.stmts.close()
+	std.mu.Unlock()
+
+	if std.conn != nil {
+		return std.conn.Close()
+	}
+	return nil
+}
+
+// statementCache holds the prepared statements of the endpoint by query,
+// evicting the least recently used once full. Statements are tracked by
+// table so that they are dropped with the layout of their table.
+type statementCache struct {
+	size    int
+	lru     *list.List // of *cachedStatement, most recently used first
+	queries map[string]*list.Element
+}
+
+type cachedStatement struct {
+	table string
+	query string
+	stmt  *sql.Stmt
+}
+
+func newStatementCache(size int) *statementCache {
+	return &statementCache{size: size, lru: list.New(), queries: make(map[string]*list.Element)}
+}
+
+// get returns the statement of a query, nil when it is not cached
+func (c *statementCache) get(query string) *sql.Stmt {
+	element, ok := c.queries[query]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(element)
+	return element.Value.(*cachedStatement).stmt
+}
+
+// put caches the statement of a query on a table, closing the least recently
+// used statement when the cache is full. Transactions running a closed
+// statement keep it until they end.
+func (c *statementCache) put(table, query string, stmt *sql.Stmt) {
+	if element, ok := c.queries[query]; ok {
+		c.remove(element)
+	}
+	for c.lru.Len() >= c.size {
+		c.remove(c.lru.Back())
+	}
+	c.queries[query] = c.lru.PushFront(&cachedStatement{table: table, query: query, stmt: stmt})
+}
+
+// forget closes the statements of a table
+func (c *statementCache) forget(table string) {
+	for element := c.lru.Front(); element != nil; {
+		next := element.Next()
+		if element.Value.(*cachedStatement).table == table {
+			c.remove(element)
+		}
+		element = next
+	}
+}
+
+// close closes every statement
+func (c *statementCache) close() {
+	for c.lru.Len() > 0 {
+		c.remove(c.lru.Front())
+	}
+}
+
+// len returns the number of cached statements
+func (c *statementCache) len() int {
+	return c.lru.Len()
+}
+
+func (c *statementCache) remove(element *list.Element) {
+	cached := c.lru.Remove(element).(*cachedStatement)
+	delete(c.queries, cached.query)
+	if cached.stmt != nil {
+		cached.stmt.Close()
+	}
+}
+
+// mysqlUpsertSQL builds a multi-row insert that updates the non-key columns on a duplicate key
+func mysqlUpsertSQL(table string, columns, keys []string, rows int) string {
+	placeholders := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + ")"
+	values := strings.TrimSuffix(strings.Repeat(placeholders+", ", rows), ", ")
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", table, mysqlColumnList(columns), values)
+	if len(keys) == 0 {
+		return query
+	}
+
+	isKey := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		isKey[key] = true
+	}
+	var assignments []string
+	for _, column := range columns {
+		if !isKey[column] {
+			quoted := mysqlQuote(column)
+			assignments = append(assignments, quoted+" = VALUES("+quoted+")")
+		}
+	}
+	if len(assignments) == 0 {
+		// Nothing to update, keep the existing row
+		quoted := mysqlQuote(columns[0])
+		assignments = append(assignments, quoted+" = "+quoted)
+	}
+	return query + " ON DUPLICATE KEY UPDATE " + strings.Join(assignments, ", ")
+}
+
+// mysqlCreateTableSQL builds the creation of a table with its columns and primary key
+func mysqlCreateTableSQL(table string, schema TableSchema) string {
+	definitions := make([]string, 0, len(schema.Columns)+1)
+	for _, column := range schema.Columns {
+		definitions = append(definitions, mysqlColumnDefinition(column))
+	}
+	if len(schema.PrimaryKey) > 0 {
+		definitions = append(definitions, fmt.Sprintf("PRIMARY KEY (%s)", mysqlColumnList(schema.PrimaryKey)))
+	}
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", table, strings.Join(definitions, ", "))
+}
+
+// mysqlAddColumnsSQL builds the addition of columns to a table
+func mysqlAddColumnsSQL(table string, columns []ColumnDefinition) string {
+	additions := make([]string, len(columns))
+	for i, column := range columns {
+		additions[i] = "ADD COLUMN " + mysqlColumnDefinition(column)
+	}
+	return fmt.Sprintf("ALTER TABLE %s %s", table, strings.Join(additions, ", "))
+}
+
+func mysqlColumnDefinition(column ColumnDefinition) string {
+	definition := mysqlQuote(column.Name) + " " + column.Type
+	if !column.Nullable {
+		definition += " NOT NULL"
+	}
+	return definition
+}
+
+// mysqlUpdateSQL builds an update of the given columns of the row matching the keys
+func mysqlUpdateSQL(table string, columns, keys []string) string {
+	assignments := make([]string, len(columns))
+	for i, column := range columns {
+		assignments[i] = mysqlQuote(column) + " = ?"
+	}
+	return fmt.Sprintf("UPDATE %s SET %s WHERE %s", table, strings.Join(assignments, ", "), mysqlKeyCondition(keys))
+}
+
+// mysqlDeleteSQL builds a delete of the row matching the keys
+func mysqlDeleteSQL(table string, keys []string) string {
+	return fmt.Sprintf("DELETE FROM %s WHERE %s", table, mysqlKeyCondition(keys))
+}
+
+func mysqlKeyCondition(keys []string) string {
+	conditions := make([]string, len(keys))
+	for i, key := range keys {
+		conditions[i] = mysqlQuote(key) + " = ?"
+	}
+	return strings.Join(conditions, " AND ")
+}
+
+func mysqlColumnList(columns []string) string {
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = mysqlQuote(column)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// mysqlQuote quotes an identifier
+func mysqlQuote(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// mysqlValue converts a decoded JSON value to a statement argument for a
+// column of the given data type. Numbers are sent as strings so the server
+// converts them without losing precision.
+func mysqlValue(value interface{}, dataType string) interface{} {
+	if value == nil {
+		return nil
+	}
+	if dataType == "json" {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil
+		}
+		return string(encoded)
+	}
+
+	switch v := value.(type) {
+	case string:
+		switch dataType {
+		case "binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob":
+			// Binary values travel as base64 in JSON
+			if decoded, err := base64.StdEncoding.DecodeString(v); err == nil {
+				return decoded
+			}
+			return []byte(v)
+		case "datetime", "timestamp", "date":
+			if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+				return t.UTC()
+			}
+		}
+		return v
+	case bool:
+		if v {
+			return int64(1)
+		}
+		return int64(0)
+	case json.Number:
+		return v.String()
+	case map[string]interface{}:
+		if scalar, ok := extendedJSONScalar(v); ok {
+			return mysqlValue(scalar, dataType)
+		}
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	return string(encoded)
 }
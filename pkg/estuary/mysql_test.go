@@ -0,0 +1,146 @@
+package estuary
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cohenjo/replicator/pkg/config"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMySQLEndpoint_SQL(t *testing.T) {
+	table := "`shop`.`orders`"
+
+	assert.Equal(t,
+		"INSERT INTO `shop`.`orders` (`id`, `qty`) VALUES (?, ?), (?, ?) ON DUPLICATE KEY UPDATE `qty` = VALUES(`qty`)",
+		mysqlUpsertSQL(table, []string{"id", "qty"}, []string{"id"}, 2))
+	// Rows made only of keys keep the existing row
+	assert.Equal(t,
+		"INSERT INTO `shop`.`orders` (`id`) VALUES (?) ON DUPLICATE KEY UPDATE `id` = `id`",
+		mysqlUpsertSQL(table, []string{"id"}, []string{"id"}, 1))
+	assert.Equal(t, "INSERT INTO `shop`.`orders` (`qty`) VALUES (?)", mysqlUpsertSQL(table, []string{"qty"}, nil, 1))
+
+	assert.Equal(t,
+		"UPDATE `shop`.`orders` SET `qty` = ?, `status` = ? WHERE `tenant` = ? AND `id` = ?",
+		mysqlUpdateSQL(table, []string{"qty", "status"}, []string{"tenant", "id"}))
+	assert.Equal(t, "DELETE FROM `shop`.`orders` WHERE `id` = ?", mysqlDeleteSQL(table, []string{"id"}))
+
+	assert.Equal(t,
+		"CREATE TABLE IF NOT EXISTS `shop`.`orders` (`id` BIGINT NOT NULL, `note` TEXT, PRIMARY KEY (`id`))",
+		mysqlCreateTableSQL(table, TableSchema{
+			Columns:    []ColumnDefinition{{Name: "id", Type: "BIGINT"}, {Name: "note", Type: "TEXT", Nullable: true}},
+			PrimaryKey: []string{"id"},
+		}))
+	assert.Equal(t,
+		"ALTER TABLE `shop`.`orders` ADD COLUMN `total` DECIMAL(10,2), ADD COLUMN `paid` TINYINT(1) NOT NULL",
+		mysqlAddColumnsSQL(table, []ColumnDefinition{{Name: "total", Type: "DECIMAL(10,2)", Nullable: true}, {Name: "paid", Type: "TINYINT(1)"}}))
+
+	endpoint := newMySQLEndpoint(nil, &config.WaterFlowsConfig{Schema: "shop"})
+	assert.Equal(t, table, endpoint.qualified("orders"))
+	assert.Equal(t, "`odd``name`", mysqlQuote("odd`name"))
+	assert.Equal(t, mysqlDefaultBatchRows, endpoint.batchRows)
+}
+
+func TestMySQLStatementCache(t *testing.T) {
+	cache := newStatementCache(3)
+	cache.put("orders", "q1", nil)
+	cache.put("orders", "q2", nil)
+	cache.put("customers", "q3", nil)
+
+	// q1 is used again, q2 is the least recently used query
+	cache.get("q1")
+	cache.put("customers", "q4", nil)
+	assert.Equal(t, 3, cache.len())
+	assert.NotContains(t, cache.queries, "q2")
+
+	// The statements of a table are dropped with its layout
+	cache.forget("customers")
+	assert.Equal(t, 1, cache.len())
+	assert.Contains(t, cache.queries, "q1")
+
+	cache.close()
+	assert.Equal(t, 0, cache.len())
+}
+
+func TestMySQLEndpoint_Values(t *testing.T) {
+	decode := func(s string) interface{} {
+		document, err := decodeDocument([]byte(`{"v":` + s + `}`))
+		require.NoError(t, err)
+		return document["v"]
+	}
+
+	assert.Equal(t, "12345678901234567890", mysqlValue(decode(`12345678901234567890`), "decimal"))
+	assert.Equal(t, int64(1), mysqlValue(decode(`true`), "tinyint"))
+	assert.Equal(t, "abc", mysqlValue(decode(`"abc"`), "varchar"))
+	assert.Nil(t, mysqlValue(nil, "varchar"))
+	assert.Equal(t, `{"a":[1,2]}`, mysqlValue(decode(`{"a":[1,2]}`), "json"))
+	assert.Equal(t, `true`, mysqlValue(decode(`true`), "json"))
+	assert.Equal(t, []byte("hello"), mysqlValue(decode(`"aGVsbG8="`), "varbinary"))
+	// Values that are not base64 are written as is, never panicking
+	assert.Equal(t, []byte("not base64!"), mysqlValue(decode(`"not base64!"`), "blob"))
+	assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), mysqlValue(decode(`"2024-01-02T05:04:05+02:00"`), "datetime"))
+	assert.Equal(t, "64b7f0c2a1b2c3d4e5f60718", mysqlValue(decode(`{"$oid":"64b7f0c2a1b2c3d4e5f60718"}`), "char"))
+}
+
+func TestMySQLDSN(t *testing.T) {
+	dsn, err := mysqlDSN(&config.WaterFlowsConfig{Host: "db", Port: 3307, Schema: "shop", MySQLUser: "app", MySQLPassword: "p@ss"})
+	require.NoError(t, err)
+	cfg, err := mysql.ParseDSN(dsn)
+	require.NoError(t, err)
+	assert.Equal(t, "app", cfg.User)
+	assert.Equal(t, "p@ss", cfg.Passwd)
+	assert.Equal(t, "db:3307", cfg.Addr)
+	assert.Equal(t, "shop", cfg.DBName)
+	assert.True(t, cfg.ClientFoundRows)
+
+	// The legacy global user is used when the target has no credentials
+	previous := config.Global
+	config.Global = &config.Config{MyDBUser: "legacy", MyDBPasswd: "secret"}
+	defer func() { config.Global = previous }()
+	dsn, err = mysqlDSN(&config.WaterFlowsConfig{Host: "db"})
+	require.NoError(t, err)
+	cfg, err = mysql.ParseDSN(dsn)
+	require.NoError(t, err)
+	assert.Equal(t, "legacy", cfg.User)
+	assert.Equal(t, "db:3306", cfg.Addr)
+
+	// A configured DSN is kept, with found rows enabled
+	dsn, err = mysqlDSN(&config.WaterFlowsConfig{MySQLURI: "mysql://u:p@tcp(h:3306)/d"})
+	require.NoError(t, err)
+	cfg, err = mysql.ParseDSN(dsn)
+	require.NoError(t, err)
+	assert.Equal(t, "u", cfg.User)
+	assert.Equal(t, "d", cfg.DBName)
+	assert.True(t, cfg.ClientFoundRows)
+
+	_, err = mysqlDSN(&config.WaterFlowsConfig{MySQLURI: "not a dsn"})
+	assert.Error(t, err)
+}
+
+func TestClassifyMySQLError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		code      string
+		retryable bool
+	}{
+		{"deadlock", &mysql.MySQLError{Number: 1213}, ErrCodeTransactionFailed, true},
+		{"gone away", &mysql.MySQLError{Number: 2006}, ErrCodeConnectionFailed, true},
+		{"read only", &mysql.MySQLError{Number: 1290}, ErrCodeWriteFailed, true},
+		{"no table", &mysql.MySQLError{Number: 1146}, ErrCodeTableNotFound, false},
+		{"unknown column", &mysql.MySQLError{Number: 1054}, ErrCodeInvalidSchema, false},
+		{"duplicate key", &mysql.MySQLError{Number: 1062}, ErrCodeInvalidRecord, false},
+		{"bad connection", mysql.ErrInvalidConn, ErrCodeConnectionFailed, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := classifyMySQLError("orders", tt.err)
+			var destErr *DestinationError
+			require.ErrorAs(t, err, &destErr)
+			assert.Equal(t, tt.code, destErr.Code)
+			assert.Equal(t, tt.retryable, IsRetryable(err))
+		})
+	}
+}
@@ -0,0 +1,742 @@
+package estuary
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cohenjo/replicator/pkg/config"
+	"github.com/cohenjo/replicator/pkg/events"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// pgMaxParameters is the bind parameter limit of a PostgreSQL statement
+	pgMaxParameters = 65535
+
+	// pgDefaultCopyThreshold is the number of upserts from which a batch is loaded with COPY
+	pgDefaultCopyThreshold = 100
+
+	// PostgreSQL batch modes
+	pgBatchModeCopy     = "copy"
+	pgBatchModeMultiRow = "multirow"
+
+	pgDestination = string(config.TargetTypePostgreSQL)
+)
+
+// pgStageCounter names the staging tables of COPY batches
+var pgStageCounter atomic.Uint64
+
+// PostgreSQLEndpoint writes records to PostgreSQL tables. Inserts are upserts
+// on the primary key, updates and deletes match rows by the document key.
+// A batch is applied in a single transaction.
+type PostgreSQLEndpoint struct {
+	pool          *pgxpool.Pool
+	schema        string
+	table         string // fixed target table; the record collection when empty
+	keyColumns    []string
+	autoCreate    bool
+	batchMode     string
+	copyThreshold int
+
+	mu     sync.Mutex
+	tables map[string]*tableLayout
+	warned map[string]bool
+}
+
+// NewPostgreSQLEndpoint creates a PostgreSQL endpoint with a connection pool
+func NewPostgreSQLEndpoint(streamConfig *config.WaterFlowsConfig) (*PostgreSQLEndpoint, error) {
+	batchMode := strings.ToLower(streamConfig.PostgreSQLBatchMode)
+	switch batchMode {
+	case "":
+		batchMode = pgBatchModeCopy
+	case pgBatchModeCopy, pgBatchModeMultiRow:
+	default:
+		return nil, fmt.Errorf("unsupported PostgreSQL batch mode: %s", streamConfig.PostgreSQLBatchMode)
+	}
+
+	pool, err := pgxpool.New(context.Background(), pgConnString(streamConfig))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create PostgreSQL connection pool: %w", err)
+	}
+
+	endpoint := newPostgreSQLEndpoint(pool, streamConfig)
+	endpoint.batchMode = batchMode
+
+	logger.Info().
+		Str("schema", endpoint.schema).
+		Str("table", endpoint.table).
+		Str("batch_mode", endpoint.batchMode).
+		Bool("auto_create", endpoint.autoCreate).
+		Msg("Created PostgreSQL endpoint")
+	return endpoint, nil
+}
+
+func newPostgreSQLEndpoint(pool *pgxpool.Pool, streamConfig *config.WaterFlowsConfig) *PostgreSQLEndpoint {
+	endpoint := &PostgreSQLEndpoint{
+		pool:          pool,
+		schema:        streamConfig.PostgreSQLTargetSchema,
+		table:         streamConfig.Collection,
+		keyColumns:    streamConfig.PostgreSQLKeyColumns,
+		autoCreate:    streamConfig.PostgreSQLAutoCreate,
+		batchMode:     pgBatchModeCopy,
+		copyThreshold: pgDefaultCopyThreshold,
+		tables:        make(map[string]*tableLayout),
+		warned:        make(map[string]bool),
+	}
+	if endpoint.schema == "" {
+		endpoint.schema = "public"
+	}
+	return endpoint
+}
+
+// pgConnString returns the configured URI or a keyword/value connection string
+func pgConnString(streamConfig *config.WaterFlowsConfig) string {
+	if streamConfig.PostgreSQLURI != "" {
+		return streamConfig.PostgreSQLURI
+	}
+
+	host, port := streamConfig.PostgreSQLHost, streamConfig.PostgreSQLPort
+	if host == "" {
+		host = streamConfig.Host
+	}
+	if port == 0 {
+		port = streamConfig.Port
+	}
+	if port == 0 {
+		port = 5432
+	}
+
+	params := []string{"host=" + pgQuoteParam(host), "port=" + strconv.Itoa(port)}
+	for _, param := range [][2]string{
+		{"user", streamConfig.PostgreSQLUser},
+		{"password", streamConfig.PostgreSQLPassword},
+		{"dbname", streamConfig.PostgreSQLDatabase},
+		{"sslmode", streamConfig.PostgreSQLSSLMode},
+		{"sslcert", streamConfig.PostgreSQLSSLCert},
+		{"sslkey", streamConfig.PostgreSQLSSLKey},
+		{"sslrootcert", streamConfig.PostgreSQLSSLRootCert},
+	} {
+		if param[1] != "" {
+			params = append(params, param[0]+"="+pgQuoteParam(param[1]))
+		}
+	}
+	return strings.Join(params, " ")
+}
+
+// pgQuoteParam quotes a connection string value
+func pgQuoteParam(value string) string {
+	if value != "" && !strings.ContainsAny(value, ` '\`) {
+		return value
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+// Write writes a single record
+func (e *PostgreSQLEndpoint) Write(ctx context.Context, record *events.RecordEvent) error {
+	return e.WriteBatch(ctx, []*events.RecordEvent{record})
+}
+
+// WriteBatch applies records in order within one transaction. Consecutive
+// inserts are upserted together, with COPY into a staging table for large
+// runs; updates and deletes are sent as a pipelined batch.
+func (e *PostgreSQLEndpoint) WriteBatch(ctx context.Context, records []*events.RecordEvent) error {
+	changes := make([]*rowChange, 0, len(records))
+	for _, record := range records {
+		change, err := newRowChange(record, e.table)
+		if err != nil {
+			return NewRecordError(pgDestination, record.Collection, "invalid record", err)
+		}
+		changes = append(changes, change)
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	tx, err := e.pool.Begin(ctx)
+	if err != nil {
+		return classifyPGError("", fmt.Errorf("failed to begin transaction: %w", err))
+	}
+	defer tx.Rollback(ctx)
+
+	if err := e.applyChanges(ctx, tx, changes); err != nil {
+		// Tables created or altered in the transaction are gone again
+		e.forgetTables(changes)
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		e.forgetTables(changes)
+		return classifyPGError("", fmt.Errorf("failed to commit transaction: %w", err))
+	}
+
+	recordsSent.Add(float64(len(records)))
+	return nil
+}
+
+// applyChanges applies runs of changes with the same action and table
+func (e *PostgreSQLEndpoint) applyChanges(ctx context.Context, tx pgx.Tx, changes []*rowChange) error {
+	for start := 0; start < len(changes); {
+		end := start + 1
+		for end < len(changes) && changes[end].action == changes[start].action && changes[end].table == changes[start].table {
+			end++
+		}
+		run := changes[start:end]
+		start = end
+
+		table, err := e.ensureTable(ctx, tx, run)
+		if err != nil {
+			return classifyPGError(run[0].table, err)
+		}
+		keys := keyColumns(table, e.keyColumns, run[0])
+
+		switch run[0].action {
+		case events.InsertAction:
+			err = e.upsert(ctx, tx, run[0].table, table, keys, run)
+		case events.UpdateAction:
+			err = e.update(ctx, tx, run[0].table, table, keys, run)
+		case events.DeleteAction:
+			err = e.delete(ctx, tx, run[0].table, table, keys, run)
+		}
+		if err != nil {
+			return classifyPGError(run[0].table, err)
+		}
+	}
+	return nil
+}
+
+// upsert inserts rows, updating the existing row on a key conflict
+func (e *PostgreSQLEndpoint) upsert(ctx context.Context, tx pgx.Tx, name string, table *tableLayout, keys []string, run []*rowChange) error {
+	for _, group := range groupByColumns(dedupeByKey(run, keys), table) {
+		columns := group.columns
+		rows := make([][]interface{}, len(group.changes))
+		for i, change := range group.changes {
+			rows[i] = make([]interface{}, len(columns))
+			for j, column := range columns {
+				rows[i][j] = pgValue(change.row[column], table.columns[column])
+			}
+		}
+
+		if e.batchMode == pgBatchModeCopy && len(rows) >= e.copyThreshold {
+			if err := e.copyUpsert(ctx, tx, name, columns, keys, rows); err != nil {
+				return err
+			}
+			continue
+		}
+
+		chunk := pgMaxParameters / len(columns)
+		for start := 0; start < len(rows); start += chunk {
+			end := start + chunk
+			if end > len(rows) {
+				end = len(rows)
+			}
+			args := make([]interface{}, 0, (end-start)*len(columns))
+			for _, row := range rows[start:end] {
+				args = append(args, row...)
+			}
+			if _, err := tx.Exec(ctx, pgUpsertSQL(e.identifier(name), columns, keys, end-start), args...); err != nil {
+				return fmt.Errorf("failed to upsert into %s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// copyUpsert loads rows into a staging table with COPY and merges them into the target
+func (e *PostgreSQLEndpoint) copyUpsert(ctx context.Context, tx pgx.Tx, name string, columns, keys []string, rows [][]interface{}) error {
+	stage := pgx.Identifier{fmt.Sprintf("replicator_stage_%d", pgStageCounter.Add(1))}
+	target := e.identifier(name)
+
+	createStage := fmt.Sprintf("CREATE TEMP TABLE %s ON COMMIT DROP AS SELECT %s FROM %s WITH NO DATA",
+		stage.Sanitize(), pgColumnList(columns), target.Sanitize())
+	if _, err := tx.Exec(ctx, createStage); err != nil {
+		return fmt.Errorf("failed to create staging table for %s: %w", name, err)
+	}
+	if _, err := tx.CopyFrom(ctx, stage, columns, pgx.CopyFromRows(rows)); err != nil {
+		return fmt.Errorf("failed to copy rows for %s: %w", name, err)
+	}
+	if _, err := tx.Exec(ctx, pgUpsertSelectSQL(target, stage, columns, keys)); err != nil {
+		return fmt.Errorf("failed to upsert into %s: %w", name, err)
+	}
+	if _, err := tx.Exec(ctx, "DROP TABLE "+stage.Sanitize()); err != nil {
+		return fmt.Errorf("failed to drop staging table for %s: %w", name, err)
+	}
+	return nil
+}
+
+// update updates rows by key. Rows that do not exist yet are upserted.
+func (e *PostgreSQLEndpoint) update(ctx context.Context, tx pgx.Tx, name string, table *tableLayout, keys []string, run []*rowChange) error {
+	if len(keys) == 0 {
+		return newDestinationError(ErrCodeInvalidSchema, pgDestination, name, "cannot update: no primary key or key columns", nil)
+	}
+
+	batch := &pgx.Batch{}
+	queued := make([]*rowChange, 0, len(run))
+	for _, change := range run {
+		columns := table.knownColumns(change.row)
+		if len(columns) == 0 {
+			continue
+		}
+		args := make([]interface{}, 0, len(columns)+len(keys))
+		for _, column := range columns {
+			args = append(args, pgValue(change.row[column], table.columns[column]))
+		}
+		for _, key := range keys {
+			args = append(args, pgValue(change.keyValue(key), table.columns[key]))
+		}
+		batch.Queue(pgUpdateSQL(e.identifier(name), columns, keys), args...)
+		queued = append(queued, change)
+	}
+	if len(queued) == 0 {
+		return nil
+	}
+
+	var missing []*rowChange
+	results := tx.SendBatch(ctx, batch)
+	for _, change := range queued {
+		tag, err := results.Exec()
+		if err != nil {
+			results.Close()
+			return fmt.Errorf("failed to update %s: %w", name, err)
+		}
+		if tag.RowsAffected() == 0 {
+			missing = append(missing, change)
+		}
+	}
+	if err := results.Close(); err != nil {
+		return fmt.Errorf("failed to update %s: %w", name, err)
+	}
+
+	if len(missing) > 0 {
+		logger.Debug().Str("table", name).Int("rows", len(missing)).Msg("Updated rows not found, upserting")
+		return e.upsert(ctx, tx, name, table, keys, missing)
+	}
+	return nil
+}
+
+// delete deletes rows by key
+func (e *PostgreSQLEndpoint) delete(ctx context.Context, tx pgx.Tx, name string, table *tableLayout, keys []string, run []*rowChange) error {
+	if len(keys) == 0 {
+		return newDestinationError(ErrCodeInvalidSchema, pgDestination, name, "cannot delete: no primary key or key columns", nil)
+	}
+
+	sql := pgDeleteSQL(e.identifier(name), keys)
+	batch := &pgx.Batch{}
+	for _, change := range run {
+		args := make([]interface{}, len(keys))
+		for i, key := range keys {
+			args[i] = pgValue(change.keyValue(key), table.columns[key])
+		}
+		batch.Queue(sql, args...)
+	}
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return fmt.Errorf("failed to delete from %s: %w", name, err)
+	}
+	return nil
+}
+
+// ensureTable loads the layout of the table of a run, creating the table or
+// adding missing columns when auto-create is enabled
+func (e *PostgreSQLEndpoint) ensureTable(ctx context.Context, tx pgx.Tx, run []*rowChange) (*tableLayout, error) {
+	name := run[0].table
+
+	e.mu.Lock()
+	table := e.tables[name]
+	e.mu.Unlock()
+
+	var err error
+	if table == nil {
+		if table, err = e.loadTable(ctx, tx, name); err != nil {
+			return nil, err
+		}
+	}
+
+	if table == nil {
+		if !e.autoCreate {
+			return nil, fmt.Errorf("table %s.%s does not exist", e.schema, name)
+		}
+		schema := e.inferSchema(name, run)
+		if _, err := tx.Exec(ctx, pgCreateTableSQL(e.schema, schema)); err != nil {
+			return nil, fmt.Errorf("failed to create table %s: %w", name, err)
+		}
+		logger.Info().Str("schema", e.schema).Str("table", name).Int("columns", len(schema.Columns)).Msg("Created PostgreSQL table")
+		if table, err = e.loadTable(ctx, tx, name); err != nil {
+			return nil, err
+		}
+		if table == nil {
+			return nil, fmt.Errorf("table %s.%s not found after creating it", e.schema, name)
+		}
+	}
+
+	if missing := table.missingColumns(run, inferPGType); len(missing) > 0 {
+		if e.autoCreate {
+			// The cached layout may be in use by other batches
+			altered := &tableLayout{columns: make(map[string]string, len(table.columns)+len(missing)), primaryKey: table.primaryKey}
+			for column, dataType := range table.columns {
+				altered.columns[column] = dataType
+			}
+			table = altered
+			for _, column := range missing {
+				column.Type = pgTypeOrText(column.Type)
+				sql := fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s",
+					e.identifier(name).Sanitize(), pgx.Identifier{column.Name}.Sanitize(), column.Type)
+				if _, err := tx.Exec(ctx, sql); err != nil {
+					return nil, fmt.Errorf("failed to add column %s to %s: %w", column.Name, name, err)
+				}
+				table.columns[column.Name] = column.Type
+			}
+			logger.Info().Str("table", name).Int("columns", len(missing)).Msg("Added columns to PostgreSQL table")
+		} else {
+			e.warnMissingColumns(name, missing)
+		}
+	}
+
+	e.mu.Lock()
+	e.tables[name] = table
+	e.mu.Unlock()
+	return table, nil
+}
+
+// loadTable reads the columns and primary key of a table, nil if it does not exist
+func (e *PostgreSQLEndpoint) loadTable(ctx context.Context, tx pgx.Tx, name string) (*tableLayout, error) {
+	rows, err := tx.Query(ctx,
+		`SELECT column_name, data_type FROM information_schema.columns
+		 WHERE table_schema = $1 AND table_name = $2 ORDER BY ordinal_position`, e.schema, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read columns of %s: %w", name, err)
+	}
+	table := &tableLayout{columns: make(map[string]string)}
+	for rows.Next() {
+		var column, dataType string
+		if err := rows.Scan(&column, &dataType); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to read columns of %s: %w", name, err)
+		}
+		table.columns[column] = dataType
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read columns of %s: %w", name, err)
+	}
+	if len(table.columns) == 0 {
+		return nil, nil
+	}
+
+	rows, err = tx.Query(ctx,
+		`SELECT a.attname FROM pg_index i
+		 JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+		 WHERE i.indrelid = $1::regclass AND i.indisprimary
+		 ORDER BY array_position(i.indkey::int2[], a.attnum)`, e.identifier(name).Sanitize())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read primary key of %s: %w", name, err)
+	}
+	if table.primaryKey, err = pgx.CollectRows(rows, pgx.RowTo[string]); err != nil {
+		return nil, fmt.Errorf("failed to read primary key of %s: %w", name, err)
+	}
+	return table, nil
+}
+
+// inferSchema derives a table schema from the rows of a run. Key columns
+// become the primary key.
+func (e *PostgreSQLEndpoint) inferSchema(name string, run []*rowChange) TableSchema {
+	schema := TableSchema{Name: name, PrimaryKey: e.keyColumns}
+	if len(schema.PrimaryKey) == 0 && run[0].keyed {
+		schema.PrimaryKey = sortedKeys(run[0].key)
+	}
+
+	types := make(map[string]string)
+	for _, column := range schema.PrimaryKey {
+		types[column] = ""
+	}
+	for _, change := range run {
+		for _, document := range []map[string]interface{}{change.key, change.row} {
+			for column, value := range document {
+				if types[column] == "" && value != nil {
+					types[column] = inferPGType(value)
+				}
+				if _, ok := types[column]; !ok {
+					types[column] = ""
+				}
+			}
+		}
+	}
+
+	isKey := make(map[string]bool)
+	for _, column := range schema.PrimaryKey {
+		isKey[column] = true
+		schema.Columns = append(schema.Columns, ColumnDefinition{Name: column, Type: pgTypeOrText(types[column])})
+	}
+	for _, column := range sortedKeys(types) {
+		if !isKey[column] {
+			schema.Columns = append(schema.Columns, ColumnDefinition{Name: column, Type: pgTypeOrText(types[column]), Nullable: true})
+		}
+	}
+	return schema
+}
+
+// warnMissingColumns logs once per column that values without a column are dropped
+func (e *PostgreSQLEndpoint) warnMissingColumns(name string, missing []ColumnDefinition) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, column := range missing {
+		if key := name + "." + column.Name; !e.warned[key] {
+			e.warned[key] = true
+			logger.Warn().Str("table", name).Str("column", column.Name).Msg("Column does not exist in PostgreSQL table, dropping its values")
+		}
+	}
+}
+
+// forgetTables drops the cached layout of the tables of failed changes
+func (e *PostgreSQLEndpoint) forgetTables(changes []*rowChange) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, change := range changes {
+		delete(e.tables, change.table)
+	}
+}
+
+func (e *PostgreSQLEndpoint) identifier(name string) pgx.Identifier {
+	return pgx.Identifier{e.schema, name}
+}
+
+// classifyPGError classifies a PostgreSQL write error by its SQLSTATE.
+// Serialization failures, deadlocks, connection and resource errors and
+// server shutdowns are transient; data and constraint errors reject the row
+// and fail again on retry.
+func classifyPGError(table string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var destErr *DestinationError
+	if errors.As(err, &destErr) {
+		return err
+	}
+
+	var serverErr *pgconn.PgError
+	if errors.As(err, &serverErr) {
+		code := serverErr.Code
+		switch {
+		case code == "40001" || code == "40P01": // serialization failure, deadlock
+			return NewTransactionError(pgDestination, "transaction rolled back", err)
+		case strings.HasPrefix(code, "08"), strings.HasPrefix(code, "53"), strings.HasPrefix(code, "57P"):
+			// connection exceptions, insufficient resources, operator intervention
+			return NewConnectionError(pgDestination, "connection lost", err)
+		case code == "25006": // read-only transaction, e.g. during a failover
+			return NewWriteError(pgDestination, table, "server is read-only", err)
+		case code == "42P01": // undefined table
+			return newDestinationError(ErrCodeTableNotFound, pgDestination, table, "table does not exist", err)
+		case code == "42501": // insufficient privilege
+			return newDestinationError(ErrCodeInvalidConfig, pgDestination, table, "write not authorized", err)
+		case strings.HasPrefix(code, "42"): // undefined columns and other syntax errors
+			return newDestinationError(ErrCodeInvalidSchema, pgDestination, table, "row does not match the table", err)
+		}
+		return NewRecordError(pgDestination, table, "row rejected", err)
+	}
+	if pgconn.Timeout(err) {
+		return newDestinationError(ErrCodeTimeout, pgDestination, table, "write timed out", err)
+	}
+	if pgconn.SafeToRetry(err) {
+		return NewConnectionError(pgDestination, "connection lost", err)
+	}
+	return classifyError(pgDestination, table, err)
+}
+
+// Ping checks that a connection of the pool answers
+func (e *PostgreSQLEndpoint) Ping(ctx context.Context) error {
+	if err := e.pool.Ping(ctx); err != nil {
+		return classifyPGError("", err)
+	}
+	return nil
+}
+
+// Close closes the connection pool
+func (e *PostgreSQLEndpoint) Close() error {
+	if e.pool != nil {
+		e.pool.Close()
+	}
+	return nil
+}
+
+// pgUpsertSQL builds a multi-row insert that updates the existing row on a key conflict
+func pgUpsertSQL(table pgx.Identifier, columns, keys []string, rows int) string {
+	var sql strings.Builder
+	fmt.Fprintf(&sql, "INSERT INTO %s (%s) VALUES ", table.Sanitize(), pgColumnList(columns))
+	param := 1
+	for row := 0; row < rows; row++ {
+		if row > 0 {
+			sql.WriteString(", ")
+		}
+		sql.WriteString("(")
+		for i := range columns {
+			if i > 0 {
+				sql.WriteString(", ")
+			}
+			fmt.Fprintf(&sql, "$%d", param)
+			param++
+		}
+		sql.WriteString(")")
+	}
+	sql.WriteString(pgConflictClause(columns, keys))
+	return sql.String()
+}
+
+// pgUpsertSelectSQL builds the merge of a staging table into its target
+func pgUpsertSelectSQL(table, stage pgx.Identifier, columns, keys []string) string {
+	return fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s%s",
+		table.Sanitize(), pgColumnList(columns), pgColumnList(columns), stage.Sanitize(), pgConflictClause(columns, keys))
+}
+
+// pgConflictClause builds the ON CONFLICT clause of an upsert
+func pgConflictClause(columns, keys []string) string {
+	if len(keys) == 0 {
+		return ""
+	}
+	isKey := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		isKey[key] = true
+	}
+	var assignments []string
+	for _, column := range columns {
+		if !isKey[column] {
+			quoted := pgx.Identifier{column}.Sanitize()
+			assignments = append(assignments, quoted+" = EXCLUDED."+quoted)
+		}
+	}
+	if len(assignments) == 0 {
+		return fmt.Sprintf(" ON CONFLICT (%s) DO NOTHING", pgColumnList(keys))
+	}
+	return fmt.Sprintf(" ON CONFLICT (%s) DO UPDATE SET %s", pgColumnList(keys), strings.Join(assignments, ", "))
+}
+
+// pgUpdateSQL builds an update of the given columns of the row matching the keys
+func pgUpdateSQL(table pgx.Identifier, columns, keys []string) string {
+	assignments := make([]string, len(columns))
+	for i, column := range columns {
+		assignments[i] = fmt.Sprintf("%s = $%d", pgx.Identifier{column}.Sanitize(), i+1)
+	}
+	return fmt.Sprintf("UPDATE %s SET %s WHERE %s",
+		table.Sanitize(), strings.Join(assignments, ", "), pgKeyCondition(keys, len(columns)+1))
+}
+
+// pgDeleteSQL builds a delete of the row matching the keys
+func pgDeleteSQL(table pgx.Identifier, keys []string) string {
+	return fmt.Sprintf("DELETE FROM %s WHERE %s", table.Sanitize(), pgKeyCondition(keys, 1))
+}
+
+func pgKeyCondition(keys []string, firstParam int) string {
+	conditions := make([]string, len(keys))
+	for i, key := range keys {
+		conditions[i] = fmt.Sprintf("%s = $%d", pgx.Identifier{key}.Sanitize(), firstParam+i)
+	}
+	return strings.Join(conditions, " AND ")
+}
+
+// pgCreateTableSQL builds the statement creating a table from its schema
+func pgCreateTableSQL(schemaName string, schema TableSchema) string {
+	definitions := make([]string, 0, len(schema.Columns)+1)
+	for _, column := range schema.Columns {
+		definition := pgx.Identifier{column.Name}.Sanitize() + " " + column.Type
+		if !column.Nullable {
+			definition += " NOT NULL"
+		}
+		definitions = append(definitions, definition)
+	}
+	if len(schema.PrimaryKey) > 0 {
+		definitions = append(definitions, fmt.Sprintf("PRIMARY KEY (%s)", pgColumnList(schema.PrimaryKey)))
+	}
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)",
+		pgx.Identifier{schemaName, schema.Name}.Sanitize(), strings.Join(definitions, ", "))
+}
+
+func pgColumnList(columns []string) string {
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = pgx.Identifier{column}.Sanitize()
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// inferPGType maps a decoded JSON value to a PostgreSQL column type
+func inferPGType(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return "text"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "bigint"
+		}
+		return "numeric"
+	case map[string]interface{}:
+		// Extended JSON scalars
+		if len(v) == 1 {
+			switch {
+			case v["$oid"] != nil:
+				return "text"
+			case v["$date"] != nil:
+				return "timestamptz"
+			case v["$numberLong"] != nil, v["$numberInt"] != nil:
+				return "bigint"
+			case v["$numberDecimal"] != nil, v["$numberDouble"] != nil:
+				return "numeric"
+			}
+		}
+		return "jsonb"
+	case []interface{}:
+		return "jsonb"
+	}
+	return "text"
+}
+
+func pgTypeOrText(dataType string) string {
+	if dataType == "" {
+		return "text"
+	}
+	return dataType
+}
+
+// pgValue converts a decoded JSON value to a statement argument for a column
+// of the given data type. Scalars are sent as text and parsed by the server.
+func pgValue(value interface{}, dataType string) interface{} {
+	if value == nil {
+		return nil
+	}
+	if dataType == "json" || dataType == "jsonb" {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil
+		}
+		return string(encoded)
+	}
+
+	switch v := value.(type) {
+	case string:
+		if dataType == "bytea" {
+			if decoded, err := base64.StdEncoding.DecodeString(v); err == nil {
+				return decoded
+			}
+			return []byte(v)
+		}
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case json.Number:
+		return v.String()
+	case map[string]interface{}:
+		if scalar, ok := extendedJSONScalar(v); ok {
+			return pgValue(scalar, dataType)
+		}
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	return string(encoded)
+}
@@ -0,0 +1,124 @@
+package estuary
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cohenjo/replicator/pkg/config"
+	"github.com/cohenjo/replicator/pkg/events"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostgreSQLEndpoint_SQL(t *testing.T) {
+	table := pgx.Identifier{"public", "orders"}
+
+	assert.Equal(t,
+		`INSERT INTO "public"."orders" ("id", "qty") VALUES ($1, $2), ($3, $4) ON CONFLICT ("id") DO UPDATE SET "qty" = EXCLUDED."qty"`,
+		pgUpsertSQL(table, []string{"id", "qty"}, []string{"id"}, 2))
+	// Rows made only of keys are left alone on conflict
+	assert.Equal(t,
+		`INSERT INTO "public"."orders" ("id") VALUES ($1) ON CONFLICT ("id") DO NOTHING`,
+		pgUpsertSQL(table, []string{"id"}, []string{"id"}, 1))
+	// Without a key, rows are plain inserts
+	assert.Equal(t, `INSERT INTO "public"."orders" ("qty") VALUES ($1)`, pgUpsertSQL(table, []string{"qty"}, nil, 1))
+
+	assert.Equal(t,
+		`INSERT INTO "public"."orders" ("id", "qty") SELECT "id", "qty" FROM "stage" ON CONFLICT ("id") DO UPDATE SET "qty" = EXCLUDED."qty"`,
+		pgUpsertSelectSQL(table, pgx.Identifier{"stage"}, []string{"id", "qty"}, []string{"id"}))
+	assert.Equal(t,
+		`UPDATE "public"."orders" SET "qty" = $1, "status" = $2 WHERE "tenant" = $3 AND "id" = $4`,
+		pgUpdateSQL(table, []string{"qty", "status"}, []string{"tenant", "id"}))
+	assert.Equal(t, `DELETE FROM "public"."orders" WHERE "id" = $1`, pgDeleteSQL(table, []string{"id"}))
+
+	// Identifiers are quoted
+	assert.Equal(t, `DELETE FROM "public"."odd""name" WHERE "a b" = $1`, pgDeleteSQL(pgx.Identifier{"public", `odd"name`}, []string{"a b"}))
+}
+
+func TestPostgreSQLEndpoint_InferSchema(t *testing.T) {
+	endpoint := newPostgreSQLEndpoint(nil, &config.WaterFlowsConfig{})
+
+	change, err := newRowChange(&events.RecordEvent{
+		Action:      events.InsertAction,
+		Collection:  "orders",
+		DocumentKey: []byte(`{"_id":{"$oid":"64b7f0c2a1b2c3d4e5f60718"}}`),
+		Data:        []byte(`{"_id":{"$oid":"64b7f0c2a1b2c3d4e5f60718"},"qty":3,"price":9.99,"paid":true,"tags":["a"],"meta":{"k":"v"},"at":{"$date":"2024-01-02T03:04:05Z"},"note":null}`),
+	}, endpoint.table)
+	require.NoError(t, err)
+
+	schema := endpoint.inferSchema("orders", []*rowChange{change})
+	assert.Equal(t, []string{"_id"}, schema.PrimaryKey)
+	assert.Equal(t,
+		`CREATE TABLE IF NOT EXISTS "public"."orders" ("_id" text NOT NULL, "at" timestamptz, "meta" jsonb, "note" text, "paid" boolean, "price" numeric, "qty" bigint, "tags" jsonb, PRIMARY KEY ("_id"))`,
+		pgCreateTableSQL(endpoint.schema, schema))
+
+	// Configured key columns become the primary key
+	endpoint.keyColumns = []string{"qty"}
+	schema = endpoint.inferSchema("orders", []*rowChange{change})
+	assert.Equal(t, []string{"qty"}, schema.PrimaryKey)
+	assert.Equal(t, ColumnDefinition{Name: "qty", Type: "bigint"}, schema.Columns[0])
+}
+
+func TestPostgreSQLEndpoint_Values(t *testing.T) {
+	decode := func(s string) interface{} {
+		document, err := decodeDocument([]byte(`{"v":` + s + `}`))
+		require.NoError(t, err)
+		return document["v"]
+	}
+
+	assert.Equal(t, "12345678901234567890", pgValue(decode(`12345678901234567890`), "numeric"))
+	assert.Equal(t, "true", pgValue(decode(`true`), "boolean"))
+	assert.Equal(t, "abc", pgValue(decode(`"abc"`), "text"))
+	assert.Nil(t, pgValue(nil, "text"))
+	assert.Equal(t, `{"a":[1,2]}`, pgValue(decode(`{"a":[1,2]}`), "jsonb"))
+	assert.Equal(t, `"abc"`, pgValue(decode(`"abc"`), "json"))
+	assert.Equal(t, `[1,"x"]`, pgValue(decode(`[1,"x"]`), "text"))
+	assert.Equal(t, []byte("hello"), pgValue(decode(`"aGVsbG8="`), "bytea"))
+
+	// Extended JSON scalars are unwrapped
+	assert.Equal(t, "64b7f0c2a1b2c3d4e5f60718", pgValue(decode(`{"$oid":"64b7f0c2a1b2c3d4e5f60718"}`), "text"))
+	assert.Equal(t, "42", pgValue(decode(`{"$numberLong":"42"}`), "bigint"))
+	assert.Equal(t, "2023-11-14T22:13:20Z", pgValue(decode(`{"$date":1700000000000}`), "timestamp with time zone"))
+	assert.Equal(t, "2023-11-14T22:13:20Z", pgValue(decode(`{"$date":{"$numberLong":"1700000000000"}}`), "timestamp with time zone"))
+}
+
+func TestPGConnString(t *testing.T) {
+	assert.Equal(t, "postgres://u:p@db/app", pgConnString(&config.WaterFlowsConfig{PostgreSQLURI: "postgres://u:p@db/app"}))
+	assert.Equal(t,
+		`host=db port=5432 user=app password='it\'s secret' dbname=orders sslmode=require`,
+		pgConnString(&config.WaterFlowsConfig{
+			PostgreSQLHost:     "db",
+			PostgreSQLUser:     "app",
+			PostgreSQLPassword: "it's secret",
+			PostgreSQLDatabase: "orders",
+			PostgreSQLSSLMode:  "require",
+		}))
+}
+
+func TestClassifyPGError(t *testing.T) {
+	tests := []struct {
+		name      string
+		code      string
+		errCode   string
+		retryable bool
+	}{
+		{"serialization failure", "40001", ErrCodeTransactionFailed, true},
+		{"admin shutdown", "57P01", ErrCodeConnectionFailed, true},
+		{"too many connections", "53300", ErrCodeConnectionFailed, true},
+		{"undefined table", "42P01", ErrCodeTableNotFound, false},
+		{"undefined column", "42703", ErrCodeInvalidSchema, false},
+		{"not null violation", "23502", ErrCodeInvalidRecord, false},
+		{"invalid text", "22P02", ErrCodeInvalidRecord, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := classifyPGError("orders", fmt.Errorf("failed to upsert into orders: %w", &pgconn.PgError{Code: tt.code}))
+			var destErr *DestinationError
+			require.ErrorAs(t, err, &destErr)
+			assert.Equal(t, tt.errCode, destErr.Code)
+			assert.Equal(t, tt.retryable, IsRetryable(err))
+		})
+	}
+}
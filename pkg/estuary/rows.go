@@ -0,0 +1,258 @@
+package estuary
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cohenjo/replicator/pkg/events"
+)
+
+// tableLayout is the cached layout of a relational target table
+type tableLayout struct {
+	columns    map[string]string // column name -> data type
+	primaryKey []string
+}
+
+// rowChange is a record mapped to a row change of a relational target table
+type rowChange struct {
+	action string
+	table  string
+	row    map[string]interface{} // new column values, empty for deletes
+	key    map[string]interface{} // values identifying the existing row
+	keyed  bool                   // key holds the document key rather than the whole row
+}
+
+// rowColumnGroup is a set of row changes writing the same columns
+type rowColumnGroup struct {
+	columns []string
+	changes []*rowChange
+}
+
+// newRowChange maps a record to a row change of a table. The table is the
+// record collection unless a target table is given.
+func newRowChange(record *events.RecordEvent, table string) (*rowChange, error) {
+	change := &rowChange{action: record.Action, table: table}
+	if change.table == "" {
+		change.table = record.Collection
+	}
+	if change.table == "" {
+		return nil, fmt.Errorf("%s record has no collection and no target table is configured", record.Action)
+	}
+
+	var err error
+	switch record.Action {
+	case events.InsertAction, events.UpdateAction:
+		if len(record.Data) == 0 {
+			return nil, fmt.Errorf("%s record has no data", record.Action)
+		}
+		if change.row, err = decodeDocument(record.Data); err != nil {
+			return nil, err
+		}
+	case events.DeleteAction:
+	default:
+		return nil, fmt.Errorf("unsupported action: %s", record.Action)
+	}
+
+	// The key identifies the row as it was before the change
+	for i, source := range [][]byte{record.DocumentKey, record.OldData, record.Data} {
+		if len(source) > 0 {
+			if change.key, err = decodeDocument(source); err != nil {
+				return nil, err
+			}
+			change.keyed = i == 0
+			break
+		}
+	}
+	if change.key == nil {
+		return nil, fmt.Errorf("%s record has no data or document key", record.Action)
+	}
+	return change, nil
+}
+
+// keyValue returns the value of a key column before the change
+func (c *rowChange) keyValue(column string) interface{} {
+	if value, ok := c.key[column]; ok {
+		return value
+	}
+	return c.row[column]
+}
+
+// keyColumns returns the columns identifying the rows of a table: its primary
+// key, the configured key columns, or the fields of the document key
+func keyColumns(table *tableLayout, configured []string, change *rowChange) []string {
+	if len(table.primaryKey) > 0 {
+		return table.primaryKey
+	}
+	if len(configured) > 0 {
+		return configured
+	}
+	if change.keyed {
+		return sortedKeys(change.key)
+	}
+	return nil
+}
+
+// knownColumns returns the sorted columns of a row that exist in the table
+func (t *tableLayout) knownColumns(row map[string]interface{}) []string {
+	columns := make([]string, 0, len(row))
+	for column := range row {
+		if _, ok := t.columns[column]; ok {
+			columns = append(columns, column)
+		}
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// missingColumns returns the row columns the table does not have, typed by infer.
+// Columns with only null values get an empty type.
+func (t *tableLayout) missingColumns(run []*rowChange, infer func(interface{}) string) []ColumnDefinition {
+	types := make(map[string]string)
+	for _, change := range run {
+		for column, value := range change.row {
+			if _, ok := t.columns[column]; ok {
+				continue
+			}
+			if types[column] == "" && value != nil {
+				types[column] = infer(value)
+			} else if _, ok := types[column]; !ok {
+				types[column] = ""
+			}
+		}
+	}
+
+	missing := make([]ColumnDefinition, 0, len(types))
+	for _, column := range sortedKeys(types) {
+		missing = append(missing, ColumnDefinition{Name: column, Type: types[column], Nullable: true})
+	}
+	return missing
+}
+
+// dedupeByKey keeps the last change of every key, so one statement never
+// affects a row twice
+func dedupeByKey(run []*rowChange, keys []string) []*rowChange {
+	if len(keys) == 0 {
+		return run
+	}
+	last := make(map[string]int, len(run))
+	for i, change := range run {
+		last[rowKeyString(change, keys)] = i
+	}
+	result := make([]*rowChange, 0, len(last))
+	for i, change := range run {
+		if last[rowKeyString(change, keys)] == i {
+			result = append(result, change)
+		}
+	}
+	return result
+}
+
+// groupByColumns groups row changes by the known columns they write
+func groupByColumns(changes []*rowChange, table *tableLayout) []*rowColumnGroup {
+	var groups []*rowColumnGroup
+	index := make(map[string]*rowColumnGroup)
+	for _, change := range changes {
+		columns := table.knownColumns(change.row)
+		if len(columns) == 0 {
+			continue
+		}
+		signature := strings.Join(columns, "\x00")
+		group, ok := index[signature]
+		if !ok {
+			group = &rowColumnGroup{columns: columns}
+			index[signature] = group
+			groups = append(groups, group)
+		}
+		group.changes = append(group.changes, change)
+	}
+	return groups
+}
+
+// rowKeyString identifies the row a change writes
+func rowKeyString(change *rowChange, keys []string) string {
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		if value, ok := change.row[key]; ok {
+			values[i] = value
+		} else {
+			values[i] = change.keyValue(key)
+		}
+	}
+	encoded, _ := json.Marshal(values)
+	return string(encoded)
+}
+
+// decodeDocument decodes a JSON object, keeping numbers exact
+func decodeDocument(data []byte) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var document map[string]interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return nil, fmt.Errorf("failed to decode document: %w", err)
+	}
+	return document, nil
+}
+
+// documentIDString converts a key value to a document id
+func documentIDString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case map[string]interface{}:
+		// MongoDB extended JSON, e.g. {"$oid": "..."}
+		if len(v) == 1 {
+			for _, inner := range v {
+				if s, ok := inner.(string); ok {
+					return s
+				}
+			}
+		}
+	}
+	encoded, _ := json.Marshal(value)
+	return string(encoded)
+}
+
+// extendedJSONScalar unwraps MongoDB extended JSON scalars such as {"$oid": "..."}
+func extendedJSONScalar(value map[string]interface{}) (interface{}, bool) {
+	if len(value) != 1 {
+		return nil, false
+	}
+	for _, name := range []string{"$oid", "$numberLong", "$numberInt", "$numberDecimal", "$numberDouble"} {
+		if scalar, ok := value[name]; ok {
+			return scalar, true
+		}
+	}
+	if date, ok := value["$date"]; ok {
+		switch d := date.(type) {
+		case string:
+			return d, true
+		case json.Number:
+			if ms, err := d.Int64(); err == nil {
+				return time.UnixMilli(ms).UTC().Format(time.RFC3339Nano), true
+			}
+		case map[string]interface{}:
+			if ms, ok := d["$numberLong"].(string); ok {
+				if millis, err := strconv.ParseInt(ms, 10, 64); err == nil {
+					return time.UnixMilli(millis).UTC().Format(time.RFC3339Nano), true
+				}
+			}
+		}
+	}
+	return nil, false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,76 @@
+package estuary
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/cohenjo/replicator/pkg/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRowChange(t *testing.T) {
+	withPK := &tableLayout{columns: map[string]string{"id": "bigint", "qty": "bigint"}, primaryKey: []string{"id"}}
+	withoutPK := &tableLayout{columns: map[string]string{"id": "bigint", "qty": "bigint"}}
+
+	change, err := newRowChange(&events.RecordEvent{
+		Action:      events.UpdateAction,
+		Collection:  "orders",
+		DocumentKey: []byte(`{"id":1}`),
+		Data:        []byte(`{"id":1,"qty":5,"extra":"x"}`),
+	}, "")
+	require.NoError(t, err)
+	assert.Equal(t, "orders", change.table)
+	assert.Equal(t, []string{"id"}, keyColumns(withPK, nil, change))
+	assert.Equal(t, []string{"id"}, keyColumns(withoutPK, nil, change))
+	// Columns the table does not have are left out
+	assert.Equal(t, []string{"id", "qty"}, withPK.knownColumns(change.row))
+	assert.Equal(t, []ColumnDefinition{{Name: "extra", Type: "text", Nullable: true}}, withPK.missingColumns([]*rowChange{change}, inferPGType))
+
+	// Deletes are keyed by the old data when there is no document key
+	change, err = newRowChange(&events.RecordEvent{Action: events.DeleteAction, Collection: "orders", OldData: []byte(`{"id":2,"qty":1}`)}, "")
+	require.NoError(t, err)
+	assert.Nil(t, change.row)
+	assert.Equal(t, json.Number("2"), change.keyValue("id"))
+	assert.Equal(t, []string{"id"}, keyColumns(withPK, nil, change))
+	assert.Empty(t, keyColumns(withoutPK, nil, change))
+
+	// Configured key columns apply to tables without a primary key
+	assert.Equal(t, []string{"qty"}, keyColumns(withoutPK, []string{"qty"}, change))
+
+	// A configured table overrides the record collection
+	change, err = newRowChange(&events.RecordEvent{Action: events.InsertAction, Collection: "orders", Data: []byte(`{"id":3}`)}, "archive")
+	require.NoError(t, err)
+	assert.Equal(t, "archive", change.table)
+
+	_, err = newRowChange(&events.RecordEvent{Action: events.InsertAction}, "")
+	assert.Error(t, err)
+	_, err = newRowChange(&events.RecordEvent{Action: "drop", Data: []byte(`{}`)}, "")
+	assert.Error(t, err)
+}
+
+func TestDedupeAndGroupByColumns(t *testing.T) {
+	table := &tableLayout{columns: map[string]string{"id": "bigint", "qty": "bigint", "note": "text"}, primaryKey: []string{"id"}}
+	row := func(data string) *rowChange {
+		document, err := decodeDocument([]byte(data))
+		require.NoError(t, err)
+		return &rowChange{action: events.InsertAction, row: document, key: document}
+	}
+
+	run := []*rowChange{
+		row(`{"id":1,"qty":1}`),
+		row(`{"id":2,"note":"a"}`),
+		row(`{"id":1,"qty":2}`),
+		row(`{"id":3,"qty":3}`),
+	}
+	deduped := dedupeByKey(run, table.primaryKey)
+	require.Len(t, deduped, 3)
+	assert.Equal(t, json.Number("2"), deduped[1].row["qty"])
+
+	groups := groupByColumns(deduped, table)
+	require.Len(t, groups, 2)
+	assert.Equal(t, []string{"id", "note"}, groups[0].columns)
+	assert.Len(t, groups[0].changes, 1)
+	assert.Equal(t, []string{"id", "qty"}, groups[1].columns)
+	assert.Len(t, groups[1].changes, 2)
+}
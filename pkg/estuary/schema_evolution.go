@@ -0,0 +1,213 @@
+package estuary
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Migration operation types
+const (
+	MigrationAddColumn    = "ADD_COLUMN"
+	MigrationDropColumn   = "DROP_COLUMN"
+	MigrationModifyColumn = "MODIFY_COLUMN"
+	MigrationAddIndex     = "ADD_INDEX"
+	MigrationDropIndex    = "DROP_INDEX"
+)
+
+// Column change types of a ColumnModification
+const (
+	ColumnTypeChange    = "TYPE_CHANGE"
+	ColumnNullChange    = "NULL_CHANGE"
+	ColumnDefaultChange = "DEFAULT_CHANGE"
+)
+
+// DefaultSchemaEvolution implements SchemaEvolution. Migrations are applied
+// additively: new columns are added through the destination, while dropped
+// and modified columns are left to the operator, as replication must not lose
+// data that the source still sends.
+type DefaultSchemaEvolution struct{}
+
+// NewSchemaEvolution creates a schema evolution
+func NewSchemaEvolution() *DefaultSchemaEvolution {
+	return &DefaultSchemaEvolution{}
+}
+
+// CompareSchemas compares the current schema of a table with a new one
+func (e *DefaultSchemaEvolution) CompareSchemas(current, new TableSchema) (*SchemaComparison, error) {
+	if new.Name == "" {
+		new.Name = current.Name
+	}
+	if current.Name != "" && !strings.EqualFold(current.Name, new.Name) {
+		return nil, fmt.Errorf("cannot compare schemas of different tables %s and %s", current.Name, new.Name)
+	}
+
+	comparison := &SchemaComparison{TableName: new.Name}
+	currentColumns := make(map[string]ColumnDefinition, len(current.Columns))
+	for _, column := range current.Columns {
+		currentColumns[strings.ToLower(column.Name)] = column
+	}
+	newColumns := make(map[string]bool, len(new.Columns))
+	for _, column := range new.Columns {
+		newColumns[strings.ToLower(column.Name)] = true
+		old, ok := currentColumns[strings.ToLower(column.Name)]
+		if !ok {
+			comparison.AddedColumns = append(comparison.AddedColumns, column)
+			continue
+		}
+		if change := columnChange(old, column); change != "" {
+			comparison.ModifiedColumns = append(comparison.ModifiedColumns, ColumnModification{
+				Name: column.Name, OldColumn: old, NewColumn: column, ChangeType: change,
+			})
+		}
+	}
+	for _, column := range current.Columns {
+		if !newColumns[strings.ToLower(column.Name)] {
+			comparison.RemovedColumns = append(comparison.RemovedColumns, column)
+		}
+	}
+
+	currentIndexes := make(map[string]bool, len(current.Indexes))
+	for _, index := range current.Indexes {
+		currentIndexes[index.Name] = true
+	}
+	newIndexes := make(map[string]bool, len(new.Indexes))
+	for _, index := range new.Indexes {
+		newIndexes[index.Name] = true
+		if !currentIndexes[index.Name] {
+			comparison.AddedIndexes = append(comparison.AddedIndexes, index)
+		}
+	}
+	for _, index := range current.Indexes {
+		if !newIndexes[index.Name] {
+			comparison.RemovedIndexes = append(comparison.RemovedIndexes, index)
+		}
+	}
+
+	comparison.HasChanges = len(comparison.AddedColumns) > 0 || len(comparison.RemovedColumns) > 0 ||
+		len(comparison.ModifiedColumns) > 0 || len(comparison.AddedIndexes) > 0 || len(comparison.RemovedIndexes) > 0
+	return comparison, nil
+}
+
+// columnChange returns the kind of change between two definitions of a column
+func columnChange(old, new ColumnDefinition) string {
+	switch {
+	case !strings.EqualFold(old.Type, new.Type):
+		return ColumnTypeChange
+	case old.Nullable != new.Nullable:
+		return ColumnNullChange
+	case !reflect.DeepEqual(old.DefaultValue, new.DefaultValue):
+		return ColumnDefaultChange
+	}
+	return ""
+}
+
+// GenerateMigration generates the operations turning the current schema into the new one
+func (e *DefaultSchemaEvolution) GenerateMigration(comparison *SchemaComparison) (*SchemaMigration, error) {
+	if comparison == nil {
+		return nil, fmt.Errorf("comparison is required")
+	}
+
+	now := time.Now()
+	migration := &SchemaMigration{
+		ID:        fmt.Sprintf("%s-%d", comparison.TableName, now.UnixNano()),
+		TableName: comparison.TableName,
+		CreatedAt: now,
+	}
+	for _, column := range comparison.AddedColumns {
+		migration.Operations = append(migration.Operations, MigrationOperation{
+			Type:        MigrationAddColumn,
+			Parameters:  map[string]interface{}{"column": column},
+			Description: fmt.Sprintf("add column %s %s", column.Name, column.Type),
+		})
+	}
+	for _, modification := range comparison.ModifiedColumns {
+		migration.Operations = append(migration.Operations, MigrationOperation{
+			Type:        MigrationModifyColumn,
+			Parameters:  map[string]interface{}{"column": modification.NewColumn, "change_type": modification.ChangeType},
+			Description: fmt.Sprintf("modify column %s (%s)", modification.Name, strings.ToLower(modification.ChangeType)),
+		})
+	}
+	for _, column := range comparison.RemovedColumns {
+		migration.Operations = append(migration.Operations, MigrationOperation{
+			Type:        MigrationDropColumn,
+			Parameters:  map[string]interface{}{"column": column},
+			Description: fmt.Sprintf("drop column %s", column.Name),
+		})
+	}
+	for _, index := range comparison.AddedIndexes {
+		migration.Operations = append(migration.Operations, MigrationOperation{
+			Type:        MigrationAddIndex,
+			Parameters:  map[string]interface{}{"index": index},
+			Description: fmt.Sprintf("add index %s", index.Name),
+		})
+	}
+	for _, index := range comparison.RemovedIndexes {
+		migration.Operations = append(migration.Operations, MigrationOperation{
+			Type:        MigrationDropIndex,
+			Parameters:  map[string]interface{}{"index": index},
+			Description: fmt.Sprintf("drop index %s", index.Name),
+		})
+	}
+	migration.Description = fmt.Sprintf("%d schema changes to %s", len(migration.Operations), comparison.TableName)
+	return migration, nil
+}
+
+// ApplyMigration adds the new columns of a migration to the destination
+// table. Other operations are logged and skipped.
+func (e *DefaultSchemaEvolution) ApplyMigration(ctx context.Context, destination DatabaseDestination, migration *SchemaMigration) error {
+	if err := e.ValidateMigration(migration); err != nil {
+		return err
+	}
+
+	schema := TableSchema{Name: migration.TableName}
+	for _, operation := range migration.Operations {
+		if operation.Type != MigrationAddColumn {
+			logger.Warn().
+				Str("table", migration.TableName).
+				Str("operation", operation.Type).
+				Str("description", operation.Description).
+				Msg("Skipping schema change that is not additive")
+			continue
+		}
+		schema.Columns = append(schema.Columns, operation.Parameters["column"].(ColumnDefinition))
+	}
+	if len(schema.Columns) == 0 {
+		return nil
+	}
+	if err := destination.UpdateSchema(ctx, migration.TableName, schema); err != nil {
+		return fmt.Errorf("failed to apply migration %s: %w", migration.ID, err)
+	}
+	return nil
+}
+
+// ValidateMigration checks that every operation is known and carries its column or index
+func (e *DefaultSchemaEvolution) ValidateMigration(migration *SchemaMigration) error {
+	if migration == nil {
+		return fmt.Errorf("migration is required")
+	}
+	if migration.TableName == "" {
+		return fmt.Errorf("migration %s has no table", migration.ID)
+	}
+	for i, operation := range migration.Operations {
+		switch operation.Type {
+		case MigrationAddColumn, MigrationDropColumn, MigrationModifyColumn:
+			column, ok := operation.Parameters["column"].(ColumnDefinition)
+			if !ok || column.Name == "" {
+				return fmt.Errorf("operation %d (%s) has no column", i, operation.Type)
+			}
+			if operation.Type != MigrationDropColumn && column.Type == "" {
+				return fmt.Errorf("operation %d (%s) has no type for column %s", i, operation.Type, column.Name)
+			}
+		case MigrationAddIndex, MigrationDropIndex:
+			if index, ok := operation.Parameters["index"].(IndexDefinition); !ok || index.Name == "" {
+				return fmt.Errorf("operation %d (%s) has no index", i, operation.Type)
+			}
+		default:
+			return fmt.Errorf("operation %d has unknown type %q", i, operation.Type)
+		}
+	}
+	return nil
+}
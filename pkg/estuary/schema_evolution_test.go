@@ -0,0 +1,92 @@
+package estuary
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// schemaEndpoint records the columns added to its tables
+type schemaEndpoint struct {
+	writeOnlyEndpoint
+	updates []TableSchema
+}
+
+func (e *schemaEndpoint) UpdateSchema(ctx context.Context, tableName string, schema TableSchema) error {
+	e.updates = append(e.updates, schema)
+	return nil
+}
+
+func TestSchemaEvolution_CompareSchemas(t *testing.T) {
+	evolution := NewSchemaEvolution()
+	current := TableSchema{
+		Name: "orders",
+		Columns: []ColumnDefinition{
+			{Name: "id", Type: "BIGINT"},
+			{Name: "qty", Type: "INT", Nullable: true},
+			{Name: "note", Type: "TEXT", Nullable: true},
+			{Name: "legacy", Type: "TEXT", Nullable: true},
+		},
+		Indexes: []IndexDefinition{{Name: "idx_legacy", Columns: []string{"legacy"}}},
+	}
+	next := TableSchema{
+		Columns: []ColumnDefinition{
+			{Name: "ID", Type: "bigint"},
+			{Name: "qty", Type: "BIGINT", Nullable: true},
+			{Name: "note", Type: "TEXT"},
+			{Name: "total", Type: "DECIMAL(10,2)", Nullable: true},
+		},
+		Indexes: []IndexDefinition{{Name: "idx_total", Columns: []string{"total"}}},
+	}
+
+	comparison, err := evolution.CompareSchemas(current, next)
+	require.NoError(t, err)
+	assert.True(t, comparison.HasChanges)
+	assert.Equal(t, "orders", comparison.TableName)
+	assert.Equal(t, []ColumnDefinition{{Name: "total", Type: "DECIMAL(10,2)", Nullable: true}}, comparison.AddedColumns)
+	assert.Equal(t, []ColumnDefinition{{Name: "legacy", Type: "TEXT", Nullable: true}}, comparison.RemovedColumns)
+	require.Len(t, comparison.ModifiedColumns, 2)
+	assert.Equal(t, ColumnTypeChange, comparison.ModifiedColumns[0].ChangeType)
+	assert.Equal(t, ColumnNullChange, comparison.ModifiedColumns[1].ChangeType)
+	assert.Equal(t, "idx_total", comparison.AddedIndexes[0].Name)
+	assert.Equal(t, "idx_legacy", comparison.RemovedIndexes[0].Name)
+
+	comparison, err = evolution.CompareSchemas(current, current)
+	require.NoError(t, err)
+	assert.False(t, comparison.HasChanges)
+
+	_, err = evolution.CompareSchemas(current, TableSchema{Name: "customers"})
+	assert.Error(t, err)
+}
+
+func TestSchemaEvolution_Migration(t *testing.T) {
+	ctx := context.Background()
+	evolution := NewSchemaEvolution()
+	comparison, err := evolution.CompareSchemas(
+		TableSchema{Name: "orders", Columns: []ColumnDefinition{{Name: "id", Type: "BIGINT"}, {Name: "legacy", Type: "TEXT"}}},
+		TableSchema{Name: "orders", Columns: []ColumnDefinition{{Name: "id", Type: "BIGINT"}, {Name: "total", Type: "DOUBLE", Nullable: true}}},
+	)
+	require.NoError(t, err)
+
+	migration, err := evolution.GenerateMigration(comparison)
+	require.NoError(t, err)
+	require.Len(t, migration.Operations, 2)
+	assert.Equal(t, MigrationAddColumn, migration.Operations[0].Type)
+	assert.Equal(t, MigrationDropColumn, migration.Operations[1].Type)
+	require.NoError(t, evolution.ValidateMigration(migration))
+
+	// Only the added column is applied
+	endpoint := &schemaEndpoint{}
+	destination := newTestDestination(t, endpoint)
+	require.NoError(t, evolution.ApplyMigration(ctx, destination, migration))
+	require.Len(t, endpoint.updates, 1)
+	assert.Equal(t, []ColumnDefinition{{Name: "total", Type: "DOUBLE", Nullable: true}}, endpoint.updates[0].Columns)
+
+	invalid := &SchemaMigration{TableName: "orders", Operations: []MigrationOperation{{Type: MigrationAddColumn, Parameters: map[string]interface{}{}}}}
+	assert.Error(t, evolution.ValidateMigration(invalid))
+	invalid.Operations[0].Type = "RENAME_TABLE"
+	assert.Error(t, evolution.ValidateMigration(invalid))
+	assert.Error(t, evolution.ApplyMigration(ctx, destination, invalid))
+}
@@ -0,0 +1,340 @@
+package estuary
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cohenjo/replicator/pkg/config"
+)
+
+// legacyTargetConfig maps a target configuration to the stream configuration
+// the endpoints are built from
+func legacyTargetConfig(targetConfig config.TargetConfig) (*config.WaterFlowsConfig, error) {
+	// Convert new config format to legacy WaterFlowsConfig format
+	legacyConfig := &config.WaterFlowsConfig{
+		Type:       string(targetConfig.Type), // Convert TargetType to string
+		Host:       targetConfig.Host,
+		Port:       targetConfig.Port,
+		Collection: targetConfig.Database, // Use database as collection/index name
+		Schema:     targetConfig.Database, // MongoDB needs schema field for database name
+	}
+
+	// For MongoDB, handle URI and authentication configuration
+	if targetConfig.Type == config.TargetTypeMongoDB {
+		// Use URI if provided, otherwise construct from host/port
+		if targetConfig.URI != "" {
+			legacyConfig.MongoURI = targetConfig.URI
+		} else if targetConfig.Host != "" && targetConfig.Port > 0 {
+			legacyConfig.MongoURI = fmt.Sprintf("mongodb://%s:%s@%s:%d/admin?authSource=admin&directConnection=true",
+				targetConfig.Username,
+				targetConfig.Password,
+				targetConfig.Host,
+				targetConfig.Port,
+			)
+		} else {
+			return nil, fmt.Errorf("MongoDB target requires either URI or host/port configuration")
+		}
+
+		legacyConfig.MongoDatabaseName = targetConfig.Database
+
+		// Pass through authentication method and related options
+		if targetConfig.Options != nil {
+			if authMethod, ok := targetConfig.Options["auth_method"].(string); ok {
+				legacyConfig.MongoAuthMethod = authMethod
+			}
+			if tenantID, ok := targetConfig.Options["tenant_id"].(string); ok {
+				legacyConfig.MongoTenantID = tenantID
+			}
+			if clientID, ok := targetConfig.Options["client_id"].(string); ok {
+				legacyConfig.MongoClientID = clientID
+			}
+			if scopes, ok := targetConfig.Options["scopes"].([]interface{}); ok {
+				scopeStrings := make([]string, len(scopes))
+				for i, scope := range scopes {
+					if scopeStr, ok := scope.(string); ok {
+						scopeStrings[i] = scopeStr
+					}
+				}
+				legacyConfig.MongoScopes = scopeStrings
+			}
+			if refreshBefore, ok := targetConfig.Options["refresh_before_expiry"].(string); ok {
+				legacyConfig.MongoRefreshBeforeExpiry = refreshBefore
+			}
+		}
+	}
+
+	// For Kafka, handle brokers, topic routing, message keys and output format
+	if targetConfig.Type == config.TargetTypeKafka && targetConfig.Options != nil {
+		legacyConfig.KafkaBrokers = stringSliceOption(targetConfig.Options, "brokers")
+		if topic, ok := targetConfig.Options["topic"].(string); ok && topic != "" {
+			legacyConfig.Schema = topic
+		}
+		if template, ok := targetConfig.Options["topic_template"].(string); ok {
+			legacyConfig.KafkaTopicTemplate = template
+		}
+		legacyConfig.KafkaKeyFields = stringSliceOption(targetConfig.Options, "key_fields")
+		if format, ok := targetConfig.Options["format"].(string); ok {
+			legacyConfig.KafkaFormat = format
+		}
+		if txnID, ok := targetConfig.Options["transactional_id"].(string); ok {
+			legacyConfig.KafkaTransactionalID = txnID
+		}
+	}
+
+	// For Cosmos DB, handle the endpoint, container, partition key and authentication
+	if targetConfig.Type == config.TargetTypeCosmosDB {
+		legacyConfig.CosmosEndpoint = targetConfig.URI
+		if legacyConfig.CosmosEndpoint == "" && targetConfig.Host != "" {
+			legacyConfig.CosmosEndpoint = targetConfig.Host
+			if !strings.Contains(legacyConfig.CosmosEndpoint, "://") {
+				legacyConfig.CosmosEndpoint = "https://" + legacyConfig.CosmosEndpoint
+			}
+			if targetConfig.Port > 0 {
+				legacyConfig.CosmosEndpoint = fmt.Sprintf("%s:%d", legacyConfig.CosmosEndpoint, targetConfig.Port)
+			}
+		}
+		legacyConfig.CosmosDatabaseName = targetConfig.Database
+		legacyConfig.CosmosAccountKey = targetConfig.Password
+
+		if targetConfig.Options != nil {
+			if container, ok := targetConfig.Options["container"].(string); ok {
+				legacyConfig.CosmosContainerName = container
+			}
+			legacyConfig.CosmosPartitionKeyPaths = stringSliceOption(targetConfig.Options, "partition_key_path")
+			if idField, ok := targetConfig.Options["id_field"].(string); ok {
+				legacyConfig.CosmosIDField = idField
+			}
+			if key, ok := targetConfig.Options["account_key"].(string); ok {
+				legacyConfig.CosmosAccountKey = key
+			}
+			if authMethod, ok := targetConfig.Options["auth_method"].(string); ok {
+				legacyConfig.CosmosAuthMethod = authMethod
+			}
+			if tenantID, ok := targetConfig.Options["tenant_id"].(string); ok {
+				legacyConfig.CosmosTenantID = tenantID
+			}
+			if clientID, ok := targetConfig.Options["client_id"].(string); ok {
+				legacyConfig.CosmosClientID = clientID
+			}
+			if clientSecret, ok := targetConfig.Options["client_secret"].(string); ok {
+				legacyConfig.CosmosClientSecret = clientSecret
+			}
+			legacyConfig.CosmosMaxConcurrency = intOption(targetConfig.Options, "max_concurrency")
+			if skipVerify, ok := targetConfig.Options["insecure_skip_verify"].(bool); ok {
+				legacyConfig.CosmosInsecureSkipVerify = skipVerify
+			}
+		}
+
+		// Without explicit settings, authenticate like the rest of the Azure integration
+		if legacyConfig.CosmosAuthMethod == "" && legacyConfig.CosmosAccountKey == "" && config.Global != nil {
+			azureAuth := config.Global.Azure.Authentication
+			legacyConfig.CosmosAuthMethod = azureAuth.Method
+			legacyConfig.CosmosTenantID = azureAuth.TenantID
+			legacyConfig.CosmosClientID = azureAuth.ClientID
+			legacyConfig.CosmosClientSecret = azureAuth.ClientSecret
+		}
+	}
+
+	// For Elasticsearch, handle the nodes, credentials, TLS, index pattern, document ids, bulk thresholds and templates
+	if targetConfig.Type == config.TargetTypeElastic {
+		// The URI may list several nodes, separated by commas
+		for _, address := range strings.Split(targetConfig.URI, ",") {
+			if address = strings.TrimSpace(address); address != "" {
+				legacyConfig.ElasticAddresses = append(legacyConfig.ElasticAddresses, address)
+			}
+		}
+		legacyConfig.ElasticUsername = targetConfig.Username
+		legacyConfig.ElasticPassword = targetConfig.Password
+	}
+	if targetConfig.Type == config.TargetTypeElastic && targetConfig.Options != nil {
+		if addresses := stringSliceOption(targetConfig.Options, "addresses"); len(addresses) > 0 {
+			legacyConfig.ElasticAddresses = addresses
+		}
+		if apiKey, ok := targetConfig.Options["api_key"].(string); ok {
+			legacyConfig.ElasticAPIKey = apiKey
+		}
+		if caCert, ok := targetConfig.Options["ca_cert"].(string); ok {
+			legacyConfig.ElasticCACert = caCert
+		}
+		if clientCert, ok := targetConfig.Options["client_cert"].(string); ok {
+			legacyConfig.ElasticClientCert = clientCert
+		}
+		if clientKey, ok := targetConfig.Options["client_key"].(string); ok {
+			legacyConfig.ElasticClientKey = clientKey
+		}
+		if skipVerify, ok := targetConfig.Options["insecure_skip_verify"].(bool); ok {
+			legacyConfig.ElasticInsecureSkipVerify = skipVerify
+		}
+		switch version := targetConfig.Options["version"].(type) {
+		case string:
+			legacyConfig.ElasticVersion = version
+		case int, float64:
+			legacyConfig.ElasticVersion = fmt.Sprintf("%v", version)
+		}
+		legacyConfig.ElasticCompatibleWith = intOption(targetConfig.Options, "compatible_with")
+		if externalVersion, ok := targetConfig.Options["external_version"].(bool); ok {
+			legacyConfig.ElasticExternalVersion = externalVersion
+		}
+		if index, ok := targetConfig.Options["index"].(string); ok && index != "" {
+			legacyConfig.Collection = index
+		}
+		if idField, ok := targetConfig.Options["id_field"].(string); ok {
+			legacyConfig.ElasticIDField = idField
+		}
+		switch refresh := targetConfig.Options["refresh"].(type) {
+		case string:
+			legacyConfig.ElasticRefresh = refresh
+		case bool:
+			legacyConfig.ElasticRefresh = fmt.Sprintf("%t", refresh)
+		}
+		legacyConfig.ElasticBulkActions = intOption(targetConfig.Options, "bulk_size")
+		legacyConfig.ElasticBulkBytes = intOption(targetConfig.Options, "bulk_bytes")
+		if timeout, ok := targetConfig.Options["bulk_timeout"].(string); ok {
+			interval, err := time.ParseDuration(timeout)
+			if err != nil {
+				return nil, fmt.Errorf("invalid Elasticsearch bulk_timeout %q: %w", timeout, err)
+			}
+			legacyConfig.ElasticBulkTimeout = int(interval.Milliseconds())
+		}
+		if name, ok := targetConfig.Options["template_name"].(string); ok {
+			legacyConfig.ElasticTemplateName = name
+		}
+		legacyConfig.ElasticTemplate = mapOption(targetConfig.Options, "template")
+		legacyConfig.ElasticMappings = mapOption(targetConfig.Options, "mappings")
+		legacyConfig.ElasticSettings = mapOption(targetConfig.Options, "settings")
+	}
+
+	// For MySQL, handle the credentials, target table and write options
+	if targetConfig.Type == config.TargetTypeMySQL {
+		legacyConfig.MySQLURI = targetConfig.URI
+		legacyConfig.MySQLUser = targetConfig.Username
+		legacyConfig.MySQLPassword = targetConfig.Password
+		// Rows go to the table of their source collection unless a table is configured
+		legacyConfig.Collection = ""
+
+		if targetConfig.Options != nil {
+			if table, ok := targetConfig.Options["table"].(string); ok {
+				legacyConfig.Collection = table
+			}
+			legacyConfig.MySQLKeyColumns = stringSliceOption(targetConfig.Options, "key_columns")
+			legacyConfig.MySQLBatchRows = intOption(targetConfig.Options, "batch_rows")
+		}
+	}
+
+	// For PostgreSQL, handle the connection, target table and write options
+	if targetConfig.Type == config.TargetTypePostgreSQL {
+		legacyConfig.PostgreSQLURI = targetConfig.URI
+		legacyConfig.PostgreSQLHost = targetConfig.Host
+		legacyConfig.PostgreSQLPort = targetConfig.Port
+		legacyConfig.PostgreSQLDatabase = targetConfig.Database
+		legacyConfig.PostgreSQLUser = targetConfig.Username
+		legacyConfig.PostgreSQLPassword = targetConfig.Password
+		// Rows go to the table of their source collection unless a table is configured
+		legacyConfig.Collection = ""
+
+		if targetConfig.Options != nil {
+			if table, ok := targetConfig.Options["table"].(string); ok {
+				legacyConfig.Collection = table
+			}
+			if schema, ok := targetConfig.Options["schema"].(string); ok {
+				legacyConfig.PostgreSQLTargetSchema = schema
+			}
+			legacyConfig.PostgreSQLKeyColumns = stringSliceOption(targetConfig.Options, "key_columns")
+			if autoCreate, ok := targetConfig.Options["auto_create"].(bool); ok {
+				legacyConfig.PostgreSQLAutoCreate = autoCreate
+			}
+			if batchMode, ok := targetConfig.Options["batch_mode"].(string); ok {
+				legacyConfig.PostgreSQLBatchMode = batchMode
+			}
+			if sslMode, ok := targetConfig.Options["ssl_mode"].(string); ok {
+				legacyConfig.PostgreSQLSSLMode = sslMode
+			}
+		}
+	}
+
+	// Override collection from options if specified
+	if targetConfig.Options != nil {
+		if collection, ok := targetConfig.Options["collection"].(string); ok && collection != "" {
+			legacyConfig.Collection = collection
+			if targetConfig.Type == config.TargetTypeMongoDB {
+				legacyConfig.MongoCollectionName = collection
+			}
+			if targetConfig.Type == config.TargetTypeCosmosDB && legacyConfig.CosmosContainerName == "" {
+				legacyConfig.CosmosContainerName = collection
+			}
+		}
+	}
+
+	return legacyConfig, nil
+}
+
+// stringSliceOption reads a list of strings from target options.
+// A single string value is split on commas.
+func stringSliceOption(options map[string]interface{}, key string) []string {
+	switch v := options[key].(type) {
+	case []string:
+		return v
+	case []interface{}:
+		result := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok && s != "" {
+				result = append(result, s)
+			}
+		}
+		return result
+	case string:
+		var result []string
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				result = append(result, item)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+// intOption reads an integer from target options, which JSON decodes as float64
+func intOption(options map[string]interface{}, key string) int {
+	switch v := options[key].(type) {
+	case int:
+		return v
+	case float64:
+		return int(v)
+	}
+	return 0
+}
+
+// mapOption reads an object from target options. YAML may decode nested
+// objects with interface keys, which are converted to strings.
+func mapOption(options map[string]interface{}, key string) map[string]interface{} {
+	if m, ok := normalizeOption(options[key]).(map[string]interface{}); ok {
+		return m
+	}
+	return nil
+}
+
+func normalizeOption(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[key] = normalizeOption(item)
+		}
+		return result
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[fmt.Sprintf("%v", key)] = normalizeOption(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = normalizeOption(item)
+		}
+		return result
+	}
+	return value
+}
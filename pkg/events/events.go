@@ -5,6 +5,10 @@ const (
 	UpdateAction = "update"
 	InsertAction = "insert"
 	DeleteAction = "delete"
+
+	// CommitAction marks the end of a source transaction. Streams grouping
+	// their events by transaction send it after the last event of each one.
+	CommitAction = "commit"
 )
 
 type RecordKey struct {
@@ -18,6 +22,11 @@ Action: <insert|update|delete>
 schema & collection are mapped to the equivilent terms for the other databases (e.g. db & table)
 OldData contains the key to the previous record being changes (used for updates & deletes)
 Data olds the full document in JSON format.
+Position carries the source position of the event when the stream reports one
+(e.g. topic/partition/offset for Kafka), so sinks can commit it alongside their writes.
+Stream names the replication stream that read the event.
+Transaction identifies the source transaction of the event when the stream groups
+its events by transaction; a CommitAction event with the same id ends it.
 */
 type RecordEvent struct {
 	Action      string
@@ -26,6 +35,9 @@ type RecordEvent struct {
 	DocumentKey []byte // Explicit document identifier for updates and deletes
 	OldData     []byte // Used for updates.
 	Data        []byte // let's keep a json here to use Kazaam
+	Position    map[string]interface{} `json:",omitempty"` // Source position, if known
+	Stream      string                 `json:",omitempty"` // Name of the stream that read the event
+	Transaction string                 `json:",omitempty"` // Source transaction, when events are grouped by transaction
 }
 
 type KafkaMessage struct {
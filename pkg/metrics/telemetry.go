@@ -257,6 +257,15 @@ func (tm *TelemetryManager) createInstruments() error {
 		return fmt.Errorf("failed to create mongodb_events_fallback_failed counter: %w", err)
 	}
 	
+	tm.counters["transformation_rule_executions"], err = tm.meter.Int64Counter(
+		"replicator_transformation_rule_executions_total",
+		metric.WithDescription("Total number of transformation rule executions"),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create transformation_rule_executions counter: %w", err)
+	}
+	
 	// Histograms
 	tm.histograms["replication_lag"], err = tm.meter.Float64Histogram(
 		"replicator_replication_lag_seconds",
@@ -276,6 +285,15 @@ func (tm *TelemetryManager) createInstruments() error {
 		return fmt.Errorf("failed to create processing_duration histogram: %w", err)
 	}
 
+	tm.histograms["transformation_rule_duration"], err = tm.meter.Float64Histogram(
+		"replicator_transformation_rule_duration_seconds",
+		metric.WithDescription("Transformation rule execution duration in seconds"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create transformation_rule_duration histogram: %w", err)
+	}
+
 	// Observable gauges
 	tm.gauges["active_streams"], err = tm.meter.Float64ObservableGauge(
 		"replicator_active_streams",
@@ -381,6 +399,26 @@ func (tm *TelemetryManager) RecordBytes(ctx context.Context, streamName string,
 		tm.counters["bytes_processed"].Add(ctx, bytes, metric.WithAttributes(attributes...))
 	}
 	
+// RecordTransformationRule records the execution of a transformation rule of a stream
+func (tm *TelemetryManager) RecordTransformationRule(ctx context.Context, streamName, rule string, duration time.Duration, success bool) {
+	if !tm.config.Metrics.Enabled {
+		return
+	}
+
+	attributes := []attribute.KeyValue{
+		attribute.String("stream_name", streamName),
+		attribute.String("rule", rule),
+		attribute.Bool("success", success),
+	}
+
+	if counter, exists := tm.counters["transformation_rule_executions"]; exists {
+		counter.Add(ctx, 1, metric.WithAttributes(attributes...))
+	}
+	if histogram, exists := tm.histograms["transformation_rule_duration"]; exists {
+		histogram.Record(ctx, duration.Seconds(), metric.WithAttributes(attributes...))
+	}
+}
+
 // RecordMongoRecoveryMode records MongoDB recovery mode metrics
 func (tm *TelemetryManager) RecordMongoRecoveryMode(ctx context.Context, streamName, operation, recoveryMode string) {
 	if !tm.config.Metrics.Enabled {
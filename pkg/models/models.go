@@ -21,6 +21,7 @@ type StreamState struct {
 	StartedAt          *time.Time             `json:"started_at,omitempty"`
 	StoppedAt          *time.Time             `json:"stopped_at,omitempty"`
 	LastHeartbeatAt    *time.Time             `json:"last_heartbeat_at,omitempty"`
+	Statistics         *EventStatistics       `json:"statistics,omitempty"`
 }
 
 // ChangeEvent represents a change event from a data source
@@ -104,6 +105,13 @@ type Stream interface {
 	GetCheckpoint() (map[string]interface{}, error)
 }
 
+// PositionCommitter is implemented by streams that commit their source
+// position only once the events read before it are applied
+type PositionCommitter interface {
+	// CommitPosition commits a source position reported by the stream
+	CommitPosition(position map[string]interface{}) error
+}
+
 // StreamManager represents a manager for multiple replication streams
 type StreamManager interface {
 	// CreateStream creates a new replication stream
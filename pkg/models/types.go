@@ -113,6 +113,7 @@ type RetryPolicy struct {
 	InitialDelay    time.Duration `json:"initial_delay"`
 	MaxDelay        time.Duration `json:"max_delay"`
 	BackoffFactor   float64       `json:"backoff_factor"`
+	Jitter          float64       `json:"jitter"` // fraction of the delay added or removed at random
 	RetryableErrors []string      `json:"retryable_errors"`
 }
 
@@ -0,0 +1,138 @@
+package position
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CosmosDBPosition implements Position for the Cosmos DB change feed.
+// The change feed is read per feed range (physical partition key range), and
+// each range keeps its own continuation ETag.
+type CosmosDBPosition struct {
+	// Continuations maps feed range id to its change feed continuation (ETag)
+	Continuations map[string]string `json:"continuations"`
+
+	// Mode is the change feed mode (latest_version, all_versions_and_deletes)
+	Mode string `json:"mode,omitempty"`
+
+	// Database is the database name
+	Database string `json:"database,omitempty"`
+
+	// Container is the container name
+	Container string `json:"container,omitempty"`
+
+	// Timestamp when the position was captured
+	Timestamp int64 `json:"timestamp"`
+}
+
+// NewCosmosDBPosition creates a new, empty Cosmos DB position
+func NewCosmosDBPosition(database, container string) *CosmosDBPosition {
+	return &CosmosDBPosition{
+		Continuations: make(map[string]string),
+		Database:      database,
+		Container:     container,
+	}
+}
+
+// Serialize converts the position to JSON bytes
+func (cp *CosmosDBPosition) Serialize() ([]byte, error) {
+	return json.Marshal(cp)
+}
+
+// Deserialize restores the position from JSON bytes
+func (cp *CosmosDBPosition) Deserialize(data []byte) error {
+	if err := json.Unmarshal(data, cp); err != nil {
+		return err
+	}
+	if cp.Continuations == nil {
+		cp.Continuations = make(map[string]string)
+	}
+	return nil
+}
+
+// String returns a human-readable representation
+func (cp *CosmosDBPosition) String() string {
+	ranges := make([]string, 0, len(cp.Continuations))
+	for id := range cp.Continuations {
+		ranges = append(ranges, id)
+	}
+	sort.Strings(ranges)
+
+	parts := make([]string, 0, len(ranges))
+	for _, id := range ranges {
+		parts = append(parts, fmt.Sprintf("%s=%s", id, cp.Continuations[id]))
+	}
+	return fmt.Sprintf("container=%s/%s, ranges=[%s]", cp.Database, cp.Container, strings.Join(parts, ", "))
+}
+
+// IsValid checks if the position is valid
+func (cp *CosmosDBPosition) IsValid() bool {
+	return len(cp.Continuations) > 0
+}
+
+// Compare compares this position with another Cosmos DB position.
+// Continuations are opaque, so positions are ordered by capture time.
+func (cp *CosmosDBPosition) Compare(other Position) int {
+	otherCosmos, ok := other.(*CosmosDBPosition)
+	if !ok {
+		return -1 // Different types, this is considered "less than"
+	}
+
+	if cp.Timestamp < otherCosmos.Timestamp {
+		return -1
+	} else if cp.Timestamp > otherCosmos.Timestamp {
+		return 1
+	}
+
+	return 0
+}
+
+// SetContinuation records the continuation of a feed range
+func (cp *CosmosDBPosition) SetContinuation(feedRange, continuation string) {
+	if cp.Continuations == nil {
+		cp.Continuations = make(map[string]string)
+	}
+	cp.Continuations[feedRange] = continuation
+	cp.Timestamp = time.Now().Unix()
+}
+
+// GetContinuation returns the continuation of a feed range, if known
+func (cp *CosmosDBPosition) GetContinuation(feedRange string) (string, bool) {
+	continuation, ok := cp.Continuations[feedRange]
+	return continuation, ok
+}
+
+// Clone creates a deep copy of this position
+func (cp *CosmosDBPosition) Clone() *CosmosDBPosition {
+	clone := &CosmosDBPosition{
+		Continuations: make(map[string]string, len(cp.Continuations)),
+		Mode:          cp.Mode,
+		Database:      cp.Database,
+		Container:     cp.Container,
+		Timestamp:     cp.Timestamp,
+	}
+	for id, continuation := range cp.Continuations {
+		clone.Continuations[id] = continuation
+	}
+	return clone
+}
+
+// CosmosDBPositionFactory creates Cosmos DB positions from serialized data
+type CosmosDBPositionFactory struct{}
+
+// CreatePosition creates a Cosmos DB position from serialized data
+func (f *CosmosDBPositionFactory) CreatePosition(data []byte) (Position, error) {
+	var pos CosmosDBPosition
+	if err := pos.Deserialize(data); err != nil {
+		return nil, err
+	}
+	return &pos, nil
+}
+
+// GetPositionType returns the position type identifier
+func (f *CosmosDBPositionFactory) GetPositionType() string {
+	return "cosmosdb"
+}
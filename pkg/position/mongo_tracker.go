@@ -93,7 +93,8 @@ type MongoWriteConcern struct {
 	// J specifies whether to wait for journal acknowledgment
 	J bool `json:"j" yaml:"j"`
 	
-	// WTimeout specifies the time limit for the write concern
+	// WTimeout specifies the time limit for the write concern. The driver has
+	// no wtimeout option, so it bounds the context of the writes instead.
 	WTimeout time.Duration `json:"wtimeout" yaml:"wtimeout"`
 }
 
@@ -241,35 +242,24 @@ SetRetryReads(config.RetryReads)
 	
 	// Set write concern
 	if config.WriteConcern != nil {
-		wcOpts := make([]writeconcern.Option, 0)
+		wc := &writeconcern.WriteConcern{}
 		
 		// Set W (write concern)
 		switch w := config.WriteConcern.W.(type) {
-case int:
-wcOpts = append(wcOpts, writeconcern.W(w))
-case string:
-if w == "majority" {
-wcOpts = append(wcOpts, writeconcern.WMajority())
-} else {
-wcOpts = append(wcOpts, writeconcern.WTagSet(w))
-}
+		case int, string:
+			wc.W = w
 		default:
-			wcOpts = append(wcOpts, writeconcern.WMajority())
+			wc.W = "majority"
 		}
 		
 		// Set journal requirement
-if config.WriteConcern.J {
-wcOpts = append(wcOpts, writeconcern.J(true))
-}
-		
-		// Set timeout
-if config.WriteConcern.WTimeout > 0 {
-wcOpts = append(wcOpts, writeconcern.WTimeout(config.WriteConcern.WTimeout))
-}
+		if config.WriteConcern.J {
+			journal := true
+			wc.Journal = &journal
+		}
 		
-		wc := writeconcern.New(wcOpts...)
-clientOpts.SetWriteConcern(wc)
-			}
+		clientOpts.SetWriteConcern(wc)
+	}
 	
 	// Set compressors
 	if len(config.Compressors) > 0 {
@@ -280,7 +270,7 @@ clientOpts.SetWriteConcern(wc)
 	ctx, cancel := context.WithTimeout(context.Background(), config.ConnectTimeout)
 	defer cancel()
 	
-	client, err := mongo.Connect(ctx, clientOpts)
+	client, err := mongo.Connect(clientOpts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
 	}
@@ -356,6 +346,12 @@ func (mt *MongoTracker) Save(ctx context.Context, streamID string, position Posi
 		Version:      time.Now().Unix(), // Simple versioning for optimistic locking
 	}
 	
+	if mt.config.WriteConcern != nil && mt.config.WriteConcern.WTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, mt.config.WriteConcern.WTimeout)
+		defer cancel()
+	}
+	
 	if mt.config.EnableTransactions {
 		return mt.saveWithTransaction(ctx, &doc)
 	}
@@ -371,7 +367,7 @@ func (mt *MongoTracker) saveWithTransaction(ctx context.Context, doc *MongoPosit
 	}
 	defer session.EndSession(ctx)
 	
-	callback := func(sessionCtx mongo.SessionContext) (interface{}, error) {
+	callback := func(sessionCtx context.Context) (interface{}, error) {
 		// Check if document exists to preserve created_at
 		filter := bson.M{"_id": doc.ID}
 		var existing MongoPositionDocument
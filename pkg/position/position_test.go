@@ -297,6 +297,36 @@ func TestPostgreSQLPosition_Validation(t *testing.T) {
 	}
 }
 
+// ===== Cosmos DB Position Tests =====
+
+func TestCosmosDBPosition_Serialization(t *testing.T) {
+	position := NewCosmosDBPosition("testdb", "testcontainer")
+	assert.False(t, position.IsValid())
+
+	position.Mode = "latest_version"
+	position.SetContinuation("0", `"120"`)
+	position.SetContinuation("1", `"98"`)
+	assert.True(t, position.IsValid())
+
+	data, err := position.Serialize()
+	require.NoError(t, err)
+
+	deserialized := &CosmosDBPosition{}
+	require.NoError(t, deserialized.Deserialize(data))
+	assert.Equal(t, position.Continuations, deserialized.Continuations)
+	assert.Equal(t, "latest_version", deserialized.Mode)
+	assert.Equal(t, `container=testdb/testcontainer, ranges=[0="120", 1="98"]`, deserialized.String())
+
+	continuation, ok := deserialized.GetContinuation("1")
+	assert.True(t, ok)
+	assert.Equal(t, `"98"`, continuation)
+
+	clone := deserialized.Clone()
+	clone.SetContinuation("1", `"99"`)
+	continuation, _ = deserialized.GetContinuation("1")
+	assert.Equal(t, `"98"`, continuation)
+}
+
 // ===== File Tracker Tests =====
 
 func TestFileTracker_BasicOperations(t *testing.T) {
@@ -0,0 +1,423 @@
+package replicator
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+
+	"github.com/cohenjo/replicator/pkg/config"
+	"github.com/cohenjo/replicator/pkg/events"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultWorkers is the number of workers applying the events of a stream
+const DefaultWorkers = 1
+
+// workersForStream returns the number of workers applying the events of a stream
+func workersForStream(stream config.StreamConfig) int {
+	if stream.Workers > 0 {
+		return stream.Workers
+	}
+	return DefaultWorkers
+}
+
+// ApplyFunc applies a batch of events of a stream and reports whether every
+// event was applied: written, skipped or sent to the dead-letter queue
+type ApplyFunc func(ctx context.Context, batch []events.RecordEvent) bool
+
+// ApplyStage applies the events of a stream with parallel workers, each
+// batching and writing its own events. Events are hash-partitioned by
+// document key, or by table when they have none, so the changes of a row are
+// applied in order by the same worker. Events ordered by their table, schema
+// changes and rows without a key, are barriers: they are handed to their
+// worker once the events of the table read before them are applied, and the
+// rows of the table read after them wait for them. Source positions are acknowledged in
+// stream order, once every event read before them is applied. The position
+// stops at the first batch that failed, so the stream resumes from it, and
+// the stage stops reading events once its window of events not yet
+// acknowledged is full.
+//
+// Events grouped by source transaction are applied by a single worker, one
+// transaction after the other in commit order. The commit events ending the
+// transactions are acknowledged without being applied.
+type ApplyStage struct {
+	name     string
+	events   <-chan events.RecordEvent
+	workers  []chan SequencedEvent
+	batchers []*EventBatcher
+	acks     *PositionAcks
+
+	mu       sync.Mutex
+	inFlight map[string][]int // events of a table handed to each worker and not yet applied
+	barriers map[string]int   // barriers of a table handed to their worker and not yet applied
+	applied  chan struct{}    // signalled when a worker applied a batch
+}
+
+// NewApplyStage creates the apply stage of a stream reading its events from
+// the channel and applying them with apply
+func NewApplyStage(name string, batchConfig BatchConfig, workers int, in <-chan events.RecordEvent, apply ApplyFunc) *ApplyStage {
+	if workers < 1 || batchConfig.MaxTransactionEvents > 0 {
+		workers = 1
+	}
+	stage := &ApplyStage{
+		name:     name,
+		events:   in,
+		workers:  make([]chan SequencedEvent, workers),
+		acks:     NewPositionAcks(pendingWindow(batchConfig, workers)),
+		inFlight: make(map[string][]int),
+		barriers: make(map[string]int),
+		applied:  make(chan struct{}, 1),
+	}
+	for i := range stage.workers {
+		worker := i
+		stage.workers[i] = make(chan SequencedEvent, batchConfig.Size)
+		stage.batchers = append(stage.batchers, NewEventBatcher(batchConfig, stage.workers[i], func(ctx context.Context, batch []SequencedEvent) {
+			records := make([]events.RecordEvent, 0, len(batch))
+			seqs := make([]uint64, len(batch))
+			for i, event := range batch {
+				seqs[i] = event.Seq
+				if event.Event.Action != events.CommitAction {
+					records = append(records, event.Event)
+				}
+			}
+			applied := len(records) == 0 || apply(ctx, records)
+			// Writes interrupted by shutdown are not acknowledged
+			if applied && ctx.Err() == nil {
+				stage.acks.Ack(seqs...)
+			}
+			stage.release(worker, batch)
+		}))
+	}
+	return stage
+}
+
+// pendingWindow returns the number of events a stage reads ahead of its
+// acknowledged position: the buffered events, a batch per worker and a
+// whole source transaction
+func pendingWindow(batchConfig BatchConfig, workers int) int {
+	return batchConfig.Buffer + workers*max(batchConfig.Size, 1) + batchConfig.MaxTransactionEvents
+}
+
+// Workers returns the number of workers of the stage
+func (a *ApplyStage) Workers() int {
+	return len(a.workers)
+}
+
+// BatchConfig returns the micro-batching of the workers
+func (a *ApplyStage) BatchConfig() BatchConfig {
+	return a.batchers[0].Config()
+}
+
+// Acks returns the position acknowledgements of the stream
+func (a *ApplyStage) Acks() *PositionAcks {
+	return a.acks
+}
+
+// Run dispatches the events of the stream to its workers until the context is
+// done or stop is closed. On stop the events still buffered are handed to the
+// workers, which flush them before Run returns.
+func (a *ApplyStage) Run(ctx context.Context, stop <-chan struct{}) {
+	workersStop := make(chan struct{})
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer close(workersStop)
+	for _, batcher := range a.batchers {
+		wg.Add(1)
+		go func(batcher *EventBatcher) {
+			defer wg.Done()
+			batcher.Run(ctx, workersStop)
+		}(batcher)
+	}
+
+	stalled := false
+	for {
+		// A batch that failed holds the acknowledged position, events are
+		// no longer read once the window behind it is full
+		if a.acks.Full() {
+			if !stalled {
+				log.Warn().Str("stream", a.name).Int("pending", a.acks.Pending()).Msg("Events not acknowledged, waiting before reading more")
+				stalled = true
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-stop:
+				a.drain(ctx)
+				return
+			case <-a.acks.Acked():
+			}
+			continue
+		}
+		stalled = false
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-stop:
+			a.drain(ctx)
+			return
+		case event := <-a.events:
+			if err := a.dispatch(ctx, event); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// drain hands the events still buffered in the stream channel to the
+// workers, giving up when drainTimeout runs out. The events left are not
+// acknowledged, so the stream reads them again once restarted.
+func (a *ApplyStage) drain(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, drainTimeout)
+	defer cancel()
+	for {
+		select {
+		case event := <-a.events:
+			if err := a.dispatch(ctx, event); err != nil {
+				log.Warn().Err(err).Str("stream", a.name).Int("events", len(a.events)+1).Msg("Events not applied on stop, they are read again on restart")
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// dispatch hands an event to its worker, waiting for the events it is
+// ordered after on the other workers. It fails only when the context is done.
+func (a *ApplyStage) dispatch(ctx context.Context, event events.RecordEvent) error {
+	worker := partition(event, len(a.workers))
+	if len(a.workers) > 1 {
+		if err := a.acquire(ctx, worker, event); err != nil {
+			return err
+		}
+	}
+	item := SequencedEvent{Seq: a.acks.Track(event.Position), Event: event}
+	select {
+	case a.workers[worker] <- item:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// acquire waits until an event can be handed to its worker and counts it in
+// flight. A barrier waits for the events of its table on the other workers,
+// a row of a table waits for the barriers of the table on another worker.
+func (a *ApplyStage) acquire(ctx context.Context, worker int, event events.RecordEvent) error {
+	table := tableKey(event)
+	barrier := partitionKey(event) == nil
+	barrierWorker := partition(events.RecordEvent{Schema: event.Schema, Collection: event.Collection}, len(a.workers))
+	for {
+		a.mu.Lock()
+		inFlight := a.inFlight[table]
+		if inFlight == nil {
+			inFlight = make([]int, len(a.workers))
+			a.inFlight[table] = inFlight
+		}
+		ready := true
+		if barrier {
+			for w, n := range inFlight {
+				if w != worker && n > 0 {
+					ready = false
+				}
+			}
+		} else if worker != barrierWorker && a.barriers[table] > 0 {
+			ready = false
+		}
+		if ready {
+			inFlight[worker]++
+			if barrier {
+				a.barriers[table]++
+			}
+		}
+		a.mu.Unlock()
+		if ready {
+			return nil
+		}
+
+		select {
+		case <-a.applied:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// release counts the events of a batch applied by a worker out of flight
+func (a *ApplyStage) release(worker int, batch []SequencedEvent) {
+	if len(a.workers) <= 1 {
+		return
+	}
+	a.mu.Lock()
+	for _, item := range batch {
+		table := tableKey(item.Event)
+		if partitionKey(item.Event) == nil && a.barriers[table] > 0 {
+			a.barriers[table]--
+			if a.barriers[table] == 0 {
+				delete(a.barriers, table)
+			}
+		}
+		inFlight := a.inFlight[table]
+		if inFlight == nil {
+			continue
+		}
+		inFlight[worker]--
+		idle := a.barriers[table] == 0
+		for _, n := range inFlight {
+			idle = idle && n == 0
+		}
+		if idle {
+			delete(a.inFlight, table)
+		}
+	}
+	a.mu.Unlock()
+
+	select {
+	case a.applied <- struct{}{}:
+	default:
+	}
+}
+
+// tableKey returns the table of an event, the unit barriers order
+func tableKey(event events.RecordEvent) string {
+	return event.Schema + "\x00" + event.Collection
+}
+
+// partition returns the worker of an event out of n
+func partition(event events.RecordEvent, n int) int {
+	if n <= 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(tableKey(event)))
+	if key := partitionKey(event); key != nil {
+		h.Write([]byte{0})
+		h.Write(key)
+	}
+	return int(h.Sum32() % uint32(n))
+}
+
+// partitionKey returns the document key ordering the changes of a row, nil
+// when the event is ordered by its table: schema changes and other events
+// that are not row changes, and rows without a document key
+func partitionKey(event events.RecordEvent) []byte {
+	switch event.Action {
+	case events.InsertAction, events.UpdateAction, events.DeleteAction, "replace":
+	default:
+		return nil
+	}
+	// Streams that fail to extract a key send an empty document
+	if len(event.DocumentKey) == 0 || string(event.DocumentKey) == "{}" {
+		return nil
+	}
+	return event.DocumentKey
+}
+
+// PositionAcks acknowledges the source positions of a stream in the order
+// the stream read its events, while the workers apply them out of order. The
+// acknowledged position is the position of the newest event that has every
+// event before it applied.
+type PositionAcks struct {
+	mu        sync.Mutex
+	next      uint64                            // sequence number of the next event
+	watermark uint64                            // every event before it is applied
+	window    int                               // pending events before the acks are full, 0 for no limit
+	applied   map[uint64]bool                   // applied events from the watermark on
+	positions map[uint64]map[string]interface{} // positions of the pending events
+	position  map[string]interface{}            // acknowledged position
+	acked     chan struct{}                     // signalled when the watermark moves
+	onAck     func(position map[string]interface{})
+}
+
+// NewPositionAcks creates the position acknowledgements of a stream, full
+// once window events are pending. A window of 0 is never full.
+func NewPositionAcks(window int) *PositionAcks {
+	return &PositionAcks{
+		window:    window,
+		applied:   make(map[uint64]bool),
+		positions: make(map[uint64]map[string]interface{}),
+		acked:     make(chan struct{}, 1),
+	}
+}
+
+// Track returns the sequence number of the next event read by the stream,
+// with its source position, which may be nil
+func (p *PositionAcks) Track(position map[string]interface{}) uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	seq := p.next
+	p.next++
+	if position != nil {
+		p.positions[seq] = position
+	}
+	return seq
+}
+
+// OnAcknowledged sets the function called with every position acknowledged,
+// in stream order. Sources reading several partitions report a position per
+// partition, none of which may be skipped.
+func (p *PositionAcks) OnAcknowledged(fn func(position map[string]interface{})) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onAck = fn
+}
+
+// Ack marks events as applied and reports whether the acknowledged position
+// moved forward
+func (p *PositionAcks) Ack(seqs ...uint64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, seq := range seqs {
+		if seq >= p.watermark {
+			p.applied[seq] = true
+		}
+	}
+	moved := false
+	watermark := p.watermark
+	for p.applied[p.watermark] {
+		if position, ok := p.positions[p.watermark]; ok {
+			p.position = position
+			moved = true
+			if p.onAck != nil {
+				p.onAck(position)
+			}
+		}
+		delete(p.applied, p.watermark)
+		delete(p.positions, p.watermark)
+		p.watermark++
+	}
+	if p.watermark != watermark {
+		select {
+		case p.acked <- struct{}{}:
+		default:
+		}
+	}
+	return moved
+}
+
+// Acked returns a channel signalled when events are acknowledged
+func (p *PositionAcks) Acked() <-chan struct{} {
+	return p.acked
+}
+
+// Full reports whether the window of pending events is full
+func (p *PositionAcks) Full() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.window > 0 && p.next-p.watermark >= uint64(p.window)
+}
+
+// Position returns the acknowledged position, nil when none is
+func (p *PositionAcks) Position() map[string]interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.position
+}
+
+// Pending returns the number of events read and not yet acknowledged
+func (p *PositionAcks) Pending() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return int(p.next - p.watermark)
+}
@@ -0,0 +1,282 @@
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cohenjo/replicator/pkg/config"
+	"github.com/cohenjo/replicator/pkg/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkersForStream(t *testing.T) {
+	assert.Equal(t, DefaultWorkers, workersForStream(config.StreamConfig{}))
+	assert.Equal(t, 8, workersForStream(config.StreamConfig{Workers: 8}))
+}
+
+func rowEvent(collection string, key int) events.RecordEvent {
+	return events.RecordEvent{
+		Action:      events.UpdateAction,
+		Schema:      "shop",
+		Collection:  collection,
+		DocumentKey: []byte(fmt.Sprintf(`{"_id":%d}`, key)),
+	}
+}
+
+func TestPartition(t *testing.T) {
+	assert.Equal(t, 0, partition(rowEvent("orders", 1), 1))
+
+	// The changes of a row go to the same worker
+	workers := make(map[int]bool)
+	for key := 0; key < 100; key++ {
+		worker := partition(rowEvent("orders", key), 8)
+		assert.Equal(t, worker, partition(rowEvent("orders", key), 8))
+		workers[worker] = true
+	}
+	assert.Greater(t, len(workers), 1, "rows are spread over the workers")
+
+	// Events without a document key and schema changes are ordered by table
+	table := partition(events.RecordEvent{Action: events.InsertAction, Schema: "shop", Collection: "orders"}, 8)
+	assert.Equal(t, table, partition(events.RecordEvent{Action: "alter", Schema: "shop", Collection: "orders", DocumentKey: []byte(`{"_id":1}`)}, 8))
+	assert.Equal(t, table, partition(events.RecordEvent{Action: events.DeleteAction, Schema: "shop", Collection: "orders", DocumentKey: []byte(`{}`)}, 8))
+}
+
+func TestPositionAcks(t *testing.T) {
+	acks := NewPositionAcks(0)
+	var committed []map[string]interface{}
+	acks.OnAcknowledged(func(position map[string]interface{}) {
+		committed = append(committed, position)
+	})
+	first := acks.Track(map[string]interface{}{"offset": 1})
+	second := acks.Track(nil)
+	third := acks.Track(map[string]interface{}{"offset": 3})
+	assert.Equal(t, 3, acks.Pending())
+
+	assert.False(t, acks.Ack(third), "earlier events are not applied yet")
+	assert.Nil(t, acks.Position())
+
+	assert.True(t, acks.Ack(first))
+	assert.Equal(t, map[string]interface{}{"offset": 1}, acks.Position())
+	assert.Equal(t, 2, acks.Pending())
+
+	assert.True(t, acks.Ack(second), "the applied third event is acknowledged with the second")
+	assert.Equal(t, map[string]interface{}{"offset": 3}, acks.Position())
+	assert.Equal(t, 0, acks.Pending())
+	assert.Equal(t, []map[string]interface{}{{"offset": 1}, {"offset": 3}}, committed)
+}
+
+func TestApplyStageKeepsRowOrder(t *testing.T) {
+	const keys, perKey = 10, 20
+	in := make(chan events.RecordEvent, keys*perKey)
+	var mu sync.Mutex
+	applied := make(map[string][]int)
+	stage := NewApplyStage("orders", BatchConfig{Size: 3, Bytes: DefaultBatchBytes}, 4, in, func(ctx context.Context, batch []events.RecordEvent) bool {
+		time.Sleep(time.Duration(rand.Intn(500)) * time.Microsecond)
+		mu.Lock()
+		defer mu.Unlock()
+		for _, event := range batch {
+			key := string(event.DocumentKey)
+			applied[key] = append(applied[key], event.Position["offset"].(int))
+		}
+		return true
+	})
+	require.Equal(t, 4, stage.Workers())
+
+	offset := 0
+	for i := 0; i < perKey; i++ {
+		for key := 0; key < keys; key++ {
+			event := rowEvent("orders", key)
+			event.Position = map[string]interface{}{"offset": offset}
+			in <- event
+			offset++
+		}
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		stage.Run(context.Background(), stop)
+	}()
+	assert.Eventually(t, func() bool { return stage.Acks().Pending() == 0 && len(in) == 0 }, 5*time.Second, 5*time.Millisecond)
+	close(stop)
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, applied, keys)
+	for key, offsets := range applied {
+		require.Len(t, offsets, perKey, key)
+		assert.IsIncreasing(t, offsets, "changes of %s applied out of order", key)
+	}
+	assert.Equal(t, map[string]interface{}{"offset": offset - 1}, stage.Acks().Position())
+}
+
+func TestApplyStageAppliesTransactions(t *testing.T) {
+	in := make(chan events.RecordEvent, 10)
+	var mu sync.Mutex
+	var applied [][]events.RecordEvent
+	stage := NewApplyStage("orders", BatchConfig{Size: 2, Bytes: DefaultBatchBytes, MaxTransactionEvents: 100}, 4, in, func(ctx context.Context, batch []events.RecordEvent) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		applied = append(applied, batch)
+		return true
+	})
+	require.Equal(t, 1, stage.Workers(), "transactions are applied in commit order")
+
+	for key := 0; key < 3; key++ {
+		event := rowEvent("orders", key)
+		event.Transaction = "tx1"
+		in <- event
+	}
+	in <- events.RecordEvent{Action: events.CommitAction, Transaction: "tx1", Position: map[string]interface{}{"lsn": "0/16B3748"}}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		stage.Run(context.Background(), stop)
+	}()
+	assert.Eventually(t, func() bool { return stage.Acks().Pending() == 0 && len(in) == 0 }, 5*time.Second, 5*time.Millisecond)
+	close(stop)
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, applied, 1)
+	assert.Len(t, applied[0], 3, "the commit is acknowledged, not applied")
+	assert.Equal(t, map[string]interface{}{"lsn": "0/16B3748"}, stage.Acks().Position())
+}
+
+func TestApplyStageHoldsFailedPositions(t *testing.T) {
+	in := make(chan events.RecordEvent, 10)
+	stage := NewApplyStage("orders", BatchConfig{Size: 1, Bytes: DefaultBatchBytes, Buffer: 10}, 1, in, func(ctx context.Context, batch []events.RecordEvent) bool {
+		return batch[0].Position["offset"] != 1
+	})
+	for offset := 0; offset < 3; offset++ {
+		event := rowEvent("orders", offset)
+		event.Position = map[string]interface{}{"offset": offset}
+		in <- event
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		stage.Run(context.Background(), stop)
+	}()
+	assert.Eventually(t, func() bool { return stage.Acks().Pending() == 2 && len(in) == 0 }, 5*time.Second, 5*time.Millisecond)
+	close(stop)
+	<-done
+
+	assert.Equal(t, map[string]interface{}{"offset": 0}, stage.Acks().Position(), "the position stops before the failed event")
+}
+
+func TestApplyStageStopsReadingBehindFailedBatch(t *testing.T) {
+	in := make(chan events.RecordEvent, 100)
+	stage := NewApplyStage("orders", BatchConfig{Size: 1, Bytes: DefaultBatchBytes, Buffer: 4}, 1, in, func(ctx context.Context, batch []events.RecordEvent) bool {
+		return batch[0].Position["offset"] != 0
+	})
+	for offset := 0; offset < 50; offset++ {
+		event := rowEvent("orders", offset)
+		event.Position = map[string]interface{}{"offset": offset}
+		in <- event
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		stage.Run(context.Background(), stop)
+	}()
+	assert.Eventually(t, stage.Acks().Full, 5*time.Second, 5*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 5, stage.Acks().Pending(), "the events pending stay within the window")
+	assert.Equal(t, 45, len(in), "events are left in the stream channel")
+	assert.Nil(t, stage.Acks().Position())
+
+	close(stop)
+	<-done
+}
+
+func TestApplyStageOrdersBarriers(t *testing.T) {
+	in := make(chan events.RecordEvent, 100)
+	var mu sync.Mutex
+	var applied []int
+	stage := NewApplyStage("orders", BatchConfig{Size: 3, Bytes: DefaultBatchBytes}, 4, in, func(ctx context.Context, batch []events.RecordEvent) bool {
+		time.Sleep(time.Duration(rand.Intn(500)) * time.Microsecond)
+		mu.Lock()
+		defer mu.Unlock()
+		for _, event := range batch {
+			applied = append(applied, event.Position["offset"].(int))
+		}
+		return true
+	})
+
+	// Rows of the table, a schema change and a row without a key, more rows
+	offset := 0
+	send := func(event events.RecordEvent) {
+		event.Position = map[string]interface{}{"offset": offset}
+		in <- event
+		offset++
+	}
+	for key := 0; key < 20; key++ {
+		send(rowEvent("orders", key))
+	}
+	send(events.RecordEvent{Action: "alter", Schema: "shop", Collection: "orders"})
+	send(events.RecordEvent{Action: events.InsertAction, Schema: "shop", Collection: "orders"})
+	for key := 0; key < 20; key++ {
+		send(rowEvent("orders", key))
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		stage.Run(context.Background(), stop)
+	}()
+	assert.Eventually(t, func() bool { return stage.Acks().Pending() == 0 && len(in) == 0 }, 5*time.Second, 5*time.Millisecond)
+	close(stop)
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, applied, offset)
+	position := make(map[int]int, len(applied))
+	for i, applied := range applied {
+		position[applied] = i
+	}
+	for key := 0; key < 20; key++ {
+		assert.Less(t, position[key], position[20], "rows read before the schema change are applied before it")
+		assert.Greater(t, position[22+key], position[21], "rows read after the row without a key are applied after it")
+	}
+	assert.Less(t, position[20], position[21])
+}
+
+func TestApplyStageDrainsOnStop(t *testing.T) {
+	in := make(chan events.RecordEvent, 100)
+	var mu sync.Mutex
+	applied := 0
+	stage := NewApplyStage("orders", BatchConfig{Size: 1, Bytes: DefaultBatchBytes}, 2, in, func(ctx context.Context, batch []events.RecordEvent) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		applied += len(batch)
+		return true
+	})
+	for key := 0; key < 50; key++ {
+		in <- rowEvent("orders", key)
+	}
+
+	stop := make(chan struct{})
+	close(stop)
+	stage.Run(context.Background(), stop)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 50, applied, "events buffered on stop are applied, not dropped")
+}
@@ -0,0 +1,272 @@
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cohenjo/replicator/pkg/config"
+	"github.com/cohenjo/replicator/pkg/events"
+	"github.com/rs/zerolog/log"
+)
+
+// Micro-batching defaults of a stream
+const (
+	DefaultBatchSize   = 100
+	DefaultBatchBytes  = 1 << 20 // 1 MiB
+	DefaultBatchLinger = 50 * time.Millisecond
+	DefaultBufferSize  = 10000
+
+	// DefaultMaxTransactionEvents is the size above which source
+	// transactions are split
+	DefaultMaxTransactionEvents = 10000
+
+	// drainTimeout bounds the writes of the events still buffered when a
+	// batcher stops
+	drainTimeout = 5 * time.Second
+)
+
+// BatchConfig is the micro-batching of a stream between its source and target
+type BatchConfig struct {
+	Size   int           // Events per batch
+	Bytes  int           // Payload bytes per batch
+	Linger time.Duration // How long the first event of a batch waits for it to fill, 0 to not wait
+	Buffer int           // Events buffered between the source and the batcher
+
+	// MaxTransactionEvents is the number of events of a source transaction
+	// written in one batch, larger transactions are split. 0 when the events
+	// are not grouped by transaction.
+	MaxTransactionEvents int
+}
+
+// batchConfigForStream returns the micro-batching of a stream, with the
+// defaults for the settings it leaves out
+func batchConfigForStream(stream config.StreamConfig) (BatchConfig, error) {
+	batch := BatchConfig{
+		Size:   DefaultBatchSize,
+		Bytes:  DefaultBatchBytes,
+		Linger: DefaultBatchLinger,
+		Buffer: DefaultBufferSize,
+	}
+	if stream.BatchSize > 0 {
+		batch.Size = stream.BatchSize
+	}
+	if stream.BatchBytes > 0 {
+		batch.Bytes = stream.BatchBytes
+	}
+	if stream.BatchLinger != "" {
+		linger, err := time.ParseDuration(stream.BatchLinger)
+		if err != nil {
+			return batch, fmt.Errorf("invalid batch linger: %w", err)
+		}
+		batch.Linger = linger
+	}
+	if stream.BufferSize > 0 {
+		batch.Buffer = stream.BufferSize
+	}
+	if transactionsEnabled(stream) {
+		batch.MaxTransactionEvents = DefaultMaxTransactionEvents
+		if stream.Transactions.MaxEvents > 0 {
+			batch.MaxTransactionEvents = stream.Transactions.MaxEvents
+		}
+	}
+	return batch, nil
+}
+
+// transactionsEnabled reports whether the events of a stream are grouped by
+// source transaction
+func transactionsEnabled(stream config.StreamConfig) bool {
+	return stream.Transactions != nil && stream.Transactions.Enabled
+}
+
+// eventSize returns the number of payload bytes of an event
+func eventSize(event events.RecordEvent) int {
+	return len(event.Data) + len(event.OldData) + len(event.DocumentKey)
+}
+
+// SequencedEvent is an event with its sequence number in its stream
+type SequencedEvent struct {
+	Seq   uint64
+	Event events.RecordEvent
+}
+
+// FlushFunc writes a batch of events of a stream
+type FlushFunc func(ctx context.Context, batch []SequencedEvent)
+
+// EventBatcher collects the events of a stream into micro-batches. A batch is
+// flushed when it reaches the batch size or byte size, when its first event
+// has waited for the linger time, or at once when no more events are
+// buffered and the linger time is 0.
+//
+// When the events are grouped by source transaction a batch is only flushed
+// at a transaction boundary, once the commit of its last transaction is read,
+// so each transaction is written with a single batch. A transaction with more
+// than MaxTransactionEvents events is split over several batches.
+type EventBatcher struct {
+	config   BatchConfig
+	events   <-chan SequencedEvent
+	flush    FlushFunc
+	batch    []SequencedEvent
+	bytes    int
+	lingered bool // the linger time ran out while a transaction was open
+
+	tx       string // open transaction, "" at a transaction boundary
+	txStart  int    // index of the first event of the open transaction in the batch
+	txEvents int    // events of the open transaction in the batch
+}
+
+// NewEventBatcher creates a batcher reading the events of a stream from the
+// channel and passing its batches to flush
+func NewEventBatcher(batchConfig BatchConfig, in <-chan SequencedEvent, flush FlushFunc) *EventBatcher {
+	return &EventBatcher{
+		config: batchConfig,
+		events: in,
+		flush:  flush,
+	}
+}
+
+// Config returns the micro-batching of the batcher
+func (b *EventBatcher) Config() BatchConfig {
+	return b.config
+}
+
+// Run batches events until the context is done or stop is closed. The write
+// in progress when stop is closed is cancelled; the events still pending are
+// then flushed with drainTimeout to be written.
+func (b *EventBatcher) Run(ctx context.Context, stop <-chan struct{}) {
+	writeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-writeCtx.Done():
+		}
+	}()
+
+	linger := time.NewTimer(b.config.Linger)
+	linger.Stop()
+	defer linger.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stop:
+			b.drain(ctx)
+			return
+		case event := <-b.events:
+			first := len(b.batch) == 0
+			b.add(event)
+			b.fill()
+			switch {
+			case b.splitDue() || b.atBoundary() && (b.full() || b.config.Linger <= 0 || b.lingered):
+				linger.Stop()
+				b.flushBatch(writeCtx)
+			case first:
+				linger.Reset(b.config.Linger)
+			}
+		case <-linger.C:
+			if b.atBoundary() {
+				b.flushBatch(writeCtx)
+			} else {
+				b.lingered = true
+			}
+		}
+	}
+}
+
+// drain flushes the pending events and the events still buffered in the
+// channel, giving up on the rest when drainTimeout runs out. The events of a
+// transaction whose commit was not read are not written.
+func (b *EventBatcher) drain(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), drainTimeout)
+	defer cancel()
+	for ctx.Err() == nil {
+		select {
+		case event := <-b.events:
+			b.add(event)
+			if b.splitDue() || b.full() && b.atBoundary() {
+				b.flushBatch(ctx)
+			}
+		default:
+			if b.tx != "" {
+				log.Warn().Str("transaction", b.tx).Int("events", len(b.batch)-b.txStart).Msg("Not writing the events of an uncommitted transaction")
+				b.batch = b.batch[:b.txStart]
+				b.tx = ""
+			}
+			b.flushBatch(ctx)
+			return
+		}
+	}
+}
+
+// fill adds the events already buffered in the channel, up to a full batch
+// that ends at a transaction boundary or a transaction to split
+func (b *EventBatcher) fill() {
+	for !b.splitDue() && (!b.full() || !b.atBoundary()) {
+		select {
+		case event := <-b.events:
+			b.add(event)
+		default:
+			return
+		}
+	}
+}
+
+func (b *EventBatcher) add(event SequencedEvent) {
+	b.batch = append(b.batch, event)
+	b.bytes += eventSize(event.Event)
+	if b.config.MaxTransactionEvents <= 0 {
+		return
+	}
+
+	switch {
+	case event.Event.Action == events.CommitAction:
+		b.tx = ""
+		b.txEvents = 0
+	case event.Event.Transaction == "":
+		// Events outside of transactions end the open one, whose commit was lost
+		b.tx = ""
+		b.txEvents = 0
+	default:
+		if event.Event.Transaction != b.tx {
+			b.tx = event.Event.Transaction
+			b.txStart = len(b.batch) - 1
+			b.txEvents = 0
+		}
+		b.txEvents++
+	}
+}
+
+func (b *EventBatcher) full() bool {
+	return len(b.batch) >= b.config.Size || b.bytes >= b.config.Bytes
+}
+
+// atBoundary reports whether the batch can be flushed without breaking a
+// transaction
+func (b *EventBatcher) atBoundary() bool {
+	return b.tx == ""
+}
+
+// splitDue reports whether the open transaction reached the size above which
+// it is split
+func (b *EventBatcher) splitDue() bool {
+	return b.tx != "" && b.txEvents >= b.config.MaxTransactionEvents
+}
+
+func (b *EventBatcher) flushBatch(ctx context.Context) {
+	if len(b.batch) == 0 {
+		return
+	}
+	if b.tx != "" {
+		log.Warn().Str("transaction", b.tx).Int("events", b.txEvents).Msg("Splitting transaction above the maximum transaction size")
+	}
+	batch := b.batch
+	b.batch = make([]SequencedEvent, 0, b.config.Size)
+	b.bytes = 0
+	b.lingered = false
+	b.txStart = 0
+	b.txEvents = 0
+	b.flush(ctx, batch)
+}
@@ -0,0 +1,311 @@
+package replicator
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cohenjo/replicator/pkg/config"
+	"github.com/cohenjo/replicator/pkg/dlq"
+	"github.com/cohenjo/replicator/pkg/estuary"
+	"github.com/cohenjo/replicator/pkg/events"
+	"github.com/cohenjo/replicator/pkg/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchConfigForStream(t *testing.T) {
+	batch, err := batchConfigForStream(config.StreamConfig{})
+	require.NoError(t, err)
+	assert.Equal(t, BatchConfig{Size: DefaultBatchSize, Bytes: DefaultBatchBytes, Linger: DefaultBatchLinger, Buffer: DefaultBufferSize}, batch)
+
+	batch, err = batchConfigForStream(config.StreamConfig{BatchSize: 500, BatchBytes: 4096, BatchLinger: "0s", BufferSize: 20})
+	require.NoError(t, err)
+	assert.Equal(t, BatchConfig{Size: 500, Bytes: 4096, Linger: 0, Buffer: 20}, batch)
+
+	_, err = batchConfigForStream(config.StreamConfig{BatchLinger: "soon"})
+	assert.Error(t, err)
+
+	batch, err = batchConfigForStream(config.StreamConfig{Transactions: &config.TransactionConfig{Enabled: true}})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultMaxTransactionEvents, batch.MaxTransactionEvents)
+	batch, err = batchConfigForStream(config.StreamConfig{Transactions: &config.TransactionConfig{Enabled: true, MaxEvents: 50}})
+	require.NoError(t, err)
+	assert.Equal(t, 50, batch.MaxTransactionEvents)
+	batch, err = batchConfigForStream(config.StreamConfig{Transactions: &config.TransactionConfig{MaxEvents: 50}})
+	require.NoError(t, err)
+	assert.Equal(t, 0, batch.MaxTransactionEvents, "transactions are not grouped unless enabled")
+}
+
+// runBatcher runs a batcher over the events and returns its batches as they
+// are flushed, and a function that stops it
+func runBatcher(batchConfig BatchConfig, in chan SequencedEvent) (<-chan []SequencedEvent, func()) {
+	batches := make(chan []SequencedEvent, 16)
+	batcher := NewEventBatcher(batchConfig, in, func(ctx context.Context, batch []SequencedEvent) {
+		batches <- batch
+	})
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		batcher.Run(context.Background(), stop)
+	}()
+	return batches, func() {
+		close(stop)
+		<-done
+	}
+}
+
+func nextBatch(t *testing.T, batches <-chan []SequencedEvent) []SequencedEvent {
+	t.Helper()
+	select {
+	case batch := <-batches:
+		return batch
+	case <-time.After(time.Second):
+		require.FailNow(t, "no batch flushed")
+		return nil
+	}
+}
+
+func TestEventBatcherFlushesOnSize(t *testing.T) {
+	in := make(chan SequencedEvent, 10)
+	for i := 0; i < 5; i++ {
+		in <- SequencedEvent{Event: events.RecordEvent{Action: "insert"}}
+	}
+	batches, stop := runBatcher(BatchConfig{Size: 2, Bytes: DefaultBatchBytes, Linger: time.Hour}, in)
+
+	assert.Len(t, nextBatch(t, batches), 2)
+	assert.Len(t, nextBatch(t, batches), 2)
+	select {
+	case batch := <-batches:
+		assert.Failf(t, "partial batch flushed before its linger time", "%d events", len(batch))
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// The pending event is flushed on stop
+	stop()
+	assert.Len(t, nextBatch(t, batches), 1)
+}
+
+func TestEventBatcherFlushesOnBytes(t *testing.T) {
+	in := make(chan SequencedEvent, 10)
+	for i := 0; i < 3; i++ {
+		in <- SequencedEvent{Event: events.RecordEvent{Action: "insert", Data: []byte(`{"id":1}`)}}
+	}
+	batches, stop := runBatcher(BatchConfig{Size: 100, Bytes: 16, Linger: time.Hour}, in)
+	defer stop()
+
+	assert.Len(t, nextBatch(t, batches), 2)
+}
+
+func TestEventBatcherFlushesOnLinger(t *testing.T) {
+	in := make(chan SequencedEvent, 10)
+	batches, stop := runBatcher(BatchConfig{Size: 100, Bytes: DefaultBatchBytes, Linger: 10 * time.Millisecond}, in)
+	defer stop()
+
+	in <- SequencedEvent{Event: events.RecordEvent{Action: "insert"}}
+	assert.Len(t, nextBatch(t, batches), 1)
+
+	// Without a linger time the buffered events are flushed at once
+	in2 := make(chan SequencedEvent, 10)
+	batches2, stop2 := runBatcher(BatchConfig{Size: 100, Bytes: DefaultBatchBytes}, in2)
+	defer stop2()
+	in2 <- SequencedEvent{Event: events.RecordEvent{Action: "insert"}}
+	assert.Len(t, nextBatch(t, batches2), 1)
+}
+
+// sendTransaction sends the rows of a source transaction and, when commit is
+// set, its commit event
+func sendTransaction(in chan<- SequencedEvent, id string, rows int, commit bool) {
+	for i := 0; i < rows; i++ {
+		in <- SequencedEvent{Event: events.RecordEvent{Action: "insert", Transaction: id}}
+	}
+	if commit {
+		in <- SequencedEvent{Event: events.RecordEvent{Action: events.CommitAction, Transaction: id}}
+	}
+}
+
+func TestEventBatcherFlushesWholeTransactions(t *testing.T) {
+	in := make(chan SequencedEvent, 20)
+	sendTransaction(in, "tx1", 3, true)
+	in <- SequencedEvent{Event: events.RecordEvent{Action: "insert"}}
+	batches, stop := runBatcher(BatchConfig{Size: 2, Bytes: DefaultBatchBytes, MaxTransactionEvents: 100}, in)
+	defer stop()
+
+	batch := nextBatch(t, batches)
+	require.Len(t, batch, 4, "the transaction is not split at the batch size")
+	assert.Equal(t, events.CommitAction, batch[3].Event.Action)
+	assert.Len(t, nextBatch(t, batches), 1)
+
+	// An open transaction waits for its commit, even without a linger time
+	sendTransaction(in, "tx2", 2, false)
+	select {
+	case batch := <-batches:
+		assert.Failf(t, "uncommitted transaction flushed", "%d events", len(batch))
+	case <-time.After(20 * time.Millisecond):
+	}
+	in <- SequencedEvent{Event: events.RecordEvent{Action: events.CommitAction, Transaction: "tx2"}}
+	assert.Len(t, nextBatch(t, batches), 3)
+}
+
+func TestEventBatcherSplitsLargeTransactions(t *testing.T) {
+	in := make(chan SequencedEvent, 20)
+	sendTransaction(in, "tx1", 5, true)
+	batches, stop := runBatcher(BatchConfig{Size: 100, Bytes: DefaultBatchBytes, MaxTransactionEvents: 2}, in)
+	defer stop()
+
+	assert.Len(t, nextBatch(t, batches), 2)
+	assert.Len(t, nextBatch(t, batches), 2)
+	assert.Len(t, nextBatch(t, batches), 2, "the last row with the commit")
+}
+
+func TestEventBatcherDoesNotDrainUncommittedTransactions(t *testing.T) {
+	in := make(chan SequencedEvent, 20)
+	in <- SequencedEvent{Event: events.RecordEvent{Action: "insert"}}
+	sendTransaction(in, "tx1", 2, true)
+	sendTransaction(in, "tx2", 2, false)
+	batches, stop := runBatcher(BatchConfig{Size: 100, Bytes: DefaultBatchBytes, Linger: time.Hour, MaxTransactionEvents: 100}, in)
+
+	stop()
+	batch := nextBatch(t, batches)
+	require.Len(t, batch, 4, "the events before the open transaction are written")
+	assert.Equal(t, events.CommitAction, batch[3].Event.Action)
+}
+
+// batchRecorder records the batches it writes and rejects the events of the
+// rejected collection
+type batchRecorder struct {
+	rejected string
+	batches  int
+	written  []string
+}
+
+func (w *batchRecorder) write(event map[string]interface{}) error {
+	if event["collection"] == w.rejected {
+		return estuary.NewRecordError("test", w.rejected, "rejected", nil)
+	}
+	w.written = append(w.written, event["collection"].(string))
+	return nil
+}
+
+func (w *batchRecorder) WriteEvent(ctx context.Context, event map[string]interface{}) error {
+	return w.write(event)
+}
+
+func (w *batchRecorder) WriteBatch(ctx context.Context, batch []map[string]interface{}) error {
+	w.batches++
+	for _, event := range batch {
+		if event["collection"] == w.rejected {
+			return estuary.NewRecordError("test", w.rejected, "rejected", nil)
+		}
+	}
+	for _, event := range batch {
+		if err := w.write(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *batchRecorder) Close() error {
+	return nil
+}
+
+// atomicBatchRecorder applies its batches atomically
+type atomicBatchRecorder struct {
+	batchRecorder
+}
+
+func (w *atomicBatchRecorder) Atomic() bool {
+	return true
+}
+
+func newBatchTestService(writer EstuaryWriter, deadLetters dlq.Queue) *Service {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	rw, _ := newTestWriter(writer, models.RetryPolicy{MaxRetries: 1}, nil)
+	return &Service{
+		logger:      logger,
+		deadLetters: deadLetters,
+		estuaries:   map[string]*ResilientWriter{"orders": rw},
+	}
+}
+
+func testBatch(collections ...string) []events.RecordEvent {
+	batch := make([]events.RecordEvent, 0, len(collections))
+	for _, collection := range collections {
+		batch = append(batch, events.RecordEvent{Action: "insert", Collection: collection, Data: []byte(`{"id":1}`), Stream: "orders"})
+	}
+	return batch
+}
+
+func TestApplyBatchWritesOneBatch(t *testing.T) {
+	writer := &batchRecorder{}
+	service := newBatchTestService(writer, nil)
+
+	failed, err := service.applyBatch(context.Background(), "orders", testBatch("a", "b", "c"), false)
+	require.NoError(t, err)
+	assert.Equal(t, 0, failed)
+	assert.Equal(t, 1, writer.batches)
+	assert.Equal(t, []string{"a", "b", "c"}, writer.written)
+
+	// Streams without an estuary drop their events
+	failed, err = service.applyBatch(context.Background(), "users", testBatch("d"), false)
+	require.NoError(t, err)
+	assert.Equal(t, 0, failed)
+}
+
+func TestApplyBatchSplitsRejectedBatch(t *testing.T) {
+	ctx := context.Background()
+	writer := &batchRecorder{rejected: "bad"}
+	service := newBatchTestService(writer, nil)
+
+	failed, err := service.applyBatch(ctx, "orders", testBatch("a", "bad", "c"), false)
+	require.Error(t, err)
+	assert.Equal(t, 1, failed, "only the rejected event fails")
+	assert.Equal(t, []string{"a", "c"}, writer.written)
+
+	// With the dead-letter queue the rejected event is dead-lettered
+	queue, err := dlq.NewFileQueue(filepath.Join(t.TempDir(), "dlq.jsonl"))
+	require.NoError(t, err)
+	writer = &batchRecorder{rejected: "bad"}
+	service = newBatchTestService(writer, queue)
+
+	failed, err = service.applyBatch(ctx, "orders", testBatch("a", "bad", "c"), true)
+	require.NoError(t, err)
+	assert.Equal(t, 0, failed)
+	assert.Equal(t, []string{"a", "c"}, writer.written)
+	entries, err := queue.List(ctx, dlq.Filter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "bad", entries[0].Event.Collection)
+	assert.Equal(t, dlq.StageWrite, entries[0].Stage)
+}
+
+func TestApplyBatchFailsAtomicBatchAsWhole(t *testing.T) {
+	ctx := context.Background()
+	queue, err := dlq.NewFileQueue(filepath.Join(t.TempDir(), "dlq.jsonl"))
+	require.NoError(t, err)
+	writer := &atomicBatchRecorder{batchRecorder{rejected: "bad"}}
+	service := newBatchTestService(writer, queue)
+
+	failed, err := service.applyBatch(ctx, "orders", testBatch("a", "bad", "c"), true)
+	require.NoError(t, err)
+	assert.Equal(t, 0, failed)
+	assert.Empty(t, writer.written, "the batch is not split")
+	entries, err := queue.List(ctx, dlq.Filter{})
+	require.NoError(t, err)
+	assert.Len(t, entries, 3, "the whole transaction is dead-lettered")
+}
+
+func TestApplyBatchFailsWholeBatchAfterRetries(t *testing.T) {
+	writer := &scriptedWriter{errs: []error{connectionError(), connectionError()}}
+	service := newBatchTestService(writer, nil)
+
+	failed, err := service.applyBatch(context.Background(), "orders", testBatch("a", "b"), false)
+	require.Error(t, err)
+	assert.Equal(t, 2, failed)
+	assert.Equal(t, 2, writer.writes, "the batch is retried, not split")
+}
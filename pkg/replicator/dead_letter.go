@@ -0,0 +1,119 @@
+package replicator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/cohenjo/replicator/pkg/dlq"
+	"github.com/cohenjo/replicator/pkg/events"
+	"github.com/cohenjo/replicator/pkg/transform"
+	"github.com/sirupsen/logrus"
+)
+
+// DeadLetters returns the dead-letter queue, nil when it is disabled
+func (s *Service) DeadLetters() dlq.Queue {
+	return s.deadLetters
+}
+
+// PrepareReplay creates the estuary writers of a service that is not
+// started, so dead-letter entries can be replayed without running the streams
+func (s *Service) PrepareReplay(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status == StatusRunning || len(s.estuaries) > 0 {
+		return nil
+	}
+	return s.initializeStreams(ctx)
+}
+
+// ReplayDeadLetter replays an entry through the stage it failed in: entries
+// that failed to transform are processed again from the source event, entries
+// that failed to be written are written again to the estuary of their stream.
+// Entries of a route are replayed to the route alone. A replay that fails
+// again returns the error and is not dead-lettered.
+func (s *Service) ReplayDeadLetter(ctx context.Context, entry *dlq.Entry) error {
+	switch entry.Stage {
+	case dlq.StageTransform:
+		if entry.Event == nil {
+			return fmt.Errorf("dead-letter entry %s has no event", entry.ID)
+		}
+		if route := s.route(entry.Stream); route != nil {
+			return s.replayRoute(ctx, route, *entry.Event)
+		}
+		return s.applyEvent(ctx, *entry.Event, false)
+	case dlq.StageWrite:
+		writer, ok := s.estuaries[entry.Stream]
+		if !ok {
+			return fmt.Errorf("no estuary for stream %s", entry.Stream)
+		}
+		return writer.WriteEvent(ctx, entry.Payload)
+	default:
+		return fmt.Errorf("unknown dead-letter stage: %s", entry.Stage)
+	}
+}
+
+// replayRoute transforms an event with the rules of its stream and writes it
+// to the target of a route
+func (s *Service) replayRoute(ctx context.Context, route *Route, event events.RecordEvent) error {
+	payloads, err := s.transformEvent(ctx, event, false)
+	if err != nil {
+		return err
+	}
+	batch := make([]events.RecordEvent, len(payloads))
+	for i := range batch {
+		batch[i] = event
+	}
+	_, err = s.applyRoute(ctx, route, batch, payloads, false)
+	return err
+}
+
+// deadLetterTransform sends an event that a dead_letter rule of a stream or
+// route failed on to the dead-letter queue
+func (s *Service) deadLetterTransform(ctx context.Context, stream string, event events.RecordEvent, result *transform.TransformationResult, deadLetter bool) error {
+	entry := dlq.NewEntry(stream, dlq.StageTransform, transform.ErrDeadLetter, 1, &event, nil)
+	if len(result.Errors) > 0 {
+		failed := result.Errors[len(result.Errors)-1]
+		entry.Rule = failed.Rule
+		entry.Error = failed.Message
+	}
+	if !deadLetter || s.deadLetters == nil {
+		return fmt.Errorf("rule %s failed: %s", entry.Rule, entry.Error)
+	}
+	return s.putDeadLetter(ctx, entry)
+}
+
+// deadLetterWrite sends an event that failed to be written to the
+// dead-letter queue. Writes cancelled by shutdown are not dead-lettered.
+func (s *Service) deadLetterWrite(ctx context.Context, stream string, event events.RecordEvent, payload map[string]interface{}, cause error) error {
+	if s.deadLetters == nil {
+		return cause
+	}
+	if errors.Is(cause, context.Canceled) || ctx.Err() != nil {
+		return cause
+	}
+	attempts := 1
+	var failure *WriteFailure
+	if errors.As(cause, &failure) {
+		attempts = failure.Attempts
+	}
+	return s.putDeadLetter(ctx, dlq.NewEntry(stream, dlq.StageWrite, cause, attempts, &event, payload))
+}
+
+func (s *Service) putDeadLetter(ctx context.Context, entry *dlq.Entry) error {
+	if err := s.deadLetters.Put(ctx, entry); err != nil {
+		s.logger.WithError(err).WithField("stream", entry.Stream).Error("Failed to send event to the dead-letter queue")
+		return fmt.Errorf("failed to send event to the dead-letter queue: %w", err)
+	}
+	s.logger.WithFields(logrus.Fields{
+		"stream":   entry.Stream,
+		"stage":    entry.Stage,
+		"id":       entry.ID,
+		"attempts": entry.Attempts,
+		"error":    entry.Error,
+	}).Warn("Event sent to the dead-letter queue")
+	if s.metricsCollector != nil {
+		s.metricsCollector.IncrementCounter("events_dead_lettered_total", 1)
+	}
+	return nil
+}
@@ -5,102 +5,41 @@ import (
 "encoding/json"
 "fmt"
 
-"github.com/cohenjo/replicator/pkg/config"
 "github.com/cohenjo/replicator/pkg/estuary"
 "github.com/cohenjo/replicator/pkg/events"
 "github.com/rs/zerolog/log"
 )
 
-// EstuaryBridge adapts the legacy Endpoint interface to the new EstuaryWriter interface
+// EstuaryBridge adapts a DatabaseDestination to the EstuaryWriter interface
 type EstuaryBridge struct {
-	endpoint estuary.Endpoint
-	name     string
+	destination  estuary.DatabaseDestination
+	name         string
+	transactions bool // batches are written in destination transactions
 }
 
-// NewEstuaryBridge creates a new bridge for the given target configuration
-func NewEstuaryBridge(targetConfig config.TargetConfig) (*EstuaryBridge, error) {
-	// Convert new config format to legacy WaterFlowsConfig format
-	legacyConfig := &config.WaterFlowsConfig{
-		Type:       string(targetConfig.Type), // Convert TargetType to string
-		Host:       targetConfig.Host,
-		Port:       targetConfig.Port,
-		Collection: targetConfig.Database, // Use database as collection/index name
-		Schema:     targetConfig.Database, // MongoDB needs schema field for database name
-	}
-
-	// For MongoDB, handle URI and authentication configuration
-	if targetConfig.Type == config.TargetTypeMongoDB {
-		// Use URI if provided, otherwise construct from host/port
-		if targetConfig.URI != "" {
-			legacyConfig.MongoURI = targetConfig.URI
-		} else if targetConfig.Host != "" && targetConfig.Port > 0 {
-			legacyConfig.MongoURI = fmt.Sprintf("mongodb://%s:%s@%s:%d/admin?authSource=admin&directConnection=true",
-				targetConfig.Username,
-				targetConfig.Password,
-				targetConfig.Host,
-				targetConfig.Port,
-			)
-		} else {
-			return nil, fmt.Errorf("MongoDB target requires either URI or host/port configuration")
-		}
-		
-		legacyConfig.MongoDatabaseName = targetConfig.Database
-		
-		// Pass through authentication method and related options
-		if targetConfig.Options != nil {
-			if authMethod, ok := targetConfig.Options["auth_method"].(string); ok {
-				legacyConfig.MongoAuthMethod = authMethod
-			}
-			if tenantID, ok := targetConfig.Options["tenant_id"].(string); ok {
-				legacyConfig.MongoTenantID = tenantID
-			}
-			if clientID, ok := targetConfig.Options["client_id"].(string); ok {
-				legacyConfig.MongoClientID = clientID
-			}
-			if scopes, ok := targetConfig.Options["scopes"].([]interface{}); ok {
-				scopeStrings := make([]string, len(scopes))
-				for i, scope := range scopes {
-					if scopeStr, ok := scope.(string); ok {
-						scopeStrings[i] = scopeStr
-					}
-				}
-				legacyConfig.MongoScopes = scopeStrings
-			}
-			if refreshBefore, ok := targetConfig.Options["refresh_before_expiry"].(string); ok {
-				legacyConfig.MongoRefreshBeforeExpiry = refreshBefore
-			}
-		}
-	}
+// eventWriter is implemented by destinations that write record events directly
+type eventWriter interface {
+	WriteEvents(ctx context.Context, records []*events.RecordEvent, size int) error
+}
 
-	// Override collection from options if specified
-	if targetConfig.Options != nil {
-		if collection, ok := targetConfig.Options["collection"].(string); ok && collection != "" {
-			legacyConfig.Collection = collection
-			if targetConfig.Type == config.TargetTypeMongoDB {
-				legacyConfig.MongoCollectionName = collection
-			}
-		}
+// NewEstuaryBridge creates a new bridge writing to the given destination
+func NewEstuaryBridge(name string, destination estuary.DatabaseDestination) *EstuaryBridge {
+	return &EstuaryBridge{
+		destination: destination,
+		name:        name,
 	}
+}
 
-	// Create the appropriate endpoint based on type
-	var endpoint estuary.Endpoint
-	switch targetConfig.Type {
-	case config.TargetTypeElastic:
-		endpoint = estuary.NewElasticEndpoint(legacyConfig)
-	case config.TargetTypeMySQL:
-		endpoint = estuary.NewMySQLEndpoint(legacyConfig)
-	case config.TargetTypeMongoDB:
-		endpoint = estuary.NewMongoEndpoint(legacyConfig)
-	case config.TargetTypeKafka:
-		endpoint = estuary.NewKafkaEndpoint(legacyConfig)
-	default:
-		return nil, fmt.Errorf("unsupported target type: %s", targetConfig.Type)
-	}
+// WithTransactions makes the bridge write each batch in a destination
+// transaction, so the batch is applied atomically
+func (eb *EstuaryBridge) WithTransactions() *EstuaryBridge {
+	eb.transactions = true
+	return eb
+}
 
-	return &EstuaryBridge{
-		endpoint: endpoint,
-		name:     fmt.Sprintf("%s-%s:%d", targetConfig.Type, targetConfig.Host, targetConfig.Port),
-	}, nil
+// Atomic reports whether the batches of the bridge are applied atomically
+func (eb *EstuaryBridge) Atomic() bool {
+	return eb.transactions
 }
 
 // WriteEvent implements the EstuaryWriter interface
@@ -123,7 +62,7 @@ func (eb *EstuaryBridge) WriteEvent(ctx context.Context, event map[string]interf
 	recordEvent, err := eb.convertToRecordEvent(event)
 	if err != nil {
 		log.Error().Err(err).Str("name", eb.name).Msg("EstuaryBridge.WriteEvent failed to convert event")
-		return fmt.Errorf("failed to convert event: %w", err)
+		return estuary.NewRecordError(eb.name, "", "failed to convert event", err)
 	}
 
 	log.Debug().
@@ -132,22 +71,84 @@ func (eb *EstuaryBridge) WriteEvent(ctx context.Context, event map[string]interf
 		Bool("has_old_data", recordEvent.OldData != nil).
 		Int("old_data_len", len(recordEvent.OldData)).
 		Interface("recordEvent", recordEvent).
-		Msg("EstuaryBridge calling endpoint.WriteEvent")
+		Msg("EstuaryBridge calling destination")
 
-	// Call the legacy endpoint's WriteEvent method
-	eb.endpoint.WriteEvent(recordEvent)
+	if err := eb.write(ctx, []*events.RecordEvent{recordEvent}); err != nil {
+		return err
+	}
 
 	log.Debug().Str("name", eb.name).Msg("EstuaryBridge.WriteEvent completed")
 	return nil
 }
 
+// WriteBatch writes events in order with a single endpoint batch
+func (eb *EstuaryBridge) WriteBatch(ctx context.Context, batch []map[string]interface{}) error {
+	records := make([]*events.RecordEvent, 0, len(batch))
+	for _, event := range batch {
+		recordEvent, err := eb.convertToRecordEvent(event)
+		if err != nil {
+			return estuary.NewRecordError(eb.name, "", "failed to convert event", err)
+		}
+		records = append(records, recordEvent)
+	}
+	return eb.write(ctx, records)
+}
+
+// write hands the records to the destination, as record events when it takes them
+func (eb *EstuaryBridge) write(ctx context.Context, records []*events.RecordEvent) error {
+	if eb.transactions {
+		return eb.writeTransaction(ctx, records)
+	}
+	if writer, ok := eb.destination.(eventWriter); ok {
+		size := 0
+		for _, record := range records {
+			size += len(record.Data)
+		}
+		return writer.WriteEvents(ctx, records, size)
+	}
+
+	batch, err := eb.destinationRecords(records)
+	if err != nil {
+		return err
+	}
+	return eb.destination.WriteBatch(ctx, batch)
+}
+
+// writeTransaction writes the records in a destination transaction, rolled
+// back when they cannot be written
+func (eb *EstuaryBridge) writeTransaction(ctx context.Context, records []*events.RecordEvent) error {
+	batch, err := eb.destinationRecords(records)
+	if err != nil {
+		return err
+	}
+	tx, err := eb.destination.BeginTransaction(ctx)
+	if err != nil {
+		return err
+	}
+	if err := tx.WriteBatch(ctx, batch); err != nil {
+		if rollbackErr := tx.Rollback(ctx); rollbackErr != nil {
+			log.Warn().Err(rollbackErr).Str("name", eb.name).Str("transaction", tx.GetID()).Msg("Failed to roll back transaction")
+		}
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (eb *EstuaryBridge) destinationRecords(records []*events.RecordEvent) ([]estuary.DestinationRecord, error) {
+	batch := make([]estuary.DestinationRecord, 0, len(records))
+	for _, record := range records {
+		destinationRecord, err := estuary.DestinationRecordFromEvent(record)
+		if err != nil {
+			return nil, estuary.NewRecordError(eb.name, record.Collection, "failed to convert event", err)
+		}
+		batch = append(batch, destinationRecord)
+	}
+	return batch, nil
+}
+
 // Close implements the EstuaryWriter interface
 func (eb *EstuaryBridge) Close() error {
-	// Check if the endpoint has a Close method and call it
-	if closer, ok := eb.endpoint.(interface{ Close() error }); ok {
-		return closer.Close()
-	}
-	return nil
+	return eb.destination.Close()
 }
 
 // convertToRecordEvent converts the transformed event data back to RecordEvent format
@@ -228,6 +229,8 @@ func (eb *EstuaryBridge) convertToRecordEvent(event map[string]interface{}) (*ev
 		}
 	}
 
+	position, _ := event["position"].(map[string]interface{})
+
 	return &events.RecordEvent{
 		Action:     action,
 		Schema:     schema,
@@ -235,6 +238,7 @@ func (eb *EstuaryBridge) convertToRecordEvent(event map[string]interface{}) (*ev
 		Data:       dataBytes,
 		OldData:    oldDataBytes,
 		DocumentKey: documentKeyBytes,
+		Position:   position,
 	}, nil
 }
 
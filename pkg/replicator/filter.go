@@ -0,0 +1,251 @@
+package replicator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sync/atomic"
+
+	"github.com/cohenjo/replicator/pkg/config"
+	"github.com/cohenjo/replicator/pkg/events"
+	"github.com/cohenjo/replicator/pkg/models"
+	"github.com/cohenjo/replicator/pkg/streams"
+	"github.com/cohenjo/replicator/pkg/transform"
+)
+
+var _ streams.StreamFilter = (*FilterStage)(nil)
+
+// FilterStage drops the events of a stream that its filter excludes, before
+// they are transformed, and counts them as skipped. An event is kept when its
+// operation, database and collection are in the include lists, or the lists
+// are empty, and not in the exclude lists; and when it matches every include
+// field filter and none of the exclude ones. Names wrapped in slashes are
+// regular expressions. Field filters test the document of the event, the
+// previous document for deletes; a field the document lacks, or a value
+// that does not compare, does not match.
+type FilterStage struct {
+	filter      models.EventFilter
+	operations  nameFilter
+	databases   nameFilter
+	collections nameFilter
+	fields      []fieldFilter
+	conditions  *transform.KazaamRuleEngine
+	seen        atomic.Int64
+	skipped     atomic.Int64
+}
+
+// nameFilter matches names against include and exclude lists
+type nameFilter struct {
+	include namePatterns
+	exclude namePatterns
+}
+
+// namePatterns are the names and regular expressions of a list
+type namePatterns struct {
+	names    map[string]bool
+	patterns []*regexp.Regexp
+}
+
+type fieldFilter struct {
+	condition transform.Condition
+	include   bool
+}
+
+// NewFilterStage creates the filter stage of an event filter
+func NewFilterStage(filter models.EventFilter) (*FilterStage, error) {
+	if filter.CustomFilter != "" {
+		return nil, fmt.Errorf("custom filters are not supported")
+	}
+	stage := &FilterStage{filter: filter, conditions: transform.NewKazaamRuleEngine()}
+
+	var err error
+	if stage.operations, err = newNameFilter(filter.IncludeOperations, filter.ExcludeOperations); err != nil {
+		return nil, fmt.Errorf("invalid operation filter: %w", err)
+	}
+	if stage.databases, err = newNameFilter(filter.IncludeDatabases, filter.ExcludeDatabases); err != nil {
+		return nil, fmt.Errorf("invalid database filter: %w", err)
+	}
+	if stage.collections, err = newNameFilter(filter.IncludeCollections, filter.ExcludeCollections); err != nil {
+		return nil, fmt.Errorf("invalid collection filter: %w", err)
+	}
+	for i, field := range filter.FieldFilters {
+		condition := transform.Condition{Field: field.Field, Operator: field.Operator, Value: field.Value}
+		if err := condition.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid field filter %d: %w", i, err)
+		}
+		stage.fields = append(stage.fields, fieldFilter{condition: condition, include: field.Include})
+	}
+	return stage, nil
+}
+
+// eventFilterFromConfig converts the filter of a stream configuration
+func eventFilterFromConfig(cfg *config.FilterConfig) models.EventFilter {
+	filter := models.EventFilter{
+		IncludeOperations:  cfg.IncludeOperations,
+		ExcludeOperations:  cfg.ExcludeOperations,
+		IncludeDatabases:   cfg.IncludeDatabases,
+		ExcludeDatabases:   cfg.ExcludeDatabases,
+		IncludeCollections: cfg.IncludeCollections,
+		ExcludeCollections: cfg.ExcludeCollections,
+		CustomFilter:       cfg.CustomFilter,
+	}
+	for _, field := range cfg.FieldFilters {
+		filter.FieldFilters = append(filter.FieldFilters, models.FieldFilter{
+			Field:    field.Field,
+			Operator: field.Operator,
+			Value:    field.Value,
+			Include:  field.Include,
+		})
+	}
+	return filter
+}
+
+// Filter returns the event filter of the stage
+func (f *FilterStage) Filter() models.EventFilter {
+	return f.filter
+}
+
+// Include reports whether an event of the stream is kept, counting the
+// events that are not as skipped
+func (f *FilterStage) Include(event events.RecordEvent) bool {
+	f.seen.Add(1)
+	include := f.match(event.Action, event.Schema, event.Collection, func() map[string]interface{} {
+		data := event.Data
+		if len(data) == 0 {
+			data = event.OldData
+		}
+		var document map[string]interface{}
+		if err := json.Unmarshal(data, &document); err != nil {
+			return nil
+		}
+		return document
+	})
+	if !include {
+		f.skipped.Add(1)
+	}
+	return include
+}
+
+// ShouldInclude reports whether a change event is kept
+func (f *FilterStage) ShouldInclude(event models.ChangeEvent, _ config.StreamConfig) (bool, error) {
+	database := event.Database
+	collection := event.Collection
+	if collection == "" {
+		collection = event.Table
+	}
+	return f.match(event.OperationType, database, collection, func() map[string]interface{} {
+		if event.FullDocument != nil {
+			return event.FullDocument
+		}
+		return event.DocumentKey
+	}), nil
+}
+
+// ValidateFilters validates an event filter given as a map
+func (f *FilterStage) ValidateFilters(filters map[string]interface{}) error {
+	raw, err := json.Marshal(filters)
+	if err != nil {
+		return fmt.Errorf("failed to marshal filters: %w", err)
+	}
+	var filter models.EventFilter
+	if err := json.Unmarshal(raw, &filter); err != nil {
+		return fmt.Errorf("invalid filters: %w", err)
+	}
+	_, err = NewFilterStage(filter)
+	return err
+}
+
+// Seen returns the number of events the stage filtered
+func (f *FilterStage) Seen() int64 {
+	return f.seen.Load()
+}
+
+// Skipped returns the number of events the stage dropped
+func (f *FilterStage) Skipped() int64 {
+	return f.skipped.Load()
+}
+
+// match applies the filter to an event, decoding its document only when
+// there are field filters
+func (f *FilterStage) match(operation, database, collection string, document func() map[string]interface{}) bool {
+	if !f.operations.match(operation) || !f.databases.match(database) || !f.collections.match(collection) {
+		return false
+	}
+	if len(f.fields) == 0 {
+		return true
+	}
+
+	// The fields are tested on the document decoded once
+	doc := document()
+	if doc != nil {
+		doc = transform.DecodeEnvelope(doc)
+	}
+	for _, field := range f.fields {
+		if f.matchField(doc, field.condition) != field.include {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *FilterStage) matchField(document map[string]interface{}, condition transform.Condition) bool {
+	if document == nil {
+		return false
+	}
+	met, err := f.conditions.EvaluateConditions(context.Background(), document, []transform.Condition{condition})
+	return err == nil && met
+}
+
+func newNameFilter(include, exclude []string) (nameFilter, error) {
+	var filter nameFilter
+	var err error
+	if filter.include, err = newNamePatterns(include); err != nil {
+		return filter, err
+	}
+	if filter.exclude, err = newNamePatterns(exclude); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+// match reports whether a name is included and not excluded
+func (f nameFilter) match(name string) bool {
+	if !f.include.empty() && !f.include.match(name) {
+		return false
+	}
+	return !f.exclude.match(name)
+}
+
+func newNamePatterns(entries []string) (namePatterns, error) {
+	patterns := namePatterns{names: make(map[string]bool)}
+	for _, entry := range entries {
+		expr, ok := config.FilterPattern(entry)
+		if !ok {
+			patterns.names[entry] = true
+			continue
+		}
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return patterns, fmt.Errorf("invalid pattern %s: %w", entry, err)
+		}
+		patterns.patterns = append(patterns.patterns, pattern)
+	}
+	return patterns, nil
+}
+
+func (p namePatterns) empty() bool {
+	return len(p.names) == 0 && len(p.patterns) == 0
+}
+
+func (p namePatterns) match(name string) bool {
+	if p.names[name] {
+		return true
+	}
+	for _, pattern := range p.patterns {
+		if pattern.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package replicator
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cohenjo/replicator/pkg/config"
+	"github.com/cohenjo/replicator/pkg/events"
+	"github.com/cohenjo/replicator/pkg/models"
+	"github.com/cohenjo/replicator/pkg/transform"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterStageNames(t *testing.T) {
+	stage, err := NewFilterStage(eventFilterFromConfig(&config.FilterConfig{
+		IncludeOperations:  []string{"insert", "update"},
+		ExcludeDatabases:   []string{"/^tmp_/"},
+		IncludeCollections: []string{"orders", "/^audit_.*$/"},
+		ExcludeCollections: []string{"audit_debug"},
+	}))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		event    events.RecordEvent
+		expected bool
+	}{
+		{"included", events.RecordEvent{Action: "insert", Schema: "shop", Collection: "orders"}, true},
+		{"excluded operation", events.RecordEvent{Action: "delete", Schema: "shop", Collection: "orders"}, false},
+		{"excluded database pattern", events.RecordEvent{Action: "insert", Schema: "tmp_shop", Collection: "orders"}, false},
+		{"included collection pattern", events.RecordEvent{Action: "update", Schema: "shop", Collection: "audit_login"}, true},
+		{"excluded collection", events.RecordEvent{Action: "update", Schema: "shop", Collection: "audit_debug"}, false},
+		{"collection not included", events.RecordEvent{Action: "insert", Schema: "shop", Collection: "users"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, stage.Include(tt.event))
+		})
+	}
+	assert.Equal(t, int64(len(tests)), stage.Seen())
+	assert.Equal(t, int64(4), stage.Skipped())
+}
+
+func TestFilterStageFields(t *testing.T) {
+	stage, err := NewFilterStage(models.EventFilter{
+		FieldFilters: []models.FieldFilter{
+			{Field: "status", Operator: "in", Value: []interface{}{"paid", "shipped"}, Include: true},
+			{Field: "customer.test", Operator: "eq", Value: true, Include: false},
+		},
+	})
+	require.NoError(t, err)
+
+	assert.True(t, stage.Include(events.RecordEvent{Action: "insert", Data: []byte(`{"status":"paid","customer":{"test":false}}`)}))
+	assert.True(t, stage.Include(events.RecordEvent{Action: "insert", Data: []byte(`{"status":"paid"}`)}), "a missing field does not match the exclude filter")
+	assert.False(t, stage.Include(events.RecordEvent{Action: "insert", Data: []byte(`{"status":"paid","customer":{"test":true}}`)}))
+	assert.False(t, stage.Include(events.RecordEvent{Action: "insert", Data: []byte(`{"status":"pending"}`)}))
+	assert.False(t, stage.Include(events.RecordEvent{Action: "insert", Data: []byte(`{}`)}), "a missing field does not match the include filter")
+	assert.True(t, stage.Include(events.RecordEvent{Action: "delete", OldData: []byte(`{"status":"shipped"}`)}), "deletes are filtered on their previous document")
+	assert.Equal(t, int64(3), stage.Skipped())
+
+	include, err := stage.ShouldInclude(models.ChangeEvent{OperationType: "insert", FullDocument: map[string]interface{}{"status": "shipped"}}, config.StreamConfig{})
+	require.NoError(t, err)
+	assert.True(t, include)
+	assert.Equal(t, int64(3), stage.Skipped(), "change events are not counted")
+}
+
+func TestFilterStageInvalid(t *testing.T) {
+	_, err := NewFilterStage(models.EventFilter{IncludeCollections: []string{"/[/"}})
+	assert.Error(t, err)
+
+	_, err = NewFilterStage(models.EventFilter{FieldFilters: []models.FieldFilter{{Field: "status", Operator: "like", Value: "x"}}})
+	assert.Error(t, err)
+
+	_, err = NewFilterStage(models.EventFilter{CustomFilter: "status == 'paid'"})
+	assert.Error(t, err)
+
+	stage, err := NewFilterStage(models.EventFilter{})
+	require.NoError(t, err)
+	assert.NoError(t, stage.ValidateFilters(map[string]interface{}{"include_operations": []string{"insert"}}))
+	assert.Error(t, stage.ValidateFilters(map[string]interface{}{"exclude_databases": []string{"/(/"}}))
+}
+
+func TestApplyBatchSkipsFilteredEvents(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	stage, err := NewFilterStage(models.EventFilter{ExcludeOperations: []string{"delete"}})
+	require.NoError(t, err)
+	service := &Service{
+		logger:           logger,
+		transformEngines: make(map[string]*transform.Engine),
+		filters:          map[string]*FilterStage{"orders": stage},
+	}
+
+	batch := []events.RecordEvent{
+		{Action: "insert", Collection: "orders", Data: []byte(`{"id":1}`), Stream: "orders"},
+		{Action: "delete", Collection: "orders", OldData: []byte(`{"id":2}`), Stream: "orders"},
+	}
+	failed, err := service.applyBatch(context.Background(), "orders", batch, false)
+	require.NoError(t, err)
+	assert.Equal(t, 0, failed)
+	assert.Equal(t, int64(2), stage.Seen())
+	assert.Equal(t, int64(1), stage.Skipped())
+
+	// The skipped events are reported with the statistics of the stream
+	service.streamManager = &StreamManager{streams: map[string]models.Stream{"orders": &statsStream{}}}
+	stats, ok := service.EventStatistics("orders")
+	require.True(t, ok)
+	assert.Equal(t, int64(1), stats.SkippedEvents)
+	assert.Equal(t, int64(5), stats.TotalEvents)
+}
+
+// statsStream is a stream reporting fixed metrics
+type statsStream struct {
+	models.Stream
+}
+
+func (s *statsStream) GetMetrics() models.ReplicationMetrics {
+	return models.ReplicationMetrics{StreamName: "orders", EventsProcessed: 5}
+}
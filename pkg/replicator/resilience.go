@@ -0,0 +1,485 @@
+package replicator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/cohenjo/replicator/pkg/config"
+	"github.com/cohenjo/replicator/pkg/estuary"
+	"github.com/cohenjo/replicator/pkg/models"
+	"github.com/rs/zerolog/log"
+)
+
+// Defaults for targets that do not configure retries or the circuit breaker
+const (
+	defaultMaxRetries       = 3
+	defaultInitialDelay     = 100 * time.Millisecond
+	defaultMaxDelay         = 30 * time.Second
+	defaultBackoffFactor    = 2.0
+	defaultJitter           = 0.2
+	defaultFailureThreshold = 5
+	defaultRecoveryTimeout  = 30 * time.Second
+	defaultHalfOpenRequests = 1
+)
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+// destinationErrorCodes are the codes a retry policy may list as retryable
+var destinationErrorCodes = map[string]bool{
+	estuary.ErrCodeConnectionFailed:     true,
+	estuary.ErrCodeWriteFailed:          true,
+	estuary.ErrCodeSchemaNotFound:       true,
+	estuary.ErrCodeInvalidSchema:        true,
+	estuary.ErrCodeTransactionFailed:    true,
+	estuary.ErrCodeTableNotFound:        true,
+	estuary.ErrCodeInvalidConfig:        true,
+	estuary.ErrCodeDestinationNotFound:  true,
+	estuary.ErrCodeUnsupportedOperation: true,
+	estuary.ErrCodeInvalidRecord:        true,
+	estuary.ErrCodeThrottled:            true,
+	estuary.ErrCodeTimeout:              true,
+}
+
+// retryPolicyForTarget builds the retry policy of a target, filling the
+// settings the target leaves unset with the defaults
+func retryPolicyForTarget(target config.TargetConfig) (models.RetryPolicy, error) {
+	policy := models.RetryPolicy{
+		MaxRetries:    defaultMaxRetries,
+		InitialDelay:  defaultInitialDelay,
+		MaxDelay:      defaultMaxDelay,
+		BackoffFactor: defaultBackoffFactor,
+		Jitter:        defaultJitter,
+	}
+	if target.Retry == nil {
+		return policy, nil
+	}
+
+	retry := target.Retry
+	if retry.MaxRetries > 0 {
+		policy.MaxRetries = retry.MaxRetries
+	}
+	var err error
+	if retry.InitialDelay != "" {
+		if policy.InitialDelay, err = time.ParseDuration(retry.InitialDelay); err != nil {
+			return policy, fmt.Errorf("invalid initial_delay: %w", err)
+		}
+	}
+	if retry.MaxDelay != "" {
+		if policy.MaxDelay, err = time.ParseDuration(retry.MaxDelay); err != nil {
+			return policy, fmt.Errorf("invalid max_delay: %w", err)
+		}
+	}
+	if retry.BackoffFactor != 0 {
+		policy.BackoffFactor = retry.BackoffFactor
+	}
+	if retry.Jitter > 0 {
+		policy.Jitter = retry.Jitter
+	}
+	for _, code := range retry.RetryableErrors {
+		if !destinationErrorCodes[code] {
+			return policy, fmt.Errorf("unknown retryable error code: %s", code)
+		}
+	}
+	policy.RetryableErrors = retry.RetryableErrors
+	return policy, nil
+}
+
+// circuitBreakerForTarget builds the circuit breaker configuration of a
+// target. The breaker is disabled unless the target enables it.
+func circuitBreakerForTarget(target config.TargetConfig) (models.CircuitBreakerConfig, error) {
+	breaker := models.CircuitBreakerConfig{
+		FailureThreshold: defaultFailureThreshold,
+		RecoveryTimeout:  defaultRecoveryTimeout,
+		HalfOpenRequests: defaultHalfOpenRequests,
+	}
+	if target.CircuitBreaker == nil {
+		return breaker, nil
+	}
+
+	cb := target.CircuitBreaker
+	breaker.Enabled = cb.Enabled
+	if cb.FailureThreshold > 0 {
+		breaker.FailureThreshold = cb.FailureThreshold
+	}
+	if cb.HalfOpenRequests > 0 {
+		breaker.HalfOpenRequests = cb.HalfOpenRequests
+	}
+	if cb.RecoveryTimeout != "" {
+		timeout, err := time.ParseDuration(cb.RecoveryTimeout)
+		if err != nil {
+			return breaker, fmt.Errorf("invalid recovery_timeout: %w", err)
+		}
+		breaker.RecoveryTimeout = timeout
+	}
+	return breaker, nil
+}
+
+// backoffDelay returns the delay before the given retry, counting from zero.
+// The delay grows by the backoff factor up to the max delay, and the jitter
+// spreads it by up to that fraction in either direction.
+func backoffDelay(policy models.RetryPolicy, attempt int) time.Duration {
+	delay := float64(policy.InitialDelay) * math.Pow(policy.BackoffFactor, float64(attempt))
+	if policy.MaxDelay > 0 && delay > float64(policy.MaxDelay) {
+		delay = float64(policy.MaxDelay)
+	}
+	if policy.Jitter > 0 {
+		delay += delay * policy.Jitter * (2*rand.Float64() - 1)
+	}
+	if delay < 0 {
+		return 0
+	}
+	return time.Duration(delay)
+}
+
+// isRetryableWith reports whether the policy retries the error. A policy
+// listing error codes retries only destination errors with those codes.
+func isRetryableWith(policy models.RetryPolicy, err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if len(policy.RetryableErrors) == 0 {
+		return estuary.IsRetryable(err)
+	}
+	var destErr *estuary.DestinationError
+	if !errors.As(err, &destErr) {
+		return false
+	}
+	for _, code := range policy.RetryableErrors {
+		if destErr.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// BreakerSnapshot is the state of a circuit breaker at a point in time
+type BreakerSnapshot struct {
+	State               string    `json:"state"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	OpenedAt            time.Time `json:"opened_at,omitempty"`
+}
+
+// CircuitBreaker opens after a number of consecutive failures. Once the
+// recovery timeout has passed it lets probes through half-open, and closes
+// again after enough of them succeed.
+type CircuitBreaker struct {
+	config    models.CircuitBreakerConfig
+	state     string
+	failures  int
+	successes int
+	openedAt  time.Time
+	now       func() time.Time
+	mu        sync.Mutex
+}
+
+// NewCircuitBreaker creates a new closed circuit breaker
+func NewCircuitBreaker(cfg models.CircuitBreakerConfig) *CircuitBreaker {
+	return &CircuitBreaker{
+		config: cfg,
+		state:  BreakerClosed,
+		now:    time.Now,
+	}
+}
+
+// State returns the current state of the breaker
+func (cb *CircuitBreaker) State() string {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+// Snapshot returns the state and failure count of the breaker
+func (cb *CircuitBreaker) Snapshot() BreakerSnapshot {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return BreakerSnapshot{
+		State:               cb.state,
+		ConsecutiveFailures: cb.failures,
+		OpenedAt:            cb.openedAt,
+	}
+}
+
+// RecordFailure counts a failure and reports whether it opened the breaker.
+// A failed half-open probe opens the breaker again.
+func (cb *CircuitBreaker) RecordFailure() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.failures++
+	cb.successes = 0
+	if cb.state == BreakerHalfOpen || (cb.state == BreakerClosed && cb.failures >= cb.config.FailureThreshold) {
+		cb.state = BreakerOpen
+		cb.openedAt = cb.now()
+		return true
+	}
+	return false
+}
+
+// RecordSuccess counts a success and reports whether it closed the breaker
+func (cb *CircuitBreaker) RecordSuccess() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.failures = 0
+	if cb.state != BreakerHalfOpen {
+		return false
+	}
+	cb.successes++
+	if cb.successes < cb.config.HalfOpenRequests {
+		return false
+	}
+	cb.state = BreakerClosed
+	cb.successes = 0
+	cb.openedAt = time.Time{}
+	return true
+}
+
+// RecoveryWait returns how long an open breaker stays open before probing
+func (cb *CircuitBreaker) RecoveryWait() time.Duration {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state != BreakerOpen {
+		return 0
+	}
+	if wait := cb.openedAt.Add(cb.config.RecoveryTimeout).Sub(cb.now()); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// TryHalfOpen moves an open breaker whose recovery timeout has passed to
+// half-open, and reports whether probes may be sent
+func (cb *CircuitBreaker) TryHalfOpen() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch cb.state {
+	case BreakerHalfOpen:
+		return true
+	case BreakerOpen:
+		if cb.now().Before(cb.openedAt.Add(cb.config.RecoveryTimeout)) {
+			return false
+		}
+		cb.state = BreakerHalfOpen
+		cb.successes = 0
+		return true
+	}
+	return false
+}
+
+// batchWriter is implemented by writers that write a batch of events with a
+// single target write
+type batchWriter interface {
+	WriteBatch(ctx context.Context, batch []map[string]interface{}) error
+}
+
+// atomicWriter is implemented by writers that can report whether each of
+// their batches is applied atomically
+type atomicWriter interface {
+	Atomic() bool
+}
+
+// ErrBreakerOpen is the error of the writes of a fail-fast writer while its
+// circuit breaker is open
+var ErrBreakerOpen = errors.New("circuit breaker is open")
+
+// ResilientWriter retries the writes of an EstuaryWriter with backoff. With
+// a circuit breaker, writes that keep failing open the breaker instead of
+// being dropped: the stream is paused, the pending write waits out the
+// recovery timeout and is then sent as the half-open probe. A fail-fast
+// writer does not wait: its writes fail with ErrBreakerOpen until the
+// recovery timeout has passed.
+type ResilientWriter struct {
+	writer   EstuaryWriter
+	name     string
+	policy   models.RetryPolicy
+	breaker  *CircuitBreaker
+	failFast bool
+	onOpen   func(ctx context.Context)
+	onClose  func(ctx context.Context)
+	sleep    func(ctx context.Context, d time.Duration) error
+}
+
+// NewResilientWriter wraps a writer with the retry policy and, when not nil,
+// the circuit breaker. onOpen and onClose are called when the breaker opens
+// and closes, and may be nil.
+func NewResilientWriter(name string, writer EstuaryWriter, policy models.RetryPolicy, breaker *CircuitBreaker, onOpen, onClose func(ctx context.Context)) *ResilientWriter {
+	return &ResilientWriter{
+		writer:  writer,
+		name:    name,
+		policy:  policy,
+		breaker: breaker,
+		onOpen:  onOpen,
+		onClose: onClose,
+		sleep:   sleepContext,
+	}
+}
+
+// WriteFailure is the error of a write that failed after all its attempts
+type WriteFailure struct {
+	Attempts int
+	Err      error
+}
+
+func (e *WriteFailure) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the error of the last attempt
+func (e *WriteFailure) Unwrap() error {
+	return e.Err
+}
+
+// Name returns the name of the stream or route the writer writes for
+func (rw *ResilientWriter) Name() string {
+	return rw.name
+}
+
+// FailFast makes the writes fail with ErrBreakerOpen while the circuit
+// breaker is open rather than wait for the target to recover
+func (rw *ResilientWriter) FailFast() *ResilientWriter {
+	rw.failFast = true
+	return rw
+}
+
+// Breaker returns the circuit breaker of the writer, nil when it has none
+func (rw *ResilientWriter) Breaker() *CircuitBreaker {
+	return rw.breaker
+}
+
+// Atomic reports whether the batches of the writer are applied atomically,
+// all of their events or none
+func (rw *ResilientWriter) Atomic() bool {
+	writer, ok := rw.writer.(atomicWriter)
+	return ok && writer.Atomic()
+}
+
+// WriteEvent implements the EstuaryWriter interface
+func (rw *ResilientWriter) WriteEvent(ctx context.Context, event map[string]interface{}) error {
+	return rw.do(ctx, func(ctx context.Context) error {
+		return rw.writer.WriteEvent(ctx, event)
+	})
+}
+
+// WriteBatch writes the events in order, with a single target write when the
+// writer takes batches and event by event otherwise. A failing batch is
+// retried as a whole.
+func (rw *ResilientWriter) WriteBatch(ctx context.Context, batch []map[string]interface{}) error {
+	writer, ok := rw.writer.(batchWriter)
+	return rw.do(ctx, func(ctx context.Context) error {
+		if ok {
+			return writer.WriteBatch(ctx, batch)
+		}
+		for _, event := range batch {
+			if err := rw.writer.WriteEvent(ctx, event); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Close implements the EstuaryWriter interface
+func (rw *ResilientWriter) Close() error {
+	return rw.writer.Close()
+}
+
+// do runs the write until it succeeds, fails with an error the policy does
+// not retry, or runs out of retries while there is no circuit breaker or,
+// failing fast, once the breaker is open. The error is a WriteFailure
+// counting the attempts.
+func (rw *ResilientWriter) do(ctx context.Context, write func(ctx context.Context) error) error {
+	total := 0
+	for {
+		if rw.breaker != nil && rw.breaker.State() == BreakerOpen {
+			if rw.failFast && !rw.breaker.TryHalfOpen() {
+				return &WriteFailure{Attempts: total, Err: fmt.Errorf("%s: %w", rw.name, ErrBreakerOpen)}
+			}
+			if err := rw.awaitRecovery(ctx); err != nil {
+				return err
+			}
+		}
+
+		attempts, err := rw.attempt(ctx, write)
+		total += attempts
+		if err == nil {
+			if rw.breaker != nil && rw.breaker.RecordSuccess() {
+				log.Info().Str("name", rw.name).Msg("Circuit breaker closed")
+				if rw.onClose != nil {
+					rw.onClose(ctx)
+				}
+			}
+			return nil
+		}
+		if rw.breaker == nil || !isRetryableWith(rw.policy, err) {
+			return &WriteFailure{Attempts: total, Err: err}
+		}
+
+		if rw.breaker.RecordFailure() {
+			log.Warn().Err(err).Str("name", rw.name).Msg("Circuit breaker opened")
+			if rw.onOpen != nil {
+				rw.onOpen(ctx)
+			}
+		}
+		if rw.failFast && rw.breaker.State() == BreakerOpen {
+			return &WriteFailure{Attempts: total, Err: err}
+		}
+	}
+}
+
+// attempt runs the write with the retries of the policy. A half-open probe
+// is a single attempt, so a target that is still down reopens the breaker
+// at once. It returns the number of attempts made.
+func (rw *ResilientWriter) attempt(ctx context.Context, write func(ctx context.Context) error) (int, error) {
+	retries := rw.policy.MaxRetries
+	if rw.breaker != nil && rw.breaker.State() == BreakerHalfOpen {
+		retries = 0
+	}
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = write(ctx); err == nil || attempt >= retries || !isRetryableWith(rw.policy, err) {
+			return attempt + 1, err
+		}
+		delay := backoffDelay(rw.policy, attempt)
+		log.Debug().Err(err).Str("name", rw.name).Int("attempt", attempt+1).Dur("delay", delay).Msg("Retrying write")
+		if sleepErr := rw.sleep(ctx, delay); sleepErr != nil {
+			return attempt + 1, err
+		}
+	}
+}
+
+// awaitRecovery waits until the open breaker may send half-open probes
+func (rw *ResilientWriter) awaitRecovery(ctx context.Context) error {
+	for !rw.breaker.TryHalfOpen() {
+		if err := rw.sleep(ctx, rw.breaker.RecoveryWait()); err != nil {
+			return fmt.Errorf("circuit breaker of %s is open: %w", rw.name, err)
+		}
+	}
+	log.Info().Str("name", rw.name).Msg("Circuit breaker half-open, probing")
+	return nil
+}
+
+// sleepContext sleeps for the duration or until the context is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,189 @@
+package replicator
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cohenjo/replicator/pkg/config"
+	"github.com/cohenjo/replicator/pkg/estuary"
+	"github.com/cohenjo/replicator/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scriptedWriter fails its writes with the scripted errors, then succeeds
+type scriptedWriter struct {
+	errs   []error
+	writes int
+}
+
+func (w *scriptedWriter) WriteEvent(ctx context.Context, event map[string]interface{}) error {
+	w.writes++
+	if len(w.errs) == 0 {
+		return nil
+	}
+	err := w.errs[0]
+	w.errs = w.errs[1:]
+	return err
+}
+
+func (w *scriptedWriter) Close() error {
+	return nil
+}
+
+func connectionError() error {
+	return estuary.NewConnectionError("test", "connection refused", nil)
+}
+
+// newTestWriter returns a resilient writer whose sleeps advance a fake clock
+func newTestWriter(writer EstuaryWriter, policy models.RetryPolicy, breaker *CircuitBreaker) (*ResilientWriter, *[]string) {
+	transitions := &[]string{}
+	rw := NewResilientWriter("test", writer, policy, breaker,
+		func(ctx context.Context) { *transitions = append(*transitions, "pause") },
+		func(ctx context.Context) { *transitions = append(*transitions, "resume") })
+	now := time.Now()
+	if breaker != nil {
+		breaker.now = func() time.Time { return now }
+	}
+	rw.sleep = func(ctx context.Context, d time.Duration) error {
+		now = now.Add(d)
+		return ctx.Err()
+	}
+	return rw, transitions
+}
+
+func TestRetryPolicyForTarget(t *testing.T) {
+	policy, err := retryPolicyForTarget(config.TargetConfig{})
+	require.NoError(t, err)
+	assert.Equal(t, defaultMaxRetries, policy.MaxRetries)
+	assert.Equal(t, defaultInitialDelay, policy.InitialDelay)
+
+	policy, err = retryPolicyForTarget(config.TargetConfig{Retry: &config.RetryConfig{
+		MaxRetries:      5,
+		InitialDelay:    "1s",
+		MaxDelay:        "1m",
+		Jitter:          0.5,
+		RetryableErrors: []string{estuary.ErrCodeThrottled},
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, 5, policy.MaxRetries)
+	assert.Equal(t, time.Second, policy.InitialDelay)
+	assert.Equal(t, time.Minute, policy.MaxDelay)
+	assert.Equal(t, defaultBackoffFactor, policy.BackoffFactor)
+	assert.Equal(t, 0.5, policy.Jitter)
+
+	// Fields left out of a retry block keep their defaults
+	policy, err = retryPolicyForTarget(config.TargetConfig{Retry: &config.RetryConfig{MaxDelay: "1m"}})
+	require.NoError(t, err)
+	assert.Equal(t, defaultMaxRetries, policy.MaxRetries)
+	assert.Equal(t, defaultInitialDelay, policy.InitialDelay)
+	assert.Equal(t, time.Minute, policy.MaxDelay)
+	assert.Equal(t, defaultBackoffFactor, policy.BackoffFactor)
+	assert.Equal(t, defaultJitter, policy.Jitter)
+
+	_, err = retryPolicyForTarget(config.TargetConfig{Retry: &config.RetryConfig{RetryableErrors: []string{"BOGUS"}}})
+	assert.Error(t, err)
+}
+
+func TestBackoffDelay(t *testing.T) {
+	policy := models.RetryPolicy{InitialDelay: 100 * time.Millisecond, MaxDelay: time.Second, BackoffFactor: 2}
+	assert.Equal(t, 100*time.Millisecond, backoffDelay(policy, 0))
+	assert.Equal(t, 400*time.Millisecond, backoffDelay(policy, 2))
+	assert.Equal(t, time.Second, backoffDelay(policy, 10), "capped at the max delay")
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		delay := backoffDelay(policy, 0)
+		assert.GreaterOrEqual(t, delay, 50*time.Millisecond)
+		assert.LessOrEqual(t, delay, 150*time.Millisecond)
+	}
+}
+
+func TestIsRetryableWith(t *testing.T) {
+	throttled := &estuary.DestinationError{Code: estuary.ErrCodeThrottled, Destination: "test", Message: "slow down"}
+	assert.True(t, isRetryableWith(models.RetryPolicy{}, connectionError()))
+	assert.False(t, isRetryableWith(models.RetryPolicy{}, estuary.NewRecordError("test", "t", "bad", nil)))
+
+	policy := models.RetryPolicy{RetryableErrors: []string{estuary.ErrCodeThrottled}}
+	assert.True(t, isRetryableWith(policy, throttled))
+	assert.False(t, isRetryableWith(policy, connectionError()))
+	assert.False(t, isRetryableWith(policy, errors.New("unclassified")))
+	assert.False(t, isRetryableWith(policy, context.Canceled))
+}
+
+func TestResilientWriterRetries(t *testing.T) {
+	policy := models.RetryPolicy{MaxRetries: 2, InitialDelay: time.Millisecond, BackoffFactor: 2}
+
+	writer := &scriptedWriter{errs: []error{connectionError(), connectionError()}}
+	rw, _ := newTestWriter(writer, policy, nil)
+	require.NoError(t, rw.WriteEvent(context.Background(), map[string]interface{}{}))
+	assert.Equal(t, 3, writer.writes)
+
+	writer = &scriptedWriter{errs: []error{connectionError(), connectionError(), connectionError()}}
+	rw, _ = newTestWriter(writer, policy, nil)
+	err := rw.WriteEvent(context.Background(), map[string]interface{}{})
+	var failure *WriteFailure
+	require.ErrorAs(t, err, &failure, "fails once the retries are exhausted")
+	assert.Equal(t, 3, failure.Attempts)
+	assert.Equal(t, 3, writer.writes)
+
+	writer = &scriptedWriter{errs: []error{estuary.NewRecordError("test", "t", "bad", nil)}}
+	rw, _ = newTestWriter(writer, policy, nil)
+	assert.Error(t, rw.WriteEvent(context.Background(), map[string]interface{}{}))
+	assert.Equal(t, 1, writer.writes, "non-retryable errors are not retried")
+}
+
+func TestResilientWriterCircuitBreaker(t *testing.T) {
+	policy := models.RetryPolicy{MaxRetries: 1, InitialDelay: time.Millisecond, BackoffFactor: 2}
+	breaker := NewCircuitBreaker(models.CircuitBreakerConfig{
+		Enabled:          true,
+		FailureThreshold: 2,
+		RecoveryTimeout:  time.Minute,
+		HalfOpenRequests: 1,
+	})
+
+	// Two rounds of retries open the breaker, the first probe fails and the second succeeds
+	errs := make([]error, 5)
+	for i := range errs {
+		errs[i] = connectionError()
+	}
+	writer := &scriptedWriter{errs: errs}
+	rw, transitions := newTestWriter(writer, policy, breaker)
+
+	require.NoError(t, rw.WriteEvent(context.Background(), map[string]interface{}{}), "the event is held until the target recovers")
+	assert.Equal(t, 6, writer.writes)
+	assert.Equal(t, []string{"pause", "pause", "resume"}, *transitions)
+	assert.Equal(t, BreakerClosed, breaker.State())
+	assert.Zero(t, breaker.Snapshot().ConsecutiveFailures)
+}
+
+func TestResilientWriterOpenBreakerHonoursContext(t *testing.T) {
+	breaker := NewCircuitBreaker(models.CircuitBreakerConfig{Enabled: true, FailureThreshold: 1, RecoveryTimeout: time.Minute, HalfOpenRequests: 1})
+	writer := &scriptedWriter{errs: []error{connectionError()}}
+	rw, _ := newTestWriter(writer, models.RetryPolicy{}, breaker)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rw.onOpen = func(context.Context) { cancel() }
+	err := rw.WriteEvent(ctx, map[string]interface{}{})
+	require.Error(t, err)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, BreakerOpen, breaker.State())
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	now := time.Now()
+	breaker := NewCircuitBreaker(models.CircuitBreakerConfig{Enabled: true, FailureThreshold: 1, RecoveryTimeout: time.Second, HalfOpenRequests: 2})
+	breaker.now = func() time.Time { return now }
+
+	assert.True(t, breaker.RecordFailure())
+	assert.False(t, breaker.TryHalfOpen(), "still within the recovery timeout")
+	assert.Equal(t, time.Second, breaker.RecoveryWait())
+
+	now = now.Add(time.Second)
+	assert.True(t, breaker.TryHalfOpen())
+	assert.False(t, breaker.RecordSuccess(), "needs two successful probes")
+	assert.True(t, breaker.RecordSuccess())
+	assert.Equal(t, BreakerClosed, breaker.State())
+}
@@ -0,0 +1,82 @@
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/cohenjo/replicator/pkg/config"
+	"github.com/cohenjo/replicator/pkg/transform"
+)
+
+// Route sends the events of a stream that meet its conditions to a target of
+// its own. Routes test the events once transformed by the rules of their
+// stream, and transform them again with their own rules before writing
+// them. Routes are named after their stream, stream/route, which is the name
+// of their writer, destination and dead-letter entries.
+type Route struct {
+	name       string
+	stream     string
+	conditions []transform.Condition
+	evaluator  *transform.KazaamRuleEngine
+	engine     *transform.Engine // nil when the route transforms nothing
+	writer     *ResilientWriter  // nil until the target of the route is created
+	seen       atomic.Int64
+	routed     atomic.Int64
+}
+
+// NewRoute creates a route of a stream from its configuration, without its
+// transformations and target
+func NewRoute(stream string, cfg config.RouteConfig) (*Route, error) {
+	route := &Route{
+		name:       routeName(stream, cfg.Name),
+		stream:     stream,
+		conditions: transform.ConditionsFromConfig(cfg.Conditions),
+		evaluator:  transform.NewKazaamRuleEngine(),
+	}
+	for i, condition := range route.conditions {
+		if err := condition.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid condition %d: %w", i, err)
+		}
+	}
+	return route, nil
+}
+
+// routeName returns the name of a route of a stream
+func routeName(stream, route string) string {
+	return stream + "/" + route
+}
+
+// Name returns the name of the route, stream/route
+func (r *Route) Name() string {
+	return r.name
+}
+
+// Stream returns the name of the stream of the route
+func (r *Route) Stream() string {
+	return r.stream
+}
+
+// Match reports whether a payload of the stream meets the conditions of the
+// route. A condition that cannot be evaluated is not met.
+func (r *Route) Match(payload map[string]interface{}) bool {
+	r.seen.Add(1)
+	if len(r.conditions) > 0 {
+		met, err := r.evaluator.EvaluateConditions(context.Background(), payload, r.conditions)
+		if err != nil || !met {
+			return false
+		}
+	}
+	r.routed.Add(1)
+	return true
+}
+
+// Seen returns the number of payloads the route tested
+func (r *Route) Seen() int64 {
+	return r.seen.Load()
+}
+
+// Routed returns the number of payloads the route sent to its target
+func (r *Route) Routed() int64 {
+	return r.routed.Load()
+}
@@ -0,0 +1,211 @@
+package replicator
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cohenjo/replicator/pkg/config"
+	"github.com/cohenjo/replicator/pkg/dlq"
+	"github.com/cohenjo/replicator/pkg/events"
+	"github.com/cohenjo/replicator/pkg/models"
+	"github.com/cohenjo/replicator/pkg/transform"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingWriter records the events written to it
+type recordingWriter struct {
+	events []map[string]interface{}
+}
+
+func (w *recordingWriter) WriteEvent(ctx context.Context, event map[string]interface{}) error {
+	w.events = append(w.events, event)
+	return nil
+}
+
+func (w *recordingWriter) Close() error {
+	return nil
+}
+
+// routedService returns a service writing the orders stream to its target and
+// to the routes, whose writers are returned by route name
+func routedService(t *testing.T, routes ...config.RouteConfig) (*Service, *recordingWriter, map[string]*recordingWriter) {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	service := &Service{
+		logger:           logger,
+		transformEngines: make(map[string]*transform.Engine),
+		routes:           make(map[string][]*Route),
+		estuaries:        make(map[string]*ResilientWriter),
+	}
+
+	target := &recordingWriter{}
+	service.estuaries["orders"] = NewResilientWriter("orders", target, models.RetryPolicy{}, nil, nil, nil)
+	writers := make(map[string]*recordingWriter)
+	for _, routeConfig := range routes {
+		route, err := NewRoute("orders", routeConfig)
+		require.NoError(t, err)
+		route.engine, err = service.createRulesEngine(route.Name(), routeConfig.Transformation)
+		require.NoError(t, err)
+		writer := &recordingWriter{}
+		route.writer = NewResilientWriter(route.Name(), writer, models.RetryPolicy{}, nil, nil, nil)
+		service.estuaries[route.Name()] = route.writer
+		service.routes["orders"] = append(service.routes["orders"], route)
+		writers[routeConfig.Name] = writer
+	}
+	return service, target, writers
+}
+
+func routingBatch() []events.RecordEvent {
+	return []events.RecordEvent{
+		{Action: "insert", Collection: "orders", Data: []byte(`{"id":1}`), Stream: "orders"},
+		{Action: "insert", Collection: "customers", Data: []byte(`{"id":2}`), Stream: "orders"},
+		{Action: "delete", Collection: "orders", OldData: []byte(`{"id":3}`), Stream: "orders"},
+	}
+}
+
+func TestApplyBatchRoutesEvents(t *testing.T) {
+	service, target, writers := routedService(t,
+		config.RouteConfig{
+			Name:       "search",
+			Conditions: []config.Condition{{Field: "collection", Operator: "eq", Value: "orders"}},
+		},
+		config.RouteConfig{
+			Name:       "audit",
+			Conditions: []config.Condition{{Field: "action", Operator: "eq", Value: "delete"}},
+			Transformation: &config.TransformationRulesConfig{
+				Enabled: true,
+				Rules: []config.TransformationRule{{
+					Name:    "audit",
+					Enabled: true,
+					Actions: []config.Action{{Type: "kazaam", Spec: `[{"operation": "default", "spec": {"audited": true}}]`}},
+				}},
+			},
+		},
+	)
+
+	failed, err := service.applyBatch(context.Background(), "orders", routingBatch(), false)
+	require.NoError(t, err)
+	assert.Equal(t, 0, failed)
+
+	assert.Len(t, target.events, 3, "the target of the stream receives every event")
+	require.Len(t, writers["search"].events, 2)
+	assert.Equal(t, "insert", writers["search"].events[0]["action"])
+	assert.Equal(t, "delete", writers["search"].events[1]["action"])
+	assert.NotContains(t, writers["search"].events[1], "audited", "routes do not see the transformations of other routes")
+	require.Len(t, writers["audit"].events, 1)
+	assert.Equal(t, "delete", writers["audit"].events[0]["action"])
+	assert.Equal(t, true, writers["audit"].events[0]["audited"])
+
+	route := service.route("orders/search")
+	require.NotNil(t, route)
+	assert.Equal(t, int64(3), route.Seen())
+	assert.Equal(t, int64(2), route.Routed())
+	assert.Nil(t, service.route("orders/archive"))
+}
+
+func TestApplyBatchRouteDeadLetter(t *testing.T) {
+	service, target, writers := routedService(t,
+		config.RouteConfig{Name: "all"},
+		config.RouteConfig{
+			Name: "strict",
+			Transformation: &config.TransformationRulesConfig{
+				Enabled: true,
+				Rules: []config.TransformationRule{{
+					Name:          "require_total",
+					Enabled:       true,
+					Conditions:    []config.Condition{{Field: "collection", Operator: "eq", Value: "customers"}},
+					Actions:       []config.Action{{Type: "jq", Spec: `error("no total")`}},
+					ErrorHandling: config.ErrorHandlingPolicy{Strategy: "dead_letter", DeadLetterTopic: "dlq"},
+				}},
+			},
+		},
+	)
+
+	failed, err := service.applyBatch(context.Background(), "orders", routingBatch(), false)
+	assert.Error(t, err)
+	assert.Equal(t, 1, failed)
+	assert.Len(t, target.events, 3)
+	assert.Len(t, writers["all"].events, 3)
+	assert.Len(t, writers["strict"].events, 2, "the other events of the route are written")
+
+	// Entries of a route are replayed to the route alone
+	event := routingBatch()[0]
+	entry := dlq.NewEntry("orders/strict", dlq.StageTransform, transform.ErrDeadLetter, 1, &event, nil)
+	require.NoError(t, service.ReplayDeadLetter(context.Background(), entry))
+	assert.Len(t, writers["strict"].events, 3)
+	assert.Len(t, writers["all"].events, 3)
+	assert.Len(t, target.events, 3)
+}
+
+func TestApplyBatchFailingRoute(t *testing.T) {
+	service, target, writers := routedService(t,
+		config.RouteConfig{Name: "all"},
+		config.RouteConfig{Name: "search", Conditions: []config.Condition{{Field: "collection", Operator: "eq", Value: "orders"}}},
+	)
+	queue, err := dlq.NewFileQueue(filepath.Join(t.TempDir(), "dlq.jsonl"))
+	require.NoError(t, err)
+	service.deadLetters = queue
+
+	// The target of the search route is down
+	down := &scriptedWriter{errs: make([]error, 10)}
+	for i := range down.errs {
+		down.errs[i] = connectionError()
+	}
+	breaker := NewCircuitBreaker(models.CircuitBreakerConfig{Enabled: true, FailureThreshold: 1, RecoveryTimeout: time.Minute, HalfOpenRequests: 1})
+	route := service.route("orders/search")
+	route.writer = NewResilientWriter(route.Name(), down, models.RetryPolicy{}, breaker, nil, nil).FailFast()
+	service.estuaries[route.Name()] = route.writer
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		failed, err := service.applyBatch(ctx, "orders", routingBatch(), true)
+		require.NoError(t, err, "the events of the failing route are dead-lettered")
+		assert.Equal(t, 0, failed)
+	}
+	require.NoError(t, ctx.Err(), "the open breaker of the route does not block the stream")
+	assert.Equal(t, BreakerOpen, breaker.State())
+	assert.Equal(t, 1, down.writes, "the second batch fails fast")
+	assert.Len(t, target.events, 6)
+	assert.Len(t, writers["all"].events, 6)
+
+	entries, err := queue.List(ctx, dlq.Filter{})
+	require.NoError(t, err)
+	require.Len(t, entries, 4)
+	for _, entry := range entries {
+		assert.Equal(t, "orders/search", entry.Stream)
+		assert.Equal(t, dlq.StageWrite, entry.Stage)
+	}
+
+	// Without a dead-letter queue the events of the route fail
+	failed, err := service.applyBatch(ctx, "orders", routingBatch(), false)
+	assert.ErrorIs(t, err, ErrBreakerOpen)
+	assert.Equal(t, 2, failed)
+	assert.Len(t, writers["all"].events, 9)
+}
+
+func TestApplyBatchRoutesWithoutStreamTarget(t *testing.T) {
+	service, _, writers := routedService(t, config.RouteConfig{
+		Name:       "deletes",
+		Conditions: []config.Condition{{Field: "action", Operator: "in", Value: []interface{}{"delete"}}},
+	})
+	delete(service.estuaries, "orders")
+
+	failed, err := service.applyBatch(context.Background(), "orders", routingBatch(), false)
+	require.NoError(t, err)
+	assert.Equal(t, 0, failed)
+	assert.Len(t, writers["deletes"].events, 1)
+}
+
+func TestNewRouteInvalidCondition(t *testing.T) {
+	_, err := NewRoute("orders", config.RouteConfig{
+		Name:       "search",
+		Conditions: []config.Condition{{Field: "collection", Operator: "like", Value: "orders"}},
+	})
+	assert.Error(t, err)
+}
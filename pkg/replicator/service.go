@@ -2,6 +2,7 @@ package replicator
 
 import (
 "context"
+"errors"
 "fmt"
 "sync"
 "time"
@@ -9,6 +10,8 @@ import (
 "github.com/cohenjo/replicator/pkg/api"
 "github.com/cohenjo/replicator/pkg/auth"
 "github.com/cohenjo/replicator/pkg/config"
+"github.com/cohenjo/replicator/pkg/dlq"
+"github.com/cohenjo/replicator/pkg/estuary"
 "github.com/cohenjo/replicator/pkg/events"
 "github.com/cohenjo/replicator/pkg/metrics"
 "github.com/cohenjo/replicator/pkg/models"
@@ -32,14 +35,21 @@ type Service struct {
 	streamManager    *StreamManager
 	apiServer        *api.ServerV2
 	authProvider     auth.Provider
+	secrets          auth.SecretProvider                  // keys of the transformations
+	referenceStores  map[string]*transform.ReferenceStore // stores of the lookup actions, by name
 	metricsCollector *metrics.TelemetryManager
-	transformEngine  *transform.Engine
+	transformEngines map[string]*transform.Engine // transformation rules, by stream
+	filters          map[string]*FilterStage      // event filters, by stream
+	routes           map[string][]*Route          // routes of the events, by stream
+	destinations     *estuary.DefaultDestinationManager
 	shutdownHandler  *ShutdownHandler
-	eventChannel     chan events.RecordEvent
+	applyStages      map[string]*ApplyStage // stages applying the events of the sources to the targets, by stream
 	shutdownChannel  chan struct{}
 	status           ServiceStatus
 	startTime        time.Time
-	estuaries        []EstuaryWriter
+	estuaries        map[string]*ResilientWriter // estuary writers, by stream and by route
+	deadLetters      dlq.Queue                   // nil when the dead-letter queue is disabled
+	breakers         map[string]*CircuitBreaker  // circuit breakers of the targets, by stream and by route
 	wg               sync.WaitGroup
 	mu               sync.RWMutex
 }
@@ -59,9 +69,8 @@ const (
 
 // ServiceOptions represents configuration options for the service
 type ServiceOptions struct {
-	Config       *config.Config
-	Logger       *logrus.Logger
-	EventBuffer  int
+	Config *config.Config
+	Logger *logrus.Logger
 }
 
 // NewService creates a new replicator service instance
@@ -74,18 +83,10 @@ func NewService(opts ServiceOptions) (*Service, error) {
 		opts.Logger = logrus.New()
 	}
 	
-	if opts.EventBuffer == 0 {
-		opts.EventBuffer = 10000 // Default buffer size
-	}
-	
-	// Create event channel
-	eventChannel := make(chan events.RecordEvent, opts.EventBuffer)
-	
 	// Create stream manager
 	streamManager := &StreamManager{
 		streams:      make(map[string]models.Stream),
 		streamStates: make(map[string]models.StreamState),
-		eventChannel: eventChannel,
 		logger:       opts.Logger,
 	}
 	
@@ -101,15 +102,37 @@ func NewService(opts ServiceOptions) (*Service, error) {
 		return nil, fmt.Errorf("failed to create auth provider: %w", err)
 	}
 	
-	// Create transform engine
-	transformConfig := transform.DefaultTransformationConfig()
-	transformEngine := transform.NewEngine(transformConfig)
+	// Create secret provider
+	secrets, err := auth.NewSecretProvider(opts.Config.Secrets, opts.Config.Azure)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create secret provider: %w", err)
+	}
+	
+	// Open reference stores
+	referenceStores, err := transform.NewReferenceStores(opts.Config.ReferenceStores)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open reference stores: %w", err)
+	}
+	
+	// Create destination manager
+	destinations := estuary.NewDestinationManager()
+	
+	// Create dead-letter queue
+	var deadLetters dlq.Queue
+	if opts.Config.DeadLetter.Enabled {
+		deadLetters, err = dlq.New(opts.Config.DeadLetter)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create dead-letter queue: %w", err)
+		}
+	}
 	
 	// Create API server
 	apiServer, err := api.NewServerV2(api.ServerV2Config{
 		Config:          opts.Config,
 		StreamManager:   streamManager,
 		MetricsCollector: metricsCollector,
+		Destinations:    destinations.Registry(),
+		DeadLetters:     deadLetters,
 		Logger:          opts.Logger,
 	})
 	if err != nil {
@@ -123,11 +146,20 @@ func NewService(opts ServiceOptions) (*Service, error) {
 		apiServer:       apiServer,
 		metricsCollector: metricsCollector,
 		authProvider:    authProvider,
-		transformEngine: transformEngine,
-		eventChannel:    eventChannel,
+		secrets:         secrets,
+		referenceStores: referenceStores,
+		transformEngines: make(map[string]*transform.Engine),
+		filters:         make(map[string]*FilterStage),
+		routes:          make(map[string][]*Route),
+		destinations:    destinations,
+		deadLetters:     deadLetters,
+		breakers:        make(map[string]*CircuitBreaker),
+		applyStages:     make(map[string]*ApplyStage),
+		estuaries:       make(map[string]*ResilientWriter),
 		shutdownChannel: make(chan struct{}),
 		status:          StatusStopped,
 	}
+	apiServer.SetDeadLetterReplay(service.ReplayDeadLetter)
 	
 	return service, nil
 }
@@ -152,15 +184,23 @@ func (s *Service) Start(ctx context.Context) error {
 		return fmt.Errorf("failed to start metrics collector: %w", err)
 	}
 	
+	// Start destination health monitoring
+	if err := s.destinations.Start(ctx); err != nil {
+		s.status = StatusError
+		return fmt.Errorf("failed to start destination manager: %w", err)
+	}
+	
 	// Initialize streams from configuration
 	if err := s.initializeStreams(ctx); err != nil {
 		s.status = StatusError
 		return fmt.Errorf("failed to initialize streams: %w", err)
 	}
 	
-	// Start event processor
-	s.wg.Add(1)
-	go s.processEvents(ctx)
+	// Start the stages applying the events of each stream to its target
+	for name, stage := range s.applyStages {
+		s.wg.Add(1)
+		go s.processEvents(ctx, name, stage)
+	}
 	
 	// Start stream monitoring
 	s.wg.Add(1)
@@ -215,11 +255,28 @@ func (s *Service) Start(ctx context.Context) error {
 				s.logger.WithError(err).Error("Failed to stop API server")
 			}
 			
+			// Close destinations
+			if err := s.destinations.Stop(ctx); err != nil {
+				s.logger.WithError(err).Error("Failed to close some destinations")
+			}
+			
 			// Stop metrics collector
 			if err := s.metricsCollector.Stop(ctx); err != nil {
 				s.logger.WithError(err).Error("Failed to stop metrics collector")
 			}
 			
+			// Close dead-letter queue
+			if s.deadLetters != nil {
+				if err := s.deadLetters.Close(); err != nil {
+					s.logger.WithError(err).Error("Failed to close dead-letter queue")
+				}
+			}
+			
+			// Close reference stores
+			if err := transform.CloseReferenceStores(s.referenceStores); err != nil {
+				s.logger.WithError(err).Error("Failed to close some reference stores")
+			}
+			
 			// Wait for all goroutines to finish
 			done := make(chan struct{})
 			go func() {
@@ -261,6 +318,8 @@ func (s *Service) Start(ctx context.Context) error {
 				streamStates := make(map[string]models.StreamState)
 				for name, stream := range s.streamManager.streams {
 					state := stream.GetState()
+					stats := s.eventStatistics(name, stream)
+					state.Statistics = &stats
 					streamStates[name] = state
 					
 					// If any stream is in error state, mark service as degraded
@@ -272,11 +331,49 @@ func (s *Service) Start(ctx context.Context) error {
 				// Perform health checks
 				checks := make(map[string]models.CheckResult)
 				
-				// Database connectivity check
-				checks["database"] = models.CheckResult{
-					Status:    "pass",
-					Message:   "All database connections healthy",
-					Timestamp: time.Now(),
+				// Destination connectivity checks, from the last health check of the manager
+				for _, name := range s.destinations.Registry().ListDestinations() {
+					health, err := s.destinations.GetDestinationHealth(name)
+					if err != nil {
+						continue
+					}
+					responseTime := health.ResponseTime
+					check := models.CheckResult{
+						Status:    "pass",
+						Message:   health.Message,
+						Timestamp: health.LastCheck,
+						Duration:  &responseTime,
+					}
+					switch health.Status {
+					case estuary.HealthDegraded:
+						check.Status = "warn"
+					case estuary.HealthUnhealthy:
+						check.Status = "fail"
+						if status == "healthy" {
+							status = "degraded"
+						}
+					}
+					checks["destination:"+name] = check
+				}
+				
+				// Circuit breaker checks, an open breaker has paused its stream
+				for name, breaker := range s.breakers {
+					snapshot := breaker.Snapshot()
+					check := models.CheckResult{
+						Status:    "pass",
+						Message:   fmt.Sprintf("circuit breaker %s, %d consecutive failures", snapshot.State, snapshot.ConsecutiveFailures),
+						Timestamp: time.Now(),
+					}
+					switch snapshot.State {
+					case BreakerHalfOpen:
+						check.Status = "warn"
+					case BreakerOpen:
+						check.Status = "fail"
+						if status == "healthy" {
+							status = "degraded"
+						}
+					}
+					checks["circuit_breaker:"+name] = check
 				}
 				
 				// Memory check
@@ -309,11 +406,45 @@ func (s *Service) Start(ctx context.Context) error {
 						continue
 					}
 					
-					stream, err := s.createStream(streamConfig)
+					batchConfig, err := batchConfigForStream(streamConfig)
+					if err != nil {
+						return fmt.Errorf("failed to configure batching of stream %s: %w", streamConfig.Name, err)
+					}
+					eventChannel := make(chan events.RecordEvent, batchConfig.Buffer)
+					
+					engine, err := s.createTransformEngine(streamConfig)
+					if err != nil {
+						return fmt.Errorf("failed to configure transformations of stream %s: %w", streamConfig.Name, err)
+					}
+					if engine != nil {
+						s.transformEngines[streamConfig.Name] = engine
+					}
+					
+					if streamConfig.Filter != nil {
+						filter, err := NewFilterStage(eventFilterFromConfig(streamConfig.Filter))
+						if err != nil {
+							return fmt.Errorf("failed to configure the filter of stream %s: %w", streamConfig.Name, err)
+						}
+						s.filters[streamConfig.Name] = filter
+					}
+					
+					stream, err := s.createStream(streamConfig, eventChannel)
 					if err != nil {
 						return fmt.Errorf("failed to create stream %s: %w", streamConfig.Name, err)
 					}
 					
+					name := streamConfig.Name
+					s.applyStages[name] = NewApplyStage(name, batchConfig, workersForStream(streamConfig), eventChannel, func(ctx context.Context, batch []events.RecordEvent) bool {
+						return s.handleBatch(ctx, name, batch)
+					})
+					if committer, ok := stream.(models.PositionCommitter); ok {
+						s.applyStages[name].Acks().OnAcknowledged(func(position map[string]interface{}) {
+							if err := committer.CommitPosition(position); err != nil {
+								s.logger.WithError(err).WithField("stream", name).Warn("Failed to commit source position")
+							}
+						})
+					}
+					
 					s.streamManager.streams[streamConfig.Name] = stream
 					s.streamManager.streamStates[streamConfig.Name] = models.StreamState{
 						Name:   streamConfig.Name,
@@ -323,14 +454,17 @@ func (s *Service) Start(ctx context.Context) error {
 					// Create EstuaryWriter instances for the target configuration
 					if streamConfig.Target.Type != "" {
 						log.Debug().Str("stream", streamConfig.Name).Str("target_type", string(streamConfig.Target.Type)).Str("host", streamConfig.Target.Host).Msg("Creating EstuaryWriter")
-						estuary, err := s.createEstuaryWriter(streamConfig.Target)
+						estuary, err := s.createEstuaryWriter(ctx, streamConfig.Name, targetConfigForStream(streamConfig), transactionsEnabled(streamConfig))
 						if err != nil {
 							log.Error().Err(err).Str("stream", streamConfig.Name).Msg("Failed to create estuary writer")
 							return fmt.Errorf("failed to create estuary writer for stream %s: %w", streamConfig.Name, err)
 						}
-						
-						s.estuaries = append(s.estuaries, estuary)
-						log.Debug().Int("total_estuaries", len(s.estuaries)).Msg("EstuaryWriter added to estuaries slice")
+						resilient, err := s.newResilientWriter(streamConfig.Name, streamConfig.Name, streamConfig.Target, estuary)
+						if err != nil {
+							return fmt.Errorf("failed to configure estuary writer for stream %s: %w", streamConfig.Name, err)
+						}
+						s.estuaries[streamConfig.Name] = resilient
+						log.Debug().Int("total_estuaries", len(s.estuaries)).Msg("EstuaryWriter added to estuaries")
 						s.logger.WithFields(logrus.Fields{
 							"stream": streamConfig.Name,
 							"target_type": streamConfig.Target.Type,
@@ -339,6 +473,14 @@ func (s *Service) Start(ctx context.Context) error {
 					} else {
 						log.Debug().Str("stream", streamConfig.Name).Msg("No target configuration for stream")
 					}
+					
+					for _, routeConfig := range streamConfig.Routes {
+						route, err := s.createRoute(ctx, streamConfig, routeConfig)
+						if err != nil {
+							return fmt.Errorf("failed to configure route %s of stream %s: %w", routeConfig.Name, streamConfig.Name, err)
+						}
+						s.routes[streamConfig.Name] = append(s.routes[streamConfig.Name], route)
+					}
 							
 					s.logger.WithField("stream", streamConfig.Name).Info("Stream initialized")
 				}
@@ -346,35 +488,134 @@ func (s *Service) Start(ctx context.Context) error {
 				return nil
 			}
 					
-// createStream creates a stream instance based on configuration
-func (s *Service) createStream(streamConfig config.StreamConfig) (models.Stream, error) {
+// createTransformEngine creates the transformation engine of a stream from
+// its rules, nil when the stream transforms nothing. The rule executions are
+// recorded with the stream as a label.
+func (s *Service) createTransformEngine(streamConfig config.StreamConfig) (*transform.Engine, error) {
+	return s.createRulesEngine(streamConfig.Name, streamConfig.Transformation)
+}
+
+// createRulesEngine creates the transformation engine of a stream or route
+// from its rules, nil when they transform nothing
+func (s *Service) createRulesEngine(name string, rules *config.TransformationRulesConfig) (*transform.Engine, error) {
+	if rules == nil || !rules.Enabled {
+		return nil, nil
+	}
+	transformConfig, err := transform.ConfigForStream(name, *rules)
+	if err != nil {
+		return nil, err
+	}
+	engine := transform.NewEngine(transformConfig)
+	if s.secrets != nil {
+		engine.SetSecretProvider(s.secrets)
+	}
+	engine.SetReferenceStores(s.referenceStores)
+	if err := engine.ValidateRules(transformConfig.Rules); err != nil {
+		return nil, err
+	}
+	if s.metricsCollector != nil && transformConfig.Metrics.Enabled {
+		collector := s.metricsCollector
+		engine.SetRuleObserver(func(stream, rule string, success bool, duration time.Duration) {
+			collector.RecordTransformationRule(context.Background(), stream, rule, duration, success)
+		})
+	}
+	s.logger.WithFields(logrus.Fields{
+		"stream": name,
+		"rules":  len(transformConfig.Rules),
+	}).Info("Transformation engine initialized")
+	return engine, nil
+}
+
+// createRoute creates a route of a stream with its transformation engine and
+// the writer of its target, which are named after the route
+func (s *Service) createRoute(ctx context.Context, streamConfig config.StreamConfig, routeConfig config.RouteConfig) (*Route, error) {
+	route, err := NewRoute(streamConfig.Name, routeConfig)
+	if err != nil {
+		return nil, err
+	}
+	if route.engine, err = s.createRulesEngine(route.Name(), routeConfig.Transformation); err != nil {
+		return nil, fmt.Errorf("failed to configure transformations: %w", err)
+	}
+	estuary, err := s.createEstuaryWriter(ctx, route.Name(), routeConfig.Target, transactionsEnabled(streamConfig))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create estuary writer: %w", err)
+	}
+	if route.writer, err = s.newResilientWriter(streamConfig.Name, route.Name(), routeConfig.Target, estuary); err != nil {
+		return nil, fmt.Errorf("failed to configure estuary writer: %w", err)
+	}
+	s.estuaries[route.Name()] = route.writer
+	s.logger.WithFields(logrus.Fields{
+		"stream":      streamConfig.Name,
+		"route":       route.Name(),
+		"conditions":  len(routeConfig.Conditions),
+		"target_type": routeConfig.Target.Type,
+	}).Info("Route initialized")
+	return route, nil
+}
+
+// createStream creates a stream instance based on configuration, sending its
+// events to the channel
+func (s *Service) createStream(streamConfig config.StreamConfig, eventChannel chan<- events.RecordEvent) (models.Stream, error) {
 	log.Debug().Str("name", streamConfig.Name).Str("source_type", string(streamConfig.Source.Type)).Msg("createStream called")
 	
 	// Create appropriate stream based on source type
 	switch streamConfig.Source.Type {
 	case "mongodb":
 		log.Debug().Msg("Creating MongoDB stream")
-		return streams.NewMongoDBStream(streamConfig, s.eventChannel)
+		return streams.NewMongoDBStream(streamConfig, eventChannel)
 	case "mysql":
 		log.Debug().Msg("Creating MySQL stream")
-		return streams.NewMySQLStream(streamConfig, s.eventChannel)
+		return streams.NewMySQLStream(streamConfig, eventChannel)
 	case "postgresql":
 		log.Debug().Msg("Creating PostgreSQL stream")
-		return streams.NewPostgreSQLStream(streamConfig, s.eventChannel)
+		return streams.NewPostgreSQLStream(streamConfig, eventChannel)
 	case "kafka":
 		log.Debug().Msg("Creating Kafka stream")
-		return streams.NewKafkaStream(streamConfig, s.eventChannel)
+		return streams.NewKafkaStream(streamConfig, eventChannel)
+	case "cosmosdb":
+		log.Debug().Msg("Creating Cosmos DB stream")
+		return streams.NewCosmosDBStream(streamConfig, eventChannel)
 	default:
 		log.Error().Str("source_type", string(streamConfig.Source.Type)).Msg("Unsupported stream type")
 		return nil, fmt.Errorf("stream type %s not yet implemented", streamConfig.Source.Type)
 	}
 }
 					
-// createEstuaryWriter creates an EstuaryWriter instance based on target configuration
-func (s *Service) createEstuaryWriter(targetConfig config.TargetConfig) (EstuaryWriter, error) {
-	bridge, err := NewEstuaryBridge(targetConfig)
+// targetConfigForStream returns the stream's target configuration with
+// stream-level settings that the estuary needs folded into its options
+func targetConfigForStream(streamConfig config.StreamConfig) config.TargetConfig {
+	target := streamConfig.Target
+	if streamConfig.DeliveryGuarantee != config.DeliveryExactlyOnce {
+		return target
+	}
+
+	// Copy the options so the stream configuration is left untouched
+	options := make(map[string]interface{}, len(target.Options)+1)
+	for k, v := range target.Options {
+		options[k] = v
+	}
+	// Exactly-once needs a transactional id that is stable across restarts
+	if _, ok := options["transactional_id"]; !ok {
+		options["transactional_id"] = "replicator-" + streamConfig.Name
+	}
+	target.Options = options
+	return target
+}
+
+// createEstuaryWriter creates the destination of a stream through the
+// destination manager and an EstuaryWriter writing to it, in destination
+// transactions when the stream applies source transactions atomically
+func (s *Service) createEstuaryWriter(ctx context.Context, name string, targetConfig config.TargetConfig, transactions bool) (EstuaryWriter, error) {
+	if err := s.destinations.CreateDestination(ctx, name, targetConfig); err != nil {
+		return nil, fmt.Errorf("failed to create destination: %w", err)
+	}
+	destination, err := s.destinations.GetDestination(name)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create estuary bridge: %w", err)
+		return nil, fmt.Errorf("failed to get destination: %w", err)
+	}
+	bridge := NewEstuaryBridge(name, destination)
+	if transactions {
+		bridge.WithTransactions()
 	}
 	
 	s.logger.WithFields(logrus.Fields{
@@ -387,37 +628,344 @@ func (s *Service) createEstuaryWriter(targetConfig config.TargetConfig) (Estuary
 		return bridge, nil
 	}
 	
-	// processEvents processes events from the event channel
-	func (s *Service) processEvents(ctx context.Context) {
-		defer s.wg.Done()
-		
-		s.logger.Info("Starting event processor")
-		
-		for {
-			select {
-			case <-ctx.Done():
-				s.logger.Info("Event processor stopping due to context cancellation")
+// newResilientWriter wraps the writer of a stream or route with the retry
+// policy and circuit breaker of its target. An open breaker of the stream
+// pauses it so its source stops reading until the target recovers. Routes
+// fail fast instead, so a route that is down does not hold back the
+// stream and its other routes: its events fail or are dead-lettered.
+func (s *Service) newResilientWriter(stream, name string, target config.TargetConfig, writer EstuaryWriter) (*ResilientWriter, error) {
+	policy, err := retryPolicyForTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	breakerConfig, err := circuitBreakerForTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	if !breakerConfig.Enabled {
+		return NewResilientWriter(name, writer, policy, nil, nil, nil), nil
+	}
+
+	breaker := NewCircuitBreaker(breakerConfig)
+	s.breakers[name] = breaker
+	if name != stream {
+		return NewResilientWriter(name, writer, policy, breaker, nil, nil).FailFast(), nil
+	}
+	onOpen := func(ctx context.Context) {
+		if source, ok := s.streamManager.streams[stream]; ok {
+			if err := source.Pause(ctx); err != nil {
+				s.logger.WithError(err).WithField("stream", stream).Warn("Failed to pause stream while circuit breaker is open")
 				return
-			case <-s.shutdownChannel:
-				s.logger.Info("Event processor stopping due to shutdown signal")
+			}
+			s.logger.WithFields(logrus.Fields{"stream": stream, "writer": name}).Warn("Stream paused, circuit breaker opened")
+		}
+	}
+	onClose := func(ctx context.Context) {
+		if source, ok := s.streamManager.streams[stream]; ok {
+			if err := source.Resume(ctx); err != nil {
+				s.logger.WithError(err).WithField("stream", stream).Warn("Failed to resume stream after circuit breaker closed")
 				return
-			case event := <-s.eventChannel:
-				if err := s.handleEvent(ctx, event); err != nil {
-					s.logger.WithError(err).Error("Failed to process event")
-					s.metricsCollector.IncrementCounter("events_failed_total", 1)
-					} else {
-						s.metricsCollector.IncrementCounter("events_processed_total", 1)
-					}
-				}
 			}
+			s.logger.WithFields(logrus.Fields{"stream": stream, "writer": name}).Info("Stream resumed, circuit breaker closed")
 		}
-							
-// handleEvent processes a single event
-func (s *Service) handleEvent(ctx context.Context, event events.RecordEvent) error {
+	}
+	return NewResilientWriter(name, writer, policy, breaker, onOpen, onClose), nil
+}
+
+// processEvents runs the apply stage of a stream until shutdown
+func (s *Service) processEvents(ctx context.Context, name string, stage *ApplyStage) {
+	defer s.wg.Done()
+	
+	batchConfig := stage.BatchConfig()
+	s.logger.WithFields(logrus.Fields{
+		"stream":       name,
+		"workers":      stage.Workers(),
+		"batch_size":   batchConfig.Size,
+		"batch_bytes":  batchConfig.Bytes,
+		"batch_linger": batchConfig.Linger,
+		"buffer_size":  batchConfig.Buffer,
+		"transactions": batchConfig.MaxTransactionEvents > 0,
+	}).Info("Starting event processor")
+	
+	stage.Run(ctx, s.shutdownChannel)
+	s.logger.WithField("stream", name).Info("Event processor stopped")
+}
+
+// handleBatch processes a batch of events of a stream, sending the events
+// that fail to transform or to be written to the dead-letter queue. It
+// reports whether every event was written or dead-lettered.
+func (s *Service) handleBatch(ctx context.Context, stream string, batch []events.RecordEvent) bool {
+	failed, err := s.applyBatch(ctx, stream, batch, s.deadLetters != nil)
+	if err != nil {
+		s.logger.WithError(err).WithField("stream", stream).Error("Failed to process events")
+	}
+	if s.metricsCollector == nil {
+		return failed == 0
+	}
+	// Events written to several targets may fail more than once
+	s.metricsCollector.IncrementCounter("events_processed_total", int64(max(len(batch)-failed, 0)))
+	if failed > 0 {
+		s.metricsCollector.IncrementCounter("events_failed_total", int64(failed))
+	}
+	s.metricsCollector.IncrementCounter("batches_processed_total", 1)
+	s.metricsCollector.SetGauge("stream_batch_size", float64(len(batch)), map[string]string{
+		"stream": stream,
+	})
+	return failed == 0
+}
+
+// applyEvent transforms an event and writes it to the estuary of its stream.
+// Without deadLetter failures are returned rather than sent to the
+// dead-letter queue.
+func (s *Service) applyEvent(ctx context.Context, event events.RecordEvent, deadLetter bool) error {
+	_, err := s.applyBatch(ctx, event.Stream, []events.RecordEvent{event}, deadLetter)
+	return err
+}
+
+// applyBatch filters and transforms a batch of events of a stream and writes
+// them to its estuary with a single batch write, and to the targets of its
+// routes. It returns the number of events that failed, counted once per
+// target. Without deadLetter failures are returned rather than sent to the
+// dead-letter queue.
+func (s *Service) applyBatch(ctx context.Context, stream string, batch []events.RecordEvent, deadLetter bool) (int, error) {
+	pending := make([]events.RecordEvent, 0, len(batch))
+	payloads := make([]map[string]interface{}, 0, len(batch))
+	failed := 0
+	var errs []error
+	filter := s.filters[stream]
+	if filter != nil {
+		// Events the filter of the stream excludes are skipped
+		included := batch[:0:0]
+		for _, event := range batch {
+			if filter.Include(event) {
+				included = append(included, event)
+			}
+		}
+		batch = included
+	}
+	s.prepareBatch(ctx, stream, batch)
+	for _, event := range batch {
+		eventPayloads, err := s.transformEvent(ctx, event, deadLetter)
+		if err != nil {
+			failed++
+			errs = append(errs, err)
+			continue
+		}
+		// Skipped and dead-lettered events have no payload, events fanned
+		// out by the transformations have several
+		for _, payload := range eventPayloads {
+			pending = append(pending, event)
+			payloads = append(payloads, payload)
+		}
+	}
+	if len(payloads) == 0 {
+		return failed, errors.Join(errs...)
+	}
+
+	routes := s.routes[stream]
+	if writer, ok := s.estuaries[stream]; ok {
+		writeFailed, err := s.writeBatch(ctx, writer, pending, payloads, deadLetter)
+		failed += writeFailed
+		if err != nil {
+			errs = append(errs, err)
+		}
+	} else if len(routes) == 0 {
+		log.Debug().Str("stream", stream).Int("events", len(payloads)).Msg("Service.applyBatch: no estuary for stream")
+	}
+	for _, route := range routes {
+		routeFailed, err := s.applyRoute(ctx, route, pending, payloads, deadLetter)
+		failed += routeFailed
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return failed, errors.Join(errs...)
+}
+
+// writeBatch writes the payloads of a batch of events with a single batch
+// write and returns the number of events that failed
+func (s *Service) writeBatch(ctx context.Context, writer *ResilientWriter, batch []events.RecordEvent, payloads []map[string]interface{}, deadLetter bool) (int, error) {
+	if len(payloads) == 0 {
+		return 0, nil
+	}
+	// Retries and the circuit breaker are handled by the writer, an error here
+	// means the batch could not be written
+	log.Debug().Str("estuary", writer.Name()).Int("events", len(payloads)).Msg("Service.writeBatch: writing batch to estuary")
+	if err := writer.WriteBatch(ctx, payloads); err != nil {
+		return s.batchFailed(ctx, writer, batch, payloads, err, deadLetter)
+	}
+	return 0, nil
+}
+
+// applyRoute writes the payloads of a batch of events that meet the
+// conditions of a route to its target, transformed by the rules of the
+// route. It returns the number of events that failed.
+func (s *Service) applyRoute(ctx context.Context, route *Route, batch []events.RecordEvent, payloads []map[string]interface{}, deadLetter bool) (int, error) {
+	routedEvents := make([]events.RecordEvent, 0, len(batch))
+	routed := make([]map[string]interface{}, 0, len(payloads))
+	for i, payload := range payloads {
+		if route.Match(payload) {
+			routedEvents = append(routedEvents, batch[i])
+			routed = append(routed, payload)
+		}
+	}
+	if len(routed) == 0 || route.engine == nil {
+		return s.writeBatch(ctx, route.writer, routedEvents, routed, deadLetter)
+	}
+
+	if len(routed) > 1 {
+		if err := route.engine.PrepareBatch(ctx, routed); err != nil {
+			s.logger.WithError(err).WithField("route", route.Name()).Warn("Failed to prepare the transformation of a batch")
+		}
+	}
+	pending := make([]events.RecordEvent, 0, len(routed))
+	transformed := make([]map[string]interface{}, 0, len(routed))
+	failed := 0
+	var errs []error
+	for i, payload := range routed {
+		outputs, err := s.transformRoute(ctx, route, routedEvents[i], payload, deadLetter)
+		if err != nil {
+			failed++
+			errs = append(errs, err)
+			continue
+		}
+		for _, output := range outputs {
+			pending = append(pending, routedEvents[i])
+			transformed = append(transformed, output)
+		}
+	}
+	writeFailed, err := s.writeBatch(ctx, route.writer, pending, transformed, deadLetter)
+	failed += writeFailed
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return failed, errors.Join(errs...)
+}
+
+// transformRoute applies the transformations of a route to a payload of its
+// stream. Like the transformations of the stream, a payload that fails to
+// transform is written as it is unless a dead_letter rule failed on it.
+func (s *Service) transformRoute(ctx context.Context, route *Route, event events.RecordEvent, payload map[string]interface{}, deadLetter bool) ([]map[string]interface{}, error) {
+	result, err := route.engine.Transform(ctx, payload)
+	if err != nil {
+		s.logger.WithError(err).WithField("route", route.Name()).Error("Failed to transform event")
+		return []map[string]interface{}{payload}, nil
+	}
+	if result.DeadLetter {
+		return nil, s.deadLetterTransform(ctx, route.Name(), event, result, deadLetter)
+	}
+	if !result.Success {
+		s.logger.WithFields(logrus.Fields{
+			"route":    route.Name(),
+			"errors":   result.Errors,
+			"warnings": result.Warnings,
+		}).Warn("Event transformation completed with errors")
+	}
+	return result.Documents(), nil
+}
+
+// route returns the route with a name, nil when there is none
+func (s *Service) route(name string) *Route {
+	for _, routes := range s.routes {
+		for _, route := range routes {
+			if route.Name() == name {
+				return route
+			}
+		}
+	}
+	return nil
+}
+
+// prepareBatch prepares the transformations of a batch of events, reading
+// the records their lookups enrich them from at once. The events are looked
+// up one by one when it fails.
+func (s *Service) prepareBatch(ctx context.Context, stream string, batch []events.RecordEvent) {
+	engine, ok := s.transformEngines[stream]
+	if !ok || len(batch) < 2 {
+		return
+	}
+	documents := make([]map[string]interface{}, len(batch))
+	for i, event := range batch {
+		documents[i] = eventDocument(event)
+	}
+	if err := engine.PrepareBatch(ctx, documents); err != nil {
+		s.logger.WithError(err).WithField("stream", stream).Warn("Failed to prepare the transformation of a batch")
+	}
+}
+
+// batchFailed handles a batch that could not be written and returns the
+// number of its events that failed. A batch rejected for one of its records
+// is written again event by event, from the first record the estuary did not
+// write, so only the rejected events fail; a batch that failed after all its
+// retries, behind an open circuit breaker, or whose events must be applied
+// atomically, fails as a whole.
+func (s *Service) batchFailed(ctx context.Context, writer *ResilientWriter, batch []events.RecordEvent, payloads []map[string]interface{}, cause error, deadLetter bool) (int, error) {
+	s.logger.WithError(cause).WithFields(logrus.Fields{
+		"stream": writer.Name(),
+		"events": len(batch),
+	}).Error("Failed to write batch to estuary")
+
+	failed := 0
+	var errs []error
+	deadLettered := func(i int, err error) bool {
+		return deadLetter && s.deadLetterWrite(ctx, writer.Name(), batch[i], payloads[i], err) == nil
+	}
+
+	if len(batch) > 1 && !writer.Atomic() && !isRetryableWith(writer.policy, cause) && !errors.Is(cause, ErrBreakerOpen) && ctx.Err() == nil {
+		for i := estuary.WrittenRecords(cause); i < len(batch); i++ {
+			if err := writer.WriteEvent(ctx, payloads[i]); err != nil && !deadLettered(i, err) {
+				failed++
+				errs = append(errs, err)
+			}
+		}
+	} else {
+		for i := range batch {
+			if !deadLettered(i, cause) {
+				failed++
+			}
+		}
+		if failed > 0 {
+			errs = append(errs, cause)
+		}
+	}
+
+	if failed == 0 {
+		return 0, nil
+	}
+	return failed, fmt.Errorf("failed to write %d events to estuary %s: %w", failed, writer.Name(), errors.Join(errs...))
+}
+
+// eventDocument converts an event to the document the transformations run on
+func eventDocument(event events.RecordEvent) map[string]interface{} {
+	return map[string]interface{}{
+		"action":      event.Action,
+		"schema":      event.Schema,
+		"collection":  event.Collection,
+		"table":       event.Collection, // Use collection as table
+		"data":        event.Data,
+		"old_data":    event.OldData,
+		"documentKey": event.DocumentKey, // Ensure documentKey is preserved
+		"position":    event.Position,    // Source position, nil when the stream does not report one
+		"timestamp":   time.Now(),        // Use current time
+		"source":      event.Schema,      // Use schema as source
+		"stream":      event.Stream,
+		"_metadata": map[string]interface{}{
+			"event_id":     fmt.Sprintf("%s_%s_%d", event.Schema, event.Collection, time.Now().UnixNano()),
+			"source_type":  event.Schema,
+			"processed_at": time.Now(),
+		},
+	}
+}
+
+// transformEvent converts an event to the payloads written to the estuaries
+// and applies the transformations, which may fan the event out to several
+// payloads. No payload without an error means the event is not written: it
+// was skipped, dropped by a transformation or sent to the dead-letter queue.
+func (s *Service) transformEvent(ctx context.Context, event events.RecordEvent, deadLetter bool) ([]map[string]interface{}, error) {
 	s.logger.WithFields(logrus.Fields{
-	"action":     event.Action,
-	"schema":     event.Schema,
-	"collection": event.Collection,
+		"action":     event.Action,
+		"schema":     event.Schema,
+		"collection": event.Collection,
 	}).Debug("Processing event")
 
 	// Guard against empty data for actionable operations
@@ -431,111 +979,101 @@ func (s *Service) handleEvent(ctx context.Context, event events.RecordEvent) err
 		}).Warn("Skipping event with empty Data field for actionable operation")
 		if event.DocumentKey == nil {
 			s.logger.Warn("Missing document key for update/delete operation")
-		return nil
+			return nil, nil
 		}
 
 		// Update metrics for skipped events
 		if s.metricsCollector != nil {
-		s.metricsCollector.IncrementCounter("events_data_missing_total", 1)
+			s.metricsCollector.IncrementCounter("events_data_missing_total", 1)
 		}
-		return nil
+		return nil, nil
 	}
 
 	// Convert event to map for transformation
-	eventData := map[string]interface{}{
-		"action":       event.Action,
-		"schema":       event.Schema,
-		"collection":   event.Collection,
-		"table":        event.Collection, // Use collection as table
-		"data":         event.Data,
-		"old_data":     event.OldData,
-		"documentKey":  event.DocumentKey, // Ensure documentKey is preserved
-		"position":     "", // Not available in this event type
-		"timestamp":    time.Now(), // Use current time
-		"source":       event.Schema, // Use schema as source
-		"_metadata": map[string]interface{}{
-			"event_id":    fmt.Sprintf("%s_%s_%d", event.Schema, event.Collection, time.Now().UnixNano()),
-			"source_type": event.Schema,
-			"processed_at": time.Now(),
-		},
-	}
+	eventData := eventDocument(event)
 
 	// Apply transformations if configured
-	var transformedData map[string]interface{}
+	var transformedData []map[string]interface{}
 	var transformationErr error
 
-	if s.transformEngine != nil {
-	// Apply stream-specific transformations
-	// Note: In a real implementation, you'd get the stream config and rules
-	// For now, we'll apply any global transformations
-	transformResult, err := s.transformEngine.Transform(ctx, eventData)
-	if err != nil {
-	transformationErr = fmt.Errorf("transformation failed: %w", err)
-	s.logger.WithError(err).Error("Failed to transform event")
+	if engine, ok := s.transformEngines[event.Stream]; ok {
+		// Apply the transformation rules of the stream
+		transformResult, err := engine.Transform(ctx, eventData)
+		if err != nil {
+			transformationErr = fmt.Errorf("transformation failed: %w", err)
+			s.logger.WithError(err).Error("Failed to transform event")
 
-	// Use original data if transformation fails
-	transformedData = eventData
-	} else if transformResult.Success {
-		transformedData = transformResult.Output
-		s.logger.WithFields(logrus.Fields{
-		"applied_rules": transformResult.AppliedRules,
-		"execution_time": transformResult.ExecutionTime,
-		}).Debug("Event transformed successfully")
-	} else {
-	// Transformation had errors but may have partial results
-	transformedData = transformResult.Output
-	s.logger.WithFields(logrus.Fields{
-	"errors": transformResult.Errors,
-	"warnings": transformResult.Warnings,
-	}).Warn("Event transformation completed with errors")
-	}
-
-	// Preserve critical fields that should not be lost during transformation
-	// This ensures update/delete operations have the necessary document key
-	if eventData["old_data"] != nil && transformedData != nil {
-	transformedData["old_data"] = eventData["old_data"]
-	s.logger.WithFields(logrus.Fields{
-	"action": event.Action,
-	"has_old_data": true,
-	}).Debug("Preserved old_data field after transformation")
-	}
-	} else {
-	// No transformation engine, use original data
-	transformedData = eventData
-	}
-
-	// Route to appropriate destinations (estuaries)
-	// Note: This would typically route based on stream configuration
-	log.Debug().Int("estuary_count", len(s.estuaries)).Msg("Service.handleEvent: routing to estuaries")
-	for i, estuary := range s.estuaries {
-	log.Debug().Int("estuary_index", i).Str("estuary", fmt.Sprintf("%T", estuary)).Msg("Service.handleEvent: writing to estuary")
-	if err := estuary.WriteEvent(ctx, transformedData); err != nil {
-		s.logger.WithError(err).Error("Failed to write event to estuary")
-		log.Error().Err(err).Int("estuary_index", i).Msg("Service.handleEvent: failed to write to estuary")
-		// Continue with other estuaries even if one fails
+			// Use original data if transformation fails
+			transformedData = []map[string]interface{}{eventData}
+		} else if transformResult.Success {
+			transformedData = transformResult.Documents()
+			s.logger.WithFields(logrus.Fields{
+				"applied_rules":  transformResult.AppliedRules,
+				"execution_time": transformResult.ExecutionTime,
+			}).Debug("Event transformed successfully")
 		} else {
-			log.Debug().Int("estuary_index", i).Msg("Service.handleEvent: successfully wrote to estuary")
+			// Transformation had errors but may have partial results
+			transformedData = transformResult.Documents()
+			s.logger.WithFields(logrus.Fields{
+				"errors":   transformResult.Errors,
+				"warnings": transformResult.Warnings,
+			}).Warn("Event transformation completed with errors")
+		}
+
+		// A rule with the dead_letter strategy failed, the event is not written
+		if err == nil && transformResult.DeadLetter {
+			return nil, s.deadLetterTransform(ctx, event.Stream, event, transformResult, deadLetter)
 		}
+	} else {
+		// No transformation engine, use original data
+		transformedData = []map[string]interface{}{eventData}
 	}
 
 	// Update metrics
 	if s.metricsCollector != nil {
 		metrics := map[string]interface{}{
 			"events_processed": 1,
-			"event_action": event.Action,
-			"source_type": event.Schema,
+			"event_action":     event.Action,
+			"source_type":      event.Schema,
 		}
-	
+
 		if transformationErr != nil {
 			metrics["transformation_errors"] = 1
 		} else {
 			metrics["transformation_success"] = 1
 		}
-		
+
 		s.metricsCollector.RecordMetrics(ctx, metrics)
 	}
-	
-	return nil
+
+	return transformedData, nil
+}
+
+// EventStatistics returns the event statistics of a stream: the events it
+// processed and had errors on, and the events its filter skipped
+func (s *Service) EventStatistics(name string) (models.EventStatistics, bool) {
+	s.streamManager.mu.RLock()
+	stream, ok := s.streamManager.streams[name]
+	s.streamManager.mu.RUnlock()
+	if !ok {
+		return models.EventStatistics{}, false
+	}
+	return s.eventStatistics(name, stream), true
+}
+
+// eventStatistics returns the event statistics of a stream
+func (s *Service) eventStatistics(name string, stream models.Stream) models.EventStatistics {
+	metrics := stream.GetMetrics()
+	stats := models.EventStatistics{
+		StreamName:  name,
+		TotalEvents: metrics.EventsProcessed,
+		ErrorEvents: metrics.ErrorCount,
+		WindowEnd:   time.Now(),
+	}
+	if filter, ok := s.filters[name]; ok {
+		stats.SkippedEvents = filter.Skipped()
+	}
+	return stats
 }
 
 // monitorStreams monitors stream health and metrics
@@ -570,6 +1108,14 @@ func (s *Service) updateStreamMetrics() {
 		metrics := stream.GetMetrics()
 		state := stream.GetState()
 		
+		// Streams that keep no checkpoint report the position applied in order
+		stage, staged := s.applyStages[name]
+		if staged && state.Checkpoint == nil {
+			state.Checkpoint = stage.Acks().Position()
+		}
+		stats := s.eventStatistics(name, stream)
+		state.Statistics = &stats
+		
 		// Update state
 		s.streamManager.streamStates[name] = state
 		
@@ -585,5 +1131,24 @@ func (s *Service) updateStreamMetrics() {
 		s.metricsCollector.SetGauge("stream_error_count", float64(metrics.ErrorCount), map[string]string{
 			"stream": name,
 		})
+		
+		if staged {
+			s.metricsCollector.SetGauge("stream_pending_events", float64(stage.Acks().Pending()), map[string]string{
+				"stream": name,
+			})
+		}
+
+		if _, ok := s.filters[name]; ok {
+			s.metricsCollector.SetGauge("stream_skipped_events", float64(stats.SkippedEvents), map[string]string{
+				"stream": name,
+			})
+		}
+
+		for _, route := range s.routes[name] {
+			s.metricsCollector.SetGauge("stream_routed_events", float64(route.Routed()), map[string]string{
+				"stream": name,
+				"route":  route.Name(),
+			})
+		}
 	}
 }
@@ -0,0 +1,91 @@
+package replicator
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cohenjo/replicator/pkg/config"
+	"github.com/cohenjo/replicator/pkg/events"
+	"github.com/cohenjo/replicator/pkg/transform"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransformEventUsesStreamRules(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	service := &Service{logger: logger, transformEngines: make(map[string]*transform.Engine)}
+
+	engine, err := service.createTransformEngine(config.StreamConfig{
+		Name: "orders",
+		Transformation: &config.TransformationRulesConfig{
+			Enabled: true,
+			Rules: []config.TransformationRule{{
+				Name:    "flag",
+				Enabled: true,
+				Actions: []config.Action{{Type: "kazaam", Spec: `[{"operation": "default", "spec": {"flagged": true}}]`}},
+			}},
+		},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, engine)
+	service.transformEngines["orders"] = engine
+
+	ctx := context.Background()
+	payloads, err := service.transformEvent(ctx, events.RecordEvent{Action: "insert", Collection: "orders", Data: []byte(`{"id":1}`), Stream: "orders"}, false)
+	require.NoError(t, err)
+	require.Len(t, payloads, 1)
+	assert.Equal(t, true, payloads[0]["flagged"])
+
+	// Streams without rules are not transformed
+	payloads, err = service.transformEvent(ctx, events.RecordEvent{Action: "insert", Collection: "users", Data: []byte(`{"id":1}`), Stream: "users"}, false)
+	require.NoError(t, err)
+	require.Len(t, payloads, 1)
+	assert.NotContains(t, payloads[0], "flagged")
+
+	engine, err = service.createTransformEngine(config.StreamConfig{Name: "users", Transformation: &config.TransformationRulesConfig{}})
+	require.NoError(t, err)
+	assert.Nil(t, engine, "disabled transformations have no engine")
+
+	_, err = service.createTransformEngine(config.StreamConfig{
+		Name: "users",
+		Transformation: &config.TransformationRulesConfig{
+			Enabled: true,
+			Rules:   []config.TransformationRule{{Name: "broken", Enabled: true, Actions: []config.Action{{Type: "kazaam", Spec: `{`}}}},
+		},
+	})
+	assert.Error(t, err, "invalid rules fail the stream")
+}
+
+func TestTransformEventFansOut(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	service := &Service{logger: logger, transformEngines: make(map[string]*transform.Engine)}
+
+	engine, err := service.createTransformEngine(config.StreamConfig{
+		Name: "orders",
+		Transformation: &config.TransformationRulesConfig{
+			Enabled: true,
+			Rules: []config.TransformationRule{{
+				Name:    "split",
+				Enabled: true,
+				Actions: []config.Action{{
+					Type:   "jq",
+					Spec:   `.line = (1, 2) | .origin = $stream`,
+					Config: map[string]interface{}{config.JQMultipleOutputs: config.JQOutputsFanOut},
+				}},
+			}},
+		},
+	})
+	require.NoError(t, err)
+	service.transformEngines["orders"] = engine
+
+	payloads, err := service.transformEvent(context.Background(), events.RecordEvent{Action: "insert", Collection: "orders", Data: []byte(`{"id":1}`), Stream: "orders"}, false)
+	require.NoError(t, err)
+	require.Len(t, payloads, 2)
+	for i, payload := range payloads {
+		assert.Equal(t, i+1, payload["line"])
+		assert.Equal(t, "orders", payload["origin"])
+	}
+}
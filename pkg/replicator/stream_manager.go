@@ -7,7 +7,6 @@ import (
 "time"
 
 "github.com/cohenjo/replicator/pkg/config"
-"github.com/cohenjo/replicator/pkg/events"
 "github.com/cohenjo/replicator/pkg/models"
 "github.com/sirupsen/logrus"
 )
@@ -16,7 +15,6 @@ import (
 type StreamManager struct {
 	streams      map[string]models.Stream
 	streamStates map[string]models.StreamState
-	eventChannel chan<- events.RecordEvent
 	logger       *logrus.Logger
 	mu           sync.RWMutex
 }
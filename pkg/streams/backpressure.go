@@ -0,0 +1,62 @@
+package streams
+
+import (
+	"context"
+	"sync"
+
+	"github.com/cohenjo/replicator/pkg/events"
+)
+
+// dispatch hands an event to the pipeline, waiting for room while the
+// channel is full so that a slow target holds back the source instead of
+// losing events. It fails only once the context is done.
+func dispatch(ctx context.Context, eventChannel chan<- events.RecordEvent, event events.RecordEvent) error {
+	select {
+	case eventChannel <- event:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pauseGate holds back the reads of a paused stream until it is resumed
+type pauseGate struct {
+	mu      sync.Mutex
+	resumed chan struct{} // closed on resume, nil while not paused
+}
+
+// pause holds back the reads until resume is called
+func (g *pauseGate) pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resumed == nil {
+		g.resumed = make(chan struct{})
+	}
+}
+
+// resume releases the reads held back by pause
+func (g *pauseGate) resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resumed != nil {
+		close(g.resumed)
+		g.resumed = nil
+	}
+}
+
+// wait blocks while the stream is paused. It returns false once the context
+// is done.
+func (g *pauseGate) wait(ctx context.Context) bool {
+	g.mu.Lock()
+	resumed := g.resumed
+	g.mu.Unlock()
+	if resumed == nil {
+		return ctx.Err() == nil
+	}
+	select {
+	case <-resumed:
+		return ctx.Err() == nil
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,60 @@
+package streams
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cohenjo/replicator/pkg/events"
+)
+
+// TestDispatchWaitsForRoom tests that a full pipeline holds back the source
+func TestDispatchWaitsForRoom(t *testing.T) {
+	pipeline := make(chan events.RecordEvent, 1)
+	require.NoError(t, dispatch(context.Background(), pipeline, events.RecordEvent{Action: events.InsertAction}))
+
+	sent := make(chan error)
+	go func() {
+		sent <- dispatch(context.Background(), pipeline, events.RecordEvent{Action: events.DeleteAction})
+	}()
+	select {
+	case <-sent:
+		t.Fatal("the event was sent to a full pipeline")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	assert.Equal(t, events.InsertAction, (<-pipeline).Action)
+	require.NoError(t, <-sent)
+	assert.Equal(t, events.DeleteAction, (<-pipeline).Action, "the event is not dropped")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	pipeline <- events.RecordEvent{}
+	assert.ErrorIs(t, dispatch(ctx, pipeline, events.RecordEvent{}), context.Canceled)
+}
+
+// TestPauseGate tests that a paused stream reads nothing until it is resumed
+func TestPauseGate(t *testing.T) {
+	var gate pauseGate
+	ctx := context.Background()
+	assert.True(t, gate.wait(ctx))
+
+	gate.pause()
+	read := make(chan bool)
+	go func() { read <- gate.wait(ctx) }()
+	select {
+	case <-read:
+		t.Fatal("read while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+	gate.resume()
+	assert.True(t, <-read)
+
+	gate.pause()
+	stopped, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.False(t, gate.wait(stopped), "a stopped stream stops waiting")
+}
@@ -2,18 +2,39 @@ package streams
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/data/azcosmos"
+	"github.com/cohenjo/replicator/pkg/auth"
 	"github.com/cohenjo/replicator/pkg/config"
 	"github.com/cohenjo/replicator/pkg/events"
+	"github.com/cohenjo/replicator/pkg/position"
 	"github.com/sirupsen/logrus"
 )
 
+// cosmosPositionMetadataKey holds the serialized position in tracker metadata,
+// since trackers do not deserialize typed positions on Load
+const cosmosPositionMetadataKey = "cosmosdb_position"
+
+// errCosmosStreamStopped is returned when the provider is stopped while dispatching events
+var errCosmosStreamStopped = errors.New("cosmos db stream stopped")
+
+// Cosmos DB authentication methods
+const (
+	CosmosAuthDefault          = auth.AzureAuthMethodDefault
+	CosmosAuthManagedIdentity  = auth.AzureAuthMethodManagedIdentity
+	CosmosAuthServicePrincipal = auth.AzureAuthMethodServicePrincipal
+	CosmosAuthCLI              = auth.AzureAuthMethodCLI
+	CosmosAuthKey              = "key"
+)
+
 // CosmosDBStreamProvider implements the Stream interface for Azure Cosmos DB change feed
 type CosmosDBStreamProvider struct {
 	config         *CosmosDBConfig
@@ -23,7 +44,12 @@ type CosmosDBStreamProvider struct {
 	stopChannel    chan struct{}
 	logger         *logrus.Logger
 	isRunning      bool
-	continuationToken string
+	feedClient     *cosmosChangeFeedClient
+	feedRanges     []cosmosFeedRange
+	position       *position.CosmosDBPosition
+	tracker        position.Tracker
+	streamID       string
+	stopOnce       sync.Once
 	pollInterval   time.Duration
 	backoffFactor  float64
 	maxBackoff     time.Duration
@@ -39,9 +65,18 @@ type CosmosDBConfig struct {
 	ContainerName string `json:"container"`    // Container name
 	
 	// Authentication settings (using Managed Identity)
-	UseManagedIdentity bool `json:"use_managed_identity"` // Use Azure Managed Identity
+	UseManagedIdentity bool   `json:"use_managed_identity"` // Use Azure Managed Identity
+	AuthMethod         string `json:"auth_method"`          // default, managed_identity, service_principal, cli or key
+	TenantID           string `json:"tenant_id"`            // Tenant of the service principal
+	ClientID           string `json:"client_id"`            // Service principal or user-assigned identity client id
+	ClientSecret       string `json:"client_secret"`        // Service principal secret
+	AccountKey         string `json:"-"`                    // Account key, for the emulator or key-based access
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"` // Skip TLS verification (emulator self-signed certificate)
 	
 	// Change feed settings
+	ChangeFeedMode     string        `json:"change_feed_mode"`     // latest_version or all_versions_and_deletes
+	StartFrom          string        `json:"start_from"`           // beginning, now or an RFC3339 point in time
+	StartTime          time.Time     `json:"-"`                    // Parsed point in time of StartFrom
 	StartFromBeginning bool          `json:"start_from_beginning"` // Start from beginning or now
 	MaxItemCount       int           `json:"max_item_count"`       // Maximum items per page
 	PollInterval       time.Duration `json:"poll_interval"`        // Polling interval for change feed
@@ -70,6 +105,12 @@ func NewCosmosDBStreamProvider(eventSender chan<- events.RecordEvent, logger *lo
 	}
 }
 
+// SetPositionTracker sets the tracker used to persist the continuation of each feed range
+func (c *CosmosDBStreamProvider) SetPositionTracker(tracker position.Tracker, streamID string) {
+	c.tracker = tracker
+	c.streamID = streamID
+}
+
 // Listen starts listening to Cosmos DB change feed
 func (c *CosmosDBStreamProvider) Listen(ctx context.Context) error {
 	c.logger.Info("Starting Cosmos DB stream provider")
@@ -85,7 +126,17 @@ func (c *CosmosDBStreamProvider) Listen(ctx context.Context) error {
 	}
 	
 	defer c.cleanup()
-	
+
+	// Resume from the saved feed range continuations
+	if err := c.loadPosition(ctx); err != nil {
+		return fmt.Errorf("failed to load change feed position: %w", err)
+	}
+
+	return c.run(ctx)
+}
+
+// run polls the change feed until the context is cancelled or the provider is stopped
+func (c *CosmosDBStreamProvider) run(ctx context.Context) error {
 	c.isRunning = true
 	c.retryAttempts = 0
 	currentBackoff := c.config.RetryDelay
@@ -202,6 +253,8 @@ func (c *CosmosDBStreamProvider) parseCosmosDBConfig() error {
 		if len(wfc.CosmosExcludeOperations) > 0 {
 			cosmosConfig.ExcludeOperations = wfc.CosmosExcludeOperations
 		}
+		cosmosConfig.ChangeFeedMode = wfc.CosmosChangeFeedMode
+		cosmosConfig.StartFrom = wfc.CosmosStartFrom
 	}
 	
 	// Validate required fields
@@ -214,6 +267,17 @@ func (c *CosmosDBStreamProvider) parseCosmosDBConfig() error {
 	if cosmosConfig.ContainerName == "" {
 		return fmt.Errorf("cosmos DB container name is required")
 	}
+	azureAuth := globalConfig.Azure.Authentication
+	cosmosConfig.AuthMethod = azureAuth.Method
+	cosmosConfig.TenantID = azureAuth.TenantID
+	cosmosConfig.ClientID = azureAuth.ClientID
+	cosmosConfig.ClientSecret = azureAuth.ClientSecret
+	if err := cosmosConfig.resolveAuthSettings(); err != nil {
+		return err
+	}
+	if err := cosmosConfig.resolveChangeFeedSettings(); err != nil {
+		return err
+	}
 	
 	c.config = cosmosConfig
 	c.maxRetries = cosmosConfig.MaxRetries
@@ -230,104 +294,422 @@ func (c *CosmosDBStreamProvider) parseCosmosDBConfig() error {
 	return nil
 }
 
-// connect establishes connection to Cosmos DB using managed identity
+// connect establishes connection to Cosmos DB using an Azure identity or the account key
 func (c *CosmosDBStreamProvider) connect(ctx context.Context) error {
-	c.logger.Info("Connecting to Cosmos DB using managed identity")
-	
-	// Create Azure credential using managed identity
-	cred, err := azidentity.NewDefaultAzureCredential(nil)
-	if err != nil {
-		return fmt.Errorf("failed to create Azure credential: %w", err)
+	httpClient := &http.Client{Timeout: c.config.RequestTimeout}
+	if c.config.InsecureSkipVerify {
+		httpClient.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
 	}
-	
-	// Create Cosmos client options
 	clientOptions := &azcosmos.ClientOptions{}
-	
-	// Create Cosmos DB client
-	client, err := azcosmos.NewClient(c.config.Endpoint, cred, clientOptions)
-	if err != nil {
-		return fmt.Errorf("failed to create Cosmos DB client: %w", err)
+	clientOptions.Transport = httpClient
+
+	var authorize cosmosAuthorizer
+	if c.config.AuthMethod == CosmosAuthKey {
+		c.logger.Info("Connecting to Cosmos DB using the account key")
+
+		keyCred, err := azcosmos.NewKeyCredential(c.config.AccountKey)
+		if err != nil {
+			return fmt.Errorf("failed to create key credential: %w", err)
+		}
+		if c.client, err = azcosmos.NewClientWithKey(c.config.Endpoint, keyCred, clientOptions); err != nil {
+			return fmt.Errorf("failed to create Cosmos DB client: %w", err)
+		}
+		if authorize, err = newCosmosKeyAuthorizer(c.config.AccountKey); err != nil {
+			return err
+		}
+	} else {
+		c.logger.WithField("auth_method", c.config.AuthMethod).Info("Connecting to Cosmos DB using Azure identity")
+
+		cred, err := auth.NewAzureCredential(c.config.AuthMethod, c.config.TenantID, c.config.ClientID, c.config.ClientSecret)
+		if err != nil {
+			return fmt.Errorf("failed to create Azure credential: %w", err)
+		}
+		if c.client, err = azcosmos.NewClient(c.config.Endpoint, cred, clientOptions); err != nil {
+			return fmt.Errorf("failed to create Cosmos DB client: %w", err)
+		}
+		if authorize, err = newCosmosAADAuthorizer(cred, c.config.Endpoint); err != nil {
+			return err
+		}
 	}
-	
-	c.client = client
-	
+
 	// Get container client
-	c.container, err = client.NewContainer(c.config.DatabaseName, c.config.ContainerName)
+	var err error
+	c.container, err = c.client.NewContainer(c.config.DatabaseName, c.config.ContainerName)
 	if err != nil {
 		return fmt.Errorf("failed to create container client: %w", err)
 	}
-	
+
 	// Test connection by getting container properties
 	_, err = c.container.Read(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("failed to read container properties (connection test failed): %w", err)
 	}
-	
+
+	c.feedClient = newCosmosChangeFeedClient(c.config.Endpoint, c.config.DatabaseName, c.config.ContainerName,
+		c.config.ChangeFeedMode, c.config.MaxItemCount, c.config.RequestTimeout, authorize)
+	c.feedClient.httpClient = httpClient
+
 	c.logger.WithFields(logrus.Fields{
 		"endpoint":  c.config.Endpoint,
 		"database":  c.config.DatabaseName,
 		"container": c.config.ContainerName,
+		"mode":      c.config.ChangeFeedMode,
+		"start_from": c.config.StartFrom,
 	}).Info("Successfully connected to Cosmos DB")
-	
+
+	return nil
+}
+
+// resolveAuthSettings applies defaults to and validates the authentication settings
+func (cfg *CosmosDBConfig) resolveAuthSettings() error {
+	if cfg.AuthMethod == "" {
+		cfg.AuthMethod = CosmosAuthDefault
+		if cfg.AccountKey != "" {
+			cfg.AuthMethod = CosmosAuthKey
+		}
+	}
+
+	switch cfg.AuthMethod {
+	case CosmosAuthDefault, CosmosAuthManagedIdentity, CosmosAuthCLI:
+	case CosmosAuthServicePrincipal:
+		if cfg.TenantID == "" || cfg.ClientID == "" || cfg.ClientSecret == "" {
+			return fmt.Errorf("cosmos DB service_principal auth requires tenant_id, client_id and client_secret")
+		}
+	case CosmosAuthKey:
+		if cfg.AccountKey == "" {
+			return fmt.Errorf("cosmos DB key auth requires an account key")
+		}
+	default:
+		return fmt.Errorf("unsupported cosmos DB auth method: %s", cfg.AuthMethod)
+	}
+	return nil
+}
+
+// resolveChangeFeedSettings applies defaults to and validates the change feed mode and start position
+func (cfg *CosmosDBConfig) resolveChangeFeedSettings() error {
+	if cfg.ChangeFeedMode == "" {
+		cfg.ChangeFeedMode = CosmosChangeFeedLatestVersion
+	}
+	if cfg.StartFrom == "" {
+		cfg.StartFrom = CosmosStartFromNow
+		if cfg.StartFromBeginning {
+			cfg.StartFrom = CosmosStartFromBeginning
+		}
+	}
+
+	switch cfg.ChangeFeedMode {
+	case CosmosChangeFeedLatestVersion, CosmosChangeFeedAllVersionsAndDeletes:
+	default:
+		return fmt.Errorf("unsupported cosmos DB change feed mode: %s", cfg.ChangeFeedMode)
+	}
+
+	switch cfg.StartFrom {
+	case CosmosStartFromBeginning, CosmosStartFromNow:
+	default:
+		startTime, err := time.Parse(time.RFC3339, cfg.StartFrom)
+		if err != nil {
+			return fmt.Errorf("invalid cosmos DB change feed start %q: expected beginning, now or an RFC3339 time", cfg.StartFrom)
+		}
+		cfg.StartTime = startTime
+	}
+
+	// The all versions and deletes feed only retains changes from the point it is read
+	if cfg.ChangeFeedMode == CosmosChangeFeedAllVersionsAndDeletes && cfg.StartFrom != CosmosStartFromNow {
+		return fmt.Errorf("cosmos DB all_versions_and_deletes change feed can only start from now")
+	}
 	return nil
 }
 
-// readChangeFeed reads and processes changes from Cosmos DB change feed
+// readChangeFeed reads and processes changes from every feed range of the container
 func (c *CosmosDBStreamProvider) readChangeFeed(ctx context.Context) error {
-	// Cosmos DB change feed is implemented via SQL queries with special headers
-	// We'll simulate a change feed by querying documents ordered by _ts (timestamp)
-	
-	query := "SELECT * FROM c ORDER BY c._ts"
-	
-	// Create query options
-	opt := azcosmos.QueryOptions{}
-	
-	if c.continuationToken != "" {
-		opt.ContinuationToken = &c.continuationToken
+	if c.feedRanges == nil {
+		if err := c.refreshFeedRanges(ctx); err != nil {
+			return err
+		}
 	}
-	
-	// Execute query
-	queryPager := c.container.NewQueryItemsPager(query, azcosmos.PartitionKey{}, &opt)
-	
-	if !queryPager.More() {
-		// No more results
-		return nil
+
+	for _, feedRange := range c.feedRanges {
+		if err := c.drainFeedRange(ctx, feedRange.ID); err != nil {
+			var statusErr *cosmosStatusError
+			if errors.As(err, &statusErr) && statusErr.isFeedRangeGone() {
+				// The range was split or merged; its children take over from its continuation
+				c.logger.WithField("feed_range", feedRange.ID).Info("Feed range is gone, refreshing feed ranges")
+				return c.refreshFeedRanges(ctx)
+			}
+			return err
+		}
 	}
-	
-	// Get next page
-	response, err := queryPager.NextPage(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to read change feed page: %w", err)
+
+	return nil
+}
+
+// drainFeedRange reads a feed range until it has no more changes, saving the
+// continuation after each page has been dispatched. A page with an item that
+// fails is read again from its continuation. The last event of a page carries
+// the continuation after it in its position, see CosmosDBStream.CommitPosition.
+func (c *CosmosDBStreamProvider) drainFeedRange(ctx context.Context, feedRange string) error {
+	continuation, ok := c.position.GetContinuation(feedRange)
+	var startTime time.Time
+	if !ok {
+		continuation, startTime = c.initialContinuation()
 	}
-	
-	// Process items if any
-	if len(response.Items) > 0 {
-		for _, item := range response.Items {
-			if err := c.processChangeItem(item); err != nil {
-				c.logger.WithError(err).Error("Failed to process change item")
-				// Continue processing other items
+
+	for {
+		page, err := c.feedClient.readChanges(ctx, feedRange, continuation, startTime)
+		if err != nil {
+			return err
+		}
+
+		pageEvents := make([]events.RecordEvent, 0, len(page.Items))
+		for _, item := range page.Items {
+			event, err := c.feedEvent(feedRange, item)
+			if err != nil {
+				return fmt.Errorf("failed to process change item of feed range %s: %w", feedRange, err)
+			}
+			if event != nil {
+				pageEvents = append(pageEvents, *event)
+			}
+		}
+		if n := len(pageEvents); n > 0 && page.Continuation != continuation {
+			pageEvents[n-1].Position["continuation"] = page.Continuation
+		}
+		for _, event := range pageEvents {
+			if err := c.sendEvent(event); err != nil {
+				if errors.Is(err, errCosmosStreamStopped) {
+					return nil
+				}
+				return err
 			}
 		}
-		
+
+		if page.Continuation != continuation {
+			c.position.SetContinuation(feedRange, page.Continuation)
+			c.savePosition(ctx)
+		}
+
+		if page.NotModified || len(page.Items) == 0 {
+			return nil
+		}
+
 		c.logger.WithFields(logrus.Fields{
-			"items_processed": len(response.Items),
+			"feed_range":      feedRange,
+			"items_processed": len(page.Items),
 		}).Debug("Processed change feed items")
+
+		continuation = page.Continuation
+		startTime = time.Time{}
 	}
-	
-	// Update continuation token for next iteration
-	if response.ContinuationToken != nil {
-		c.continuationToken = *response.ContinuationToken
+}
+
+// initialContinuation returns where a feed range without a saved continuation starts
+func (c *CosmosDBStreamProvider) initialContinuation() (string, time.Time) {
+	switch c.config.StartFrom {
+	case CosmosStartFromBeginning:
+		return "", time.Time{}
+	case CosmosStartFromNow:
+		return "*", time.Time{}
+	default:
+		return "", c.config.StartTime
 	}
-	
+}
+
+// refreshFeedRanges reloads the feed ranges of the container. New ranges
+// created by a split inherit the continuation of their parent range.
+func (c *CosmosDBStreamProvider) refreshFeedRanges(ctx context.Context) error {
+	ranges, err := c.feedClient.readFeedRanges(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read feed ranges: %w", err)
+	}
+
+	current := make(map[string]bool, len(ranges))
+	for _, feedRange := range ranges {
+		current[feedRange.ID] = true
+		if _, ok := c.position.GetContinuation(feedRange.ID); ok {
+			continue
+		}
+		// Parents are listed oldest first, the direct parent is last
+		for i := len(feedRange.Parents) - 1; i >= 0; i-- {
+			if continuation, ok := c.position.GetContinuation(feedRange.Parents[i]); ok {
+				c.position.SetContinuation(feedRange.ID, continuation)
+				break
+			}
+		}
+	}
+
+	for id := range c.position.Continuations {
+		if !current[id] {
+			delete(c.position.Continuations, id)
+		}
+	}
+
+	c.feedRanges = ranges
+	c.logger.WithField("feed_ranges", len(ranges)).Debug("Loaded change feed ranges")
 	return nil
 }
 
+// loadPosition restores the feed range continuations from the position tracker
+func (c *CosmosDBStreamProvider) loadPosition(ctx context.Context) error {
+	// A position restored from a checkpoint is kept unless the tracker has a saved one
+	if c.position == nil {
+		c.position = position.NewCosmosDBPosition(c.config.DatabaseName, c.config.ContainerName)
+	}
+	if c.position.Mode == "" {
+		c.position.Mode = c.config.ChangeFeedMode
+	}
+	if c.tracker == nil {
+		return c.checkPositionMode()
+	}
+
+	pos, metadata, err := c.tracker.Load(ctx, c.streamID)
+	if err != nil {
+		if errors.Is(err, position.ErrPositionNotFound) {
+			return c.checkPositionMode()
+		}
+		return err
+	}
+
+	if cosmosPos, ok := pos.(*position.CosmosDBPosition); ok {
+		c.position = cosmosPos
+	} else if serialized, ok := metadata[cosmosPositionMetadataKey].(string); ok {
+		if err := c.position.Deserialize([]byte(serialized)); err != nil {
+			return fmt.Errorf("%w: %v", position.ErrPositionCorrupted, err)
+		}
+	}
+
+	if err := c.checkPositionMode(); err != nil {
+		return err
+	}
+
+	c.logger.WithField("position", c.position.String()).Info("Resuming Cosmos DB change feed")
+	return nil
+}
+
+// checkPositionMode verifies the position was taken in the configured change feed mode
+func (c *CosmosDBStreamProvider) checkPositionMode() error {
+	if c.position.Mode != "" && c.position.Mode != c.config.ChangeFeedMode {
+		return fmt.Errorf("saved position was taken in %s mode, stream is configured for %s", c.position.Mode, c.config.ChangeFeedMode)
+	}
+	return nil
+}
+
+// savePosition persists the feed range continuations through the position tracker
+func (c *CosmosDBStreamProvider) savePosition(ctx context.Context) {
+	if c.tracker == nil {
+		return
+	}
+
+	serialized, err := c.position.Serialize()
+	if err != nil {
+		c.logger.WithError(err).Warn("Failed to serialize change feed position")
+		return
+	}
+
+	metadata := map[string]interface{}{
+		"stream_type":             "cosmosdb",
+		cosmosPositionMetadataKey: string(serialized),
+	}
+	if err := c.tracker.Save(ctx, c.streamID, c.position, metadata); err != nil {
+		c.logger.WithError(err).Warn("Failed to save change feed position")
+	}
+}
+
+// feedEvent converts a change feed item of a feed range to its event
+// according to the change feed mode, nil when its operation is filtered out
+func (c *CosmosDBStreamProvider) feedEvent(feedRange string, item []byte) (*events.RecordEvent, error) {
+	var event *events.RecordEvent
+	var err error
+	if c.config.ChangeFeedMode == CosmosChangeFeedAllVersionsAndDeletes {
+		event, err = c.allVersionsEvent(feedRange, item)
+	} else {
+		event, err = c.changeEvent(item)
+	}
+	if event != nil {
+		if event.Position == nil {
+			event.Position = make(map[string]interface{})
+		}
+		event.Position["feed_range"] = feedRange
+	}
+	return event, err
+}
+
+// processAllVersionsItem processes an item of the all versions and deletes change feed
+func (c *CosmosDBStreamProvider) processAllVersionsItem(feedRange string, item []byte) error {
+	event, err := c.allVersionsEvent(feedRange, item)
+	if err != nil || event == nil {
+		return err
+	}
+	return c.sendEvent(*event)
+}
+
+// allVersionsEvent converts an item of the all versions and deletes change
+// feed, which carries the operation type and, for deletes, the deleted item
+func (c *CosmosDBStreamProvider) allVersionsEvent(feedRange string, item []byte) (*events.RecordEvent, error) {
+	change, err := parseAllVersionsItem(item)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.shouldFilterOperation(change.Operation) {
+		c.logger.WithFields(logrus.Fields{
+			"operation": change.Operation,
+		}).Debug("Filtered out operation")
+		return nil, nil
+	}
+
+	recordEvent := events.RecordEvent{
+		Schema:     c.config.DatabaseName,
+		Collection: c.config.ContainerName,
+		Position: map[string]interface{}{
+			"feed_range": feedRange,
+			"lsn":        change.LSN,
+		},
+	}
+
+	switch change.Operation {
+	case "create":
+		recordEvent.Action = events.InsertAction
+	case "delete":
+		recordEvent.Action = events.DeleteAction
+	default:
+		recordEvent.Action = events.UpdateAction
+	}
+
+	document := change.Current
+	if recordEvent.Action == events.DeleteAction {
+		document = change.Previous
+	}
+	if document != nil {
+		if recordEvent.Data, err = json.Marshal(document); err != nil {
+			return nil, fmt.Errorf("failed to marshal document: %w", err)
+		}
+		if id, ok := document["id"]; ok {
+			recordEvent.DocumentKey, _ = json.Marshal(map[string]interface{}{"id": id})
+		}
+	}
+	if change.Previous != nil && recordEvent.Action != events.DeleteAction {
+		if recordEvent.OldData, err = json.Marshal(change.Previous); err != nil {
+			return nil, fmt.Errorf("failed to marshal previous document: %w", err)
+		}
+	}
+
+	return &recordEvent, nil
+}
+
 // processChangeItem processes a single change feed item
 func (c *CosmosDBStreamProvider) processChangeItem(item []byte) error {
+	event, err := c.changeEvent(item)
+	if err != nil || event == nil {
+		return err
+	}
+	return c.sendEvent(*event)
+}
+
+// changeEvent converts an item of the latest version change feed
+func (c *CosmosDBStreamProvider) changeEvent(item []byte) (*events.RecordEvent, error) {
 	// Parse the change item to determine operation type
 	var document map[string]interface{}
 	if err := json.Unmarshal(item, &document); err != nil {
-		return fmt.Errorf("failed to parse change item: %w", err)
+		return nil, fmt.Errorf("failed to parse change item: %w", err)
 	}
 	
 	// Determine operation type based on document metadata
@@ -338,13 +720,13 @@ func (c *CosmosDBStreamProvider) processChangeItem(item []byte) error {
 		c.logger.WithFields(logrus.Fields{
 			"operation": operationType,
 		}).Debug("Filtered out operation")
-		return nil
+		return nil, nil
 	}
 	
 	// Marshal document to JSON for Data field
 	docBytes, err := json.Marshal(document)
 	if err != nil {
-		return fmt.Errorf("failed to marshal document: %w", err)
+		return nil, fmt.Errorf("failed to marshal document: %w", err)
 	}
 	
 	// Create record event
@@ -355,7 +737,12 @@ func (c *CosmosDBStreamProvider) processChangeItem(item []byte) error {
 		Data:       docBytes,
 	}
 	
-	// Send event
+	return &recordEvent, nil
+}
+
+// sendEvent hands an event to the pipeline. It blocks while the channel is
+// full, so the change feed is only read as fast as its events are applied.
+func (c *CosmosDBStreamProvider) sendEvent(recordEvent events.RecordEvent) error {
 	select {
 	case c.eventSender <- recordEvent:
 		c.logger.WithFields(logrus.Fields{
@@ -363,11 +750,10 @@ func (c *CosmosDBStreamProvider) processChangeItem(item []byte) error {
 			"schema":     recordEvent.Schema,
 			"collection": recordEvent.Collection,
 		}).Debug("Sent Cosmos DB change event")
-	default:
-		c.logger.Warn("Event channel is full, dropping change event")
+		return nil
+	case <-c.stopChannel:
+		return errCosmosStreamStopped
 	}
-	
-	return nil
 }
 
 // determineOperationType determines the type of operation from a Cosmos DB document
@@ -422,6 +808,11 @@ func (c *CosmosDBStreamProvider) isFatalError(err error) bool {
 	if err == nil {
 		return false
 	}
+
+	var statusErr *cosmosStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.isFatal()
+	}
 	
 	errStr := err.Error()
 	
@@ -477,9 +868,9 @@ func (c *CosmosDBStreamProvider) cleanup() {
 // Stop stops the Cosmos DB stream provider
 func (c *CosmosDBStreamProvider) Stop() {
 	c.logger.Info("Cosmos DB stream provider stopped")
-	if c.isRunning {
+	c.stopOnce.Do(func() {
 		close(c.stopChannel)
-	}
+	})
 }
 
 // StreamType returns the type of stream
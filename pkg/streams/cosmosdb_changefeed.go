@@ -0,0 +1,356 @@
+package streams
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// The Go Cosmos DB SDK does not expose the change feed, so it is read through
+// the REST API: the container's partition key ranges are the feed ranges, and
+// each range is read with an A-IM header and continued with If-None-Match.
+
+// Change feed modes
+const (
+	CosmosChangeFeedLatestVersion         = "latest_version"
+	CosmosChangeFeedAllVersionsAndDeletes = "all_versions_and_deletes"
+)
+
+// Change feed start positions, used when a feed range has no saved continuation.
+// Any other value is parsed as an RFC3339 point in time.
+const (
+	CosmosStartFromBeginning = "beginning"
+	CosmosStartFromNow       = "now"
+)
+
+const (
+	cosmosAPIVersion           = "2020-07-15"
+	cosmosChangeFeedWireFormat = "2021-09-15"
+)
+
+// cosmosAuthorizer sets the Authorization header for a Cosmos DB REST request
+type cosmosAuthorizer func(ctx context.Context, req *http.Request, resourceType, resourceLink string) error
+
+// newCosmosAADAuthorizer authorizes requests with Microsoft Entra ID tokens
+func newCosmosAADAuthorizer(cred azcore.TokenCredential, endpoint string) (cosmosAuthorizer, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cosmos endpoint %q: %w", endpoint, err)
+	}
+	scope := fmt.Sprintf("%s://%s/.default", u.Scheme, u.Hostname())
+
+	var mu sync.Mutex
+	var token azcore.AccessToken
+
+	return func(ctx context.Context, req *http.Request, resourceType, resourceLink string) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		// Refresh the token a few minutes before it expires
+		if token.Token == "" || time.Until(token.ExpiresOn) < 5*time.Minute {
+			t, err := cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{scope}})
+			if err != nil {
+				return fmt.Errorf("failed to acquire cosmos db token: %w", err)
+			}
+			token = t
+		}
+
+		req.Header.Set("Authorization", url.QueryEscape("type=aad&ver=1.0&sig="+token.Token))
+		return nil
+	}, nil
+}
+
+// newCosmosKeyAuthorizer authorizes requests with the account master key, as used by the emulator
+func newCosmosKeyAuthorizer(accountKey string) (cosmosAuthorizer, error) {
+	key, err := base64.StdEncoding.DecodeString(accountKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cosmos account key: %w", err)
+	}
+
+	return func(ctx context.Context, req *http.Request, resourceType, resourceLink string) error {
+		payload := strings.ToLower(req.Method) + "\n" +
+			strings.ToLower(resourceType) + "\n" +
+			resourceLink + "\n" +
+			strings.ToLower(req.Header.Get("x-ms-date")) + "\n" +
+			"" + "\n"
+
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(payload))
+		signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+		req.Header.Set("Authorization", url.QueryEscape("type=master&ver=1.0&sig="+signature))
+		return nil
+	}, nil
+}
+
+// cosmosFeedRange is a physical partition key range of a container
+type cosmosFeedRange struct {
+	ID           string   `json:"id"`
+	MinInclusive string   `json:"minInclusive"`
+	MaxExclusive string   `json:"maxExclusive"`
+	Parents      []string `json:"parents,omitempty"`
+}
+
+// cosmosChangeFeedPage is one page read from the change feed of a feed range
+type cosmosChangeFeedPage struct {
+	Items        []json.RawMessage
+	Continuation string
+	NotModified  bool
+}
+
+// cosmosStatusError is a non-success response from the Cosmos DB REST API
+type cosmosStatusError struct {
+	StatusCode int
+	SubStatus  int
+	Message    string
+	RetryAfter time.Duration
+}
+
+// Error implements the error interface
+func (e *cosmosStatusError) Error() string {
+	return fmt.Sprintf("cosmos db request failed: %d %s (substatus %d): %s",
+		e.StatusCode, strings.ToLower(http.StatusText(e.StatusCode)), e.SubStatus, e.Message)
+}
+
+// isFeedRangeGone reports whether the feed range was split or merged
+func (e *cosmosStatusError) isFeedRangeGone() bool {
+	return e.StatusCode == http.StatusGone && (e.SubStatus == 1002 || e.SubStatus == 1007)
+}
+
+// isFatal reports whether retrying the request cannot succeed
+func (e *cosmosStatusError) isFatal() bool {
+	switch e.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusBadRequest:
+		return true
+	}
+	return false
+}
+
+// cosmosChangeFeedClient reads the change feed of a single container
+type cosmosChangeFeedClient struct {
+	endpoint     string
+	database     string
+	container    string
+	mode         string
+	maxItemCount int
+	httpClient   *http.Client
+	authorize    cosmosAuthorizer
+}
+
+// newCosmosChangeFeedClient creates a change feed client for a container
+func newCosmosChangeFeedClient(endpoint, database, container, mode string, maxItemCount int, timeout time.Duration, authorize cosmosAuthorizer) *cosmosChangeFeedClient {
+	if mode == "" {
+		mode = CosmosChangeFeedLatestVersion
+	}
+	return &cosmosChangeFeedClient{
+		endpoint:     strings.TrimSuffix(endpoint, "/"),
+		database:     database,
+		container:    container,
+		mode:         mode,
+		maxItemCount: maxItemCount,
+		httpClient:   &http.Client{Timeout: timeout},
+		authorize:    authorize,
+	}
+}
+
+// containerLink returns the resource link of the container
+func (c *cosmosChangeFeedClient) containerLink() string {
+	return fmt.Sprintf("dbs/%s/colls/%s", c.database, c.container)
+}
+
+// readFeedRanges lists the current partition key ranges of the container
+func (c *cosmosChangeFeedClient) readFeedRanges(ctx context.Context) ([]cosmosFeedRange, error) {
+	var ranges []cosmosFeedRange
+	continuation := ""
+
+	for {
+		req, err := c.newRequest(ctx, "pkranges")
+		if err != nil {
+			return nil, err
+		}
+		if continuation != "" {
+			req.Header.Set("x-ms-continuation", continuation)
+		}
+		if err := c.authorize(ctx, req, "pkranges", c.containerLink()); err != nil {
+			return nil, err
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read partition key ranges: %w", err)
+		}
+		body, err := readCosmosResponse(resp)
+		if err != nil {
+			return nil, err
+		}
+
+		var page struct {
+			PartitionKeyRanges []cosmosFeedRange `json:"PartitionKeyRanges"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, fmt.Errorf("failed to parse partition key ranges: %w", err)
+		}
+		ranges = append(ranges, page.PartitionKeyRanges...)
+
+		continuation = resp.Header.Get("x-ms-continuation")
+		if continuation == "" {
+			return ranges, nil
+		}
+	}
+}
+
+// readChanges reads the next page of changes of a feed range.
+// An empty continuation starts from the beginning, or from startTime when set;
+// the continuation "*" starts from now.
+func (c *cosmosChangeFeedClient) readChanges(ctx context.Context, feedRange, continuation string, startTime time.Time) (*cosmosChangeFeedPage, error) {
+	req, err := c.newRequest(ctx, "docs")
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("x-ms-documentdb-partitionkeyrangeid", feedRange)
+	if c.maxItemCount > 0 {
+		req.Header.Set("x-ms-max-item-count", strconv.Itoa(c.maxItemCount))
+	}
+	if c.mode == CosmosChangeFeedAllVersionsAndDeletes {
+		req.Header.Set("A-IM", "Full-Fidelity Feed")
+		req.Header.Set("x-ms-cosmos-changefeed-wire-format-version", cosmosChangeFeedWireFormat)
+	} else {
+		req.Header.Set("A-IM", "Incremental feed")
+	}
+	if continuation != "" {
+		req.Header.Set("If-None-Match", continuation)
+	} else if !startTime.IsZero() {
+		req.Header.Set("If-Modified-Since", startTime.UTC().Format(http.TimeFormat))
+	}
+
+	if err := c.authorize(ctx, req, "docs", c.containerLink()); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read change feed of range %s: %w", feedRange, err)
+	}
+
+	page := &cosmosChangeFeedPage{Continuation: resp.Header.Get("ETag")}
+	if page.Continuation == "" {
+		page.Continuation = continuation
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		page.NotModified = true
+		return page, nil
+	}
+
+	body, err := readCosmosResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var documents struct {
+		Documents []json.RawMessage `json:"Documents"`
+	}
+	if err := json.Unmarshal(body, &documents); err != nil {
+		return nil, fmt.Errorf("failed to parse change feed page: %w", err)
+	}
+	page.Items = documents.Documents
+	return page, nil
+}
+
+// newRequest builds a GET request for a child resource of the container
+func (c *cosmosChangeFeedClient) newRequest(ctx context.Context, resource string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/%s/%s", c.endpoint, c.containerLink(), resource), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("x-ms-version", cosmosAPIVersion)
+	req.Header.Set("x-ms-date", strings.ToLower(time.Now().UTC().Format(http.TimeFormat)))
+	req.Header.Set("Accept", "application/json")
+	return req, nil
+}
+
+// readCosmosResponse reads the response body, turning non-success statuses into a cosmosStatusError
+func readCosmosResponse(resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return body, nil
+	}
+
+	statusErr := &cosmosStatusError{StatusCode: resp.StatusCode}
+	statusErr.SubStatus, _ = strconv.Atoi(resp.Header.Get("x-ms-substatus"))
+	if ms, err := strconv.Atoi(resp.Header.Get("x-ms-retry-after-ms")); err == nil {
+		statusErr.RetryAfter = time.Duration(ms) * time.Millisecond
+	}
+
+	var payload struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &payload) == nil && payload.Message != "" {
+		statusErr.Message = payload.Message
+	} else {
+		statusErr.Message = string(body)
+	}
+	return nil, statusErr
+}
+
+// cosmosChangeFeedItem is a normalized change feed item
+type cosmosChangeFeedItem struct {
+	Operation string                 // create, replace or delete
+	Current   map[string]interface{} // the document after the change
+	Previous  map[string]interface{} // the document before the change, if known
+	LSN       int64
+}
+
+// parseAllVersionsItem parses an item of the all versions and deletes feed:
+// {"current": {...}, "previous": {...}, "metadata": {"operationType": "...", "lsn": ...}}
+func parseAllVersionsItem(item []byte) (*cosmosChangeFeedItem, error) {
+	var raw struct {
+		Current  map[string]interface{} `json:"current"`
+		Previous map[string]interface{} `json:"previous"`
+		Metadata struct {
+			OperationType string `json:"operationType"`
+			LSN           int64  `json:"lsn"`
+			ID            string `json:"id"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(item, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse change item: %w", err)
+	}
+
+	parsed := &cosmosChangeFeedItem{
+		Operation: strings.ToLower(raw.Metadata.OperationType),
+		Current:   raw.Current,
+		Previous:  raw.Previous,
+		LSN:       raw.Metadata.LSN,
+	}
+	if parsed.Operation == "" {
+		return nil, fmt.Errorf("change item has no operation type")
+	}
+	// Deletes may only carry the id in the metadata
+	if parsed.Operation == "delete" && parsed.Previous == nil && raw.Metadata.ID != "" {
+		parsed.Previous = map[string]interface{}{"id": raw.Metadata.ID}
+	}
+	return parsed, nil
+}
@@ -0,0 +1,544 @@
+package streams
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/sirupsen/logrus"
+
+	"github.com/cohenjo/replicator/pkg/auth"
+	"github.com/cohenjo/replicator/pkg/config"
+	"github.com/cohenjo/replicator/pkg/events"
+	"github.com/cohenjo/replicator/pkg/models"
+	"github.com/cohenjo/replicator/pkg/position"
+)
+
+// CosmosDBStream implements the models.Stream interface for the Cosmos DB change feed
+type CosmosDBStream struct {
+	config       config.StreamConfig
+	cosmosConfig *CosmosDBConfig
+	provider     *CosmosDBStreamProvider
+	checkpoint   *position.CosmosDBPosition
+	state        models.StreamState
+	metrics      models.ReplicationMetrics
+	eventChannel chan<- events.RecordEvent
+	pauseGate    chan struct{}
+	mu           sync.RWMutex
+	ctx          context.Context
+	cancel       context.CancelFunc
+}
+
+// NewCosmosDBStream creates a new Cosmos DB stream instance
+func NewCosmosDBStream(streamConfig config.StreamConfig, eventChannel chan<- events.RecordEvent) (*CosmosDBStream, error) {
+	if streamConfig.Source.Type != config.SourceTypeCosmosDB {
+		return nil, fmt.Errorf("invalid source type for Cosmos DB stream: %s", streamConfig.Source.Type)
+	}
+
+	cosmosConfig, err := cosmosConfigFromStream(streamConfig)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Cosmos DB stream configuration: %w", err)
+	}
+
+	return &CosmosDBStream{
+		config:       streamConfig,
+		cosmosConfig: cosmosConfig,
+		eventChannel: eventChannel,
+		state: models.StreamState{
+			Name:   streamConfig.Name,
+			Status: config.StreamStatusStopped,
+		},
+		metrics: models.ReplicationMetrics{
+			StreamName: streamConfig.Name,
+		},
+	}, nil
+}
+
+// Start begins the replication stream
+func (s *CosmosDBStream) Start(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state.Status == config.StreamStatusRunning {
+		return fmt.Errorf("stream is already running")
+	}
+
+	log.Info().Str("stream", s.config.Name).Msg("Starting Cosmos DB stream")
+
+	s.ctx, s.cancel = context.WithCancel(ctx)
+
+	// The provider hands events to the stream, which forwards them to the pipeline
+	// so that pausing holds back the change feed rather than dropping events
+	feed := make(chan events.RecordEvent, s.bufferSize())
+	provider := NewCosmosDBStreamProvider(feed, logrus.StandardLogger())
+	provider.config = s.cosmosConfig
+	provider.pollInterval = s.cosmosConfig.PollInterval
+	provider.maxRetries = s.cosmosConfig.MaxRetries
+	provider.maxBackoff = s.cosmosConfig.MaxBackoff
+	if s.checkpoint != nil {
+		provider.position = s.checkpoint.Clone()
+	}
+
+	if err := provider.connect(s.ctx); err != nil {
+		s.setError(err)
+		return fmt.Errorf("failed to connect to Cosmos DB: %w", err)
+	}
+	if err := provider.loadPosition(s.ctx); err != nil {
+		s.setError(err)
+		return fmt.Errorf("failed to load change feed position: %w", err)
+	}
+	s.provider = provider
+
+	s.state.Status = config.StreamStatusRunning
+	now := time.Now()
+	s.state.StartedAt = &now
+	s.state.LastError = nil
+	s.metrics.LastProcessedTime = now
+	s.metrics.UpstreamConnected = true
+
+	go s.forwardEvents(s.ctx, feed)
+	go s.readChangeFeed(s.ctx, provider)
+
+	log.Info().Str("stream", s.config.Name).
+		Str("container", s.cosmosConfig.ContainerName).
+		Str("mode", s.cosmosConfig.ChangeFeedMode).
+		Msg("Cosmos DB stream started successfully")
+	return nil
+}
+
+// Stop gracefully stops the replication stream
+func (s *CosmosDBStream) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state.Status == config.StreamStatusStopped {
+		return nil
+	}
+
+	log.Info().Str("stream", s.config.Name).Msg("Stopping Cosmos DB stream")
+
+	if s.provider != nil {
+		s.provider.Stop()
+		s.provider = nil
+	}
+	if s.cancel != nil {
+		s.cancel()
+	}
+	if s.pauseGate != nil {
+		close(s.pauseGate)
+		s.pauseGate = nil
+	}
+
+	s.state.Status = config.StreamStatusStopped
+	now := time.Now()
+	s.state.StoppedAt = &now
+	s.metrics.UpstreamConnected = false
+
+	log.Info().Str("stream", s.config.Name).Msg("Cosmos DB stream stopped")
+	return nil
+}
+
+// Pause temporarily pauses the replication stream. The change feed is held
+// back, so no continuation is saved past the events that were forwarded.
+func (s *CosmosDBStream) Pause(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state.Status != config.StreamStatusRunning {
+		return fmt.Errorf("stream is not running")
+	}
+
+	s.pauseGate = make(chan struct{})
+	s.state.Status = config.StreamStatusPaused
+	log.Info().Str("stream", s.config.Name).Msg("Cosmos DB stream paused")
+	return nil
+}
+
+// Resume resumes a paused replication stream
+func (s *CosmosDBStream) Resume(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state.Status != config.StreamStatusPaused {
+		return fmt.Errorf("stream is not paused")
+	}
+
+	close(s.pauseGate)
+	s.pauseGate = nil
+	s.state.Status = config.StreamStatusRunning
+	log.Info().Str("stream", s.config.Name).Msg("Cosmos DB stream resumed")
+	return nil
+}
+
+// GetState returns the current state of the stream
+func (s *CosmosDBStream) GetState() models.StreamState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.state
+}
+
+// GetConfig returns the configuration of the stream
+func (s *CosmosDBStream) GetConfig() config.StreamConfig {
+	return s.config
+}
+
+// GetMetrics returns current metrics for the stream
+func (s *CosmosDBStream) GetMetrics() models.ReplicationMetrics {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	metrics := s.metrics
+	if s.state.StartedAt != nil && metrics.EventsProcessed > 0 {
+		if duration := time.Since(*s.state.StartedAt); duration > 0 {
+			metrics.EventsPerSecond = float64(metrics.EventsProcessed) / duration.Seconds()
+			metrics.BytesPerSecond = float64(metrics.BytesProcessed) / duration.Seconds()
+		}
+	}
+	return metrics
+}
+
+// SetCheckpoint sets the feed range continuations the stream resumes from.
+// The checkpoint has the layout of a serialized CosmosDBPosition.
+func (s *CosmosDBStream) SetCheckpoint(checkpoint map[string]interface{}) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %w", err)
+	}
+
+	pos := position.NewCosmosDBPosition(s.cosmosConfig.DatabaseName, s.cosmosConfig.ContainerName)
+	if err := pos.Deserialize(data); err != nil {
+		return fmt.Errorf("invalid Cosmos DB checkpoint: %w", err)
+	}
+	if pos.Mode != "" && pos.Mode != s.cosmosConfig.ChangeFeedMode {
+		return fmt.Errorf("checkpoint was taken in %s mode, stream is configured for %s", pos.Mode, s.cosmosConfig.ChangeFeedMode)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state.Status == config.StreamStatusRunning || s.state.Status == config.StreamStatusPaused {
+		return fmt.Errorf("cannot set checkpoint while the stream is %s", s.state.Status)
+	}
+	s.checkpoint = pos
+	s.state.Checkpoint = checkpoint
+	log.Debug().Str("stream", s.config.Name).Str("position", pos.String()).Msg("Checkpoint updated")
+	return nil
+}
+
+// GetCheckpoint returns the feed range continuations of the last applied pages
+func (s *CosmosDBStream) GetCheckpoint() (map[string]interface{}, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.checkpoint == nil {
+		return make(map[string]interface{}), nil
+	}
+	return cosmosCheckpointMap(s.checkpoint)
+}
+
+// CommitPosition advances the continuation of a feed range once the last
+// event of a page is applied. The other events of the page carry no
+// continuation: a restart reads their page again.
+func (s *CosmosDBStream) CommitPosition(pos map[string]interface{}) error {
+	continuation, ok := pos["continuation"].(string)
+	if !ok {
+		return nil
+	}
+	feedRange, _ := pos["feed_range"].(string)
+	if feedRange == "" {
+		return fmt.Errorf("invalid Cosmos DB position: %v", pos)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.checkpoint == nil {
+		s.checkpoint = position.NewCosmosDBPosition(s.cosmosConfig.DatabaseName, s.cosmosConfig.ContainerName)
+		s.checkpoint.Mode = s.cosmosConfig.ChangeFeedMode
+	}
+	s.checkpoint.SetContinuation(feedRange, continuation)
+	checkpoint, err := cosmosCheckpointMap(s.checkpoint)
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %w", err)
+	}
+	s.state.Checkpoint = checkpoint
+	now := time.Now()
+	s.state.LastHeartbeatAt = &now
+	s.metrics.LastHeartbeatTime = now
+	return nil
+}
+
+// readChangeFeed polls the change feed until the stream is stopped
+func (s *CosmosDBStream) readChangeFeed(ctx context.Context, provider *CosmosDBStreamProvider) {
+	defer provider.cleanup()
+
+	err := provider.run(ctx)
+	if err == nil || ctx.Err() != nil {
+		return
+	}
+
+	log.Error().Err(err).Str("stream", s.config.Name).Msg("Cosmos DB change feed failed")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setError(err)
+	s.metrics.ErrorCount++
+	s.metrics.UpstreamConnected = false
+}
+
+// forwardEvents hands the provider's events to the pipeline, holding them back while paused
+func (s *CosmosDBStream) forwardEvents(ctx context.Context, feed <-chan events.RecordEvent) {
+	for {
+		var event events.RecordEvent
+		select {
+		case <-ctx.Done():
+			return
+		case event = <-feed:
+		}
+
+		// The pause is checked after receiving, so an event that was already
+		// being waited for is held back as well
+		if !s.waitWhilePaused(ctx) {
+			return
+		}
+
+		event.Stream = s.config.Name
+		select {
+		case s.eventChannel <- event:
+		case <-ctx.Done():
+			return
+		}
+
+		s.mu.Lock()
+		now := time.Now()
+		s.metrics.EventsProcessed++
+		s.metrics.BytesProcessed += int64(len(event.Data))
+		s.metrics.LastProcessedTime = now
+		s.state.EventsProcessed = s.metrics.EventsProcessed
+		s.state.LastProcessedTime = &now
+		s.mu.Unlock()
+	}
+}
+
+// waitWhilePaused blocks while the stream is paused; it returns false if the stream was stopped
+func (s *CosmosDBStream) waitWhilePaused(ctx context.Context) bool {
+	s.mu.RLock()
+	gate := s.pauseGate
+	s.mu.RUnlock()
+	if gate == nil {
+		return true
+	}
+
+	select {
+	case <-gate:
+		return ctx.Err() == nil
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// setError records an error in the stream state; the caller holds the lock
+func (s *CosmosDBStream) setError(err error) {
+	s.state.Status = config.StreamStatusError
+	lastError := err.Error()
+	s.state.LastError = &lastError
+	s.state.ErrorCount++
+}
+
+// bufferSize returns the size of the buffer between the change feed and the pipeline
+func (s *CosmosDBStream) bufferSize() int {
+	if s.config.BufferSize > 0 {
+		return s.config.BufferSize
+	}
+	return s.cosmosConfig.MaxItemCount
+}
+
+// cosmosCheckpointMap converts a position to the checkpoint layout of models.Stream
+func cosmosCheckpointMap(pos *position.CosmosDBPosition) (map[string]interface{}, error) {
+	data, err := pos.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	checkpoint := make(map[string]interface{})
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+// cosmosConfigFromStream builds the Cosmos DB configuration of a stream.
+// The endpoint comes from the source URI (an endpoint URL or a connection
+// string) or host; the container and change feed settings from the options.
+// Authentication falls back to the global Azure authentication settings.
+func cosmosConfigFromStream(streamConfig config.StreamConfig) (*CosmosDBConfig, error) {
+	source := streamConfig.Source
+	options := source.Options
+
+	cfg := &CosmosDBConfig{
+		DatabaseName:   source.Database,
+		MaxItemCount:   100,
+		PollInterval:   5 * time.Second,
+		MaxRetries:     5,
+		RetryDelay:     1 * time.Second,
+		MaxBackoff:     5 * time.Minute,
+		RequestTimeout: 30 * time.Second,
+	}
+
+	switch {
+	case strings.Contains(source.URI, "AccountEndpoint="):
+		cfg.Endpoint, cfg.AccountKey = auth.ParseCosmosConnectionString(source.URI)
+	case source.URI != "":
+		cfg.Endpoint = source.URI
+	case source.Host != "":
+		cfg.Endpoint = source.Host
+		if !strings.Contains(cfg.Endpoint, "://") {
+			cfg.Endpoint = "https://" + cfg.Endpoint
+		}
+		if source.Port > 0 {
+			cfg.Endpoint = fmt.Sprintf("%s:%d", cfg.Endpoint, source.Port)
+		}
+	}
+
+	cfg.ContainerName = cosmosOptionString(options, "container")
+	cfg.ChangeFeedMode = cosmosOptionString(options, "change_feed_mode")
+	cfg.StartFrom = cosmosOptionString(options, "start_from")
+	cfg.StartFromBeginning, _ = options["start_from_beginning"].(bool)
+	cfg.IncludeOperations = cosmosOptionStrings(options, "include_operations")
+	cfg.ExcludeOperations = cosmosOptionStrings(options, "exclude_operations")
+	cfg.InsecureSkipVerify, _ = options["insecure_skip_verify"].(bool)
+
+	var err error
+	if cfg.MaxItemCount, err = cosmosOptionInt(options, "max_item_count", cfg.MaxItemCount); err != nil {
+		return nil, err
+	}
+	if cfg.MaxRetries, err = cosmosOptionInt(options, "max_retries", cfg.MaxRetries); err != nil {
+		return nil, err
+	}
+	if cfg.PollInterval, err = cosmosOptionDuration(options, "poll_interval", cfg.PollInterval); err != nil {
+		return nil, err
+	}
+	if cfg.RequestTimeout, err = cosmosOptionDuration(options, "request_timeout", cfg.RequestTimeout); err != nil {
+		return nil, err
+	}
+
+	// Authentication: stream options take precedence over the global Azure settings
+	if global := config.GetConfig(); global != nil {
+		azureAuth := global.Azure.Authentication
+		cfg.AuthMethod, cfg.TenantID, cfg.ClientID, cfg.ClientSecret = azureAuth.Method, azureAuth.TenantID, azureAuth.ClientID, azureAuth.ClientSecret
+	}
+	if method := cosmosOptionString(options, "auth_method"); method != "" {
+		cfg.AuthMethod = method
+	}
+	if tenantID := cosmosOptionString(options, "tenant_id"); tenantID != "" {
+		cfg.TenantID = tenantID
+	}
+	if clientID := cosmosOptionString(options, "client_id"); clientID != "" {
+		cfg.ClientID = clientID
+	}
+	if clientSecret := cosmosOptionString(options, "client_secret"); clientSecret != "" {
+		cfg.ClientSecret = clientSecret
+	}
+	if key := cosmosOptionString(options, "account_key"); key != "" {
+		cfg.AccountKey = key
+	} else if cfg.AccountKey == "" && cfg.AuthMethod == CosmosAuthKey {
+		cfg.AccountKey = source.Password
+	}
+	// A connection string or explicit key selects key auth over the global settings
+	if cfg.AccountKey != "" && cosmosOptionString(options, "auth_method") == "" {
+		cfg.AuthMethod = CosmosAuthKey
+	}
+
+	if cfg.Endpoint == "" {
+		return nil, fmt.Errorf("cosmos DB endpoint is required")
+	}
+	if cfg.DatabaseName == "" {
+		return nil, fmt.Errorf("cosmos DB database name is required")
+	}
+	if cfg.ContainerName == "" {
+		return nil, fmt.Errorf("cosmos DB container name is required")
+	}
+	if err := cfg.resolveAuthSettings(); err != nil {
+		return nil, err
+	}
+	if err := cfg.resolveChangeFeedSettings(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// cosmosOptionString reads a string source option
+func cosmosOptionString(options map[string]interface{}, key string) string {
+	value, _ := options[key].(string)
+	return value
+}
+
+// cosmosOptionStrings reads a list source option, given as a list or a comma-separated string
+func cosmosOptionStrings(options map[string]interface{}, key string) []string {
+	switch value := options[key].(type) {
+	case []string:
+		return value
+	case []interface{}:
+		result := make([]string, 0, len(value))
+		for _, item := range value {
+			if str, ok := item.(string); ok {
+				result = append(result, str)
+			}
+		}
+		return result
+	case string:
+		var result []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				result = append(result, item)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+// cosmosOptionInt reads an integer source option
+func cosmosOptionInt(options map[string]interface{}, key string, defaultValue int) (int, error) {
+	switch value := options[key].(type) {
+	case nil:
+		return defaultValue, nil
+	case int:
+		return value, nil
+	case int64:
+		return int(value), nil
+	case float64:
+		return int(value), nil
+	case string:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s %q: %w", key, value, err)
+		}
+		return n, nil
+	default:
+		return 0, fmt.Errorf("invalid %s: %v", key, value)
+	}
+}
+
+// cosmosOptionDuration reads a duration source option, given as a
+// duration string ("5s") or a number of milliseconds
+func cosmosOptionDuration(options map[string]interface{}, key string, defaultValue time.Duration) (time.Duration, error) {
+	switch value := options[key].(type) {
+	case nil:
+		return defaultValue, nil
+	case string:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s %q: %w", key, value, err)
+		}
+		return d, nil
+	default:
+		ms, err := cosmosOptionInt(options, key, 0)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+}
@@ -1,7 +1,12 @@
 package streams
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -479,4 +484,347 @@ func BenchmarkCosmosDBStreamProvider_shouldFilterOperation(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		_ = provider.shouldFilterOperation("create")
 	}
-}
\ No newline at end of file
+}
+// newTestChangeFeedProvider creates a provider reading the change feed from a test server
+func newTestChangeFeedProvider(t *testing.T, serverURL string, cfg *CosmosDBConfig) (*CosmosDBStreamProvider, chan events.RecordEvent) {
+	t.Helper()
+	require.NoError(t, cfg.resolveChangeFeedSettings())
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+	eventChan := make(chan events.RecordEvent, 10)
+	provider := NewCosmosDBStreamProvider(eventChan, logger)
+	provider.config = cfg
+	provider.feedClient = newCosmosChangeFeedClient(serverURL, cfg.DatabaseName, cfg.ContainerName, cfg.ChangeFeedMode, 100, time.Second,
+		func(ctx context.Context, req *http.Request, resourceType, resourceLink string) error {
+			req.Header.Set("Authorization", "test")
+			return nil
+		})
+	require.NoError(t, provider.loadPosition(context.Background()))
+	return provider, eventChan
+}
+
+// TestCosmosDBConfig_resolveChangeFeedSettings tests change feed mode and start validation
+func TestCosmosDBConfig_resolveChangeFeedSettings(t *testing.T) {
+	cfg := &CosmosDBConfig{}
+	require.NoError(t, cfg.resolveChangeFeedSettings())
+	assert.Equal(t, CosmosChangeFeedLatestVersion, cfg.ChangeFeedMode)
+	assert.Equal(t, CosmosStartFromNow, cfg.StartFrom)
+
+	cfg = &CosmosDBConfig{StartFromBeginning: true}
+	require.NoError(t, cfg.resolveChangeFeedSettings())
+	assert.Equal(t, CosmosStartFromBeginning, cfg.StartFrom)
+
+	cfg = &CosmosDBConfig{StartFrom: "2024-01-02T03:04:05Z"}
+	require.NoError(t, cfg.resolveChangeFeedSettings())
+	assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), cfg.StartTime)
+
+	assert.Error(t, (&CosmosDBConfig{StartFrom: "yesterday"}).resolveChangeFeedSettings())
+	assert.Error(t, (&CosmosDBConfig{ChangeFeedMode: "snapshot"}).resolveChangeFeedSettings())
+	assert.Error(t, (&CosmosDBConfig{ChangeFeedMode: CosmosChangeFeedAllVersionsAndDeletes, StartFrom: CosmosStartFromBeginning}).resolveChangeFeedSettings())
+}
+
+// TestCosmosDBStreamProvider_readChangeFeed tests per feed range reads with continuations
+func TestCosmosDBStreamProvider_readChangeFeed(t *testing.T) {
+	var seen []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/dbs/testdb/colls/testcontainer/pkranges"):
+			fmt.Fprint(w, `{"PartitionKeyRanges":[{"id":"0","minInclusive":"","maxExclusive":"80"},{"id":"1","minInclusive":"80","maxExclusive":"FF"}]}`)
+		case strings.HasSuffix(r.URL.Path, "/dbs/testdb/colls/testcontainer/docs"):
+			assert.Equal(t, "Incremental feed", r.Header.Get("A-IM"))
+			rangeID := r.Header.Get("x-ms-documentdb-partitionkeyrangeid")
+			etag := r.Header.Get("If-None-Match")
+			seen = append(seen, rangeID+":"+etag)
+			if etag == "" {
+				w.Header().Set("ETag", `"`+rangeID+`-1"`)
+				fmt.Fprintf(w, `{"Documents":[{"id":"doc-%s","_ts":1}],"_count":1}`, rangeID)
+				return
+			}
+			w.Header().Set("ETag", etag)
+			w.WriteHeader(http.StatusNotModified)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	provider, eventChan := newTestChangeFeedProvider(t, server.URL, &CosmosDBConfig{
+		DatabaseName:  "testdb",
+		ContainerName: "testcontainer",
+		StartFrom:     CosmosStartFromBeginning,
+	})
+
+	require.NoError(t, provider.readChangeFeed(context.Background()))
+	require.Len(t, eventChan, 2)
+	assert.Equal(t, []string{"0:", `0:"0-1"`, "1:", `1:"1-1"`}, seen)
+	event := <-eventChan
+	assert.Equal(t, map[string]interface{}{"feed_range": "0", "continuation": `"0-1"`}, event.Position,
+		"the last event of a page carries the continuation after it")
+
+	continuation, ok := provider.position.GetContinuation("1")
+	assert.True(t, ok)
+	assert.Equal(t, `"1-1"`, continuation)
+
+	// The next read continues from the saved continuations
+	seen = nil
+	require.NoError(t, provider.readChangeFeed(context.Background()))
+	assert.Equal(t, []string{`0:"0-1"`, `1:"1-1"`}, seen)
+}
+
+// TestCosmosDBStreamProvider_readChangeFeedFailedItem tests that a page with
+// an item that fails is not skipped
+func TestCosmosDBStreamProvider_readChangeFeedFailedItem(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/pkranges"):
+			fmt.Fprint(w, `{"PartitionKeyRanges":[{"id":"0","minInclusive":"","maxExclusive":"FF"}]}`)
+		case strings.HasSuffix(r.URL.Path, "/docs"):
+			w.Header().Set("ETag", `"0-1"`)
+			fmt.Fprint(w, `{"Documents":[{"current":{"id":"a"},"metadata":{"operationType":"create","lsn":1}},{"current":{"id":"b"}}],"_count":2}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	provider, eventChan := newTestChangeFeedProvider(t, server.URL, &CosmosDBConfig{
+		DatabaseName:   "testdb",
+		ContainerName:  "testcontainer",
+		ChangeFeedMode: CosmosChangeFeedAllVersionsAndDeletes,
+	})
+
+	assert.Error(t, provider.readChangeFeed(context.Background()))
+	assert.Empty(t, eventChan, "no event of the page is dispatched")
+	_, ok := provider.position.GetContinuation("0")
+	assert.False(t, ok, "the continuation is not saved past the failed item")
+}
+
+// TestCosmosDBStreamProvider_refreshFeedRanges tests that split ranges inherit their parent's continuation
+func TestCosmosDBStreamProvider_refreshFeedRanges(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"PartitionKeyRanges":[{"id":"1","parents":["0"]},{"id":"2","parents":["0"]}]}`)
+	}))
+	defer server.Close()
+
+	provider, _ := newTestChangeFeedProvider(t, server.URL, &CosmosDBConfig{DatabaseName: "testdb", ContainerName: "testcontainer"})
+	provider.position.SetContinuation("0", `"42"`)
+
+	require.NoError(t, provider.refreshFeedRanges(context.Background()))
+	assert.Equal(t, map[string]string{"1": `"42"`, "2": `"42"`}, provider.position.Continuations)
+}
+
+// TestCosmosDBStreamProvider_processAllVersionsItem tests all versions and deletes items
+func TestCosmosDBStreamProvider_processAllVersionsItem(t *testing.T) {
+	provider, eventChan := newTestChangeFeedProvider(t, "http://localhost", &CosmosDBConfig{
+		DatabaseName:   "testdb",
+		ContainerName:  "testcontainer",
+		ChangeFeedMode: CosmosChangeFeedAllVersionsAndDeletes,
+	})
+
+	require.NoError(t, provider.processAllVersionsItem("0", []byte(
+		`{"current":{"id":"a","qty":2},"previous":{"id":"a","qty":1},"metadata":{"operationType":"replace","lsn":12}}`)))
+	event := <-eventChan
+	assert.Equal(t, events.UpdateAction, event.Action)
+	assert.JSONEq(t, `{"id":"a"}`, string(event.DocumentKey))
+	assert.JSONEq(t, `{"id":"a","qty":1}`, string(event.OldData))
+	assert.Equal(t, int64(12), event.Position["lsn"])
+
+	require.NoError(t, provider.processAllVersionsItem("0", []byte(
+		`{"metadata":{"operationType":"delete","lsn":13,"id":"b"}}`)))
+	event = <-eventChan
+	assert.Equal(t, events.DeleteAction, event.Action)
+	assert.JSONEq(t, `{"id":"b"}`, string(event.DocumentKey))
+
+	_, err := parseAllVersionsItem([]byte(`{"current":{"id":"c"}}`))
+	assert.Error(t, err)
+}
+
+// TestCosmosStatusError tests change feed error classification
+func TestCosmosStatusError(t *testing.T) {
+	logger := logrus.New()
+	provider := NewCosmosDBStreamProvider(make(chan events.RecordEvent), logger)
+
+	gone := &cosmosStatusError{StatusCode: http.StatusGone, SubStatus: 1002}
+	assert.True(t, gone.isFeedRangeGone())
+	assert.False(t, provider.isFatalError(gone))
+	assert.True(t, provider.isFatalError(fmt.Errorf("wrapped: %w", &cosmosStatusError{StatusCode: http.StatusForbidden})))
+	assert.False(t, provider.isFatalError(&cosmosStatusError{StatusCode: http.StatusTooManyRequests}))
+}
+
+// TestNewCosmosDBStream tests building the Cosmos DB configuration from the stream configuration
+func TestNewCosmosDBStream(t *testing.T) {
+	config.SetConfig(nil)
+
+	t.Run("emulator_connection_string", func(t *testing.T) {
+		stream, err := NewCosmosDBStream(config.StreamConfig{
+			Name: "cosmos",
+			Source: config.SourceConfig{
+				Type:     config.SourceTypeCosmosDB,
+				URI:      "AccountEndpoint=https://localhost:8081/;AccountKey=C2y6yDjf5/R+ob0N8A7Cgv30VRDJIWEHLM+4QDU5DE2nQ9nDuVTqobD4b8mGGyPMbIZnqyMsEcaGQy67XIw/Jw==;",
+				Database: "shop",
+				Options: map[string]interface{}{
+					"container":            "orders",
+					"poll_interval":        "2s",
+					"max_item_count":       float64(50),
+					"include_operations":   "create, replace",
+					"insecure_skip_verify": true,
+				},
+			},
+		}, make(chan events.RecordEvent))
+		require.NoError(t, err)
+
+		cfg := stream.cosmosConfig
+		assert.Equal(t, "https://localhost:8081/", cfg.Endpoint)
+		assert.Equal(t, CosmosAuthKey, cfg.AuthMethod)
+		assert.NotEmpty(t, cfg.AccountKey)
+		assert.Equal(t, "orders", cfg.ContainerName)
+		assert.Equal(t, 2*time.Second, cfg.PollInterval)
+		assert.Equal(t, 50, cfg.MaxItemCount)
+		assert.Equal(t, []string{"create", "replace"}, cfg.IncludeOperations)
+		assert.True(t, cfg.InsecureSkipVerify)
+		assert.Equal(t, CosmosChangeFeedLatestVersion, cfg.ChangeFeedMode)
+		assert.Equal(t, CosmosStartFromNow, cfg.StartFrom)
+	})
+
+	t.Run("host_with_global_identity", func(t *testing.T) {
+		config.SetConfig(&config.Config{Azure: config.AzureConfig{Authentication: config.AuthenticationConfig{
+			Method: CosmosAuthManagedIdentity, ClientID: "global-client",
+		}}})
+		defer config.SetConfig(nil)
+
+		stream, err := NewCosmosDBStream(config.StreamConfig{
+			Source: config.SourceConfig{
+				Type:     config.SourceTypeCosmosDB,
+				Host:     "account.documents.azure.com",
+				Port:     443,
+				Database: "shop",
+				Options: map[string]interface{}{
+					"container":        "orders",
+					"client_id":        "stream-client",
+					"change_feed_mode": CosmosChangeFeedAllVersionsAndDeletes,
+					"poll_interval":    1500,
+				},
+			},
+		}, make(chan events.RecordEvent))
+		require.NoError(t, err)
+
+		cfg := stream.cosmosConfig
+		assert.Equal(t, "https://account.documents.azure.com:443", cfg.Endpoint)
+		assert.Equal(t, CosmosAuthManagedIdentity, cfg.AuthMethod)
+		assert.Equal(t, "stream-client", cfg.ClientID)
+		assert.Equal(t, 1500*time.Millisecond, cfg.PollInterval)
+		assert.Equal(t, CosmosChangeFeedAllVersionsAndDeletes, cfg.ChangeFeedMode)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		base := config.SourceConfig{
+			Type:     config.SourceTypeCosmosDB,
+			URI:      "https://account.documents.azure.com:443/",
+			Database: "shop",
+			Options:  map[string]interface{}{"container": "orders"},
+		}
+
+		wrongType := base
+		wrongType.Type = config.SourceTypeMongoDB
+		noContainer := base
+		noContainer.Options = nil
+		badAuth := base
+		badAuth.Options = map[string]interface{}{"container": "orders", "auth_method": "service_principal"}
+		noKey := base
+		noKey.Options = map[string]interface{}{"container": "orders", "auth_method": "key"}
+
+		for name, source := range map[string]config.SourceConfig{
+			"wrong_type": wrongType, "no_container": noContainer, "incomplete_service_principal": badAuth, "key_without_key": noKey,
+		} {
+			_, err := NewCosmosDBStream(config.StreamConfig{Source: source}, make(chan events.RecordEvent))
+			assert.Error(t, err, name)
+		}
+	})
+}
+
+// TestCosmosKeyAuthorizer tests the master key signature against the documented example
+func TestCosmosKeyAuthorizer(t *testing.T) {
+	authorize, err := newCosmosKeyAuthorizer("dsZQi3KtZmCv1ljt3VNWNm7sQUF1y5rJfC6kv5JiwvW0EndXdDku/dkKBp8/ufDToSxLzR4y+O/0H/t4bQtVNw==")
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "https://localhost/dbs/ToDoList", nil)
+	req.Header.Set("x-ms-date", "Thu, 27 Apr 2017 00:51:12 GMT")
+	require.NoError(t, authorize(context.Background(), req, "dbs", "dbs/ToDoList"))
+
+	assert.Equal(t, strings.ToLower("type%3dmaster%26ver%3d1.0%26sig%3dc09PEVJrgp2uQRkr934kFbTqhByc7TVr3OHyqlu%2bc%2bc%3d"),
+		strings.ToLower(req.Header.Get("Authorization")))
+
+	_, err = newCosmosKeyAuthorizer("not base64!")
+	assert.Error(t, err)
+}
+
+// TestCosmosDBStream_Checkpoint tests that checkpoints round-trip through the position layout
+func TestCosmosDBStream_Checkpoint(t *testing.T) {
+	stream, err := NewCosmosDBStream(config.StreamConfig{
+		Source: config.SourceConfig{
+			Type:     config.SourceTypeCosmosDB,
+			URI:      "https://account.documents.azure.com:443/",
+			Database: "shop",
+			Options:  map[string]interface{}{"container": "orders"},
+		},
+	}, make(chan events.RecordEvent))
+	require.NoError(t, err)
+
+	checkpoint, err := stream.GetCheckpoint()
+	require.NoError(t, err)
+	assert.Empty(t, checkpoint)
+
+	require.NoError(t, stream.SetCheckpoint(map[string]interface{}{
+		"continuations": map[string]interface{}{"0": `"120"`, "1": `"98"`},
+		"mode":          CosmosChangeFeedLatestVersion,
+	}))
+	continuation, ok := stream.checkpoint.GetContinuation("1")
+	assert.True(t, ok)
+	assert.Equal(t, `"98"`, continuation)
+
+	// Applied pages advance the checkpoint, events before the last of a page do not
+	require.NoError(t, stream.CommitPosition(map[string]interface{}{"feed_range": "0", "continuation": `"130"`}))
+	require.NoError(t, stream.CommitPosition(map[string]interface{}{"feed_range": "1"}))
+	assert.Error(t, stream.CommitPosition(map[string]interface{}{"continuation": `"99"`}))
+
+	checkpoint, err = stream.GetCheckpoint()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"0": `"130"`, "1": `"98"`}, checkpoint["continuations"])
+	assert.Equal(t, "shop", checkpoint["database"])
+
+	assert.Error(t, stream.SetCheckpoint(map[string]interface{}{"mode": CosmosChangeFeedAllVersionsAndDeletes}))
+}
+
+// TestCosmosDBStream_PauseHoldsBackEvents tests that a paused stream stops forwarding events
+func TestCosmosDBStream_PauseHoldsBackEvents(t *testing.T) {
+	out := make(chan events.RecordEvent, 10)
+	stream, err := NewCosmosDBStream(config.StreamConfig{
+		Source: config.SourceConfig{
+			Type:     config.SourceTypeCosmosDB,
+			URI:      "https://account.documents.azure.com:443/",
+			Database: "shop",
+			Options:  map[string]interface{}{"container": "orders"},
+		},
+	}, out)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream.state.Status = config.StreamStatusRunning
+
+	feed := make(chan events.RecordEvent, 10)
+	go stream.forwardEvents(ctx, feed)
+
+	feed <- events.RecordEvent{Action: events.InsertAction, Data: []byte(`{"id":"1"}`)}
+	require.Eventually(t, func() bool { return len(out) == 1 }, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, stream.Pause(ctx))
+	feed <- events.RecordEvent{Action: events.InsertAction, Data: []byte(`{"id":"2"}`)}
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, out, 1)
+
+	require.NoError(t, stream.Resume(ctx))
+	require.Eventually(t, func() bool { return len(out) == 2 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(2), stream.GetMetrics().EventsProcessed)
+}
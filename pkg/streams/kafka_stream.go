@@ -29,6 +29,12 @@ type KafkaStream struct {
 	cancel        context.CancelFunc
 	consumerGroup string
 	topics        []string
+	// exactlyOnce hands offset commits to the transactional Kafka estuary
+	exactlyOnce bool
+	// session marks the offsets of the applied messages, nil between sessions
+	session sarama.ConsumerGroupSession
+	// pauseGate holds back the claims while the stream is paused
+	pauseGate pauseGate
 }
 
 // NewKafkaStream creates a new Kafka stream instance
@@ -69,6 +75,7 @@ func NewKafkaStream(streamConfig config.StreamConfig, eventChannel chan<- events
 		stopChan:      make(chan struct{}),
 		consumerGroup: consumerGroup,
 		topics:        topics,
+		exactlyOnce:   streamConfig.DeliveryGuarantee == config.DeliveryExactlyOnce,
 		state: models.StreamState{
 			Name:   streamConfig.Name,
 			Status: config.StreamStatusStopped,
@@ -139,6 +146,7 @@ func (s *KafkaStream) Stop(ctx context.Context) error {
 	}
 
 	// Update state
+	s.pauseGate.resume()
 	s.state.Status = config.StreamStatusStopped
 	now := time.Now()
 	s.state.StoppedAt = &now
@@ -156,6 +164,7 @@ func (s *KafkaStream) Pause(ctx context.Context) error {
 		return fmt.Errorf("stream is not running")
 	}
 
+	s.pauseGate.pause()
 	s.state.Status = config.StreamStatusPaused
 	log.Info().Str("stream", s.config.Name).Msg("Kafka stream paused")
 	return nil
@@ -170,6 +179,7 @@ func (s *KafkaStream) Resume(ctx context.Context) error {
 		return fmt.Errorf("stream is not paused")
 	}
 
+	s.pauseGate.resume()
 	s.state.Status = config.StreamStatusRunning
 	log.Info().Str("stream", s.config.Name).Msg("Kafka stream resumed")
 	return nil
@@ -213,6 +223,31 @@ func (s *KafkaStream) SetCheckpoint(checkpoint map[string]interface{}) error {
 	return nil
 }
 
+// CommitPosition marks the offset of an applied message, which the consumer
+// group commits. Offsets acknowledged between sessions are not marked; the
+// next session reads their messages again.
+func (s *KafkaStream) CommitPosition(position map[string]interface{}) error {
+	if s.exactlyOnce {
+		return nil
+	}
+	topic, _ := position["topic"].(string)
+	partition, ok := position["partition"].(int32)
+	if !ok || topic == "" {
+		return fmt.Errorf("invalid Kafka position: %v", position)
+	}
+	offset, ok := position["offset"].(int64)
+	if !ok {
+		return fmt.Errorf("invalid Kafka position: %v", position)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.session != nil {
+		s.session.MarkOffset(topic, partition, offset+1, "")
+	}
+	return nil
+}
+
 // GetCheckpoint returns the current checkpoint
 func (s *KafkaStream) GetCheckpoint() (map[string]interface{}, error) {
 	s.mu.RLock()
@@ -231,6 +266,13 @@ func (s *KafkaStream) setupConsumer() error {
 	config.Consumer.Return.Errors = true
 	config.Version = sarama.V2_6_0_0
 
+	// With exactly-once delivery the offsets are committed by the estuary
+	// transaction, and only committed messages of upstream producers are read
+	if s.exactlyOnce {
+		config.Consumer.Offsets.AutoCommit.Enable = false
+		config.Consumer.IsolationLevel = sarama.ReadCommitted
+	}
+
 	// Enable auto-commit by default
 	config.Consumer.Group.Session.Timeout = 10 * time.Second
 	config.Consumer.Group.Heartbeat.Interval = 3 * time.Second
@@ -324,14 +366,20 @@ type consumerGroupHandler struct {
 }
 
 // Setup is run at the beginning of a new session, before ConsumeClaim
-func (h *consumerGroupHandler) Setup(sarama.ConsumerGroupSession) error {
+func (h *consumerGroupHandler) Setup(session sarama.ConsumerGroupSession) error {
 	log.Debug().Str("stream", h.stream.config.Name).Msg("Kafka consumer session setup")
+	h.stream.mu.Lock()
+	h.stream.session = session
+	h.stream.mu.Unlock()
 	return nil
 }
 
 // Cleanup is run at the end of a session, once all ConsumeClaim goroutines have exited
 func (h *consumerGroupHandler) Cleanup(sarama.ConsumerGroupSession) error {
 	log.Debug().Str("stream", h.stream.config.Name).Msg("Kafka consumer session cleanup")
+	h.stream.mu.Lock()
+	h.stream.session = nil
+	h.stream.mu.Unlock()
 	return nil
 }
 
@@ -339,26 +387,20 @@ func (h *consumerGroupHandler) Cleanup(sarama.ConsumerGroupSession) error {
 func (h *consumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
 	// Process messages
 	for {
+		// No message is read while the stream is paused
+		if !h.stream.pauseGate.wait(session.Context()) {
+			return nil
+		}
 		select {
-		case <-h.stream.ctx.Done():
+		case <-session.Context().Done():
 			return nil
 		case message := <-claim.Messages():
 			if message == nil {
 				return nil
 			}
 
-			// Check if stream is paused
-			h.stream.mu.RLock()
-			isPaused := h.stream.state.Status == config.StreamStatusPaused
-			h.stream.mu.RUnlock()
-
-			if isPaused {
-				time.Sleep(100 * time.Millisecond)
-				continue
-			}
-
 			// Process the message
-			if err := h.processMessage(message); err != nil {
+			if err := h.processMessage(session.Context(), message); err != nil {
 				log.Error().Err(err).Str("stream", h.stream.config.Name).Msg("Failed to process Kafka message")
 				h.stream.mu.Lock()
 				h.stream.metrics.ErrorCount++
@@ -366,8 +408,7 @@ func (h *consumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession,
 				continue
 			}
 
-			// Mark message as processed
-			session.MarkMessage(message, "")
+			// The offset is marked once the message is applied, see CommitPosition
 
 			// Update metrics
 			h.stream.mu.Lock()
@@ -378,8 +419,9 @@ func (h *consumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession,
 	}
 }
 
-// processMessage processes a single Kafka message
-func (h *consumerGroupHandler) processMessage(message *sarama.ConsumerMessage) error {
+// processMessage processes a single Kafka message, waiting while the
+// pipeline is full. It fails once the session ends.
+func (h *consumerGroupHandler) processMessage(ctx context.Context, message *sarama.ConsumerMessage) error {
 	// Try to parse the message as JSON to extract action and other metadata
 	var messageData map[string]interface{}
 	if err := json.Unmarshal(message.Value, &messageData); err != nil {
@@ -419,6 +461,13 @@ func (h *consumerGroupHandler) processMessage(message *sarama.ConsumerMessage) e
 		Schema:     schema,
 		Collection: collection,
 		Data:       data,
+		Stream:     h.stream.config.Name,
+		Position: map[string]interface{}{
+			"consumer_group": h.stream.consumerGroup,
+			"topic":          message.Topic,
+			"partition":      message.Partition,
+			"offset":         message.Offset,
+		},
 	}
 
 	// Add Kafka-specific metadata
@@ -430,24 +479,18 @@ func (h *consumerGroupHandler) processMessage(message *sarama.ConsumerMessage) e
 		// Could store headers in the event if needed
 	}
 
-	// Send to event channel (non-blocking)
-	select {
-	case h.stream.eventChannel <- recordEvent:
-		log.Debug().
-			Str("stream", h.stream.config.Name).
-			Str("topic", message.Topic).
-			Int32("partition", message.Partition).
-			Int64("offset", message.Offset).
-			Str("action", action).
-			Msg("Kafka message sent to processing pipeline")
-	default:
-		log.Warn().
-			Str("stream", h.stream.config.Name).
-			Msg("Event channel full, dropping Kafka message")
-		h.stream.mu.Lock()
-		h.stream.metrics.ErrorCount++
-		h.stream.mu.Unlock()
+	// An event must never be dropped, since a later committed offset would
+	// skip it
+	if err := dispatch(ctx, h.stream.eventChannel, recordEvent); err != nil {
+		return err
 	}
+	log.Debug().
+		Str("stream", h.stream.config.Name).
+		Str("topic", message.Topic).
+		Int32("partition", message.Partition).
+		Int64("offset", message.Offset).
+		Str("action", action).
+		Msg("Kafka message sent to processing pipeline")
 
 	return nil
 }
\ No newline at end of file
@@ -41,6 +41,8 @@ type MongoDBStream struct {
 	ctx          context.Context
 	cancel       context.CancelFunc
 	telemetry    *metrics.TelemetryManager
+	// pauseGate holds back the change stream while the stream is paused
+	pauseGate pauseGate
 }
 
 // NewMongoDBStream creates a new MongoDB stream instance
@@ -142,6 +144,7 @@ func (s *MongoDBStream) Stop(ctx context.Context) error {
 	}
 
 	// Update state
+	s.pauseGate.resume()
 	s.state.Status = config.StreamStatusStopped
 	now := time.Now()
 	s.state.StoppedAt = &now
@@ -159,6 +162,7 @@ func (s *MongoDBStream) Pause(ctx context.Context) error {
 		return fmt.Errorf("stream is not running")
 	}
 
+	s.pauseGate.pause()
 	s.state.Status = config.StreamStatusPaused
 	log.Info().Str("stream", s.config.Name).Msg("MongoDB stream paused")
 	return nil
@@ -173,6 +177,7 @@ func (s *MongoDBStream) Resume(ctx context.Context) error {
 		return fmt.Errorf("stream is not paused")
 	}
 
+	s.pauseGate.resume()
 	s.state.Status = config.StreamStatusRunning
 	log.Info().Str("stream", s.config.Name).Msg("MongoDB stream resumed")
 	return nil
@@ -348,16 +353,8 @@ func (s *MongoDBStream) processEvents() {
 
 	log.Info().Str("stream", s.config.Name).Msg("Starting event processing")
 
-	for s.changeStream.Next(s.ctx) {
-		// Check if stream is paused
-		s.mu.RLock()
-		isPaused := s.state.Status == config.StreamStatusPaused
-		s.mu.RUnlock()
-
-		if isPaused {
-			time.Sleep(100 * time.Millisecond)
-			continue
-		}
+	// The change stream is not read while the stream is paused
+	for s.pauseGate.wait(s.ctx) && s.changeStream.Next(s.ctx) {
 
 		// Decode the change event
 		var changeEvent bson.M
@@ -459,6 +456,7 @@ func (s *MongoDBStream) processChangeEvent(changeEvent bson.M) error {
 		Collection:  collection,
 		DocumentKey: nil, // Ensure DocumentKey is passed correctly
 		Data:        data,
+		Stream:      s.config.Name,
 	}
 
 	if operationType == "update" || operationType == "delete" || operationType == "insert" {
@@ -501,19 +499,8 @@ func (s *MongoDBStream) processChangeEvent(changeEvent bson.M) error {
 		recordEvent.Data = emptyDocJSON
 	}
 
-	// Send to event channel (non-blocking)
-	select {
-	case s.eventChannel <- recordEvent:
-		// Event sent successfully - no additional logging needed
-	default:
-		log.Warn().
-			Str("stream", s.config.Name).
-			Str("operation", operationType).
-			Str("collection", collection).
-			Msg("Event channel full, dropping event")
-		s.mu.Lock()
-		s.metrics.ErrorCount++
-		s.mu.Unlock()
+	if err := dispatch(s.ctx, s.eventChannel, recordEvent); err != nil {
+		return fmt.Errorf("failed to send event: %w", err)
 	}
 
 return nil
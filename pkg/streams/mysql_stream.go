@@ -28,6 +28,11 @@ type MySQLStream struct {
 	mu           sync.RWMutex
 	ctx          context.Context
 	cancel       context.CancelFunc
+
+	// Source transaction tracking, owned by the event processing goroutine
+	binlogFile  string // current binlog file
+	gtid        string // GTID of the current transaction, when GTIDs are on
+	transaction string // open transaction, "" between transactions
 }
 
 // NewMySQLStream creates a new MySQL stream instance
@@ -197,12 +202,27 @@ func (s *MySQLStream) SetCheckpoint(checkpoint map[string]interface{}) error {
 	return nil
 }
 
+// CommitPosition records the binlog position of an applied transaction as
+// the checkpoint of the stream
+func (s *MySQLStream) CommitPosition(position map[string]interface{}) error {
+	if _, ok := position["file"].(string); !ok {
+		return fmt.Errorf("invalid MySQL position: %v", position)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state.Checkpoint = position
+	return nil
+}
+
 // GetCheckpoint returns the current checkpoint
 func (s *MySQLStream) GetCheckpoint() (map[string]interface{}, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	// Return current checkpoint (implementation depends on requirements)
+	if s.state.Checkpoint != nil {
+		return s.state.Checkpoint, nil
+	}
 	return make(map[string]interface{}), nil
 }
 
@@ -314,7 +334,27 @@ func (s *MySQLStream) processBinlogEvent(ev *replication.BinlogEvent) error {
 	case *replication.RowsEvent:
 		return s.processRowsEvent(e, ev.Header.EventType)
 	case *replication.QueryEvent:
+		if groupsTransactions(s.config) {
+			switch string(e.Query) {
+			case "BEGIN":
+				s.beginTransaction(ev.Header)
+				return nil
+			case "COMMIT":
+				// Transactions on non-transactional tables commit without an XID
+				return s.commitTransaction(ev.Header)
+			}
+		}
 		return s.processQueryEvent(e)
+	case *replication.XIDEvent:
+		return s.commitTransaction(ev.Header)
+	case *replication.GTIDEvent:
+		if next, err := e.GTIDNext(); err == nil {
+			s.gtid = next.String()
+		}
+		return nil
+	case *replication.RotateEvent:
+		s.binlogFile = string(e.NextLogName)
+		return nil
 	default:
 		// Ignore other event types for now
 		// log.Debug().Str("stream", s.config.Name).Interface("event",e).Msg("Ignoring non-row event")
@@ -386,6 +426,35 @@ func (s *MySQLStream) processRowsEvent(ev *replication.RowsEvent, eventType repl
 	return nil
 }
 
+// beginTransaction opens the source transaction of the rows that follow. It
+// is identified by its GTID, or by its binlog position when GTIDs are off.
+func (s *MySQLStream) beginTransaction(header *replication.EventHeader) {
+	s.transaction = s.gtid
+	if s.transaction == "" {
+		s.transaction = fmt.Sprintf("%s:%d", s.binlogFile, header.LogPos)
+	}
+}
+
+// commitTransaction ends the open source transaction with a commit event
+// carrying its binlog position
+func (s *MySQLStream) commitTransaction(header *replication.EventHeader) error {
+	if !groupsTransactions(s.config) || s.transaction == "" {
+		return nil
+	}
+	position := map[string]interface{}{"file": s.binlogFile, "pos": header.LogPos}
+	if s.gtid != "" {
+		position["gtid"] = s.gtid
+	}
+	commit := events.RecordEvent{
+		Action:      events.CommitAction,
+		Position:    position,
+		Stream:      s.config.Name,
+		Transaction: s.transaction,
+	}
+	s.transaction, s.gtid = "", ""
+	return dispatch(s.ctx, s.eventChannel, commit)
+}
+
 // processQueryEvent processes DDL and other query events
 func (s *MySQLStream) processQueryEvent(ev *replication.QueryEvent) error {
 	// For now, we'll ignore query events
@@ -408,10 +477,12 @@ func (s *MySQLStream) processRow(action, schema, table string, row []interface{}
 
 	// Create replication event using the existing RecordEvent structure
 	recordEvent := events.RecordEvent{
-		Action:     action,
-		Schema:     schema,
-		Collection: table,
-		Data:       data,
+		Action:      action,
+		Schema:      schema,
+		Collection:  table,
+		Data:        data,
+		Stream:      s.config.Name,
+		Transaction: s.transaction,
 	}
 
 	log.Debug().
@@ -420,22 +491,14 @@ func (s *MySQLStream) processRow(action, schema, table string, row []interface{}
 		Str("table", table).
 		Msg("Processed row event")
 
-	// Send to event channel (non-blocking)
-	select {
-	case s.eventChannel <- recordEvent:
-		log.Debug().
-			Str("stream", s.config.Name).
-			Str("action", action).
-			Str("table", table).
-			Msg("Event sent to processing pipeline")
-	default:
-		log.Warn().
-			Str("stream", s.config.Name).
-			Msg("Event channel full, dropping event")
-		s.mu.Lock()
-		s.metrics.ErrorCount++
-		s.mu.Unlock()
+	if err := dispatch(s.ctx, s.eventChannel, recordEvent); err != nil {
+		return fmt.Errorf("failed to send event: %w", err)
 	}
+	log.Debug().
+		Str("stream", s.config.Name).
+		Str("action", action).
+		Str("table", table).
+		Msg("Event sent to processing pipeline")
 
 	return nil
 }
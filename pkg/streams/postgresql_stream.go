@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"sync"
 	"time"
 
@@ -30,6 +31,12 @@ type PostgreSQLStream struct {
 	cancel       context.CancelFunc
 	slotName     string
 	publication  string
+	transaction  string // open source transaction, owned by the event processing goroutine
+
+	// LSN of the applied changes, confirmed to the replication slot by the
+	// event processing goroutine
+	appliedLSN   pglogrepl.LSN
+	confirmedLSN pglogrepl.LSN
 }
 
 // NewPostgreSQLStream creates a new PostgreSQL stream instance
@@ -219,15 +226,51 @@ func (s *PostgreSQLStream) SetCheckpoint(checkpoint map[string]interface{}) erro
 	return nil
 }
 
+// CommitPosition records the commit LSN of an applied transaction, which is
+// confirmed to the replication slot so the server can release its WAL
+func (s *PostgreSQLStream) CommitPosition(position map[string]interface{}) error {
+	value, _ := position["lsn"].(string)
+	lsn, err := pglogrepl.ParseLSN(value)
+	if err != nil {
+		return fmt.Errorf("invalid PostgreSQL position: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if lsn > s.appliedLSN {
+		s.appliedLSN = lsn
+		s.state.Checkpoint = position
+	}
+	return nil
+}
+
 // GetCheckpoint returns the current checkpoint
 func (s *PostgreSQLStream) GetCheckpoint() (map[string]interface{}, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	// Return current checkpoint (implementation depends on requirements)
+	if s.state.Checkpoint != nil {
+		return s.state.Checkpoint, nil
+	}
 	return make(map[string]interface{}), nil
 }
 
+// confirmPosition sends the LSN of the applied changes to the server, when
+// it moved since it was last sent
+func (s *PostgreSQLStream) confirmPosition() {
+	s.mu.RLock()
+	lsn := s.appliedLSN
+	s.mu.RUnlock()
+	if lsn <= s.confirmedLSN {
+		return
+	}
+	if err := pglogrepl.SendStandbyStatusUpdate(s.ctx, s.conn, pglogrepl.StandbyStatusUpdate{WALWritePosition: lsn}); err != nil {
+		log.Warn().Err(err).Str("stream", s.config.Name).Msg("Failed to confirm the applied LSN")
+		return
+	}
+	s.confirmedLSN = lsn
+}
+
 // setupConnection establishes connection to PostgreSQL
 func (s *PostgreSQLStream) setupConnection() error {
 	connString := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s",
@@ -390,6 +433,8 @@ func (s *PostgreSQLStream) processEvents() {
 				continue
 			}
 
+			s.confirmPosition()
+
 			// Receive message with timeout
 			ctx, cancel := context.WithTimeout(s.ctx, 2*time.Second)
 			msg, err := s.conn.ReceiveMessage(ctx)
@@ -453,17 +498,34 @@ func (s *PostgreSQLStream) processCopyData(data []byte) error {
 	case *pglogrepl.DeleteMessage:
 		return s.processDelete(msg)
 	case *pglogrepl.BeginMessage:
-		// Transaction begin - might want to track this
+		if groupsTransactions(s.config) {
+			s.transaction = strconv.FormatUint(uint64(msg.Xid), 10)
+		}
 		return nil
 	case *pglogrepl.CommitMessage:
-		// Transaction commit - might want to track this
-		return nil
+		return s.commitTransaction(msg)
 	default:
 		// Ignore other message types
 		return nil
 	}
 }
 
+// commitTransaction ends the open source transaction with a commit event
+// carrying its commit LSN
+func (s *PostgreSQLStream) commitTransaction(msg *pglogrepl.CommitMessage) error {
+	if !groupsTransactions(s.config) || s.transaction == "" {
+		return nil
+	}
+	commit := events.RecordEvent{
+		Action:      events.CommitAction,
+		Position:    map[string]interface{}{"lsn": msg.CommitLSN.String()},
+		Stream:      s.config.Name,
+		Transaction: s.transaction,
+	}
+	s.transaction = ""
+	return dispatch(s.ctx, s.eventChannel, commit)
+}
+
 // processInsert processes an INSERT operation
 func (s *PostgreSQLStream) processInsert(msg *pglogrepl.InsertMessage) error {
 	data, err := s.extractRowData(msg.Tuple)
@@ -525,28 +587,22 @@ func (s *PostgreSQLStream) extractRowData(tuple *pglogrepl.TupleData) ([]byte, e
 func (s *PostgreSQLStream) sendEvent(action string, relationID uint32, data []byte) error {
 	// Create replication event
 	recordEvent := events.RecordEvent{
-		Action:     action,
-		Schema:     s.config.Source.Database,
-		Collection: fmt.Sprintf("relation_%d", relationID), // In a real implementation, we'd resolve this to table name
-		Data:       data,
-	}
-
-	// Send to event channel (non-blocking)
-	select {
-	case s.eventChannel <- recordEvent:
-		log.Debug().
-			Str("stream", s.config.Name).
-			Str("action", action).
-			Uint32("relation", relationID).
-			Msg("Event sent to processing pipeline")
-	default:
-		log.Warn().
-			Str("stream", s.config.Name).
-			Msg("Event channel full, dropping event")
-		s.mu.Lock()
-		s.metrics.ErrorCount++
-		s.mu.Unlock()
-	}
+		Action:      action,
+		Schema:      s.config.Source.Database,
+		Collection:  fmt.Sprintf("relation_%d", relationID), // In a real implementation, we'd resolve this to table name
+		Data:        data,
+		Stream:      s.config.Name,
+		Transaction: s.transaction,
+	}
+
+	if err := dispatch(s.ctx, s.eventChannel, recordEvent); err != nil {
+		return fmt.Errorf("failed to send event: %w", err)
+	}
+	log.Debug().
+		Str("stream", s.config.Name).
+		Str("action", action).
+		Uint32("relation", relationID).
+		Msg("Event sent to processing pipeline")
 
 	return nil
 }
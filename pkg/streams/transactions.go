@@ -0,0 +1,12 @@
+package streams
+
+import (
+	"github.com/cohenjo/replicator/pkg/config"
+)
+
+// groupsTransactions reports whether a stream groups its events by source
+// transaction, stamping them with their transaction and ending each one with
+// a commit event
+func groupsTransactions(streamConfig config.StreamConfig) bool {
+	return streamConfig.Transactions != nil && streamConfig.Transactions.Enabled
+}
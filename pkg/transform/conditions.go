@@ -0,0 +1,577 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Condition operators
+const (
+	OperatorEq         = "eq"
+	OperatorNe         = "ne"
+	OperatorGt         = "gt"
+	OperatorGte        = "gte"
+	OperatorLt         = "lt"
+	OperatorLte        = "lte"
+	OperatorIn         = "in"
+	OperatorNin        = "nin"
+	OperatorNotIn      = "not_in" // alias of nin
+	OperatorContains   = "contains"
+	OperatorRegex      = "regex"
+	OperatorStartsWith = "startswith"
+	OperatorEndsWith   = "endswith"
+	OperatorBetween    = "between"
+	OperatorExists     = "exists"
+	OperatorIsNull     = "is_null"
+)
+
+// Condition value types, the type of the compared values is inferred when a
+// condition has none
+const (
+	ConditionTypeString    = "string"
+	ConditionTypeNumber    = "number"
+	ConditionTypeBoolean   = "boolean"
+	ConditionTypeTimestamp = "timestamp"
+)
+
+// ErrFieldNotFound is returned for condition fields missing from the event
+var ErrFieldNotFound = errors.New("field not found")
+
+// validOperator reports whether an operator is supported
+func validOperator(operator string) bool {
+	switch operator {
+	case OperatorEq, OperatorNe, OperatorGt, OperatorGte, OperatorLt, OperatorLte,
+		OperatorIn, OperatorNin, OperatorNotIn, OperatorContains, OperatorRegex,
+		OperatorStartsWith, OperatorEndsWith, OperatorBetween, OperatorExists, OperatorIsNull:
+		return true
+	}
+	return false
+}
+
+// validConditionType reports whether a condition type is supported
+func validConditionType(conditionType string) bool {
+	switch conditionType {
+	case "", ConditionTypeString, ConditionTypeNumber, ConditionTypeBoolean, ConditionTypeTimestamp:
+		return true
+	}
+	return false
+}
+
+// evaluateCondition evaluates a single condition or a group of conditions
+func (re *KazaamRuleEngine) evaluateCondition(data map[string]interface{}, condition Condition) (bool, error) {
+	switch {
+	case len(condition.All) > 0:
+		for _, c := range condition.All {
+			met, err := re.evaluateCondition(data, c)
+			if err != nil || !met {
+				return false, err
+			}
+		}
+		return true, nil
+	case len(condition.Any) > 0:
+		for _, c := range condition.Any {
+			met, err := re.evaluateCondition(data, c)
+			if err != nil {
+				return false, err
+			}
+			if met {
+				return true, nil
+			}
+		}
+		return false, nil
+	case condition.Not != nil:
+		met, err := re.evaluateCondition(data, *condition.Not)
+		return !met && err == nil, err
+	}
+
+	value, err := getFieldValue(data, condition.Field)
+	if err != nil {
+		if errors.Is(err, ErrFieldNotFound) {
+			switch condition.Operator {
+			case OperatorExists:
+				return false, nil
+			case OperatorIsNull:
+				return true, nil
+			}
+		}
+		return false, err
+	}
+
+	switch condition.Operator {
+	case OperatorExists:
+		return value != nil, nil
+	case OperatorIsNull:
+		return value == nil, nil
+	case OperatorEq, OperatorNe:
+		equal, err := equalValues(value, condition.Value, condition.Type)
+		if err != nil {
+			return false, err
+		}
+		return equal == (condition.Operator == OperatorEq), nil
+	case OperatorGt, OperatorGte, OperatorLt, OperatorLte:
+		if value == nil || condition.Value == nil {
+			return false, nil
+		}
+		cmp, err := compareValues(value, condition.Value, condition.Type)
+		if err != nil {
+			return false, err
+		}
+		switch condition.Operator {
+		case OperatorGt:
+			return cmp > 0, nil
+		case OperatorGte:
+			return cmp >= 0, nil
+		case OperatorLt:
+			return cmp < 0, nil
+		default:
+			return cmp <= 0, nil
+		}
+	case OperatorIn, OperatorNin, OperatorNotIn:
+		list, ok := conditionList(condition.Value)
+		if !ok {
+			return false, fmt.Errorf("operator %s needs a list of values", condition.Operator)
+		}
+		found := false
+		for _, item := range list {
+			if equal, err := equalValues(value, item, condition.Type); err == nil && equal {
+				found = true
+				break
+			}
+		}
+		return found == (condition.Operator == OperatorIn), nil
+	case OperatorBetween:
+		bounds, ok := conditionList(condition.Value)
+		if !ok || len(bounds) != 2 {
+			return false, fmt.Errorf("operator %s needs a list of two values", condition.Operator)
+		}
+		if value == nil {
+			return false, nil
+		}
+		low, err := compareValues(value, bounds[0], condition.Type)
+		if err != nil {
+			return false, err
+		}
+		high, err := compareValues(value, bounds[1], condition.Type)
+		if err != nil {
+			return false, err
+		}
+		return low >= 0 && high <= 0, nil
+	case OperatorContains:
+		return containsValue(value, condition.Value, condition.Type), nil
+	case OperatorStartsWith:
+		return value != nil && strings.HasPrefix(stringValue(value), stringValue(condition.Value)), nil
+	case OperatorEndsWith:
+		return value != nil && strings.HasSuffix(stringValue(value), stringValue(condition.Value)), nil
+	case OperatorRegex:
+		pattern, err := re.getRegexp(stringValue(condition.Value))
+		if err != nil {
+			return false, fmt.Errorf("invalid regex: %w", err)
+		}
+		return value != nil && pattern.MatchString(stringValue(value)), nil
+	default:
+		return false, fmt.Errorf("unsupported operator: %s", condition.Operator)
+	}
+}
+
+// getRegexp gets or compiles the regular expression of a regex condition
+func (re *KazaamRuleEngine) getRegexp(expr string) (*regexp.Regexp, error) {
+	re.mutex.RLock()
+	pattern, exists := re.patterns[expr]
+	re.mutex.RUnlock()
+
+	if exists {
+		return pattern, nil
+	}
+
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	re.mutex.Lock()
+	re.patterns[expr] = pattern
+	re.mutex.Unlock()
+
+	return pattern, nil
+}
+
+// pathSegment is a step of a field path: an object key or an array index
+type pathSegment struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// parseFieldPath parses a field path: a field name, a dotted path such as
+// data.user.email, or a JSONPath such as $.items[0].name or $['a.b'][-1].
+// Negative indexes count from the end of arrays.
+func parseFieldPath(path string) ([]pathSegment, error) {
+	rest := strings.TrimPrefix(path, "$")
+	if rest == "" {
+		if path == "$" {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("empty field path")
+	}
+	if path[0] != '$' && rest[0] != '[' {
+		rest = "." + rest
+	}
+
+	var segments []pathSegment
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("invalid field path %q: empty field name", path)
+			}
+			segments = append(segments, pathSegment{key: rest[:end]})
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid field path %q: unclosed bracket", path)
+			}
+			inner := rest[1:end]
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				segments = append(segments, pathSegment{key: inner[1 : len(inner)-1]})
+			} else {
+				index, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, fmt.Errorf("invalid field path %q: invalid index %q", path, inner)
+				}
+				segments = append(segments, pathSegment{index: index, isIndex: true})
+			}
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("invalid field path %q", path)
+		}
+	}
+	return segments, nil
+}
+
+// getFieldValue returns the value at a field path of the data. Values that
+// hold a JSON document, like the data of the events, are decoded to be
+// walked through.
+func getFieldValue(data map[string]interface{}, fieldPath string) (interface{}, error) {
+	segments, err := parseFieldPath(fieldPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var current interface{} = data
+	for _, segment := range segments {
+		current = decodeJSONValue(current)
+		if segment.isIndex {
+			list, ok := current.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%w: '%s' is not an array at index %d", ErrFieldNotFound, fieldPath, segment.index)
+			}
+			index := segment.index
+			if index < 0 {
+				index += len(list)
+			}
+			if index < 0 || index >= len(list) {
+				return nil, fmt.Errorf("%w: index %d of '%s' out of range", ErrFieldNotFound, segment.index, fieldPath)
+			}
+			current = list[index]
+			continue
+		}
+
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%w: '%s' is not an object at '%s'", ErrFieldNotFound, fieldPath, segment.key)
+		}
+		value, exists := object[segment.key]
+		if !exists {
+			return nil, fmt.Errorf("%w: '%s'", ErrFieldNotFound, fieldPath)
+		}
+		current = value
+	}
+	return current, nil
+}
+
+// DecodeEnvelope returns the event with its fields holding a JSON object or
+// array decoded, like the data of the events, so that the conditions on the
+// event do not decode them each. The event is copied when a field is
+// decoded, never changed.
+func DecodeEnvelope(data map[string]interface{}) map[string]interface{} {
+	var decoded map[string]interface{}
+	for key, value := range data {
+		switch value.(type) {
+		case []byte, json.RawMessage:
+		default:
+			continue
+		}
+		document := decodeJSONValue(value)
+		if _, ok := document.([]byte); ok {
+			continue
+		}
+		if _, ok := document.(json.RawMessage); ok {
+			continue
+		}
+		if decoded == nil {
+			decoded = make(map[string]interface{}, len(data))
+			for k, v := range data {
+				decoded[k] = v
+			}
+		}
+		decoded[key] = document
+	}
+	if decoded == nil {
+		return data
+	}
+	return decoded
+}
+
+// decodeJSONValue decodes a value holding a JSON object or array, other
+// values are returned as they are
+func decodeJSONValue(value interface{}) interface{} {
+	return decodeJSON(value, false)
+}
+
+// decodeJSONNumbers decodes a value holding a JSON object or array like
+// decodeJSONValue, keeping its numbers as json.Number so that they are not
+// rounded to a float64
+func decodeJSONNumbers(value interface{}) interface{} {
+	return decodeJSON(value, true)
+}
+
+func decodeJSON(value interface{}, useNumber bool) interface{} {
+	var raw []byte
+	switch v := value.(type) {
+	case []byte:
+		raw = v
+	case json.RawMessage:
+		raw = v
+	default:
+		return value
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	if useNumber {
+		decoder.UseNumber()
+	}
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil || decoder.More() {
+		return value
+	}
+	switch decoded.(type) {
+	case map[string]interface{}, []interface{}:
+		return decoded
+	}
+	return value
+}
+
+// conditionList returns the values of a list condition value
+func conditionList(value interface{}) ([]interface{}, bool) {
+	if list, ok := value.([]interface{}); ok {
+		return list, true
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, false
+	}
+	list := make([]interface{}, v.Len())
+	for i := range list {
+		list[i] = v.Index(i).Interface()
+	}
+	return list, true
+}
+
+// equalValues reports whether two values are equal as the given type
+func equalValues(a, b interface{}, conditionType string) (bool, error) {
+	if a == nil || b == nil {
+		return a == nil && b == nil, nil
+	}
+	cmp, err := compareValues(a, b, conditionType)
+	if err != nil {
+		return false, err
+	}
+	return cmp == 0, nil
+}
+
+// compareValues compares two values as the given type, or as the type
+// inferred from the values: numbers when both are numbers or one is a
+// number and the other a numeric string, booleans when both are booleans,
+// timestamps when one is a time, and strings otherwise. It returns -1, 0
+// or 1 like strings.Compare.
+func compareValues(a, b interface{}, conditionType string) (int, error) {
+	if conditionType == "" {
+		conditionType = inferConditionType(a, b)
+	}
+
+	switch conditionType {
+	case ConditionTypeNumber:
+		x, err := numberValue(a)
+		if err != nil {
+			return 0, err
+		}
+		y, err := numberValue(b)
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case x < y:
+			return -1, nil
+		case x > y:
+			return 1, nil
+		}
+		return 0, nil
+	case ConditionTypeBoolean:
+		x, err := booleanValue(a)
+		if err != nil {
+			return 0, err
+		}
+		y, err := booleanValue(b)
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case x == y:
+			return 0, nil
+		case !x:
+			return -1, nil
+		}
+		return 1, nil
+	case ConditionTypeTimestamp:
+		x, err := timestampValue(a)
+		if err != nil {
+			return 0, err
+		}
+		y, err := timestampValue(b)
+		if err != nil {
+			return 0, err
+		}
+		return x.Compare(y), nil
+	case ConditionTypeString:
+		return strings.Compare(stringValue(a), stringValue(b)), nil
+	default:
+		return 0, fmt.Errorf("unsupported condition type: %s", conditionType)
+	}
+}
+
+func inferConditionType(a, b interface{}) string {
+	_, aTime := a.(time.Time)
+	_, bTime := b.(time.Time)
+	if aTime || bTime {
+		return ConditionTypeTimestamp
+	}
+	_, aBool := a.(bool)
+	_, bBool := b.(bool)
+	if aBool && bBool {
+		return ConditionTypeBoolean
+	}
+	if isNumber(a) || isNumber(b) {
+		if _, err := numberValue(a); err == nil {
+			if _, err := numberValue(b); err == nil {
+				return ConditionTypeNumber
+			}
+		}
+	}
+	return ConditionTypeString
+}
+
+func isNumber(value interface{}) bool {
+	switch value.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, json.Number:
+		return true
+	}
+	return false
+}
+
+func numberValue(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case json.Number:
+		return v.Float64()
+	case string:
+		n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return 0, fmt.Errorf("not a number: %q", v)
+		}
+		return n, nil
+	}
+	if isNumber(value) {
+		return reflect.ValueOf(value).Convert(reflect.TypeOf(float64(0))).Float(), nil
+	}
+	return 0, fmt.Errorf("not a number: %v", value)
+}
+
+func booleanValue(value interface{}) (bool, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case string:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, fmt.Errorf("not a boolean: %q", v)
+		}
+		return b, nil
+	}
+	return false, fmt.Errorf("not a boolean: %v", value)
+}
+
+// timestampValue converts a time, an RFC 3339 string or a date, or a number
+// of seconds since the Unix epoch to a time
+func timestampValue(value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05", time.DateOnly} {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("not a timestamp: %q", v)
+	}
+	if isNumber(value) {
+		seconds, _ := numberValue(value)
+		whole, fraction := math.Modf(seconds)
+		return time.Unix(int64(whole), int64(fraction*1e9)), nil
+	}
+	return time.Time{}, fmt.Errorf("not a timestamp: %v", value)
+}
+
+func stringValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case nil:
+		return ""
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// containsValue reports whether an array holds a value, an object has a key
+// or a string contains a substring
+func containsValue(haystack, needle interface{}, conditionType string) bool {
+	switch h := decodeJSONValue(haystack).(type) {
+	case []interface{}:
+		for _, item := range h {
+			if equal, err := equalValues(item, needle, conditionType); err == nil && equal {
+				return true
+			}
+		}
+		return false
+	case map[string]interface{}:
+		_, ok := h[stringValue(needle)]
+		return ok
+	case nil:
+		return false
+	}
+	return strings.Contains(stringValue(haystack), stringValue(needle))
+}
@@ -0,0 +1,182 @@
+package transform
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cohenjo/replicator/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func conditionEvent() map[string]interface{} {
+	return map[string]interface{}{
+		"action":     "insert",
+		"collection": "orders",
+		"data":       []byte(`{"id": 42, "total": "10", "email": "ann@company.com", "vip": true, "items": [{"sku": "a-1", "qty": 9}, {"sku": "b-2", "qty": 10}], "created_at": "2024-03-01T12:00:00Z", "coupon": null}`),
+		"timestamp":  time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		"count":      10,
+	}
+}
+
+func TestConditionOperators(t *testing.T) {
+	re := NewKazaamRuleEngine()
+	tests := []struct {
+		name      string
+		condition Condition
+		met       bool
+	}{
+		{"eq", Condition{Field: "action", Operator: "eq", Value: "insert"}, true},
+		{"eq number and numeric string", Condition{Field: "data.total", Operator: "eq", Value: 10}, true},
+		{"ne", Condition{Field: "action", Operator: "ne", Value: "delete"}, true},
+		{"gt numbers", Condition{Field: "count", Operator: "gt", Value: 9}, true},
+		{"gt is numeric, not lexicographic", Condition{Field: "data.total", Operator: "gt", Value: 9, Type: "number"}, true},
+		{"gt strings", Condition{Field: "collection", Operator: "gt", Value: "users"}, false},
+		{"gte", Condition{Field: "count", Operator: "gte", Value: 10}, true},
+		{"lt", Condition{Field: "count", Operator: "lt", Value: 9}, false},
+		{"lte", Condition{Field: "count", Operator: "lte", Value: 10}, true},
+		{"lt timestamps", Condition{Field: "data.created_at", Operator: "lt", Value: "2024-03-02", Type: "timestamp"}, true},
+		{"gt inferred timestamps", Condition{Field: "timestamp", Operator: "gt", Value: "2024-02-29T00:00:00Z"}, true},
+		{"eq booleans", Condition{Field: "data.vip", Operator: "eq", Value: "true", Type: "boolean"}, true},
+		{"in", Condition{Field: "action", Operator: "in", Value: []interface{}{"insert", "update"}}, true},
+		{"in numbers", Condition{Field: "data.id", Operator: "in", Value: []int{41, 42}}, true},
+		{"nin", Condition{Field: "action", Operator: "nin", Value: []interface{}{"insert", "update"}}, false},
+		{"not_in", Condition{Field: "action", Operator: "not_in", Value: []string{"delete"}}, true},
+		{"contains string", Condition{Field: "data.email", Operator: "contains", Value: "@company"}, true},
+		{"regex", Condition{Field: "data.email", Operator: "regex", Value: `^[a-z]+@company\.com$`}, true},
+		{"regex no match", Condition{Field: "data.items[0].sku", Operator: "regex", Value: `^b-`}, false},
+		{"startswith", Condition{Field: "data.email", Operator: "startswith", Value: "ann@"}, true},
+		{"endswith", Condition{Field: "data.email", Operator: "endswith", Value: ".org"}, false},
+		{"between", Condition{Field: "count", Operator: "between", Value: []interface{}{5, 10}}, true},
+		{"between outside", Condition{Field: "data.items[0].qty", Operator: "between", Value: []interface{}{10, 20}}, false},
+		{"between timestamps", Condition{Field: "data.created_at", Operator: "between", Value: []interface{}{"2024-01-01", "2024-12-31"}, Type: "timestamp"}, true},
+		{"exists", Condition{Field: "data.email", Operator: "exists"}, true},
+		{"exists missing", Condition{Field: "data.phone", Operator: "exists"}, false},
+		{"is_null", Condition{Field: "data.coupon", Operator: "is_null"}, true},
+		{"is_null missing", Condition{Field: "data.phone", Operator: "is_null"}, true},
+		{"is_null set", Condition{Field: "data.email", Operator: "is_null"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, tt.condition.Validate())
+			met, err := re.evaluateCondition(conditionEvent(), tt.condition)
+			require.NoError(t, err)
+			assert.Equal(t, tt.met, met)
+		})
+	}
+
+	// Values that do not convert to the condition type are errors
+	_, err := re.evaluateCondition(conditionEvent(), Condition{Field: "data.email", Operator: "gt", Value: 1, Type: "number"})
+	assert.Error(t, err)
+	_, err = re.evaluateCondition(conditionEvent(), Condition{Field: "data.phone", Operator: "eq", Value: "1"})
+	assert.ErrorIs(t, err, ErrFieldNotFound)
+}
+
+func TestConditionContainsArray(t *testing.T) {
+	re := NewKazaamRuleEngine()
+	event := map[string]interface{}{"tags": []interface{}{"new", 7}}
+	met, err := re.evaluateCondition(event, Condition{Field: "tags", Operator: "contains", Value: "new"})
+	require.NoError(t, err)
+	assert.True(t, met)
+	met, err = re.evaluateCondition(event, Condition{Field: "tags", Operator: "contains", Value: 7})
+	require.NoError(t, err)
+	assert.True(t, met)
+	met, err = re.evaluateCondition(event, Condition{Field: "tags", Operator: "contains", Value: "old"})
+	require.NoError(t, err)
+	assert.False(t, met)
+}
+
+func TestConditionGroups(t *testing.T) {
+	re := NewKazaamRuleEngine()
+	insert := Condition{Field: "action", Operator: "eq", Value: "insert"}
+	large := Condition{Field: "count", Operator: "gt", Value: 100}
+
+	tests := []struct {
+		name      string
+		condition Condition
+		met       bool
+	}{
+		{"all", Condition{All: []Condition{insert, large}}, false},
+		{"any", Condition{Any: []Condition{insert, large}}, true},
+		{"not", Condition{Not: &large}, true},
+		{"nested", Condition{All: []Condition{insert, {Not: &Condition{Any: []Condition{large, {Field: "data.vip", Operator: "eq", Value: false}}}}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, tt.condition.Validate())
+			met, err := re.evaluateCondition(conditionEvent(), tt.condition)
+			require.NoError(t, err)
+			assert.Equal(t, tt.met, met)
+		})
+	}
+
+	assert.Error(t, (&Condition{All: []Condition{insert}, Any: []Condition{large}}).Validate(), "a group is one of all, any or not")
+	assert.Error(t, (&Condition{Field: "action", Not: &large}).Validate(), "groups have no field")
+	assert.Error(t, (&Condition{Any: []Condition{{Field: "action", Operator: "like"}}}).Validate(), "conditions of groups are validated")
+}
+
+func TestDecodeEnvelope(t *testing.T) {
+	event := conditionEvent()
+	decoded := DecodeEnvelope(event)
+	require.IsType(t, map[string]interface{}{}, decoded["data"])
+	assert.Equal(t, "ann@company.com", decoded["data"].(map[string]interface{})["email"])
+	assert.IsType(t, []byte{}, event["data"], "the event is not changed")
+	assert.Equal(t, "orders", decoded["collection"])
+
+	// Events without JSON documents are returned as they are
+	plain := map[string]interface{}{"action": "insert", "data": []byte(`"text"`)}
+	DecodeEnvelope(plain)["action"] = "update"
+	assert.Equal(t, "update", plain["action"])
+
+	met, err := NewKazaamRuleEngine().EvaluateConditions(context.Background(), event, []Condition{
+		{Field: "data.vip", Operator: "eq", Value: true},
+		{Field: "data.items[1].sku", Operator: "eq", Value: "b-2"},
+		{Any: []Condition{{Field: "data.id", Operator: "gt", Value: 100}, {Field: "data.total", Operator: "eq", Value: 10}}},
+	})
+	require.NoError(t, err)
+	assert.True(t, met)
+}
+
+func TestGetFieldValue(t *testing.T) {
+	event := conditionEvent()
+	event["a.b"] = map[string]interface{}{"c": 1}
+
+	tests := []struct {
+		path  string
+		value interface{}
+	}{
+		{"action", "insert"},
+		{"$.action", "insert"},
+		{"data.id", float64(42)},
+		{"$.data.items[1].sku", "b-2"},
+		{"data.items[-1].qty", float64(10)},
+		{"$['a.b'].c", 1},
+		{`$["data"]["items"][0]["sku"]`, "a-1"},
+	}
+	for _, tt := range tests {
+		value, err := getFieldValue(event, tt.path)
+		require.NoError(t, err, tt.path)
+		assert.Equal(t, tt.value, value, tt.path)
+	}
+
+	for _, path := range []string{"data.items[2].sku", "data.id.value", "action[0]", "missing"} {
+		_, err := getFieldValue(event, path)
+		assert.ErrorIs(t, err, ErrFieldNotFound, path)
+	}
+	for _, path := range []string{"data..id", "data.items[x]", "data.items[*]", "data.items[0", ""} {
+		_, err := parseFieldPath(path)
+		assert.Error(t, err, path)
+	}
+}
+
+func TestValidateConfigCondition(t *testing.T) {
+	assert.NoError(t, config.ValidateCondition(&config.Condition{Any: []config.Condition{
+		{Field: "action", Operator: "in", Value: []interface{}{"insert", "update"}},
+		{Not: &config.Condition{Field: "data.deleted_at", Operator: "is_null"}},
+	}}))
+	assert.Error(t, config.ValidateCondition(&config.Condition{Field: "count", Operator: "between", Value: []interface{}{1}}))
+	assert.Error(t, config.ValidateCondition(&config.Condition{Field: "email", Operator: "regex", Value: "("}))
+	assert.Error(t, config.ValidateCondition(&config.Condition{Field: "count", Operator: "gt", Value: 1, Type: "decimal"}))
+	assert.Error(t, config.ValidateCondition(&config.Condition{All: []config.Condition{{Field: "count"}}}))
+}
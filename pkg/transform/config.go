@@ -0,0 +1,132 @@
+package transform
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cohenjo/replicator/pkg/config"
+)
+
+// ConfigForStream converts the transformation rules of a stream to the
+// configuration of its engine. Rules without an error handling policy use
+// the policy of the stream, which defaults to DefaultErrorHandling.
+func ConfigForStream(stream string, rules config.TransformationRulesConfig) (TransformationConfig, error) {
+	transformConfig := DefaultTransformationConfig()
+	transformConfig.Stream = stream
+	if rules.Engine != "" {
+		transformConfig.Engine = rules.Engine
+	}
+
+	if rules.ErrorHandling.Strategy != "" {
+		policy, err := errorHandlingFromConfig(rules.ErrorHandling)
+		if err != nil {
+			return transformConfig, fmt.Errorf("invalid error handling of stream %s: %w", stream, err)
+		}
+		transformConfig.ErrorHandling = policy
+	}
+
+	transformConfig.Metrics.Enabled = rules.Metrics.Enabled
+	transformConfig.Metrics.DetailedMetrics = rules.Metrics.DetailedMetrics
+	if rules.Metrics.CollectionInterval != "" {
+		interval, err := time.ParseDuration(rules.Metrics.CollectionInterval)
+		if err != nil {
+			return transformConfig, fmt.Errorf("invalid metrics collection interval of stream %s: %w", stream, err)
+		}
+		transformConfig.Metrics.CollectionInterval = interval
+	}
+
+	transformConfig.Rules = make([]TransformationRule, 0, len(rules.Rules))
+	for _, rule := range rules.Rules {
+		converted, err := ruleFromConfig(rule, transformConfig.ErrorHandling)
+		if err != nil {
+			return transformConfig, fmt.Errorf("invalid rule %s of stream %s: %w", rule.Name, stream, err)
+		}
+		transformConfig.Rules = append(transformConfig.Rules, converted)
+	}
+	return transformConfig, nil
+}
+
+// ruleFromConfig converts a configured rule, falling back to the error
+// handling policy of its stream
+func ruleFromConfig(rule config.TransformationRule, streamPolicy ErrorHandlingPolicy) (TransformationRule, error) {
+	converted := TransformationRule{
+		Name:          rule.Name,
+		Description:   rule.Description,
+		Enabled:       rule.Enabled,
+		Priority:      rule.Priority,
+		Conditions:    make([]Condition, 0, len(rule.Conditions)),
+		Actions:       make([]Action, 0, len(rule.Actions)),
+		ErrorHandling: streamPolicy,
+		Metadata:      rule.Metadata,
+	}
+	for _, condition := range rule.Conditions {
+		converted.Conditions = append(converted.Conditions, conditionFromConfig(condition))
+	}
+	for _, action := range rule.Actions {
+		converted.Actions = append(converted.Actions, Action{
+			Type:   action.Type,
+			Spec:   action.Spec,
+			Input:  action.Input,
+			Target: action.Target,
+			Config: action.Config,
+		})
+	}
+	if rule.ErrorHandling.Strategy != "" {
+		policy, err := errorHandlingFromConfig(rule.ErrorHandling)
+		if err != nil {
+			return converted, err
+		}
+		converted.ErrorHandling = policy
+	}
+	return converted, nil
+}
+
+// ConditionsFromConfig converts configured conditions, such as the
+// conditions of a route
+func ConditionsFromConfig(conditions []config.Condition) []Condition {
+	converted := make([]Condition, 0, len(conditions))
+	for _, condition := range conditions {
+		converted = append(converted, conditionFromConfig(condition))
+	}
+	return converted
+}
+
+// conditionFromConfig converts a configured condition with the conditions
+// of its group
+func conditionFromConfig(condition config.Condition) Condition {
+	converted := Condition{
+		Field:    condition.Field,
+		Operator: condition.Operator,
+		Value:    condition.Value,
+		Type:     condition.Type,
+	}
+	for _, c := range condition.All {
+		converted.All = append(converted.All, conditionFromConfig(c))
+	}
+	for _, c := range condition.Any {
+		converted.Any = append(converted.Any, conditionFromConfig(c))
+	}
+	if condition.Not != nil {
+		not := conditionFromConfig(*condition.Not)
+		converted.Not = &not
+	}
+	return converted
+}
+
+func errorHandlingFromConfig(policy config.ErrorHandlingPolicy) (ErrorHandlingPolicy, error) {
+	converted := ErrorHandlingPolicy{
+		Strategy:        ErrorStrategy(policy.Strategy),
+		MaxRetries:      policy.MaxRetries,
+		DeadLetterTopic: policy.DeadLetterTopic,
+		LogErrors:       policy.LogErrors,
+		Metrics:         policy.Metrics,
+	}
+	if policy.RetryDelay != "" {
+		delay, err := time.ParseDuration(policy.RetryDelay)
+		if err != nil {
+			return converted, fmt.Errorf("invalid retry delay: %w", err)
+		}
+		converted.RetryDelay = delay
+	}
+	return converted, nil
+}
@@ -0,0 +1,85 @@
+package transform
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cohenjo/replicator/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigForStream(t *testing.T) {
+	rules := config.TransformationRulesConfig{
+		Enabled:       true,
+		ErrorHandling: config.ErrorHandlingPolicy{Strategy: "continue", RetryDelay: "2s"},
+		Metrics:       config.TransformationMetricsConfig{Enabled: true, CollectionInterval: "10s"},
+		Rules: []config.TransformationRule{
+			{
+				Name:       "flag",
+				Enabled:    true,
+				Priority:   2,
+				Conditions: []config.Condition{{Field: "action", Operator: "eq", Value: "insert"}},
+				Actions:    []config.Action{{Type: "kazaam", Spec: `[{"operation": "default", "spec": {"flagged": true}}]`, Target: "data"}},
+			},
+			{
+				Name:          "strict",
+				Enabled:       true,
+				Actions:       []config.Action{{Type: "kazaam", Spec: `[]`}},
+				ErrorHandling: config.ErrorHandlingPolicy{Strategy: "fail_fast"},
+			},
+		},
+	}
+
+	transformConfig, err := ConfigForStream("orders", rules)
+	require.NoError(t, err)
+	assert.Equal(t, "orders", transformConfig.Stream)
+	assert.Equal(t, "kazaam", transformConfig.Engine)
+	assert.Equal(t, 10*time.Second, transformConfig.Metrics.CollectionInterval)
+	require.Len(t, transformConfig.Rules, 2)
+
+	flag := transformConfig.Rules[0]
+	assert.Equal(t, 2, flag.Priority)
+	assert.Equal(t, []Condition{{Field: "action", Operator: "eq", Value: "insert"}}, flag.Conditions)
+	assert.Equal(t, "data", flag.Actions[0].Target)
+	assert.Equal(t, ErrorStrategyContinue, flag.ErrorHandling.Strategy, "rules inherit the policy of the stream")
+	assert.Equal(t, 2*time.Second, flag.ErrorHandling.RetryDelay)
+	assert.Equal(t, ErrorStrategyFailFast, transformConfig.Rules[1].ErrorHandling.Strategy)
+
+	// Without a policy the rules use the default one
+	transformConfig, err = ConfigForStream("orders", config.TransformationRulesConfig{Rules: rules.Rules[:1]})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultErrorHandling(), transformConfig.Rules[0].ErrorHandling)
+
+	rules.ErrorHandling.RetryDelay = "soon"
+	_, err = ConfigForStream("orders", rules)
+	assert.Error(t, err)
+}
+
+func TestEngineRuleMetricsLabeledByStream(t *testing.T) {
+	transformConfig, err := ConfigForStream("orders", config.TransformationRulesConfig{
+		Enabled: true,
+		Rules: []config.TransformationRule{
+			{Name: "flag", Enabled: true, Actions: []config.Action{{Type: "kazaam", Spec: `[{"operation": "default", "spec": {"flagged": true}}]`}}},
+		},
+	})
+	require.NoError(t, err)
+	engine := NewEngine(transformConfig)
+	assert.Equal(t, "orders", engine.Stream())
+
+	var observed []string
+	engine.SetRuleObserver(func(stream, rule string, success bool, duration time.Duration) {
+		assert.True(t, success)
+		observed = append(observed, stream+"/"+rule)
+	})
+
+	result, err := engine.Transform(context.Background(), map[string]interface{}{"id": 1})
+	require.NoError(t, err)
+	assert.Equal(t, true, result.Output["flagged"])
+	assert.Equal(t, []string{"orders/flag"}, observed)
+
+	metrics := engine.GetMetrics().RuleMetrics["flag"]
+	assert.Equal(t, "orders", metrics.Stream)
+	assert.Equal(t, int64(1), metrics.Successes)
+}
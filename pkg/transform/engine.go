@@ -3,11 +3,15 @@ package transform
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/cohenjo/replicator/pkg/config"
+	"github.com/itchyny/gojq"
 	"github.com/qntfy/kazaam/v4"
 	"github.com/rs/zerolog/log"
 )
@@ -18,9 +22,14 @@ type Engine struct {
 	rules      []TransformationRule
 	ruleEngine RuleEngine
 	metrics    *EngineMetrics
+	observer   RuleObserver
 	mutex      sync.RWMutex
 }
 
+// RuleObserver is called after each rule execution of an engine, to export
+// the rule metrics labeled by the stream of the engine
+type RuleObserver func(stream, rule string, success bool, duration time.Duration)
+
 // EngineMetrics tracks transformation engine metrics
 type EngineMetrics struct {
 	TotalTransformations   int64                   `json:"total_transformations"`
@@ -32,12 +41,20 @@ type EngineMetrics struct {
 	MinExecutionTime       time.Duration           `json:"min_execution_time"`
 	RuleMetrics            map[string]*RuleMetrics `json:"rule_metrics"`
 	LastTransformationAt   *time.Time              `json:"last_transformation_at,omitempty"`
+	DeadLetterEvents       int64                   `json:"dead_letter_events"`
 	mutex                  sync.RWMutex
 }
 
-// KazaamRuleEngine implements RuleEngine using Kazaam
+// KazaamRuleEngine implements RuleEngine using Kazaam, jq and Lua for the
+// actions of those types, and the built-in field and lookup actions
 type KazaamRuleEngine struct {
 	transformers map[string]*kazaam.Kazaam
+	queries      map[string]*gojq.Code
+	scripts      map[luaKey]*luaScript
+	patterns     map[string]*regexp.Regexp
+	secrets      SecretProvider
+	keys         map[string][]byte // keys of the field actions, by secret
+	stores       map[string]*ReferenceStore
 	mutex        sync.RWMutex
 }
 
@@ -51,6 +68,37 @@ func NewEngine(config TransformationConfig) *Engine {
 	}
 }
 
+// Stream returns the stream the engine transforms, empty for an engine not
+// bound to a stream
+func (e *Engine) Stream() string {
+	return e.config.Stream
+}
+
+// SetRuleObserver sets the observer of the rule executions
+func (e *Engine) SetRuleObserver(observer RuleObserver) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.observer = observer
+}
+
+// SetSecretProvider sets the provider of the keys of the field actions, to be
+// set before the rules are validated
+func (e *Engine) SetSecretProvider(secrets SecretProvider) {
+	if re, ok := e.ruleEngine.(interface{ SetSecretProvider(SecretProvider) }); ok {
+		re.SetSecretProvider(secrets)
+	}
+}
+
+// SetReferenceStores sets the reference stores of the lookup actions, to be
+// set before the rules are validated
+func (e *Engine) SetReferenceStores(stores map[string]*ReferenceStore) {
+	if re, ok := e.ruleEngine.(interface {
+		SetReferenceStores(map[string]*ReferenceStore)
+	}); ok {
+		re.SetReferenceStores(stores)
+	}
+}
+
 // NewEngineMetrics creates new engine metrics
 func NewEngineMetrics() *EngineMetrics {
 	return &EngineMetrics{
@@ -62,6 +110,10 @@ func NewEngineMetrics() *EngineMetrics {
 func NewKazaamRuleEngine() *KazaamRuleEngine {
 	return &KazaamRuleEngine{
 		transformers: make(map[string]*kazaam.Kazaam),
+		queries:      make(map[string]*gojq.Code),
+		scripts:      make(map[luaKey]*luaScript),
+		patterns:     make(map[string]*regexp.Regexp),
+		keys:         make(map[string][]byte),
 	}
 }
 
@@ -81,6 +133,7 @@ func (e *Engine) Transform(ctx context.Context, input map[string]interface{}) (*
 	e.mutex.RLock()
 	rules := make([]TransformationRule, len(e.rules))
 	copy(rules, e.rules)
+	observer := e.observer
 	e.mutex.RUnlock()
 
 	// Sort rules by priority (lower number = higher priority)
@@ -92,71 +145,122 @@ func (e *Engine) Transform(ctx context.Context, input map[string]interface{}) (*
 		}
 	}
 
-	currentData := input
+	// Actions that fan out turn the input into several documents, each
+	// transformed by the remaining actions and rules
+	documents := []map[string]interface{}{input}
+	fannedOut := false
 	allSuccess := true
 
+rules:
 	for _, rule := range rules {
 		if !rule.Enabled {
 			continue
 		}
+		ruleStart := time.Now()
+		matched := false
+		ruleSuccess := true
 
-		// Check if conditions are met
-		if len(rule.Conditions) > 0 {
-			conditionsMet, err := e.ruleEngine.EvaluateConditions(ctx, currentData, rule.Conditions)
-			if err != nil {
-				transformErr := TransformationError{
-					Rule:        rule.Name,
-					ErrorType:   "condition_evaluation",
-					Message:     fmt.Sprintf("Failed to evaluate conditions: %v", err),
-					Timestamp:   time.Now(),
-					Recoverable: true,
+		next := make([]map[string]interface{}, 0, len(documents))
+		for i, currentData := range documents {
+			// Check if conditions are met
+			if len(rule.Conditions) > 0 {
+				conditionsMet, err := e.ruleEngine.EvaluateConditions(ctx, currentData, rule.Conditions)
+				if err != nil {
+					transformErr := TransformationError{
+						Rule:        rule.Name,
+						ErrorType:   "condition_evaluation",
+						Message:     fmt.Sprintf("Failed to evaluate conditions: %v", err),
+						Timestamp:   time.Now(),
+						Recoverable: true,
+					}
+					result.Errors = append(result.Errors, transformErr)
+
+					if err := e.handleError(rule.ErrorHandling, transformErr); err != nil {
+						allSuccess = false
+						if errors.Is(err, ErrDeadLetter) {
+							result.DeadLetter = true
+							break rules
+						}
+					}
 				}
-				result.Errors = append(result.Errors, transformErr)
-				
-				if e.handleError(rule.ErrorHandling, transformErr) != nil {
-					allSuccess = false
+				if !conditionsMet {
+					next = append(next, currentData)
 					continue
 				}
 			}
-			if !conditionsMet {
-				continue
-			}
-		}
-
-		// Apply actions
-		ruleSuccess := true
-		for _, action := range rule.Actions {
-			transformedData, err := e.ruleEngine.ExecuteAction(ctx, currentData, action)
-			if err != nil {
-				transformErr := TransformationError{
-					Rule:        rule.Name,
-					Action:      action.Type,
-					ErrorType:   "action_execution",
-					Message:     fmt.Sprintf("Failed to execute action: %v", err),
-					Timestamp:   time.Now(),
-					Recoverable: true,
+			matched = true
+
+			// Apply actions
+			outputs := []map[string]interface{}{currentData}
+			for _, action := range rule.Actions {
+				transformed := make([]map[string]interface{}, 0, len(outputs))
+				failed := false
+				for _, data := range outputs {
+					actionOutputs, err := e.applyAction(ctx, data, action)
+					if err != nil {
+						transformErr := TransformationError{
+							Rule:        rule.Name,
+							Action:      action.Type,
+							ErrorType:   "action_execution",
+							Message:     fmt.Sprintf("Failed to execute action: %v", err),
+							Timestamp:   time.Now(),
+							Recoverable: true,
+						}
+						result.Errors = append(result.Errors, transformErr)
+
+						if err := e.handleError(rule.ErrorHandling, transformErr); err != nil {
+							ruleSuccess = false
+							allSuccess = false
+							if errors.Is(err, ErrDeadLetter) {
+								result.DeadLetter = true
+								e.updateRuleMetrics(observer, rule.Name, false, time.Since(ruleStart))
+								break rules
+							}
+							failed = true
+							break
+						}
+						transformed = append(transformed, data)
+						continue
+					}
+					if len(actionOutputs) != 1 {
+						fannedOut = true
+					}
+					transformed = append(transformed, actionOutputs...)
 				}
-				result.Errors = append(result.Errors, transformErr)
-				
-				if e.handleError(rule.ErrorHandling, transformErr) != nil {
-					ruleSuccess = false
-					allSuccess = false
+				if failed {
+					// The rule stops at the failed action
 					break
 				}
-			} else {
-				currentData = transformedData
+				outputs = transformed
+			}
+			next = append(next, outputs...)
+
+			if !ruleSuccess {
+				// The documents the rule did not reach are kept as they are
+				next = append(next, documents[i+1:]...)
+				break
 			}
 		}
+		documents = next
 
+		if !matched {
+			continue
+		}
 		if ruleSuccess {
 			result.AppliedRules = append(result.AppliedRules, rule.Name)
 		}
 
 		// Update rule metrics
-		e.updateRuleMetrics(rule.Name, ruleSuccess, time.Since(startTime))
+		e.updateRuleMetrics(observer, rule.Name, ruleSuccess, time.Since(ruleStart))
 	}
 
-	result.Output = currentData
+	result.Output = nil
+	if len(documents) > 0 {
+		result.Output = documents[0]
+	}
+	if fannedOut {
+		result.Outputs = documents
+	}
 	result.Success = allSuccess && len(result.Errors) == 0
 	result.ExecutionTime = time.Since(startTime)
 
@@ -166,9 +270,94 @@ func (e *Engine) Transform(ctx context.Context, input map[string]interface{}) (*
 	return result, nil
 }
 
+// applyAction runs an action on the field of a document named by its input,
+// or on the whole document, and returns the documents it produces: copies of
+// the document with each output of the action written to its target, or
+// the outputs themselves. The protected fields the outputs drop are set back.
+func (e *Engine) applyAction(ctx context.Context, document map[string]interface{}, action Action) ([]map[string]interface{}, error) {
+	input, target := actionPaths(action)
+	// Field actions hash and tokenize numbers as they are written, and keep
+	// the numbers they do not change exact
+	decode, decodeValue := decodeDocument, decodeJSONValue
+	if config.IsFieldAction(strings.ToLower(action.Type)) {
+		decode, decodeValue = decodeDocumentNumbers, decodeJSONNumbers
+	}
+	if input == "" {
+		outputs, err := e.executeAction(ctx, decode(document), action)
+		if err != nil {
+			return nil, err
+		}
+		for _, output := range outputs {
+			restoreProtected(document, output)
+		}
+		return outputs, nil
+	}
+
+	value, err := getFieldValue(document, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read action input: %w", err)
+	}
+	object, ok := decodeValue(value).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("action input '%s' is not an object", input)
+	}
+
+	outputs, err := e.executeAction(contextWithEnvelope(ctx, document), object, action)
+	if err != nil {
+		return nil, err
+	}
+	documents := make([]map[string]interface{}, 0, len(outputs))
+	for _, output := range outputs {
+		updated, err := setFieldValue(document, target, output)
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, updated)
+	}
+	return documents, nil
+}
+
+// executeAction executes an action on a document and returns the documents
+// it produces
+func (e *Engine) executeAction(ctx context.Context, data map[string]interface{}, action Action) ([]map[string]interface{}, error) {
+	if fanOut, ok := e.ruleEngine.(FanOutRuleEngine); ok {
+		return fanOut.ExecuteActionOutputs(ctx, data, action)
+	}
+	output, err := e.ruleEngine.ExecuteAction(ctx, data, action)
+	if err != nil {
+		return nil, err
+	}
+	return []map[string]interface{}{output}, nil
+}
+
+// PrepareBatch prepares the actions of the enabled rules for a batch of
+// inputs, such as reading the records the lookup actions enrich them from in
+// a single query. The inputs are transformed as well when it fails.
+func (e *Engine) PrepareBatch(ctx context.Context, inputs []map[string]interface{}) error {
+	batchEngine, ok := e.ruleEngine.(BatchRuleEngine)
+	if !ok {
+		return nil
+	}
+	var errs []error
+	for _, rule := range e.GetRules() {
+		if !rule.Enabled {
+			continue
+		}
+		for _, action := range rule.Actions {
+			if err := batchEngine.PrepareBatch(ctx, inputs, action); err != nil {
+				errs = append(errs, fmt.Errorf("failed to prepare action %s of rule %s: %w", action.Type, rule.Name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
 // TransformBatch applies transformations to a batch of input data
 func (e *Engine) TransformBatch(ctx context.Context, inputs []map[string]interface{}) ([]TransformationResult, error) {
 	results := make([]TransformationResult, len(inputs))
+	if err := e.PrepareBatch(ctx, inputs); err != nil {
+		log.Warn().Err(err).Msg("Failed to prepare the transformation of a batch")
+	}
 	
 	for i, input := range inputs {
 		result, err := e.Transform(ctx, input)
@@ -289,6 +478,7 @@ func (e *Engine) GetMetrics() TransformationMetrics {
 		MinExecutionTime:       e.metrics.MinExecutionTime,
 		RuleMetrics:            ruleMetrics,
 		LastTransformationAt:   e.metrics.LastTransformationAt,
+		ErrorMetrics:           ErrorMetrics{DeadLetterEvents: e.metrics.DeadLetterEvents},
 	}
 }
 
@@ -305,13 +495,19 @@ func (e *Engine) ResetMetrics() error {
 	e.metrics.MaxExecutionTime = 0
 	e.metrics.MinExecutionTime = 0
 	e.metrics.LastTransformationAt = nil
+	e.metrics.DeadLetterEvents = 0
 	e.metrics.RuleMetrics = make(map[string]*RuleMetrics)
 
 	return nil
 }
 
-// EvaluateConditions evaluates whether conditions are met
+// EvaluateConditions evaluates whether conditions are met. The JSON
+// documents of the event, like its data, are decoded once for all the
+// conditions.
 func (re *KazaamRuleEngine) EvaluateConditions(ctx context.Context, data map[string]interface{}, conditions []Condition) (bool, error) {
+	if len(conditions) > 0 {
+		data = DecodeEnvelope(data)
+	}
 	for _, condition := range conditions {
 		met, err := re.evaluateCondition(data, condition)
 		if err != nil {
@@ -329,11 +525,41 @@ func (re *KazaamRuleEngine) ExecuteAction(ctx context.Context, data map[string]i
 	switch strings.ToLower(action.Type) {
 	case "kazaam":
 		return re.executeKazaamAction(data, action)
+	case "jq", "lua":
+		outputs, err := re.ExecuteActionOutputs(ctx, data, action)
+		if err != nil {
+			return nil, err
+		}
+		if len(outputs) != 1 {
+			return nil, fmt.Errorf("%s action returned %d outputs, expected 1", action.Type, len(outputs))
+		}
+		return outputs[0], nil
+	case config.ActionMask, config.ActionHash, config.ActionTokenize, config.ActionRedact, config.ActionDrop, config.ActionTruncate, config.ActionGeneralize:
+		return re.executeFieldAction(ctx, data, action)
+	case config.ActionLookup:
+		return re.executeLookupAction(ctx, data, action)
 	default:
 		return nil, fmt.Errorf("unsupported action type: %s", action.Type)
 	}
 }
 
+// ExecuteActionOutputs executes a transformation action and returns each of
+// its outputs: jq actions that fan out return an output per query result,
+// Lua scripts the events their transform function returns
+func (re *KazaamRuleEngine) ExecuteActionOutputs(ctx context.Context, data map[string]interface{}, action Action) ([]map[string]interface{}, error) {
+	switch strings.ToLower(action.Type) {
+	case "jq":
+		return re.executeJQAction(ctx, data, action)
+	case "lua":
+		return re.executeLuaAction(ctx, data, action)
+	}
+	output, err := re.ExecuteAction(ctx, data, action)
+	if err != nil {
+		return nil, err
+	}
+	return []map[string]interface{}{output}, nil
+}
+
 // ValidateCondition validates a condition
 func (re *KazaamRuleEngine) ValidateCondition(condition Condition) error {
 	return condition.Validate()
@@ -350,6 +576,16 @@ func (re *KazaamRuleEngine) ValidateAction(action Action) error {
 		// Validate Kazaam spec by creating a transformer
 		_, err := kazaam.NewKazaam(action.Spec)
 		return err
+	case "jq":
+		return validateJQAction(action)
+	case "lua":
+		// Loading the script checks it defines its transform function
+		_, err := re.getLuaScript(action)
+		return err
+	case config.ActionMask, config.ActionHash, config.ActionTokenize, config.ActionRedact, config.ActionDrop, config.ActionTruncate, config.ActionGeneralize:
+		return re.validateFieldAction(action)
+	case config.ActionLookup:
+		return re.validateLookupAction(action)
 	default:
 		return fmt.Errorf("unsupported action type: %s", action.Type)
 	}
@@ -407,107 +643,8 @@ func (re *KazaamRuleEngine) getKazaamTransformer(spec string) (*kazaam.Kazaam, e
 	return newTransformer, nil
 }
 
-// evaluateCondition evaluates a single condition
-func (re *KazaamRuleEngine) evaluateCondition(data map[string]interface{}, condition Condition) (bool, error) {
-	// Get field value using dot notation
-	value, err := getFieldValue(data, condition.Field)
-	if err != nil {
-		if condition.Operator == "exists" {
-			return false, nil
-		}
-		return false, err
-	}
-
-	switch condition.Operator {
-	case "exists":
-		return value != nil, nil
-	case "eq":
-		return compareValues(value, condition.Value, "eq"), nil
-	case "ne":
-		return compareValues(value, condition.Value, "ne"), nil
-	case "gt":
-		return compareValues(value, condition.Value, "gt"), nil
-	case "lt":
-		return compareValues(value, condition.Value, "lt"), nil
-	case "contains":
-		return containsValue(value, condition.Value), nil
-	default:
-		return false, fmt.Errorf("unsupported operator: %s", condition.Operator)
-	}
-}
-
-// Helper functions for condition evaluation and field access
-func getFieldValue(data map[string]interface{}, fieldPath string) (interface{}, error) {
-	parts := strings.Split(fieldPath, ".")
-	current := data
-
-	for i, part := range parts {
-		if current == nil {
-			return nil, fmt.Errorf("field path '%s' not found", fieldPath)
-		}
-
-		if i == len(parts)-1 {
-			value, exists := current[part]
-			if !exists {
-				return nil, fmt.Errorf("field '%s' not found", part)
-			}
-			return value, nil
-		}
-
-		next, exists := current[part]
-		if !exists {
-			return nil, fmt.Errorf("field path '%s' not found", fieldPath)
-		}
-
-		nextMap, ok := next.(map[string]interface{})
-		if !ok {
-			return nil, fmt.Errorf("field '%s' is not a map", part)
-		}
-		current = nextMap
-	}
-
-	return current, nil
-}
-
-func compareValues(a, b interface{}, operator string) bool {
-	// Handle nil values
-	if a == nil || b == nil {
-		switch operator {
-		case "eq":
-			return a == b
-		case "ne":
-			return a != b
-		default:
-			return false
-		}
-	}
-
-	// Type-specific comparisons would go here
-	// For simplicity, using string representation
-	aStr := fmt.Sprintf("%v", a)
-	bStr := fmt.Sprintf("%v", b)
-
-	switch operator {
-	case "eq":
-		return aStr == bStr
-	case "ne":
-		return aStr != bStr
-	case "gt":
-		return aStr > bStr
-	case "lt":
-		return aStr < bStr
-	default:
-		return false
-	}
-}
-
-func containsValue(haystack, needle interface{}) bool {
-	haystackStr := fmt.Sprintf("%v", haystack)
-	needleStr := fmt.Sprintf("%v", needle)
-	return strings.Contains(haystackStr, needleStr)
-}
-
 // Helper methods for metrics updates
+
 func (e *Engine) updateMetrics(success bool, duration time.Duration) {
 	e.metrics.mutex.Lock()
 	defer e.metrics.mutex.Unlock()
@@ -543,13 +680,17 @@ func (e *Engine) updateMetrics(success bool, duration time.Duration) {
 	e.metrics.LastTransformationAt = &now
 }
 
-func (e *Engine) updateRuleMetrics(ruleName string, success bool, duration time.Duration) {
+func (e *Engine) updateRuleMetrics(observer RuleObserver, ruleName string, success bool, duration time.Duration) {
+	if observer != nil {
+		observer(e.config.Stream, ruleName, success, duration)
+	}
+
 	e.metrics.mutex.Lock()
 	defer e.metrics.mutex.Unlock()
 
 	metrics, exists := e.metrics.RuleMetrics[ruleName]
 	if !exists {
-		metrics = &RuleMetrics{Name: ruleName}
+		metrics = &RuleMetrics{Name: ruleName, Stream: e.config.Stream}
 		e.metrics.RuleMetrics[ruleName] = metrics
 	}
 
@@ -586,6 +727,11 @@ func (e *Engine) handleError(policy ErrorHandlingPolicy, err TransformationError
 	switch policy.Strategy {
 	case ErrorStrategyFailFast:
 		return fmt.Errorf("transformation failed: %s", err.Message)
+	case ErrorStrategyDeadLetter:
+		e.metrics.mutex.Lock()
+		e.metrics.DeadLetterEvents++
+		e.metrics.mutex.Unlock()
+		return fmt.Errorf("rule %s: %w", err.Rule, ErrDeadLetter)
 	case ErrorStrategySkip:
 		return nil // Continue processing
 	case ErrorStrategyContinue:
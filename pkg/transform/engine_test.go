@@ -0,0 +1,49 @@
+package transform
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransformDeadLetterStopsRules(t *testing.T) {
+	config := DefaultTransformationConfig()
+	config.Rules = []TransformationRule{
+		{
+			Name:     "broken",
+			Enabled:  true,
+			Priority: 1,
+			Actions:  []Action{{Type: "unknown", Spec: "{}"}},
+			ErrorHandling: ErrorHandlingPolicy{
+				Strategy:        ErrorStrategyDeadLetter,
+				DeadLetterTopic: "dlq",
+			},
+		},
+		{
+			Name:     "rename",
+			Enabled:  true,
+			Priority: 2,
+			Actions:  []Action{{Type: "kazaam", Spec: `[{"operation": "shift", "spec": {"renamed": "name"}}]`}},
+		},
+	}
+	engine := NewEngine(config)
+
+	result, err := engine.Transform(context.Background(), map[string]interface{}{"name": "a"})
+	require.NoError(t, err)
+	assert.True(t, result.DeadLetter)
+	assert.False(t, result.Success)
+	assert.Empty(t, result.AppliedRules, "rules after the dead-lettered one are not applied")
+	require.Len(t, result.Errors, 1)
+	assert.Equal(t, "broken", result.Errors[0].Rule)
+	assert.Equal(t, int64(1), engine.GetMetrics().ErrorMetrics.DeadLetterEvents)
+
+	// The skip strategy carries on with the next rules
+	config.Rules[0].ErrorHandling.Strategy = ErrorStrategySkip
+	engine = NewEngine(config)
+	result, err = engine.Transform(context.Background(), map[string]interface{}{"name": "a"})
+	require.NoError(t, err)
+	assert.False(t, result.DeadLetter)
+	assert.Contains(t, result.AppliedRules, "rename")
+}
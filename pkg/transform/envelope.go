@@ -0,0 +1,211 @@
+package transform
+
+import (
+	"context"
+	"fmt"
+)
+
+// ProtectedFields are the envelope fields of the events that transformations
+// cannot drop: an action whose output misses one of them, or sets it to null,
+// gets the value of its input back. Transformations can still change them.
+var ProtectedFields = []string{"action", "schema", "collection", "table", "documentKey", "old_data", "position", "stream"}
+
+type envelopeKey struct{}
+
+// contextWithEnvelope returns a context carrying the event an action runs
+// on, for actions scoped to a field of the event to reach its metadata
+func contextWithEnvelope(ctx context.Context, envelope map[string]interface{}) context.Context {
+	return context.WithValue(ctx, envelopeKey{}, envelope)
+}
+
+// envelopeFromContext returns the event an action runs on, data when the
+// context does not carry it
+func envelopeFromContext(ctx context.Context, data map[string]interface{}) map[string]interface{} {
+	if envelope, ok := ctx.Value(envelopeKey{}).(map[string]interface{}); ok {
+		return envelope
+	}
+	return data
+}
+
+// actionPaths returns the input and target paths of an action, each
+// defaulting to the other, both empty for an action on the whole event
+func actionPaths(action Action) (input, target string) {
+	input, target = action.Input, action.Target
+	if input == "" {
+		input = target
+	}
+	if target == "" {
+		target = input
+	}
+	return input, target
+}
+
+// protectedField returns the protected field a path is in, empty when the
+// path is not in the envelope
+func protectedField(segments []pathSegment) string {
+	if len(segments) == 0 || segments[0].isIndex {
+		return ""
+	}
+	for _, field := range ProtectedFields {
+		if segments[0].key == field {
+			return field
+		}
+	}
+	return ""
+}
+
+// validateActionPaths checks the input and target paths of an action, which
+// cannot replace a protected field or a field in one
+func validateActionPaths(action Action) error {
+	input, target := actionPaths(action)
+	if input == "" {
+		return nil
+	}
+	if _, err := parseFieldPath(input); err != nil {
+		return fmt.Errorf("invalid action input: %w", err)
+	}
+	segments, err := parseFieldPath(target)
+	if err != nil {
+		return fmt.Errorf("invalid action target: %w", err)
+	}
+	if len(segments) == 0 {
+		return fmt.Errorf("invalid action target %q: use no target to transform the whole event", target)
+	}
+	if field := protectedField(segments); field != "" {
+		return fmt.Errorf("invalid action target %q: %s is a protected field", target, field)
+	}
+	return nil
+}
+
+// decodeDocument returns a copy of a document with its values holding JSON
+// documents decoded, so actions see the data of the events as objects
+func decodeDocument(document map[string]interface{}) map[string]interface{} {
+	decoded := make(map[string]interface{}, len(document))
+	for k, v := range document {
+		decoded[k] = decodeJSONValue(v)
+	}
+	return decoded
+}
+
+// decodeDocumentNumbers is decodeDocument keeping the numbers of the JSON
+// fields as json.Number, for the actions that write them back as they were
+func decodeDocumentNumbers(document map[string]interface{}) map[string]interface{} {
+	decoded := make(map[string]interface{}, len(document))
+	for k, v := range document {
+		decoded[k] = decodeJSONNumbers(v)
+	}
+	return decoded
+}
+
+// setFieldValue returns a copy of the data with the value set at a field
+// path, creating the objects missing along the path. The data itself is not
+// modified.
+func setFieldValue(data map[string]interface{}, fieldPath string, value interface{}) (map[string]interface{}, error) {
+	segments, err := parseFieldPath(fieldPath)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := setPathValue(data, segments, value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set '%s': %w", fieldPath, err)
+	}
+	object, ok := updated.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("failed to set '%s': the event must stay an object", fieldPath)
+	}
+	return object, nil
+}
+
+// deleteFieldValue returns a copy of the data without the field at a path,
+// an array item being removed from its array. The data itself is not
+// modified.
+func deleteFieldValue(data map[string]interface{}, fieldPath string) (map[string]interface{}, error) {
+	segments, err := parseFieldPath(fieldPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("failed to delete '%s': the event cannot be deleted", fieldPath)
+	}
+	updated, err := setPathValue(data, segments, removedValue{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete '%s': %w", fieldPath, err)
+	}
+	return updated.(map[string]interface{}), nil
+}
+
+// removedValue set by setPathValue removes the field instead
+type removedValue struct{}
+
+func setPathValue(current interface{}, segments []pathSegment, value interface{}) (interface{}, error) {
+	if len(segments) == 0 {
+		return value, nil
+	}
+	segment := segments[0]
+	current = decodeJSONValue(current)
+	_, remove := value.(removedValue)
+	remove = remove && len(segments) == 1
+
+	if segment.isIndex {
+		list, ok := current.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("not an array at index %d", segment.index)
+		}
+		index := segment.index
+		if index < 0 {
+			index += len(list)
+		}
+		if index < 0 || index >= len(list) {
+			return nil, fmt.Errorf("index %d out of range", segment.index)
+		}
+		updated := make([]interface{}, len(list))
+		copy(updated, list)
+		if remove {
+			return append(updated[:index], updated[index+1:]...), nil
+		}
+		item, err := setPathValue(list[index], segments[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		updated[index] = item
+		return updated, nil
+	}
+
+	var object map[string]interface{}
+	switch c := current.(type) {
+	case nil:
+		object = map[string]interface{}{}
+	case map[string]interface{}:
+		object = c
+	default:
+		return nil, fmt.Errorf("not an object at '%s'", segment.key)
+	}
+	updated := make(map[string]interface{}, len(object)+1)
+	for k, v := range object {
+		updated[k] = v
+	}
+	if remove {
+		delete(updated, segment.key)
+		return updated, nil
+	}
+	item, err := setPathValue(object[segment.key], segments[1:], value)
+	if err != nil {
+		return nil, err
+	}
+	updated[segment.key] = item
+	return updated, nil
+}
+
+// restoreProtected sets back the protected fields of the input that an
+// output dropped
+func restoreProtected(input, output map[string]interface{}) {
+	for _, field := range ProtectedFields {
+		value, ok := input[field]
+		if !ok || value == nil {
+			continue
+		}
+		if output[field] == nil {
+			output[field] = value
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package transform
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func envelopeEvent() map[string]interface{} {
+	return map[string]interface{}{
+		"action":      "update",
+		"schema":      "shop",
+		"collection":  "users",
+		"stream":      "users",
+		"data":        []byte(`{"id": 1, "name": "ann", "address": {"city": "paris"}}`),
+		"old_data":    []byte(`{"id": 1, "name": "an"}`),
+		"documentKey": []byte(`{"id": 1}`),
+		"position":    map[string]interface{}{"file": "binlog.000001", "pos": uint32(4)},
+	}
+}
+
+func transformWith(t *testing.T, actions ...Action) *TransformationResult {
+	transformConfig := DefaultTransformationConfig()
+	transformConfig.Rules = []TransformationRule{{Name: "rule", Enabled: true, Actions: actions, ErrorHandling: DefaultErrorHandling()}}
+	engine := NewEngine(transformConfig)
+	require.NoError(t, engine.ValidateRules(transformConfig.Rules))
+	result, err := engine.Transform(context.Background(), envelopeEvent())
+	require.NoError(t, err)
+	require.True(t, result.Success, "%v", result.Errors)
+	return result
+}
+
+func TestActionTarget(t *testing.T) {
+	input := envelopeEvent()
+
+	// The action runs on the data and writes it back, the envelope is intact
+	result := transformWith(t, Action{Type: "kazaam", Spec: `[{"operation": "shift", "spec": {"user_id": "id", "city": "address.city"}}]`, Target: "data"})
+	output := result.Output
+	assert.Equal(t, map[string]interface{}{"user_id": float64(1), "city": "paris"}, output["data"])
+	for _, field := range []string{"action", "schema", "collection", "old_data", "documentKey", "position"} {
+		assert.Equal(t, input[field], output[field], field)
+	}
+
+	// The output is written to another field, leaving the input untouched
+	result = transformWith(t, Action{Type: "jq", Spec: `{name: (.name | ascii_upcase), table: $collection}`, Input: "data", Target: "_metadata.display"})
+	output = result.Output
+	assert.Equal(t, input["data"], output["data"])
+	assert.Equal(t, map[string]interface{}{"display": map[string]interface{}{"name": "ANN", "table": "users"}}, output["_metadata"])
+
+	// Nested fields of the data
+	result = transformWith(t, Action{Type: "lua", Spec: `function transform(address) address.city = string.upper(address.city) return address end`, Target: "data.address"})
+	data := result.Output["data"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"city": "PARIS"}, data["address"])
+	assert.Equal(t, "ann", data["name"])
+
+	// Fanned out outputs are each written to a copy of the event
+	result = transformWith(t, Action{Type: "jq", Spec: `{id: .id, part: (1, 2)}`, Target: "data", Config: map[string]interface{}{"multiple_outputs": "fan_out"}})
+	require.Len(t, result.Documents(), 2)
+	for i, document := range result.Documents() {
+		assert.Equal(t, map[string]interface{}{"id": float64(1), "part": i + 1}, document["data"])
+		assert.Equal(t, "update", document["action"])
+	}
+}
+
+func TestProtectedFields(t *testing.T) {
+	input := envelopeEvent()
+
+	// A transformation of the whole event cannot drop the envelope
+	result := transformWith(t, Action{Type: "kazaam", Spec: `[{"operation": "shift", "spec": {"data": "data"}}]`})
+	output := result.Output
+	assert.Equal(t, map[string]interface{}{"id": float64(1), "name": "ann", "address": map[string]interface{}{"city": "paris"}}, output["data"], "actions see the data as an object")
+	for _, field := range []string{"action", "schema", "collection", "stream", "old_data", "documentKey", "position"} {
+		assert.Equal(t, input[field], output[field], field)
+	}
+
+	// Nor null it, but it can change it
+	result = transformWith(t, Action{Type: "jq", Spec: `.documentKey = null | .collection = "customers"`})
+	assert.Equal(t, input["documentKey"], result.Output["documentKey"])
+	assert.Equal(t, "customers", result.Output["collection"])
+}
+
+func TestValidateActionPaths(t *testing.T) {
+	assert.NoError(t, (&Action{Type: "jq", Spec: ".", Target: "data"}).Validate())
+	assert.NoError(t, (&Action{Type: "jq", Spec: ".", Input: "data.items[0]", Target: "data.first"}).Validate())
+	assert.Error(t, (&Action{Type: "jq", Spec: ".", Target: "old_data"}).Validate(), "protected fields cannot be replaced")
+	assert.Error(t, (&Action{Type: "jq", Spec: ".", Input: "data", Target: "documentKey"}).Validate())
+	assert.Error(t, (&Action{Type: "jq", Spec: ".", Input: "data", Target: "documentKey._id"}).Validate(), "nor the fields in them")
+	assert.Error(t, (&Action{Type: "jq", Spec: ".", Input: "position.offset"}).Validate())
+	assert.Error(t, (&Action{Type: "jq", Spec: ".", Target: "data..id"}).Validate())
+	assert.Error(t, (&Action{Type: "jq", Spec: ".", Target: "$"}).Validate())
+
+	// The input of an action must be an object
+	transformConfig := DefaultTransformationConfig()
+	transformConfig.Rules = []TransformationRule{{Name: "rule", Enabled: true, Actions: []Action{{Type: "jq", Spec: ".", Input: "action", Target: "data"}}, ErrorHandling: DefaultErrorHandling()}}
+	result, err := NewEngine(transformConfig).Transform(context.Background(), envelopeEvent())
+	require.NoError(t, err)
+	assert.False(t, result.Success)
+	assert.Equal(t, envelopeEvent()["data"], result.Output["data"])
+}
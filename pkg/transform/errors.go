@@ -22,4 +22,5 @@ var (
 	ErrValidationFailed         = errors.New("validation failed")
 	ErrConditionEvaluationFailed = errors.New("condition evaluation failed")
 	ErrActionExecutionFailed    = errors.New("action execution failed")
+	ErrDeadLetter               = errors.New("event sent to the dead letter queue")
 )
\ No newline at end of file
@@ -0,0 +1,118 @@
+package transform
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/cohenjo/replicator/pkg/config"
+	"github.com/itchyny/gojq"
+)
+
+// executeJQAction runs the query of a jq action and returns its outputs,
+// each an object. The query variables are bound to the metadata of the
+// event, also when the action runs on a field of the event. A query
+// returning more or less than one output fails, unless the action fans out,
+// in which case no output drops the event.
+func (re *KazaamRuleEngine) executeJQAction(ctx context.Context, data map[string]interface{}, action Action) ([]map[string]interface{}, error) {
+	code, err := re.getJQQuery(action.Spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile jq query: %w", err)
+	}
+
+	// Round-trip the event through JSON so the query only sees JSON values
+	inputJSON, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal input data: %w", err)
+	}
+	var input interface{}
+	if err := json.Unmarshal(inputJSON, &input); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal input data: %w", err)
+	}
+
+	var outputs []map[string]interface{}
+	iter := code.RunWithContext(ctx, input, jqVariableValues(envelopeFromContext(ctx, data))...)
+	for {
+		value, ok := iter.Next()
+		if !ok {
+			break
+		}
+		if err, ok := value.(error); ok {
+			var halt *gojq.HaltError
+			if errors.As(err, &halt) && halt.Value() == nil {
+				break
+			}
+			return nil, fmt.Errorf("jq query failed: %w", err)
+		}
+		output, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("jq query returned %s, expected an object", gojq.TypeOf(value))
+		}
+		outputs = append(outputs, output)
+	}
+
+	if len(outputs) != 1 && !jqFansOut(action) {
+		return nil, fmt.Errorf("jq query returned %d outputs, expected 1", len(outputs))
+	}
+	return outputs, nil
+}
+
+// getJQQuery gets or compiles the jq query of the given spec
+func (re *KazaamRuleEngine) getJQQuery(spec string) (*gojq.Code, error) {
+	re.mutex.RLock()
+	code, exists := re.queries[spec]
+	re.mutex.RUnlock()
+
+	if exists {
+		return code, nil
+	}
+
+	code, err := compileJQ(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	re.mutex.Lock()
+	re.queries[spec] = code
+	re.mutex.Unlock()
+
+	return code, nil
+}
+
+// validateJQAction compiles the query of a jq action and checks its
+// multiple outputs policy
+func validateJQAction(action Action) error {
+	if _, err := compileJQ(action.Spec); err != nil {
+		return fmt.Errorf("invalid jq query: %w", err)
+	}
+	if outputs, ok := action.Config[config.JQMultipleOutputs]; ok && outputs != config.JQOutputsReject && outputs != config.JQOutputsFanOut {
+		return fmt.Errorf("invalid %s for jq action: %v", config.JQMultipleOutputs, outputs)
+	}
+	return nil
+}
+
+func compileJQ(spec string) (*gojq.Code, error) {
+	query, err := gojq.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	return gojq.Compile(query, gojq.WithVariables(config.JQVariables))
+}
+
+// jqVariableValues returns the values of config.JQVariables for an event,
+// null for the metadata the event does not have
+func jqVariableValues(data map[string]interface{}) []interface{} {
+	values := make([]interface{}, len(config.JQVariables))
+	for i, variable := range config.JQVariables {
+		if value, ok := data[variable[1:]].(string); ok {
+			values[i] = value
+		}
+	}
+	return values
+}
+
+// jqFansOut reports whether each output of a jq action becomes an event
+func jqFansOut(action Action) bool {
+	return action.Config[config.JQMultipleOutputs] == config.JQOutputsFanOut
+}
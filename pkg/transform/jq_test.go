@@ -0,0 +1,75 @@
+package transform
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cohenjo/replicator/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJQAction(t *testing.T) {
+	ctx := context.Background()
+	re := NewKazaamRuleEngine()
+	event := map[string]interface{}{"action": "insert", "schema": "shop", "collection": "orders", "stream": "orders", "total": 10}
+
+	output, err := re.ExecuteAction(ctx, event, Action{Type: "jq", Spec: `{total: (.total * 2), source: "\($schema).\($collection)", op: $action, stream: $stream}`})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"total": float64(20), "source": "shop.orders", "op": "insert", "stream": "orders"}, output)
+
+	// Queries are compiled once
+	_, err = re.ExecuteAction(ctx, event, Action{Type: "jq", Spec: `{total: (.total * 2), source: "\($schema).\($collection)", op: $action, stream: $stream}`})
+	require.NoError(t, err)
+	assert.Len(t, re.queries, 1)
+
+	// Missing metadata is bound to null
+	output, err = re.ExecuteAction(ctx, map[string]interface{}{"total": 1}, Action{Type: "jq", Spec: `.stream = $stream`})
+	require.NoError(t, err)
+	assert.Nil(t, output["stream"])
+
+	_, err = re.ExecuteAction(ctx, event, Action{Type: "jq", Spec: `.total`})
+	assert.Error(t, err, "outputs must be objects")
+}
+
+func TestJQActionMultipleOutputs(t *testing.T) {
+	ctx := context.Background()
+	re := NewKazaamRuleEngine()
+	event := map[string]interface{}{"items": []interface{}{"a", "b"}}
+	split := Action{Type: "jq", Spec: `.items[] as $item | {item: $item}`}
+
+	_, err := re.ExecuteActionOutputs(ctx, event, split)
+	assert.Error(t, err, "multiple outputs are rejected by default")
+
+	split.Config = map[string]interface{}{config.JQMultipleOutputs: config.JQOutputsFanOut}
+	outputs, err := re.ExecuteActionOutputs(ctx, event, split)
+	require.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{{"item": "a"}, {"item": "b"}}, outputs)
+
+	// Fanned out documents go through the following actions and rules
+	transformConfig := DefaultTransformationConfig()
+	transformConfig.Rules = []TransformationRule{
+		{Name: "split", Enabled: true, Priority: 1, Actions: []Action{split}, ErrorHandling: DefaultErrorHandling()},
+		{Name: "tag", Enabled: true, Priority: 2, Actions: []Action{{Type: "jq", Spec: `.tagged = true`}}, ErrorHandling: DefaultErrorHandling()},
+	}
+	result, err := NewEngine(transformConfig).Transform(ctx, event)
+	require.NoError(t, err)
+	assert.True(t, result.Success)
+	assert.Equal(t, []map[string]interface{}{{"item": "a", "tagged": true}, {"item": "b", "tagged": true}}, result.Documents())
+
+	// A fan-out without outputs drops the event
+	result, err = NewEngine(transformConfig).Transform(ctx, map[string]interface{}{"items": []interface{}{}})
+	require.NoError(t, err)
+	assert.Empty(t, result.Documents())
+}
+
+func TestValidateJQAction(t *testing.T) {
+	re := NewKazaamRuleEngine()
+	assert.NoError(t, re.ValidateAction(Action{Type: "jq", Spec: `.schema = $schema`}))
+	assert.Error(t, re.ValidateAction(Action{Type: "jq", Spec: `.a |`}), "syntax errors")
+	assert.Error(t, re.ValidateAction(Action{Type: "jq", Spec: `.a = $unknown`}), "unknown variables")
+	assert.Error(t, re.ValidateAction(Action{Type: "jq", Spec: `.`, Config: map[string]interface{}{config.JQMultipleOutputs: "merge"}}))
+
+	assert.NoError(t, config.ValidateAction(&config.Action{Type: "jq", Spec: `.collection = $collection`}))
+	assert.Error(t, config.ValidateAction(&config.Action{Type: "jq", Spec: `.a = $unknown`}), "queries are validated when the config is loaded")
+}
@@ -0,0 +1,442 @@
+package transform
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cohenjo/replicator/pkg/config"
+)
+
+// ErrLookupMiss is returned by the lookup actions failing on a key their
+// store does not have
+var ErrLookupMiss = errors.New("lookup key not found")
+
+// ReferenceSource reads the records of a reference store by key
+type ReferenceSource interface {
+	// Lookup returns the records of the keys it has, by their lookupKey
+	Lookup(ctx context.Context, keys []interface{}) (map[string]map[string]interface{}, error)
+
+	// Close releases the connection of the source
+	Close() error
+}
+
+// ReferenceStore is a reference source behind a least recently used cache
+// whose entries expire. The keys the source does not have are cached too, so
+// misses do not query it again until they expire.
+type ReferenceStore struct {
+	name    string
+	source  ReferenceSource
+	cache   *lookupCache
+	timeout time.Duration
+	hits    atomic.Int64
+	misses  atomic.Int64
+}
+
+// NewReferenceStore opens the reference store of a configuration. Database
+// connections are established on the first lookup.
+func NewReferenceStore(cfg config.ReferenceStoreConfig) (*ReferenceStore, error) {
+	if err := config.ValidateReferenceStoreConfig(&cfg); err != nil {
+		return nil, err
+	}
+	source, err := openReferenceSource(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	size := cfg.CacheSize
+	if size == 0 {
+		size = config.DefaultReferenceCacheSize
+	}
+	ttl := config.DefaultReferenceCacheTTL
+	if cfg.CacheTTL != "" {
+		ttl, _ = time.ParseDuration(cfg.CacheTTL)
+	}
+	timeout := config.DefaultReferenceTimeout
+	if cfg.Timeout != "" {
+		timeout, _ = time.ParseDuration(cfg.Timeout)
+	}
+	return newReferenceStore(cfg.Name, source, size, ttl, timeout), nil
+}
+
+func newReferenceStore(name string, source ReferenceSource, size int, ttl, timeout time.Duration) *ReferenceStore {
+	return &ReferenceStore{
+		name:    name,
+		source:  source,
+		cache:   newLookupCache(size, ttl),
+		timeout: timeout,
+	}
+}
+
+// NewReferenceStores opens the reference stores of a configuration, by name
+func NewReferenceStores(cfgs []config.ReferenceStoreConfig) (map[string]*ReferenceStore, error) {
+	stores := make(map[string]*ReferenceStore, len(cfgs))
+	for _, cfg := range cfgs {
+		store, err := NewReferenceStore(cfg)
+		if err != nil {
+			CloseReferenceStores(stores)
+			return nil, fmt.Errorf("failed to open reference store %s: %w", cfg.Name, err)
+		}
+		stores[cfg.Name] = store
+	}
+	return stores, nil
+}
+
+// CloseReferenceStores closes reference stores
+func CloseReferenceStores(stores map[string]*ReferenceStore) error {
+	var errs []error
+	for _, store := range stores {
+		if err := store.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Name returns the name of the store
+func (s *ReferenceStore) Name() string {
+	return s.name
+}
+
+// Lookup returns the records of the keys the store has, by their lookup
+// key. The keys missing from the cache are read from the source in a single
+// query. Records are shared and must not be modified.
+func (s *ReferenceStore) Lookup(ctx context.Context, keys []interface{}) (map[string]map[string]interface{}, error) {
+	found := make(map[string]map[string]interface{}, len(keys))
+	seen := make(map[string]bool, len(keys))
+	var missing []interface{}
+	now := time.Now()
+	for _, key := range keys {
+		k := lookupKey(key)
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		if record, ok, cached := s.cache.get(k, now); cached {
+			s.hits.Add(1)
+			if ok {
+				found[k] = record
+			}
+			continue
+		}
+		s.misses.Add(1)
+		missing = append(missing, normalizeLookupKey(key))
+	}
+	if len(missing) == 0 {
+		return found, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	records, err := s.source.Lookup(ctx, missing)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up %d keys in %s: %w", len(missing), s.name, err)
+	}
+	for _, key := range missing {
+		k := lookupKey(key)
+		record, ok := records[k]
+		s.cache.put(k, record, ok, now)
+		if ok {
+			found[k] = record
+		}
+	}
+	return found, nil
+}
+
+// CacheStats returns the number of keys read from the cache and from the
+// source
+func (s *ReferenceStore) CacheStats() (hits, misses int64) {
+	return s.hits.Load(), s.misses.Load()
+}
+
+// Close closes the source of the store
+func (s *ReferenceStore) Close() error {
+	return s.source.Close()
+}
+
+// SetReferenceStores sets the reference stores of the lookup actions
+func (re *KazaamRuleEngine) SetReferenceStores(stores map[string]*ReferenceStore) {
+	re.mutex.Lock()
+	defer re.mutex.Unlock()
+	re.stores = stores
+}
+
+// executeLookupAction enriches an event with the record of its key: the
+// record is written to the target field, and the record fields to their
+// paths. Events without a key or whose key is not found follow the miss
+// policy of the action.
+func (re *KazaamRuleEngine) executeLookupAction(ctx context.Context, data map[string]interface{}, action Action) (map[string]interface{}, error) {
+	settings, store, err := re.lookupStore(action)
+	if err != nil {
+		return nil, err
+	}
+
+	var record map[string]interface{}
+	found := false
+	key, err := getFieldValue(data, settings.Key)
+	if err != nil && !errors.Is(err, ErrFieldNotFound) {
+		return nil, err
+	}
+	if err == nil && key != nil {
+		records, err := store.Lookup(ctx, []interface{}{key})
+		if err != nil {
+			return nil, err
+		}
+		record, found = records[lookupKey(key)]
+	}
+
+	if !found {
+		switch settings.OnMiss {
+		case config.LookupMissSkip:
+			return data, nil
+		case config.LookupMissFail:
+			return nil, fmt.Errorf("%w: %v in %s", ErrLookupMiss, key, settings.Store)
+		}
+	}
+
+	output := data
+	if settings.TargetField != "" {
+		var value interface{}
+		if found {
+			value = cloneJSONValue(record)
+		}
+		if output, err = setFieldValue(output, settings.TargetField, value); err != nil {
+			return nil, err
+		}
+	}
+	paths := make([]string, 0, len(settings.Fields))
+	for path := range settings.Fields {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		var value interface{}
+		if found {
+			value = cloneJSONValue(record[settings.Fields[path]])
+		}
+		if output, err = setFieldValue(output, path, value); err != nil {
+			return nil, err
+		}
+	}
+	return output, nil
+}
+
+// PrepareBatch reads the records of the keys of a batch of events for a
+// lookup action in a single query, so the lookups of the events hit the
+// cache. The keys are read from the events as they are before the rules
+// run; the events whose key an earlier action changes are looked up on
+// their own.
+func (re *KazaamRuleEngine) PrepareBatch(ctx context.Context, documents []map[string]interface{}, action Action) error {
+	if strings.ToLower(action.Type) != config.ActionLookup {
+		return nil
+	}
+	settings, store, err := re.lookupStore(action)
+	if err != nil {
+		return err
+	}
+
+	input, _ := actionPaths(action)
+	keys := make([]interface{}, 0, len(documents))
+	for _, document := range documents {
+		var data interface{} = document
+		if input != "" {
+			if data, err = getFieldValue(document, input); err != nil {
+				continue
+			}
+		}
+		object, ok := decodeJSONValue(data).(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if key, err := getFieldValue(object, settings.Key); err == nil && key != nil {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err = store.Lookup(ctx, keys)
+	return err
+}
+
+// lookupStore returns the settings and store of a lookup action
+func (re *KazaamRuleEngine) lookupStore(action Action) (config.LookupSettings, *ReferenceStore, error) {
+	settings, err := config.ParseLookupSettings(action.Config)
+	if err != nil {
+		return settings, nil, err
+	}
+	re.mutex.RLock()
+	store, ok := re.stores[settings.Store]
+	re.mutex.RUnlock()
+	if !ok {
+		return settings, nil, fmt.Errorf("unknown reference store: %s", settings.Store)
+	}
+	return settings, store, nil
+}
+
+// validateLookupAction checks the settings and paths of a lookup action and
+// that its store is configured
+func (re *KazaamRuleEngine) validateLookupAction(action Action) error {
+	settings, _, err := re.lookupStore(action)
+	if err != nil {
+		return err
+	}
+	if _, err := parseFieldPath(settings.Key); err != nil {
+		return fmt.Errorf("invalid lookup key: %w", err)
+	}
+	targets := make([]string, 0, len(settings.Fields)+1)
+	if settings.TargetField != "" {
+		targets = append(targets, settings.TargetField)
+	}
+	for path := range settings.Fields {
+		targets = append(targets, path)
+	}
+	for _, target := range targets {
+		if err := validateActionPaths(Action{Target: target}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookupKey returns the text keys are matched by, so that the numbers and
+// strings of events, databases and files match: integral numbers are
+// written without a fraction
+func lookupKey(key interface{}) string {
+	switch k := normalizeLookupKey(key).(type) {
+	case string:
+		return k
+	case int64:
+		return strconv.FormatInt(k, 10)
+	case float64:
+		return strconv.FormatFloat(k, 'f', -1, 64)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprintf("%v", k)
+	}
+}
+
+// normalizeLookupKey converts the integral numbers of a key to int64 and
+// its bytes to a string, as queried from the sources
+func normalizeLookupKey(key interface{}) interface{} {
+	switch k := key.(type) {
+	case []byte:
+		return string(k)
+	case json.Number:
+		if n, err := k.Int64(); err == nil {
+			return n
+		}
+		if f, err := k.Float64(); err == nil {
+			return normalizeLookupKey(f)
+		}
+		return k.String()
+	case float32:
+		return normalizeLookupKey(float64(k))
+	case float64:
+		if k == math.Trunc(k) && math.Abs(k) < 1<<53 {
+			return int64(k)
+		}
+		return k
+	}
+	if isNumber(key) {
+		n, _ := numberValue(key)
+		return normalizeLookupKey(n)
+	}
+	return key
+}
+
+// cloneJSONValue copies the objects and arrays of a value, so the records of
+// the cache are not modified through the events
+func cloneJSONValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		clone := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			clone[k] = cloneJSONValue(item)
+		}
+		return clone
+	case []interface{}:
+		clone := make([]interface{}, len(v))
+		for i, item := range v {
+			clone[i] = cloneJSONValue(item)
+		}
+		return clone
+	}
+	return value
+}
+
+// lookupCache is a least recently used cache of the records of a store,
+// keeping the keys not found as well, whose entries expire after a TTL
+type lookupCache struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	items map[string]*list.Element
+	order *list.List // most recently used first
+}
+
+type lookupEntry struct {
+	key     string
+	record  map[string]interface{}
+	found   bool
+	expires time.Time
+}
+
+func newLookupCache(size int, ttl time.Duration) *lookupCache {
+	return &lookupCache{size: size, ttl: ttl, items: make(map[string]*list.Element), order: list.New()}
+}
+
+// get returns the cached record of a key, whether the store has it, and
+// whether the key is cached
+func (c *lookupCache) get(key string, now time.Time) (map[string]interface{}, bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.items[key]
+	if !ok {
+		return nil, false, false
+	}
+	entry := element.Value.(*lookupEntry)
+	if now.After(entry.expires) {
+		c.order.Remove(element)
+		delete(c.items, key)
+		return nil, false, false
+	}
+	c.order.MoveToFront(element)
+	return entry.record, entry.found, true
+}
+
+// put caches the record of a key, evicting the least recently used keys
+// beyond the size of the cache
+func (c *lookupCache) put(key string, record map[string]interface{}, found bool, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &lookupEntry{key: key, record: record, found: found, expires: now.Add(c.ttl)}
+	if element, ok := c.items[key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+	c.items[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lookupEntry).key)
+	}
+}
+
+// len returns the number of cached keys
+func (c *lookupCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
@@ -0,0 +1,306 @@
+package transform
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/cohenjo/replicator/pkg/config"
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// lookupQueryKeys bounds the keys of a query, larger lookups are split
+const lookupQueryKeys = 1000
+
+// openReferenceSource opens the source of a reference store
+func openReferenceSource(cfg config.ReferenceStoreConfig) (ReferenceSource, error) {
+	switch cfg.Type {
+	case config.ReferenceStoreMySQL:
+		db, err := sql.Open("mysql", cfg.URI)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open MySQL connection: %w", err)
+		}
+		return &mysqlSource{db: db, table: cfg.Table, key: cfg.KeyField}, nil
+	case config.ReferenceStorePostgreSQL:
+		pool, err := pgxpool.New(context.Background(), cfg.URI)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open PostgreSQL connection: %w", err)
+		}
+		return &postgresSource{pool: pool, table: cfg.Table, key: cfg.KeyField}, nil
+	case config.ReferenceStoreMongoDB:
+		client, err := mongo.Connect(options.Client().ApplyURI(cfg.URI))
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
+		}
+		return &mongoSource{client: client, collection: client.Database(cfg.Database).Collection(cfg.Table), key: cfg.KeyField}, nil
+	case config.ReferenceStoreCSV, config.ReferenceStoreJSON:
+		return loadFileSource(cfg.Type, cfg.Path, cfg.KeyField)
+	default:
+		return nil, fmt.Errorf("unsupported reference store type: %s", cfg.Type)
+	}
+}
+
+// lookupChunks splits keys into the chunks queried at once
+func lookupChunks(keys []interface{}) [][]interface{} {
+	var chunks [][]interface{}
+	for len(keys) > lookupQueryKeys {
+		chunks = append(chunks, keys[:lookupQueryKeys])
+		keys = keys[lookupQueryKeys:]
+	}
+	return append(chunks, keys)
+}
+
+// quoteIdentifier quotes a table or column name, each part of a qualified
+// name on its own
+func quoteIdentifier(name string, quote string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = quote + strings.ReplaceAll(part, quote, quote+quote) + quote
+	}
+	return strings.Join(parts, ".")
+}
+
+// lookupSQL returns the query reading the rows of keys, with a placeholder
+// per key
+func lookupSQL(table, key string, keys int, quote string, placeholder func(int) string) string {
+	placeholders := make([]string, keys)
+	for i := range placeholders {
+		placeholders[i] = placeholder(i + 1)
+	}
+	return fmt.Sprintf("SELECT * FROM %s WHERE %s IN (%s)",
+		quoteIdentifier(table, quote), quoteIdentifier(key, quote), strings.Join(placeholders, ", "))
+}
+
+// mysqlSource reads the rows of a MySQL table
+type mysqlSource struct {
+	db    *sql.DB
+	table string
+	key   string
+}
+
+func (s *mysqlSource) Lookup(ctx context.Context, keys []interface{}) (map[string]map[string]interface{}, error) {
+	records := make(map[string]map[string]interface{}, len(keys))
+	for _, chunk := range lookupChunks(keys) {
+		query := lookupSQL(s.table, s.key, len(chunk), "`", func(int) string { return "?" })
+		rows, err := s.db.QueryContext(ctx, query, chunk...)
+		if err != nil {
+			return nil, err
+		}
+		err = scanSQLRecords(rows, s.key, records)
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+func (s *mysqlSource) Close() error {
+	return s.db.Close()
+}
+
+// scanSQLRecords reads rows into records by the lookup key of their key
+// column, with the text columns as strings
+func scanSQLRecords(rows *sql.Rows, key string, records map[string]map[string]interface{}) error {
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return err
+		}
+		record := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			if b, ok := values[i].([]byte); ok {
+				values[i] = string(b)
+			}
+			record[column] = values[i]
+		}
+		records[lookupKey(record[key])] = record
+	}
+	return rows.Err()
+}
+
+// postgresSource reads the rows of a PostgreSQL table
+type postgresSource struct {
+	pool  *pgxpool.Pool
+	table string
+	key   string
+}
+
+func (s *postgresSource) Lookup(ctx context.Context, keys []interface{}) (map[string]map[string]interface{}, error) {
+	records := make(map[string]map[string]interface{}, len(keys))
+	for _, chunk := range lookupChunks(keys) {
+		query := lookupSQL(s.table, s.key, len(chunk), `"`, func(i int) string { return fmt.Sprintf("$%d", i) })
+		rows, err := s.pool.Query(ctx, query, chunk...)
+		if err != nil {
+			return nil, err
+		}
+		fields := rows.FieldDescriptions()
+		for rows.Next() {
+			values, err := rows.Values()
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			record := make(map[string]interface{}, len(fields))
+			for i, field := range fields {
+				if uuid, ok := values[i].([16]byte); ok {
+					values[i] = fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16])
+				}
+				record[field.Name] = values[i]
+			}
+			records[lookupKey(record[s.key])] = record
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+func (s *postgresSource) Close() error {
+	s.pool.Close()
+	return nil
+}
+
+// mongoSource reads the documents of a MongoDB collection. Keys that are
+// object ids in hex also match the documents whose key is the object id.
+type mongoSource struct {
+	client     *mongo.Client
+	collection *mongo.Collection
+	key        string
+}
+
+func (s *mongoSource) Lookup(ctx context.Context, keys []interface{}) (map[string]map[string]interface{}, error) {
+	records := make(map[string]map[string]interface{}, len(keys))
+	for _, chunk := range lookupChunks(keys) {
+		values := make([]interface{}, 0, len(chunk))
+		for _, key := range chunk {
+			values = append(values, key)
+			if hex, ok := key.(string); ok {
+				if id, err := bson.ObjectIDFromHex(hex); err == nil {
+					values = append(values, id)
+				}
+			}
+		}
+		cursor, err := s.collection.Find(ctx, bson.M{s.key: bson.M{"$in": values}})
+		if err != nil {
+			return nil, err
+		}
+		var documents []bson.M
+		if err := cursor.All(ctx, &documents); err != nil {
+			return nil, err
+		}
+		for _, document := range documents {
+			// Round-trip the documents through JSON so records hold JSON values
+			encoded, err := json.Marshal(document)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode document: %w", err)
+			}
+			var record map[string]interface{}
+			if err := json.Unmarshal(encoded, &record); err != nil {
+				return nil, fmt.Errorf("failed to decode document: %w", err)
+			}
+			records[lookupKey(record[s.key])] = record
+		}
+	}
+	return records, nil
+}
+
+func (s *mongoSource) Close() error {
+	return s.client.Disconnect(context.Background())
+}
+
+// fileSource holds the records of a CSV or JSON file, read when the store
+// is opened. CSV files have a header row and their values are strings, JSON
+// files hold an array of objects.
+type fileSource struct {
+	records map[string]map[string]interface{}
+}
+
+func loadFileSource(format, path, key string) (*fileSource, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open reference file: %w", err)
+	}
+	defer file.Close()
+
+	var rows []map[string]interface{}
+	if format == config.ReferenceStoreCSV {
+		rows, err = readCSVRecords(file)
+	} else {
+		err = json.NewDecoder(file).Decode(&rows)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reference file %s: %w", path, err)
+	}
+
+	source := &fileSource{records: make(map[string]map[string]interface{}, len(rows))}
+	for i, row := range rows {
+		value, ok := row[key]
+		if !ok || value == nil {
+			return nil, fmt.Errorf("record %d of %s has no %s", i+1, path, key)
+		}
+		source.records[lookupKey(value)] = row
+	}
+	return source, nil
+}
+
+func readCSVRecords(reader io.Reader) ([]map[string]interface{}, error) {
+	csvReader := csv.NewReader(reader)
+	header, err := csvReader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rows []map[string]interface{}
+	for {
+		values, err := csvReader.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		row := make(map[string]interface{}, len(header))
+		for i, column := range header {
+			row[column] = values[i]
+		}
+		rows = append(rows, row)
+	}
+}
+
+func (s *fileSource) Lookup(_ context.Context, keys []interface{}) (map[string]map[string]interface{}, error) {
+	records := make(map[string]map[string]interface{}, len(keys))
+	for _, key := range keys {
+		k := lookupKey(key)
+		if record, ok := s.records[k]; ok {
+			records[k] = record
+		}
+	}
+	return records, nil
+}
+
+func (s *fileSource) Close() error {
+	return nil
+}
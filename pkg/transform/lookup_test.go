@@ -0,0 +1,226 @@
+package transform
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cohenjo/replicator/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingSource is a reference source recording the keys it is queried for
+type countingSource struct {
+	records map[string]map[string]interface{}
+	queries [][]interface{}
+}
+
+func (s *countingSource) Lookup(_ context.Context, keys []interface{}) (map[string]map[string]interface{}, error) {
+	s.queries = append(s.queries, keys)
+	found := make(map[string]map[string]interface{})
+	for _, key := range keys {
+		if record, ok := s.records[lookupKey(key)]; ok {
+			found[lookupKey(key)] = record
+		}
+	}
+	return found, nil
+}
+
+func (s *countingSource) Close() error {
+	return nil
+}
+
+func customersSource() *countingSource {
+	return &countingSource{records: map[string]map[string]interface{}{
+		"1": {"id": int64(1), "name": "ann", "tier": "gold"},
+		"2": {"id": int64(2), "name": "bob", "tier": "silver"},
+	}}
+}
+
+func orderEvent(customerID interface{}) map[string]interface{} {
+	data := map[string]interface{}{"id": "o-1", "total": 42.5}
+	if customerID != nil {
+		data["customer_id"] = customerID
+	}
+	return map[string]interface{}{"action": "insert", "collection": "orders", "data": data}
+}
+
+func lookupEngine(t *testing.T, store *ReferenceStore, settings map[string]interface{}) *Engine {
+	t.Helper()
+	transformConfig := DefaultTransformationConfig()
+	transformConfig.Rules = []TransformationRule{{
+		Name:          "enrich",
+		Enabled:       true,
+		Actions:       []Action{{Type: "lookup", Config: settings}},
+		ErrorHandling: ErrorHandlingPolicy{Strategy: ErrorStrategyFailFast},
+	}}
+	engine := NewEngine(transformConfig)
+	engine.SetReferenceStores(map[string]*ReferenceStore{"customers": store})
+	require.NoError(t, engine.ValidateRules(transformConfig.Rules))
+	return engine
+}
+
+func TestLookupAction(t *testing.T) {
+	store := newReferenceStore("customers", customersSource(), 10, time.Minute, time.Second)
+	engine := lookupEngine(t, store, map[string]interface{}{
+		"store":        "customers",
+		"key":          "data.customer_id",
+		"fields":       map[string]interface{}{"data.customer_name": "name"},
+		"target_field": "data.customer",
+	})
+
+	result, err := engine.Transform(context.Background(), orderEvent(float64(1)))
+	require.NoError(t, err)
+	require.True(t, result.Success, "%v", result.Errors)
+	data := result.Output["data"].(map[string]interface{})
+	assert.Equal(t, "ann", data["customer_name"])
+	assert.Equal(t, map[string]interface{}{"id": int64(1), "name": "ann", "tier": "gold"}, data["customer"])
+
+	// Records are copied to the events
+	data["customer"].(map[string]interface{})["name"] = "changed"
+	records, err := store.Lookup(context.Background(), []interface{}{"1"})
+	require.NoError(t, err)
+	assert.Equal(t, "ann", records["1"]["name"])
+}
+
+func TestLookupMissPolicies(t *testing.T) {
+	settings := func(onMiss string) map[string]interface{} {
+		return map[string]interface{}{"store": "customers", "key": "data.customer_id", "fields": map[string]interface{}{"data.customer_name": "name"}, "on_miss": onMiss}
+	}
+	ctx := context.Background()
+
+	for _, event := range []map[string]interface{}{orderEvent(float64(3)), orderEvent(nil)} {
+		store := newReferenceStore("customers", customersSource(), 10, time.Minute, time.Second)
+
+		result, err := lookupEngine(t, store, settings(config.LookupMissNull)).Transform(ctx, event)
+		require.NoError(t, err)
+		data := result.Output["data"].(map[string]interface{})
+		assert.Contains(t, data, "customer_name")
+		assert.Nil(t, data["customer_name"])
+
+		result, err = lookupEngine(t, store, settings(config.LookupMissSkip)).Transform(ctx, event)
+		require.NoError(t, err)
+		assert.True(t, result.Success)
+		assert.NotContains(t, result.Output["data"], "customer_name")
+
+		result, err = lookupEngine(t, store, settings(config.LookupMissFail)).Transform(ctx, event)
+		require.NoError(t, err)
+		assert.False(t, result.Success)
+		require.Len(t, result.Errors, 1)
+		assert.Contains(t, result.Errors[0].Message, ErrLookupMiss.Error())
+	}
+}
+
+func TestLookupBatch(t *testing.T) {
+	source := customersSource()
+	store := newReferenceStore("customers", source, 10, time.Minute, time.Second)
+	engine := lookupEngine(t, store, map[string]interface{}{"store": "customers", "key": "data.customer_id", "fields": map[string]interface{}{"data.customer_name": "name"}})
+
+	inputs := []map[string]interface{}{orderEvent(float64(1)), orderEvent("2"), orderEvent(float64(1)), orderEvent(float64(3)), orderEvent(nil)}
+	results, err := engine.TransformBatch(context.Background(), inputs)
+	require.NoError(t, err)
+	require.Len(t, results, len(inputs))
+
+	names := make([]interface{}, len(results))
+	for i, result := range results {
+		names[i] = result.Output["data"].(map[string]interface{})["customer_name"]
+	}
+	assert.Equal(t, []interface{}{"ann", "bob", "ann", nil, nil}, names)
+	require.Len(t, source.queries, 1, "the keys of the batch are read at once, misses are cached")
+	assert.ElementsMatch(t, []interface{}{int64(1), "2", int64(3)}, source.queries[0])
+
+	hits, misses := store.CacheStats()
+	assert.Equal(t, int64(3), misses)
+	assert.Equal(t, int64(4), hits)
+}
+
+func TestLookupCache(t *testing.T) {
+	cache := newLookupCache(2, time.Minute)
+	now := time.Now()
+	cache.put("a", map[string]interface{}{"v": 1}, true, now)
+	cache.put("b", nil, false, now)
+
+	_, found, cached := cache.get("b", now)
+	assert.True(t, cached)
+	assert.False(t, found, "misses are cached")
+
+	// a is the least recently used key
+	cache.put("c", map[string]interface{}{"v": 3}, true, now)
+	_, _, cached = cache.get("a", now)
+	assert.False(t, cached)
+	assert.Equal(t, 2, cache.len())
+
+	// Entries expire
+	_, _, cached = cache.get("c", now.Add(2*time.Minute))
+	assert.False(t, cached)
+	assert.Equal(t, 1, cache.len())
+}
+
+func TestFileReferenceStores(t *testing.T) {
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "customers.csv")
+	require.NoError(t, os.WriteFile(csvPath, []byte("id,name\n1,ann\n2,bob\n"), 0o600))
+	jsonPath := filepath.Join(dir, "customers.json")
+	require.NoError(t, os.WriteFile(jsonPath, []byte(`[{"id": 1, "name": "ann"}, {"id": 2, "name": "bob"}]`), 0o600))
+
+	for storeType, path := range map[string]string{config.ReferenceStoreCSV: csvPath, config.ReferenceStoreJSON: jsonPath} {
+		store, err := NewReferenceStore(config.ReferenceStoreConfig{Name: "customers", Type: storeType, Path: path, KeyField: "id"})
+		require.NoError(t, err, storeType)
+
+		records, err := store.Lookup(context.Background(), []interface{}{float64(2), "1", 3})
+		require.NoError(t, err)
+		assert.Len(t, records, 2, storeType)
+		assert.Equal(t, "bob", records["2"]["name"], storeType)
+		assert.Equal(t, "ann", records["1"]["name"], storeType)
+		require.NoError(t, store.Close())
+	}
+
+	_, err := NewReferenceStore(config.ReferenceStoreConfig{Name: "customers", Type: config.ReferenceStoreCSV, Path: csvPath, KeyField: "email"})
+	assert.Error(t, err, "records need a key")
+	_, err = NewReferenceStore(config.ReferenceStoreConfig{Name: "customers", Type: config.ReferenceStoreJSON, Path: filepath.Join(dir, "missing.json"), KeyField: "id"})
+	assert.Error(t, err)
+}
+
+func TestLookupSQL(t *testing.T) {
+	assert.Equal(t, "SELECT * FROM `crm`.`customers` WHERE `id` IN (?, ?)",
+		lookupSQL("crm.customers", "id", 2, "`", func(int) string { return "?" }))
+	assert.Equal(t, `SELECT * FROM "customers" WHERE "customer""id" IN ($1, $2, $3)`,
+		lookupSQL("customers", `customer"id`, 3, `"`, func(i int) string { return "$" + string(rune('0'+i)) }))
+
+	keys := make([]interface{}, 2500)
+	chunks := lookupChunks(keys)
+	require.Len(t, chunks, 3)
+	assert.Len(t, chunks[2], 500)
+}
+
+func TestLookupKey(t *testing.T) {
+	assert.Equal(t, "42", lookupKey(float64(42)))
+	assert.Equal(t, "42", lookupKey(int32(42)))
+	assert.Equal(t, "42", lookupKey([]byte("42")))
+	assert.Equal(t, "4.5", lookupKey(4.5))
+	assert.Equal(t, int64(42), normalizeLookupKey(float64(42)))
+	assert.Equal(t, "abc", normalizeLookupKey([]byte("abc")))
+}
+
+func TestLookupActionValidation(t *testing.T) {
+	engine := NewKazaamRuleEngine()
+	engine.SetReferenceStores(map[string]*ReferenceStore{"customers": newReferenceStore("customers", customersSource(), 10, time.Minute, time.Second)})
+
+	valid := Action{Type: "lookup", Config: map[string]interface{}{"store": "customers", "key": "data.customer_id", "target_field": "data.customer"}}
+	assert.NoError(t, engine.ValidateAction(valid))
+
+	invalid := []map[string]interface{}{
+		{"store": "suppliers", "key": "data.customer_id", "target_field": "data.customer"},
+		{"store": "customers", "target_field": "data.customer"},
+		{"store": "customers", "key": "data.customer_id"},
+		{"store": "customers", "key": "data.customer_id", "target_field": "collection"},
+		{"store": "customers", "key": "data.customer_id", "fields": map[string]interface{}{"$": "name"}},
+		{"store": "customers", "key": "data.customer_id", "target_field": "data.customer", "on_miss": "drop"},
+	}
+	for _, settings := range invalid {
+		assert.Error(t, engine.ValidateAction(Action{Type: "lookup", Config: settings}), "%v", settings)
+	}
+}
@@ -0,0 +1,372 @@
+package transform
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// Limits of the Lua scripts, which actions can change with the timeout and
+// registry_max_size config settings
+const (
+	DefaultLuaTimeout         = 100 * time.Millisecond
+	DefaultLuaRegistryMaxSize = 64 * 1024 // value stack slots, not a heap limit
+	luaRegistrySize           = 1024
+	luaCallStackSize          = 200
+
+	// luaPoolSize is the number of idle interpreters kept per script
+	luaPoolSize = 8
+)
+
+// luaLibs are the libraries opened in the sandbox, without io, os, package,
+// coroutine, channel and debug
+var luaLibs = []struct {
+	name string
+	open lua.LGFunction
+}{
+	{lua.BaseLibName, lua.OpenBase},
+	{lua.TabLibName, lua.OpenTable},
+	{lua.StringLibName, lua.OpenString},
+	{lua.MathLibName, lua.OpenMath},
+}
+
+// luaRemoved are the functions of the opened libraries that reach outside of
+// the sandbox or can allocate unbounded memory at once
+var luaRemoved = map[string][]string{
+	"":       {"dofile", "loadfile", "load", "loadstring", "require", "module", "print", "collectgarbage", "getfenv", "setfenv", "newproxy"},
+	"string": {"rep"},
+}
+
+// luaLimits are the limits of a script: its CPU time and the size of its
+// value stack. The tables and strings a script builds are not bounded, other
+// than by the time it is given to build them.
+type luaLimits struct {
+	timeout         time.Duration
+	registryMaxSize int
+}
+
+// luaKey identifies the pool of a script
+type luaKey struct {
+	spec   string
+	limits luaLimits
+}
+
+// luaScript is a compiled Lua script with its pool of interpreters, each
+// running the script once so it defines its transform function
+type luaScript struct {
+	proto  *lua.FunctionProto
+	limits luaLimits
+	states chan *lua.LState
+}
+
+// executeLuaAction calls the transform function of a Lua action with the
+// event and returns the events it produces: the table it returns, each table
+// of the list it returns, or none when it returns nil.
+func (re *KazaamRuleEngine) executeLuaAction(ctx context.Context, data map[string]interface{}, action Action) ([]map[string]interface{}, error) {
+	script, err := re.getLuaScript(action)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Lua script: %w", err)
+	}
+
+	// Round-trip the event through JSON so the script only sees JSON values
+	inputJSON, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal input data: %w", err)
+	}
+	var input interface{}
+	if err := json.Unmarshal(inputJSON, &input); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal input data: %w", err)
+	}
+
+	return script.run(ctx, input)
+}
+
+// getLuaScript gets or compiles the script of a Lua action
+func (re *KazaamRuleEngine) getLuaScript(action Action) (*luaScript, error) {
+	limits, err := luaLimitsFromConfig(action.Config)
+	if err != nil {
+		return nil, err
+	}
+	key := luaKey{spec: action.Spec, limits: limits}
+
+	re.mutex.RLock()
+	script, exists := re.scripts[key]
+	re.mutex.RUnlock()
+
+	if exists {
+		return script, nil
+	}
+
+	proto, err := compileLua(action.Spec)
+	if err != nil {
+		return nil, err
+	}
+	script = &luaScript{proto: proto, limits: limits, states: make(chan *lua.LState, luaPoolSize)}
+
+	// Loading an interpreter checks the script defines its transform function
+	state, err := script.get()
+	if err != nil {
+		return nil, err
+	}
+	script.put(state)
+
+	re.mutex.Lock()
+	if existing, ok := re.scripts[key]; ok {
+		script = existing
+	} else {
+		re.scripts[key] = script
+	}
+	re.mutex.Unlock()
+
+	return script, nil
+}
+
+// run calls the transform function of the script with an input
+func (s *luaScript) run(ctx context.Context, input interface{}) ([]map[string]interface{}, error) {
+	state, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.limits.timeout)
+	defer cancel()
+	state.SetContext(ctx)
+
+	err = state.CallByParam(lua.P{Fn: state.GetGlobal("transform"), NRet: 1, Protect: true}, toLua(state, input))
+	if err != nil {
+		// Interpreters interrupted by an error are not reused
+		state.Close()
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("lua script exceeded its %s time limit", s.limits.timeout)
+		}
+		return nil, fmt.Errorf("lua script failed: %w", err)
+	}
+	result := state.Get(-1)
+	state.Pop(1)
+	state.RemoveContext()
+	s.put(state)
+
+	return luaOutputs(result)
+}
+
+// get takes an idle interpreter of the script from its pool, or loads a new one
+func (s *luaScript) get() (*lua.LState, error) {
+	select {
+	case state := <-s.states:
+		return state, nil
+	default:
+	}
+
+	state := newLuaState(s.limits)
+	ctx, cancel := context.WithTimeout(context.Background(), s.limits.timeout)
+	defer cancel()
+	state.SetContext(ctx)
+	state.Push(state.NewFunctionFromProto(s.proto))
+	if err := state.PCall(0, 0, nil); err != nil {
+		state.Close()
+		return nil, fmt.Errorf("failed to run Lua script: %w", err)
+	}
+	state.RemoveContext()
+	if state.GetGlobal("transform").Type() != lua.LTFunction {
+		state.Close()
+		return nil, errors.New("lua script does not define a transform function")
+	}
+	return state, nil
+}
+
+// put returns an interpreter to the pool of the script, closing it when the
+// pool is full
+func (s *luaScript) put(state *lua.LState) {
+	select {
+	case s.states <- state:
+	default:
+		state.Close()
+	}
+}
+
+// newLuaState creates a sandboxed interpreter: only the base, table, string
+// and math libraries are opened, without the functions that load code or
+// reach the process, and the value and call stacks are bounded
+func newLuaState(limits luaLimits) *lua.LState {
+	state := lua.NewState(lua.Options{
+		SkipOpenLibs:    true,
+		CallStackSize:   luaCallStackSize,
+		RegistrySize:    min(luaRegistrySize, limits.registryMaxSize),
+		RegistryMaxSize: limits.registryMaxSize,
+	})
+	for _, lib := range luaLibs {
+		state.Push(state.NewFunction(lib.open))
+		state.Push(lua.LString(lib.name))
+		state.Call(1, 0)
+	}
+	for lib, names := range luaRemoved {
+		table := state.Get(lua.GlobalsIndex).(*lua.LTable)
+		if lib != "" {
+			table = state.GetGlobal(lib).(*lua.LTable)
+		}
+		for _, name := range names {
+			table.RawSetString(name, lua.LNil)
+		}
+	}
+	return state
+}
+
+// validateLuaAction compiles the script of a Lua action and checks its limits
+func validateLuaAction(action Action) error {
+	if _, err := luaLimitsFromConfig(action.Config); err != nil {
+		return err
+	}
+	if _, err := compileLua(action.Spec); err != nil {
+		return fmt.Errorf("invalid Lua script: %w", err)
+	}
+	return nil
+}
+
+func compileLua(spec string) (*lua.FunctionProto, error) {
+	chunk, err := parse.Parse(strings.NewReader(spec), "transform")
+	if err != nil {
+		return nil, err
+	}
+	return lua.Compile(chunk, "transform")
+}
+
+// luaLimitsFromConfig returns the limits of a Lua action, with the defaults
+// for the settings it leaves out
+func luaLimitsFromConfig(actionConfig map[string]interface{}) (luaLimits, error) {
+	limits := luaLimits{timeout: DefaultLuaTimeout, registryMaxSize: DefaultLuaRegistryMaxSize}
+	if timeout, ok := actionConfig["timeout"]; ok {
+		value, ok := timeout.(string)
+		if !ok {
+			return limits, fmt.Errorf("invalid Lua timeout: %v", timeout)
+		}
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			return limits, fmt.Errorf("invalid Lua timeout: %s", value)
+		}
+		limits.timeout = parsed
+	}
+	if size, ok := actionConfig["registry_max_size"]; ok {
+		var value int
+		switch size := size.(type) {
+		case int:
+			value = size
+		case float64:
+			value = int(size)
+		}
+		if value <= 0 {
+			return limits, fmt.Errorf("invalid Lua registry_max_size: %v", size)
+		}
+		limits.registryMaxSize = value
+	}
+	return limits, nil
+}
+
+// luaOutputs converts the value returned by a transform function to events
+func luaOutputs(result lua.LValue) ([]map[string]interface{}, error) {
+	if result == lua.LNil {
+		return []map[string]interface{}{}, nil
+	}
+	table, ok := result.(*lua.LTable)
+	if !ok {
+		return nil, fmt.Errorf("lua transform returned %s, expected a table or nil", result.Type())
+	}
+
+	value, err := fromLua(table)
+	if err != nil {
+		return nil, err
+	}
+	switch value := value.(type) {
+	case map[string]interface{}:
+		return []map[string]interface{}{value}, nil
+	case []interface{}:
+		outputs := make([]map[string]interface{}, 0, len(value))
+		for i, item := range value {
+			output, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("lua transform returned a list with %T at %d, expected tables", item, i+1)
+			}
+			outputs = append(outputs, output)
+		}
+		return outputs, nil
+	}
+	return nil, fmt.Errorf("lua transform returned %T, expected a table", value)
+}
+
+// toLua converts a JSON value to a Lua value
+func toLua(state *lua.LState, value interface{}) lua.LValue {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		table := state.CreateTable(0, len(value))
+		for k, v := range value {
+			table.RawSetString(k, toLua(state, v))
+		}
+		return table
+	case []interface{}:
+		table := state.CreateTable(len(value), 0)
+		for i, v := range value {
+			table.RawSetInt(i+1, toLua(state, v))
+		}
+		return table
+	case string:
+		return lua.LString(value)
+	case float64:
+		return lua.LNumber(value)
+	case bool:
+		return lua.LBool(value)
+	default:
+		return lua.LNil
+	}
+}
+
+// fromLua converts a Lua value to a JSON value. Tables with the keys 1 to n
+// are lists, other tables are objects.
+func fromLua(value lua.LValue) (interface{}, error) {
+	switch value := value.(type) {
+	case *lua.LNilType:
+		return nil, nil
+	case lua.LBool:
+		return bool(value), nil
+	case lua.LNumber:
+		return float64(value), nil
+	case lua.LString:
+		return string(value), nil
+	case *lua.LTable:
+		if n := value.MaxN(); n > 0 && value.Len() == n && luaTableSize(value) == n {
+			list := make([]interface{}, 0, n)
+			for i := 1; i <= n; i++ {
+				item, err := fromLua(value.RawGetInt(i))
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, item)
+			}
+			return list, nil
+		}
+		object := make(map[string]interface{})
+		var err error
+		value.ForEach(func(k, v lua.LValue) {
+			if err != nil {
+				return
+			}
+			object[k.String()], err = fromLua(v)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return object, nil
+	default:
+		return nil, fmt.Errorf("unsupported Lua value of type %s", value.Type())
+	}
+}
+
+func luaTableSize(table *lua.LTable) int {
+	size := 0
+	table.ForEach(func(lua.LValue, lua.LValue) { size++ })
+	return size
+}
@@ -0,0 +1,118 @@
+package transform
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cohenjo/replicator/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLuaAction(t *testing.T) {
+	ctx := context.Background()
+	re := NewKazaamRuleEngine()
+	event := map[string]interface{}{"action": "insert", "items": []interface{}{"a", "b"}, "total": 3}
+
+	reshape := Action{Type: "lua", Spec: `
+function transform(event)
+  local names = {}
+  for i, item in ipairs(event.items) do
+    names[i] = string.upper(item)
+  end
+  event.items = names
+  if event.total > 2 then
+    event.large = true
+  end
+  return event
+end`}
+	output, err := re.ExecuteAction(ctx, event, reshape)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"action": "insert", "items": []interface{}{"A", "B"}, "total": float64(3), "large": true}, output)
+
+	// Returning nil drops the event, returning a list splits it
+	outputs, err := re.ExecuteActionOutputs(ctx, event, Action{Type: "lua", Spec: `function transform(event) return nil end`})
+	require.NoError(t, err)
+	assert.Empty(t, outputs)
+
+	split := Action{Type: "lua", Spec: `
+function transform(event)
+  local events = {}
+  for i, item in ipairs(event.items) do
+    events[i] = {action = event.action, item = item}
+  end
+  return events
+end`}
+	outputs, err = re.ExecuteActionOutputs(ctx, event, split)
+	require.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{{"action": "insert", "item": "a"}, {"action": "insert", "item": "b"}}, outputs)
+
+	_, err = re.ExecuteAction(ctx, event, split)
+	assert.Error(t, err, "actions that expect one event reject splits")
+
+	_, err = re.ExecuteAction(ctx, event, Action{Type: "lua", Spec: `function transform(event) error("bad event") end`})
+	assert.ErrorContains(t, err, "bad event")
+}
+
+func TestLuaActionPool(t *testing.T) {
+	re := NewKazaamRuleEngine()
+	action := Action{Type: "lua", Spec: `function transform(event) return event end`}
+	for i := 0; i < 3; i++ {
+		_, err := re.ExecuteAction(context.Background(), map[string]interface{}{"id": i}, action)
+		require.NoError(t, err)
+	}
+	require.Len(t, re.scripts, 1, "scripts are compiled once")
+	for _, script := range re.scripts {
+		assert.Len(t, script.states, 1, "interpreters are reused")
+	}
+}
+
+func TestLuaActionSandbox(t *testing.T) {
+	ctx := context.Background()
+	re := NewKazaamRuleEngine()
+	event := map[string]interface{}{"id": 1}
+
+	for _, script := range []string{
+		`function transform(event) return io.open("/etc/passwd") end`,
+		`function transform(event) return os.execute("true") end`,
+		`function transform(event) return require("socket") end`,
+		`function transform(event) return dofile("/etc/passwd") end`,
+		`function transform(event) return loadstring("return 1")() end`,
+	} {
+		_, err := re.ExecuteAction(ctx, event, Action{Type: "lua", Spec: script})
+		assert.Error(t, err, script)
+	}
+
+	// CPU time is limited
+	_, err := re.ExecuteAction(ctx, event, Action{
+		Type:   "lua",
+		Spec:   `function transform(event) while true do end end`,
+		Config: map[string]interface{}{"timeout": "20ms"},
+	})
+	assert.ErrorContains(t, err, "time limit")
+
+	// So is the stack the script can grow
+	_, err = re.ExecuteAction(ctx, event, Action{
+		Type:   "lua",
+		Spec:   `function transform(event) return {unpack({}, 1, 100000)} end`,
+		Config: map[string]interface{}{"registry_max_size": 4096},
+	})
+	assert.ErrorContains(t, err, "registry overflow")
+
+	_, err = re.ExecuteAction(ctx, event, Action{
+		Type: "lua",
+		Spec: `function transform(event) return {string.rep("x", 1000000000)} end`,
+	})
+	assert.Error(t, err)
+}
+
+func TestValidateLuaAction(t *testing.T) {
+	re := NewKazaamRuleEngine()
+	assert.NoError(t, re.ValidateAction(Action{Type: "lua", Spec: `function transform(event) return event end`}))
+	assert.Error(t, re.ValidateAction(Action{Type: "lua", Spec: `function transform(event`}), "syntax errors")
+	assert.Error(t, re.ValidateAction(Action{Type: "lua", Spec: `local x = 1`}), "no transform function")
+	assert.Error(t, re.ValidateAction(Action{Type: "lua", Spec: `function transform(event) return event end`, Config: map[string]interface{}{"timeout": "soon"}}))
+
+	assert.NoError(t, config.ValidateAction(&config.Action{Type: "lua", Spec: `function transform(event) return event end`}))
+	assert.Error(t, config.ValidateAction(&config.Action{Type: "lua", Spec: `function transform(`}), "scripts are validated when the config is loaded")
+}
@@ -2,7 +2,12 @@ package transform
 
 import (
 	"context"
+	"fmt"
+	"regexp"
+	"strings"
 	"time"
+
+	"github.com/cohenjo/replicator/pkg/config"
 )
 
 // TransformationRule represents a single transformation rule
@@ -17,19 +22,25 @@ type TransformationRule struct {
 	Metadata     map[string]interface{} `json:"metadata,omitempty" yaml:"metadata,omitempty"`
 }
 
-// Condition represents a condition for applying a transformation
+// Condition represents a condition for applying a transformation. A
+// condition with All, Any or Not is a group combining other conditions
+// instead of testing a field.
 type Condition struct {
 	Field    string      `json:"field" yaml:"field"`         // JSONPath or field name
-	Operator string      `json:"operator" yaml:"operator"`   // eq, ne, gt, lt, contains, exists, etc.
+	Operator string      `json:"operator" yaml:"operator"`   // eq, ne, gt, gte, lt, lte, in, nin, contains, regex, startswith, endswith, between, exists, is_null
 	Value    interface{} `json:"value" yaml:"value"`         // Value to compare against
-	Type     string      `json:"type,omitempty" yaml:"type,omitempty"` // string, number, boolean, null
+	Type     string      `json:"type,omitempty" yaml:"type,omitempty"` // string, number, boolean, timestamp; inferred from the values when empty
+	All      []Condition `json:"all,omitempty" yaml:"all,omitempty"`   // Met when all of the conditions are
+	Any      []Condition `json:"any,omitempty" yaml:"any,omitempty"`   // Met when any of the conditions is
+	Not      *Condition  `json:"not,omitempty" yaml:"not,omitempty"`   // Met when the condition is not
 }
 
 // Action represents a transformation action
 type Action struct {
-	Type     string                 `json:"type" yaml:"type"`         // "kazaam", "jq", "lua", "javascript"
+	Type     string                 `json:"type" yaml:"type"`         // "kazaam", "jq", "lua", "javascript", or a field action
 	Spec     string                 `json:"spec" yaml:"spec"`         // Transformation specification
-	Target   string                 `json:"target,omitempty" yaml:"target,omitempty"` // Target field for output
+	Input    string                 `json:"input,omitempty" yaml:"input,omitempty"`   // Field the action runs on, defaults to Target
+	Target   string                 `json:"target,omitempty" yaml:"target,omitempty"` // Field the output is written to, defaults to Input; the whole event when both are empty
 	Config   map[string]interface{} `json:"config,omitempty" yaml:"config,omitempty"` // Action-specific config
 }
 
@@ -56,6 +67,7 @@ const (
 
 // TransformationConfig represents the configuration for transformation engine
 type TransformationConfig struct {
+	Stream        string                 `json:"stream,omitempty" yaml:"stream,omitempty"` // Stream the rules transform, labels the metrics
 	Engine        string                 `json:"engine" yaml:"engine"`                 // "kazaam", "jq", "lua", "javascript"
 	Rules         []TransformationRule   `json:"rules" yaml:"rules"`
 	GlobalConfig  map[string]interface{} `json:"global_config,omitempty" yaml:"global_config,omitempty"`
@@ -90,6 +102,20 @@ type TransformationResult struct {
 	ExecutionTime time.Duration          `json:"execution_time"`
 	Timestamp     time.Time              `json:"timestamp"`
 	Metadata      map[string]interface{} `json:"metadata,omitempty"`
+	DeadLetter    bool                   `json:"dead_letter,omitempty"` // A rule with the dead_letter strategy failed, the event should not be written
+	Outputs       []map[string]interface{} `json:"outputs,omitempty"`   // Every event the input was transformed to when an action fanned it out, Output is the first of them
+}
+
+// Documents returns the documents the input was transformed to: Outputs when
+// an action fanned the input out, otherwise Output
+func (r *TransformationResult) Documents() []map[string]interface{} {
+	if r.Outputs != nil {
+		return r.Outputs
+	}
+	if r.Output == nil {
+		return nil
+	}
+	return []map[string]interface{}{r.Output}
 }
 
 // TransformationError represents an error that occurred during transformation
@@ -123,6 +149,7 @@ type TransformationMetrics struct {
 // RuleMetrics represents metrics for a specific transformation rule
 type RuleMetrics struct {
 	Name              string        `json:"name"`
+	Stream            string        `json:"stream,omitempty"`
 	Executions        int64         `json:"executions"`
 	Successes         int64         `json:"successes"`
 	Failures          int64         `json:"failures"`
@@ -183,6 +210,24 @@ type RuleEngine interface {
 	ValidateAction(action Action) error
 }
 
+// FanOutRuleEngine is a rule engine whose actions can turn an event into
+// several events, or drop it
+type FanOutRuleEngine interface {
+	RuleEngine
+
+	// ExecuteActionOutputs executes a transformation action and returns each of its outputs
+	ExecuteActionOutputs(ctx context.Context, data map[string]interface{}, action Action) ([]map[string]interface{}, error)
+}
+
+// BatchRuleEngine is a rule engine that prepares its actions for a batch of
+// events before they are transformed one by one
+type BatchRuleEngine interface {
+	RuleEngine
+
+	// PrepareBatch prepares an action for a batch of events
+	PrepareBatch(ctx context.Context, documents []map[string]interface{}, action Action) error
+}
+
 // DefaultTransformationConfig returns a default transformation configuration
 func DefaultTransformationConfig() TransformationConfig {
 	return TransformationConfig{
@@ -245,28 +290,87 @@ func (r *TransformationRule) Validate() error {
 
 // Validate validates a condition
 func (c *Condition) Validate() error {
+	if c.IsGroup() {
+		if c.Field != "" || c.Operator != "" {
+			return fmt.Errorf("%w: condition groups have no field or operator", ErrInvalidConditionOperator)
+		}
+		if conditionGroups(c) > 1 {
+			return fmt.Errorf("%w: a condition group is one of all, any or not", ErrInvalidConditionOperator)
+		}
+		for _, condition := range append(append([]Condition{}, c.All...), c.Any...) {
+			if err := condition.Validate(); err != nil {
+				return err
+			}
+		}
+		if c.Not != nil {
+			return c.Not.Validate()
+		}
+		return nil
+	}
+
 	if c.Field == "" {
 		return ErrInvalidConditionField
 	}
+	if _, err := parseFieldPath(c.Field); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidConditionField, err)
+	}
 	
 	if c.Operator == "" {
 		return ErrInvalidConditionOperator
 	}
+	if !validOperator(c.Operator) {
+		return fmt.Errorf("%w: %s", ErrInvalidConditionOperator, c.Operator)
+	}
+	if !validConditionType(c.Type) {
+		return fmt.Errorf("%w: unsupported type %s", ErrInvalidConditionOperator, c.Type)
+	}
+
+	switch c.Operator {
+	case OperatorIn, OperatorNin, OperatorNotIn:
+		if _, ok := conditionList(c.Value); !ok {
+			return fmt.Errorf("%w: %s needs a list of values", ErrInvalidConditionOperator, c.Operator)
+		}
+	case OperatorBetween:
+		if bounds, ok := conditionList(c.Value); !ok || len(bounds) != 2 {
+			return fmt.Errorf("%w: %s needs a list of two values", ErrInvalidConditionOperator, c.Operator)
+		}
+	case OperatorRegex:
+		if _, err := regexp.Compile(stringValue(c.Value)); err != nil {
+			return fmt.Errorf("%w: invalid regex: %v", ErrInvalidConditionOperator, err)
+		}
+	}
 	
 	return nil
 }
 
+// IsGroup reports whether the condition combines other conditions
+func (c *Condition) IsGroup() bool {
+	return conditionGroups(c) > 0
+}
+
+func conditionGroups(c *Condition) int {
+	groups := 0
+	for _, set := range []bool{len(c.All) > 0, len(c.Any) > 0, c.Not != nil} {
+		if set {
+			groups++
+		}
+	}
+	return groups
+}
+
 // Validate validates an action
 func (a *Action) Validate() error {
 	if a.Type == "" {
 		return ErrInvalidActionType
 	}
 	
-	if a.Spec == "" {
+	// Field and lookup actions are configured by their settings
+	actionType := strings.ToLower(a.Type)
+	if a.Spec == "" && !config.IsFieldAction(actionType) && actionType != config.ActionLookup {
 		return ErrInvalidActionSpec
 	}
 	
-	return nil
+	return validateActionPaths(*a)
 }
 
 // Validate validates an error handling policy
@@ -0,0 +1,312 @@
+package transform
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/cohenjo/replicator/pkg/config"
+)
+
+// SecretProvider reads the keys of the hash and tokenize actions by name
+type SecretProvider interface {
+	GetSecret(ctx context.Context, name string) ([]byte, error)
+}
+
+// SetSecretProvider sets the provider of the keys of the actions
+func (re *KazaamRuleEngine) SetSecretProvider(secrets SecretProvider) {
+	re.mutex.Lock()
+	defer re.mutex.Unlock()
+	re.secrets = secrets
+	re.keys = make(map[string][]byte)
+}
+
+// executeFieldAction runs a field action on each of its fields the event
+// has, leaving the missing and null ones as they are, except for drop which
+// removes them
+func (re *KazaamRuleEngine) executeFieldAction(ctx context.Context, data map[string]interface{}, action Action) (map[string]interface{}, error) {
+	actionType := strings.ToLower(action.Type)
+	settings, err := config.ParseFieldActionSettings(actionType, action.Config)
+	if err != nil {
+		return nil, err
+	}
+	var key []byte
+	if actionType == config.ActionHash || actionType == config.ActionTokenize {
+		if key, err = re.getKey(ctx, settings.KeySecret); err != nil {
+			return nil, err
+		}
+	}
+
+	output := data
+	for _, field := range settings.Fields {
+		value, err := getFieldValue(output, field)
+		if errors.Is(err, ErrFieldNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if actionType == config.ActionDrop {
+			if output, err = deleteFieldValue(output, field); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if value == nil {
+			continue
+		}
+		transformed, err := fieldActionValue(actionType, settings, key, value)
+		if err != nil {
+			return nil, fmt.Errorf("%s action failed on '%s': %w", actionType, field, err)
+		}
+		if output, err = setFieldValue(output, field, transformed); err != nil {
+			return nil, err
+		}
+	}
+	return output, nil
+}
+
+// getKey gets or reads the key of a secret
+func (re *KazaamRuleEngine) getKey(ctx context.Context, name string) ([]byte, error) {
+	re.mutex.RLock()
+	secrets := re.secrets
+	key, exists := re.keys[name]
+	re.mutex.RUnlock()
+
+	if exists {
+		return key, nil
+	}
+	if secrets == nil {
+		return nil, fmt.Errorf("no secret provider to read key %s", name)
+	}
+
+	key, err := secrets.GetSecret(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key %s: %w", name, err)
+	}
+
+	re.mutex.Lock()
+	re.keys[name] = key
+	re.mutex.Unlock()
+
+	return key, nil
+}
+
+// validateFieldAction checks the settings and fields of a field action, and
+// reads its key so a missing key fails the rules rather than the events
+func (re *KazaamRuleEngine) validateFieldAction(action Action) error {
+	settings, err := config.ParseFieldActionSettings(strings.ToLower(action.Type), action.Config)
+	if err != nil {
+		return err
+	}
+	for _, field := range settings.Fields {
+		segments, err := parseFieldPath(field)
+		if err != nil {
+			return fmt.Errorf("invalid field: %w", err)
+		}
+		if len(segments) == 0 {
+			return fmt.Errorf("invalid field %q: field actions transform the fields of the event", field)
+		}
+		if protected := protectedField(segments); protected != "" {
+			return fmt.Errorf("invalid field %q: %s is a protected field", field, protected)
+		}
+	}
+	if settings.KeySecret != "" {
+		if _, err := re.getKey(context.Background(), settings.KeySecret); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fieldActionValue returns the value of a field transformed by an action
+func fieldActionValue(actionType string, settings config.FieldActionSettings, key []byte, value interface{}) (interface{}, error) {
+	switch actionType {
+	case config.ActionMask:
+		return maskValue(fieldText(value), settings.KeepLast, settings.MaskChar), nil
+	case config.ActionHash:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(fieldText(value)))
+		return hex.EncodeToString(mac.Sum(nil)), nil
+	case config.ActionTokenize:
+		return tokenizeValue(fieldText(value), key), nil
+	case config.ActionRedact:
+		return settings.Replacement, nil
+	case config.ActionTruncate:
+		text, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("cannot truncate %T, expected a string", value)
+		}
+		if runes := []rune(text); len(runes) > settings.Length {
+			return string(runes[:settings.Length]), nil
+		}
+		return text, nil
+	case config.ActionGeneralize:
+		return generalizeValue(value, settings)
+	}
+	return nil, fmt.Errorf("unsupported field action: %s", actionType)
+}
+
+// maskValue replaces all but the last keepLast characters of a text
+func maskValue(text string, keepLast int, maskChar string) string {
+	runes := []rune(text)
+	keep := min(keepLast, len(runes))
+	return strings.Repeat(maskChar, len(runes)-keep) + string(runes[len(runes)-keep:])
+}
+
+// tokenizeValue replaces each digit and letter of a text with one of the
+// same kind and case drawn from the HMAC of the text, keeping the other
+// characters. Letters and digits outside of ASCII are replaced within their
+// script and block of the Unicode table, see tokenRunes. Tokens are
+// deterministic, so they still join, and cannot be reversed; distinct values
+// may share a token.
+func tokenizeValue(text string, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(text))
+	seed := mac.Sum(nil)
+
+	var stream []byte
+	next := func() int {
+		if len(stream) == 0 {
+			block := hmac.New(sha256.New, key)
+			block.Write(seed)
+			seed = block.Sum(nil)
+			stream = seed
+		}
+		b := stream[0]
+		stream = stream[1:]
+		return int(b)
+	}
+
+	var token strings.Builder
+	for _, r := range text {
+		switch {
+		case r >= '0' && r <= '9':
+			token.WriteRune('0' + rune(next()%10))
+		case r >= 'a' && r <= 'z':
+			token.WriteRune('a' + rune(next()%26))
+		case r >= 'A' && r <= 'Z':
+			token.WriteRune('A' + rune(next()%26))
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			runes := tokenRunes(r)
+			token.WriteRune(runes[(next()<<8|next())%len(runes)])
+		default:
+			token.WriteRune(r)
+		}
+	}
+	return token.String()
+}
+
+// tokenClass is the class of the runes a letter or digit is tokenized to
+type tokenClass struct {
+	block  rune // first rune of the 256 runes block of the rune
+	script string
+	kind   int
+}
+
+const (
+	tokenDigit = iota
+	tokenUpper
+	tokenLower
+	tokenLetter // letters without case
+)
+
+// tokenClasses caches the runes of the token classes
+var tokenClasses sync.Map
+
+// tokenRunes returns the runes a letter or digit outside of ASCII may be
+// tokenized to: the runes of its 256 runes block of the same script and
+// kind, digit, upper or lower case letter or letter without case, which
+// include the rune itself
+func tokenRunes(r rune) []rune {
+	class := tokenClass{block: r &^ 0xFF, script: runeScript(r), kind: tokenKind(r)}
+	if runes, ok := tokenClasses.Load(class); ok {
+		return runes.([]rune)
+	}
+	var runes []rune
+	for c := class.block; c <= class.block|0xFF; c++ {
+		if (unicode.IsLetter(c) || unicode.IsDigit(c)) && tokenKind(c) == class.kind && runeScript(c) == class.script {
+			runes = append(runes, c)
+		}
+	}
+	tokenClasses.Store(class, runes)
+	return runes
+}
+
+func tokenKind(r rune) int {
+	switch {
+	case unicode.IsDigit(r):
+		return tokenDigit
+	case unicode.IsUpper(r):
+		return tokenUpper
+	case unicode.IsLower(r):
+		return tokenLower
+	}
+	return tokenLetter
+}
+
+// runeScript returns the name of the script of a rune, "" when it has none
+func runeScript(r rune) string {
+	for name, table := range unicode.Scripts {
+		if unicode.Is(table, r) {
+			return name
+		}
+	}
+	return ""
+}
+
+// generalizeValue rounds a number down to its bucket, or a date to its
+// year, month or day
+func generalizeValue(value interface{}, settings config.FieldActionSettings) (interface{}, error) {
+	if isNumber(value) {
+		if settings.BucketSize <= 0 {
+			return nil, fmt.Errorf("cannot generalize a number without bucket_size")
+		}
+		number, err := numberValue(value)
+		if err != nil {
+			return nil, err
+		}
+		return math.Floor(number/settings.BucketSize) * settings.BucketSize, nil
+	}
+
+	t, err := timestampValue(value)
+	if err != nil {
+		return nil, fmt.Errorf("cannot generalize %v, expected a date or a number", value)
+	}
+	t = t.UTC()
+	switch settings.Granularity {
+	case "year":
+		return t.Format("2006"), nil
+	case "day":
+		return t.Format("2006-01-02"), nil
+	default:
+		return t.Format("2006-01"), nil
+	}
+}
+
+// fieldText returns the text a field action masks, hashes or tokenizes:
+// numbers in full, as written in the event, and objects and arrays as JSON
+func fieldText(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case map[string]interface{}, []interface{}:
+		if encoded, err := json.Marshal(v); err == nil {
+			return string(encoded)
+		}
+	}
+	return stringValue(value)
+}
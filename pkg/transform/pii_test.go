@@ -0,0 +1,179 @@
+package transform
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type staticSecrets map[string]string
+
+func (s staticSecrets) GetSecret(_ context.Context, name string) ([]byte, error) {
+	value, ok := s[name]
+	if !ok {
+		return nil, fmt.Errorf("secret %s not found", name)
+	}
+	return []byte(value), nil
+}
+
+func piiEvent() map[string]interface{} {
+	return map[string]interface{}{
+		"action":      "insert",
+		"collection":  "customers",
+		"documentKey": []byte(`{"id": 7}`),
+		"data": []byte(`{"id": 7, "email": "ann@example.com", "card": "4111-1111-1111-1234", "phone": 33612345678,
+			"birth_date": "1990-07-14T08:30:00Z", "income": 52340, "notes": "likes cats", "address": {"street": "1 rue de Rivoli", "city": "Paris"},
+			"tags": ["vip", "newsletter"], "nickname": null}`),
+	}
+}
+
+func transformFields(t *testing.T, actions ...Action) map[string]interface{} {
+	t.Helper()
+	transformConfig := DefaultTransformationConfig()
+	transformConfig.Rules = []TransformationRule{{Name: "pii", Enabled: true, Actions: actions, ErrorHandling: DefaultErrorHandling()}}
+	engine := NewEngine(transformConfig)
+	engine.SetSecretProvider(staticSecrets{"pii-key": "s3cr3t"})
+	require.NoError(t, engine.ValidateRules(transformConfig.Rules))
+	result, err := engine.Transform(context.Background(), piiEvent())
+	require.NoError(t, err)
+	require.True(t, result.Success, "%v", result.Errors)
+	return result.Output["data"].(map[string]interface{})
+}
+
+func fieldAction(actionType string, settings map[string]interface{}) Action {
+	return Action{Type: actionType, Config: settings}
+}
+
+func TestFieldActions(t *testing.T) {
+	mac := hmac.New(sha256.New, []byte("s3cr3t"))
+	mac.Write([]byte("ann@example.com"))
+	emailHash := hex.EncodeToString(mac.Sum(nil))
+
+	data := transformFields(t,
+		fieldAction("mask", map[string]interface{}{"fields": []interface{}{"data.card", "data.phone"}}),
+		fieldAction("hash", map[string]interface{}{"fields": []interface{}{"data.email"}, "key_secret": "pii-key"}),
+		fieldAction("redact", map[string]interface{}{"fields": []interface{}{"data.address.street", "data.nickname", "data.missing"}}),
+		fieldAction("drop", map[string]interface{}{"fields": []interface{}{"data.notes", "data.tags[0]"}}),
+		fieldAction("truncate", map[string]interface{}{"fields": []interface{}{"data.address.city"}, "length": 3}),
+		fieldAction("generalize", map[string]interface{}{"fields": []interface{}{"data.birth_date"}}),
+		fieldAction("generalize", map[string]interface{}{"fields": []interface{}{"data.income"}, "bucket_size": 10000}),
+	)
+
+	assert.Equal(t, "***************1234", data["card"])
+	assert.Equal(t, "*******5678", data["phone"], "numbers are masked in full")
+	assert.Equal(t, emailHash, data["email"])
+	assert.Equal(t, map[string]interface{}{"street": "[REDACTED]", "city": "Par"}, data["address"])
+	assert.Nil(t, data["nickname"], "null values are left as they are")
+	assert.NotContains(t, data, "missing")
+	assert.NotContains(t, data, "notes")
+	assert.Equal(t, []interface{}{"newsletter"}, data["tags"])
+	assert.Equal(t, "1990-07", data["birth_date"])
+	assert.Equal(t, float64(50000), data["income"])
+	assert.Equal(t, json.Number("7"), data["id"], "numbers are kept as written")
+}
+
+func TestFieldActionsKeepLargeNumbers(t *testing.T) {
+	transformConfig := DefaultTransformationConfig()
+	transformConfig.Rules = []TransformationRule{{Name: "pii", Enabled: true, ErrorHandling: DefaultErrorHandling(), Actions: []Action{
+		fieldAction("hash", map[string]interface{}{"fields": []interface{}{"data.account"}, "key_secret": "pii-key"}),
+	}}}
+	engine := NewEngine(transformConfig)
+	engine.SetSecretProvider(staticSecrets{"pii-key": "s3cr3t"})
+	require.NoError(t, engine.ValidateRules(transformConfig.Rules))
+
+	hash := func(account string) interface{} {
+		result, err := engine.Transform(context.Background(), map[string]interface{}{
+			"action": "insert",
+			"data":   []byte(`{"account": ` + account + `, "balance": 12345678901234567891}`),
+		})
+		require.NoError(t, err)
+		data := result.Output["data"].(map[string]interface{})
+		assert.Equal(t, json.Number("12345678901234567891"), data["balance"], "numbers the action does not change stay exact")
+		return data["account"]
+	}
+	// Both numbers round to the same float64
+	assert.NotEqual(t, hash("9007199254740993"), hash("9007199254740992"), "numbers are hashed in full")
+}
+
+func TestFieldActionSettings(t *testing.T) {
+	data := transformFields(t,
+		fieldAction("mask", map[string]interface{}{"fields": []interface{}{"data.email"}, "keep_last": 0, "mask_char": "#"}),
+		fieldAction("redact", map[string]interface{}{"fields": []interface{}{"data.notes"}, "replacement": ""}),
+		fieldAction("generalize", map[string]interface{}{"fields": []interface{}{"data.birth_date"}, "granularity": "year"}),
+	)
+	assert.Equal(t, "###############", data["email"])
+	assert.Equal(t, "", data["notes"])
+	assert.Equal(t, "1990", data["birth_date"])
+}
+
+func TestTokenize(t *testing.T) {
+	tokenize := fieldAction("tokenize", map[string]interface{}{"fields": []interface{}{"data.card", "data.email"}, "key_secret": "pii-key"})
+	first := transformFields(t, tokenize)
+	second := transformFields(t, tokenize)
+
+	card := first["card"].(string)
+	assert.Regexp(t, `^\d{4}-\d{4}-\d{4}-\d{4}$`, card, "the format is kept")
+	assert.NotEqual(t, "4111-1111-1111-1234", card)
+	assert.Regexp(t, `^[a-z]{3}@[a-z]{7}\.[a-z]{3}$`, first["email"])
+	assert.Equal(t, first, second, "tokens are deterministic")
+
+	assert.NotEqual(t, tokenizeValue("4111-1111-1111-1234", []byte("other")), card, "tokens depend on the key")
+
+	// Letters and digits outside of ASCII keep their script and case
+	for _, text := range []string{"Élodie Müller", "Дмитрий", "山田太郎", "٠١٢٣٤٥"} {
+		token := []rune(tokenizeValue(text, []byte("s3cr3t")))
+		runes := []rune(text)
+		require.Len(t, token, len(runes), text)
+		for i, r := range runes {
+			assert.Equal(t, runeScript(r), runeScript(token[i]), "%s: %c and %c", text, r, token[i])
+			assert.Equal(t, tokenKind(r), tokenKind(token[i]), "%s: %c and %c", text, r, token[i])
+		}
+	}
+}
+
+func TestFieldActionValidation(t *testing.T) {
+	engine := NewKazaamRuleEngine()
+	engine.SetSecretProvider(staticSecrets{"pii-key": "s3cr3t"})
+
+	valid := fieldAction("mask", map[string]interface{}{"fields": []interface{}{"data.card"}})
+	assert.NoError(t, engine.ValidateAction(valid))
+
+	invalid := []Action{
+		fieldAction("mask", nil),
+		fieldAction("mask", map[string]interface{}{"fields": []interface{}{"data.card"}, "keep_last": -1}),
+		fieldAction("mask", map[string]interface{}{"fields": []interface{}{"data.card"}, "mask_char": "**"}),
+		fieldAction("drop", map[string]interface{}{"fields": []interface{}{"documentKey"}}),
+		fieldAction("drop", map[string]interface{}{"fields": []interface{}{"documentKey._id"}}),
+		fieldAction("redact", map[string]interface{}{"fields": []interface{}{"position.offset"}}),
+		fieldAction("drop", map[string]interface{}{"fields": []interface{}{"$"}}),
+		fieldAction("truncate", map[string]interface{}{"fields": []interface{}{"data.name"}}),
+		fieldAction("generalize", map[string]interface{}{"fields": []interface{}{"data.age"}, "granularity": "week"}),
+		fieldAction("generalize", map[string]interface{}{"fields": []interface{}{"data.age"}, "bucket_size": 0}),
+		fieldAction("hash", map[string]interface{}{"fields": []interface{}{"data.email"}}),
+		fieldAction("hash", map[string]interface{}{"fields": []interface{}{"data.email"}, "key_secret": "unknown"}),
+	}
+	for _, action := range invalid {
+		assert.Error(t, engine.ValidateAction(action), "%s %v", action.Type, action.Config)
+	}
+
+	// Keys are required when the rules are validated
+	assert.Error(t, NewKazaamRuleEngine().ValidateAction(fieldAction("tokenize", map[string]interface{}{"fields": []interface{}{"data.card"}, "key_secret": "pii-key"})))
+}
+
+func TestFieldActionErrors(t *testing.T) {
+	engine := NewKazaamRuleEngine()
+	_, err := engine.ExecuteAction(context.Background(), map[string]interface{}{"name": map[string]interface{}{"first": "ann"}},
+		fieldAction("truncate", map[string]interface{}{"fields": []interface{}{"name"}, "length": 2}))
+	assert.Error(t, err)
+
+	_, err = engine.ExecuteAction(context.Background(), map[string]interface{}{"age": 42},
+		fieldAction("generalize", map[string]interface{}{"fields": []interface{}{"age"}}))
+	assert.Error(t, err, "numbers need a bucket size")
+}